@@ -0,0 +1,108 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package anchor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// payloadMagic is the prefix of payload, followed by version
+var payloadMagic = []byte("PDU")
+
+const (
+	payloadVersion = 1
+	// PayloadSize is the size of payload, fit into OP_RETURN of Bitcoin
+	PayloadSize = 3 + 1 + common.HashLength + 8 + common.HashLength
+)
+
+var (
+	// ErrPayloadNotValid returns if the data of transaction is not payload of anchor
+	ErrPayloadNotValid = errors.New("payload of anchor not valid")
+	// ErrTxNotFound returns if the transaction of anchor not found on chain
+	ErrTxNotFound = errors.New("transaction of anchor not found")
+)
+
+// Chain is the external chain which the anchors published to
+type Chain interface {
+	// Name of chain, such as eth or btc
+	Name() string
+	// Publish send the transaction with payload, return the transaction ID
+	Publish(payload []byte) (string, error)
+	// Lookup return the payload of transaction, ErrTxNotFound if not exist
+	Lookup(txID string) ([]byte, error)
+}
+
+// Anchor is the record of checkpoint state root published to chain
+type Anchor struct {
+	SpaceTimeID common.Hash `json:"spacetimeID"`
+	Seq         uint64      `json:"seq"`
+	StateRoot   common.Hash `json:"stateRoot"`
+	Chain       string      `json:"chain"`
+	TxID        string      `json:"txID"`
+	Time        int64       `json:"time"`
+}
+
+// Payload return the data published to chain
+func (a Anchor) Payload() []byte {
+	payload := make([]byte, 0, PayloadSize)
+	payload = append(payload, payloadMagic...)
+	payload = append(payload, payloadVersion)
+	payload = append(payload, a.SpaceTimeID[:]...)
+	seqBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(seqBytes, a.Seq)
+	payload = append(payload, seqBytes...)
+	return append(payload, a.StateRoot[:]...)
+}
+
+// ParsePayload parse the space-time, sequence and state root from payload
+func ParsePayload(payload []byte) (*Anchor, error) {
+	if len(payload) != PayloadSize || !bytes.HasPrefix(payload, payloadMagic) || payload[len(payloadMagic)] != payloadVersion {
+		return nil, ErrPayloadNotValid
+	}
+	payload = payload[len(payloadMagic)+1:]
+	a := &Anchor{SpaceTimeID: common.Bytes2Hash(payload[:common.HashLength])}
+	payload = payload[common.HashLength:]
+	a.Seq = binary.BigEndian.Uint64(payload[:8])
+	a.StateRoot = common.Bytes2Hash(payload[8:])
+	return a, nil
+}
+
+// Publish send the state root of checkpoint to chain
+func Publish(chain Chain, cp *core.Checkpoint) (*Anchor, error) {
+	a := &Anchor{SpaceTimeID: cp.SpaceTimeID, Seq: cp.Seq, StateRoot: cp.StateRoot, Chain: chain.Name()}
+	txID, err := chain.Publish(a.Payload())
+	if err != nil {
+		return nil, err
+	}
+	a.TxID, a.Time = txID, time.Now().Unix()
+	return a, nil
+}
+
+// Verify check the transaction of anchor on chain carry the payload of anchor
+func Verify(chain Chain, a *Anchor) (bool, error) {
+	payload, err := chain.Lookup(a.TxID)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(payload, a.Payload()), nil
+}
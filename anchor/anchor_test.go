@@ -0,0 +1,172 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package anchor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// fakeNode serve the rpc methods of Ethereum and Bitcoin node used by anchor,
+// the data of transactions are kept in memory
+type fakeNode struct {
+	mu  sync.Mutex
+	txs map[string]string
+}
+
+func (f *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user, pass, _ := r.BasicAuth(); req.Method != "eth_sendTransaction" && req.Method != "eth_getTransactionByHash" && (user != "user" || pass != "pass") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var param string
+	var result interface{}
+	var rpcErr *rpcError
+	switch req.Method {
+	case "eth_sendTransaction":
+		var tx map[string]string
+		json.Unmarshal(req.Params[0], &tx)
+		id := fmt.Sprintf("0x%d", len(f.txs))
+		f.txs[id] = tx["data"]
+		result = id
+	case "eth_getTransactionByHash":
+		json.Unmarshal(req.Params[0], &param)
+		if data, ok := f.txs[param]; ok {
+			result = map[string]string{"input": data}
+		}
+	case "createrawtransaction":
+		var outputs map[string]string
+		json.Unmarshal(req.Params[1], &outputs)
+		data, _ := hex.DecodeString(outputs["data"])
+		result = fmt.Sprintf("6a%02x%s", len(data), outputs["data"])
+		if len(data) >= opPushData1 {
+			result = fmt.Sprintf("6a4c%02x%s", len(data), outputs["data"])
+		}
+	case "fundrawtransaction":
+		json.Unmarshal(req.Params[0], &param)
+		result = map[string]string{"hex": param}
+	case "signrawtransactionwithwallet":
+		json.Unmarshal(req.Params[0], &param)
+		result = map[string]interface{}{"hex": param, "complete": true}
+	case "sendrawtransaction":
+		json.Unmarshal(req.Params[0], &param)
+		id := fmt.Sprintf("%064d", len(f.txs))
+		f.txs[id] = param
+		result = id
+	case "getrawtransaction":
+		json.Unmarshal(req.Params[0], &param)
+		if script, ok := f.txs[param]; ok {
+			result = map[string]interface{}{"vout": []interface{}{
+				map[string]interface{}{"scriptPubKey": map[string]string{"hex": "0014" + strings.Repeat("00", 20)}},
+				map[string]interface{}{"scriptPubKey": map[string]string{"hex": script}},
+			}}
+		} else {
+			rpcErr = &rpcError{Code: rpcInvalidAddressOrKey, Message: "No such mempool or blockchain transaction"}
+		}
+	default:
+		rpcErr = &rpcError{Code: -32601, Message: "Method not found"}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "result": result, "error": rpcErr})
+}
+
+func TestParsePayload(t *testing.T) {
+	a := Anchor{SpaceTimeID: common.Bytes2Hash([]byte("st")), Seq: 1024, StateRoot: common.Bytes2Hash([]byte("root"))}
+	payload := a.Payload()
+	if len(payload) != PayloadSize || PayloadSize > 80 {
+		t.Fatal("payload should fit into OP_RETURN", len(payload))
+	}
+	parsed, err := ParsePayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.SpaceTimeID != a.SpaceTimeID || parsed.Seq != a.Seq || parsed.StateRoot != a.StateRoot {
+		t.Error("payload not match", parsed)
+	}
+	payload[0] = 'X'
+	if _, err := ParsePayload(payload); err != ErrPayloadNotValid {
+		t.Errorf("err should be %s, but get %s", ErrPayloadNotValid, err)
+	}
+}
+
+func TestChains(t *testing.T) {
+	server := httptest.NewServer(&fakeNode{txs: make(map[string]string)})
+	defer server.Close()
+	eth, err := NewEthereum(server.URL, "0x01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	btc, err := NewBitcoin(strings.Replace(server.URL, "http://", "http://user:pass@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &core.Checkpoint{SpaceTimeID: common.Bytes2Hash([]byte("st")), Seq: 7, StateRoot: common.Bytes2Hash([]byte("root"))}
+	for _, chain := range []Chain{eth, btc} {
+		a, err := Publish(chain, cp)
+		if err != nil {
+			t.Fatal(chain.Name(), "publish fail", err)
+		}
+		if a.Chain != chain.Name() || a.TxID == "" || a.Seq != cp.Seq {
+			t.Error(chain.Name(), "anchor not match", a)
+		}
+		if ok, err := Verify(chain, a); err != nil || !ok {
+			t.Error(chain.Name(), "verify anchor fail", err)
+		}
+		tampered := *a
+		tampered.StateRoot = common.Hash{}
+		if ok, _ := Verify(chain, &tampered); ok {
+			t.Error(chain.Name(), "tampered anchor should not be verified")
+		}
+		tampered.TxID = "0x99"
+		if _, err := Verify(chain, &tampered); err != ErrTxNotFound {
+			t.Errorf("%s err should be %s, but get %s", chain.Name(), ErrTxNotFound, err)
+		}
+	}
+}
+
+func TestParseOpReturn(t *testing.T) {
+	for script, expect := range map[string]string{
+		"6a03010203":     "010203",
+		"6a4c03010203":   "010203",
+		"6a0401020304ff": "",
+		"0014":           "",
+	} {
+		b, _ := hex.DecodeString(script)
+		data, ok := parseOpReturn(b)
+		if ok != (expect != "") || hex.EncodeToString(data) != expect {
+			t.Error("parse op return fail", script, hex.EncodeToString(data))
+		}
+	}
+}
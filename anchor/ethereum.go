@@ -0,0 +1,73 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package anchor
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// Ethereum publish the payload as data of transaction sent by the account
+// managed by Ethereum node (geth, clef...), to the contract if given, or the
+// account itself. The contract should accept any data in fallback.
+type Ethereum struct {
+	rpc  *rpcClient
+	from string
+	to   string
+}
+
+// NewEthereum create the chain by url of Ethereum node, the from account
+// should be unlocked in node
+func NewEthereum(url, from, to string) (*Ethereum, error) {
+	rpc, err := newRPCClient(url)
+	if err != nil {
+		return nil, err
+	}
+	if to == "" {
+		to = from
+	}
+	return &Ethereum{rpc: rpc, from: from, to: to}, nil
+}
+
+// Name of chain
+func (e *Ethereum) Name() string {
+	return "eth"
+}
+
+// Publish send the transaction with payload as data
+func (e *Ethereum) Publish(payload []byte) (string, error) {
+	tx := map[string]string{"from": e.from, "to": e.to, "data": "0x" + hex.EncodeToString(payload)}
+	var txHash string
+	if err := e.rpc.call("eth_sendTransaction", &txHash, tx); err != nil {
+		return "", err
+	}
+	return txHash, nil
+}
+
+// Lookup return the data of transaction
+func (e *Ethereum) Lookup(txID string) ([]byte, error) {
+	var tx *struct {
+		Input string `json:"input"`
+	}
+	if err := e.rpc.call("eth_getTransactionByHash", &tx, txID); err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, ErrTxNotFound
+	}
+	return hex.DecodeString(strings.TrimPrefix(tx.Input, "0x"))
+}
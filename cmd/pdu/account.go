@@ -34,18 +34,21 @@ import (
 const (
 	operGenerate = "generate"
 	operInspect  = "inspect"
+	operImport   = "import"
+	operExport   = "export"
 )
 
 var (
 	errUnknownOperation = errors.New("unknown operation")
 	errPasswordNotMatch = errors.New("password not match")
 	errKeyFileMissing   = errors.New("key file missing")
+	errKeyNotExportable = errors.New("key can not be imported or exported by this crypt")
 )
 
 // accountCmd represents the create command
 var accountCmd = &cobra.Command{
-	Use:   "account [generate/inspect]",
-	Short: "Account generate or inspect",
+	Use:   "account [generate/inspect/import/export]",
+	Short: "Account generate, inspect, import or export",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(_ *cobra.Command, args []string) error {
 		switch strings.ToLower(args[0]) {
@@ -53,6 +56,10 @@ var accountCmd = &cobra.Command{
 			return generate()
 		case operInspect:
 			return inspect()
+		case operImport:
+			return importKey()
+		case operExport:
+			return exportKey()
 		default:
 			return errUnknownOperation
 		}
@@ -60,26 +67,52 @@ var accountCmd = &cobra.Command{
 	},
 }
 
-func generate() error {
-	var engine crypto.Engine
+func newPasswd() (string, error) {
 	fmt.Printf("password: ")
 	passwd, err := gopass.GetPasswd()
 	if err != nil {
-		return err
+		return "", err
 	}
 	fmt.Printf("repeat password: ")
 	passwd2, err := gopass.GetPasswd()
 	if err != nil {
-		return err
+		return "", err
 	}
 	if string(passwd) != string(passwd2) {
-		return errPasswordNotMatch
+		return "", errPasswordNotMatch
 	}
+	return string(passwd), nil
+}
+
+func checkOutput() error {
 	if _, err := os.Stat(accOutput); err == nil {
 		return fmt.Errorf("keyfile already exists at %s", accOutput)
 	} else if !os.IsNotExist(err) {
 		return err
 	}
+	return nil
+}
+
+func writeOutput(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(accOutput), 0700); err != nil {
+		return fmt.Errorf("could not create directory %s", filepath.Dir(accOutput))
+	}
+	if err := ioutil.WriteFile(accOutput, data, 0600); err != nil {
+		return fmt.Errorf("failed to write keyfile to %s: %v", accOutput, err)
+	}
+	fmt.Println(accOutput, "is created success.")
+	return nil
+}
+
+func generate() error {
+	var engine crypto.Engine
+	passwd, err := newPasswd()
+	if err != nil {
+		return err
+	}
+	if err := checkOutput(); err != nil {
+		return err
+	}
 
 	if strings.ToUpper(accSigType) != crypto.Signature2PublicKey && strings.ToUpper(accSigType) != crypto.MultipleSignatures {
 		return crypto.ErrSigTypeNotSupport
@@ -94,18 +127,87 @@ func generate() error {
 	if err != nil {
 		return err
 	}
-	keyJSON, err := engine.EncryptKey(privateKey, string(passwd))
+	keyJSON, err := engine.EncryptKey(privateKey, passwd)
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(accOutput), 0700); err != nil {
-		return fmt.Errorf("could not create directory %s", filepath.Dir(accOutput))
+	return writeOutput(keyJSON)
+}
+
+// importKey read the PEM or DER private key from input, such as the key
+// generated by openssl, and save it as key file encrypted by password
+func importKey() error {
+	if accInput == "" {
+		return errKeyFileMissing
 	}
-	if err := ioutil.WriteFile(accOutput, keyJSON, 0600); err != nil {
-		return fmt.Errorf("failed to write keyfile to %s: %v", accOutput, err)
+	engine, err := utils.SelectEngine(accCrypt)
+	if err != nil {
+		return err
 	}
-	fmt.Println(accOutput, "is created success.")
-	return nil
+	exporter, ok := engine.(crypto.KeyExporter)
+	if !ok {
+		return errKeyNotExportable
+	}
+	data, err := ioutil.ReadFile(accInput)
+	if err != nil {
+		return err
+	}
+	der, err := crypto.DecodePEM(data)
+	if err != nil {
+		return err
+	}
+	privateKey, _, err := exporter.ImportKey(der)
+	if err != nil {
+		return err
+	}
+	if err := checkOutput(); err != nil {
+		return err
+	}
+	passwd, err := newPasswd()
+	if err != nil {
+		return err
+	}
+	keyJSON, err := engine.EncryptKey(privateKey, passwd)
+	if err != nil {
+		return err
+	}
+	return writeOutput(keyJSON)
+}
+
+// exportKey unlock the key file and write the private key to output as PEM
+// or DER, so it can be used by other tooling
+func exportKey() error {
+	format := strings.ToUpper(accFormat)
+	if format != crypto.KeyFormatPKCS8 && format != crypto.KeyFormatSEC1 {
+		return crypto.ErrKeyFormatNotSupport
+	}
+	if err := checkOutput(); err != nil {
+		return err
+	}
+	priKey, _, err := unlockKeyByCmd()
+	if err != nil {
+		return err
+	}
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return err
+	}
+	exporter, ok := engine.(crypto.KeyExporter)
+	if !ok {
+		return errKeyNotExportable
+	}
+	der, err := exporter.ExportKey(priKey, format)
+	if err != nil {
+		return err
+	}
+	if accDER {
+		return writeOutput(der)
+	}
+	pemBytes, err := crypto.EncodePEM(der, format)
+	if err != nil {
+		return err
+	}
+	return writeOutput(pemBytes)
 }
 
 func inspect() error {
@@ -123,5 +225,8 @@ func init() {
 	accountCmd.PersistentFlags().IntVar(&accMSCount, "msCount", 3, "number of private key if sigType is MS")
 	accountCmd.PersistentFlags().StringVar(&accCrypt, "crypt", crypto.PDU, "type of crypt")
 	accountCmd.PersistentFlags().StringVarP(&accOutput, "output", "o", "key.json", "output file")
+	accountCmd.PersistentFlags().StringVarP(&accInput, "input", "i", "", "PEM or DER private key file to import")
+	accountCmd.PersistentFlags().StringVar(&accFormat, "format", crypto.KeyFormatPKCS8, "format of exported key, PKCS8 or SEC1")
+	accountCmd.PersistentFlags().BoolVar(&accDER, "der", false, "export key as DER instead of PEM")
 	rootCmd.AddCommand(accountCmd)
 }
@@ -0,0 +1,194 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+// adminUnixPrefix is the prefix of admin address served on unix socket,
+// such as unix:/var/run/pdu/admin.sock
+const adminUnixPrefix = "unix:"
+
+var errAdminAddrMissing = errors.New("admin address missing")
+
+// adminCmd represents the admin command
+var adminCmd = &cobra.Command{
+	Use:   "admin <method> [params...]",
+	Short: "Call the admin apis of running node, such as peers, addPeer, removePeer, bandwidth, setLogLevel, backup, shutdown, createAPIKey, audit, exportAudit",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if adminAddr == "" {
+			return errAdminAddrMissing
+		}
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		method := args[0]
+		if !strings.HasPrefix(method, "admin_") {
+			method = "admin_" + method
+		}
+		reqBytes, err := json.Marshal(&node.AdminRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: args[1:]})
+		if err != nil {
+			return err
+		}
+		client, url := adminClient(adminAddr)
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqBytes))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		// the token is not required on unix socket, send it if exist
+		token, err := loadAdminToken(adminTokenFilePath(), false)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var res node.AdminResponse
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return fmt.Errorf("%s %v", resp.Status, err)
+		}
+		if res.Error != nil {
+			return fmt.Errorf("%s (%d)", res.Error.Message, res.Error.Code)
+		}
+		resBytes, err := json.MarshalIndent(res.Result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(resBytes))
+		return nil
+	},
+}
+
+// adminClient return the http client and url of admin address
+func adminClient(addr string) (*http.Client, string) {
+	if !strings.HasPrefix(addr, adminUnixPrefix) {
+		return http.DefaultClient, "http://" + addr + node.AdminPath
+	}
+	sock := strings.TrimPrefix(addr, adminUnixPrefix)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	return client, "http://unix" + node.AdminPath
+}
+
+// listenAdmin listen on the admin address, the token is loaded from token
+// file, or created if served on tcp, which can not be served without token
+func listenAdmin(addr string) (net.Listener, string, error) {
+	if strings.HasPrefix(addr, adminUnixPrefix) {
+		sock := strings.TrimPrefix(addr, adminUnixPrefix)
+		// remove the socket left by the node not stopped normally
+		if fi, err := os.Lstat(sock); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(sock); err != nil {
+				return nil, "", err
+			}
+		}
+		l, err := net.Listen("unix", sock)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := os.Chmod(sock, 0600); err != nil {
+			l.Close()
+			return nil, "", err
+		}
+		token, err := loadAdminToken(adminTokenFile, false)
+		if err != nil {
+			l.Close()
+			return nil, "", err
+		}
+		return l, token, nil
+	}
+	token, err := loadAdminToken(adminTokenFilePath(), true)
+	if err != nil {
+		return nil, "", err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	return l, token, nil
+}
+
+// adminTokenFilePath return the token file set by flag, or the default one
+// in data dir
+func adminTokenFilePath() string {
+	if adminTokenFile != "" {
+		return adminTokenFile
+	}
+	return path.Join(dataDir, node.AdminTokenFile)
+}
+
+// loadAdminToken read the token from file, the random token is written
+// into file if not exist and create is true
+func loadAdminToken(fileName string, create bool) (string, error) {
+	if fileName == "" {
+		return "", nil
+	}
+	tokenBytes, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		return strings.TrimSpace(string(tokenBytes)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if !create {
+		return "", nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := ioutil.WriteFile(fileName, []byte(token), 0600); err != nil {
+		return "", err
+	}
+	fmt.Println("Admin token written into", fileName)
+	return token, nil
+}
+
+func init() {
+	adminCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir of default token file (default $HOME/%s)", params.DefaultPath))
+	adminCmd.PersistentFlags().StringVar(&adminAddr, "admin", "", "admin address of running node, unix:/path/to/admin.sock or ip:port")
+	adminCmd.PersistentFlags().StringVar(&adminTokenFile, "adminToken", "", fmt.Sprintf("admin token file (default $datadir/%s)", node.AdminTokenFile))
+	rootCmd.AddCommand(adminCmd)
+}
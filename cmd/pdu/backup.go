@@ -0,0 +1,110 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backup"
+	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+var errBackupDirMissing = errors.New("backup dir missing")
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backup the node storage, full snapshot first and per-day deltas after",
+	RunE: func(_ *cobra.Command, args []string) error {
+		if backupOut == "" {
+			return errBackupDirMissing
+		}
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		// backup from the running node first, so msgs intake is not stopped
+		nodeURL := fmt.Sprintf("http://127.0.0.1:%d", localPort)
+		if backupUniverse != "" {
+			nodeURL += node.UniversePathPrefix + backupUniverse
+		}
+		var src backup.Source = backup.NewRemoteSource(nodeURL)
+		if _, err := src.MsgCount(); err != nil {
+			udb, err := initDBLoad()
+			if err != nil {
+				return err
+			}
+			defer udb.Close()
+			src = backup.NewLocalSource(udb)
+		} else {
+			fmt.Println("Backup from running node on port", localPort)
+		}
+		entry, err := backup.Backup(src, backupOut)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			fmt.Println("No new msg since last backup")
+			return nil
+		}
+		fmt.Println("Backup", entry.Kind, entry.File, "msgs from", entry.From, "to", entry.To, "sha256", entry.Checksum)
+		return nil
+	},
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the node storage from backup, the node should be stopped",
+	RunE: func(_ *cobra.Command, args []string) error {
+		if restoreFrom == "" {
+			return errBackupDirMissing
+		}
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dataDir, 0700); err != nil {
+			return err
+		}
+		dbFilePath := path.Join(dataDir, "u.db")
+		count, err := backup.Restore(restoreFrom, dbFilePath, func(p string) (db.UDB, error) {
+			return bolt.NewDB(p)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(count, "msgs restored into", dbFilePath)
+		return nil
+	},
+}
+
+func init() {
+	backupCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir used if node not running (default $HOME/%s)", params.DefaultPath))
+	backupCmd.PersistentFlags().StringVar(&backupOut, "out", "", "backup dir, full snapshot is written if empty, otherwise delta of today")
+	backupCmd.PersistentFlags().Uint64Var(&localPort, "port", node.DefaultLocalPort, "local port of running node")
+	backupCmd.PersistentFlags().StringVar(&backupUniverse, "universe", "", "universe ID (hex) if node is started by pdu host")
+	restoreCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir to restore into, should have no db (default $HOME/%s)", params.DefaultPath))
+	restoreCmd.PersistentFlags().StringVar(&restoreFrom, "from", "", "backup dir to restore from")
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
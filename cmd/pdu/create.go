@@ -17,8 +17,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"os"
 	"strconv"
@@ -32,6 +34,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var errGenesisFirstMsgMissing = errors.New("first msg of genesis missing")
+
 // createCmd represents the create command
 var createCmd = &cobra.Command{
 	Use:   "create",
@@ -43,11 +47,12 @@ var createCmd = &cobra.Command{
 		}
 		fmt.Println("Database initialized successfully", dataDir)
 
-		if err := createNewUniverse(udb); err != nil {
-			os.RemoveAll(dataDir)
-			return err
+		if createGenesisFile != "" {
+			err = createUniverseFromGenesis(udb)
+		} else if err = createNewUniverse(udb); err == nil {
+			err = initUniverseSettings(udb)
 		}
-		if err := initUniverseSettings(udb); err != nil {
+		if err != nil {
 			os.RemoveAll(dataDir)
 			return err
 		}
@@ -61,6 +66,20 @@ var createCmd = &cobra.Command{
 	},
 }
 
+func readUniverseHasher() common.Hasher {
+	for {
+		var hasherInput string
+		fmt.Printf("Universe Hasher [%s/%s/%s] (%s): ", common.HasherSHA256, common.HasherKeccak256, common.HasherBLAKE3, common.HasherSHA256)
+		scanLine(&hasherInput)
+		hasher, err := common.SelectHasher(hasherInput)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		return hasher
+	}
+}
+
 func readUniverseDimension() int64 {
 	for {
 		var dimensionInput string
@@ -145,8 +164,14 @@ func initUniverseSettings(udb db.UDB) (err error) {
 }
 
 func createNewUniverse(udb db.UDB) error {
+	// hasher should be chosen before the ID of root users be calculated
+	hasher := readUniverseHasher()
+	if err := db.SaveUniverseHasher(udb, hasher.Name()); err != nil {
+		return err
+	}
+
 	// create root users
-	users, priKeys, err := createRootUsers()
+	users, priKeys, err := createRootUsers(hasher)
 	if err != nil {
 		return err
 	}
@@ -158,7 +183,10 @@ func createNewUniverse(udb db.UDB) error {
 	fmt.Println("Create root users successfully", users[0].Gender(), users[1].Gender())
 
 	// create universe by root users
-	universe, err := core.NewUniverse(users[0], users[1])
+	config := core.DefaultUniverseConfig()
+	config.Hasher = hasher.Name()
+	config.NetworkID = currentNetwork.network
+	universe, err := core.NewUniverseWithConfig(users[0], users[1], config)
 	if err != nil {
 		return err
 	}
@@ -181,6 +209,75 @@ func createNewUniverse(udb db.UDB) error {
 	if err := db.SaveMsg(udb, msg); err != nil {
 		return err
 	}
+	if createGenesisOut != "" {
+		return saveGenesis(core.NewGenesis(users[0], users[1], msg, config))
+	}
+	return nil
+}
+
+// createUniverseFromGenesis create the universe by genesis file, the settings
+// of universe are default
+func createUniverseFromGenesis(udb db.UDB) error {
+	f, err := os.Open(createGenesisFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	genesis, err := core.LoadGenesis(f)
+	if err != nil {
+		return err
+	}
+	if genesis.Config == nil {
+		genesis.Config = core.DefaultUniverseConfig()
+		genesis.Config.NetworkID = currentNetwork.network
+	}
+	if genesis.Config.NetworkID != currentNetwork.network {
+		return fmt.Errorf("genesis is on %s network", core.NetworkName(genesis.Config.NetworkID))
+	}
+	if genesis.FirstMsg == nil {
+		return errGenesisFirstMsgMissing
+	}
+	universe, err := core.NewUniverseFromGenesis(genesis)
+	if err != nil {
+		return err
+	}
+	users, err := genesis.RootUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		user.SetHasher(universe.Hasher())
+	}
+	if err := db.SaveUniverseHasher(udb, universe.Hasher().Name()); err != nil {
+		return err
+	}
+	if err := db.SaveRootUsers(udb, users); err != nil {
+		return err
+	}
+	if err := db.SaveMsg(udb, genesis.FirstMsg); err != nil {
+		return err
+	}
+	fmt.Println("Create universe", common.Hash2String(universe.ID()), "from genesis", createGenesisFile)
+	if err := udb.Set(db.BucketConfig, db.ConfigUniverseDimension, big.NewInt(core.DefaultDimensionNum).Bytes()); err != nil {
+		return err
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigUniversePerimeter, big.NewInt(core.DefaultPerimeter).Bytes()); err != nil {
+		return err
+	}
+	return udb.Set(db.BucketConfig, db.ConfigUniverseRedshiftConstant, big.NewInt(core.DefaultPerimeter/1e+4).Bytes())
+}
+
+// saveGenesis write the genesis into file, which can be shared with other
+// nodes to create the same universe by pdu create --genesis
+func saveGenesis(genesis *core.Genesis) error {
+	genesisBytes, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(createGenesisOut, genesisBytes, 0644); err != nil {
+		return err
+	}
+	fmt.Println("Genesis saved into", createGenesisOut)
 	return nil
 }
 
@@ -190,8 +287,8 @@ func createFirstMsg(users []*core.User, priKeys []*crypto.PrivateKey) (*core.Mes
 	var priKey *crypto.PrivateKey
 	var content string
 	fmt.Println("Please select the user to create first message: [0/1] ")
-	fmt.Println("user 0: ", common.Hash2String(users[0].ID()))
-	fmt.Println("user 1: ", common.Hash2String(users[1].ID()))
+	fmt.Println("user 0: ", common.EncodeAddress(users[0].ID()))
+	fmt.Println("user 1: ", common.EncodeAddress(users[1].ID()))
 	fmt.Scan(&userSelected)
 	if userSelected == 0 {
 		user = users[0]
@@ -208,7 +305,7 @@ func createFirstMsg(users []*core.User, priKeys []*crypto.PrivateKey) (*core.Mes
 		ContentType: core.TypeText,
 		Content:     []byte(content),
 	}
-	msg, err := core.CreateMsg(user, &value, priKey)
+	msg, err := core.CreateMsgOnNetwork(currentNetwork.network, user, &value, priKey, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -216,7 +313,7 @@ func createFirstMsg(users []*core.User, priKeys []*crypto.PrivateKey) (*core.Mes
 	return msg, nil
 }
 
-func createRootUsers() (users []*core.User, priKeys []*crypto.PrivateKey, err error) {
+func createRootUsers(hasher common.Hasher) (users []*core.User, priKeys []*crypto.PrivateKey, err error) {
 
 	for i := 0; i < 2; i++ {
 		priKey, pubKey, err := unlockKeyByCmd()
@@ -231,7 +328,8 @@ func createRootUsers() (users []*core.User, priKeys []*crypto.PrivateKey, err er
 			fmt.Print("Extra: ")
 			scanLine(&rootExtra)
 			user := core.CreateRootUser(*pubKey, rootName, rootExtra)
-			fmt.Println("ID", common.Hash2String(user.ID()), "name", user.Name, "extra", user.BirthExtra, "gender", user.Gender())
+			user.SetHasher(hasher)
+			fmt.Println("ID", common.Hash2String(user.ID()), "address", common.EncodeAddress(user.ID()), "name", user.Name, "extra", user.BirthExtra, "gender", user.Gender())
 			fmt.Print("save new user (yes/no): ")
 			fmt.Scan(&isSave)
 			if strings.ToUpper(isSave) == "YES" || strings.ToUpper(isSave) == "Y" {
@@ -246,5 +344,7 @@ func createRootUsers() (users []*core.User, priKeys []*crypto.PrivateKey, err er
 
 func init() {
 	createCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	createCmd.PersistentFlags().StringVar(&createGenesisFile, "genesis", "", "json file of genesis (root users, first msg and config), universe is created from it without prompt")
+	createCmd.PersistentFlags().StringVar(&createGenesisOut, "genesisOut", "", "json file which genesis of universe created is saved into, used by other nodes to create the same universe")
 	rootCmd.AddCommand(createCmd)
 }
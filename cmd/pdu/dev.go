@@ -0,0 +1,158 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/signal"
+	"path"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/spf13/cobra"
+)
+
+// devPassword is the password of root keys in dev mode, only for local use
+const devPassword = "pdu-dev"
+
+// devCmd represents the dev command
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Run a single node universe in memory for local development",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// dev network if not be set
+		if !cmd.Flag("network").Changed {
+			currentNetwork = networkPresets[core.NetworkDev]
+			if !cmd.Flag("port").Changed {
+				localPort = currentNetwork.port
+			}
+		}
+		if devKeysDir == "" {
+			dir, err := ioutil.TempDir("", "pdu-dev")
+			if err != nil {
+				return err
+			}
+			devKeysDir = dir
+		} else if err := os.MkdirAll(devKeysDir, 0700); err != nil {
+			return err
+		}
+
+		users, priKeys, err := createDevRoots()
+		if err != nil {
+			return err
+		}
+		udb := memdb.New()
+		if err := initDevUniverse(udb, users, priKeys[0]); err != nil {
+			return err
+		}
+		pn, err := node.New(udb)
+		if err != nil {
+			return err
+		}
+		pn.SetLocalPort(localPort)
+		pn.EnableSearch()
+		pn.EnableWSMsg()
+		// root 0 make time proof, so msgs are in order without peers
+		if err := pn.EnableTP(users[0], priKeys[0], nodeTPInterval); err != nil {
+			return err
+		}
+
+		fmt.Println("Dev universe on", core.NetworkName(currentNetwork.network), "network")
+		for i, user := range users {
+			fmt.Println("root", i, common.EncodeAddress(user.ID()), "key", path.Join(devKeysDir, fmt.Sprintf("root%d.json", i)))
+		}
+		fmt.Println("password of keys", devPassword, "in", path.Join(devKeysDir, "pass"))
+		fmt.Printf("api on http://127.0.0.1:%d/ (search, user, file), time proof every %d seconds\n", localPort, nodeTPInterval)
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		pn.Run(c)
+		return nil
+	},
+}
+
+// createDevRoots create two root users with diff gender, the keys are saved
+// in devKeysDir, so apps can sign msgs by them
+func createDevRoots() ([]*core.User, []*crypto.PrivateKey, error) {
+	engine := ethereum.New()
+	users := make([]*core.User, 2)
+	priKeys := make([]*crypto.PrivateKey, 2)
+	for users[0] == nil || users[1] == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		user := core.CreateRootUser(*pubKey, "dev", "")
+		i := 0
+		if user.Gender() {
+			i = 1
+		}
+		if users[i] == nil {
+			users[i], priKeys[i] = user, priKey
+		}
+	}
+	for i, priKey := range priKeys {
+		keyJSON, err := engine.EncryptKey(priKey, devPassword)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := ioutil.WriteFile(path.Join(devKeysDir, fmt.Sprintf("root%d.json", i)), keyJSON, 0600); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(devKeysDir, "pass"), []byte(devPassword), 0600); err != nil {
+		return nil, nil, err
+	}
+	return users, priKeys, nil
+}
+
+// initDevUniverse save the roots and first msg of root 0 into db, the first
+// msg make root 0 a space-time
+func initDevUniverse(udb db.UDB, users []*core.User, priKey *crypto.PrivateKey) error {
+	if err := initBuckets(udb); err != nil {
+		return err
+	}
+	if err := db.SaveUniverseHasher(udb, common.HasherSHA256); err != nil {
+		return err
+	}
+	if err := db.SaveRootUsers(udb, users); err != nil {
+		return err
+	}
+	msg, err := core.CreateMsgOnNetwork(currentNetwork.network, users[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte("dev universe")}, priKey, 0)
+	if err != nil {
+		return err
+	}
+	if err := db.SaveMsg(udb, msg); err != nil {
+		return err
+	}
+	return udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepRootsSaved).Bytes())
+}
+
+func init() {
+	devCmd.PersistentFlags().Uint64Var(&localPort, "port", node.DefaultLocalPort, "local port (default port of dev network)")
+	devCmd.PersistentFlags().Uint64Var(&nodeTPInterval, "tpInterval", 1, "seconds between time proof msgs")
+	devCmd.PersistentFlags().StringVar(&devKeysDir, "keys", "", "dir to save keys of root users (default temp dir)")
+	rootCmd.AddCommand(devCmd)
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/pdupub/go-pdu/node"
+	"github.com/spf13/cobra"
+)
+
+var errDataDirsMissing = errors.New("data dirs missing")
+
+// hostCmd represents the host command
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Run nodes of many universes in one process on one port",
+	RunE: func(_ *cobra.Command, args []string) error {
+		if hostDataDirs == "" {
+			return errDataDirsMissing
+		}
+		h := node.NewHost(localPort)
+		h.SetPathPrefix(nodePathPrefix)
+		for _, dir := range strings.Split(hostDataDirs, ",") {
+			// each universe have its own data dir, created by pdu init
+			dataDir = dir
+			if err := migrateDB(false); err != nil {
+				return err
+			}
+			udb, err := initDBLoad()
+			if err != nil {
+				return err
+			}
+			defer udb.Close()
+			pn, err := h.NewNode(udb)
+			if err != nil {
+				return err
+			}
+			pn.SetCheckpointInterval(nodeCPInterval)
+			if nodeSearchEnable {
+				pn.EnableSearch()
+			}
+			if err := setProxyOptions(pn); err != nil {
+				return err
+			}
+			if err := h.AddNode(pn); err != nil {
+				return err
+			}
+		}
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		h.Run(c)
+		return nil
+	},
+}
+
+func init() {
+	hostCmd.PersistentFlags().StringVar(&hostDataDirs, "datadirs", "", "data dirs of universes to host, split by comma, apis of each are served on /u/{universeID}/")
+	hostCmd.PersistentFlags().Uint64Var(&localPort, "port", node.DefaultLocalPort, "local port")
+	hostCmd.PersistentFlags().BoolVar(&nodeSearchEnable, "search", false, "index msgs for search, serve on /u/{universeID}/search")
+	hostCmd.PersistentFlags().Uint64Var(&nodeCPInterval, "cpInterval", node.DefaultCheckpointInterval, "time proof sequence interval between checkpoints")
+	addProxyFlags(hostCmd)
+	rootCmd.AddCommand(hostCmd)
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/howeyc/gopass"
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+var (
+	errExportUserMissing = errors.New("user ID missing")
+	errExportKeyNotMatch = errors.New("key file not match the user")
+)
+
+// exportIdentityCmd represents the export-identity command
+var exportIdentityCmd = &cobra.Command{
+	Use:   "export-identity",
+	Short: "Export the portable bundle of user with its birth, parents and msgs, the node should be stopped",
+	RunE: func(_ *cobra.Command, args []string) error {
+		if exportUserID == "" {
+			return errExportUserMissing
+		}
+		userID, err := common.ParseUserID(exportUserID)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(exportOut); err == nil {
+			return fmt.Errorf("bundle already exists at %s", exportOut)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		udb, err := initDBLoad()
+		if err != nil {
+			return err
+		}
+		defer udb.Close()
+		pn, err := node.New(udb)
+		if err != nil {
+			return err
+		}
+		bundle, err := pn.ExportIdentity(userID)
+		if err != nil {
+			return err
+		}
+		if exportWithKey {
+			if bundle.Key, err = readUserKey(bundle.User); err != nil {
+				return err
+			}
+		}
+		bundleBytes, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(exportOut, bundleBytes, 0600); err != nil {
+			return err
+		}
+		fmt.Println("User", common.Hash2String(userID), "with", len(bundle.Ancestors), "ancestors and", len(bundle.Msgs), "msgs exported to", exportOut)
+		return nil
+	},
+}
+
+// importIdentityCmd represents the import-identity command
+var importIdentityCmd = &cobra.Command{
+	Use:   "import-identity [bundle file]",
+	Short: "Verify the bundle exported by export-identity and import it, the node should be stopped",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		bundleBytes, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		var bundle core.IdentityBundle
+		if err := json.Unmarshal(bundleBytes, &bundle); err != nil {
+			return err
+		}
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		udb, err := initDBLoad()
+		if err != nil {
+			return err
+		}
+		defer udb.Close()
+		pn, err := node.New(udb)
+		if err != nil {
+			return err
+		}
+		r, err := pn.ImportIdentity(&bundle)
+		if err != nil {
+			return err
+		}
+		for _, msgID := range r.Added {
+			fmt.Println("Added     ", common.Hash2String(msgID))
+		}
+		for _, msgID := range r.Duplicated {
+			fmt.Println("Duplicated", common.Hash2String(msgID))
+		}
+		for _, rejection := range r.Rejected {
+			fmt.Println("Rejected  ", common.Hash2String(rejection.MsgID), "code", rejection.Code, rejection.Reason)
+		}
+		fmt.Println("User", common.Hash2String(r.UserID), "imported,", len(r.Added), "added,", len(r.Duplicated), "duplicated,", len(r.Rejected), "rejected")
+		if importKeyOut != "" && len(bundle.Key) > 0 {
+			if _, err := os.Stat(importKeyOut); err == nil {
+				return fmt.Errorf("keyfile already exists at %s", importKeyOut)
+			}
+			if err := ioutil.WriteFile(importKeyOut, bundle.Key, 0600); err != nil {
+				return err
+			}
+			fmt.Println("Key file of user written to", importKeyOut)
+		}
+		return nil
+	},
+}
+
+// readUserKey read the key file of user, which is unlocked by password to
+// check it match the user, and kept encrypted in bundle
+func readUserKey(user *core.User) ([]byte, error) {
+	var keyFile string
+	fmt.Print("KeyFile path: ")
+	fmt.Scan(&keyFile)
+	keyJSON, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Print("Password: ")
+	passwd, err := gopass.GetPasswd()
+	if err != nil {
+		return nil, err
+	}
+	_, pubKey, err := utils.DecryptKey(keyJSON, string(passwd))
+	if err != nil {
+		return nil, err
+	}
+	p1, err := json.Marshal(user.Auth.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	p2, err := json.Marshal(pubKey.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	if user.Auth.Source != pubKey.Source || user.Auth.SigType != pubKey.SigType || string(p1) != string(p2) {
+		return nil, errExportKeyNotMatch
+	}
+	return keyJSON, nil
+}
+
+func init() {
+	exportIdentityCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir of node (default $HOME/%s)", params.DefaultPath))
+	exportIdentityCmd.PersistentFlags().StringVar(&exportUserID, "user", "", "user ID, can be address (pdu1...) or hex")
+	exportIdentityCmd.PersistentFlags().StringVar(&exportOut, "out", "bundle.json", "bundle file")
+	exportIdentityCmd.PersistentFlags().BoolVar(&exportWithKey, "withKey", false, "include the key file of user, still encrypted by password")
+	importIdentityCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir of node (default $HOME/%s)", params.DefaultPath))
+	importIdentityCmd.PersistentFlags().StringVar(&importKeyOut, "keyOut", "", "write the key file in bundle to this path if included")
+	rootCmd.AddCommand(exportIdentityCmd)
+	rootCmd.AddCommand(importIdentityCmd)
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/spf13/cobra"
+)
+
+var errNotIncluded = errors.New("not included by state root")
+
+// verifyInclusionCmd represents the verify-inclusion command
+var verifyInclusionCmd = &cobra.Command{
+	Use:   "verify-inclusion [stateRoot] [proofFile]",
+	Short: "Verify the msg or user is included by state root, the proof is from /inclusion of node",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		root, err := common.HashFromString(args[0])
+		if err != nil {
+			return err
+		}
+		proofBytes, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		var proof core.InclusionProof
+		if err := json.Unmarshal(proofBytes, &proof); err != nil {
+			return err
+		}
+		ok, err := core.VerifyInclusion(root, &proof)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNotIncluded
+		}
+		fmt.Println(proof.Kind, common.Hash2String(proof.ID), "is included by", common.Hash2String(root), "at seq", proof.Seq)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyInclusionCmd)
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the db layout to this release, or rollback to the layout before last upgrade",
+	RunE: func(_ *cobra.Command, args []string) error {
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		if migrateRollback {
+			version, err := db.RollbackFile(path.Join(dataDir, "u.db"))
+			if err != nil {
+				return err
+			}
+			fmt.Println("Rollback db to schema version", version)
+			return nil
+		}
+		return migrateDB(migrateDryRun)
+	},
+}
+
+// migrateDB apply pending migrations on db in data dir, the db is copied
+// before migrate, so it can be rolled back by pdu migrate --rollback
+func migrateDB(dryRun bool) error {
+	dbFilePath := path.Join(dataDir, "u.db")
+	// bolt create the db file if not exist
+	if _, err := os.Stat(dbFilePath); err != nil {
+		return err
+	}
+	version, applied, err := db.MigrateFile(dbFilePath, func(p string) (db.UDB, error) {
+		return bolt.NewDB(p)
+	}, dryRun)
+	for _, m := range applied {
+		log.Info("Migration", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Info("Schema version", version, "is up to date")
+	} else if dryRun {
+		log.Info("Dry run migrate from schema version", version, "to", db.SchemaVersion(), "successfully")
+	} else {
+		log.Info("Migrate from schema version", version, "to", db.SchemaVersion(), "backup", db.MigrationBackupFile(dbFilePath, version))
+	}
+	return nil
+}
+
+func init() {
+	migrateCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir of db to migrate (default $HOME/%s)", params.DefaultPath))
+	migrateCmd.PersistentFlags().BoolVar(&migrateDryRun, "dryRun", false, "run migrations on a copy of db, db is not changed")
+	migrateCmd.PersistentFlags().BoolVar(&migrateRollback, "rollback", false, "restore db from the backup made by last migrate")
+	rootCmd.AddCommand(migrateCmd)
+}
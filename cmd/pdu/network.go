@@ -0,0 +1,62 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+// networkPreset is the default settings of network, so nodes of test and
+// dev network can run on same machine with the main network
+type networkPreset struct {
+	network uint64
+	path    string // data dir under $HOME
+	port    uint64
+}
+
+var networkPresets = map[uint64]*networkPreset{
+	core.NetworkMain: {network: core.NetworkMain, path: params.DefaultPath, port: node.DefaultLocalPort},
+	core.NetworkTest: {network: core.NetworkTest, path: params.DefaultPath + "-test", port: node.DefaultLocalPort + 1},
+	core.NetworkDev:  {network: core.NetworkDev, path: params.DefaultPath + "-dev", port: node.DefaultLocalPort + 2},
+}
+
+// currentNetwork is the preset selected by --network
+var currentNetwork = networkPresets[core.NetworkMain]
+
+// selectNetwork set the preset by name, custom network by number use the
+// default port and its own data dir. The port is replaced by the preset
+// if not be set in command line.
+func selectNetwork(cmd *cobra.Command, _ []string) error {
+	network, err := core.NetworkByName(networkName)
+	if err != nil {
+		return err
+	}
+	preset, ok := networkPresets[network]
+	if !ok {
+		preset = &networkPreset{network: network, path: fmt.Sprintf("%s-%d", params.DefaultPath, network), port: node.DefaultLocalPort}
+	}
+	currentNetwork = preset
+	if f := cmd.Flags().Lookup("port"); f != nil && !f.Changed {
+		localPort = preset.port
+	}
+	return nil
+}
@@ -16,8 +16,19 @@
 
 package main
 
+import "time"
+
 // public
-var dataDir string
+var (
+	dataDir     string
+	networkName string
+)
+
+// create
+var (
+	createGenesisFile string
+	createGenesisOut  string
+)
 
 // account
 var (
@@ -25,15 +36,127 @@ var (
 	accOutput  string
 	accSigType string
 	accMSCount int
+	accInput   string
+	accFormat  string
+	accDER     bool
 )
 
 // start
 var (
 	nodeAddressList    string
+	nodePrimarySTID    string
+	nodeTrustedSTIDs   string
 	nodeTPEnable       bool
 	nodeTPInterval     uint64
+	nodeTPJitter       time.Duration
+	nodeTPAlert        int
+	nodeCPInterval     uint64
+	nodeSearchEnable   bool
+	nodeSnapshotSync   bool
+	nodeGenesisSync    bool
+	nodeBroadcastAck   bool
+	nodeAnnounce       bool
+	nodeAPIKeys        bool
+	nodeAPIKeysPrivate bool
+	nodeCORSOrigins    string
+	nodeTrustedProxies string
+	nodePathPrefix     string
+	nodeIPFSAPI        string
+	nodeIPFSGateways   string
+	nodeIPFSPin        bool
+	nodeWebhookFile    string
+	nodeRelayFile      string
+	nodeNotifyUsers    string
+	nodePinnedNodes    string
+	nodeDNSSeeds       string
+	nodeSeedKey        string
+	nodeMaxPeers       int
+	nodeMaxInbound     int
+	nodeMaxPerIP       int
+	nodeMaxPerSubnet   int
+	nodeAnchors        string
+	nodeDialTimeout    time.Duration
+	nodeReadTimeout    time.Duration
+	nodeWriteTimeout   time.Duration
+	nodeDialWorkers    int
+	nodeSendRate       int64
+	nodeRecvRate       int64
+	nodePeerSendRate   int64
+	nodePeerRecvRate   int64
+	nodeSenderRate     float64
+	nodeSenderBurst    int
+	nodeSQLiteMirror   string
+	nodeWiretapDir     string
+	nodeReadyPeers     int
+	nodeReadyLag       uint64
+	nodeRetentionFile  string
+	nodeArchiveURL     string
+	nodeArchiveRegion  string
+	nodeArchiveKeep    uint64
+	nodeLeaderURL      string
+	nodeLeaderKey      string
+	nodeAnchorEth      string
+	nodeAnchorBtc      string
+	nodeAnchorFrom     string
+	nodeAnchorTo       string
+	nodeAnchorInterval time.Duration
+	nodeSeenWindow     time.Duration
+	nodeFilterFile     string
+	nodeFilterFlag     float64
+	nodeFilterReject   float64
 	localPort          uint64
 	unlockKeyFile      string
 	unlockPassFile     string
 	unlockUserIDPrefix string
 )
+
+// replay
+var (
+	replayExport  bool
+	replaySelfRef bool
+)
+
+// export identity
+var (
+	exportUserID  string
+	exportOut     string
+	exportWithKey bool
+	importKeyOut  string
+)
+
+// backup
+var (
+	backupOut      string
+	backupUniverse string
+	restoreFrom    string
+)
+
+// migrate
+var (
+	migrateDryRun   bool
+	migrateRollback bool
+)
+
+// host
+var hostDataDirs string
+
+// dev
+var devKeysDir string
+
+// sync
+var (
+	syncUniverse string
+	syncAPIKey   string
+)
+
+// wiretap
+var (
+	wiretapPeer    string
+	wiretapCommand string
+)
+
+// admin
+var (
+	adminAddr      string
+	adminTokenFile string
+)
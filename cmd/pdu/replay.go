@@ -0,0 +1,142 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"os/signal"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+const maxExportCheckpoints = 100000
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay [archive file]",
+	Short: "Replay the msg archive, or export local msgs into archive",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if replayExport {
+			if err := updateDataDir(); err != nil {
+				return err
+			}
+			return exportArchive(args[0])
+		}
+		return replayArchive(args[0])
+	},
+}
+
+func replayArchive(archiveFile string) error {
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	config := core.DefaultUniverseConfig()
+	config.SelfRefRequired = replaySelfRef
+	config.NetworkID = currentNetwork.network
+	// replay of long archive can be stopped by interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	defer signal.Stop(c)
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	universe, rejections, err := core.ReplayContext(ctx, f, *config)
+	for _, r := range rejections {
+		fmt.Println("Rejected", common.Hash2String(r.MsgID), "code", r.Code, r.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	for _, stID := range universe.GetSpaceTimeIDs() {
+		fmt.Println("Space-time", common.Hash2String(stID), "max seq", universe.GetMaxSeq(stID))
+	}
+	fmt.Println("Replay finished without divergence")
+	return nil
+}
+
+func exportArchive(archiveFile string) error {
+	udb, err := initDBLoad()
+	if err != nil {
+		return err
+	}
+	defer udb.Close()
+	user0, user1, err := db.GetRootUsers(udb)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(archiveFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	aw, err := core.NewArchiveWriter(f, user0, user1)
+	if err != nil {
+		return err
+	}
+	count, err := db.GetMsgCount(udb)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count.Uint64(); i++ {
+		msgs := db.GetMsgByOrder(udb, new(big.Int).SetUint64(i), 1)
+		if len(msgs) == 0 {
+			return db.ErrMessageNotFound
+		}
+		if err := aw.WriteMsg(msgs[0]); err != nil {
+			return err
+		}
+	}
+	rows, err := udb.Find(db.BucketCheckpoint, "", maxExportCheckpoints)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var cp core.Checkpoint
+		if err := json.Unmarshal(row.V, &cp); err != nil {
+			return err
+		}
+		if err := aw.WriteCheckpoint(&cp); err != nil {
+			return err
+		}
+	}
+	fmt.Println(count, "msgs and", len(rows), "checkpoints exported to", archiveFile)
+	return nil
+}
+
+func init() {
+	replayCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("data dir of msgs to export (default $HOME/%s)", params.DefaultPath))
+	replayCmd.PersistentFlags().BoolVar(&replayExport, "export", false, "export local msgs into archive file")
+	replayCmd.PersistentFlags().BoolVar(&replaySelfRef, "selfRef", false, "self reference required when replay")
+	rootCmd.AddCommand(replayCmd)
+}
@@ -30,6 +30,7 @@ var rootCmd = &cobra.Command{
 	Long: `Parallel Digital Universe
 A decentralized identity-based social network
 Website: https://pdu.pub`,
+	PersistentPreRunE: selectNetwork,
 	/*
 		Run: func(cmd *cobra.Command, args []string) {
 			log.Info("pdu running ...")
@@ -46,4 +47,5 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&networkName, "network", "main", "network preset [main/test/dev] or number of custom network, msgs on diff networks never be accepted by each other")
 }
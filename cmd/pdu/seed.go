@@ -0,0 +1,64 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/spf13/cobra"
+)
+
+// seedCmd represents the seed command
+var seedCmd = &cobra.Command{
+	Use:   "seed [node address...]",
+	Short: "Sign node addresses [userid@ip:port/nodeKey] into TXT records of dns seed",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		var priKey *crypto.PrivateKey
+		var pubKey *crypto.PublicKey
+		var err error
+		if unlockKeyFile != "" {
+			priKey, pubKey, err = unlockKeyByFile(unlockKeyFile, unlockPassFile)
+		} else {
+			priKey, pubKey, err = unlockKeyByCmd()
+		}
+		if err != nil {
+			return err
+		}
+		seedKey, err := node.SeedKeyString(pubKey)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Seed key:", seedKey)
+		for _, address := range args {
+			record, err := node.CreateSeedRecord(address, priKey)
+			if err != nil {
+				return err
+			}
+			fmt.Println(record)
+		}
+		return nil
+	},
+}
+
+func init() {
+	seedCmd.PersistentFlags().StringVar(&unlockKeyFile, "key", "", "key file of seed, ETH key only")
+	seedCmd.PersistentFlags().StringVar(&unlockPassFile, "pass", "", "pass file")
+	rootCmd.AddCommand(seedCmd)
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/spf13/cobra"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Initial sync of running node, msgs are downloaded from all peers in parallel",
+}
+
+// syncStatusCmd represents the sync status command
+var syncStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the progress of sync of running node, and whether its view is current",
+	RunE: func(_ *cobra.Command, args []string) error {
+		nodeURL := fmt.Sprintf("http://127.0.0.1:%d", localPort)
+		if syncUniverse != "" {
+			nodeURL += node.UniversePathPrefix + syncUniverse
+		}
+		req, err := http.NewRequest(http.MethodGet, nodeURL+"/sync", nil)
+		if err != nil {
+			return err
+		}
+		if syncAPIKey != "" {
+			req.Header.Set(node.APIKeyHeader, syncAPIKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("%s %s", resp.Status, msg)
+		}
+		var status node.SyncStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return err
+		}
+		if snap := status.Snapshot; snap != nil {
+			printSnapshotStatus(snap)
+		}
+		switch {
+		case status.Done:
+			fmt.Println("Initial sync done in", status.EndTime.Sub(status.StartTime).Round(time.Millisecond))
+		case status.Running:
+			fmt.Println("Initial sync running for", time.Since(status.StartTime).Round(time.Second))
+		default:
+			fmt.Println("Initial sync not started, waiting for peers")
+		}
+		if status.Done || status.Running {
+			fmt.Println("Peers     ", status.Peers)
+			fmt.Println("Asked     ", status.Requested, "of", status.Target, "msgs,", status.InFlight, "ranges in flight")
+			fmt.Println("Downloaded", status.Downloaded, "msgs")
+			fmt.Println("Committed ", status.Committed, "msgs,", int(status.Rate), "msgs/s")
+			fmt.Println("Skipped   ", status.Duplicated, "duplicated,", status.Rejected, "rejected,", status.Pending, "pending")
+		}
+		printCurrentStatus(&status)
+		return nil
+	},
+}
+
+func printCurrentStatus(status *node.SyncStatus) {
+	fmt.Println()
+	if status.Current {
+		fmt.Println("View is current with peers")
+	} else {
+		fmt.Println("View is behind peers or not compared yet")
+	}
+	fmt.Println("Local     ", status.LocalCount, "msgs,", status.Remaining, "msgs remaining")
+	fmt.Printf("Download   %.1f msgs/s from %d active peers\n", status.DownloadRate, len(status.ActivePeers))
+	for _, url := range status.ActivePeers {
+		fmt.Println("          ", url)
+	}
+	for _, st := range status.SpaceTimes {
+		fmt.Println("Space-time", common.EncodeAddress(st.SpaceTimeID), "seq", st.LocalSeq, "of", st.PeerSeq, "known by peers")
+	}
+}
+
+func printSnapshotStatus(snap *node.SnapshotStatus) {
+	switch {
+	case snap.Applied:
+		fmt.Println("Snapshot applied in", snap.EndTime.Sub(snap.StartTime).Round(time.Millisecond))
+	case snap.Done:
+		fmt.Println("Snapshot not applied,", snap.Err)
+		return
+	case snap.Running && snap.Chunks == 0:
+		fmt.Println("Snapshot waiting for offers from peers")
+		return
+	case snap.Running:
+		fmt.Println("Snapshot sync running for", time.Since(snap.StartTime).Round(time.Second))
+	default:
+		fmt.Println("Snapshot sync not started, waiting for peers")
+		return
+	}
+	fmt.Println("Checkpoint", snap.Seq, "of space-time", common.EncodeAddress(snap.SpaceTimeID))
+	fmt.Println("Chunks    ", snap.Fetched, "fetched,", snap.Replayed, "replayed of", snap.Chunks, "from", snap.Peers, "peers")
+	fmt.Println("Msgs      ", snap.MsgCount)
+}
+
+func init() {
+	syncStatusCmd.PersistentFlags().Uint64Var(&localPort, "port", node.DefaultLocalPort, "local port of running node")
+	syncStatusCmd.PersistentFlags().StringVar(&syncUniverse, "universe", "", "universe ID (hex) if node is started by pdu host")
+	syncStatusCmd.PersistentFlags().StringVar(&syncAPIKey, "apikey", "", "api key if node requires it for local apis")
+	syncCmd.AddCommand(syncStatusCmd)
+	rootCmd.AddCommand(syncCmd)
+}
@@ -61,7 +61,7 @@ func initConfig() error {
 func initDir() error {
 	if dataDir == "" {
 		home, _ := homedir.Dir()
-		dataDir = path.Join(home, params.DefaultPath)
+		dataDir = path.Join(home, currentNetwork.path)
 	}
 	err := os.Mkdir(dataDir, os.ModePerm)
 	if err != nil {
@@ -77,34 +77,90 @@ func initDB() (db.UDB, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := udb.CreateBucket(db.BucketConfig); err != nil {
+	if err := initBuckets(udb); err != nil {
 		return nil, err
 	}
+	return udb, nil
+}
+
+// initBuckets create all buckets and the config of new db
+func initBuckets(udb db.UDB) error {
+	if err := udb.CreateBucket(db.BucketConfig); err != nil {
+		return err
+	}
 	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
-		return nil, err
+		return err
 	}
 	if err := udb.CreateBucket(db.BucketUser); err != nil {
-		return nil, err
+		return err
 	}
 	if err := udb.CreateBucket(db.BucketMsg); err != nil {
-		return nil, err
+		return err
 	}
 	if err := udb.CreateBucket(db.BucketMID); err != nil {
-		return nil, err
+		return err
 	}
 	if err := udb.CreateBucket(db.BucketMOD); err != nil {
-		return nil, err
+		return err
 	}
 	if err := udb.CreateBucket(db.BucketLastMID); err != nil {
-		return nil, err
+		return err
 	}
 	if err := udb.CreateBucket(db.BucketPeer); err != nil {
-		return nil, err
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketCheckpoint); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketSenderMID); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketTypeMID); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketContent); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketContentRef); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketNotify); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketAudit); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketOutbox); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketOutboxEvent); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketDelivery); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketArchive); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketFeed); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketAnchor); err != nil {
+		return err
+	}
+	if err := udb.CreateBucket(db.BucketLabel); err != nil {
+		return err
 	}
 	if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepInitDB).Bytes()); err != nil {
-		return nil, err
+		return err
 	}
-	return udb, nil
+	if err := db.SaveSchemaVersion(udb, db.SchemaVersion()); err != nil {
+		return err
+	}
+	if err := db.SaveNetworkID(udb, currentNetwork.network); err != nil {
+		return err
+	}
+	return nil
 }
 
 func unlockKeyByCmd() (*crypto.PrivateKey, *crypto.PublicKey, error) {
@@ -0,0 +1,64 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pdupub/go-pdu/conformance"
+	"github.com/spf13/cobra"
+)
+
+// defaultVectorsDir is the golden files in source tree
+const defaultVectorsDir = "conformance/testdata"
+
+var errVectorsFailed = errors.New("vectors failed")
+
+// verifyVectorsCmd represents the verify-vectors command
+var verifyVectorsCmd = &cobra.Command{
+	Use:   "verify-vectors [dir]",
+	Short: "Verify the test vectors of wire formats, such as the golden files of other implementations",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		dir := defaultVectorsDir
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		v, err := conformance.Load(dir)
+		if err != nil {
+			return err
+		}
+		failed := 0
+		results := v.Verify()
+		for _, r := range results {
+			fmt.Println(r)
+			if r.Err != nil {
+				failed++
+			}
+		}
+		fmt.Println(len(results)-failed, "passed", failed, "failed")
+		if failed > 0 {
+			return errVectorsFailed
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyVectorsCmd)
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pdupub/go-pdu/peer"
+	"github.com/spf13/cobra"
+)
+
+// wiretapCmd represents the wiretap command
+var wiretapCmd = &cobra.Command{
+	Use:   "wiretap",
+	Short: "Waves recorded by node started with --wiretap, to debug interop of node versions",
+}
+
+// wiretapDecodeCmd represents the wiretap decode command
+var wiretapDecodeCmd = &cobra.Command{
+	Use:   "decode [wiretap file or dir]",
+	Short: "Decode the recorded waves in order, the files in dir are decoded from oldest",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		files := []string{args[0]}
+		if info, err := os.Stat(args[0]); err != nil {
+			return err
+		} else if info.IsDir() {
+			if files, err = peer.WiretapFiles(args[0]); err != nil {
+				return err
+			}
+		}
+		for _, fileName := range files {
+			if err := decodeWiretap(fileName); err != nil {
+				return fmt.Errorf("%s %v", fileName, err)
+			}
+		}
+		return nil
+	},
+}
+
+func decodeWiretap(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	wr := peer.NewWiretapReader(f)
+	for {
+		r, err := wr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if wiretapPeer != "" && !strings.Contains(r.Peer, wiretapPeer) {
+			continue
+		}
+		if wiretapCommand != "" && r.Command != wiretapCommand {
+			continue
+		}
+		fmt.Println(r.Time.Format(time.RFC3339Nano), r.Dir, r.Peer, r.Command, len(r.Wave), "bytes")
+		// the wave can not be decoded by this version is shown as it is
+		w, err := r.Decode()
+		if err != nil {
+			fmt.Println("  decode fail:", err)
+			fmt.Println(" ", hex.EncodeToString(r.Wave))
+			continue
+		}
+		waveBytes, err := json.Marshal(w)
+		if err != nil {
+			return err
+		}
+		fmt.Println(" ", string(waveBytes))
+	}
+}
+
+func init() {
+	wiretapDecodeCmd.PersistentFlags().StringVar(&wiretapPeer, "peer", "", "only the waves of peers contain it, such as ip:port")
+	wiretapDecodeCmd.PersistentFlags().StringVar(&wiretapCommand, "command", "", "only the waves of command, such as messages")
+	wiretapCmd.AddCommand(wiretapDecodeCmd)
+	rootCmd.AddCommand(wiretapCmd)
+}
@@ -27,3 +27,293 @@
 // flags = '-target {} '.format(target)
 
 package pdumobile
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+// Only the types supported by gomobile (string, []byte, int, bool, error and
+// pointer of struct in this package) are used in the exported API, users
+// and msgs are passed in json.
+
+var (
+	errParseNodeAddressFail = errors.New("parse node address fail, should be [userID@]ip:port/nodeKey")
+	errAlreadySubscribed    = errors.New("feed already subscribed")
+	errIntervalNotValid     = errors.New("interval of subscription should be positive")
+)
+
+// Account contains the key pair of user
+type Account struct {
+	priKey *crypto.PrivateKey
+	pubKey *crypto.PublicKey
+}
+
+// NewAccount generate new key pair by source (PDU, ETH, BTC, BLS)
+func NewAccount(source string) (*Account, error) {
+	engine, err := utils.SelectEngine(source)
+	if err != nil {
+		return nil, err
+	}
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{priKey: priKey, pubKey: pubKey}, nil
+}
+
+// ImportAccount decrypt the keystore json by pass
+func ImportAccount(keyJSON []byte, pass string) (*Account, error) {
+	priKey, pubKey, err := utils.DecryptKey(keyJSON, pass)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{priKey: priKey, pubKey: pubKey}, nil
+}
+
+// Export encrypt the private key into keystore json by pass
+func (a *Account) Export(pass string) ([]byte, error) {
+	engine, err := utils.SelectEngine(a.priKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	return engine.EncryptKey(a.priKey, pass)
+}
+
+// RootUser return the root user json of this account
+func (a *Account) RootUser(name, extra string) ([]byte, error) {
+	return json.Marshal(core.CreateRootUser(*a.pubKey, name, extra))
+}
+
+// SignMsg create msg of user signed by this account, refs is the json of
+// []*core.MsgReference, can be empty
+func (a *Account) SignMsg(userJSON []byte, contentType int, content []byte, refs []byte) ([]byte, error) {
+	var user core.User
+	if err := json.Unmarshal(userJSON, &user); err != nil {
+		return nil, err
+	}
+	var msgRefs []*core.MsgReference
+	if len(refs) > 0 {
+		if err := json.Unmarshal(refs, &msgRefs); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := core.CreateMsg(&user, &core.MsgValue{ContentType: contentType, Content: content}, a.priKey, msgRefs...)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+// UserID return the address of user
+func UserID(userJSON []byte) (string, error) {
+	var user core.User
+	if err := json.Unmarshal(userJSON, &user); err != nil {
+		return "", err
+	}
+	return common.EncodeAddress(user.ID()), nil
+}
+
+// MsgID return the ID of msg in hex
+func MsgID(msgJSON []byte) (string, error) {
+	var msg core.Message
+	if err := json.Unmarshal(msgJSON, &msg); err != nil {
+		return "", err
+	}
+	return common.Hash2String(msg.ID()), nil
+}
+
+// VerifyMsg verify the signature of msg by the public key of sender
+func VerifyMsg(userJSON, msgJSON []byte) (bool, error) {
+	var user core.User
+	if err := json.Unmarshal(userJSON, &user); err != nil {
+		return false, err
+	}
+	var msg core.Message
+	if err := json.Unmarshal(msgJSON, &msg); err != nil {
+		return false, err
+	}
+	if msg.Signature == nil || msg.SenderID != user.ID() {
+		return false, nil
+	}
+	signature := *msg.Signature
+	signature.PubKey = user.Auth.PubKey
+	msg.Signature = &signature
+	return core.VerifyMsg(msg)
+}
+
+// VerifySeqProof verify the proof of msg sequence got from node by the
+// checkpoint trusted by app, the proof can be trimmed to the checkpoint by
+// node, see /proof of node.
+func VerifySeqProof(proofJSON, checkpointJSON []byte) (bool, error) {
+	var proof core.SeqProof
+	if err := json.Unmarshal(proofJSON, &proof); err != nil {
+		return false, err
+	}
+	var cp core.Checkpoint
+	if err := json.Unmarshal(checkpointJSON, &cp); err != nil {
+		return false, err
+	}
+	return core.VerifySeqProof(&proof, cp)
+}
+
+// FeedHandler is implemented by app to receive the msgs from node
+type FeedHandler interface {
+	OnMessage(msgJSON []byte)
+	OnError(err string)
+}
+
+// Conn is the connection to a node
+type Conn struct {
+	p *peer.Peer
+
+	mu         sync.Mutex
+	lastMsgID  common.Hash
+	subscribed bool
+	quit       chan struct{}
+}
+
+// Connect dial the node by address [userID@]ip:port/nodeKey
+func Connect(address string) (*Conn, error) {
+	if i := strings.Index(address, "@"); i >= 0 {
+		address = address[i+1:]
+	}
+	res := strings.Split(address, ":")
+	if len(res) != 2 {
+		return nil, errParseNodeAddressFail
+	}
+	ip := res[0]
+	res = strings.Split(res[1], "/")
+	if len(res) != 2 {
+		return nil, errParseNodeAddressFail
+	}
+	port, err := strconv.ParseUint(res[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	p, err := peer.New(ip, port, res[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Dial(); err != nil {
+		return nil, err
+	}
+	return &Conn{p: p, quit: make(chan struct{})}, nil
+}
+
+// SendMsg send the msg to node
+func (c *Conn) SendMsg(msgJSON []byte) error {
+	var msg core.Message
+	if err := json.Unmarshal(msgJSON, &msg); err != nil {
+		return err
+	}
+	return c.p.SendMsg(common.CreateHash(), &msg)
+}
+
+// Subscribe ask node for the msgs after lastMsgID (hex, empty from the first
+// msg) every interval milliseconds, the msgs received are passed to h.
+func (c *Conn) Subscribe(lastMsgID string, interval int, h FeedHandler) error {
+	if interval <= 0 {
+		return errIntervalNotValid
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed {
+		return errAlreadySubscribed
+	}
+	if lastMsgID != "" {
+		msgID, err := common.HashFromString(lastMsgID)
+		if err != nil {
+			return err
+		}
+		c.lastMsgID = msgID
+	}
+	c.subscribed = true
+
+	go func() {
+		if err := c.p.Serve(&feedHandler{c: c, h: h}); err != nil {
+			h.OnError(err.Error())
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if err := c.p.AskMessages(common.CreateHash(), c.getLastMsgID()); err != nil {
+				h.OnError(err.Error())
+			}
+			select {
+			case <-ticker.C:
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Conn) getLastMsgID() common.Hash {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastMsgID
+}
+
+func (c *Conn) setLastMsgID(msgID common.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastMsgID = msgID
+}
+
+// Close stop the subscription and close the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.quit:
+	default:
+		close(c.quit)
+	}
+	c.mu.Unlock()
+	return c.p.Close()
+}
+
+// feedHandler pass the msgs from node to FeedHandler
+type feedHandler struct {
+	galaxy.BaseHandler
+	c *Conn
+	h FeedHandler
+}
+
+func (f *feedHandler) HandleMessages(w *galaxy.WaveMessages) error {
+	for _, msgBytes := range w.Msgs {
+		var msg core.Message
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			f.h.OnError(err.Error())
+			continue
+		}
+		f.c.setLastMsgID(msg.ID())
+		f.h.OnMessage(msgBytes)
+	}
+	return nil
+}
+
+func (f *feedHandler) HandleErr(w *galaxy.WaveErr) error {
+	f.h.OnError(w.Err)
+	return nil
+}
+
+// waves not about feed are ignored
+func (f *feedHandler) HandlePong(w *galaxy.WavePong) error               { return nil }
+func (f *feedHandler) HandleRejections(w *galaxy.WaveRejections) error   { return nil }
+func (f *feedHandler) HandleCheckpoints(w *galaxy.WaveCheckpoints) error { return nil }
+func (f *feedHandler) HandlePeers(w *galaxy.WavePeers) error             { return nil }
@@ -0,0 +1,113 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package pdumobile
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type testFeedHandler struct{}
+
+func (h *testFeedHandler) OnMessage(msgJSON []byte) {}
+func (h *testFeedHandler) OnError(err string)       {}
+
+// newTestNode start the ws server which count the waves received, the asks
+// of subscription in these tests
+func newTestNode(t *testing.T) (*httptest.Server, string, *int32) {
+	var cnt int32
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				return
+			}
+			atomic.AddInt32(&cnt, 1)
+		}
+	}))
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, fmt.Sprintf("%s:%s/nodekey", host, port), &cnt
+}
+
+// waitCount wait until the count reach n, false if timeout
+func waitCount(cnt *int32, n int32) bool {
+	for i := 0; i < 200; i++ {
+		if atomic.LoadInt32(cnt) >= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestConn_Subscribe(t *testing.T) {
+	srv, address, cnt := newTestNode(t)
+	defer srv.Close()
+	c, err := Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Subscribe("", 10, &testFeedHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Subscribe("", 10, &testFeedHandler{}); err != errAlreadySubscribed {
+		t.Errorf("err should be %s, but get %v", errAlreadySubscribed, err)
+	}
+	if !waitCount(cnt, 3) {
+		t.Fatal("node should be asked every interval")
+	}
+	// unsubscribed once closed, node is not asked any more
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	asked := atomic.LoadInt32(cnt)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(cnt) != asked {
+		t.Error("node should not be asked after closed")
+	}
+}
+
+func TestConn_SubscribeInterval(t *testing.T) {
+	srv, address, cnt := newTestNode(t)
+	defer srv.Close()
+	c, err := Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, interval := range []int{0, -1} {
+		if err := c.Subscribe("", interval, &testFeedHandler{}); err != errIntervalNotValid {
+			t.Errorf("err should be %s, but get %v", errIntervalNotValid, err)
+		}
+	}
+	if err := c.Subscribe("", 10, &testFeedHandler{}); err != nil {
+		t.Fatal("subscribe should not be blocked by invalid interval", err)
+	}
+	if !waitCount(cnt, 1) {
+		t.Error("node should be asked")
+	}
+}
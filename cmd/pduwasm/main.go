@@ -0,0 +1,234 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build js && wasm
+// +build js,wasm
+
+// GOOS=js GOARCH=wasm go build -o pdu.wasm github.com/pdupub/go-pdu/cmd/pduwasm
+//
+// Load pdu.wasm with wasm_exec.js of the same go version, then the functions
+// are in the global object pdu. Keys, users, msgs and waves are passed as
+// json string, wave bytes are passed as Uint8Array, an Error is returned
+// instead of result if anything wrong.
+//
+//   pdu.generateKey(source, pass)                        => keyJSON
+//   pdu.unlockKey(keyJSON, pass)                         => handle
+//   pdu.createRootUser(handle, name, extra)              => userJSON
+//   pdu.userID(userJSON)                                 => address
+//   pdu.createMsg(handle, userJSON, type, content, refs) => msgJSON
+//   pdu.verifyMsg(userJSON, msgJSON)                     => bool
+//   pdu.encodeWave(command, waveJSON)                    => Uint8Array
+//   pdu.decodeWave(Uint8Array)                           => {command, wave}
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"syscall/js"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/galaxy"
+)
+
+var errKeyNotUnlocked = errors.New("key not unlocked")
+
+type keyPair struct {
+	priKey *crypto.PrivateKey
+	pubKey *crypto.PublicKey
+}
+
+// unlocked keys, the private key never leave wasm memory
+var keys []*keyPair
+
+type jsFunc func(args []js.Value) (interface{}, error)
+
+// wrap convert the error into js Error as result
+func wrap(f jsFunc) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		res, err := f(args)
+		if err != nil {
+			return js.Global().Get("Error").New(err.Error())
+		}
+		return res
+	})
+}
+
+func getKey(handle js.Value) (*keyPair, error) {
+	if handle.Type() != js.TypeNumber {
+		return nil, errKeyNotUnlocked
+	}
+	i := handle.Int()
+	if i < 0 || i >= len(keys) {
+		return nil, errKeyNotUnlocked
+	}
+	return keys[i], nil
+}
+
+func generateKey(args []js.Value) (interface{}, error) {
+	engine, err := utils.SelectEngine(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+	priKey, _, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	keyJSON, err := engine.EncryptKey(priKey, args[1].String())
+	if err != nil {
+		return nil, err
+	}
+	return string(keyJSON), nil
+}
+
+func unlockKey(args []js.Value) (interface{}, error) {
+	priKey, pubKey, err := utils.DecryptKey([]byte(args[0].String()), args[1].String())
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, &keyPair{priKey: priKey, pubKey: pubKey})
+	return len(keys) - 1, nil
+}
+
+func createRootUser(args []js.Value) (interface{}, error) {
+	key, err := getKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	user := core.CreateRootUser(*key.pubKey, args[1].String(), args[2].String())
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	return string(userBytes), nil
+}
+
+func parseUser(userJSON string) (*core.User, error) {
+	var user core.User
+	if err := json.Unmarshal([]byte(userJSON), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func userID(args []js.Value) (interface{}, error) {
+	user, err := parseUser(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+	return common.EncodeAddress(user.ID()), nil
+}
+
+func createMsg(args []js.Value) (interface{}, error) {
+	key, err := getKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	user, err := parseUser(args[1].String())
+	if err != nil {
+		return nil, err
+	}
+	value := &core.MsgValue{ContentType: args[2].Int(), Content: []byte(args[3].String())}
+	var refs []*core.MsgReference
+	if len(args) > 4 && args[4].Truthy() {
+		if err := json.Unmarshal([]byte(args[4].String()), &refs); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := core.CreateMsg(user, value, key.priKey, refs...)
+	if err != nil {
+		return nil, err
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return string(msgBytes), nil
+}
+
+// verifyMsg verify the signature of msg by public key of sender
+func verifyMsg(args []js.Value) (interface{}, error) {
+	user, err := parseUser(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+	var msg core.Message
+	if err := json.Unmarshal([]byte(args[1].String()), &msg); err != nil {
+		return nil, err
+	}
+	if msg.Signature == nil || msg.SenderID != user.ID() {
+		return false, nil
+	}
+	signature := *msg.Signature
+	signature.PubKey = user.Auth.PubKey
+	msg.Signature = &signature
+	return core.VerifyMsg(msg)
+}
+
+func encodeWave(args []js.Value) (interface{}, error) {
+	wave, err := galaxy.NewWave(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(args[1].String()), wave); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := galaxy.SendWave(&buf, wave); err != nil {
+		return nil, err
+	}
+	res := js.Global().Get("Uint8Array").New(buf.Len())
+	for i, b := range buf.Bytes() {
+		res.SetIndex(i, b)
+	}
+	return res, nil
+}
+
+func decodeWave(args []js.Value) (interface{}, error) {
+	waveBytes := make([]byte, args[0].Length())
+	for i := range waveBytes {
+		waveBytes[i] = byte(args[0].Index(i).Int())
+	}
+	wave, err := galaxy.ReceiveWave(bytes.NewReader(waveBytes))
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(wave)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"command": wave.Command(), "wave": string(body)}, nil
+}
+
+func main() {
+	pdu := map[string]interface{}{
+		"generateKey":    wrap(generateKey),
+		"unlockKey":      wrap(unlockKey),
+		"createRootUser": wrap(createRootUser),
+		"userID":         wrap(userID),
+		"createMsg":      wrap(createMsg),
+		"verifyMsg":      wrap(verifyMsg),
+		"encodeWave":     wrap(encodeWave),
+		"decodeWave":     wrap(decodeWave),
+	}
+	js.Global().Set("pdu", js.ValueOf(pdu))
+	// keep running, so the functions can be called from js
+	select {}
+}
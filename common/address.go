@@ -0,0 +1,150 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+// AddressPrefix is the human readable part of address
+const AddressPrefix = "pdu"
+
+const (
+	addressSeparator = '1'
+	addressCharset   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	checksumLength   = 6
+)
+
+var (
+	// ErrAddressPrefixNotMatch is returned if the prefix of address is not AddressPrefix
+	ErrAddressPrefixNotMatch = errors.New("address prefix not match")
+
+	// ErrAddressChecksumFail is returned if the checksum of address is not valid
+	ErrAddressChecksumFail = errors.New("address checksum fail")
+
+	// ErrAddressNotValid is returned if address contains invalid char or length
+	ErrAddressNotValid = errors.New("address not valid")
+
+	addressGenerator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+)
+
+// EncodeAddress encode the user ID into bech32 address, such as pdu1...
+func EncodeAddress(h Hash) string {
+	data := convertBits(h[:], 8, 5)
+	values := append(expandPrefix(AddressPrefix), data...)
+	values = append(values, make([]byte, checksumLength)...)
+	mod := polymod(values) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(AddressPrefix)
+	sb.WriteByte(addressSeparator)
+	for _, v := range data {
+		sb.WriteByte(addressCharset[v])
+	}
+	for i := 0; i < checksumLength; i++ {
+		sb.WriteByte(addressCharset[(mod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// DecodeAddress decode the bech32 address into user ID, checksum is verified
+func DecodeAddress(s string) (Hash, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return Hash{}, ErrAddressNotValid
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, addressSeparator)
+	if pos < 1 || pos+checksumLength+1 > len(s) {
+		return Hash{}, ErrAddressNotValid
+	}
+	if s[:pos] != AddressPrefix {
+		return Hash{}, ErrAddressPrefixNotMatch
+	}
+	var values []byte
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(addressCharset, c)
+		if v == -1 {
+			return Hash{}, ErrAddressNotValid
+		}
+		values = append(values, byte(v))
+	}
+	if polymod(append(expandPrefix(AddressPrefix), values...)) != 1 {
+		return Hash{}, ErrAddressChecksumFail
+	}
+	data := values[:len(values)-checksumLength]
+	if len(data) != (HashLength*8+4)/5 || data[len(data)-1]&0xf != 0 {
+		return Hash{}, ErrAddressNotValid
+	}
+	b := convertBits(data, 5, 8)
+	return Bytes2Hash(b[:HashLength]), nil
+}
+
+// ParseUserID parse the user ID from address (pdu1...) or hex string
+func ParseUserID(s string) (Hash, error) {
+	if strings.HasPrefix(strings.ToLower(s), AddressPrefix+string(addressSeparator)) {
+		return DecodeAddress(s)
+	}
+	return HashFromString(s)
+}
+
+// expandPrefix expand the prefix for checksum
+func expandPrefix(prefix string) []byte {
+	ret := make([]byte, 0, len(prefix)*2+1)
+	for i := 0; i < len(prefix); i++ {
+		ret = append(ret, prefix[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(prefix); i++ {
+		ret = append(ret, prefix[i]&31)
+	}
+	return ret
+}
+
+// polymod is the checksum function of bech32
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= addressGenerator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// convertBits regroup the bits of data from fromBits to toBits, padding with zero
+func convertBits(data []byte, fromBits, toBits uint) []byte {
+	var ret []byte
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<toBits - 1
+	for _, v := range data {
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+	if bits > 0 {
+		ret = append(ret, byte(acc<<(toBits-bits)&maxv))
+	}
+	return ret
+}
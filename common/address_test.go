@@ -0,0 +1,58 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeAddress(t *testing.T) {
+	h := Bytes2Hash([]byte("hello world, this is a user id!!"))
+	addr := EncodeAddress(h)
+	if !strings.HasPrefix(addr, AddressPrefix+"1") {
+		t.Errorf("address should start with %s1, but get %s", AddressPrefix, addr)
+	}
+	if res, err := DecodeAddress(addr); err != nil || res != h {
+		t.Error("decode address fail", err)
+	}
+	if res, err := DecodeAddress(strings.ToUpper(addr)); err != nil || res != h {
+		t.Error("decode upper case address fail", err)
+	}
+	if res, err := ParseUserID(addr); err != nil || res != h {
+		t.Error("parse user ID from address fail", err)
+	}
+	if res, err := ParseUserID(Hash2String(h)); err != nil || res != h {
+		t.Error("parse user ID from hex fail", err)
+	}
+
+	typo := []byte(addr)
+	if typo[10] == 'q' {
+		typo[10] = 'p'
+	} else {
+		typo[10] = 'q'
+	}
+	if _, err := DecodeAddress(string(typo)); err != ErrAddressChecksumFail {
+		t.Errorf("err should be %s, but get %s", ErrAddressChecksumFail, err)
+	}
+	if _, err := DecodeAddress("btc" + addr[len(AddressPrefix):]); err != ErrAddressPrefixNotMatch {
+		t.Errorf("err should be %s, but get %s", ErrAddressPrefixNotMatch, err)
+	}
+	if _, err := DecodeAddress(addr[:5] + strings.ToUpper(addr[5:])); err != ErrAddressNotValid {
+		t.Errorf("err should be %s, but get %s", ErrAddressNotValid, err)
+	}
+}
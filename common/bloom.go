@@ -0,0 +1,95 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+var (
+	errBloomParamsNotValid = errors.New("bloom filter params not valid")
+)
+
+// BloomFilter is used to check if item not exist quickly, false positive
+// is possible, but false negative is not.
+type BloomFilter struct {
+	Bits   []uint64 `json:"bits"`
+	K      uint64   `json:"k"`
+	M      uint64   `json:"m"`
+	Count  uint64   `json:"count"`
+	FPRate float64  `json:"fpRate"`
+}
+
+// NewBloomFilter create bloom filter for capacity items with expected false positive rate
+func NewBloomFilter(capacity uint64, fpRate float64) (*BloomFilter, error) {
+	if capacity == 0 || fpRate <= 0 || fpRate >= 1 {
+		return nil, errBloomParamsNotValid
+	}
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	return &BloomFilter{
+		Bits:   make([]uint64, (m+63)/64),
+		K:      k,
+		M:      m,
+		FPRate: fpRate,
+	}, nil
+}
+
+// locations return the bit positions of item by double hashing
+func (bf BloomFilter) locations(item []byte) []uint64 {
+	h := fnv.New128a()
+	h.Write(item)
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:])
+	locs := make([]uint64, bf.K)
+	for i := uint64(0); i < bf.K; i++ {
+		locs[i] = (h1 + i*h2) % bf.M
+	}
+	return locs
+}
+
+// Add the item into bloom filter
+func (bf *BloomFilter) Add(item []byte) {
+	for _, loc := range bf.locations(item) {
+		bf.Bits[loc/64] |= 1 << (loc % 64)
+	}
+	bf.Count++
+}
+
+// MayContain return false if item definitely not be added,
+// true if item may be added before.
+func (bf BloomFilter) MayContain(item []byte) bool {
+	for _, loc := range bf.locations(item) {
+		if bf.Bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Match return true if bloom filter created by same params
+func (bf BloomFilter) Match(capacity uint64, fpRate float64) bool {
+	other, err := NewBloomFilter(capacity, fpRate)
+	if err != nil {
+		return false
+	}
+	return bf.M == other.M && bf.K == other.K && uint64(len(bf.Bits)) == (bf.M+63)/64
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import "fmt"
+
+// ErrorCode is the stable numeric code of error, which is kept across
+// versions, so rpc clients and peers can know the category of failure
+// without comparing the error strings. The codes are grouped by package.
+type ErrorCode uint32
+
+// ranges of error codes
+const (
+	// ErrCodeUnknown is the code of error not created by NewError
+	ErrCodeUnknown ErrorCode = 0
+	// ErrCodeCore is the start code of errors in core
+	ErrCodeCore ErrorCode = 1000
+	// ErrCodeCrypto is the start code of errors in crypto
+	ErrCodeCrypto ErrorCode = 2000
+	// ErrCodePeer is the start code of errors in peer
+	ErrCodePeer ErrorCode = 3000
+	// ErrCodeGalaxy is the start code of errors in galaxy
+	ErrCodeGalaxy ErrorCode = 4000
+)
+
+// Error is the error with stable code. The sentinel errors are created by
+// NewError and can wrap the cause by Wrap, the wrapped error is matched with
+// the sentinel by errors.Is and its cause can be got by errors.As.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Cause   error
+}
+
+// NewError create the error with code and message
+func NewError(code ErrorCode, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+// Unwrap return the cause of error
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is return true if the target is Error with same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap return the copy of error with cause
+func (e *Error) Wrap(cause error) *Error {
+	return &Error{Code: e.Code, Message: e.Message, Cause: cause}
+}
+
+// Wrapf return the copy of error with cause formatted
+func (e *Error) Wrapf(format string, args ...interface{}) *Error {
+	return e.Wrap(fmt.Errorf(format, args...))
+}
+
+// CodeOf return the code of first Error in the chain of err, the chain is
+// unwrapped by Unwrap() error, ErrCodeUnknown if not found
+func CodeOf(err error) ErrorCode {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e.Code
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return ErrCodeUnknown
+		}
+		err = u.Unwrap()
+	}
+	return ErrCodeUnknown
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	errA := NewError(ErrCodeCore+1, "a")
+	errB := NewError(ErrCodeCore+2, "b")
+	if CodeOf(errA) != ErrCodeCore+1 || CodeOf(errors.New("a")) != ErrCodeUnknown || CodeOf(nil) != ErrCodeUnknown {
+		t.Error("code not match")
+	}
+
+	cause := errors.New("cause")
+	wrapped := errA.Wrap(cause)
+	if wrapped.Error() != "a: cause" {
+		t.Errorf("message should be a: cause, but get %s", wrapped.Error())
+	}
+	if !errors.Is(wrapped, errA) || errors.Is(wrapped, errB) || !errors.Is(wrapped, cause) {
+		t.Error("wrapped error should match sentinel and cause")
+	}
+
+	// remote error with same code match the sentinel
+	if !errors.Is(NewError(errB.Code, "remote"), errB) {
+		t.Error("error of same code should match")
+	}
+
+	outer := fmt.Errorf("outer: %w", wrapped)
+	if CodeOf(outer) != errA.Code {
+		t.Error("code should be found in chain")
+	}
+	var e *Error
+	if !errors.As(outer, &e) || e.Cause != cause {
+		t.Error("error should be got by errors.As")
+	}
+}
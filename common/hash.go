@@ -17,10 +17,14 @@
 package common
 
 import (
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"math/rand"
+	"strings"
 )
 
 // Hash is fixed length []byte
@@ -29,13 +33,75 @@ type Hash [HashLength]byte
 // HashLength is the length of Hash
 const HashLength = 32
 
-// Hash2String is transform Hash to string
-func Hash2String(h Hash) (s string) {
-	s = ""
-	for i := 0; i < HashLength; i++ {
-		s += fmt.Sprintf("%02X", h[i])
+// ErrHashNotValid is returned if the string is not hex of HashLength bytes
+var ErrHashNotValid = errors.New("hash not valid")
+
+// IsZero return true if all bytes of hash are zero
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+// Equal compare two hashes in constant time
+func (h Hash) Equal(other Hash) bool {
+	return subtle.ConstantTimeCompare(h[:], other[:]) == 1
+}
+
+// MarshalText encode hash into hex, so hash is string in json
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(Hash2String(h)), nil
+}
+
+// UnmarshalText decode hash from hex
+func (h *Hash) UnmarshalText(input []byte) error {
+	res, err := HashFromString(string(input))
+	if err != nil {
+		return err
 	}
-	return s
+	*h = res
+	return nil
+}
+
+// UnmarshalJSON decode hash from hex string, or from the array of bytes which
+// is the json of hash before it is marshalled as text.
+func (h *Hash) UnmarshalJSON(input []byte) error {
+	if string(input) == "null" {
+		return nil
+	}
+	if len(input) > 0 && input[0] == '[' {
+		var b []byte
+		if err := json.Unmarshal(input, &b); err != nil {
+			return err
+		}
+		if len(b) != HashLength {
+			return ErrHashNotValid
+		}
+		*h = Bytes2Hash(b)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(input, &s); err != nil {
+		return err
+	}
+	return h.UnmarshalText([]byte(s))
+}
+
+// HashFromString decode the hex string (0x prefix is optional) into Hash,
+// the length must be HashLength bytes.
+func HashFromString(s string) (Hash, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s) != HashLength*2 {
+		return Hash{}, ErrHashNotValid
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Hash{}, ErrHashNotValid
+	}
+	return Bytes2Hash(b), nil
+}
+
+// Hash2String is transform Hash to string
+func Hash2String(h Hash) string {
+	return strings.ToUpper(hex.EncodeToString(h[:]))
 }
 
 // Bytes2String is transform []byte to string
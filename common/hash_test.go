@@ -0,0 +1,89 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHash_MarshalText(t *testing.T) {
+	h := Bytes2Hash([]byte("hello world"))
+	b, err := json.Marshal(struct {
+		ID Hash `json:"id"`
+	}{ID: h})
+	if err != nil {
+		t.Fatal("marshal fail", err)
+	}
+	if string(b) != `{"id":"`+Hash2String(h)+`"}` {
+		t.Error("hash should be hex string in json, but get", string(b))
+	}
+	var res struct {
+		ID Hash `json:"id"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil || res.ID != h {
+		t.Error("unmarshal fail", err)
+	}
+	if err := json.Unmarshal([]byte(`{"id":"1234"}`), &res); err == nil {
+		t.Error("unmarshal should fail if length not match")
+	}
+}
+
+func TestHash_UnmarshalJSONLegacy(t *testing.T) {
+	h := Bytes2Hash([]byte("hello world"))
+	legacy, err := json.Marshal(struct {
+		ID [HashLength]byte `json:"id"`
+	}{ID: h})
+	if err != nil {
+		t.Fatal("marshal fail", err)
+	}
+	var res struct {
+		ID Hash `json:"id"`
+	}
+	if err := json.Unmarshal(legacy, &res); err != nil || res.ID != h {
+		t.Error("hash encoded as array of bytes should be decoded", err)
+	}
+	if err := json.Unmarshal([]byte(`{"id":[1,2,3]}`), &res); err == nil {
+		t.Error("unmarshal should fail if length not match")
+	}
+	var hs map[Hash]bool
+	if err := json.Unmarshal([]byte(`{"`+Hash2String(h)+`":true}`), &hs); err != nil || !hs[h] {
+		t.Error("hash as map key should be decoded from hex", err)
+	}
+}
+
+func TestHashFromString(t *testing.T) {
+	h := Bytes2Hash([]byte("hello world"))
+	s := Hash2String(h)
+	for _, v := range []string{s, strings.ToLower(s), "0x" + s} {
+		if res, err := HashFromString(v); err != nil || !res.Equal(h) {
+			t.Error("hash from string fail", v, err)
+		}
+	}
+	for _, v := range []string{"", s[2:], s + "00", "zz" + s[2:]} {
+		if _, err := HashFromString(v); err != ErrHashNotValid {
+			t.Errorf("err should be %s, but get %v", ErrHashNotValid, err)
+		}
+	}
+	if h.IsZero() || !(Hash{}).IsZero() {
+		t.Error("is zero not match")
+	}
+	if h.Equal(Hash{}) {
+		t.Error("hash should not be equal")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// HasherSHA256 is the name of SHA-256 hasher, used by default
+	HasherSHA256 = "sha256"
+	// HasherKeccak256 is the name of Keccak-256 hasher, same as Ethereum
+	HasherKeccak256 = "keccak256"
+	// HasherBLAKE3 is the name of BLAKE3 hasher with 256 bits output
+	HasherBLAKE3 = "blake3"
+)
+
+// ErrHasherNotSupport is returned if the hasher name is unknown
+var ErrHasherNotSupport = errors.New("hasher not support")
+
+// Hasher is the hash function used to calculate the ID of msg and user,
+// chosen by each universe
+type Hasher interface {
+	Name() string
+	New() hash.Hash
+}
+
+type hasher struct {
+	name  string
+	newFn func() hash.Hash
+}
+
+func (h *hasher) Name() string   { return h.name }
+func (h *hasher) New() hash.Hash { return h.newFn() }
+
+// hashers are pointers, so the hashers can be compared and the msgs or users
+// bound to same hasher are equal
+var hashers = map[string]Hasher{
+	HasherSHA256:    &hasher{name: HasherSHA256, newFn: sha256.New},
+	HasherKeccak256: &hasher{name: HasherKeccak256, newFn: sha3.NewLegacyKeccak256},
+	HasherBLAKE3:    &hasher{name: HasherBLAKE3, newFn: NewBLAKE3},
+}
+
+// SelectHasher return the hasher by name, SHA-256 is returned if name is empty
+func SelectHasher(name string) (Hasher, error) {
+	if name == "" {
+		return DefaultHasher(), nil
+	}
+	h, ok := hashers[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrHasherNotSupport
+	}
+	return h, nil
+}
+
+// DefaultHasher return the SHA-256 hasher, used by the msgs and users not
+// bound to any universe yet
+func DefaultHasher() Hasher {
+	return hashers[HasherSHA256]
+}
+
+// NewHash return a new hash.Hash of the default hasher, used by the hashes
+// not depend on universe, such as peer ID and merkle tree of proofs
+func NewHash() hash.Hash {
+	return DefaultHasher().New()
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSelectHasher(t *testing.T) {
+	vectors := map[string]string{
+		HasherSHA256:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		HasherKeccak256: "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
+		HasherBLAKE3:    "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+	}
+	for name, expect := range vectors {
+		h, err := SelectHasher(name)
+		if err != nil {
+			t.Fatal("select hasher fail", name, err)
+		}
+		if h.Name() != name {
+			t.Errorf("name should be %s, but get %s", name, h.Name())
+		}
+		if res := hex.EncodeToString(h.New().Sum(nil)); res != expect {
+			t.Errorf("hash of %s should be %s, but get %s", name, expect, res)
+		}
+	}
+	if h, err := SelectHasher(""); err != nil || h.Name() != HasherSHA256 {
+		t.Error("default hasher should be", HasherSHA256)
+	}
+	if _, err := SelectHasher("md5"); err != ErrHasherNotSupport {
+		t.Errorf("err should be %s, but get %v", ErrHasherNotSupport, err)
+	}
+}
+
+func TestBLAKE3(t *testing.T) {
+	// test vectors from the BLAKE3 reference, input is i % 251
+	vectors := map[int]string{
+		1:    "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213",
+		1024: "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7",
+		1025: "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444",
+		2048: "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a",
+	}
+	for n, expect := range vectors {
+		input := make([]byte, n)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		h := NewBLAKE3()
+		// write in two parts, result should be same
+		h.Write(input[:n/3])
+		h.Write(input[n/3:])
+		if res := hex.EncodeToString(h.Sum(nil)); res != expect {
+			t.Errorf("hash of %d bytes should be %s, but get %s", n, expect, res)
+		}
+	}
+}
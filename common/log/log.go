@@ -17,8 +17,11 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -34,6 +37,37 @@ const (
 	LvlTrace
 )
 
+// ErrLevelNotValid is returned if the level name can not be parsed
+var ErrLevelNotValid = errors.New("log level not valid")
+
+// level is the max level printed, all levels are printed by default
+var level = int32(LvlTrace)
+
+// SetLevel set the max level printed, logs above it are dropped
+func SetLevel(lvl int) {
+	atomic.StoreInt32(&level, int32(lvl))
+}
+
+// Level return the max level printed
+func Level() int {
+	return int(atomic.LoadInt32(&level))
+}
+
+// ParseLevel parse the level name, such as error, warn, info, debug or trace
+func ParseLevel(name string) (int, error) {
+	for lvl := LvlError; lvl <= LvlTrace; lvl++ {
+		if strings.EqualFold(strings.TrimSpace(alignedString(lvl)), name) {
+			return lvl, nil
+		}
+	}
+	return 0, ErrLevelNotValid
+}
+
+// LevelName return the name of level in lower case
+func LevelName(lvl int) string {
+	return strings.ToLower(strings.TrimSpace(alignedString(lvl)))
+}
+
 func alignedString(lvl int) string {
 	switch lvl {
 	case LvlTrace:
@@ -95,6 +129,9 @@ func Debug(v ...interface{}) {
 }
 
 func println(lvl int, v ...interface{}) {
+	if lvl > Level() {
+		return
+	}
 	fmt.Printf("%c[;;%dm", 0x1B, msgColor(lvl))
 	v = append([]interface{}{alignedString(lvl)}, v...)
 	log.Println(v...)
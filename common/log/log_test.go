@@ -16,7 +16,10 @@
 
 package log
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestLogMsg(t *testing.T) {
 	Error("this is a error msg")
@@ -25,3 +28,22 @@ func TestLogMsg(t *testing.T) {
 	Trace("this is a trace msg")
 	Debug("this is a debug msg")
 }
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(LvlTrace)
+	for _, name := range []string{"error", "warn", "INFO", "debug", "trace"} {
+		lvl, err := ParseLevel(name)
+		if err != nil {
+			t.Error(err)
+		}
+		SetLevel(lvl)
+		if Level() != lvl || LevelName(Level()) != strings.ToLower(name) {
+			t.Error("level not set", name)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err != ErrLevelNotValid {
+		t.Error("level should not be valid")
+	}
+	SetLevel(LvlWarn)
+	Info("this info msg should be dropped")
+}
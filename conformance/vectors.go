@@ -0,0 +1,381 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package conformance provides the test vectors of wire formats, such as
+// the encodings of keys, signatures, msgs and waves. The vectors are kept as
+// golden files in testdata, so other implementations can check they are
+// compatible with this one.
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/galaxy"
+)
+
+// Files of vectors in dir
+const (
+	KeysFile       = "keys.json"
+	SignaturesFile = "signatures.json"
+	MessagesFile   = "messages.json"
+	WavesFile      = "waves.json"
+)
+
+// vectorTimestamp is the timestamp of all msgs in vectors, so msgs are same
+// whenever generated
+const vectorTimestamp = 1600000000
+
+var errKeyVectorNotExist = errors.New("key vector not exist")
+
+// KeyVector is the key pair and the root user created by its public key.
+// Private key is derived from the sha256 of seed, so it is same whenever
+// generated.
+type KeyVector struct {
+	Name       string          `json:"name"`
+	Seeds      []string        `json:"seeds"`
+	PrivateKey json.RawMessage `json:"privateKey"`
+	PublicKey  json.RawMessage `json:"publicKey"`
+	User       json.RawMessage `json:"user"`
+	UserID     string          `json:"userID"`
+}
+
+// SignatureVector is the signature of data by key, the signature of each
+// key in MS is joined by order
+type SignatureVector struct {
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+	Data      string `json:"data"`      // hex
+	Signature string `json:"signature"` // hex
+}
+
+// MessageVector is the msg signed by key, SignedBytes is the bytes of msg
+// signed by sender
+type MessageVector struct {
+	Name        string          `json:"name"`
+	Key         string          `json:"key"`
+	Message     json.RawMessage `json:"message"`
+	SignedBytes string          `json:"signedBytes"` // hex
+	ID          string          `json:"id"`
+}
+
+// WaveVector is the bytes of wave sent to peer, with header
+type WaveVector struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Encoded string `json:"encoded"` // hex
+}
+
+// Vectors is all vectors, each kind is saved in its own file
+type Vectors struct {
+	Keys       []*KeyVector
+	Signatures []*SignatureVector
+	Messages   []*MessageVector
+	Waves      []*WaveVector
+}
+
+// keySpec is the key pair generated, sigType is MS if more than one seed
+type keySpec struct {
+	name   string
+	source string
+	seeds  []string
+}
+
+var keySpecs = []*keySpec{
+	{name: "eth", source: crypto.ETH, seeds: []string{"pdu-eth-0"}},
+	{name: "btc", source: crypto.BTC, seeds: []string{"pdu-btc-0"}},
+	{name: "eth-ms", source: crypto.ETH, seeds: []string{"pdu-eth-ms-0", "pdu-eth-ms-1"}},
+}
+
+// Generate create all vectors by the keys derived from seeds. IDs of users
+// and msgs depend on the hasher, so vectors should be generated and verified
+// by the default hasher.
+func Generate() (*Vectors, error) {
+	v := &Vectors{}
+	keys := make(map[string]*crypto.PrivateKey)
+	users := make(map[string]*core.User)
+	for _, spec := range keySpecs {
+		kv, priKey, user, err := generateKey(spec)
+		if err != nil {
+			return nil, err
+		}
+		v.Keys = append(v.Keys, kv)
+		keys[spec.name], users[spec.name] = priKey, user
+	}
+
+	for _, spec := range keySpecs {
+		data := []byte("pdu conformance " + spec.name)
+		sig, err := signData(keys[spec.name], data)
+		if err != nil {
+			return nil, err
+		}
+		v.Signatures = append(v.Signatures, &SignatureVector{
+			Name:      spec.name,
+			Key:       spec.name,
+			Data:      hex.EncodeToString(data),
+			Signature: hex.EncodeToString(sig),
+		})
+	}
+
+	var msgs []*core.Message
+	for _, spec := range keySpecs {
+		user, priKey := users[spec.name], keys[spec.name]
+		text := &core.MsgValue{ContentType: core.TypeText, Content: []byte("hello " + spec.name)}
+		// timestamp is part of msg ID, so msgs are stamped before referred
+		stamp := func(msg *core.Message, err error) (*core.Message, error) {
+			if err != nil {
+				return nil, err
+			}
+			msg.Timestamp = vectorTimestamp
+			return msg, msg.Sign(priKey)
+		}
+		first, err := stamp(core.CreateMsg(user, text, priKey))
+		if err != nil {
+			return nil, err
+		}
+		ref := &core.MsgReference{SenderID: user.ID(), MsgID: first.ID()}
+		reply, err := stamp(core.CreateMsgOnNetwork(core.NetworkDev, user, &core.MsgValue{ContentType: core.TypeText, Content: []byte("reply")}, priKey, 0, ref))
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := stamp(core.CreateMsgWithExpiry(core.NetworkMain, 16, user, &core.MsgValue{ContentType: core.TypeText, Content: []byte("ephemeral")}, priKey, 0, ref))
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range []*core.Message{first, reply, ephemeral} {
+			mv, err := messageVector(fmt.Sprintf("%s-%s", spec.name, []string{"text", "reply-dev", "ephemeral"}[i]), spec.name, msg)
+			if err != nil {
+				return nil, err
+			}
+			v.Messages = append(v.Messages, mv)
+			msgs = append(msgs, msg)
+		}
+	}
+
+	waves, err := generateWaves(msgs)
+	if err != nil {
+		return nil, err
+	}
+	v.Waves = waves
+	return v, nil
+}
+
+// generateKey create the key pair by seeds, and the root user of it
+func generateKey(spec *keySpec) (*KeyVector, *crypto.PrivateKey, *core.User, error) {
+	engine, err := utils.SelectEngine(spec.source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyMap := map[string]interface{}{"source": spec.source, "sigType": crypto.Signature2PublicKey}
+	var keyHex []string
+	for _, seed := range spec.seeds {
+		d := sha256.Sum256([]byte(seed))
+		keyHex = append(keyHex, hex.EncodeToString(d[:]))
+	}
+	if len(keyHex) > 1 {
+		keyMap["sigType"], keyMap["privKey"] = crypto.MultipleSignatures, keyHex
+	} else {
+		keyMap["privKey"] = keyHex[0]
+	}
+	keyBytes, err := json.Marshal(keyMap)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	priKey, _, err := engine.Unmarshal(keyBytes, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pubKey, err := publicKey(priKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	priKeyBytes, pubKeyBytes, err := engine.Marshal(priKey, pubKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	user := core.CreateRootUser(*pubKey, spec.name, "conformance")
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &KeyVector{
+		Name:       spec.name,
+		Seeds:      spec.seeds,
+		PrivateKey: priKeyBytes,
+		PublicKey:  pubKeyBytes,
+		User:       userBytes,
+		UserID:     common.Hash2String(user.ID()),
+	}, priKey, user, nil
+}
+
+// publicKey derive the public key from private key, the engines return the
+// public key with signature
+func publicKey(priKey *crypto.PrivateKey) (*crypto.PublicKey, error) {
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := engine.Sign([]byte{}, priKey)
+	if err != nil {
+		return nil, err
+	}
+	return &sig.PublicKey, nil
+}
+
+// signData return the signature bytes of data
+func signData(priKey *crypto.PrivateKey, data []byte) ([]byte, error) {
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := engine.Sign(data, priKey)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Signature, nil
+}
+
+func messageVector(name, key string, msg *core.Message) (*MessageVector, error) {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := msg.SignedBytes()
+	if err != nil {
+		return nil, err
+	}
+	return &MessageVector{
+		Name:        name,
+		Key:         key,
+		Message:     msgBytes,
+		SignedBytes: hex.EncodeToString(signed),
+		ID:          common.Hash2String(msg.ID()),
+	}, nil
+}
+
+// generateWaves create the waves with fixed wave ID, the msgs wave carry
+// the msgs given
+func generateWaves(msgs []*core.Message) ([]*WaveVector, error) {
+	waveID := common.Bytes2Hash([]byte("pdu conformance wave"))
+	var msgsBytes [][]byte
+	for _, msg := range msgs {
+		msgBytes, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgsBytes = append(msgsBytes, msgBytes)
+	}
+	rejection := &core.Rejection{MsgID: msgs[0].ID(), Code: core.RejectDuplicate, Reason: core.ErrMsgAlreadyExist.Error()}
+	waves := []struct {
+		name string
+		wave galaxy.Wave
+	}{
+		{"ping", &galaxy.WavePing{WaveID: waveID}},
+		{"version", &galaxy.WaveVersion{WaveID: waveID}},
+		{"question-msgrange", &galaxy.WaveQuestion{WaveID: waveID, Cmd: galaxy.QuestionMsgRange, Args: [][]byte{[]byte("1"), []byte("16")}}},
+		{"messages", &galaxy.WaveMessages{WaveID: waveID, Msgs: msgsBytes, Total: uint64(len(msgsBytes))}},
+		{"rejections", &galaxy.WaveRejections{WaveID: waveID, Rejections: []*core.Rejection{rejection}}},
+		{"ack", &galaxy.WaveAck{WaveID: waveID, MsgIDs: []common.Hash{msgs[0].ID()}}},
+		{"inv", &galaxy.WaveInv{WaveID: waveID, MsgIDs: []common.Hash{msgs[0].ID()}}},
+		{"getmsgs", &galaxy.WaveGetMsgs{WaveID: waveID, MsgIDs: []common.Hash{msgs[0].ID()}}},
+	}
+	var vectors []*WaveVector
+	for _, w := range waves {
+		encoded, err := encodeWave(w.wave)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, &WaveVector{Name: w.name, Command: w.wave.Command(), Encoded: hex.EncodeToString(encoded)})
+	}
+	return vectors, nil
+}
+
+// Load read the vectors from files in dir
+func Load(dir string) (*Vectors, error) {
+	v := &Vectors{}
+	for fileName, vectors := range v.files() {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, vectors); err != nil {
+			return nil, fmt.Errorf("%s %v", fileName, err)
+		}
+	}
+	return v, nil
+}
+
+// Save write the vectors into files in dir
+func (v *Vectors) Save(dir string) error {
+	for fileName, vectors := range v.files() {
+		data, err := marshalFile(vectors)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fileName), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files return the content of each file, same as the file saved
+func (v *Vectors) Files() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for fileName, vectors := range v.files() {
+		data, err := marshalFile(vectors)
+		if err != nil {
+			return nil, err
+		}
+		files[fileName] = data
+	}
+	return files, nil
+}
+
+func (v *Vectors) files() map[string]interface{} {
+	return map[string]interface{}{
+		KeysFile:       &v.Keys,
+		SignaturesFile: &v.Signatures,
+		MessagesFile:   &v.Messages,
+		WavesFile:      &v.Waves,
+	}
+}
+
+func marshalFile(vectors interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (v *Vectors) key(name string) (*KeyVector, error) {
+	for _, kv := range v.Keys {
+		if kv.Name == name {
+			return kv, nil
+		}
+	}
+	return nil, errKeyVectorNotExist
+}
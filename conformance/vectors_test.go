@@ -0,0 +1,87 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package conformance
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+const testdataDir = "testdata"
+
+func TestGenerate(t *testing.T) {
+	v, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := v.Save(testdataDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := v.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fileName, data := range files {
+		golden, err := ioutil.ReadFile(filepath.Join(testdataDir, fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, golden) {
+			t.Errorf("%s not match golden file, run go test -update if the change is intended", fileName)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	v, err := Load(testdataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := v.Verify()
+	if len(results) != len(v.Keys)+len(v.Signatures)+len(v.Messages)+len(v.Waves) {
+		t.Error("all vectors should be verified", len(results))
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Error(r)
+		}
+	}
+
+	// vectors changed should fail
+	v.Signatures[0].Data = v.Signatures[1].Data
+	v.Messages[0].ID = v.Messages[1].ID
+	v.Waves[0].Encoded = strings.Replace(v.Waves[0].Encoded, "70696e67", "706f6e67", 1)
+	failed := make(map[string]bool)
+	for _, r := range v.Verify() {
+		if r.Err != nil {
+			failed[r.Kind+"/"+r.Name] = true
+		}
+	}
+	for _, name := range []string{"signature/" + v.Signatures[0].Name, "message/" + v.Messages[0].Name, "wave/" + v.Waves[0].Name} {
+		if !failed[name] {
+			t.Error("vector changed should fail", name)
+		}
+	}
+}
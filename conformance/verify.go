@@ -0,0 +1,245 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package conformance
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/galaxy"
+)
+
+var (
+	errKeyNotMatch       = errors.New("key encoding not match")
+	errUserNotMatch      = errors.New("user not match")
+	errSignatureNotMatch = errors.New("signature not match")
+	errSignatureNotValid = errors.New("signature not valid")
+	errMsgNotMatch       = errors.New("msg encoding not match")
+	errWaveNotMatch      = errors.New("wave encoding not match")
+)
+
+// Result is the result of one vector verified, Err is nil if passed
+type Result struct {
+	Kind string
+	Name string
+	Err  error
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("FAIL %s/%s: %v", r.Kind, r.Name, r.Err)
+	}
+	return fmt.Sprintf("ok   %s/%s", r.Kind, r.Name)
+}
+
+// Verify check all vectors by this implementation, keys and msgs are
+// decoded and encoded again, signatures are signed again and verified,
+// waves are received and sent again. The result of each vector is returned
+// in order of files.
+func (v *Vectors) Verify() []*Result {
+	var results []*Result
+	for _, kv := range v.Keys {
+		results = append(results, &Result{Kind: "key", Name: kv.Name, Err: v.verifyKey(kv)})
+	}
+	for _, sv := range v.Signatures {
+		results = append(results, &Result{Kind: "signature", Name: sv.Name, Err: v.verifySignature(sv)})
+	}
+	for _, mv := range v.Messages {
+		results = append(results, &Result{Kind: "message", Name: mv.Name, Err: v.verifyMessage(mv)})
+	}
+	for _, wv := range v.Waves {
+		results = append(results, &Result{Kind: "wave", Name: wv.Name, Err: verifyWave(wv)})
+	}
+	return results
+}
+
+// parseKey decode the key pair of vector, the public key is derived from
+// private key and should be same as the one in vector
+func parseKey(kv *KeyVector) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	priKey, pubKey, err := utils.ParsePrivateKey(kv.PrivateKey, kv.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	derived, err := publicKey(priKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	priKeyBytes, derivedBytes, err := engine.Marshal(priKey, derived)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !jsonEqual(priKeyBytes, kv.PrivateKey) || !jsonEqual(derivedBytes, kv.PublicKey) {
+		return nil, nil, errKeyNotMatch
+	}
+	return priKey, pubKey, nil
+}
+
+func (v *Vectors) verifyKey(kv *KeyVector) error {
+	_, pubKey, err := parseKey(kv)
+	if err != nil {
+		return err
+	}
+	var user core.User
+	if err := json.Unmarshal(kv.User, &user); err != nil {
+		return err
+	}
+	root := core.CreateRootUser(*pubKey, user.Name, user.BirthExtra)
+	rootBytes, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	if !jsonEqual(rootBytes, kv.User) || common.Hash2String(user.ID()) != kv.UserID || common.Hash2String(root.ID()) != kv.UserID {
+		return errUserNotMatch
+	}
+	return nil
+}
+
+func (v *Vectors) verifySignature(sv *SignatureVector) error {
+	kv, err := v.key(sv.Key)
+	if err != nil {
+		return err
+	}
+	priKey, pubKey, err := parseKey(kv)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(sv.Data)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(sv.Signature)
+	if err != nil {
+		return err
+	}
+	if err := verifySig(data, signature, pubKey); err != nil {
+		return err
+	}
+	// signatures are deterministic, so same signature is created again
+	if resigned, err := signData(priKey, data); err != nil {
+		return err
+	} else if !bytes.Equal(resigned, signature) {
+		return errSignatureNotMatch
+	}
+	return nil
+}
+
+func verifySig(data, signature []byte, pubKey *crypto.PublicKey) error {
+	engine, err := utils.SelectEngine(pubKey.Source)
+	if err != nil {
+		return err
+	}
+	ok, err := engine.Verify(data, &crypto.Signature{PublicKey: *pubKey, Signature: signature})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errSignatureNotValid
+	}
+	return nil
+}
+
+func (v *Vectors) verifyMessage(mv *MessageVector) error {
+	kv, err := v.key(mv.Key)
+	if err != nil {
+		return err
+	}
+	priKey, pubKey, err := parseKey(kv)
+	if err != nil {
+		return err
+	}
+	var msg core.Message
+	if err := json.Unmarshal(mv.Message, &msg); err != nil {
+		return err
+	}
+	msgBytes, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	signed, err := msg.SignedBytes()
+	if err != nil {
+		return err
+	}
+	if !jsonEqual(msgBytes, mv.Message) || hex.EncodeToString(signed) != mv.SignedBytes || common.Hash2String(msg.ID()) != mv.ID {
+		return errMsgNotMatch
+	}
+	if msg.Signature == nil {
+		return errSignatureNotValid
+	}
+	if err := verifySig(signed, msg.Signature.Signature, pubKey); err != nil {
+		return err
+	}
+	signature := msg.Signature.Signature
+	if err := msg.Sign(priKey); err != nil {
+		return err
+	}
+	if !bytes.Equal(msg.Signature.Signature, signature) {
+		return errSignatureNotMatch
+	}
+	return nil
+}
+
+func verifyWave(wv *WaveVector) error {
+	encoded, err := hex.DecodeString(wv.Encoded)
+	if err != nil {
+		return err
+	}
+	wave, err := galaxy.ReceiveWave(bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	if wave.Command() != wv.Command {
+		return errWaveNotMatch
+	}
+	sent, err := encodeWave(wave)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sent, encoded) {
+		return errWaveNotMatch
+	}
+	return nil
+}
+
+// encodeWave return the bytes of wave sent to peer
+func encodeWave(wave galaxy.Wave) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := galaxy.SendWave(&buf, wave); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonEqual return true if a and b are same after compacted, so the json
+// indented in files is same as the one marshaled
+func jsonEqual(a, b []byte) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return false
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
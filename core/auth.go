@@ -17,7 +17,7 @@
 package core
 
 import (
-	"encoding/json"
+	"bytes"
 
 	"github.com/pdupub/go-pdu/crypto"
 	"github.com/pdupub/go-pdu/crypto/utils"
@@ -30,18 +30,7 @@ type Auth struct {
 
 // UnmarshalJSON is used to unmarshal json
 func (a *Auth) UnmarshalJSON(input []byte) error {
-	aMap := make(map[string]interface{})
-	err := json.Unmarshal(input, &aMap)
-	if err != nil {
-		return err
-	}
-	a.Source = aMap["source"].(string)
-	a.SigType = aMap["sigType"].(string)
-	engine, err := utils.SelectEngine(a.Source)
-	if err != nil {
-		return err
-	}
-	_, pk, err := engine.Unmarshal(nil, input)
+	pk, err := utils.ParsePublicKeyJSON(input)
 	if err != nil {
 		return err
 	}
@@ -58,3 +47,21 @@ func (a Auth) MarshalJSON() ([]byte, error) {
 	_, pkBytes, err := engine.Marshal(nil, &a.PublicKey)
 	return pkBytes, err
 }
+
+// Equal return true if the public keys are same, the keys are compared by
+// the JSON marshalled by engine, so the keys parsed from different forms
+// are compared correctly
+func (a Auth) Equal(other Auth) bool {
+	if a.Source != other.Source || a.SigType != other.SigType {
+		return false
+	}
+	aBytes, err := a.MarshalJSON()
+	if err != nil {
+		return false
+	}
+	otherBytes, err := other.MarshalJSON()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, otherBytes)
+}
@@ -0,0 +1,450 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"testing"
+
+	dag "github.com/pdupub/go-dag"
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// benchScales are the number of msgs already in universe (or vertices in
+// DAG) when the hot paths are measured, 1M is skipped in short mode.
+var benchScales = []int{10000, 100000, 1000000}
+
+// benchTPInterval is the number of msgs between two time proof msgs in
+// the universe built for benchmarks
+const benchTPInterval = 8
+
+// benchVertexID build a deterministic vertex id, same type as msg.ID()
+func benchVertexID(i int) common.Hash {
+	var h common.Hash
+	binary.BigEndian.PutUint64(h[common.HashLength-8:], uint64(i)+1)
+	return h
+}
+
+// benchMsgs build n sealed msgs like msgD, each msg reference the previous
+// msg and the msg refCnt steps before, same as a message reference the last
+// message of sender and one message of time proof. The first msg has no
+// reference. The msgs are built before measured, so only the storage counts.
+func benchMsgs(n int, refCnt int) []*Message {
+	hasher := common.DefaultHasher()
+	msgs := make([]*Message, n)
+	for i := range msgs {
+		msg := &Message{id: benchVertexID(i), hasher: hasher}
+		if i > 0 {
+			msg.Reference = append(msg.Reference, &MsgReference{MsgID: benchVertexID(i - 1)})
+		}
+		if i > refCnt {
+			msg.Reference = append(msg.Reference, &MsgReference{MsgID: benchVertexID(i - refCnt)})
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+// benchStorage is the vertex storage of msgs measured by benchmarks, the
+// msgDAG used by universe and the DAG of vertices with maps of interface{}
+// used before, to compare with.
+type benchStorage struct {
+	name string
+	new  func(root *Message) (benchStore, error)
+}
+
+// benchStore is the storage built by benchStorage
+type benchStore interface {
+	add(msg *Message) error
+	get(id common.Hash) *Message
+}
+
+// benchDAG wrap the DAG as benchStore
+type benchDAG struct{ *dag.DAG }
+
+func (d benchDAG) add(msg *Message) error {
+	var refs []interface{}
+	for _, r := range msg.Reference {
+		refs = append(refs, r.MsgID)
+	}
+	v, err := dag.NewVertex(msg.ID(), msg, refs...)
+	if err != nil {
+		return err
+	}
+	return d.AddVertex(v)
+}
+
+func (d benchDAG) get(id common.Hash) *Message {
+	if v := d.GetVertex(id); v != nil {
+		return v.Value().(*Message)
+	}
+	return nil
+}
+
+var benchStorages = []benchStorage{
+	{"msgDAG", func(root *Message) (benchStore, error) { return newMsgDAG(root), nil }},
+	{"dag", func(root *Message) (benchStore, error) {
+		v, err := dag.NewVertex(root.ID(), root)
+		if err != nil {
+			return nil, err
+		}
+		d, err := dag.NewDAG(1, v)
+		if err != nil {
+			return nil, err
+		}
+		d.RemoveStrict()
+		return benchDAG{d}, nil
+	}},
+}
+
+// buildBenchStore add all msgs into the storage, msgs[0] is the root
+func buildBenchStore(s benchStorage, msgs []*Message) (benchStore, error) {
+	d, err := s.new(msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs[1:] {
+		if err := d.add(msg); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// runBenchStorages run the benchmark on each storage as sub benchmark
+func runBenchStorages(b *testing.B, f func(b *testing.B, s benchStorage)) {
+	for _, s := range benchStorages {
+		s := s
+		b.Run(s.name, func(b *testing.B) { f(b, s) })
+	}
+}
+
+// runBenchScales run the benchmark on each scale as sub benchmark
+func runBenchScales(b *testing.B, f func(b *testing.B, size int)) {
+	for _, size := range benchScales {
+		size := size
+		name := fmt.Sprintf("%dk", size/1000)
+		if size >= 1000000 {
+			name = fmt.Sprintf("%dM", size/1000000)
+		}
+		b.Run(name, func(b *testing.B) {
+			if testing.Short() && size >= 1000000 {
+				b.Skip("skip 1M scale in short mode")
+			}
+			f(b, size)
+		})
+	}
+}
+
+// benchUniverse is the universe with msgs for benchmarks. Eve post time
+// proof every benchTPInterval msgs, Adam post the others. All msgs refer
+// the msg before, so the msgD is a chain with time proofs on it.
+type benchUniverse struct {
+	u               *Universe
+	eve, adam       *User
+	eveKey, adamKey *crypto.PrivateKey
+	last, lastTP    *MsgReference
+	cnt             int
+}
+
+// newBenchUniverse create the universe with size msgs, the msgs are not
+// signed and committed without verifiers, so large universe can be built
+// in seconds. Only the msgs measured need to be signed.
+func newBenchUniverse(size int) (*benchUniverse, error) {
+	engine, err := utils.SelectEngine(crypto.ETH)
+	if err != nil {
+		return nil, err
+	}
+	bu := &benchUniverse{}
+	for bu.adam == nil || bu.eve == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if user := CreateRootUser(*pubKey, "name", "extra"); user.Gender() {
+			bu.adam, bu.adamKey = user, priKey
+		} else {
+			bu.eve, bu.eveKey = user, priKey
+		}
+	}
+	if bu.u, err = NewUniverse(bu.eve, bu.adam); err != nil {
+		return nil, err
+	}
+	for i := 0; i < size; i++ {
+		msg := bu.nextMsg()
+		if err := bu.u.Commit(&Receipt{MsgID: msg.ID(), msg: msg}); err != nil {
+			return nil, err
+		}
+		bu.committed(msg)
+	}
+	return bu, nil
+}
+
+// nextMsg create the next sealed msg not signed, the first msg and every
+// benchTPInterval msgs are time proof by Eve
+func (bu *benchUniverse) nextMsg() *Message {
+	value := &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("msg:%d", bu.cnt))}
+	bu.cnt++
+	var msg *Message
+	if bu.last == nil {
+		msg = newMsg(bu.eve, value)
+	} else if bu.cnt%benchTPInterval == 0 {
+		if bu.lastTP.MsgID == bu.last.MsgID {
+			msg = newMsg(bu.eve, value, bu.lastTP)
+		} else {
+			msg = newMsg(bu.eve, value, bu.lastTP, bu.last)
+		}
+	} else {
+		msg = newMsg(bu.adam, value, bu.last)
+	}
+	msg.Seal()
+	return msg
+}
+
+// nextSignedMsgs create n msgs after last committed msg, signed by sender.
+// The msgs refer the msg before, so should be committed in order.
+func (bu *benchUniverse) nextSignedMsgs(n int) ([]*Message, error) {
+	last, lastTP, cnt := bu.last, bu.lastTP, bu.cnt
+	defer func() { bu.last, bu.lastTP, bu.cnt = last, lastTP, cnt }()
+	msgs := make([]*Message, n)
+	for i := range msgs {
+		msg := bu.nextMsg()
+		priKey := bu.adamKey
+		if msg.SenderID == bu.eve.ID() {
+			priKey = bu.eveKey
+		}
+		if err := msg.sign(priKey); err != nil {
+			return nil, err
+		}
+		msgs[i] = msg
+		bu.committed(msg)
+	}
+	return msgs, nil
+}
+
+// committed move the last msg (and last time proof) to msg
+func (bu *benchUniverse) committed(msg *Message) {
+	bu.last = &MsgReference{SenderID: msg.SenderID, MsgID: msg.ID()}
+	if msg.SenderID == bu.eve.ID() {
+		bu.lastTP = bu.last
+	}
+}
+
+// BenchmarkMsgD_AddVertex measure the insert throughput of vertex storage
+func BenchmarkMsgD_AddVertex(b *testing.B) {
+	runBenchStorages(b, func(b *testing.B, s benchStorage) {
+		msgs := benchMsgs(b.N+1, 8)
+		b.ReportAllocs()
+		b.ResetTimer()
+		if _, err := buildBenchStore(s, msgs); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+// BenchmarkMsgD_GetVertex measure the lookup of vertex by msg id
+func BenchmarkMsgD_GetVertex(b *testing.B) {
+	const size = 1 << 14
+	runBenchStorages(b, func(b *testing.B, s benchStorage) {
+		d, err := buildBenchStore(s, benchMsgs(size, 8))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if d.get(benchVertexID(i%size)) == nil {
+				b.Fatal("vertex not found")
+			}
+		}
+	})
+}
+
+// BenchmarkMsgD_MemoryPerVertex report the heap used by each vertex in the
+// storage, the msgs themselves are not counted
+func BenchmarkMsgD_MemoryPerVertex(b *testing.B) {
+	runBenchStorages(b, func(b *testing.B, s benchStorage) {
+		for _, size := range []int{1 << 10, 1 << 14, 1 << 17} {
+			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+				msgs := benchMsgs(size, 8)
+				var used int64
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var before, after runtime.MemStats
+					runtime.GC()
+					runtime.ReadMemStats(&before)
+					d, err := buildBenchStore(s, msgs)
+					if err != nil {
+						b.Fatal(err)
+					}
+					runtime.GC()
+					runtime.ReadMemStats(&after)
+					used += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+					runtime.KeepAlive(d)
+				}
+				b.ReportMetric(float64(used)/float64(b.N*size), "bytes/vertex")
+			})
+		}
+	})
+}
+
+// BenchmarkMsgD_AddVertexAtScale measure the insert of vertex into large storage
+func BenchmarkMsgD_AddVertexAtScale(b *testing.B) {
+	runBenchStorages(b, func(b *testing.B, s benchStorage) {
+		runBenchScales(b, func(b *testing.B, size int) {
+			msgs := benchMsgs(size+b.N, 8)
+			d, err := buildBenchStore(s, msgs[:size])
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for _, msg := range msgs[size:] {
+				if err := d.add(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkUniverse_AddMsg measure the whole pipeline of msg, include
+// signature verify, validators, DAG insertion, time proof and handler
+func BenchmarkUniverse_AddMsg(b *testing.B) {
+	runBenchScales(b, func(b *testing.B, size int) {
+		bu, err := newBenchUniverse(size)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msgs, err := bu.nextSignedMsgs(b.N)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for _, msg := range msgs {
+			if err := bu.u.AddMsg(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUniverse_Validate measure the verifiers of msg, most of the time
+// is signature verify
+func BenchmarkUniverse_Validate(b *testing.B) {
+	runBenchScales(b, func(b *testing.B, size int) {
+		bu, err := newBenchUniverse(size)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msgs, err := bu.nextSignedMsgs(1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := bu.u.Validate(msgs[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUniverse_UpdateTimeProof measure the time proof update of the
+// space-time of sender, the msgs are not added into msgD
+func BenchmarkUniverse_UpdateTimeProof(b *testing.B) {
+	runBenchScales(b, func(b *testing.B, size int) {
+		bu, err := newBenchUniverse(size)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msgs := make([]*Message, b.N)
+		for i := range msgs {
+			value := &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("tp:%d", i))}
+			msgs[i] = newMsg(bu.eve, value, bu.lastTP)
+			msgs[i].Seal()
+			bu.committed(msgs[i])
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for _, msg := range msgs {
+			if err := bu.u.updateTimeProof(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUniverse_Rollback measure the rollback to the checkpoint of the
+// time proof before last one, only a few msgs are unwound but all msgs before
+// checkpoint are replayed
+func BenchmarkUniverse_Rollback(b *testing.B) {
+	runBenchScales(b, func(b *testing.B, size int) {
+		bu, err := newBenchUniverse(size)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cp, err := bu.u.CreateCheckpoint(bu.eve.ID(), bu.u.GetMaxSeq(bu.eve.ID())-1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := bu.u.Rollback(cp, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(len(bu.u.msgD.msgIDs())), "msgs/op")
+	})
+}
+
+// BenchmarkVerifyMsg measure the signature verify of msg by each engine,
+// which not depend on the size of universe
+func BenchmarkVerifyMsg(b *testing.B) {
+	for _, source := range []string{crypto.ETH, crypto.BTC, crypto.PDU} {
+		b.Run(source, func(b *testing.B) {
+			engine, err := utils.SelectEngine(source)
+			if err != nil {
+				b.Fatal(err)
+			}
+			priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+			if err != nil {
+				b.Fatal(err)
+			}
+			user := CreateRootUser(*pubKey, "name", "extra")
+			msg, err := CreateMsg(user, &MsgValue{ContentType: TypeText, Content: []byte("verify")}, priKey)
+			if err != nil {
+				b.Fatal(err)
+			}
+			msg.Signature.PubKey = user.Auth.PubKey
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if ok, err := VerifyMsg(*msg); err != nil || !ok {
+					b.Fatal("verify msg fail", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/bls"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// Checkpoint is the record of space-time at one time proof sequence. Two nodes
+// with same checkpoint agree with each other on this space-time up to the sequence.
+type Checkpoint struct {
+	SpaceTimeID common.Hash       `json:"spacetimeID"`
+	Seq         uint64            `json:"seq"`
+	MsgID       common.Hash       `json:"msgID"`
+	StateRoot   common.Hash       `json:"stateRoot"`
+	SignerID    common.Hash       `json:"signerID"`
+	Signature   *crypto.Signature `json:"signature"`
+}
+
+// CreateCheckpoint create the checkpoint of space-time at the sequence, not signed yet
+func (u Universe) CreateCheckpoint(spacetimeID common.Hash, seq uint64) (*Checkpoint, error) {
+	st, err := u.getSpaceTime(spacetimeID)
+	if err != nil {
+		return nil, err
+	}
+	msgID, ok := st.GetMsgIDBySeq(seq)
+	if !ok {
+		return nil, ErrSeqNotFound
+	}
+	return &Checkpoint{
+		SpaceTimeID: spacetimeID,
+		Seq:         seq,
+		MsgID:       msgID,
+		StateRoot:   u.stateRoot(st, spacetimeID, seq, msgID),
+	}, nil
+}
+
+// StateRoot return the state root of space-time at the sequence
+func (u Universe) StateRoot(spacetimeID common.Hash, seq uint64) (common.Hash, error) {
+	cp, err := u.CreateCheckpoint(spacetimeID, seq)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return cp.StateRoot, nil
+}
+
+// stateRoot is the Merkle root of the header, the users born in this space-time
+// before the sequence and the msgs reached by the time proof msg by references,
+// see InclusionProof for the structure.
+func (u Universe) stateRoot(st *SpaceTime, spacetimeID common.Hash, seq uint64, msgID common.Hash) common.Hash {
+	return u.newStateTree(st, spacetimeID, seq, msgID).root()
+}
+
+// Sign the checkpoint by user
+func (cp *Checkpoint) Sign(user *User, priKey *crypto.PrivateKey) error {
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return err
+	}
+	cp.SignerID = user.ID()
+	cp.Signature = nil
+	jsonCP, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	sig, err := engine.Sign(jsonCP, priKey)
+	if err != nil {
+		return err
+	}
+	sig.PubKey = nil
+	cp.Signature = sig
+	return nil
+}
+
+// VerifyCheckpoint verify the signature of checkpoint by the signer
+func VerifyCheckpoint(cp Checkpoint, signer *User) (bool, error) {
+	if cp.Signature == nil {
+		return false, ErrCheckpointNotSigned
+	}
+	if cp.SignerID != signer.ID() {
+		return false, nil
+	}
+	signature := *cp.Signature
+	signature.PubKey = signer.Auth.PubKey
+	cp.Signature = nil
+	engine, err := utils.SelectEngine(signature.Source)
+	if err != nil {
+		return false, err
+	}
+	jsonCP, err := json.Marshal(&cp)
+	if err != nil {
+		return false, err
+	}
+	return engine.Verify(jsonCP, &signature)
+}
+
+// AggregateCheckpoints aggregate the signatures of checkpoints signed by
+// different users into one signature, all checkpoints must be signed by BLS.
+// cps[i] should be signed by signers[i], the public keys of signers are
+// required to weight the signatures.
+func AggregateCheckpoints(cps []Checkpoint, signers []*User) (*crypto.Signature, error) {
+	if len(cps) != len(signers) {
+		return nil, ErrSignerNotMatch
+	}
+	var sigs []*crypto.Signature
+	for i, cp := range cps {
+		if cp.Signature == nil {
+			return nil, ErrCheckpointNotSigned
+		}
+		sig := *cp.Signature
+		sig.PubKey = signers[i].Auth.PubKey
+		sigs = append(sigs, &sig)
+	}
+	sig, err := bls.Aggregate(sigs...)
+	if err != nil {
+		return nil, err
+	}
+	sig.PubKey = nil
+	return sig, nil
+}
+
+// VerifyCheckpoints verify the signature aggregated from checkpoints by one
+// pairing check, cps[i] should be signed by signers[i].
+func VerifyCheckpoints(cps []Checkpoint, signers []*User, sig *crypto.Signature) (bool, error) {
+	if len(cps) != len(signers) {
+		return false, ErrSignerNotMatch
+	}
+	var hashes [][]byte
+	var pubKeys []interface{}
+	for i, cp := range cps {
+		if cp.SignerID != signers[i].ID() {
+			return false, nil
+		}
+		cp.Signature = nil
+		jsonCP, err := json.Marshal(&cp)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, jsonCP)
+		pubKeys = append(pubKeys, signers[i].Auth.PubKey)
+	}
+	signature := *sig
+	signature.PubKey = pubKeys
+	return bls.VerifyAggregate(hashes, &signature)
+}
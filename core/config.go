@@ -0,0 +1,91 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+const (
+	// DefaultBloomCapacity is the default expected number of msgs in bloom filter
+	DefaultBloomCapacity = 1000000
+
+	// DefaultBloomFPRate is the default false positive rate of bloom filter
+	DefaultBloomFPRate = 0.01
+
+	// DefaultMaxTimestampDrift is the default seconds of msg timestamp hint can be
+	// later than the clock of local node
+	DefaultMaxTimestampDrift = 600
+)
+
+// UniverseConfig contain the validation rules of universe, which are not nature
+// rules (see core/rule), so different universe can choose different setting.
+type UniverseConfig struct {
+	// SelfRefRequired means each msg from user, except the first one, must
+	// reference the last msg of this user in the universe. Then the msgs from
+	// one user are in a single line, no fork.
+	SelfRefRequired bool `json:"selfRefRequired"`
+
+	// SearchEnable means the content of msgs will be indexed when msgs be
+	// added into universe, so msgs can be found by Universe.Search.
+	SearchEnable bool `json:"searchEnable"`
+
+	// BloomCapacity is the expected number of msgs in bloom filter, which is
+	// used to check if msg not exist quickly. Bloom filter disabled if 0.
+	BloomCapacity uint64 `json:"bloomCapacity"`
+
+	// BloomFPRate is the expected false positive rate of bloom filter when
+	// the number of msgs reach BloomCapacity.
+	BloomFPRate float64 `json:"bloomFPRate"`
+
+	// Hasher is the name of hash function used to calculate the ID of msgs
+	// and users (sha256, keccak256 or blake3), SHA-256 is used if empty.
+	Hasher string `json:"hasher"`
+
+	// MaxTimestampDrift is the max seconds of msg timestamp hint can be later
+	// than the clock of local node, not check if 0.
+	MaxTimestampDrift uint64 `json:"maxTimestampDrift"`
+
+	// PoWDifficulty is the leading zero bits of PoW hash required by content
+	// type, as spam cost for open deployments. Not required if type not set.
+	PoWDifficulty map[int]uint8 `json:"powDifficulty,omitempty"`
+
+	// NetworkID is the network of universe (NetworkMain, NetworkTest or
+	// NetworkDev), only msgs created on same network are accepted.
+	NetworkID uint64 `json:"networkID,omitempty"`
+
+	// ConsentWindow is the max sequence steps between the time proof msg
+	// referenced by parent when signing the birth consent and the birth msg,
+	// so old consent can not be reused forever. The signatures of parents
+	// are not checked if 0.
+	ConsentWindow uint64 `json:"consentWindow,omitempty"`
+}
+
+// DefaultUniverseConfig return the config used by NewUniverse
+func DefaultUniverseConfig() *UniverseConfig {
+	return &UniverseConfig{
+		SelfRefRequired:   false,
+		SearchEnable:      false,
+		BloomCapacity:     DefaultBloomCapacity,
+		BloomFPRate:       DefaultBloomFPRate,
+		Hasher:            common.HasherSHA256,
+		MaxTimestampDrift: DefaultMaxTimestampDrift,
+	}
+}
+
+// Difficulty return the PoW difficulty required for msg of content type
+func (c UniverseConfig) Difficulty(contentType int) uint8 {
+	return c.PoWDifficulty[contentType]
+}
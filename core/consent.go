@@ -0,0 +1,174 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// ContentConsentRevoke is the content of TypeConsentRevoke msg, the sender
+// revoke the consent signed as parent, before the birth msg be included.
+type ContentConsentRevoke struct {
+	ConsentID common.Hash `json:"consentID"`
+}
+
+// CreateContentConsentRevoke create the content to revoke the consent of birth
+func CreateContentConsentRevoke(cb *ContentBirth) *ContentConsentRevoke {
+	return &ContentConsentRevoke{ConsentID: cb.ConsentID()}
+}
+
+// ConsentID is the ID of user in birth content before born, which is signed
+// by parents, used to revoke the consent.
+func (mv ContentBirth) ConsentID() common.Hash {
+	return mv.User.ID()
+}
+
+// consentBytes return the bytes signed by parent. If time proof msg ref is
+// set, the digest of user and ref is signed, because some engines only sign
+// the leading 32 bytes of data.
+func (mv ContentBirth) consentBytes(ref *MsgReference) ([]byte, error) {
+	if ref == nil {
+		return json.Marshal(mv.User)
+	}
+	jsonBytes, err := json.Marshal(struct {
+		User User
+		Ref  *MsgReference
+	}{mv.User, ref})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(jsonBytes)
+	return digest[:], nil
+}
+
+// IsConsentRevoked return true if parent revoked the consent
+func (u Universe) IsConsentRevoked(consentID common.Hash, parentID common.Hash) bool {
+	return u.revoked[consentID][parentID]
+}
+
+// validateConsent check the consent of parents in birth msg is not revoked. If
+// consent window is set, the signatures of parents are verified, and the birth
+// msg should be within the window from the time proof msg signed by each parent.
+func validateConsent(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeBirth {
+		return nil
+	}
+	var cb ContentBirth
+	if err := json.Unmarshal(msg.Value.Content, &cb); err != nil {
+		return err
+	}
+	consentID := cb.ConsentID()
+	for _, p := range cb.Parents {
+		if u.IsConsentRevoked(consentID, p.UserID) {
+			return ErrConsentRevoked
+		}
+	}
+	if u.config.ConsentWindow == 0 {
+		return nil
+	}
+	memo := make(map[common.Hash]map[common.Hash]uint64)
+	for _, p := range cb.Parents {
+		if p.Ref == nil {
+			return ErrConsentNotValid
+		}
+		if err := cb.verifyParent(u, p); err != nil {
+			return err
+		}
+		st, err := u.getSpaceTime(p.Ref.SenderID)
+		if err != nil {
+			return ErrConsentNotValid
+		}
+		signedSeq := st.GetSeq(p.Ref.MsgID)
+		if signedSeq == 0 {
+			return ErrConsentNotValid
+		}
+		if memo[p.Ref.SenderID] == nil {
+			memo[p.Ref.SenderID] = make(map[common.Hash]uint64)
+		}
+		// the birth msg not in universe yet, seen by its references
+		var seq uint64
+		for _, r := range msg.Reference {
+			if s := u.seenSeq(r.MsgID, st, memo[p.Ref.SenderID]); s > seq {
+				seq = s
+			}
+		}
+		if seq > signedSeq+u.config.ConsentWindow {
+			return ErrConsentExpired
+		}
+	}
+	return nil
+}
+
+// verifyParent verify the signature of parent on consent
+func (mv ContentBirth) verifyParent(u *Universe, p ParentSig) error {
+	parent := u.GetUserByID(p.UserID)
+	if parent == nil {
+		return ErrUserNotExist
+	}
+	return mv.verifyParentSig(parent, p)
+}
+
+// verifyParentSig verify the signature of parent user on consent, the
+// parent may be not in universe, such as in identity bundle
+func (mv ContentBirth) verifyParentSig(parent *User, p ParentSig) error {
+	engine, err := utils.SelectEngine(parent.Auth.Source)
+	if err != nil {
+		return err
+	}
+	consent, err := mv.consentBytes(p.Ref)
+	if err != nil {
+		return err
+	}
+	signature := &crypto.Signature{PublicKey: parent.Auth.PublicKey, Signature: p.Signature}
+	if ok, err := engine.Verify(consent, signature); err != nil || !ok {
+		return ErrConsentNotValid
+	}
+	return nil
+}
+
+// validateConsentRevoke check the birth of consent not be included yet
+func validateConsentRevoke(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeConsentRevoke {
+		return nil
+	}
+	var content ContentConsentRevoke
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	if u.born[content.ConsentID] {
+		return ErrConsentRevokeNotValid
+	}
+	return nil
+}
+
+// handleConsentRevoke record the consent revoked by sender
+func handleConsentRevoke(u *Universe, msg *Message) error {
+	var content ContentConsentRevoke
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	if u.revoked[content.ConsentID] == nil {
+		u.revoked[content.ConsentID] = make(map[common.Hash]bool)
+	}
+	u.revoked[content.ConsentID][msg.SenderID] = true
+	return nil
+}
@@ -17,6 +17,9 @@
 package core
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 
 	"github.com/pdupub/go-pdu/common"
@@ -34,6 +37,28 @@ type ContentBirth struct {
 type ParentSig struct {
 	UserID    common.Hash
 	Signature []byte
+	Ref       *MsgReference `json:",omitempty"` // time proof msg seen by parent when signing, see SignByParentAt
+}
+
+// UnmarshalJSON decode the birth content, the user is required and the
+// signatures of parents are validated by schema
+func (cb *ContentBirth) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		User    *User
+		Parents [2]ParentSig
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.User == nil {
+		return schemaError("ContentBirth", "User", "is required")
+	}
+	c := ContentBirth{User: *raw.User, Parents: raw.Parents}
+	if err := c.validateSchema(); err != nil {
+		return err
+	}
+	*cb = c
+	return nil
 }
 
 // CreateContentBirth create the birth msg content , which usually from the new user, not sign by parents yet
@@ -43,10 +68,129 @@ func CreateContentBirth(name string, extra string, auth *Auth) (*ContentBirth, e
 
 }
 
+// BirthDisclosure is the name and extra of user hidden in birth msg, which is
+// kept by the user, and can be revealed later by TypeBirthReveal msg. The salt
+// is random, so the commitment can not be guessed from common names.
+type BirthDisclosure struct {
+	Name  string `json:"name"`
+	Extra string `json:"extra"`
+	Salt  []byte `json:"salt"`
+}
+
+// BirthSaltSize is the size of salt in BirthDisclosure
+const BirthSaltSize = 32
+
+// CreateHiddenContentBirth create the birth msg content as CreateContentBirth,
+// but only the commitment of name and extra is public in birth msg. The
+// disclosure returned should be kept by the new user to reveal them later.
+func CreateHiddenContentBirth(name string, extra string, auth *Auth) (*ContentBirth, *BirthDisclosure, error) {
+	salt := make([]byte, BirthSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	disclosure := &BirthDisclosure{Name: name, Extra: extra, Salt: salt}
+	commitment, err := disclosure.Commitment()
+	if err != nil {
+		return nil, nil, err
+	}
+	user := User{Auth: auth, Commitment: commitment}
+	return &ContentBirth{User: user}, disclosure, nil
+}
+
+// Commitment return the sha256 of disclosure, which is kept in User
+func (d BirthDisclosure) Commitment() ([]byte, error) {
+	jsonBytes, err := json.Marshal(&d)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(jsonBytes)
+	return hash[:], nil
+}
+
+// GetBirthDisclosure return the name and extra revealed by the hidden user,
+// nil if user is not hidden or not revealed yet
+func (u Universe) GetBirthDisclosure(userID common.Hash) *BirthDisclosure {
+	if d, ok := u.disclosures[userID]; ok {
+		disclosure := *d
+		return &disclosure
+	}
+	return nil
+}
+
+// validateBirth check the new user not exist yet, and can be added into at
+// least one space-time referenced by the nature rule of parents
+func validateBirth(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeBirth {
+		return nil
+	}
+	user, err := CreateNewUser(u, msg)
+	if err != nil {
+		return err
+	}
+	if u.GetUserByID(user.ID()) != nil {
+		return ErrUserAlreadyExist
+	}
+	var contentBirth ContentBirth
+	if err := json.Unmarshal(msg.Value.Content, &contentBirth); err != nil {
+		return err
+	}
+	for _, ref := range msg.Reference {
+		if vertex := u.stD.GetVertex(ref.SenderID); vertex != nil {
+			if _, _, _, err := vertex.Value().(*SpaceTime).birthParents(ref, contentBirth); err == nil {
+				return nil
+			}
+		}
+	}
+	return ErrNewUserAddFail
+}
+
+// validateBirthReveal check the reveal is sent by the hidden user self, and
+// the disclosure match the commitment in birth msg, each user reveal once.
+func validateBirthReveal(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeBirthReveal {
+		return nil
+	}
+	var d BirthDisclosure
+	if err := json.Unmarshal(msg.Value.Content, &d); err != nil {
+		return err
+	}
+	user := u.GetUserByID(msg.SenderID)
+	if user == nil {
+		return ErrUserNotExist
+	}
+	if !user.Hidden() || u.disclosures[msg.SenderID] != nil {
+		return ErrBirthRevealNotValid
+	}
+	commitment, err := d.Commitment()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(commitment, user.Commitment) {
+		return ErrBirthRevealNotValid
+	}
+	return nil
+}
+
+// handleBirthReveal keep the name and extra revealed by sender
+func handleBirthReveal(u *Universe, msg *Message) error {
+	var d BirthDisclosure
+	if err := json.Unmarshal(msg.Value.Content, &d); err != nil {
+		return err
+	}
+	u.disclosures[msg.SenderID] = &d
+	return nil
+}
+
 // SignByParent used to sign the birth msg by both parents
 func (mv *ContentBirth) SignByParent(user *User, privKey crypto.PrivateKey) error {
+	return mv.SignByParentAt(user, privKey, nil)
+}
 
-	jsonByte, err := json.Marshal(mv.User)
+// SignByParentAt sign the birth msg by parent as SignByParent, the time proof
+// msg ref is signed together, so the consent expire after the sequence of ref
+// goes beyond the consent window of universe.
+func (mv *ContentBirth) SignByParentAt(user *User, privKey crypto.PrivateKey, ref *MsgReference) error {
+	jsonByte, err := mv.consentBytes(ref)
 	if err != nil {
 		return err
 	}
@@ -62,9 +206,9 @@ func (mv *ContentBirth) SignByParent(user *User, privKey crypto.PrivateKey) erro
 	}
 
 	if user.Gender() {
-		mv.Parents[1] = ParentSig{UserID: user.ID(), Signature: signature.Signature}
+		mv.Parents[1] = ParentSig{UserID: user.ID(), Signature: signature.Signature, Ref: ref}
 	} else {
-		mv.Parents[0] = ParentSig{UserID: user.ID(), Signature: signature.Signature}
+		mv.Parents[0] = ParentSig{UserID: user.ID(), Signature: signature.Signature, Ref: ref}
 	}
 	return nil
 }
@@ -0,0 +1,188 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// ContentDeviceAuth is the content of TypeDeviceAuth msg, the master key of
+// sender authorize the device key to sign msgs of sender, so the master key
+// need not be copied to each device. The latest authorization of same device
+// replace the previous one.
+type ContentDeviceAuth struct {
+	Device Auth   `json:"device"`
+	Scopes []int  `json:"scopes,omitempty"` // content types device can sign, all but masterOnlyTypes if empty
+	Expiry uint64 `json:"expiry,omitempty"` // sequence distance of primary space-time authorization expire after, 0 never
+}
+
+// masterOnlyTypes are the content types can not be signed by device, which
+// change the identity of sender or its devices
+var masterOnlyTypes = map[int]bool{
+	TypeBirth:           true,
+	TypeUserStateUpdate: true,
+	TypeBirthReveal:     true,
+	TypeConsentRevoke:   true,
+	TypeDeviceAuth:      true,
+}
+
+// deviceAuth is the authorization of device in universe
+type deviceAuth struct {
+	msgID   common.Hash
+	content *ContentDeviceAuth
+}
+
+// CreateContentDeviceAuth create the content to authorize the device key
+func CreateContentDeviceAuth(device *crypto.PublicKey, expiry uint64, scopes ...int) *ContentDeviceAuth {
+	return &ContentDeviceAuth{Device: Auth{PublicKey: *device}, Scopes: scopes, Expiry: expiry}
+}
+
+// CreateDeviceMsg create msg with PoW as CreateMsgOnNetwork, but signed by the
+// device key authorized by TypeDeviceAuth msg of user, instead of the master
+// key. The public key of device is carried in msg.
+func CreateDeviceMsg(network uint64, user *User, device *crypto.PublicKey, value *MsgValue, priKey *crypto.PrivateKey, difficulty uint8, refs ...*MsgReference) (*Message, error) {
+	if difficulty > MaxPoWDifficulty {
+		return nil, ErrPoWDifficultyTooHigh
+	}
+	msg := newMsg(user, value, refs...)
+	msg.Network = network
+	msg.Device = &Auth{PublicKey: *device}
+	if err := msg.grindAndSign(priKey, difficulty); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// inScopes return true if device can sign the content type
+func (c ContentDeviceAuth) inScopes(contentType int) bool {
+	if masterOnlyTypes[contentType] {
+		return false
+	}
+	if len(c.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range c.Scopes {
+		if scope == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceKey return the key of device in universe
+func deviceKey(device Auth) (string, error) {
+	keyBytes, err := json.Marshal(device)
+	if err != nil {
+		return "", err
+	}
+	return string(keyBytes), nil
+}
+
+// GetDeviceAuth return the authorization of device by user and the id of msg
+// authorized it, nil if device not authorized
+func (u Universe) GetDeviceAuth(userID common.Hash, device Auth) (*ContentDeviceAuth, common.Hash) {
+	key, err := deviceKey(device)
+	if err != nil {
+		return nil, common.Hash{}
+	}
+	if auth, ok := u.devices[userID][key]; ok {
+		return auth.content, auth.msgID
+	}
+	return nil, common.Hash{}
+}
+
+// validateDevice check the authorization of device is signed by master key,
+// and the msg signed by device is authorized by sender. The chain is walked
+// from the device key to its authorization, which is signed by the master
+// key of sender. The authorization should be in scopes and not expired at
+// the sequence of primary space-time seen by msg.
+func validateDevice(u *Universe, msg *Message) error {
+	if msg.Value == nil {
+		return nil
+	}
+	if msg.Value.ContentType == TypeDeviceAuth {
+		if msg.Device != nil {
+			return ErrDeviceAuthNotValid
+		}
+		var content ContentDeviceAuth
+		if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+			return err
+		}
+		sender := u.GetUserByID(msg.SenderID)
+		if sender == nil {
+			return ErrUserNotExist
+		}
+		masterKey, err := deviceKey(*sender.Auth)
+		if err != nil {
+			return err
+		}
+		if key, err := deviceKey(content.Device); err != nil || key == masterKey {
+			return ErrDeviceAuthNotValid
+		}
+		for _, scope := range content.Scopes {
+			if masterOnlyTypes[scope] {
+				return ErrDeviceAuthNotValid
+			}
+		}
+	}
+	if msg.Device == nil {
+		return nil
+	}
+
+	content, authMsgID := u.GetDeviceAuth(msg.SenderID, *msg.Device)
+	if content == nil || !content.inScopes(msg.Value.ContentType) {
+		return ErrDeviceNotAuthorized
+	}
+	if content.Expiry == 0 {
+		return nil
+	}
+	st, err := u.getSpaceTime(u.GetPrimarySpaceTime())
+	if err != nil {
+		return nil
+	}
+	memo := make(map[common.Hash]uint64)
+	var seq uint64
+	for _, r := range msg.Reference {
+		if s := u.seenSeq(r.MsgID, st, memo); s > seq {
+			seq = s
+		}
+	}
+	if seq >= u.seenSeq(authMsgID, st, memo)+content.Expiry {
+		return ErrDeviceAuthExpired
+	}
+	return nil
+}
+
+// handleDeviceAuth record the device authorized by sender
+func handleDeviceAuth(u *Universe, msg *Message) error {
+	var content ContentDeviceAuth
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	key, err := deviceKey(content.Device)
+	if err != nil {
+		return err
+	}
+	if u.devices[msg.SenderID] == nil {
+		u.devices[msg.SenderID] = make(map[string]*deviceAuth)
+	}
+	u.devices[msg.SenderID][key] = &deviceAuth{msgID: msg.ID(), content: &content}
+	return nil
+}
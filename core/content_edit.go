@@ -0,0 +1,87 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ContentEdit is the content of TypeEdit msg, which replace the content of
+// original msg. The original msg is never changed, the edits are added into
+// its version chain, so the history can be retrieved by GetEditHistory.
+type ContentEdit struct {
+	MsgID   common.Hash `json:"msgID"`
+	Content []byte      `json:"content"`
+}
+
+// CreateContentEdit create the edit of original msg, if the original is an
+// edit, the msg it edited is used instead, so all edits refer the first version.
+func CreateContentEdit(original *Message, content []byte) (*ContentEdit, error) {
+	if original == nil || original.Value == nil {
+		return nil, ErrMsgStructureNotValid
+	}
+	if original.Value.ContentType == TypeEdit {
+		var ce ContentEdit
+		if err := json.Unmarshal(original.Value.Content, &ce); err != nil {
+			return nil, err
+		}
+		return &ContentEdit{MsgID: ce.MsgID, Content: content}, nil
+	}
+	return &ContentEdit{MsgID: original.ID(), Content: content}, nil
+}
+
+// GetEditHistory return the version chain of msg, the original msg first and
+// then the edits in order of added. The msg can be the original or any edit
+// of it, nil if msg not exist.
+func (u Universe) GetEditHistory(msgID common.Hash) []*Message {
+	msg := u.GetMsgByID(msgID)
+	if msg == nil {
+		return nil
+	}
+	if msg.Value != nil && msg.Value.ContentType == TypeEdit {
+		var ce ContentEdit
+		if err := json.Unmarshal(msg.Value.Content, &ce); err != nil {
+			return nil
+		}
+		if msg = u.GetMsgByID(ce.MsgID); msg == nil {
+			return nil
+		}
+	}
+	history := []*Message{msg}
+	for _, id := range u.edits[msg.ID()] {
+		if edit := u.GetMsgByID(id); edit != nil {
+			history = append(history, edit)
+		}
+	}
+	return history
+}
+
+// EditCount return the number of edits of the msg
+func (u Universe) EditCount(msgID common.Hash) int {
+	return len(u.edits[msgID])
+}
+
+// editable return true if the content of msg can be replaced by edit
+func editable(msg *Message) bool {
+	return msg.Value != nil && msg.Value.ContentType == TypeText
+}
+
+func (u *Universe) addEdit(msgID common.Hash, edit *Message) {
+	u.edits[msgID] = append(u.edits[msgID], edit.ID())
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+// MaxFileChunkSize is the max number of bytes of data in one TypeFile msg
+const MaxFileChunkSize = 1024 * 32
+
+// ContentResolver store the large content out of msg, such as IPFS. The
+// content is addressed by CID returned from Put.
+type ContentResolver interface {
+	Put(data []byte) (cid string, err error)
+	Get(cid string) ([]byte, error)
+	Pin(cid string) error
+	Unpin(cid string) error
+}
+
+// ContentFile is the content of TypeFile msg, which is one chunk of file.
+// Data is kept in msg if it is small, otherwise only CID is kept and data
+// is stored by ContentResolver. Hash of data is always kept, so the data
+// resolved can be verified without knowing how CID be calculated.
+type ContentFile struct {
+	Name  string      `json:"name"`
+	Size  uint64      `json:"size"`
+	Index int         `json:"index"`
+	Total int         `json:"total"`
+	Hash  common.Hash `json:"hash"`
+	Data  []byte      `json:"data,omitempty"`
+	CID   string      `json:"cid,omitempty"`
+}
+
+// CreateContentFile create the chunk (index of total) of file, the data is
+// stored by resolver if resolver is not nil, otherwise data is kept in msg
+// and should not larger than MaxFileChunkSize.
+func CreateContentFile(name string, size uint64, index, total int, data []byte, resolver ContentResolver) (*ContentFile, error) {
+	cf := &ContentFile{Name: name, Size: size, Index: index, Total: total, Hash: hashFileChunk(data)}
+	if resolver != nil {
+		cid, err := resolver.Put(data)
+		if err != nil {
+			return nil, err
+		}
+		cf.CID = cid
+	} else {
+		cf.Data = data
+	}
+	if err := cf.check(); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// Offloaded return true if the data is stored out of msg
+func (cf ContentFile) Offloaded() bool {
+	return len(cf.CID) > 0
+}
+
+// Resolve return the data of chunk, data stored out of msg is got from
+// resolver and verified by hash
+func (cf ContentFile) Resolve(resolver ContentResolver) ([]byte, error) {
+	if !cf.Offloaded() {
+		return cf.Data, nil
+	}
+	if resolver == nil {
+		return nil, ErrContentResolverMissing
+	}
+	data, err := resolver.Get(cf.CID)
+	if err != nil {
+		return nil, err
+	}
+	if hashFileChunk(data) != cf.Hash {
+		return nil, ErrFileChunkHashNotMatch
+	}
+	return data, nil
+}
+
+func (cf ContentFile) check() error {
+	if cf.Total <= 0 || cf.Index < 0 || cf.Index >= cf.Total {
+		return ErrFileChunkNotValid
+	}
+	if cf.Offloaded() == (len(cf.Data) > 0) {
+		return ErrFileChunkNotValid
+	}
+	if len(cf.Data) > MaxFileChunkSize {
+		return ErrFileChunkNotValid
+	}
+	if !cf.Offloaded() && hashFileChunk(cf.Data) != cf.Hash {
+		return ErrFileChunkHashNotMatch
+	}
+	return nil
+}
+
+func hashFileChunk(data []byte) common.Hash {
+	hash := common.NewHash()
+	hash.Write(data)
+	return common.Bytes2Hash(hash.Sum(nil))
+}
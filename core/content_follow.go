@@ -0,0 +1,128 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ContentFollow is the content of TypeFollow and TypeUnfollow msg, the sender
+// follow or unfollow the user.
+type ContentFollow struct {
+	UserID common.Hash `json:"userID"`
+}
+
+// CreateContentFollow create the content to follow or unfollow the user
+func CreateContentFollow(userID common.Hash) *ContentFollow {
+	return &ContentFollow{UserID: userID}
+}
+
+// GetFollowing return the ids of users followed by the user, sorted by id
+func (u Universe) GetFollowing(userID common.Hash) []common.Hash {
+	return sortedUserIDs(u.following[userID])
+}
+
+// GetFollowers return the ids of users following the user, sorted by id
+func (u Universe) GetFollowers(userID common.Hash) []common.Hash {
+	return sortedUserIDs(u.followers[userID])
+}
+
+// GetTimeline return at most limit msgs sent by users followed by the user,
+// from new to old by the sequence in primary space-time. The msgs of same
+// sequence are ordered by timestamp hint, then by id.
+func (u Universe) GetTimeline(userID common.Hash, limit int) []*Message {
+	return u.GetTimelineAt(userID, nil, 0, limit)
+}
+
+// GetTimelineAt return the page of timeline in view, the msgs committed after
+// the view taken are not included, so the pages after skip are not shifted.
+func (u Universe) GetTimelineAt(userID common.Hash, view *View, skip, limit int) []*Message {
+	var msgs []*Message
+	following := u.following[userID]
+	if len(following) == 0 || limit <= 0 || u.msgD == nil {
+		return msgs
+	}
+	for _, id := range u.msgD.msgIDs() {
+		if msg := u.GetMsgByID(id); msg != nil && following[msg.SenderID] && u.InView(view, msg.ID()) {
+			msgs = append(msgs, msg)
+		}
+	}
+	seqs := make(map[common.Hash]uint64)
+	if st, err := u.getSpaceTime(u.GetPrimarySpaceTime()); err == nil {
+		memo := make(map[common.Hash]uint64)
+		for _, msg := range msgs {
+			seqs[msg.ID()] = u.seenSeq(msg.ID(), st, memo)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		a, b := msgs[i], msgs[j]
+		if seqs[a.ID()] != seqs[b.ID()] {
+			return seqs[a.ID()] > seqs[b.ID()]
+		}
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp > b.Timestamp
+		}
+		aID, bID := a.ID(), b.ID()
+		return bytes.Compare(aID[:], bID[:]) > 0
+	})
+	if skip > len(msgs) {
+		skip = len(msgs)
+	}
+	msgs = msgs[skip:]
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs
+}
+
+// updateFollowByMsg add or remove the edge of follow graph from msg sender
+func (u *Universe) updateFollowByMsg(msg *Message) error {
+	var content ContentFollow
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	follower, followee := msg.SenderID, content.UserID
+	if msg.Value.ContentType == TypeUnfollow {
+		delete(u.following[follower], followee)
+		delete(u.followers[followee], follower)
+		return nil
+	}
+	if u.following[follower] == nil {
+		u.following[follower] = make(map[common.Hash]bool)
+	}
+	if u.followers[followee] == nil {
+		u.followers[followee] = make(map[common.Hash]bool)
+	}
+	u.following[follower][followee] = true
+	u.followers[followee][follower] = true
+	return nil
+}
+
+func sortedUserIDs(set map[common.Hash]bool) []common.Hash {
+	userIDs := []common.Hash{}
+	for userID := range set {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		return bytes.Compare(userIDs[i][:], userIDs[j][:]) < 0
+	})
+	return userIDs
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+const (
+	// MinHandleLength is the min number of characters of handle
+	MinHandleLength = 3
+	// MaxHandleLength is the max number of characters of handle
+	MaxHandleLength = 32
+)
+
+// ContentNameClaim is the content of TypeNameClaim msg, the sender claim the
+// handle, such as @alice, in each space-time the sender belongs to.
+type ContentNameClaim struct {
+	Handle string `json:"handle"`
+}
+
+// CreateContentNameClaim create the claim of handle, the leading @ is removed
+// and the handle is converted to lower case.
+func CreateContentNameClaim(handle string) (*ContentNameClaim, error) {
+	handle, err := NormalizeHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &ContentNameClaim{Handle: handle}, nil
+}
+
+// NormalizeHandle return the handle in lower case without leading @, only
+// letters, digits and underscore are allowed.
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(handle, "@"))
+	if len(handle) < MinHandleLength || len(handle) > MaxHandleLength {
+		return "", ErrHandleNotValid
+	}
+	for _, c := range handle {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return "", ErrHandleNotValid
+		}
+	}
+	return handle, nil
+}
+
+// nameClaim is the latest claim of user in one space-time
+type nameClaim struct {
+	handle string
+	msgID  common.Hash
+	seq    uint64
+}
+
+// before return true if claim c is earlier than o in space-time. Claim with
+// smaller sequence is earlier, claim not reach any time proof is later than
+// others, the smaller msg id is earlier if sequences are same. So every node
+// get same owner of handle whatever the order msgs be received.
+func (c nameClaim) before(o nameClaim) bool {
+	if c.seq != o.seq {
+		return o.seq == 0 || (c.seq != 0 && c.seq < o.seq)
+	}
+	return bytes.Compare(c.msgID[:], o.msgID[:]) < 0
+}
+
+// nameIndex is the handles claimed in one space-time
+type nameIndex struct {
+	claims map[common.Hash]nameClaim       // user.id : latest claim of user
+	users  map[string]map[common.Hash]bool // handle : users claiming the handle
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{claims: make(map[common.Hash]nameClaim), users: make(map[string]map[common.Hash]bool)}
+}
+
+// claim replace the handle claimed by user before
+func (ni *nameIndex) claim(userID common.Hash, c nameClaim) {
+	if old, ok := ni.claims[userID]; ok {
+		delete(ni.users[old.handle], userID)
+		if len(ni.users[old.handle]) == 0 {
+			delete(ni.users, old.handle)
+		}
+	}
+	ni.claims[userID] = c
+	if ni.users[c.handle] == nil {
+		ni.users[c.handle] = make(map[common.Hash]bool)
+	}
+	ni.users[c.handle][userID] = true
+}
+
+// LookupHandle return the owner of handle in space-time. The earliest claim
+// wins if the handle is claimed by more than one user, the claims of users
+// banned in the space-time are ignored.
+func (u Universe) LookupHandle(handle string, spacetimeID common.Hash) (common.Hash, bool) {
+	handle, err := NormalizeHandle(handle)
+	if err != nil {
+		return common.Hash{}, false
+	}
+	ni, ok := u.names[spacetimeID]
+	if !ok {
+		return common.Hash{}, false
+	}
+	var owner common.Hash
+	var first *nameClaim
+	for userID := range ni.users[handle] {
+		if state, ok := u.GetUserState(userID, spacetimeID); !ok || state == UserStatusBanned {
+			continue
+		}
+		if c := ni.claims[userID]; first == nil || c.before(*first) {
+			owner, first = userID, &c
+		}
+	}
+	return owner, first != nil
+}
+
+// GetHandle return the handle owned by user in space-time, false if user not
+// claim any handle or the handle is owned by other user
+func (u Universe) GetHandle(userID common.Hash, spacetimeID common.Hash) (string, bool) {
+	ni, ok := u.names[spacetimeID]
+	if !ok {
+		return "", false
+	}
+	c, ok := ni.claims[userID]
+	if !ok {
+		return "", false
+	}
+	if owner, ok := u.LookupHandle(c.handle, spacetimeID); !ok || owner != userID {
+		return "", false
+	}
+	return c.handle, true
+}
+
+// addNameClaimByMsg add the claim into each space-time the sender belongs to
+func (u *Universe) addNameClaimByMsg(msg *Message) error {
+	var content ContentNameClaim
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	for _, stID := range u.GetSpaceTimeIDs() {
+		st, err := u.getSpaceTime(stID)
+		if err != nil || st.GetUserInfo(msg.SenderID) == nil {
+			continue
+		}
+		ni, ok := u.names[stID]
+		if !ok {
+			ni = newNameIndex()
+			u.names[stID] = ni
+		}
+		seq := u.seenSeq(msg.ID(), st, make(map[common.Hash]uint64))
+		ni.claim(msg.SenderID, nameClaim{handle: content.Handle, msgID: msg.ID(), seq: seq})
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ContentRepost is the content of TypeRepost msg, which share the original
+// msg with the comment of sender. The author is kept for provenance, so the
+// original can be shown with its author even before the msg is resolved.
+type ContentRepost struct {
+	MsgID    common.Hash `json:"msgID"`
+	AuthorID common.Hash `json:"authorID"`
+	Comment  string      `json:"comment,omitempty"`
+}
+
+// CreateContentRepost create the repost of original msg, if the original is
+// a repost, the msg it shared is reposted instead.
+func CreateContentRepost(original *Message, comment string) (*ContentRepost, error) {
+	if original == nil || original.Value == nil {
+		return nil, ErrMsgStructureNotValid
+	}
+	if original.Value.ContentType == TypeRepost {
+		var cr ContentRepost
+		if err := json.Unmarshal(original.Value.Content, &cr); err != nil {
+			return nil, err
+		}
+		return &ContentRepost{MsgID: cr.MsgID, AuthorID: cr.AuthorID, Comment: comment}, nil
+	}
+	return &ContentRepost{MsgID: original.ID(), AuthorID: original.SenderID, Comment: comment}, nil
+}
+
+// GetReposts return the ids of repost msgs of the msg, in order of added
+func (u Universe) GetReposts(msgID common.Hash) []common.Hash {
+	return append([]common.Hash{}, u.reposts[msgID]...)
+}
+
+// RepostCount return the number of repost msgs of the msg
+func (u Universe) RepostCount(msgID common.Hash) int {
+	return len(u.reposts[msgID])
+}
+
+// hasRepost return true if the user already repost the msg
+func (u Universe) hasRepost(msgID, senderID common.Hash) bool {
+	for _, id := range u.reposts[msgID] {
+		if repost := u.GetMsgByID(id); repost != nil && repost.SenderID == senderID {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Universe) addRepost(msgID common.Hash, repost *Message) {
+	u.reposts[msgID] = append(u.reposts[msgID], repost.ID())
+}
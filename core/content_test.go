@@ -17,6 +17,8 @@
 package core
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -27,3 +29,55 @@ func TestContentBirth(t *testing.T) {
 func TestContentEvidence(t *testing.T) {
 
 }
+
+type testResolver map[string][]byte
+
+func (r testResolver) Put(data []byte) (string, error) {
+	cid := fmt.Sprintf("cid%d", len(r))
+	r[cid] = data
+	return cid, nil
+}
+
+func (r testResolver) Get(cid string) ([]byte, error) {
+	if data, ok := r[cid]; ok {
+		return data, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (r testResolver) Pin(cid string) error   { return nil }
+func (r testResolver) Unpin(cid string) error { return nil }
+
+func TestContentFile(t *testing.T) {
+	data := []byte("chunk of file")
+	cf, err := CreateContentFile("a.txt", 100, 0, 2, data, nil)
+	if err != nil || cf.Offloaded() {
+		t.Fatal("create inline file chunk fail", err)
+	}
+	if res, err := cf.Resolve(nil); err != nil || string(res) != string(data) {
+		t.Error("resolve inline file chunk fail", err)
+	}
+	if _, err := CreateContentFile("a.txt", 100, 2, 2, data, nil); err != ErrFileChunkNotValid {
+		t.Errorf("err should be %s, but get %s", ErrFileChunkNotValid, err)
+	}
+	if _, err := CreateContentFile("a.txt", 100, 0, 1, make([]byte, MaxFileChunkSize+1), nil); err != ErrFileChunkNotValid {
+		t.Errorf("err should be %s, but get %s", ErrFileChunkNotValid, err)
+	}
+
+	r := make(testResolver)
+	large := make([]byte, MaxFileChunkSize+1)
+	cf, err = CreateContentFile("b.bin", uint64(len(large)), 0, 1, large, r)
+	if err != nil || !cf.Offloaded() || len(cf.Data) != 0 {
+		t.Fatal("create offloaded file chunk fail", err)
+	}
+	if _, err := cf.Resolve(nil); err != ErrContentResolverMissing {
+		t.Errorf("err should be %s, but get %s", ErrContentResolverMissing, err)
+	}
+	if res, err := cf.Resolve(r); err != nil || len(res) != len(large) {
+		t.Error("resolve offloaded file chunk fail", err)
+	}
+	r[cf.CID] = data
+	if _, err := cf.Resolve(r); err != ErrFileChunkHashNotMatch {
+		t.Errorf("err should be %s, but get %s", ErrFileChunkHashNotMatch, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ContentUserStateUpdate is the content of TypeUserStateUpdate msg, which set
+// the public state of user in the space-time of msg sender.
+type ContentUserStateUpdate struct {
+	UserID common.Hash `json:"userID"`
+	State  int         `json:"state"`
+}
+
+// CreateContentUserStateUpdate create the content to set the public state
+// (UserStatusNormal, UserStatusHidden or UserStatusBanned) of user
+func CreateContentUserStateUpdate(userID common.Hash, state int) (*ContentUserStateUpdate, error) {
+	if state < UserStatusNormal || state > UserStatusBanned {
+		return nil, ErrUserStateNotValid
+	}
+	return &ContentUserStateUpdate{UserID: userID, State: state}, nil
+}
+
+// validateUserStateUpdate check the state is known, and the user updated
+// belongs to the space-time owned by sender
+func validateUserStateUpdate(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeUserStateUpdate {
+		return nil
+	}
+	var content ContentUserStateUpdate
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	if content.State < UserStatusNormal || content.State > UserStatusBanned {
+		return ErrUserStateNotValid
+	}
+	st, err := u.getSpaceTime(msg.SenderID)
+	if err != nil {
+		return ErrNotSpaceTimeOwner
+	}
+	if st.GetUserInfo(content.UserID) == nil {
+		return ErrUserNotExist
+	}
+	return nil
+}
@@ -16,45 +16,217 @@
 
 package core
 
-import "errors"
+import "github.com/pdupub/go-pdu/common"
+
+// Error is the error of core with stable code, the codes of core errors start
+// from common.ErrCodeCore and never change once released.
+type Error = common.Error
 
 var (
 	// ErrUserNotExist returns fail to find a user
-	ErrUserNotExist = errors.New("user not exist")
+	ErrUserNotExist = common.NewError(common.ErrCodeCore+1, "user not exist")
 
 	// ErrMsgAlreadyExist returns try to add a message which already exist in universe
-	ErrMsgAlreadyExist = errors.New("msg already exist")
+	ErrMsgAlreadyExist = common.NewError(common.ErrCodeCore+2, "msg already exist")
 
 	// ErrMsgNotFound returns fail to find a message
-	ErrMsgNotFound = errors.New("msg not found")
+	ErrMsgNotFound = common.NewError(common.ErrCodeCore+3, "msg not found")
 
 	// ErrTPAlreadyExist returns time proof message already exist
-	ErrTPAlreadyExist = errors.New("time proof already exist")
+	ErrTPAlreadyExist = common.NewError(common.ErrCodeCore+4, "time proof already exist")
 
 	// ErrUserAlreadyExist returns try a add a user who is already exist in unverse
-	ErrUserAlreadyExist = errors.New("user already exist")
+	ErrUserAlreadyExist = common.NewError(common.ErrCodeCore+5, "user already exist")
 
 	// ErrNotSupportYet returns not support temp
-	ErrNotSupportYet = errors.New("not error, just not support yet")
+	ErrNotSupportYet = common.NewError(common.ErrCodeCore+6, "not error, just not support yet")
 
 	// ErrNewUserAddFail returns add new user fail for unknown reason
-	ErrNewUserAddFail = errors.New("new user add fail")
+	ErrNewUserAddFail = common.NewError(common.ErrCodeCore+7, "new user add fail")
 
 	// ErrCreateSpaceTimeFail returns when create space time fail
-	ErrCreateSpaceTimeFail = errors.New("create space time fail")
+	ErrCreateSpaceTimeFail = common.NewError(common.ErrCodeCore+8, "create space time fail")
 
 	// ErrAddUserToSpaceTimeFail returns when add user to space time fail
-	ErrAddUserToSpaceTimeFail = errors.New("add user to space time fail")
+	ErrAddUserToSpaceTimeFail = common.NewError(common.ErrCodeCore+9, "add user to space time fail")
 
 	// ErrCreateRootUserFail returns when create root user fail
-	ErrCreateRootUserFail = errors.New("create root user fail")
+	ErrCreateRootUserFail = common.NewError(common.ErrCodeCore+10, "create root user fail")
 
 	// ErrContentTypeNotBirth returns when try to add user from a not birth message
-	ErrContentTypeNotBirth = errors.New("content type is not TypeBirth")
+	ErrContentTypeNotBirth = common.NewError(common.ErrCodeCore+11, "content type is not TypeBirth")
 
 	// ErrDimensionNumberNotSuitable returns if the dimension is zero or too large
-	ErrDimensionNumberNotSuitable = errors.New("number of dimension is not suitable")
+	ErrDimensionNumberNotSuitable = common.NewError(common.ErrCodeCore+12, "number of dimension is not suitable")
 
 	// ErrPerimeterIsZero returns if perimeter is zero
-	ErrPerimeterIsZero = errors.New("perimeter should not be zero")
+	ErrPerimeterIsZero = common.NewError(common.ErrCodeCore+13, "perimeter should not be zero")
+
+	// ErrMsgTimestampTooEarly returns if the timestamp hint of msg is earlier than referenced msgs
+	ErrMsgTimestampTooEarly = common.NewError(common.ErrCodeCore+14, "timestamp of msg earlier than referenced msgs")
+
+	// ErrMsgTimestampDrift returns if the timestamp hint of msg is too far later than local clock
+	ErrMsgTimestampDrift = common.NewError(common.ErrCodeCore+15, "timestamp of msg drift from local clock")
+
+	// ErrMsgPoWNotValid returns if the PoW hash of msg not meet the difficulty of its content type
+	ErrMsgPoWNotValid = common.NewError(common.ErrCodeCore+16, "proof of work of msg not valid")
+
+	// ErrPoWDifficultyTooHigh returns if the difficulty is more than MaxPoWDifficulty
+	ErrPoWDifficultyTooHigh = common.NewError(common.ErrCodeCore+17, "proof of work difficulty too high")
+
+	// ErrNotSpaceTimeOwner returns if user state be updated by user who not own a space-time
+	ErrNotSpaceTimeOwner = common.NewError(common.ErrCodeCore+18, "sender is not owner of space-time")
+
+	// ErrUserStateNotValid returns if the public state of user is unknown
+	ErrUserStateNotValid = common.NewError(common.ErrCodeCore+19, "user state not valid")
+
+	// ErrSelfRefMissing returns if msg not reference the last msg of sender when SelfRefRequired
+	ErrSelfRefMissing = common.NewError(common.ErrCodeCore+20, "reference of last msg from sender missing")
+
+	// ErrSpaceTimeNotExist returns if the space-time can not be found in universe
+	ErrSpaceTimeNotExist = common.NewError(common.ErrCodeCore+21, "space time not exist")
+
+	// ErrSpaceTimeNotTrusted returns if the space-time is not trusted by time proof policy
+	ErrSpaceTimeNotTrusted = common.NewError(common.ErrCodeCore+22, "space time not trusted")
+
+	// ErrDistrustPrimarySpaceTime returns when try to remove primary space-time from trusted list
+	ErrDistrustPrimarySpaceTime = common.NewError(common.ErrCodeCore+23, "primary space time can not be distrusted")
+
+	// ErrSeqNotFound returns if the sequence of msg can not be found or mapped in space-time
+	ErrSeqNotFound = common.NewError(common.ErrCodeCore+24, "sequence not found")
+
+	// ErrCheckpointNotSigned returns if verify the checkpoint without signature
+	ErrCheckpointNotSigned = common.NewError(common.ErrCodeCore+25, "checkpoint not signed")
+
+	// ErrSignerNotMatch returns if the count of checkpoints and signers not match
+	ErrSignerNotMatch = common.NewError(common.ErrCodeCore+26, "count of checkpoints and signers not match")
+
+	// ErrSearchNotEnable returns if search in universe which search index not be created
+	ErrSearchNotEnable = common.NewError(common.ErrCodeCore+27, "search not enable")
+
+	// ErrMsgFilterNotMatch returns if set the msg bloom filter created by different params
+	ErrMsgFilterNotMatch = common.NewError(common.ErrCodeCore+28, "msg filter not match")
+
+	// ErrMsgSignatureNotValid returns if the signature of msg not signed by sender
+	ErrMsgSignatureNotValid = common.NewError(common.ErrCodeCore+29, "msg signature not valid")
+
+	// ErrMsgStructureNotValid returns if the value, signature or reference of msg missing
+	ErrMsgStructureNotValid = common.NewError(common.ErrCodeCore+30, "msg structure not valid")
+
+	// ErrReceiptNotValid returns if commit the receipt not created by Validate
+	ErrReceiptNotValid = common.NewError(common.ErrCodeCore+31, "receipt not valid")
+
+	// ErrCheckpointNotMatch returns if the state root of universe not match the checkpoint
+	ErrCheckpointNotMatch = common.NewError(common.ErrCodeCore+32, "checkpoint not match")
+
+	// ErrArchiveRootsMissing returns if the first entry of msg archive not contain two root users
+	ErrArchiveRootsMissing = common.NewError(common.ErrCodeCore+33, "roots of archive missing")
+
+	// ErrFileChunkNotValid returns if the file chunk has both or neither data and CID,
+	// the index out of range or the data too large
+	ErrFileChunkNotValid = common.NewError(common.ErrCodeCore+34, "file chunk not valid")
+
+	// ErrFileChunkHashNotMatch returns if the data resolved not match the hash in file chunk
+	ErrFileChunkHashNotMatch = common.NewError(common.ErrCodeCore+35, "file chunk hash not match")
+
+	// ErrContentResolverMissing returns if resolve the file chunk stored out of msg without resolver
+	ErrContentResolverMissing = common.NewError(common.ErrCodeCore+36, "content resolver missing")
+
+	// ErrMsgNetworkNotMatch returns if msg is created on other network, such as testnet msg to mainnet
+	ErrMsgNetworkNotMatch = common.NewError(common.ErrCodeCore+37, "network of msg not match universe")
+
+	// ErrMsgExpiryNotValid returns if the content hash of ephemeral msg not match its content,
+	// or the content type of ephemeral msg change the state of universe
+	ErrMsgExpiryNotValid = common.NewError(common.ErrCodeCore+38, "expiry of msg not valid")
+
+	// ErrNetworkNotValid returns if the network name is not preset or number
+	ErrNetworkNotValid = common.NewError(common.ErrCodeCore+39, "network not valid")
+
+	// ErrSnapshotNotValid returns if the chunks of snapshot not match the msg count
+	ErrSnapshotNotValid = common.NewError(common.ErrCodeCore+40, "snapshot not valid")
+
+	// ErrSnapshotChunkNotValid returns if the msgs of chunk not match the hash in snapshot
+	ErrSnapshotChunkNotValid = common.NewError(common.ErrCodeCore+41, "snapshot chunk not valid")
+
+	// ErrSnapshotNotComplete returns if apply the snapshot before all chunks replayed
+	ErrSnapshotNotComplete = common.NewError(common.ErrCodeCore+42, "snapshot not complete")
+
+	// ErrSnapshotNotSigned returns if verify the snapshot without signature
+	ErrSnapshotNotSigned = common.NewError(common.ErrCodeCore+43, "snapshot not signed")
+
+	// ErrSnapshotSignerNotValid returns if the snapshot not signed by the owner of space-time
+	ErrSnapshotSignerNotValid = common.NewError(common.ErrCodeCore+44, "snapshot signer not valid")
+
+	// ErrRepostOriginalNotFound returns if the original msg of repost not exist in universe
+	ErrRepostOriginalNotFound = common.NewError(common.ErrCodeCore+45, "original msg of repost not found")
+
+	// ErrRepostNotValid returns if the author not match the original msg, or the original is a repost
+	ErrRepostNotValid = common.NewError(common.ErrCodeCore+46, "repost not valid")
+
+	// ErrRepostAlreadyExist returns if the sender already repost the original msg
+	ErrRepostAlreadyExist = common.NewError(common.ErrCodeCore+47, "repost already exist")
+
+	// ErrHandleNotValid returns if the handle too short, too long or contain char not allowed
+	ErrHandleNotValid = common.NewError(common.ErrCodeCore+48, "handle not valid")
+
+	// ErrFollowNotValid returns if the user followed not exist or is the sender self
+	ErrFollowNotValid = common.NewError(common.ErrCodeCore+49, "follow not valid")
+
+	// ErrBirthRevealNotValid returns if the sender is not hidden, already revealed, or
+	// the disclosure not match the commitment in birth msg
+	ErrBirthRevealNotValid = common.NewError(common.ErrCodeCore+50, "birth reveal not valid")
+
+	// ErrConsentNotValid returns if the signature of parent in birth msg not valid, or not
+	// signed with time proof msg when consent window is required
+	ErrConsentNotValid = common.NewError(common.ErrCodeCore+51, "consent of parent not valid")
+
+	// ErrConsentExpired returns if the birth msg is out of the consent window of parent
+	ErrConsentExpired = common.NewError(common.ErrCodeCore+52, "consent of parent expired")
+
+	// ErrConsentRevoked returns if the consent in birth msg already be revoked by parent
+	ErrConsentRevoked = common.NewError(common.ErrCodeCore+53, "consent of parent revoked")
+
+	// ErrConsentRevokeNotValid returns if revoke the consent after the birth msg be included
+	ErrConsentRevokeNotValid = common.NewError(common.ErrCodeCore+54, "consent revoke not valid")
+
+	// ErrDeviceAuthNotValid returns if the device key in authorization is same as the
+	// master key, or the authorization not signed by master key
+	ErrDeviceAuthNotValid = common.NewError(common.ErrCodeCore+55, "device authorization not valid")
+
+	// ErrDeviceNotAuthorized returns if the device key signed msg not authorized by sender,
+	// or the content type of msg is out of the scopes of device
+	ErrDeviceNotAuthorized = common.NewError(common.ErrCodeCore+56, "device not authorized")
+
+	// ErrDeviceAuthExpired returns if the msg is signed by device after its authorization expired
+	ErrDeviceAuthExpired = common.NewError(common.ErrCodeCore+57, "device authorization expired")
+
+	// ErrInclusionKindNotValid returns if the kind of inclusion proof is not msg or user
+	ErrInclusionKindNotValid = common.NewError(common.ErrCodeCore+58, "kind of inclusion proof not valid")
+
+	// ErrNotIncluded returns if the msg or user is not included by the state root
+	ErrNotIncluded = common.NewError(common.ErrCodeCore+59, "not included by state root")
+
+	// ErrEditOriginalNotFound returns if the original msg of edit not exist in universe
+	ErrEditOriginalNotFound = common.NewError(common.ErrCodeCore+60, "original msg of edit not found")
+
+	// ErrEditNotValid returns if the original msg is not sent by sender or its content type can not be edited
+	ErrEditNotValid = common.NewError(common.ErrCodeCore+61, "edit not valid")
+
+	// ErrGenesisNotValid returns if the genesis not contain two root users with public key
+	ErrGenesisNotValid = common.NewError(common.ErrCodeCore+62, "genesis not valid")
+
+	// ErrSchemaNotValid is wrapped by SchemaError if the JSON not match the schema
+	ErrSchemaNotValid = common.NewError(common.ErrCodeCore+63, "schema not valid")
+
+	// ErrReplayDiverged is wrapped by ReplayDivergence if the state root of replay not match
+	ErrReplayDiverged = common.NewError(common.ErrCodeCore+64, "replay diverged")
+
+	// ErrUniverseNotMatch returns if the peer is in the universe of other roots
+	ErrUniverseNotMatch = common.NewError(common.ErrCodeCore+65, "universe of peer not match")
+
+	// ErrIdentityNotValid returns if the user or msgs in identity bundle not valid
+	ErrIdentityNotValid = common.NewError(common.ErrCodeCore+66, "identity bundle not valid")
+
+	// ErrMsgFiltered returns if the msg is rejected by the filter of node, such as spam filter
+	ErrMsgFiltered = common.NewError(common.ErrCodeCore+67, "msg rejected by filter")
 )
@@ -0,0 +1,187 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// CreateMsgWithExpiry create ephemeral msg with PoW as CreateMsgOnNetwork, the
+// content expire after the primary space-time go expiry sequences beyond the
+// sequence seen by msg. The hash of content is signed instead of the content,
+// so the msg is still valid after its content be dropped, and no hole is left
+// in the DAG of msgs.
+func CreateMsgWithExpiry(network uint64, expiry uint64, user *User, value *MsgValue, priKey *crypto.PrivateKey, difficulty uint8, refs ...*MsgReference) (*Message, error) {
+	if difficulty > MaxPoWDifficulty {
+		return nil, ErrPoWDifficultyTooHigh
+	}
+	if expiry == 0 {
+		return nil, ErrMsgExpiryNotValid
+	}
+	msg := newMsg(user, value, refs...)
+	msg.Network = network
+	msg.Expiry = expiry
+	hash := sha256.Sum256(value.Content)
+	msg.ContentHash = hash[:]
+	if err := msg.grindAndSign(priKey, difficulty); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ContentDropped return true if the content of ephemeral msg already be dropped
+func (msg Message) ContentDropped() bool {
+	return msg.Expiry > 0 && msg.Value != nil && len(msg.Value.Content) == 0
+}
+
+// DropContent remove the content of ephemeral msg, the ID and signature of msg
+// not change. Return false if msg is not ephemeral or content already dropped.
+func (msg *Message) DropContent() bool {
+	if msg.Expiry == 0 || msg.Value == nil || msg.ContentDropped() {
+		return false
+	}
+	msg.Value = msg.signedValue()
+	return true
+}
+
+// signedValue return the value signed and hashed into ID, the content of
+// ephemeral msg is left out, since it is covered by ContentHash.
+func (msg Message) signedValue() *MsgValue {
+	if msg.Expiry == 0 || msg.Value == nil {
+		return msg.Value
+	}
+	return &MsgValue{ContentType: msg.Value.ContentType}
+}
+
+// signedMsg return the msg as signed, see signedValue
+func (msg *Message) signedMsg() *Message {
+	if msg.Expiry == 0 {
+		return msg
+	}
+	m := *msg
+	m.Value = msg.signedValue()
+	return &m
+}
+
+// validateExpiry check the content of ephemeral msg match the content hash, if
+// not dropped yet. Msg with content handler can not be ephemeral, because the
+// content is required when msgs are replayed.
+func validateExpiry(u *Universe, msg *Message) error {
+	if msg.Expiry == 0 {
+		if len(msg.ContentHash) > 0 {
+			return ErrMsgExpiryNotValid
+		}
+		return nil
+	}
+	if len(msg.ContentHash) != sha256.Size {
+		return ErrMsgExpiryNotValid
+	}
+	if _, ok := u.handlers[msg.Value.ContentType]; ok {
+		return ErrMsgExpiryNotValid
+	}
+	if msg.ContentDropped() {
+		return nil
+	}
+	if hash := sha256.Sum256(msg.Value.Content); !bytes.Equal(hash[:], msg.ContentHash) {
+		return ErrMsgExpiryNotValid
+	}
+	return nil
+}
+
+// trackExpiry keep the ephemeral msg committed, until its content be dropped
+func (u *Universe) trackExpiry(msg *Message) {
+	if msg.Expiry > 0 && !msg.ContentDropped() {
+		u.ephemeral[msg.ID()] = true
+	}
+}
+
+// ExpirySeq return the sequence of primary space-time the content of msg expire
+// at, which is the sequence seen by msg plus its expiry, 0 if never expire.
+func (u Universe) ExpirySeq(msgID common.Hash) (uint64, error) {
+	msg := u.GetMsgByID(msgID)
+	if msg == nil {
+		return 0, ErrMsgNotFound
+	}
+	if msg.Expiry == 0 {
+		return 0, nil
+	}
+	st, err := u.getSpaceTime(u.GetPrimarySpaceTime())
+	if err != nil {
+		return 0, err
+	}
+	return u.seenSeq(msgID, st, make(map[common.Hash]uint64)) + msg.Expiry, nil
+}
+
+// IsExpired return true if the content of msg expired in primary space-time
+func (u Universe) IsExpired(msgID common.Hash) bool {
+	seq, err := u.ExpirySeq(msgID)
+	return err == nil && seq > 0 && u.GetPrimaryMaxSeq() >= seq
+}
+
+// DropExpired drop the content of ephemeral msgs expired in primary space-time,
+// and return the msgs dropped, so they can be dropped by storage at the same
+// time. Msgs are only checked when the max sequence of primary space-time changed.
+func (u *Universe) DropExpired() []*Message {
+	maxSeq := u.GetPrimaryMaxSeq()
+	if len(u.ephemeral) == 0 || maxSeq == u.expiryChecked {
+		return nil
+	}
+	u.expiryChecked = maxSeq
+	st, err := u.getSpaceTime(u.GetPrimarySpaceTime())
+	if err != nil {
+		return nil
+	}
+	memo := make(map[common.Hash]uint64)
+	var dropped []*Message
+	for id := range u.ephemeral {
+		msg := u.GetMsgByID(id)
+		if msg == nil {
+			delete(u.ephemeral, id)
+			continue
+		}
+		if u.seenSeq(id, st, memo)+msg.Expiry > maxSeq {
+			continue
+		}
+		u.dropContent(msg)
+		dropped = append(dropped, msg)
+	}
+	return dropped
+}
+
+// DropContent drop the content of ephemeral msg before expired, such as by
+// the retention policy of local node. Return nil if msg not exist, not
+// ephemeral or content already dropped.
+func (u *Universe) DropContent(msgID common.Hash) *Message {
+	msg := u.GetMsgByID(msgID)
+	if msg == nil || msg.Expiry == 0 || msg.ContentDropped() {
+		return nil
+	}
+	u.dropContent(msg)
+	return msg
+}
+
+func (u *Universe) dropContent(msg *Message) {
+	if u.index != nil && msg.Value.ContentType == TypeText {
+		u.index.Remove(msg.ID(), string(msg.Value.Content))
+	}
+	msg.DropContent()
+	delete(u.ephemeral, msg.ID())
+}
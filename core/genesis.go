@@ -0,0 +1,96 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// GenesisRoot is the root user in genesis, the life time of root user is
+// always rule.MaxLifeTime, so only the key, name and extra are given.
+type GenesisRoot struct {
+	Name  string `json:"name"`
+	Extra string `json:"extra"`
+	Auth  *Auth  `json:"auth"`
+}
+
+// Genesis is the data to create universe, which can be loaded from JSON, so
+// new network is created by the genesis file instead of code. The first msg
+// is signed by one of root users, and create the first space-time.
+type Genesis struct {
+	Roots    []*GenesisRoot  `json:"roots"`
+	FirstMsg *Message        `json:"firstMsg,omitempty"`
+	Config   *UniverseConfig `json:"config,omitempty"`
+}
+
+// LoadGenesis decode the genesis in JSON from r
+func LoadGenesis(r io.Reader) (*Genesis, error) {
+	var genesis Genesis
+	if err := json.NewDecoder(r).Decode(&genesis); err != nil {
+		return nil, err
+	}
+	return &genesis, nil
+}
+
+// NewGenesis create the genesis of root users, first msg and config, which can
+// be saved in JSON and shared with other nodes of the network.
+func NewGenesis(Eve, Adam *User, firstMsg *Message, config *UniverseConfig) *Genesis {
+	genesis := &Genesis{FirstMsg: firstMsg, Config: config}
+	for _, root := range []*User{Eve, Adam} {
+		genesis.Roots = append(genesis.Roots, &GenesisRoot{Name: root.Name, Extra: root.BirthExtra, Auth: root.Auth})
+	}
+	return genesis
+}
+
+// RootUsers return the root users created from genesis
+func (g Genesis) RootUsers() ([]*User, error) {
+	if len(g.Roots) != 2 {
+		return nil, ErrGenesisNotValid
+	}
+	var users []*User
+	for _, root := range g.Roots {
+		if root == nil || root.Auth == nil {
+			return nil, ErrGenesisNotValid
+		}
+		users = append(users, CreateRootUser(root.Auth.PublicKey, root.Name, root.Extra))
+	}
+	return users, nil
+}
+
+// NewUniverseFromGenesis create universe by the root users and config of
+// genesis, DefaultUniverseConfig if config not given, then add the first msg.
+func NewUniverseFromGenesis(genesis *Genesis) (*Universe, error) {
+	config := genesis.Config
+	if config == nil {
+		config = DefaultUniverseConfig()
+	}
+	users, err := genesis.RootUsers()
+	if err != nil {
+		return nil, err
+	}
+	u, err := NewUniverseWithConfig(users[0], users[1], config)
+	if err != nil {
+		return nil, err
+	}
+	if genesis.FirstMsg != nil {
+		if err := u.AddMsg(genesis.FirstMsg); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
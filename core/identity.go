@@ -0,0 +1,164 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// IdentityBundleVersion is the version of identity bundle format
+const IdentityBundleVersion = 1
+
+// IdentityBundle is the portable history of one user, so the user can
+// migrate to another node or client with full history. The ancestors are
+// the users signed the birth msgs up to the root users, so the signatures
+// of parents can be verified without the universe. The private key is not
+// included unless requested, and it is encrypted by password as key file.
+type IdentityBundle struct {
+	Version   int             `json:"version"`
+	Network   uint64          `json:"network"`
+	User      *User           `json:"user"`          // public key, birth msg and lifetime
+	Ancestors []*User         `json:"ancestors"`     // parents first, up to root users
+	Msgs      []*Message      `json:"msgs"`          // msgs sent by user, in order of local universe
+	Key       json.RawMessage `json:"key,omitempty"` // key file of user, only if requested
+}
+
+// ExportIdentity return the bundle of user with its ancestors and all msgs
+// sent by it in local universe
+func (u Universe) ExportIdentity(userID common.Hash) (*IdentityBundle, error) {
+	user := u.GetUserByID(userID)
+	if user == nil {
+		return nil, ErrUserNotExist
+	}
+	b := &IdentityBundle{Version: IdentityBundleVersion, Network: u.config.NetworkID, User: user}
+	added := map[common.Hash]bool{userID: true}
+	for queue := []*User{user}; len(queue) > 0; queue = queue[1:] {
+		if queue[0].BirthMsg == nil {
+			continue
+		}
+		for _, parentID := range queue[0].ParentsID() {
+			if added[parentID] {
+				continue
+			}
+			parent := u.GetUserByID(parentID)
+			if parent == nil {
+				return nil, ErrUserNotExist
+			}
+			added[parentID] = true
+			b.Ancestors = append(b.Ancestors, parent)
+			queue = append(queue, parent)
+		}
+	}
+	for _, id := range u.msgD.msgIDs() {
+		if msg := u.GetMsgByID(id); msg != nil && msg.SenderID == userID {
+			b.Msgs = append(b.Msgs, msg)
+		}
+	}
+	return b, nil
+}
+
+// Verify check the bundle without universe, the birth msgs are sent by
+// parents and signed by both of them, and the msgs are sent and signed by
+// user. The root users are trusted as is, and the devices signed msgs are
+// checked by universe when imported.
+func (b IdentityBundle) Verify() error {
+	if b.User == nil {
+		return ErrIdentityNotValid
+	}
+	users := make(map[common.Hash]*User)
+	for _, user := range append([]*User{b.User}, b.Ancestors...) {
+		users[user.ID()] = user
+	}
+	for _, user := range users {
+		if user.BirthMsg == nil {
+			continue
+		}
+		sender, ok := users[user.BirthMsg.SenderID]
+		if !ok {
+			return ErrUserNotExist
+		}
+		if err := verifyMsgBySender(user.BirthMsg, sender); err != nil {
+			return err
+		}
+		var content ContentBirth
+		if err := json.Unmarshal(user.BirthMsg.Value.Content, &content); err != nil {
+			return err
+		}
+		for _, p := range content.Parents {
+			parent, ok := users[p.UserID]
+			if !ok {
+				return ErrUserNotExist
+			}
+			if err := content.verifyParentSig(parent, p); err != nil {
+				return err
+			}
+		}
+	}
+	userID := b.User.ID()
+	for _, msg := range b.Msgs {
+		if msg.SenderID != userID {
+			return ErrIdentityNotValid
+		}
+		if err := verifyMsgBySender(msg, b.User); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Items return the msgs to insert from bundle, the birth msgs of ancestors
+// from root users first, then the birth msg of user and the msgs sent by it
+func (b IdentityBundle) Items() []*Message {
+	var items []*Message
+	added := make(map[common.Hash]bool)
+	add := func(msg *Message) {
+		if msg != nil && !added[msg.ID()] {
+			added[msg.ID()] = true
+			items = append(items, msg)
+		}
+	}
+	for i := len(b.Ancestors) - 1; i >= 0; i-- {
+		add(b.Ancestors[i].BirthMsg)
+	}
+	if b.User != nil {
+		add(b.User.BirthMsg)
+	}
+	for _, msg := range b.Msgs {
+		add(msg)
+	}
+	return items
+}
+
+// VerifyIdentity check the bundle can be imported into universe, all the
+// signatures and lineage links in bundle are valid, the bundle is created
+// in same network, and the lineage starts from the root users of universe
+func (u Universe) VerifyIdentity(b *IdentityBundle) error {
+	if err := b.Verify(); err != nil {
+		return err
+	}
+	if b.Network != u.config.NetworkID {
+		return ErrMsgNetworkNotMatch
+	}
+	for _, user := range append([]*User{b.User}, b.Ancestors...) {
+		if user.BirthMsg == nil && u.GetUserByID(user.ID()) == nil {
+			return ErrUniverseNotMatch
+		}
+	}
+	return nil
+}
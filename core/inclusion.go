@@ -0,0 +1,250 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+const (
+	// InclusionMsg is the kind of proof that msg is reached by the time proof msg of checkpoint
+	InclusionMsg = "msg"
+	// InclusionUser is the kind of proof that user is born in space-time before the checkpoint
+	InclusionUser = "user"
+)
+
+// prefixes of hashes in state tree, so leaf and node can not be exchanged
+const (
+	leafMsg byte = iota
+	leafUser
+	merkleNode
+)
+
+// InclusionProof is the Merkle proof of msg or user included by the state root
+// of checkpoint. Path is the sibling hashes from the leaf to the root, the bit i
+// of Index is 1 if the hash at level i is the right child. The state root is
+// hash of header and the body, body is hash of users root and msgs root.
+type InclusionProof struct {
+	Kind        string        `json:"kind"`
+	ID          common.Hash   `json:"id"`
+	SpaceTimeID common.Hash   `json:"spacetimeID"`
+	Seq         uint64        `json:"seq"`
+	MsgID       common.Hash   `json:"msgID"`
+	Index       uint64        `json:"index"`
+	Path        []common.Hash `json:"path"`
+}
+
+// stateTree is the leaves of users and msgs at the checkpoint, sorted by ID
+type stateTree struct {
+	header common.Hash
+	users  []common.Hash
+	msgs   []common.Hash
+}
+
+// newStateTree collect the users born in space-time before the sequence and
+// the msgs reached by the time proof msg at the sequence by references.
+func (u Universe) newStateTree(st *SpaceTime, spacetimeID common.Hash, seq uint64, msgID common.Hash) *stateTree {
+	t := &stateTree{header: stateHeader(spacetimeID, seq, msgID)}
+	for _, userID := range st.GetUserIDs() {
+		if st.GetUserInfo(userID).natureBirthSeq <= seq {
+			t.users = append(t.users, userID)
+		}
+	}
+	seen := map[common.Hash]bool{msgID: true}
+	for stack := []common.Hash{msgID}; len(stack) > 0; {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		t.msgs = append(t.msgs, id)
+		if msg := u.GetMsgByID(id); msg != nil {
+			for _, r := range msg.Reference {
+				if !seen[r.MsgID] {
+					seen[r.MsgID] = true
+					stack = append(stack, r.MsgID)
+				}
+			}
+		}
+	}
+	sortHashes(t.users)
+	sortHashes(t.msgs)
+	return t
+}
+
+// root return the state root
+func (t *stateTree) root() common.Hash {
+	body := hashNode(merkleRoot(hashLeaves(leafUser, t.users)), merkleRoot(hashLeaves(leafMsg, t.msgs)))
+	return hashNode(t.header, body)
+}
+
+// prove return the proof of the leaf, false if id not in the tree
+func (t *stateTree) prove(kind string, id common.Hash) (*InclusionProof, bool) {
+	ids, leaf, other := t.users, leafUser, hashLeaves(leafMsg, t.msgs)
+	if kind == InclusionMsg {
+		ids, leaf, other = t.msgs, leafMsg, hashLeaves(leafUser, t.users)
+	}
+	i := sort.Search(len(ids), func(i int) bool { return bytes.Compare(ids[i][:], id[:]) >= 0 })
+	if i == len(ids) || ids[i] != id {
+		return nil, false
+	}
+	proof := &InclusionProof{Kind: kind, ID: id, Index: uint64(i)}
+	proof.Path = merklePath(hashLeaves(leaf, ids), i)
+	level := uint64(len(proof.Path))
+	proof.Path = append(proof.Path, merkleRoot(other), t.header)
+	if kind == InclusionMsg {
+		proof.Index |= 1 << level
+	}
+	proof.Index |= 1 << (level + 1)
+	return proof, true
+}
+
+// ProveInclusion create the proof that msg or user is included by the state root
+// of checkpoint at the sequence of space-time, the kind is InclusionMsg or
+// InclusionUser. The proof can be verified by VerifyInclusion without universe.
+func (u Universe) ProveInclusion(spacetimeID common.Hash, seq uint64, kind string, id common.Hash) (*InclusionProof, error) {
+	if kind != InclusionMsg && kind != InclusionUser {
+		return nil, ErrInclusionKindNotValid
+	}
+	st, err := u.getSpaceTime(spacetimeID)
+	if err != nil {
+		return nil, err
+	}
+	msgID, ok := st.GetMsgIDBySeq(seq)
+	if !ok {
+		return nil, ErrSeqNotFound
+	}
+	proof, ok := u.newStateTree(st, spacetimeID, seq, msgID).prove(kind, id)
+	if !ok {
+		return nil, ErrNotIncluded
+	}
+	proof.SpaceTimeID, proof.Seq, proof.MsgID = spacetimeID, seq, msgID
+	return proof, nil
+}
+
+// VerifyInclusion verify the msg or user in proof is included by the state
+// root, the root should be trusted already, such as from the checkpoint signed
+// by time proof user or anchored to other chain.
+func VerifyInclusion(root common.Hash, proof *InclusionProof) (bool, error) {
+	// users are on the left of body, msgs are on the right
+	var leaf byte
+	var side uint64
+	switch proof.Kind {
+	case InclusionMsg:
+		leaf, side = leafMsg, 1
+	case InclusionUser:
+		leaf, side = leafUser, 0
+	default:
+		return false, ErrInclusionKindNotValid
+	}
+	level := len(proof.Path) - 2
+	if level < 0 || level > 62 || proof.Path[level+1] != stateHeader(proof.SpaceTimeID, proof.Seq, proof.MsgID) {
+		return false, nil
+	}
+	if (proof.Index>>uint(level))&1 != side || proof.Index>>uint(level+1) != 1 {
+		return false, nil
+	}
+	h := hashLeaf(leaf, proof.ID)
+	for i, sibling := range proof.Path {
+		if (proof.Index>>uint(i))&1 == 1 {
+			h = hashNode(sibling, h)
+		} else {
+			h = hashNode(h, sibling)
+		}
+	}
+	return h == root, nil
+}
+
+// stateHeader is the hash of space-time ID, sequence and time proof msg ID
+func stateHeader(spacetimeID common.Hash, seq uint64, msgID common.Hash) common.Hash {
+	hash := common.NewHash()
+	seqBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(seqBytes, seq)
+	hash.Write(spacetimeID[:])
+	hash.Write(seqBytes)
+	hash.Write(msgID[:])
+	return common.Bytes2Hash(hash.Sum(nil))
+}
+
+func hashLeaf(prefix byte, id common.Hash) common.Hash {
+	hash := common.NewHash()
+	hash.Write([]byte{prefix})
+	hash.Write(id[:])
+	return common.Bytes2Hash(hash.Sum(nil))
+}
+
+func hashLeaves(prefix byte, ids []common.Hash) []common.Hash {
+	leaves := make([]common.Hash, len(ids))
+	for i, id := range ids {
+		leaves[i] = hashLeaf(prefix, id)
+	}
+	return leaves
+}
+
+func hashNode(left, right common.Hash) common.Hash {
+	hash := common.NewHash()
+	hash.Write([]byte{merkleNode})
+	hash.Write(left[:])
+	hash.Write(right[:])
+	return common.Bytes2Hash(hash.Sum(nil))
+}
+
+// merkleLevel hash the nodes of next level, the empty hash is used as the
+// sibling of the last node if the count is odd
+func merkleLevel(nodes []common.Hash) []common.Hash {
+	next := make([]common.Hash, (len(nodes)+1)/2)
+	for i := range next {
+		var right common.Hash
+		if 2*i+1 < len(nodes) {
+			right = nodes[2*i+1]
+		}
+		next[i] = hashNode(nodes[2*i], right)
+	}
+	return next
+}
+
+// merkleRoot return the root of leaves, the empty hash if no leaf
+func merkleRoot(leaves []common.Hash) common.Hash {
+	if len(leaves) == 0 {
+		return common.Hash{}
+	}
+	for len(leaves) > 1 {
+		leaves = merkleLevel(leaves)
+	}
+	return leaves[0]
+}
+
+// merklePath return the siblings of leaf i from bottom to top
+func merklePath(leaves []common.Hash, i int) []common.Hash {
+	var path []common.Hash
+	for len(leaves) > 1 {
+		var sibling common.Hash
+		if j := i ^ 1; j < len(leaves) {
+			sibling = leaves[j]
+		}
+		path = append(path, sibling)
+		leaves, i = merkleLevel(leaves), i/2
+	}
+	return path
+}
+
+func sortHashes(ids []common.Hash) {
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package ipfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MaxContentSize is the max number of bytes of content can be got
+const MaxContentSize = 1024 * 1024 * 64
+
+// DefaultTimeout is the timeout of each request to IPFS
+const DefaultTimeout = 30 * time.Second
+
+var errContentTooLarge = errors.New("content too large")
+
+// Resolver store and get content by IPFS HTTP API, content can also be
+// got from the public gateways if the API not available.
+type Resolver struct {
+	api      string
+	gateways []string
+	pinOnPut bool
+	client   *http.Client
+}
+
+// New create Resolver by the url of IPFS API, such as http://127.0.0.1:5001,
+// gateways (such as https://ipfs.io) are tried in order if get from API fail.
+// Content put is pinned by default.
+func New(api string, gateways ...string) *Resolver {
+	r := &Resolver{
+		api:      strings.TrimRight(api, "/"),
+		pinOnPut: true,
+		client:   &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, gw := range gateways {
+		if gw = strings.TrimRight(strings.TrimSpace(gw), "/"); len(gw) > 0 {
+			r.gateways = append(r.gateways, gw)
+		}
+	}
+	return r
+}
+
+// SetPinOnPut set whether the content is pinned when put
+func (r *Resolver) SetPinOnPut(pin bool) {
+	r.pinOnPut = pin
+}
+
+// Put add data into IPFS, return the CID
+func (r *Resolver) Put(data []byte) (string, error) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "chunk")
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("pin", fmt.Sprint(r.pinOnPut))
+	query.Set("cid-version", "1")
+	resp, err := r.client.Post(r.api+"/api/v0/add?"+query.Encode(), mw.FormDataContentType(), body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+	var res struct {
+		Hash string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	return res.Hash, nil
+}
+
+// Get return the content of CID, from API first and then gateways
+func (r *Resolver) Get(cid string) ([]byte, error) {
+	data, err := r.call("cat", cid)
+	if err == nil {
+		return data, nil
+	}
+	for _, gw := range r.gateways {
+		var resp *http.Response
+		resp, err = r.client.Get(gw + "/ipfs/" + url.PathEscape(cid))
+		if err != nil {
+			continue
+		}
+		data, err = readResponse(resp)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// Pin pin the content of CID in IPFS daemon, so it will not be removed by gc
+func (r *Resolver) Pin(cid string) error {
+	_, err := r.call("pin/add", cid)
+	return err
+}
+
+// Unpin remove the pin of CID
+func (r *Resolver) Unpin(cid string) error {
+	_, err := r.call("pin/rm", cid)
+	return err
+}
+
+// call the command of IPFS API with the arg
+func (r *Resolver) call(cmd, arg string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("arg", arg)
+	resp, err := r.client.Post(r.api+"/api/v0/"+cmd+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(resp)
+}
+
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxContentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxContentSize {
+		return nil, errContentTooLarge
+	}
+	return data, nil
+}
+
+// checkResponse return the error message of IPFS if status not OK
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var res struct {
+		Message string
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&res); err == nil && len(res.Message) > 0 {
+		return fmt.Errorf("ipfs: %s", res.Message)
+	}
+	return fmt.Errorf("ipfs: %s", resp.Status)
+}
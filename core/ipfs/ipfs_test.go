@@ -0,0 +1,113 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package ipfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestDaemon return a fake IPFS daemon which store content in memory
+func newTestDaemon(store map[string][]byte, pins map[string]bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v0/add", func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, `{"Message":"file missing"}`, http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(f)
+		cid := "bafy" + string(rune('a'+len(store)))
+		store[cid] = data
+		if r.URL.Query().Get("pin") == "true" {
+			pins[cid] = true
+		}
+		w.Write([]byte(`{"Name":"chunk","Hash":"` + cid + `","Size":"1"}`))
+	})
+	mux.HandleFunc("/api/v0/cat", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := store[r.URL.Query().Get("arg")]
+		if !ok {
+			http.Error(w, `{"Message":"not found"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	})
+	mux.HandleFunc("/api/v0/pin/add", func(w http.ResponseWriter, r *http.Request) {
+		pins[r.URL.Query().Get("arg")] = true
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/api/v0/pin/rm", func(w http.ResponseWriter, r *http.Request) {
+		delete(pins, r.URL.Query().Get("arg"))
+		w.Write([]byte(`{}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestResolver(t *testing.T) {
+	store, pins := make(map[string][]byte), make(map[string]bool)
+	daemon := newTestDaemon(store, pins)
+	defer daemon.Close()
+
+	r := New(daemon.URL)
+	cid, err := r.Put([]byte("hello"))
+	if err != nil {
+		t.Fatal("put fail", err)
+	}
+	if !pins[cid] {
+		t.Error("content should be pinned on put")
+	}
+	if data, err := r.Get(cid); err != nil || string(data) != "hello" {
+		t.Error("get fail", err)
+	}
+	if err := r.Unpin(cid); err != nil || pins[cid] {
+		t.Error("unpin fail", err)
+	}
+	if err := r.Pin(cid); err != nil || !pins[cid] {
+		t.Error("pin fail", err)
+	}
+	r.SetPinOnPut(false)
+	if cid, err = r.Put([]byte("world")); err != nil || pins[cid] {
+		t.Error("content should not be pinned on put", err)
+	}
+	if _, err := r.Get("bafynotexist"); err == nil || err.Error() != "ipfs: not found" {
+		t.Error("err should be from ipfs", err)
+	}
+}
+
+func TestResolver_Gateway(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/bafyx" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("from gateway"))
+	}))
+	defer gateway.Close()
+	brokenGateway := httptest.NewServer(http.NotFoundHandler())
+	defer brokenGateway.Close()
+
+	// api not reachable, so get from gateways in order
+	r := New("http://127.0.0.1:1", brokenGateway.URL, gateway.URL+"/")
+	if data, err := r.Get("bafyx"); err != nil || string(data) != "from gateway" {
+		t.Error("get from gateway fail", err)
+	}
+	if _, err := r.Get("bafyy"); err == nil {
+		t.Error("get not exist content should fail")
+	}
+}
@@ -17,9 +17,9 @@
 package core
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/crypto"
@@ -28,10 +28,19 @@ import (
 
 // Message is valid msg in pdu
 type Message struct {
-	SenderID  common.Hash       `json:"senderID"`
-	Reference []*MsgReference   `json:"reference"`
-	Value     *MsgValue         `json:"value"`
-	Signature *crypto.Signature `json:"signature"`
+	SenderID    common.Hash       `json:"senderID"`
+	Reference   []*MsgReference   `json:"reference"`
+	Value       *MsgValue         `json:"value"`
+	Timestamp   uint64            `json:"timestamp,omitempty"`   // wall-clock hint in unix seconds, 0 if not set
+	Nonce       uint64            `json:"nonce,omitempty"`       // used by PoW, see CreateMsgWithPoW
+	Network     uint64            `json:"network,omitempty"`     // signed with msg, NetworkMain if not set
+	Expiry      uint64            `json:"expiry,omitempty"`      // sequence distance the content expire after, 0 never
+	ContentHash []byte            `json:"contentHash,omitempty"` // sha256 of content, signed instead of content if Expiry set
+	Device      *Auth             `json:"device,omitempty"`      // public key of device signed msg, nil if signed by master key
+	Signature   *crypto.Signature `json:"signature"`
+
+	id     common.Hash   // cached by Seal
+	hasher common.Hasher // hasher of universe the msg in, set by Seal
 }
 
 // MsgReference is the msg before current msg
@@ -40,9 +49,13 @@ type MsgReference struct {
 	MsgID    common.Hash `json:"msgID"`
 }
 
-// CreateMsg used to create a new msg by user in universe
+// CreateMsg used to create a new msg by user in universe, without PoW
 func CreateMsg(user *User, value *MsgValue, priKey *crypto.PrivateKey, refs ...*MsgReference) (*Message, error) {
+	return CreateMsgWithPoW(user, value, priKey, 0, refs...)
+}
 
+// newMsg build the msg not signed yet
+func newMsg(user *User, value *MsgValue, refs ...*MsgReference) *Message {
 	v := &MsgValue{
 		ContentType: value.ContentType,
 		Content:     value.Content,
@@ -56,36 +69,103 @@ func CreateMsg(user *User, value *MsgValue, priKey *crypto.PrivateKey, refs ...*
 		SenderID:  user.ID(),
 		Reference: rs,
 		Value:     v,
+		Timestamp: uint64(time.Now().Unix()),
 		Signature: nil,
+		hasher:    user.hasher,
 	}
+	return msg
+}
 
+// sign the msg by private key of sender, the compact signature is used if
+// engine can recover public key from it, see recoverMsgSigner
+func (msg *Message) sign(priKey *crypto.PrivateKey) error {
 	engine, err := utils.SelectEngine(priKey.Source)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	jsonMsg, err := json.Marshal(msg)
+	msg.Signature = nil
+	jsonMsg, err := msg.SignedBytes()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	var sig *crypto.Signature
+	if r, ok := engine.(crypto.Recoverer); ok {
+		sig, err = r.SignCompact(jsonMsg, priKey)
+	} else {
+		sig, err = engine.Sign(jsonMsg, priKey)
 	}
-	sig, err := engine.Sign(jsonMsg, priKey)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	sig.PubKey = nil
 	msg.Signature = sig
-	return msg, nil
+	return nil
+}
+
+// Sign the msg again by private key of sender, should be called after the
+// signed fields such as Timestamp are modified. The msg is sealed again.
+func (msg *Message) Sign(priKey *crypto.PrivateKey) error {
+	msg.Seal()
+	return msg.sign(priKey)
+}
+
+// signedReference is MsgReference as signed, see signedMessage
+type signedReference struct {
+	SenderID [common.HashLength]byte `json:"senderID"`
+	MsgID    [common.HashLength]byte `json:"msgID"`
+}
+
+// signedMessage is Message as signed, the hashes are encoded as arrays of
+// bytes like before Hash is marshalled as hex, so the msgs signed before still
+// verify. The fields must be kept same as Message.
+type signedMessage struct {
+	SenderID    [common.HashLength]byte `json:"senderID"`
+	Reference   []*signedReference      `json:"reference"`
+	Value       *MsgValue               `json:"value"`
+	Timestamp   uint64                  `json:"timestamp,omitempty"`
+	Nonce       uint64                  `json:"nonce,omitempty"`
+	Network     uint64                  `json:"network,omitempty"`
+	Expiry      uint64                  `json:"expiry,omitempty"`
+	ContentHash []byte                  `json:"contentHash,omitempty"`
+	Device      *Auth                   `json:"device,omitempty"`
+	Signature   *crypto.Signature       `json:"signature"`
+}
+
+// SignedBytes return the bytes signed by sender, which is the JSON of msg
+// without signature, and the content replaced by its hash if msg expire.
+// The hashes are arrays of bytes in the JSON, see signedMessage.
+func (msg Message) SignedBytes() ([]byte, error) {
+	msg.Signature = nil
+	m := msg.signedMsg()
+	sm := &signedMessage{
+		SenderID:    m.SenderID,
+		Value:       m.Value,
+		Timestamp:   m.Timestamp,
+		Nonce:       m.Nonce,
+		Network:     m.Network,
+		Expiry:      m.Expiry,
+		ContentHash: m.ContentHash,
+		Device:      m.Device,
+	}
+	if m.Reference != nil {
+		sm.Reference = make([]*signedReference, len(m.Reference))
+		for i, r := range m.Reference {
+			if r != nil {
+				sm.Reference[i] = &signedReference{SenderID: r.SenderID, MsgID: r.MsgID}
+			}
+		}
+	}
+	return json.Marshal(sm)
 }
 
 // VerifyMsg is used to valid the msg and the user
 func VerifyMsg(msg Message) (bool, error) {
 	signature := msg.Signature
-	msg.Signature = nil
 	engine, err := utils.SelectEngine(signature.Source)
 	if err != nil {
 		return false, err
 	}
-	jsonMsg, err := json.Marshal(&msg)
+	jsonMsg, err := msg.SignedBytes()
 	if err != nil {
 		return false, err
 	}
@@ -93,16 +173,114 @@ func VerifyMsg(msg Message) (bool, error) {
 
 }
 
-// ID is the id of msg based on content and author info
+// recoverMsgSigner recover the public key from the compact signature of msg,
+// crypto.ErrSigNotRecoverable is returned if the engine of signature can not
+// recover public key, or the signature is not compact such as the DER
+// signature of BTC created before.
+func recoverMsgSigner(msg *Message) (*crypto.PublicKey, error) {
+	engine, err := utils.SelectEngine(msg.Signature.Source)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := engine.(crypto.Recoverer)
+	if !ok {
+		return nil, crypto.ErrSigNotRecoverable
+	}
+	jsonMsg, err := msg.SignedBytes()
+	if err != nil {
+		return nil, err
+	}
+	return r.RecoverPubKey(jsonMsg, msg.Signature)
+}
+
+// TimeHint return the wall-clock time when msg created, claimed by sender.
+// The time is only a hint for display, the order of msgs depends on time proof.
+func (msg Message) TimeHint() (time.Time, bool) {
+	if msg.Timestamp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(msg.Timestamp), 0), true
+}
+
+// ID is the id of msg based on content and author info, the cached ID is
+// returned if msg is sealed.
 func (msg Message) ID() common.Hash {
-	hash := sha256.New()
+	if msg.sealed() {
+		return msg.id
+	}
+	return msg.hashID()
+}
+
+// Seal compute the ID of msg and cache it, so ID will not marshal and hash
+// the msg again. Msgs are sealed when created or decoded from JSON, Seal
+// should be called again if SenderID, Reference, Value or Network of msg
+// is modified after that. The ID is calculated by the hasher of msg, which
+// is the hasher of sender when created, see SealWith.
+func (msg *Message) Seal() common.Hash {
+	return msg.SealWith(msg.Hasher())
+}
+
+// SealWith bind the msg to hasher and seal it, the msg decoded from JSON
+// is bound to the default hasher, and should be sealed with the hasher of
+// universe before its ID be used, see Universe.Hasher.
+func (msg *Message) SealWith(h common.Hasher) common.Hash {
+	msg.hasher = h
+	msg.id = msg.hashID()
+	return msg.id
+}
+
+// Hasher return the hasher used to calculate the ID of msg, the default
+// hasher is returned if msg is not bound to any hasher
+func (msg Message) Hasher() common.Hasher {
+	if msg.hasher == nil {
+		return common.DefaultHasher()
+	}
+	return msg.hasher
+}
+
+// sealed return true if ID of msg is cached
+func (msg Message) sealed() bool {
+	return msg.hasher != nil
+}
+
+// UnmarshalJSON decode the msg, validate its schema and seal it
+func (msg *Message) UnmarshalJSON(data []byte) error {
+	type rawMessage Message
+	var m rawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*msg = Message(m)
+	if err := msg.validateSchema(); err != nil {
+		return err
+	}
+	msg.Seal()
+	return nil
+}
+
+func (msg Message) hashID() common.Hash {
+	hash := msg.Hasher().New()
 	hash.Reset()
 	var ref string
 	for _, r := range msg.Reference {
 		ref += fmt.Sprintf("%v%v", r.SenderID, r.MsgID)
 	}
-	val := fmt.Sprintf("%v", msg.Value)
+	val := fmt.Sprintf("%v", msg.signedValue())
 	hash.Write(append(append(msg.SenderID[:], ref...), val...))
+	// same msg on other network have diff ID, msgs on main network keep the ID
+	if msg.Network != NetworkMain {
+		hash.Write([]byte(fmt.Sprintf("network%d", msg.Network)))
+	}
+	// the content of ephemeral msg is replaced by its hash, so ID not
+	// change after content dropped
+	if msg.Expiry > 0 {
+		hash.Write([]byte(fmt.Sprintf("expiry%d%x", msg.Expiry, msg.ContentHash)))
+	}
+	// timestamp is signed, msgs differ only by timestamp should have diff ID,
+	// msgs without timestamp keep the ID
+	if msg.Timestamp > 0 {
+		hash.Write([]byte(fmt.Sprintf("timestamp%d", msg.Timestamp)))
+	}
 	return common.Bytes2Hash(hash.Sum(nil))
 }
 
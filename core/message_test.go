@@ -15,3 +15,204 @@
 // along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
 
 package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+func TestMessage_Seal(t *testing.T) {
+	engine, err := utils.SelectEngine(crypto.ETH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := CreateRootUser(*pubKey, "name", "extra")
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg, err := CreateMsg(user, value, priKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.sealed() || msg.ID() != msg.hashID() {
+		t.Error("created msg should be sealed")
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(msgBytes, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.sealed() || decoded.ID() != msg.ID() {
+		t.Error("decoded msg should be sealed with same ID")
+	}
+	unsealed := Message{SenderID: msg.SenderID, Value: msg.Value, Timestamp: msg.Timestamp}
+	if unsealed.sealed() || unsealed.ID() != msg.ID() {
+		t.Error("ID of unsealed msg should be computed")
+	}
+	// timestamp is signed, so it is part of ID once set
+	noHint := Message{SenderID: msg.SenderID, Value: msg.Value}
+	if noHint.ID() == msg.ID() {
+		t.Error("ID of msg without timestamp should be diff")
+	}
+	later := Message{SenderID: msg.SenderID, Value: msg.Value, Timestamp: msg.Timestamp + 1}
+	if later.ID() == msg.ID() || later.ID() == noHint.ID() {
+		t.Error("ID of msg with diff timestamp should be diff")
+	}
+	// cached ID is kept until sealed again
+	decoded.Value = &MsgValue{ContentType: TypeText, Content: []byte("world")}
+	if decoded.ID() != msg.ID() {
+		t.Error("ID should be cached")
+	}
+	if decoded.Seal() == msg.ID() || decoded.ID() == msg.ID() {
+		t.Error("ID should be changed after sealed again")
+	}
+}
+
+func TestMessage_RecoverSigner(t *testing.T) {
+	engine, err := utils.SelectEngine(crypto.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []*User
+	var priKeys []*crypto.PrivateKey
+	for i := 0; i < 2; i++ {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, priKeys = append(users, CreateRootUser(*pubKey, "name", "extra")), append(priKeys, priKey)
+	}
+	msg, err := CreateMsg(users[0], &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Signature.Signature) != crypto.CompactSignatureSize || msg.Signature.PubKey != nil {
+		t.Error("msg should be signed by compact signature without public key")
+	}
+	if pubKey, err := recoverMsgSigner(msg); err != nil || !users[0].Auth.Equal(Auth{PublicKey: *pubKey}) {
+		t.Error("public key of sender should be recovered", err)
+	}
+	if err := verifyMsgBySender(msg, users[0]); err != nil {
+		t.Error(err)
+	}
+	if err := verifyMsgBySender(msg, users[1]); err != ErrMsgSignatureNotValid {
+		t.Errorf("err should be %s, but get %v", ErrMsgSignatureNotValid, err)
+	}
+
+	// DER signature created before is verified by public key of sender
+	signed, err := msg.SignedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Signature, err = engine.Sign(signed, priKeys[0]); err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature.PubKey = nil
+	if _, err := recoverMsgSigner(msg); err != crypto.ErrSigNotRecoverable {
+		t.Errorf("err should be %s, but get %v", crypto.ErrSigNotRecoverable, err)
+	}
+	if err := verifyMsgBySender(msg, users[0]); err != nil {
+		t.Error(err)
+	}
+	if err := verifyMsgBySender(msg, users[1]); err != ErrMsgSignatureNotValid {
+		t.Errorf("err should be %s, but get %v", ErrMsgSignatureNotValid, err)
+	}
+}
+
+func TestMessage_Schema(t *testing.T) {
+	engine, err := utils.SelectEngine(crypto.ETH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := CreateRootUser(*pubKey, "name", "extra")
+	msg, err := CreateMsg(user, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, broken := range map[string]func(m *Message){
+		"senderID":          func(m *Message) { m.SenderID = common.Hash{} },
+		"value":             func(m *Message) { m.Value = nil },
+		"value.ContentType": func(m *Message) { m.Value = &MsgValue{ContentType: -1} },
+		"value.Content":     func(m *Message) { m.Value = &MsgValue{Content: make([]byte, MaxMsgContentSize+1)} },
+		"reference[0]":      func(m *Message) { m.Reference = []*MsgReference{nil} },
+		"contentHash":       func(m *Message) { m.ContentHash = []byte("hash") },
+		"signature":         func(m *Message) { m.Signature = nil },
+	} {
+		m := *msg
+		broken(&m)
+		msgBytes, err := json.Marshal(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Message
+		err = json.Unmarshal(msgBytes, &decoded)
+		if se, ok := err.(*SchemaError); !ok || se.Field != field {
+			t.Errorf("%s should not be valid, but get %v", field, err)
+		}
+		if common.CodeOf(err) != ErrSchemaNotValid.Code {
+			t.Errorf("code of %v should be %d", err, ErrSchemaNotValid.Code)
+		}
+	}
+	custom := *msg
+	custom.Value = &MsgValue{ContentType: typeEnd + 100}
+	msgBytes, err := json.Marshal(&custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(msgBytes, new(Message)); err != nil {
+		t.Error("msg of custom content type should be decoded", err)
+	}
+	if err := json.Unmarshal([]byte(`{"senderID":"garbage"}`), new(Message)); err == nil {
+		t.Error("garbage should not be decoded")
+	}
+}
+
+// baseline msg and the public key of its sender, created before Hash is
+// marshalled as hex string
+const (
+	baselineMsgJSON    = `{"senderID":[255,26,225,201,230,89,203,191,163,23,94,71,94,178,145,96,76,69,149,57,38,237,243,163,99,230,160,7,248,200,30,233],"reference":[{"senderID":[255,26,225,201,230,89,203,191,163,23,94,71,94,178,145,96,76,69,149,57,38,237,243,163,99,230,160,7,248,200,30,233],"msgID":[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,112,97,114,101,110,116]}],"value":{"ContentType":0,"Content":"aGVsbG8="},"signature":{"source":"ETH","sigType":"S2PK","pubKey":null,"signature":"p/R0+z+py7sCccCT66IiDHKrUyiq4OHOqQhB7F/RqyZHQEADhQG7SAYZljXgoa70hExrdU0d9n1CTAFGQHiN0wA="}}`
+	baselinePubKeyJSON = `{"pubKey":"04660e90eec55ce0015eed2bc2e1fba19fc4ff8f57a1986cd2079653742e6dce5ef78173c0a20ab7bb5c2d13924add5db2d024831ff6770a08b55ee4dabc9f54a8","sigType":"S2PK","source":"ETH"}`
+	baselineMsgID      = "9C3C77507C4CAFF3D92F42C4887B9DD884657C002F5B8DB1A3A3DAB40FE7F8AC"
+)
+
+func TestMessage_Baseline(t *testing.T) {
+	pubKey, err := utils.ParsePublicKeyJSON([]byte(baselinePubKeyJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var baseline Message
+	if err := json.Unmarshal([]byte(baselineMsgJSON), &baseline); err != nil {
+		t.Fatal("baseline msg should be decoded", err)
+	}
+	// the msg encoded again by current version should verify too
+	current, err := json.Marshal(&baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msgJSON := range [][]byte{[]byte(baselineMsgJSON), current} {
+		var msg Message
+		if err := json.Unmarshal(msgJSON, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if common.Hash2String(msg.ID()) != baselineMsgID {
+			t.Error("msg ID not match baseline", common.Hash2String(msg.ID()))
+		}
+		msg.Signature.PubKey = pubKey.PubKey
+		if ok, err := VerifyMsg(msg); err != nil || !ok {
+			t.Error("baseline signature should verify", err)
+		}
+	}
+}
@@ -23,6 +23,39 @@ const (
 	TypeBirth
 	// TypeEvidence is the type which contain the illegal evidence of user
 	TypeEvidence
+	// TypeUserStateUpdate is the type which set the public state of user in
+	// space-time, only the owner (time proof user) of space-time can send
+	TypeUserStateUpdate
+	// TypeFile is the type which contain one chunk of file, the chunk data can
+	// be stored out of msg by ContentResolver, only the CID kept in msg
+	TypeFile
+	// TypeRepost is the type which share the msg of other user, the original
+	// msg and its author are kept in content and should exist in universe
+	TypeRepost
+	// TypeNameClaim is the type which claim the handle of sender, the first
+	// claim in space-time owns the handle
+	TypeNameClaim
+	// TypeFollow is the type which follow the user, msgs of followed users
+	// are merged into the timeline of sender
+	TypeFollow
+	// TypeUnfollow is the type which stop following the user
+	TypeUnfollow
+	// TypeBirthReveal is the type which reveal the name and extra of user
+	// hidden in birth msg, only the user self can send
+	TypeBirthReveal
+	// TypeConsentRevoke is the type which revoke the consent signed by parent,
+	// before the birth msg be included
+	TypeConsentRevoke
+	// TypeDeviceAuth is the type which authorize the device key to sign msgs
+	// of sender, only the master key of sender can sign
+	TypeDeviceAuth
+	// TypeEdit is the type which replace the content of original msg sent by
+	// sender self, the original and all edits are kept as version chain
+	TypeEdit
+
+	// typeEnd is the number of known content types, new type should be added
+	// before it
+	typeEnd
 )
 
 // MsgValue is the mas value
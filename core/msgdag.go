@@ -0,0 +1,114 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/pdupub/go-pdu/common"
+)
+
+// noChild is the index of edge when the msg is not referenced yet
+const noChild = -1
+
+// msgDAG is the storage of msgs in universe. The IDs of msgs are interned into
+// the index of msg in the order added, and the references are kept in compact
+// adjacency slices of index, instead of vertices with maps of interface{}. A
+// msg can be added if at least one of its references exist, the references not
+// exist yet are linked when the msgs referred are added.
+type msgDAG struct {
+	index map[common.Hash]int32 // msg.id : index
+	ids   []common.Hash         // index : msg.id
+	msgs  []*Message            // index : msg
+
+	// the children of msg i are linked from firstChild[i] by edgeNext,
+	// edgeChild is the index of child on each edge
+	firstChild []int32
+	edgeChild  []int32
+	edgeNext   []int32
+
+	waiting map[common.Hash][]int32 // msg.id not exist : index of children
+}
+
+// newMsgDAG create the msgDAG by the initial msg, which is the only msg
+// without reference
+func newMsgDAG(msg *Message) *msgDAG {
+	d := &msgDAG{
+		index:   make(map[common.Hash]int32),
+		waiting: make(map[common.Hash][]int32),
+	}
+	d.insert(msg)
+	return d
+}
+
+// insert the msg as vertex without linking references, return the index
+func (d *msgDAG) insert(msg *Message) int32 {
+	i := int32(len(d.ids))
+	d.index[msg.ID()] = i
+	d.ids = append(d.ids, msg.ID())
+	d.msgs = append(d.msgs, msg)
+	d.firstChild = append(d.firstChild, noChild)
+	return i
+}
+
+// link add the edge from parent to child
+func (d *msgDAG) link(parent, child int32) {
+	d.edgeChild = append(d.edgeChild, child)
+	d.edgeNext = append(d.edgeNext, d.firstChild[parent])
+	d.firstChild[parent] = int32(len(d.edgeChild) - 1)
+}
+
+// add the msg into DAG, at least one reference of msg should exist
+func (d *msgDAG) add(msg *Message) error {
+	id := msg.ID()
+	if _, ok := d.index[id]; ok {
+		return ErrMsgAlreadyExist
+	}
+	if len(msg.Reference) == 0 {
+		return ErrMsgStructureNotValid
+	}
+	i := d.insert(msg)
+	for _, child := range d.waiting[id] {
+		d.link(i, child)
+	}
+	delete(d.waiting, id)
+	for _, r := range msg.Reference {
+		if p, ok := d.index[r.MsgID]; ok {
+			d.link(p, i)
+		} else {
+			d.waiting[r.MsgID] = append(d.waiting[r.MsgID], i)
+		}
+	}
+	return nil
+}
+
+// get return the msg by id, nil if not exist
+func (d *msgDAG) get(id common.Hash) *Message {
+	if i, ok := d.index[id]; ok {
+		return d.msgs[i]
+	}
+	return nil
+}
+
+// isTip return true if msg exist and not referenced by other msgs
+func (d *msgDAG) isTip(id common.Hash) bool {
+	i, ok := d.index[id]
+	return ok && d.firstChild[i] == noChild
+}
+
+// msgIDs return the id of msgs in the order added
+func (d *msgDAG) msgIDs() []common.Hash {
+	return d.ids
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "testing"
+
+func TestMsgDAG(t *testing.T) {
+	msgs := benchMsgs(4, 2)
+	d := newMsgDAG(msgs[0])
+	// msgs[3] refer msgs[2] not added yet and msgs[1]
+	for _, i := range []int{1, 3} {
+		if err := d.add(msgs[i]); err != nil {
+			t.Fatal("add msg fail", err)
+		}
+	}
+	if d.isTip(msgs[1].ID()) {
+		t.Error("msg referenced should not be tip")
+	}
+	if err := d.add(msgs[2]); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if d.isTip(msgs[2].ID()) || !d.isTip(msgs[3].ID()) {
+		t.Error("msg referenced before added should not be tip")
+	}
+	if err := d.add(msgs[2]); err != ErrMsgAlreadyExist {
+		t.Errorf("err should be %s, but get %s", ErrMsgAlreadyExist, err)
+	}
+	if err := d.add(&Message{id: benchVertexID(9), hasher: msgs[0].hasher}); err != ErrMsgStructureNotValid {
+		t.Errorf("err should be %s, but get %s", ErrMsgStructureNotValid, err)
+	}
+	ids := d.msgIDs()
+	for i, j := range []int{0, 1, 3, 2} {
+		if ids[i] != msgs[j].ID() || d.get(ids[i]) != msgs[j] {
+			t.Errorf("msg %d not match", i)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// Networks preset, the network is signed with msg, so msgs on test or dev
+// network can never be accepted into universe on main network.
+const (
+	// NetworkMain is the main network, msgs created before network be
+	// introduced have no network, so they are on main network
+	NetworkMain uint64 = iota
+	// NetworkTest is the public test network
+	NetworkTest
+	// NetworkDev is the local network for development
+	NetworkDev
+)
+
+var networkNames = map[uint64]string{
+	NetworkMain: "main",
+	NetworkTest: "test",
+	NetworkDev:  "dev",
+}
+
+// NetworkByName return the network by preset name (main, test or dev) or
+// number for custom network, main network if name is empty
+func NetworkByName(name string) (uint64, error) {
+	if name == "" {
+		return NetworkMain, nil
+	}
+	for network, n := range networkNames {
+		if n == name {
+			return network, nil
+		}
+	}
+	network, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0, ErrNetworkNotValid
+	}
+	return network, nil
+}
+
+// NetworkName return the preset name of network, or the number of custom network
+func NetworkName(network uint64) string {
+	if name, ok := networkNames[network]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", network)
+}
+
+// GenesisHash is the ID of universe by roots and network, which is same as
+// UniverseID on main network, so universes with same roots on diff networks
+// have diff IDs.
+func GenesisHash(network uint64, Eve, Adam *User) common.Hash {
+	id := UniverseID(Eve, Adam)
+	if network == NetworkMain {
+		return id
+	}
+	hash := Eve.Hasher().New()
+	hash.Write(id[:])
+	hash.Write([]byte(fmt.Sprintf("network%d", network)))
+	return common.Bytes2Hash(hash.Sum(nil))
+}
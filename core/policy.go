@@ -0,0 +1,83 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+// TimeProofPolicy is the choice of local user about the space-times (the ID of
+// space-time is the ID of time proof user). Only the trusted space-times are used,
+// and the primary one is used for ordering msgs, sequence query and prune.
+// All space-times are trusted if Trusted is empty.
+type TimeProofPolicy struct {
+	Trusted []common.Hash `json:"trusted"`
+	Primary common.Hash   `json:"primary"`
+}
+
+// NewTimeProofPolicy create policy by primary space-time and trusted space-times,
+// primary space-time is always trusted.
+func NewTimeProofPolicy(primary common.Hash, trusted ...common.Hash) *TimeProofPolicy {
+	p := &TimeProofPolicy{Primary: primary}
+	for _, stID := range trusted {
+		p.Trust(stID)
+	}
+	if len(p.Trusted) > 0 {
+		p.Trust(primary)
+	}
+	return p
+}
+
+// IsTrusted return true if the space-time is trusted
+func (p TimeProofPolicy) IsTrusted(stID common.Hash) bool {
+	if len(p.Trusted) == 0 {
+		return true
+	}
+	for _, id := range p.Trusted {
+		if id == stID {
+			return true
+		}
+	}
+	return false
+}
+
+// Trust add space-time into trusted list
+func (p *TimeProofPolicy) Trust(stID common.Hash) {
+	for _, id := range p.Trusted {
+		if id == stID {
+			return
+		}
+	}
+	p.Trusted = append(p.Trusted, stID)
+}
+
+// Distrust remove space-time from trusted list, primary space-time can not be removed
+func (p *TimeProofPolicy) Distrust(stID common.Hash) error {
+	if stID == p.Primary {
+		return ErrDistrustPrimarySpaceTime
+	}
+	for i, id := range p.Trusted {
+		if id == stID {
+			p.Trusted = append(p.Trusted[:i], p.Trusted[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// copy return a deep copy of policy
+func (p TimeProofPolicy) copy() *TimeProofPolicy {
+	return &TimeProofPolicy{Primary: p.Primary, Trusted: append([]common.Hash{}, p.Trusted...)}
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+func TestTimeProofPolicy(t *testing.T) {
+	st0, st1, st2 := common.Bytes2Hash([]byte{1}), common.Bytes2Hash([]byte{2}), common.Bytes2Hash([]byte{3})
+	p := NewTimeProofPolicy(st0)
+	if !p.IsTrusted(st1) || !p.IsTrusted(st2) {
+		t.Error("all space-time should be trusted if trusted list is empty")
+	}
+	p = NewTimeProofPolicy(st0, st1)
+	if !p.IsTrusted(st0) || !p.IsTrusted(st1) {
+		t.Error("primary and st1 should be trusted")
+	}
+	if p.IsTrusted(st2) {
+		t.Error("st2 should not be trusted")
+	}
+	if err := p.Distrust(st0); err != ErrDistrustPrimarySpaceTime {
+		t.Errorf("err should be %s, but get %s", ErrDistrustPrimarySpaceTime, err)
+	}
+	if err := p.Distrust(st1); err != nil || p.IsTrusted(st1) {
+		t.Error("distrust st1 fail", err)
+	}
+	p.Trust(st2)
+	p.Trust(st2)
+	if len(p.Trusted) != 2 {
+		t.Error("trusted list should contain 2 space-time, but get", len(p.Trusted))
+	}
+}
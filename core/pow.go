@@ -0,0 +1,98 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// MaxPoWDifficulty is the max leading zero bits of PoW hash can be required,
+// so msg can be created in reasonable time.
+const MaxPoWDifficulty = 32
+
+// PoWHash is the hash of msg ID and nonce, which should meet the difficulty
+// of msg content type in universe.
+func (msg Message) PoWHash() common.Hash {
+	return powHash(msg.ID(), msg.Nonce)
+}
+
+func powHash(msgID common.Hash, nonce uint64) common.Hash {
+	hash := common.NewHash()
+	nonceBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(nonceBytes, nonce)
+	hash.Write(msgID[:])
+	hash.Write(nonceBytes)
+	return common.Bytes2Hash(hash.Sum(nil))
+}
+
+// leadingZeroBits return the count of leading zero bits of hash
+func leadingZeroBits(h common.Hash) int {
+	for i, b := range h {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return common.HashLength * 8
+}
+
+// meetDifficulty return true if the PoW hash meet the difficulty
+func meetDifficulty(msgID common.Hash, nonce uint64, difficulty uint8) bool {
+	return difficulty == 0 || leadingZeroBits(powHash(msgID, nonce)) >= int(difficulty)
+}
+
+// CreateMsgWithPoW create msg as CreateMsg, and grind the nonce until the
+// PoW hash of msg meet the difficulty before the msg be signed.
+func CreateMsgWithPoW(user *User, value *MsgValue, priKey *crypto.PrivateKey, difficulty uint8, refs ...*MsgReference) (*Message, error) {
+	return CreateMsgOnNetwork(NetworkMain, user, value, priKey, difficulty, refs...)
+}
+
+// CreateMsgOnNetwork create msg with PoW as CreateMsgWithPoW, the network is
+// signed with msg, so it can not be accepted by universe on other network.
+func CreateMsgOnNetwork(network uint64, user *User, value *MsgValue, priKey *crypto.PrivateKey, difficulty uint8, refs ...*MsgReference) (*Message, error) {
+	if difficulty > MaxPoWDifficulty {
+		return nil, ErrPoWDifficultyTooHigh
+	}
+	msg := newMsg(user, value, refs...)
+	msg.Network = network
+	if err := msg.grindAndSign(priKey, difficulty); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// grindAndSign seal the msg, grind the nonce until PoW hash meet the
+// difficulty, then sign the msg
+func (msg *Message) grindAndSign(priKey *crypto.PrivateKey, difficulty uint8) error {
+	// nonce is not part of msg ID
+	msgID := msg.Seal()
+	for !meetDifficulty(msgID, msg.Nonce, difficulty) {
+		msg.Nonce++
+	}
+	return msg.sign(priKey)
+}
+
+// validatePoW check the PoW hash of msg meet the difficulty of its content type
+func validatePoW(u *Universe, msg *Message) error {
+	if !meetDifficulty(msg.ID(), msg.Nonce, u.config.Difficulty(msg.Value.ContentType)) {
+		return ErrMsgPoWNotValid
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+// Codes of Rejection, describe which stage of validation pipeline the msg be rejected
+const (
+	// RejectUnknown is the msg rejected for unknown reason
+	RejectUnknown = iota
+	// RejectStructure is the msg with value, signature or reference missing
+	RejectStructure
+	// RejectSignature is the msg not signed by sender
+	RejectSignature
+	// RejectSender is the msg from user not exist in universe
+	RejectSender
+	// RejectDuplicate is the msg already exist in universe
+	RejectDuplicate
+	// RejectReference is the msg reference the msg not exist in universe
+	RejectReference
+	// RejectRule is the msg break the validation rule in universe config
+	RejectRule
+	// RejectContent is the msg can not be processed by handler of its content type
+	RejectContent
+)
+
+// Rules can be broken by msg, used in Rejection
+const (
+	RuleSelfRefRequired = "selfRefRequired"
+	RuleTimestampHint   = "timestampHint"
+	RuleProofOfWork     = "proofOfWork"
+	RuleNetwork         = "network"
+	RuleExpiry          = "expiry"
+	RuleConsentWindow   = "consentWindow"
+	RuleDeviceExpiry    = "deviceExpiry"
+	RuleFilter          = "filter"
+)
+
+// Rejection is the structured reason why msg be rejected by universe,
+// which can be sent back to the peer who send this msg.
+type Rejection struct {
+	MsgID     common.Hash   `json:"msgID"`
+	Code      int           `json:"code"`
+	Reason    string        `json:"reason"`
+	Reference *MsgReference `json:"reference,omitempty"`
+	Rule      string        `json:"rule,omitempty"`
+}
+
+// Reject build the rejection of msg by the error returned from Validate or Commit
+func (u Universe) Reject(msg *Message, err error) *Rejection {
+	r := &Rejection{MsgID: msg.ID(), Code: RejectUnknown}
+	if err != nil {
+		r.Reason = err.Error()
+	}
+	switch err {
+	case ErrMsgStructureNotValid:
+		r.Code = RejectStructure
+	case ErrMsgSignatureNotValid, ErrDeviceNotAuthorized:
+		r.Code = RejectSignature
+	case ErrUserNotExist:
+		r.Code = RejectSender
+	case ErrMsgAlreadyExist:
+		r.Code = RejectDuplicate
+	case ErrSelfRefMissing:
+		r.Code = RejectRule
+		r.Rule = RuleSelfRefRequired
+		if lastMsgID, ok := u.lastMsg[msg.SenderID]; ok {
+			r.Reference = &MsgReference{SenderID: msg.SenderID, MsgID: lastMsgID}
+		}
+	case ErrMsgPoWNotValid:
+		r.Code = RejectRule
+		r.Rule = RuleProofOfWork
+	case ErrMsgNetworkNotMatch:
+		r.Code = RejectRule
+		r.Rule = RuleNetwork
+	case ErrMsgExpiryNotValid:
+		r.Code = RejectRule
+		r.Rule = RuleExpiry
+	case ErrConsentExpired:
+		r.Code = RejectRule
+		r.Rule = RuleConsentWindow
+	case ErrDeviceAuthExpired:
+		r.Code = RejectRule
+		r.Rule = RuleDeviceExpiry
+	case ErrMsgFiltered:
+		r.Code = RejectRule
+		r.Rule = RuleFilter
+	case ErrMsgTimestampTooEarly, ErrMsgTimestampDrift:
+		r.Code = RejectRule
+		r.Rule = RuleTimestampHint
+	default:
+		for _, ref := range msg.Reference {
+			if ref != nil && !u.HasMsg(ref.MsgID) {
+				r.Code = RejectReference
+				r.Reference = &MsgReference{SenderID: ref.SenderID, MsgID: ref.MsgID}
+				return r
+			}
+		}
+		if msg.Value != nil {
+			if _, ok := u.handlers[msg.Value.ContentType]; ok {
+				r.Code = RejectContent
+			}
+		}
+	}
+	return r
+}
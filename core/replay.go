@@ -0,0 +1,133 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ArchiveEntry is one entry of msg archive, the first entry of archive must
+// contain the root users, then msgs in the order they be added into universe,
+// and the checkpoints as expected state after the msgs before it. The hasher
+// of universe is recorded with the root users.
+type ArchiveEntry struct {
+	Roots      []*User     `json:"roots,omitempty"`
+	Hasher     string      `json:"hasher,omitempty"`
+	Msg        *Message    `json:"msg,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// ArchiveWriter write the entries of msg archive into w
+type ArchiveWriter struct {
+	enc *json.Encoder
+}
+
+// NewArchiveWriter create the archive writer and write root users as first entry
+func NewArchiveWriter(w io.Writer, Eve, Adam *User) (*ArchiveWriter, error) {
+	aw := &ArchiveWriter{enc: json.NewEncoder(w)}
+	if err := aw.enc.Encode(&ArchiveEntry{Roots: []*User{Eve, Adam}, Hasher: Eve.Hasher().Name()}); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// WriteMsg write msg into archive
+func (aw *ArchiveWriter) WriteMsg(msg *Message) error {
+	return aw.enc.Encode(&ArchiveEntry{Msg: msg})
+}
+
+// WriteCheckpoint write the expected checkpoint into archive
+func (aw *ArchiveWriter) WriteCheckpoint(cp *Checkpoint) error {
+	return aw.enc.Encode(&ArchiveEntry{Checkpoint: cp})
+}
+
+// ReplayDivergence is returned by Replay if the state root of replayed
+// universe not match the checkpoint in archive.
+type ReplayDivergence struct {
+	Step       int         // number of msgs applied before the checkpoint
+	Checkpoint *Checkpoint // the expected checkpoint
+	StateRoot  common.Hash // the state root of replayed universe, empty if seq not reached
+}
+
+func (d *ReplayDivergence) Error() string {
+	return fmt.Sprintf("state root diverged at seq %d of space-time %s after %d msgs, expect %s but get %s",
+		d.Checkpoint.Seq, common.Hash2String(d.Checkpoint.SpaceTimeID), d.Step,
+		common.Hash2String(d.Checkpoint.StateRoot), common.Hash2String(d.StateRoot))
+}
+
+// Unwrap return ErrReplayDiverged, so the code of error is kept
+func (d *ReplayDivergence) Unwrap() error {
+	return ErrReplayDiverged
+}
+
+// Replay re-apply the msgs in archive step by step into a new universe created
+// by cfg. The rejected msgs are returned with the reasons, and the replay stop at
+// the first checkpoint which not match, the error will be *ReplayDivergence.
+func Replay(r io.Reader, cfg UniverseConfig) (*Universe, []Rejection, error) {
+	return ReplayContext(context.Background(), r, cfg)
+}
+
+// ReplayContext is same as Replay, but stop with ctx.Err() once ctx done,
+// the universe replayed so far is returned.
+func ReplayContext(ctx context.Context, r io.Reader, cfg UniverseConfig) (*Universe, []Rejection, error) {
+	dec := json.NewDecoder(r)
+	var head ArchiveEntry
+	if err := dec.Decode(&head); err != nil {
+		return nil, nil, err
+	}
+	if len(head.Roots) != 2 {
+		return nil, nil, ErrArchiveRootsMissing
+	}
+	if head.Hasher != "" {
+		cfg.Hasher = head.Hasher
+	}
+	u, err := NewUniverseWithConfig(head.Roots[0], head.Roots[1], &cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rejections []Rejection
+	step := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return u, rejections, err
+		}
+		var entry ArchiveEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return u, rejections, err
+		}
+		if entry.Msg != nil {
+			step++
+			if err := u.AddMsg(entry.Msg); err != nil {
+				rejections = append(rejections, *u.Reject(entry.Msg, err))
+			}
+		}
+		if cp := entry.Checkpoint; cp != nil {
+			root, _ := u.StateRoot(cp.SpaceTimeID, cp.Seq)
+			if !root.Equal(cp.StateRoot) {
+				return u, rejections, &ReplayDivergence{Step: step, Checkpoint: cp, StateRoot: root}
+			}
+		}
+	}
+	return u, rejections, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+// Rollback unwind the msgs added after the time proof msg of checkpoint, the users
+// and time proof sequences created by those msgs are removed at the same time. The
+// universe is rebuilt by msgs before checkpoint, and not be changed if the state
+// root of rebuilt universe not match the checkpoint.
+//
+// If commit is not nil, it is called with the rebuilt universe before u is
+// replaced, such as to truncate the msgs in db, and u is not changed if commit
+// return error. The caller should hold the lock used by readers of u, since
+// u is replaced as whole.
+//
+// No undo entry is kept by Commit, so the rebuilt universe replay all msgs from
+// genesis to the checkpoint. The cost is linear to the msgs in universe instead
+// of the msgs unwound (see BenchmarkUniverse_Rollback), and the universe before
+// rollback is kept in memory until replay finished.
+func (u *Universe) Rollback(cp *Checkpoint, commit func(nu *Universe) error) error {
+	if u.msgD == nil || u.GetMsgByID(cp.MsgID) == nil {
+		return ErrMsgNotFound
+	}
+	ids := u.msgD.msgIDs()
+	pos := 0
+	for ; pos < len(ids); pos++ {
+		if ids[pos] == cp.MsgID {
+			break
+		}
+	}
+
+	nu, err := u.fork()
+	if err != nil {
+		return err
+	}
+	// msgs already be validated, commit directly
+	for _, id := range ids[:pos+1] {
+		if err := nu.Commit(&Receipt{MsgID: id, msg: u.GetMsgByID(id)}); err != nil {
+			return err
+		}
+	}
+	if root, err := nu.StateRoot(cp.SpaceTimeID, cp.Seq); err != nil {
+		return err
+	} else if !root.Equal(cp.StateRoot) {
+		return ErrCheckpointNotMatch
+	}
+	// keep the policy if primary space-time still exist
+	if err := nu.SetTimeProofPolicy(u.policy); err != nil {
+		nu.policy = &TimeProofPolicy{}
+	}
+	if commit != nil {
+		if err := commit(nu); err != nil {
+			return err
+		}
+	}
+	*u = *nu
+	return nil
+}
+
+// fork create an empty universe with same roots, config and validation
+// pipeline of u
+func (u Universe) fork() (*Universe, error) {
+	nu, err := NewUniverseWithConfig(u.roots[0], u.roots[1], u.config)
+	if err != nil {
+		return nil, err
+	}
+	nu.verifiers, nu.validators = u.verifiers, u.validators
+	nu.handlers = make(map[int]ContentHandler)
+	for contentType, handler := range u.handlers {
+		nu.handlers[contentType] = handler
+	}
+	if u.index != nil {
+		nu.EnableSearch()
+	}
+	return nu, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+const (
+	// MaxMsgContentSize is the max number of bytes of content in one msg
+	MaxMsgContentSize = 1024 * 128
+	// MaxMsgReferences is the max number of references in one msg
+	MaxMsgReferences = 1024
+	// MaxSignatureSize is the max number of bytes of signature
+	MaxSignatureSize = 1024 * 4
+	// MaxUserNameSize is the max number of bytes of user name
+	MaxUserNameSize = 256
+	// MaxUserExtraSize is the max number of bytes of user birth extra
+	MaxUserExtraSize = 1024
+)
+
+// SchemaError is returned if the JSON of msg, user or birth content not match
+// the schema, so the garbage is rejected when decoding instead of failing deep
+// inside verification.
+type SchemaError struct {
+	Type   string // name of type decoded
+	Field  string // json name of field not valid
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("schema of %s not valid: %s %s", e.Type, e.Field, e.Reason)
+}
+
+// Unwrap return ErrSchemaNotValid, so the code of error is kept
+func (e *SchemaError) Unwrap() error {
+	return ErrSchemaNotValid
+}
+
+func schemaError(typ, field, format string, args ...interface{}) error {
+	return &SchemaError{Type: typ, Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// validateSchema check the required fields, byte-length bounds and content
+// type of msg. The content type should not be negative, the types after the
+// known ones are left to the handlers set by Universe.SetContentHandler.
+func (msg Message) validateSchema() error {
+	if msg.SenderID == (common.Hash{}) {
+		return schemaError("Message", "senderID", "is required")
+	}
+	if msg.Value == nil {
+		return schemaError("Message", "value", "is required")
+	}
+	if msg.Value.ContentType < TypeText {
+		return schemaError("Message", "value.ContentType", "%d is unknown", msg.Value.ContentType)
+	}
+	if len(msg.Value.Content) > MaxMsgContentSize {
+		return schemaError("Message", "value.Content", "size %d exceed %d", len(msg.Value.Content), MaxMsgContentSize)
+	}
+	if len(msg.Reference) > MaxMsgReferences {
+		return schemaError("Message", "reference", "count %d exceed %d", len(msg.Reference), MaxMsgReferences)
+	}
+	for i, r := range msg.Reference {
+		if r == nil {
+			return schemaError("Message", fmt.Sprintf("reference[%d]", i), "is null")
+		}
+	}
+	if len(msg.ContentHash) != 0 && len(msg.ContentHash) != sha256.Size {
+		return schemaError("Message", "contentHash", "size %d should be %d", len(msg.ContentHash), sha256.Size)
+	}
+	if msg.Signature == nil || len(msg.Signature.Signature) == 0 {
+		return schemaError("Message", "signature", "is required")
+	}
+	if len(msg.Signature.Signature) > MaxSignatureSize {
+		return schemaError("Message", "signature", "size %d exceed %d", len(msg.Signature.Signature), MaxSignatureSize)
+	}
+	return nil
+}
+
+// validateSchema check the required fields and byte-length bounds of user
+func (u User) validateSchema() error {
+	if u.Auth == nil {
+		return schemaError("User", "auth", "is required")
+	}
+	if len(u.Name) > MaxUserNameSize {
+		return schemaError("User", "name", "size %d exceed %d", len(u.Name), MaxUserNameSize)
+	}
+	if len(u.BirthExtra) > MaxUserExtraSize {
+		return schemaError("User", "birthExtra", "size %d exceed %d", len(u.BirthExtra), MaxUserExtraSize)
+	}
+	if len(u.Commitment) != 0 && len(u.Commitment) != sha256.Size {
+		return schemaError("User", "commitment", "size %d should be %d", len(u.Commitment), sha256.Size)
+	}
+	return nil
+}
+
+// validateSchema check the signatures of parents, which can be empty before
+// signed by parents
+func (cb ContentBirth) validateSchema() error {
+	for i, p := range cb.Parents {
+		if len(p.Signature) > MaxSignatureSize {
+			return schemaError("ContentBirth", fmt.Sprintf("Parents[%d].Signature", i), "size %d exceed %d", len(p.Signature), MaxSignatureSize)
+		}
+		if len(p.Signature) != 0 && p.UserID == (common.Hash{}) {
+			return schemaError("ContentBirth", fmt.Sprintf("Parents[%d].UserID", i), "is required by signature")
+		}
+	}
+	return nil
+}
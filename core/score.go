@@ -0,0 +1,112 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core/rule"
+)
+
+const (
+	// ScoreDepthDecay is the ratio of score kept by each generation from roots
+	ScoreDepthDecay = 0.9
+
+	// ScoreMatureAge is the age in sequence steps of primary space-time, user
+	// get full score of age after it
+	ScoreMatureAge = rule.ReproductionInterval
+)
+
+// UserScore is the sybil-resistance score of user. Identities created in mass
+// are far from roots, young, and born from few ancestors, so their score is
+// low, which can be used by relay policy and rate limits to dampen them.
+type UserScore struct {
+	UserID    common.Hash `json:"userID"`
+	Depth     uint64      `json:"depth"`     // generations from roots by the deeper parent
+	Age       uint64      `json:"age"`       // sequence steps since birth in primary space-time
+	Diversity float64     `json:"diversity"` // ratio of distinct users in parents and grandparents
+	Score     float64     `json:"score"`     // product of the factors in [0, 1], roots get 1
+}
+
+// GetUserScore return the score of user by lineage depth, age and diversity
+// of parents. The user not in primary space-time has no age, so score is 0.
+func (u Universe) GetUserScore(userID common.Hash) (*UserScore, error) {
+	user := u.GetUserByID(userID)
+	if user == nil {
+		return nil, ErrUserNotExist
+	}
+	score := &UserScore{UserID: userID, Diversity: 1, Score: 1}
+	if u.isRoot(userID) {
+		return score, nil
+	}
+	score.Depth = u.lineageDepth(userID, make(map[common.Hash]uint64))
+	if info := u.GetUserInfo(userID, u.GetPrimarySpaceTime()); info != nil {
+		if maxSeq := u.GetPrimaryMaxSeq(); maxSeq > info.natureBirthSeq {
+			score.Age = maxSeq - info.natureBirthSeq
+		}
+	}
+	score.Diversity = u.parentDiversity(user)
+	ageFactor := math.Min(1, float64(score.Age)/float64(ScoreMatureAge))
+	score.Score = math.Pow(ScoreDepthDecay, float64(score.Depth)) * ageFactor * score.Diversity
+	return score, nil
+}
+
+// isRoot return true if user is Eve or Adam
+func (u Universe) isRoot(userID common.Hash) bool {
+	return userID == u.roots[0].ID() || userID == u.roots[1].ID()
+}
+
+// lineageDepth return the generations from roots by the deeper parent, the
+// depth of users passed are kept in memo
+func (u Universe) lineageDepth(userID common.Hash, memo map[common.Hash]uint64) uint64 {
+	if depth, ok := memo[userID]; ok {
+		return depth
+	}
+	var depth uint64
+	if user := u.GetUserByID(userID); user != nil && !u.isRoot(userID) {
+		for _, pid := range user.ParentsID() {
+			if d := u.lineageDepth(pid, memo) + 1; d > depth {
+				depth = d
+			}
+		}
+	}
+	memo[userID] = depth
+	return depth
+}
+
+// parentDiversity return the ratio of distinct users in parents and
+// grandparents, users born from siblings or close relatives get less.
+func (u Universe) parentDiversity(user *User) float64 {
+	var ancestors []common.Hash
+	for _, pid := range user.ParentsID() {
+		ancestors = append(ancestors, pid)
+		if parent := u.GetUserByID(pid); parent != nil && !u.isRoot(pid) {
+			for _, gid := range parent.ParentsID() {
+				ancestors = append(ancestors, gid)
+			}
+		}
+	}
+	distinct := make(map[common.Hash]bool)
+	for _, id := range ancestors {
+		distinct[id] = true
+	}
+	if len(ancestors) == 0 {
+		return 1
+	}
+	return float64(len(distinct)) / float64(len(ancestors))
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// SearchIndex is a simple inverted index from token to msg ID. The content of
+// TypeText msg and the name of user created by TypeBirth msg or revealed by
+// TypeBirthReveal msg are indexed.
+type SearchIndex struct {
+	tokens map[string]map[common.Hash]struct{}
+	order  map[common.Hash]uint64 // msg.id : the order msg be indexed
+}
+
+// NewSearchIndex create an empty search index
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		tokens: make(map[string]map[common.Hash]struct{}),
+		order:  make(map[common.Hash]uint64),
+	}
+}
+
+// tokenize split the text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Add index the text by msg ID, msg can only be indexed once
+func (si *SearchIndex) Add(msgID common.Hash, text string) {
+	if _, ok := si.order[msgID]; ok {
+		return
+	}
+	si.order[msgID] = uint64(len(si.order))
+	for _, token := range tokenize(text) {
+		if _, ok := si.tokens[token]; !ok {
+			si.tokens[token] = make(map[common.Hash]struct{})
+		}
+		si.tokens[token][msgID] = struct{}{}
+	}
+}
+
+// Remove the msg indexed by text, such as the msg whose content expired
+func (si *SearchIndex) Remove(msgID common.Hash, text string) {
+	for _, token := range tokenize(text) {
+		delete(si.tokens[token], msgID)
+		if len(si.tokens[token]) == 0 {
+			delete(si.tokens, token)
+		}
+	}
+}
+
+// Search return the IDs of msg which contain all words in query,
+// the msg indexed later will be returned first.
+func (si SearchIndex) Search(query string, limit int) []common.Hash {
+	var ids []common.Hash
+	tokens := tokenize(query)
+	if len(tokens) == 0 || limit <= 0 {
+		return ids
+	}
+	for id := range si.tokens[tokens[0]] {
+		matched := true
+		for _, token := range tokens[1:] {
+			if _, ok := si.tokens[token][id]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return si.order[ids[i]] > si.order[ids[j]]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// EnableSearch create the search index of universe, msgs already in universe will be indexed.
+func (u *Universe) EnableSearch() {
+	if u.index != nil {
+		return
+	}
+	u.index = NewSearchIndex()
+	if u.msgD == nil {
+		return
+	}
+	for _, id := range u.msgD.msgIDs() {
+		u.indexMsg(u.GetMsgByID(id))
+	}
+}
+
+// Search return the msgs match the query, ErrSearchNotEnable if search index not exist.
+func (u Universe) Search(query string, limit int) ([]*Message, error) {
+	if u.index == nil {
+		return nil, ErrSearchNotEnable
+	}
+	var msgs []*Message
+	for _, id := range u.index.Search(query, limit) {
+		if msg := u.GetMsgByID(id); msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// indexMsg add the content of msg into search index if search enabled
+func (u *Universe) indexMsg(msg *Message) {
+	if u.index == nil || msg == nil {
+		return
+	}
+	switch msg.Value.ContentType {
+	case TypeText:
+		u.index.Add(msg.ID(), string(msg.Value.Content))
+	case TypeBirth:
+		var contentBirth ContentBirth
+		if err := json.Unmarshal(msg.Value.Content, &contentBirth); err == nil {
+			u.index.Add(msg.ID(), contentBirth.User.Name)
+		}
+	case TypeBirthReveal:
+		var d BirthDisclosure
+		if err := json.Unmarshal(msg.Value.Content, &d); err == nil {
+			u.index.Add(msg.ID(), d.Name)
+		}
+	}
+}
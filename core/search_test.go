@@ -0,0 +1,48 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+func TestSearchIndex(t *testing.T) {
+	id0, id1, id2 := common.Bytes2Hash([]byte{1}), common.Bytes2Hash([]byte{2}), common.Bytes2Hash([]byte{3})
+	si := NewSearchIndex()
+	si.Add(id0, "Hello World!")
+	si.Add(id1, "hello, PDU")
+	si.Add(id2, "world of pdu")
+	si.Add(id2, "hello again")
+
+	if ids := si.Search("HELLO", 10); len(ids) != 2 || ids[0] != id1 || ids[1] != id0 {
+		t.Error("should get id1 and id0 in order, but get", ids)
+	}
+	if ids := si.Search("pdu world", 10); len(ids) != 1 || ids[0] != id2 {
+		t.Error("should only get id2, but get", ids)
+	}
+	if ids := si.Search("hello", 1); len(ids) != 1 || ids[0] != id1 {
+		t.Error("should only get the last one, but get", ids)
+	}
+	if ids := si.Search("again", 10); len(ids) != 0 {
+		t.Error("msg can only be indexed once")
+	}
+	if ids := si.Search("  ", 10); len(ids) != 0 {
+		t.Error("empty query should get nothing")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+// SeqProof is the proof of msg sequence in space-time, which can be verified
+// by light client with only the checkpoint of anchor sequence. Chain is the time
+// proof msgs from the checkpoint msg back to the one reached by msg, each of them
+// reference the next one which is one sequence before. Path is the msgs from msg
+// to that time proof msg by references, empty if msg is the time proof msg itself.
+type SeqProof struct {
+	SpaceTimeID common.Hash `json:"spacetimeID"`
+	MsgID       common.Hash `json:"msgID"`
+	Seq         uint64      `json:"seq"`
+	AnchorSeq   uint64      `json:"anchorSeq"`
+	Chain       []*Message  `json:"chain"`
+	Path        []*Message  `json:"path"`
+}
+
+// ProveSeq create the proof of msg sequence in the space-time of time proof
+// user, the chain start from the latest time proof msg, and can be trimmed to
+// the checkpoint known by light client.
+func (u Universe) ProveSeq(msgID common.Hash, tpUserID common.Hash) (*SeqProof, error) {
+	st, err := u.getSpaceTime(tpUserID)
+	if err != nil {
+		return nil, err
+	}
+	msg := u.GetMsgByID(msgID)
+	if msg == nil {
+		return nil, ErrMsgNotFound
+	}
+	memo := make(map[common.Hash]uint64)
+	seq := u.seenSeq(msgID, st, memo)
+	if seq == 0 {
+		return nil, ErrSeqNotFound
+	}
+	proof := &SeqProof{SpaceTimeID: tpUserID, MsgID: msgID, Seq: seq, AnchorSeq: st.maxTimeSequence}
+
+	// follow the references which reach the sequence until the time proof msg
+	for st.GetSeq(msg.ID()) != seq {
+		proof.Path = append(proof.Path, msg)
+		var next *Message
+		for _, r := range msg.Reference {
+			if memo[r.MsgID] == seq {
+				next = u.GetMsgByID(r.MsgID)
+				break
+			}
+		}
+		if next == nil {
+			return nil, ErrSeqNotFound
+		}
+		msg = next
+	}
+	tpID := msg.ID()
+
+	anchorID, ok := st.GetMsgIDBySeq(proof.AnchorSeq)
+	if !ok {
+		return nil, ErrSeqNotFound
+	}
+	for cur := anchorID; ; {
+		tp := u.GetMsgByID(cur)
+		if tp == nil {
+			return nil, ErrMsgNotFound
+		}
+		proof.Chain = append(proof.Chain, tp)
+		curSeq := st.GetSeq(cur)
+		if curSeq == seq {
+			if cur != tpID {
+				// the time proof msg reached is not in the chain of anchor
+				return nil, ErrSeqNotFound
+			}
+			break
+		}
+		found := false
+		for _, r := range tp.Reference {
+			if r.SenderID == tpUserID && st.GetSeq(r.MsgID) == curSeq-1 {
+				cur, found = r.MsgID, true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrSeqNotFound
+		}
+	}
+	return proof, nil
+}
+
+// Trim drop the time proof msgs after the checkpoint sequence from the chain,
+// so the proof start from the checkpoint known by light client.
+func (p *SeqProof) Trim(cpSeq uint64) error {
+	if cpSeq < p.Seq || cpSeq > p.AnchorSeq {
+		return ErrSeqNotFound
+	}
+	p.Chain = p.Chain[p.AnchorSeq-cpSeq:]
+	p.AnchorSeq = cpSeq
+	return nil
+}
+
+// VerifySeqProof verify the proof by the checkpoint, which should be trusted
+// by light client already. The msgs in proof are linked by the hash of msg
+// from the checkpoint msg to the msg proved, so no signature is checked.
+func VerifySeqProof(p *SeqProof, cp Checkpoint) (bool, error) {
+	if p.SpaceTimeID != cp.SpaceTimeID || p.AnchorSeq != cp.Seq || p.Seq == 0 || p.Seq > p.AnchorSeq {
+		return false, nil
+	}
+	if uint64(len(p.Chain)) != p.AnchorSeq-p.Seq+1 || p.Chain[0].ID() != cp.MsgID {
+		return false, nil
+	}
+	for i, tp := range p.Chain {
+		if tp.SenderID != p.SpaceTimeID {
+			return false, nil
+		}
+		if i > 0 && !referTo(p.Chain[i-1], tp) {
+			return false, nil
+		}
+	}
+	tp := p.Chain[len(p.Chain)-1]
+	if len(p.Path) == 0 {
+		return tp.ID() == p.MsgID, nil
+	}
+	if p.Path[0].ID() != p.MsgID {
+		return false, nil
+	}
+	for i, msg := range p.Path {
+		next := tp
+		if i+1 < len(p.Path) {
+			next = p.Path[i+1]
+		}
+		if !referTo(msg, next) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// referTo return true if msg reference the target msg
+func referTo(msg *Message, target *Message) bool {
+	targetID := target.ID()
+	for _, r := range msg.Reference {
+		if r.SenderID == target.SenderID && r.MsgID == targetID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+// GetSeq return the sequence of msg in the space-time. The sequence of time proof
+// msg is its own sequence, the sequence of other msg is the max sequence of time
+// proof msgs which can be reached by references, 0 if nothing be reached.
+func (u Universe) GetSeq(msgID common.Hash, spacetimeID common.Hash) (uint64, error) {
+	st, err := u.getSpaceTime(spacetimeID)
+	if err != nil {
+		return 0, err
+	}
+	if u.GetMsgByID(msgID) == nil {
+		return 0, ErrMsgNotFound
+	}
+	return u.seenSeq(msgID, st, make(map[common.Hash]uint64)), nil
+}
+
+// GetSeqWithMemo return the sequence of msg in the space-time as GetSeq, the
+// sequences of msgs reached are kept in memo, so the sequences of msgs refer
+// them are got without traversal again.
+func (u Universe) GetSeqWithMemo(msgID common.Hash, spacetimeID common.Hash, memo map[common.Hash]uint64) (uint64, error) {
+	st, err := u.getSpaceTime(spacetimeID)
+	if err != nil {
+		return 0, err
+	}
+	if u.GetMsgByID(msgID) == nil {
+		return 0, ErrMsgNotFound
+	}
+	return u.seenSeq(msgID, st, memo), nil
+}
+
+// MapSeq estimate the sequence of msg in space-time toTP by its sequence in space-time
+// fromTP. The cross references between time proof msgs of two space-times give the
+// bounds: time proof msg of fromTP which reach the msg sequence of toTP is lower bound,
+// time proof msg of toTP which reach the msg sequence of fromTP is upper bound. The middle
+// is returned if both bounds exist.
+func (u Universe) MapSeq(msgID common.Hash, fromTP common.Hash, toTP common.Hash) (uint64, error) {
+	fromST, err := u.getSpaceTime(fromTP)
+	if err != nil {
+		return 0, err
+	}
+	toST, err := u.getSpaceTime(toTP)
+	if err != nil {
+		return 0, err
+	}
+	if u.GetMsgByID(msgID) == nil {
+		return 0, ErrMsgNotFound
+	}
+	fromMemo, toMemo := make(map[common.Hash]uint64), make(map[common.Hash]uint64)
+	seq := u.seenSeq(msgID, fromST, fromMemo)
+	// the msg reach sequence of toTP by itself
+	lower := u.seenSeq(msgID, toST, toMemo)
+	var upper uint64
+	if seq > 0 {
+		for _, id := range fromST.timeProofD.GetIDs() {
+			if fromST.GetSeq(id.(common.Hash)) <= seq {
+				if s := u.seenSeq(id.(common.Hash), toST, toMemo); s > lower {
+					lower = s
+				}
+			}
+		}
+		for _, id := range toST.timeProofD.GetIDs() {
+			if u.seenSeq(id.(common.Hash), fromST, fromMemo) >= seq {
+				if s := toST.GetSeq(id.(common.Hash)); upper == 0 || s < upper {
+					upper = s
+				}
+			}
+		}
+	}
+	switch {
+	case lower > 0 && upper > lower:
+		return (lower + upper) / 2, nil
+	case lower > 0:
+		return lower, nil
+	case upper > 0:
+		return upper, nil
+	}
+	return 0, ErrSeqNotFound
+}
+
+// seenSeq return the max sequence of time proof msg in st, which can be reached
+// from msgID by references. Results of all msgs passed are kept in memo.
+func (u Universe) seenSeq(msgID common.Hash, st *SpaceTime, memo map[common.Hash]uint64) uint64 {
+	stack := []common.Hash{msgID}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		if _, ok := memo[id]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if seq := st.GetSeq(id); seq > 0 {
+			memo[id] = seq
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		msg := u.GetMsgByID(id)
+		if msg == nil {
+			memo[id] = 0
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		var maxSeq uint64
+		pending := false
+		for _, r := range msg.Reference {
+			if seq, ok := memo[r.MsgID]; !ok {
+				stack = append(stack, r.MsgID)
+				pending = true
+			} else if seq > maxSeq {
+				maxSeq = seq
+			}
+		}
+		if !pending {
+			memo[id] = maxSeq
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return memo[msgID]
+}
+
+// getSpaceTime return the space-time by ID
+func (u Universe) getSpaceTime(spacetimeID common.Hash) (*SpaceTime, error) {
+	if u.stD != nil {
+		if vertex := u.stD.GetVertex(spacetimeID); vertex != nil {
+			return vertex.Value().(*SpaceTime), nil
+		}
+	}
+	return nil, ErrSpaceTimeNotExist
+}
@@ -0,0 +1,272 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"runtime"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// Snapshot is the state of universe at a checkpoint, used by new node to
+// catch up without asking msgs one by one. The msgs committed until the
+// checkpoint are split into chunks by commit order, and the hash of each
+// chunk is listed, so chunks can be fetched from many peers and verified
+// one by one. The users and sequences of space-time are covered by the
+// state root of checkpoint, which is checked after the chunks replayed.
+type Snapshot struct {
+	Checkpoint Checkpoint        `json:"checkpoint"`
+	MsgCount   uint64            `json:"msgCount"`
+	ChunkSize  uint64            `json:"chunkSize"`
+	Chunks     []common.Hash     `json:"chunks"`
+	SignerID   common.Hash       `json:"signerID"`
+	Signature  *crypto.Signature `json:"signature"`
+}
+
+// ChunkHash return the hash of msgs in chunk, the msgs are hashed with the
+// signature, so the chunk can not be replaced by msgs with same IDs. The content
+// of ephemeral msgs is left out, since it may be dropped by some nodes.
+func ChunkHash(msgs []*Message) (common.Hash, error) {
+	hash := common.NewHash()
+	for _, msg := range msgs {
+		msgBytes, err := json.Marshal(msg.signedMsg())
+		if err != nil {
+			return common.Hash{}, err
+		}
+		hash.Write(msgBytes)
+	}
+	return common.Bytes2Hash(hash.Sum(nil)), nil
+}
+
+// ChunkCount return the number of chunks of msgCount msgs
+func ChunkCount(msgCount, chunkSize uint64) int {
+	if chunkSize == 0 {
+		return 0
+	}
+	return int((msgCount + chunkSize - 1) / chunkSize)
+}
+
+// ChunkRange return the order of first msg and the msg count of chunk i
+func (s Snapshot) ChunkRange(i int) (from uint64, count uint64) {
+	from = uint64(i) * s.ChunkSize
+	if from >= s.MsgCount {
+		return from, 0
+	}
+	count = s.MsgCount - from
+	if count > s.ChunkSize {
+		count = s.ChunkSize
+	}
+	return from, count
+}
+
+// Root return the hash of snapshot without signature, the peers serve the
+// same snapshot if the roots are same
+func (s Snapshot) Root() common.Hash {
+	s.SignerID, s.Signature = common.Hash{}, nil
+	hash := common.NewHash()
+	jsonSnapshot, _ := json.Marshal(&s)
+	hash.Write(jsonSnapshot)
+	return common.Bytes2Hash(hash.Sum(nil))
+}
+
+// VerifyChunk check the msgs by the hash of chunk i
+func (s Snapshot) VerifyChunk(i int, msgs []*Message) error {
+	if i < 0 || i >= len(s.Chunks) {
+		return ErrSnapshotChunkNotValid
+	}
+	if _, count := s.ChunkRange(i); uint64(len(msgs)) != count {
+		return ErrSnapshotChunkNotValid
+	}
+	hash, err := ChunkHash(msgs)
+	if err != nil {
+		return err
+	}
+	if !hash.Equal(s.Chunks[i]) {
+		return ErrSnapshotChunkNotValid
+	}
+	return nil
+}
+
+// Sign the snapshot by user, the checkpoint in snapshot is signed together
+func (s *Snapshot) Sign(user *User, priKey *crypto.PrivateKey) error {
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return err
+	}
+	s.SignerID = user.ID()
+	s.Signature = nil
+	jsonSnapshot, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	sig, err := engine.Sign(jsonSnapshot, priKey)
+	if err != nil {
+		return err
+	}
+	sig.PubKey = nil
+	s.Signature = sig
+	return nil
+}
+
+// VerifySnapshot verify the signature of snapshot by signer
+func VerifySnapshot(s Snapshot, signer *User) (bool, error) {
+	if s.Signature == nil {
+		return false, ErrSnapshotNotSigned
+	}
+	if s.SignerID != signer.ID() {
+		return false, nil
+	}
+	signature := *s.Signature
+	signature.PubKey = signer.Auth.PubKey
+	s.Signature = nil
+	engine, err := utils.SelectEngine(signature.Source)
+	if err != nil {
+		return false, err
+	}
+	jsonSnapshot, err := json.Marshal(&s)
+	if err != nil {
+		return false, err
+	}
+	return engine.Verify(jsonSnapshot, &signature)
+}
+
+// SnapshotBuilder replay the chunks of snapshot into a staging universe,
+// the universe is replaced by the staging one only after all chunks are
+// replayed and the state match the checkpoint of snapshot.
+type SnapshotBuilder struct {
+	snap    *Snapshot
+	staging *Universe
+	next    int
+	msgs    []*Message
+}
+
+// NewSnapshotBuilder create the builder with an empty staging universe,
+// which has the same roots, config and validation pipeline as u
+func (u Universe) NewSnapshotBuilder(snap *Snapshot) (*SnapshotBuilder, error) {
+	if snap.ChunkSize == 0 || len(snap.Chunks) != ChunkCount(snap.MsgCount, snap.ChunkSize) {
+		return nil, ErrSnapshotNotValid
+	}
+	staging, err := u.fork()
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotBuilder{snap: snap, staging: staging}, nil
+}
+
+// Next return the index of chunk should be added next
+func (b SnapshotBuilder) Next() int {
+	return b.next
+}
+
+// Done return true if all chunks are added
+func (b SnapshotBuilder) Done() bool {
+	return b.next == len(b.snap.Chunks)
+}
+
+// Msgs return the msgs replayed, in the order of snapshot
+func (b SnapshotBuilder) Msgs() []*Message {
+	return b.msgs
+}
+
+// AddChunk verify the msgs by hash of next chunk, then validate the msgs in
+// parallel and commit them into staging universe in order. The snapshot
+// should be dropped if any error returned.
+func (b *SnapshotBuilder) AddChunk(msgs []*Message) error {
+	if b.Done() {
+		return ErrSnapshotChunkNotValid
+	}
+	if err := b.snap.VerifyChunk(b.next, msgs); err != nil {
+		return err
+	}
+	receipts, errs := b.staging.validateAll(msgs)
+	for i, msg := range msgs {
+		receipt, err := receipts[i], errs[i]
+		if err == ErrUserNotExist {
+			// sender is born by the msgs before it in same chunk
+			receipt, err = b.staging.Validate(msg)
+		}
+		if err != nil {
+			return err
+		}
+		if err := b.staging.Commit(receipt); err != nil {
+			return err
+		}
+	}
+	b.msgs = append(b.msgs, msgs...)
+	b.next++
+	return nil
+}
+
+// Apply replace u by the staging universe, if all chunks are replayed, the
+// state match the checkpoint and the snapshot is signed by the owner of the
+// space-time. The time proof policy of u is kept if the primary space-time
+// exist after replayed.
+func (b *SnapshotBuilder) Apply(u *Universe) error {
+	if !b.Done() {
+		return ErrSnapshotNotComplete
+	}
+	cp := b.snap.Checkpoint
+	staged, err := b.staging.CreateCheckpoint(cp.SpaceTimeID, cp.Seq)
+	if err != nil {
+		return err
+	}
+	if !staged.MsgID.Equal(cp.MsgID) || !staged.StateRoot.Equal(cp.StateRoot) {
+		return ErrCheckpointNotMatch
+	}
+	signer := b.staging.GetUserByID(cp.SpaceTimeID)
+	if signer == nil || b.snap.SignerID != cp.SpaceTimeID {
+		return ErrSnapshotSignerNotValid
+	}
+	if ok, err := VerifySnapshot(*b.snap, signer); err != nil {
+		return err
+	} else if !ok {
+		return ErrSnapshotSignerNotValid
+	}
+	if err := b.staging.SetTimeProofPolicy(u.policy); err != nil {
+		b.staging.policy = &TimeProofPolicy{}
+	}
+	*u = *b.staging
+	return nil
+}
+
+// validateAll run Validate of msgs in parallel
+func (u *Universe) validateAll(msgs []*Message) ([]*Receipt, []error) {
+	receipts := make([]*Receipt, len(msgs))
+	errs := make([]error, len(msgs))
+	jobs := make(chan int, len(msgs))
+	for i := range msgs {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU() && w < len(msgs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				receipts[i], errs[i] = u.Validate(msgs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return receipts, errs
+}
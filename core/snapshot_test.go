@@ -0,0 +1,122 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+func TestSnapshotBuilder(t *testing.T) {
+	engine, err := utils.SelectEngine(crypto.ETH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots [2]*User
+	var keys [2]*crypto.PrivateKey
+	for roots[0] == nil || roots[1] == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := CreateRootUser(*pubKey, "root", "")
+		i := 0
+		if user.Gender() {
+			i = 1
+		}
+		roots[i], keys[i] = user, priKey
+	}
+	u, err := NewUniverse(roots[0], roots[1])
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	var msgs []*Message
+	var refs []*MsgReference
+	for i := 0; i < 7; i++ {
+		value := &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("tp:%d", i))}
+		msg, _ := CreateMsg(roots[0], value, keys[0], refs...)
+		if err := u.AddMsg(msg); err != nil {
+			t.Fatal("add msg fail", err)
+		}
+		msgs = append(msgs, msg)
+		refs = []*MsgReference{{SenderID: msg.SenderID, MsgID: msg.ID()}}
+	}
+	cp, err := u.CreateCheckpoint(roots[0].ID(), 5)
+	if err != nil {
+		t.Fatal("create checkpoint fail", err)
+	}
+	snap := &Snapshot{Checkpoint: *cp, MsgCount: 5, ChunkSize: 2}
+	for i := 0; i < ChunkCount(snap.MsgCount, snap.ChunkSize); i++ {
+		from, count := snap.ChunkRange(i)
+		hash, err := ChunkHash(msgs[from : from+count])
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.Chunks = append(snap.Chunks, hash)
+	}
+	if len(snap.Chunks) != 3 {
+		t.Fatal("snapshot should have 3 chunks, but get", len(snap.Chunks))
+	}
+	root := snap.Root()
+	if err := snap.Sign(roots[0], keys[0]); err != nil {
+		t.Fatal("sign snapshot fail", err)
+	}
+	if snap.Root() != root {
+		t.Error("root of snapshot should not change by signature")
+	}
+
+	target, _ := NewUniverse(roots[0], roots[1])
+	b, err := target.NewSnapshotBuilder(snap)
+	if err != nil {
+		t.Fatal("create snapshot builder fail", err)
+	}
+	if err := b.AddChunk(msgs[1:3]); err != ErrSnapshotChunkNotValid {
+		t.Errorf("err should be %s, but get %s", ErrSnapshotChunkNotValid, err)
+	}
+	if err := b.Apply(target); err != ErrSnapshotNotComplete {
+		t.Errorf("err should be %s, but get %s", ErrSnapshotNotComplete, err)
+	}
+	for i := 0; !b.Done(); i++ {
+		from, count := snap.ChunkRange(b.Next())
+		if err := b.AddChunk(msgs[from : from+count]); err != nil {
+			t.Fatal("add chunk fail", i, err)
+		}
+	}
+	if target.HasMsg(msgs[0].ID()) {
+		t.Error("universe should not be changed before snapshot applied")
+	}
+	unsigned := *b
+	unsignedSnap := *snap
+	unsignedSnap.SignerID, unsignedSnap.Signature = common.Hash{}, nil
+	unsigned.snap = &unsignedSnap
+	if err := unsigned.Apply(target); err != ErrSnapshotSignerNotValid {
+		t.Errorf("err should be %s, but get %s", ErrSnapshotSignerNotValid, err)
+	}
+	if err := b.Apply(target); err != nil {
+		t.Fatal("apply snapshot fail", err)
+	}
+	if target.GetMaxSeq(roots[0].ID()) != 5 || !target.HasMsg(msgs[4].ID()) || target.HasMsg(msgs[5].ID()) {
+		t.Error("universe should be replaced by the snapshot")
+	}
+	if err := target.AddMsg(msgs[5]); err != nil {
+		t.Error("msgs after snapshot should be added", err)
+	}
+}
@@ -150,6 +150,25 @@ func (s SpaceTime) GetUserInfo(userID common.Hash) *UserInfo {
 	return nil
 }
 
+// GetSeq returns the time sequence of time proof msg, 0 if msg is not time proof in this space-time
+func (s SpaceTime) GetSeq(msgID common.Hash) uint64 {
+	if tp := s.timeProofD.GetVertex(msgID); tp != nil {
+		return tp.Value().(uint64)
+	}
+	return 0
+}
+
+// GetMsgIDBySeq returns the ID of time proof msg by time sequence, the first one
+// will be returned if more than one msg have same sequence.
+func (s SpaceTime) GetMsgIDBySeq(seq uint64) (common.Hash, bool) {
+	for _, id := range s.timeProofD.GetIDs() {
+		if s.timeProofD.GetVertex(id).Value().(uint64) == seq {
+			return id.(common.Hash), true
+		}
+	}
+	return common.Hash{}, false
+}
+
 // UpdateTimeProof update the tp info
 func (s *SpaceTime) UpdateTimeProof(msg *Message) error {
 	var currentSeq uint64 = 1
@@ -181,35 +200,44 @@ func (s *SpaceTime) UpdateTimeProof(msg *Message) error {
 
 // AddUser add user info to this space time
 func (s *SpaceTime) AddUser(ref *MsgReference, contentBirth ContentBirth, user *User) error {
-	if tp := s.timeProofD.GetVertex(ref.MsgID); tp != nil {
-		msgSeq := tp.Value().(uint64)
-		p0 := s.userStateD.GetVertex(contentBirth.Parents[0].UserID)
-		if p0 == nil {
-			return ErrAddUserToSpaceTimeFail
-		}
-		userInfo0 := p0.Value().(*UserInfo)
-		p1 := s.userStateD.GetVertex(contentBirth.Parents[1].UserID)
-		if p1 == nil {
-			return ErrAddUserToSpaceTimeFail
-		}
-		userInfo1 := p1.Value().(*UserInfo)
-		if userInfo0.natureBirthSeq+userInfo0.natureLifeMaxSeq > msgSeq &&
-			userInfo1.natureBirthSeq+userInfo1.natureLifeMaxSeq > msgSeq &&
-			msgSeq-userInfo0.natureLastCosign > rule.ReproductionInterval &&
-			msgSeq-userInfo1.natureLastCosign > rule.ReproductionInterval {
-			// update nature last cosign number as msgSeq
-			userInfo0.natureLastCosign = msgSeq
-			userInfo1.natureLastCosign = msgSeq
-			// add user in this st
-			userVertex, err := dag.NewVertex(user.ID(), NewUserInfo(user.Name, user.LifeTime, msgSeq), p0, p1)
-			if err != nil {
-				return err
-			}
-			if err := s.userStateD.AddVertex(userVertex); err != nil {
-				return err
-			}
-			return nil
-		}
+	msgSeq, p0, p1, err := s.birthParents(ref, contentBirth)
+	if err != nil {
+		return err
 	}
-	return ErrAddUserToSpaceTimeFail
+	// update nature last cosign number as msgSeq
+	p0.Value().(*UserInfo).natureLastCosign = msgSeq
+	p1.Value().(*UserInfo).natureLastCosign = msgSeq
+	// add user in this st
+	userVertex, err := dag.NewVertex(user.ID(), NewUserInfo(user.Name, user.LifeTime, msgSeq), p0, p1)
+	if err != nil {
+		return err
+	}
+	return s.userStateD.AddVertex(userVertex)
+}
+
+// birthParents return the seq of ref and the parents, if the parents alive
+// and can reproduce at ref in this space time by the nature rule
+func (s *SpaceTime) birthParents(ref *MsgReference, contentBirth ContentBirth) (uint64, *dag.Vertex, *dag.Vertex, error) {
+	tp := s.timeProofD.GetVertex(ref.MsgID)
+	if tp == nil {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	msgSeq := tp.Value().(uint64)
+	p0 := s.userStateD.GetVertex(contentBirth.Parents[0].UserID)
+	if p0 == nil {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	userInfo0 := p0.Value().(*UserInfo)
+	p1 := s.userStateD.GetVertex(contentBirth.Parents[1].UserID)
+	if p1 == nil {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	userInfo1 := p1.Value().(*UserInfo)
+	if userInfo0.natureBirthSeq+userInfo0.natureLifeMaxSeq > msgSeq &&
+		userInfo1.natureBirthSeq+userInfo1.natureLifeMaxSeq > msgSeq &&
+		msgSeq-userInfo0.natureLastCosign > rule.ReproductionInterval &&
+		msgSeq-userInfo1.natureLastCosign > rule.ReproductionInterval {
+		return msgSeq, p0, p1, nil
+	}
+	return 0, nil, nil, ErrAddUserToSpaceTimeFail
 }
@@ -17,7 +17,9 @@
 package core
 
 import (
+	"bytes"
 	"encoding/json"
+	"time"
 
 	dag "github.com/pdupub/go-dag"
 	"github.com/pdupub/go-pdu/common"
@@ -31,21 +33,66 @@ import (
 // this message should be valid at least in one of spacetime in stD. Information in local universe
 // is only part of information in whole decentralized system.
 type Universe struct {
-	msgD  *dag.DAG // contain all messages valid in at least one spacetime
-	userD *dag.DAG // contain all users valid in at least one spacetime (strict)
-	stD   *dag.DAG // contain all spacetime, which could be diff by selecting (strict)
+	roots   [2]*User                      // root users, Eve and Adam
+	msgD    *msgDAG                       // contain all messages valid in at least one spacetime
+	userD   *dag.DAG                      // contain all users valid in at least one spacetime (strict)
+	stD     *dag.DAG                      // contain all spacetime, which could be diff by selecting (strict)
+	lastMsg map[common.Hash]common.Hash   // user.id : id of last msg from this user
+	reposts map[common.Hash][]common.Hash // msg.id : ids of repost msgs of this msg
+	edits   map[common.Hash][]common.Hash // msg.id : ids of edit msgs of this msg, in order of added
+	names   map[common.Hash]*nameIndex    // spacetime.id : handles claimed in this spacetime
+	config  *UniverseConfig
+	hasher  common.Hasher // hasher of config, used by the IDs of msgs and users
+	policy  *TimeProofPolicy
+	index   *SearchIndex        // nil if search not enabled
+	filter  *common.BloomFilter // bloom filter of msg.ID, nil if not enabled
+	now     func() time.Time    // local clock, used to check timestamp hint of msg
+
+	following map[common.Hash]map[common.Hash]bool // user.id : users followed by this user
+	followers map[common.Hash]map[common.Hash]bool // user.id : users following this user
+
+	disclosures map[common.Hash]*BirthDisclosure       // user.id : name and extra revealed by hidden user
+	revoked     map[common.Hash]map[common.Hash]bool   // consent id : parents revoked the consent
+	born        map[common.Hash]bool                   // consent id of birth msgs included
+	devices     map[common.Hash]map[string]*deviceAuth // user.id : device key : authorization
+
+	ephemeral     map[common.Hash]bool // id of ephemeral msgs whose content not dropped yet
+	expiryChecked uint64               // max seq of primary space-time when expired msgs checked
+
+	commitSeqs map[common.Hash]uint64 // msg.id : local sequence when committed, from 1
+
+	verifiers  []Validator            // validators run in Validate
+	validators []Validator            // validators run in Commit
+	handlers   map[int]ContentHandler // content type : handler
 }
 
 // NewUniverse create Universe with two user with diff gender as root users
 func NewUniverse(Eve, Adam *User) (*Universe, error) {
-	if Eve.Gender() == Adam.Gender() {
+	return NewUniverseWithConfig(Eve, Adam, DefaultUniverseConfig())
+}
+
+// NewUniverseWithConfig create Universe with root users and the validation config.
+// The genders of root users are checked by the IDs calculated by the hasher of
+// config, and the root users are bound to the hasher once universe created.
+func NewUniverseWithConfig(Eve, Adam *User, config *UniverseConfig) (*Universe, error) {
+	if config == nil {
+		config = DefaultUniverseConfig()
+	}
+	hasher, err := common.SelectHasher(config.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	// root users are not changed until the universe created
+	eve, adam := *Eve, *Adam
+	eve.hasher, adam.hasher = hasher, hasher
+	if eve.Gender() == adam.Gender() {
 		return nil, ErrNotSupportYet
 	}
-	EveVertex, err := dag.NewVertex(Eve.ID(), Eve)
+	EveVertex, err := dag.NewVertex(eve.ID(), Eve)
 	if err != nil {
 		return nil, err
 	}
-	AdamVertex, err := dag.NewVertex(Adam.ID(), Adam)
+	AdamVertex, err := dag.NewVertex(adam.ID(), Adam)
 	if err != nil {
 		return nil, err
 	}
@@ -54,55 +101,185 @@ func NewUniverse(Eve, Adam *User) (*Universe, error) {
 		return nil, err
 	}
 	userD.SetMaxParentsCount(2)
-	return &Universe{userD: userD}, nil
+	u := &Universe{
+		roots:       [2]*User{Eve, Adam},
+		userD:       userD,
+		lastMsg:     make(map[common.Hash]common.Hash),
+		reposts:     make(map[common.Hash][]common.Hash),
+		edits:       make(map[common.Hash][]common.Hash),
+		names:       make(map[common.Hash]*nameIndex),
+		following:   make(map[common.Hash]map[common.Hash]bool),
+		followers:   make(map[common.Hash]map[common.Hash]bool),
+		ephemeral:   make(map[common.Hash]bool),
+		commitSeqs:  make(map[common.Hash]uint64),
+		disclosures: make(map[common.Hash]*BirthDisclosure),
+		revoked:     make(map[common.Hash]map[common.Hash]bool),
+		born:        make(map[common.Hash]bool),
+		devices:     make(map[common.Hash]map[string]*deviceAuth),
+		config:      config,
+		hasher:      hasher,
+		policy:      &TimeProofPolicy{},
+		now:         time.Now,
+		verifiers:   defaultVerifiers(),
+		validators:  defaultValidators(),
+		handlers:    defaultContentHandlers(),
+	}
+	if config.SearchEnable {
+		u.EnableSearch()
+	}
+	if config.BloomCapacity > 0 {
+		if u.filter, err = common.NewBloomFilter(config.BloomCapacity, config.BloomFPRate); err != nil {
+			return nil, err
+		}
+	}
+	Eve.hasher, Adam.hasher = hasher, hasher
+	return u, nil
+}
+
+// Hasher return the hasher of universe, the msgs decoded from JSON should be
+// sealed by it before their IDs be used, see Message.SealWith
+func (u *Universe) Hasher() common.Hasher {
+	return u.hasher
 }
 
-// AddMsg will check if the message from valid user, who is validated in at least one spacetime
-// (in stD). Then new message will be added into Universe and update time proof if msg.SenderID
-// is any spacetime based on.
+// AddMsg will check the message by validation pipeline, structural and signature check,
+// sender validity (sender is validated in at least one spacetime in stD), reference validation
+// and custom validators. Then new message will be added into Universe, update time proof if
+// msg.SenderID is any spacetime based on, and processed by the handler of its content type.
 func (u *Universe) AddMsg(msg *Message) error {
-	if !u.CheckUserExist(msg.SenderID) {
-		return ErrUserNotExist
+	receipt, err := u.Validate(msg)
+	if err != nil {
+		return err
+	}
+	return u.Commit(receipt)
+}
+
+// Validate run the structural and signature check of msg, which not depend on other msgs,
+// so msgs can be validated in parallel, but should not run with Commit at same time.
+// The msg is sealed by the hasher of universe if not yet, see Message.SealWith.
+func (u *Universe) Validate(msg *Message) (*Receipt, error) {
+	if msg.hasher != u.hasher {
+		msg.SealWith(u.hasher)
+	}
+	for _, v := range u.verifiers {
+		if err := v.Validate(u, msg); err != nil {
+			return nil, err
+		}
+	}
+	return &Receipt{MsgID: msg.ID(), msg: msg}, nil
+}
+
+// Commit check the msg in receipt by the validators depend on msgs in universe,
+// then add msg into universe and process it by the handler of content type.
+// All checks are done by validators before msg added, the error of handler
+// means the handler is not consistent with validators, see ContentHandler.
+// Receipts should be committed one by one.
+func (u *Universe) Commit(receipt *Receipt) error {
+	if receipt == nil || receipt.msg == nil {
+		return ErrReceiptNotValid
+	}
+	msg := receipt.msg
+	for _, v := range u.validators {
+		if err := v.Validate(u, msg); err != nil {
+			return err
+		}
 	}
 	if u.msgD == nil {
 		if err := u.initializeMsgD(msg); err != nil {
 			return err
 		}
+		u.lastMsg[msg.SenderID] = msg.ID()
 		if err := u.AddSpaceTime(msg, nil); err != nil {
 			return err
 		}
 	} else {
-		// check
-		if u.GetMsgByID(msg.ID()) != nil {
-			return ErrMsgAlreadyExist
-		}
 		// update dag
-		var refs []interface{}
-		for _, r := range msg.Reference {
-			refs = append(refs, r.MsgID)
-		}
-		msgVertex, err := dag.NewVertex(msg.ID(), msg, refs...)
-		if err != nil {
-			return err
-		}
-		err = u.msgD.AddVertex(msgVertex)
-		if err != nil {
+		if err := u.msgD.add(msg); err != nil {
 			return err
 		}
+		u.addToFilter(msg.ID())
+		u.lastMsg[msg.SenderID] = msg.ID()
 		// update tp
-		err = u.updateTimeProof(msg)
-		if err != nil {
+		if err := u.updateTimeProof(msg); err != nil {
 			return err
 		}
 		// process the msg
-		err = u.processMsg(msg)
-		if err != nil {
+		if err := u.processMsg(msg); err != nil {
 			return err
 		}
 	}
+	u.indexMsg(msg)
+	u.trackExpiry(msg)
+	u.commitSeqs[msg.ID()] = uint64(len(u.commitSeqs)) + 1
 	return nil
 }
 
+// AddValidator append custom validator to the validation pipeline, which
+// will run after the default validators.
+func (u *Universe) AddValidator(v Validator) {
+	u.validators = append(u.validators, v)
+}
+
+// SetContentHandler set the handler of content type, the default handler
+// will be replaced, nil handler means msg with this type change nothing.
+// The handler should not fail, the checks of content should be added by
+// AddValidator instead.
+func (u *Universe) SetContentHandler(contentType int, handler ContentHandler) {
+	if handler == nil {
+		delete(u.handlers, contentType)
+		return
+	}
+	u.handlers[contentType] = handler
+}
+
+// Config return the validation config of universe
+func (u Universe) Config() UniverseConfig {
+	return *u.config
+}
+
+// checkSelfRef check if msg reference the last msg of sender,
+// only if SelfRefRequired and sender already have msg in universe.
+func (u Universe) checkSelfRef(msg *Message) error {
+	if !u.config.SelfRefRequired {
+		return nil
+	}
+	lastMsgID, ok := u.lastMsg[msg.SenderID]
+	if !ok {
+		return nil
+	}
+	for _, r := range msg.Reference {
+		if r.SenderID == msg.SenderID && r.MsgID == lastMsgID {
+			return nil
+		}
+	}
+	return ErrSelfRefMissing
+}
+
+// UniverseID is the ID of universe created by the two root users, not depends
+// on the order of them, so nodes can tell if they are in the same universe. The
+// ID is calculated by the hasher of root users, see User.Hasher.
+func UniverseID(Eve, Adam *User) common.Hash {
+	id0, id1 := Eve.ID(), Adam.ID()
+	if bytes.Compare(id0[:], id1[:]) > 0 {
+		id0, id1 = id1, id0
+	}
+	hash := Eve.Hasher().New()
+	hash.Write(id0[:])
+	hash.Write(id1[:])
+	return common.Bytes2Hash(hash.Sum(nil))
+}
+
+// ID return the genesis hash of universe, which is the UniverseID of roots
+// on main network, see GenesisHash
+func (u *Universe) ID() common.Hash {
+	return GenesisHash(u.config.NetworkID, u.roots[0], u.roots[1])
+}
+
+// Network return the network of universe
+func (u *Universe) Network() uint64 {
+	return u.config.NetworkID
+}
+
 // GetSpaceTimeIDs get ids in of spacetime (list of msg.SenderID of each spacetime)
 func (u *Universe) GetSpaceTimeIDs() []common.Hash {
 	var ids []common.Hash
@@ -114,6 +291,71 @@ func (u *Universe) GetSpaceTimeIDs() []common.Hash {
 	return ids
 }
 
+// SetTimeProofPolicy set the policy of space-time used by universe,
+// the primary space-time must exist and be trusted, if it is set.
+func (u *Universe) SetTimeProofPolicy(policy *TimeProofPolicy) error {
+	if policy.Primary != (common.Hash{}) {
+		if u.stD == nil || u.stD.GetVertex(policy.Primary) == nil {
+			return ErrSpaceTimeNotExist
+		}
+		if !policy.IsTrusted(policy.Primary) {
+			return ErrSpaceTimeNotTrusted
+		}
+	}
+	u.policy = policy.copy()
+	return nil
+}
+
+// GetTimeProofPolicy return copy of the current time proof policy
+func (u Universe) GetTimeProofPolicy() *TimeProofPolicy {
+	return u.policy.copy()
+}
+
+// SetPrimarySpaceTime switch the primary space-time, which will be trusted at the same time
+func (u *Universe) SetPrimarySpaceTime(spacetimeID common.Hash) error {
+	policy := u.policy.copy()
+	policy.Primary = spacetimeID
+	if len(policy.Trusted) > 0 {
+		policy.Trust(spacetimeID)
+	}
+	return u.SetTimeProofPolicy(policy)
+}
+
+// GetPrimarySpaceTime return the ID of primary space-time, the first space-time
+// of universe will be used if primary is not set by policy.
+func (u Universe) GetPrimarySpaceTime() common.Hash {
+	if u.policy.Primary != (common.Hash{}) {
+		return u.policy.Primary
+	}
+	if ids := u.GetSpaceTimeIDs(); len(ids) > 0 {
+		return ids[0]
+	}
+	return common.Hash{}
+}
+
+// GetTrustedSpaceTimeIDs return the IDs of trusted space-time, primary first
+func (u Universe) GetTrustedSpaceTimeIDs() []common.Hash {
+	var ids []common.Hash
+	primary := u.GetPrimarySpaceTime()
+	if primary != (common.Hash{}) {
+		ids = append(ids, primary)
+	}
+	for _, id := range u.GetSpaceTimeIDs() {
+		if id != primary && u.policy.IsTrusted(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// GetPrimaryMaxSeq return the max time proof sequence of primary space-time
+func (u Universe) GetPrimaryMaxSeq() uint64 {
+	if u.stD == nil {
+		return 0
+	}
+	return u.GetMaxSeq(u.GetPrimarySpaceTime())
+}
+
 // AddSpaceTime will add spacetime in Universe with msg.SenderID, and follow the
 // time sequence from ref.
 func (u *Universe) AddSpaceTime(msg *Message, ref *MsgReference) error {
@@ -129,7 +371,7 @@ func (u *Universe) AddSpaceTime(msg *Message, ref *MsgReference) error {
 	// update time proof
 	initialize := true
 	startRecord := false
-	for _, id := range u.msgD.GetIDs() {
+	for _, id := range u.msgD.msgIDs() {
 		if id == msg.ID() {
 			startRecord = true
 		}
@@ -201,17 +443,31 @@ func (u Universe) GetMaxSeq(spacetimeID common.Hash) uint64 {
 	return 0
 }
 
-// GetUserIDs return userIDs in this space time
+// GetUserIDs return userIDs in this space time, users hidden or banned by
+// the owner of space time are not included.
 func (u Universe) GetUserIDs(spacetimeID common.Hash) []common.Hash {
 	var userIDs []common.Hash
 	if u.stD != nil {
 		if vertex := u.stD.GetVertex(spacetimeID); vertex != nil {
-			userIDs = vertex.Value().(*SpaceTime).GetUserIDs()
+			st := vertex.Value().(*SpaceTime)
+			for _, userID := range st.GetUserIDs() {
+				if st.GetUserInfo(userID).publicState == UserStatusNormal {
+					userIDs = append(userIDs, userID)
+				}
+			}
 		}
 	}
 	return userIDs
 }
 
+// GetUserState return the public state of user in space time, false if not find user
+func (u Universe) GetUserState(userID common.Hash, spacetimeID common.Hash) (int, bool) {
+	if info := u.GetUserInfo(userID, spacetimeID); info != nil {
+		return info.publicState, true
+	}
+	return 0, false
+}
+
 // GetUserInfo return the user info in space time
 // return nil if not find user
 func (u Universe) GetUserInfo(userID common.Hash, spacetimeID common.Hash) *UserInfo {
@@ -224,41 +480,125 @@ func (u Universe) GetUserInfo(userID common.Hash, spacetimeID common.Hash) *User
 }
 
 // GetMsgByID will return the msg by msg.ID()
-// nil will be return if msg not exist
-func (u Universe) GetMsgByID(msgID interface{}) *Message {
-	if v := u.msgD.GetVertex(msgID); v != nil {
-		return v.Value().(*Message)
+// nil will be return if msg not exist. The latest edit of msg is returned
+// instead if latest is true and the msg be edited.
+func (u Universe) GetMsgByID(msgID interface{}, latest ...bool) *Message {
+	if u.msgD == nil {
+		return nil
+	}
+	if id, ok := msgID.(common.Hash); ok && len(latest) > 0 && latest[0] {
+		if edits := u.edits[id]; len(edits) > 0 {
+			msgID = edits[len(edits)-1]
+		}
+	}
+	if id, ok := msgID.(common.Hash); ok {
+		return u.msgD.get(id)
 	}
 	return nil
 }
 
-// initializeMsgD only run once to create u.msgD by initial message, and the DAG
-// will remove strict rule, so msgD can accept new message if at least one of
-// reference exist in whole universe.
+// GetLastMsgID return the id of last msg added into universe from this user,
+// false will be return if no msg from this user
+func (u Universe) GetLastMsgID(userID common.Hash) (common.Hash, bool) {
+	msgID, ok := u.lastMsg[userID]
+	return msgID, ok
+}
+
+// RecommendRefs return at most n references for new msg from senderID. The last
+// msg of sender is always the first one if exist, then the latest time proof msg
+// of each spacetime, then the tips (msg not referenced by others) from new to old.
+// So the client can create well-formed msg without knowing the DAG.
+func (u Universe) RecommendRefs(senderID common.Hash, n int) []MsgReference {
+	var refs []MsgReference
+	if n <= 0 || u.msgD == nil {
+		return refs
+	}
+	picked := make(map[common.Hash]bool)
+	pick := func(msgID common.Hash) bool {
+		if len(refs) >= n {
+			return false
+		}
+		if msg := u.GetMsgByID(msgID); msg != nil && !picked[msgID] {
+			picked[msgID] = true
+			refs = append(refs, MsgReference{SenderID: msg.SenderID, MsgID: msgID})
+		}
+		return len(refs) < n
+	}
+	// latest msg from sender self
+	if msgID, ok := u.lastMsg[senderID]; ok && !pick(msgID) {
+		return refs
+	}
+	// latest time proof msg from each trusted spacetime
+	for _, stID := range u.GetTrustedSpaceTimeIDs() {
+		if stID == senderID {
+			continue
+		}
+		if msgID, ok := u.lastMsg[stID]; ok && !pick(msgID) {
+			return refs
+		}
+	}
+	// recent tips of msgD
+	ids := u.msgD.msgIDs()
+	for i := len(ids) - 1; i >= 0; i-- {
+		if u.msgD.isTip(ids[i]) {
+			if !pick(ids[i]) {
+				break
+			}
+		}
+	}
+	return refs
+}
+
+// initializeMsgD only run once to create u.msgD by initial message, then msgD
+// can accept new message if at least one of reference exist in whole universe.
 func (u *Universe) initializeMsgD(msg *Message) error {
-	// build msg dag
-	msgVertex, err := dag.NewVertex(msg.ID(), msg)
-	if err != nil {
-		return err
+	u.msgD = newMsgDAG(msg)
+	u.addToFilter(msg.ID())
+	return nil
+}
+
+// addToFilter add msg.ID into bloom filter if filter enabled
+func (u *Universe) addToFilter(msgID common.Hash) {
+	if u.filter != nil {
+		u.filter.Add(msgID[:])
 	}
-	msgD, err := dag.NewDAG(1, msgVertex)
-	if err != nil {
-		return err
+}
+
+// HasMsg return true if msg exist in universe, the bloom filter is used to
+// return false quickly if msg not be added before.
+func (u Universe) HasMsg(msgID common.Hash) bool {
+	if u.msgD == nil {
+		return false
 	}
-	msgD.RemoveStrict()
-	u.msgD = msgD
+	if u.filter != nil && !u.filter.MayContain(msgID[:]) {
+		return false
+	}
+	return u.GetMsgByID(msgID) != nil
+}
+
+// MsgFilter return the bloom filter of msg.ID, nil if not enabled
+func (u Universe) MsgFilter() *common.BloomFilter {
+	return u.filter
+}
+
+// SetMsgFilter replace the bloom filter by the one saved before, the filter
+// must be created by same params in universe config.
+func (u *Universe) SetMsgFilter(filter *common.BloomFilter) error {
+	if u.filter == nil || filter == nil || !filter.Match(u.config.BloomCapacity, u.config.BloomFPRate) {
+		return ErrMsgFilterNotMatch
+	}
+	if u.msgD != nil {
+		for _, msgID := range u.msgD.msgIDs() {
+			filter.Add(msgID[:])
+		}
+	}
+	u.filter = filter
 	return nil
 }
 
 func (u *Universe) processMsg(msg *Message) error {
-	switch msg.Value.ContentType {
-	case TypeText:
-		return nil
-	case TypeBirth:
-		err := u.addUserByMsg(msg)
-		if err != nil {
-			return err
-		}
+	if handler, ok := u.handlers[msg.Value.ContentType]; ok {
+		return handler.Handle(u, msg)
 	}
 	return nil
 }
@@ -270,6 +610,23 @@ func (u *Universe) updateTimeProof(msg *Message) error {
 	return nil
 }
 
+// updateUserStateByMsg set the public state of user in the space time of msg sender,
+// the state, owner of space-time and user are checked by validateUserStateUpdate
+func (u *Universe) updateUserStateByMsg(msg *Message) error {
+	var content ContentUserStateUpdate
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	st, err := u.getSpaceTime(msg.SenderID)
+	if err != nil {
+		return err
+	}
+	if info := st.GetUserInfo(content.UserID); info != nil {
+		info.publicState = content.State
+	}
+	return nil
+}
+
 // addUser user to u.userD
 // update info of u.stD need other func
 func (u *Universe) addUserByMsg(msg *Message) error {
@@ -308,6 +665,7 @@ func (u *Universe) addUserByMsg(msg *Message) error {
 	if err != nil {
 		return err
 	}
+	u.born[contentBirth.ConsentID()] = true
 	return nil
 }
 
@@ -17,15 +17,19 @@
 package core
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/common/log"
 	"github.com/pdupub/go-pdu/core/rule"
 	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/bls"
 	"github.com/pdupub/go-pdu/crypto/utils"
 )
 
@@ -206,12 +210,94 @@ func TestUniverse_AddBirthMsg(t *testing.T) {
 		t.Errorf("add msg4 fail, err should be %s, but now err : %s", ErrMsgAlreadyExist, err)
 	}
 
+	// parents can not reproduce again before the reproduction interval passed
+	content, _ = CreateContentBirth("A3", "1234", &auth)
+	content.SignByParent(Adam, *priKeyAdam)
+	content.SignByParent(Eve, *priKeyEve)
+	valueBirth.Content, _ = json.Marshal(content)
+	msgBirth3, _ := CreateMsg(Eve, &valueBirth, priKeyEve, &ref, &MsgReference{SenderID: Eve.ID(), MsgID: firstMsgIDFromEve})
+	if err := universe.AddMsg(msgBirth3); err != ErrNewUserAddFail {
+		t.Errorf("err should be %s, but get %s", ErrNewUserAddFail, err)
+	}
+	if universe.HasMsg(msgBirth3.ID()) {
+		t.Error("rejected msg should not be added")
+	}
+
 	if maxSeq := universe.GetMaxSeq(Eve.ID()); maxSeq != 0 {
 		t.Error("max seq for Eve time proof, should be 0 :", maxSeq)
 	}
 
 }
 
+func TestUniverse_ExportIdentity(t *testing.T) {
+	var child *User
+	for _, id := range universe.msgD.msgIDs() {
+		if msg := universe.GetMsgByID(id); msg.Value.ContentType == TypeBirth {
+			if child, err = CreateNewUser(universe, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if child == nil {
+		t.Fatal("birth msg should be added")
+	}
+	if _, err := universe.ExportIdentity(common.CreateHash()); err != ErrUserNotExist {
+		t.Error("user should not exist", err)
+	}
+	bundle, err := universe.ExportIdentity(child.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.User.ID() != child.ID() || len(bundle.Ancestors) != 2 || len(bundle.Msgs) != 0 {
+		t.Errorf("bundle should have both parents and no msgs %+v", bundle)
+	}
+	if err := bundle.Verify(); err != nil {
+		t.Error("bundle should be verified", err)
+	}
+	// the user is same after migrated as json
+	bundleBytes, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var migrated IdentityBundle
+	if err := json.Unmarshal(bundleBytes, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	if migrated.User.ID() != child.ID() || len(migrated.Ancestors) != 2 {
+		t.Error("user should be same after migrated")
+	}
+	if err := universe.VerifyIdentity(bundle); err != nil {
+		t.Error("bundle should be imported into same universe", err)
+	}
+	if items := bundle.Items(); len(items) != 1 || items[0].ID() != child.BirthMsg.ID() {
+		t.Error("only birth msg of user should be imported", len(items))
+	}
+	bundle.Network++
+	if err := universe.VerifyIdentity(bundle); err != ErrMsgNetworkNotMatch {
+		t.Error("bundle of other network should not be imported", err)
+	}
+	bundle.Network--
+	bundle.Ancestors = bundle.Ancestors[:1]
+	if err := bundle.Verify(); err != ErrUserNotExist {
+		t.Error("signature of parent can not be verified without parent", err)
+	}
+
+	bundle, err = universe.ExportIdentity(Eve.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Ancestors) != 0 || len(bundle.Msgs) == 0 {
+		t.Errorf("bundle of root should have msgs and no ancestors %+v", bundle)
+	}
+	if err := bundle.Verify(); err != nil {
+		t.Error("bundle should be verified", err)
+	}
+	bundle.Msgs = append(bundle.Msgs, universe.GetMsgByID(firstMsgIDFromAdam))
+	if err := bundle.Verify(); err != ErrIdentityNotValid {
+		t.Error("msg of other user should not be in bundle", err)
+	}
+}
+
 func TestUniverse_AddSpaceTime(t *testing.T) {
 	// Test 9: Create new space-time by msg from Eve
 	ref = MsgReference{SenderID: Eve.ID(), MsgID: firstMsgIDFromEve}
@@ -310,6 +396,39 @@ func TestUniverse_AddMsgWithDiffRef(t *testing.T) {
 
 }
 
+func TestUniverse_RecommendRefs(t *testing.T) {
+	if refs := universe.RecommendRefs(Eve.ID(), 0); len(refs) != 0 {
+		t.Error("should be empty, but get", len(refs))
+	}
+	refs := universe.RecommendRefs(Eve.ID(), 3)
+	if len(refs) != 3 {
+		t.Fatal("number of refs should be 3, but get", len(refs))
+	}
+	lastMsgID, ok := universe.GetLastMsgID(Eve.ID())
+	if !ok || refs[0].SenderID != Eve.ID() || refs[0].MsgID != lastMsgID {
+		t.Error("first ref should be the last msg from Eve")
+	}
+	if lastMsgID, _ = universe.GetLastMsgID(Adam.ID()); refs[1].MsgID != lastMsgID {
+		t.Error("second ref should be the last time proof from Adam")
+	}
+	for _, r := range refs {
+		if universe.GetMsgByID(r.MsgID) == nil {
+			t.Error("ref msg not exist")
+		}
+	}
+	// new msg created by recommended refs can be added
+	var msgRefs []*MsgReference
+	for i := range refs {
+		msgRefs = append(msgRefs, &refs[i])
+	}
+	msg, err := CreateMsg(Eve, &MsgValue{ContentType: TypeText, Content: []byte("recommend")}, priKeyEve, msgRefs...)
+	if err != nil {
+		t.Error("create msg fail", err)
+	} else if err := universe.AddMsg(msg); err != nil {
+		t.Error("add msg fail", err)
+	}
+}
+
 func loopAddMsg(universe *Universe, user *User, priKey *crypto.PrivateKey, lastMsgID common.Hash) error {
 	ref = MsgReference{SenderID: user.ID(), MsgID: lastMsgID}
 	// loop to add msg dag
@@ -398,3 +517,1679 @@ func displayAllSpaceTimeUserState() {
 		}
 	}
 }
+
+func TestUniverse_SelfRefRequired(t *testing.T) {
+	u, err := NewUniverseWithConfig(Eve, Adam, &UniverseConfig{SelfRefRequired: true})
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	if !u.Config().SelfRefRequired {
+		t.Error("self reference should be required")
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("first")}
+	msg1, _ := CreateMsg(Adam, value, priKeyAdam)
+	if err := u.AddMsg(msg1); err != nil {
+		t.Fatal("add first msg fail", err)
+	}
+	// first msg from Eve, no self reference
+	value = &MsgValue{ContentType: TypeText, Content: []byte("from Eve")}
+	msg2, _ := CreateMsg(Eve, value, priKeyEve, &MsgReference{SenderID: Adam.ID(), MsgID: msg1.ID()})
+	if err := u.AddMsg(msg2); err != nil {
+		t.Error("add first msg of Eve fail", err)
+	}
+	// msg from Adam without reference the last msg of Adam
+	value = &MsgValue{ContentType: TypeText, Content: []byte("no self ref")}
+	msg3, _ := CreateMsg(Adam, value, priKeyAdam, &MsgReference{SenderID: Eve.ID(), MsgID: msg2.ID()})
+	if err := u.AddMsg(msg3); err != ErrSelfRefMissing {
+		t.Errorf("err should be %s, but get %s", ErrSelfRefMissing, err)
+	}
+	// msg from Adam with self reference
+	msg4, _ := CreateMsg(Adam, value, priKeyAdam, &MsgReference{SenderID: Eve.ID(), MsgID: msg2.ID()}, &MsgReference{SenderID: Adam.ID(), MsgID: msg1.ID()})
+	if err := u.AddMsg(msg4); err != nil {
+		t.Error("add msg with self reference fail", err)
+	}
+}
+
+func TestUniverse_SetPrimarySpaceTime(t *testing.T) {
+	if primary := universe.GetPrimarySpaceTime(); primary != Adam.ID() {
+		t.Error("default primary space-time should be the first one")
+	}
+	if err := universe.SetPrimarySpaceTime(common.Hash{1}); err != ErrSpaceTimeNotExist {
+		t.Errorf("err should be %s, but get %s", ErrSpaceTimeNotExist, err)
+	}
+	if err := universe.SetTimeProofPolicy(NewTimeProofPolicy(Eve.ID(), Adam.ID())); err != nil {
+		t.Error("set time proof policy fail", err)
+	}
+	if ids := universe.GetTrustedSpaceTimeIDs(); len(ids) != 2 || ids[0] != Eve.ID() {
+		t.Error("trusted space-time should start with primary")
+	}
+	if universe.GetPrimaryMaxSeq() != universe.GetMaxSeq(Eve.ID()) {
+		t.Error("max seq should from primary space-time")
+	}
+	if err := universe.SetTimeProofPolicy(&TimeProofPolicy{}); err != nil {
+		t.Error("reset time proof policy fail", err)
+	}
+}
+
+func TestUniverse_MapSeq(t *testing.T) {
+	if seq, err := universe.GetSeq(AdamPartMsgIDs[10], Adam.ID()); err != nil || seq != 12 {
+		t.Error("seq of time proof msg should be 12, but get", seq, err)
+	}
+	if _, err := universe.GetSeq(AdamPartMsgIDs[10], common.Hash{}); err != ErrSpaceTimeNotExist {
+		t.Errorf("err should be %s, but get %s", ErrSpaceTimeNotExist, err)
+	}
+	// first msg from Eve reference AdamPartMsgIDs[60] is Eve seq 11
+	if seq, err := universe.MapSeq(AdamPartMsgIDs[60], Adam.ID(), Eve.ID()); err != nil || seq != 11 {
+		t.Error("mapped seq should be 11, but get", seq, err)
+	}
+	lastEveMsgID, _ := universe.GetLastMsgID(Eve.ID())
+	if seq, err := universe.MapSeq(lastEveMsgID, Eve.ID(), Adam.ID()); err != nil || seq != universe.GetMaxSeq(Adam.ID()) {
+		t.Error("mapped seq should be max seq of Adam, but get", seq, err)
+	}
+}
+
+func TestUniverse_CreateCheckpoint(t *testing.T) {
+	cp, err := universe.CreateCheckpoint(Adam.ID(), 12)
+	if err != nil {
+		t.Fatal("create checkpoint fail", err)
+	}
+	if cp.MsgID != AdamPartMsgIDs[10] {
+		t.Error("msg of checkpoint not match")
+	}
+	if root, err := universe.StateRoot(Adam.ID(), 12); err != nil || root != cp.StateRoot {
+		t.Error("state root not match", err)
+	}
+	if root, _ := universe.StateRoot(Adam.ID(), 13); root == cp.StateRoot {
+		t.Error("state root should be different")
+	}
+	if _, err := universe.CreateCheckpoint(Adam.ID(), universe.GetMaxSeq(Adam.ID())+1); err != ErrSeqNotFound {
+		t.Errorf("err should be %s, but get %s", ErrSeqNotFound, err)
+	}
+	if _, err := VerifyCheckpoint(*cp, Adam); err != ErrCheckpointNotSigned {
+		t.Errorf("err should be %s, but get %s", ErrCheckpointNotSigned, err)
+	}
+	if err := cp.Sign(Adam, priKeyAdam); err != nil {
+		t.Fatal("sign checkpoint fail", err)
+	}
+	cpBytes, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal("marshal checkpoint fail", err)
+	}
+	var cp2 Checkpoint
+	if err := json.Unmarshal(cpBytes, &cp2); err != nil {
+		t.Fatal("unmarshal checkpoint fail", err)
+	}
+	if ok, err := VerifyCheckpoint(cp2, Adam); err != nil || !ok {
+		t.Error("verify checkpoint fail", err)
+	}
+	if ok, _ := VerifyCheckpoint(cp2, Eve); ok {
+		t.Error("checkpoint not signed by Eve")
+	}
+}
+
+func TestUniverse_ProveSeq(t *testing.T) {
+	// Eve's msg reach Adam's time proof msgs by references
+	lastEveMsgID, _ := universe.GetLastMsgID(Eve.ID())
+	proof, err := universe.ProveSeq(lastEveMsgID, Adam.ID())
+	if err != nil {
+		t.Fatal("prove seq fail", err)
+	}
+	if seq, _ := universe.GetSeq(lastEveMsgID, Adam.ID()); proof.Seq != seq || len(proof.Path) == 0 {
+		t.Error("proof seq should be", seq, "but get", proof.Seq, len(proof.Path))
+	}
+	latest, err := universe.CreateCheckpoint(Adam.ID(), universe.GetMaxSeq(Adam.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifySeqProof(proof, *latest); err != nil || !ok {
+		t.Error("verify seq proof fail", err)
+	}
+
+	proof, err = universe.ProveSeq(AdamPartMsgIDs[10], Adam.ID())
+	if err != nil {
+		t.Fatal("prove seq fail", err)
+	}
+	if err := proof.Trim(11); err != ErrSeqNotFound {
+		t.Errorf("err should be %s, but get %s", ErrSeqNotFound, err)
+	}
+	if err := proof.Trim(12); err != nil || len(proof.Chain) != 1 || len(proof.Path) != 0 {
+		t.Fatal("trim proof fail", err, len(proof.Chain))
+	}
+	proofBytes, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proof2 SeqProof
+	if err := json.Unmarshal(proofBytes, &proof2); err != nil {
+		t.Fatal(err)
+	}
+	cp, _ := universe.CreateCheckpoint(Adam.ID(), 12)
+	if ok, err := VerifySeqProof(&proof2, *cp); err != nil || !ok {
+		t.Error("verify trimmed seq proof fail", err)
+	}
+	if ok, _ := VerifySeqProof(&proof2, *latest); ok {
+		t.Error("proof should not be verified by other checkpoint")
+	}
+	proof2.Seq = 11
+	if ok, _ := VerifySeqProof(&proof2, *cp); ok {
+		t.Error("proof with wrong seq should not be verified")
+	}
+	if _, err := universe.ProveSeq(lastEveMsgID, common.Hash{}); err != ErrSpaceTimeNotExist {
+		t.Errorf("err should be %s, but get %s", ErrSpaceTimeNotExist, err)
+	}
+}
+
+func TestUniverse_ProveInclusion(t *testing.T) {
+	cp, err := universe.CreateCheckpoint(Adam.ID(), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := universe.ProveInclusion(Adam.ID(), 12, InclusionUser, Adam.ID())
+	if err != nil {
+		t.Fatal("prove user inclusion fail", err)
+	}
+	if ok, err := VerifyInclusion(cp.StateRoot, proof); err != nil || !ok {
+		t.Error("verify user inclusion fail", err)
+	}
+	if ok, _ := VerifyInclusion(common.Hash{}, proof); ok {
+		t.Error("proof should not be verified by other root")
+	}
+	proof.Kind = InclusionMsg
+	if ok, _ := VerifyInclusion(cp.StateRoot, proof); ok {
+		t.Error("user should not be proved as msg")
+	}
+
+	proof, err = universe.ProveInclusion(Adam.ID(), 12, InclusionMsg, AdamPartMsgIDs[10])
+	if err != nil {
+		t.Fatal("prove msg inclusion fail", err)
+	}
+	proofBytes, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proof2 InclusionProof
+	if err := json.Unmarshal(proofBytes, &proof2); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyInclusion(cp.StateRoot, &proof2); err != nil || !ok {
+		t.Error("verify msg inclusion fail", err)
+	}
+	proof2.Seq = 13
+	if ok, _ := VerifyInclusion(cp.StateRoot, &proof2); ok {
+		t.Error("proof with wrong seq should not be verified")
+	}
+
+	// every leaf of the state tree can be proved
+	st, _ := universe.getSpaceTime(Adam.ID())
+	tree := universe.newStateTree(st, Adam.ID(), 12, cp.MsgID)
+	for kind, ids := range map[string][]common.Hash{InclusionUser: tree.users, InclusionMsg: tree.msgs} {
+		for _, id := range ids {
+			p, ok := tree.prove(kind, id)
+			if !ok {
+				t.Fatal("leaf should be in tree", kind)
+			}
+			p.SpaceTimeID, p.Seq, p.MsgID = Adam.ID(), 12, cp.MsgID
+			if ok, err := VerifyInclusion(cp.StateRoot, p); err != nil || !ok {
+				t.Error("verify leaf fail", kind, len(ids), err)
+			}
+		}
+	}
+
+	lastEveMsgID, _ := universe.GetLastMsgID(Eve.ID())
+	if _, err := universe.ProveInclusion(Adam.ID(), 12, InclusionMsg, lastEveMsgID); err != ErrNotIncluded {
+		t.Errorf("err should be %s, but get %s", ErrNotIncluded, err)
+	}
+	if _, err := universe.ProveInclusion(Adam.ID(), 12, "", Adam.ID()); err != ErrInclusionKindNotValid {
+		t.Errorf("err should be %s, but get %s", ErrInclusionKindNotValid, err)
+	}
+}
+
+func TestVerifyCheckpoints(t *testing.T) {
+	cp, err := universe.CreateCheckpoint(Adam.ID(), 12)
+	if err != nil {
+		t.Fatal("create checkpoint fail", err)
+	}
+	engine := bls.New()
+	var cps []Checkpoint
+	var signers []*User
+	for i := 0; i < 3; i++ {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal("generate key fail", err)
+		}
+		signer := CreateRootUser(*pubKey, fmt.Sprintf("signer%d", i), "")
+		signed := *cp
+		if err := signed.Sign(signer, priKey); err != nil {
+			t.Fatal("sign checkpoint fail", err)
+		}
+		cps = append(cps, signed)
+		signers = append(signers, signer)
+	}
+	sig, err := AggregateCheckpoints(cps, signers)
+	if err != nil {
+		t.Fatal("aggregate checkpoints fail", err)
+	}
+	if len(sig.Signature) != len(cps[0].Signature.Signature) {
+		t.Error("size of aggregated signature should be same as single signature")
+	}
+	if ok, err := VerifyCheckpoints(cps, signers, sig); err != nil || !ok {
+		t.Error("verify checkpoints fail", err)
+	}
+	signers[0], signers[1] = signers[1], signers[0]
+	if ok, _ := VerifyCheckpoints(cps, signers, sig); ok {
+		t.Error("verify checkpoints should fail if signers not match")
+	}
+	if _, err := VerifyCheckpoints(cps, signers[1:], sig); err != ErrSignerNotMatch {
+		t.Errorf("err should be %s, but get %s", ErrSignerNotMatch, err)
+	}
+}
+
+func TestUniverse_Search(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	if _, err := u.Search("hello", 10); err != ErrSearchNotEnable {
+		t.Errorf("err should be %s, but get %s", ErrSearchNotEnable, err)
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello universe")}
+	msg1, _ := CreateMsg(Adam, value, priKeyAdam)
+	if err := u.AddMsg(msg1); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	// msg already in universe will be indexed
+	u.EnableSearch()
+	value = &MsgValue{ContentType: TypeText, Content: []byte("hello from Eve")}
+	msg2, _ := CreateMsg(Eve, value, priKeyEve, &MsgReference{SenderID: Adam.ID(), MsgID: msg1.ID()})
+	if err := u.AddMsg(msg2); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if msgs, err := u.Search("Hello", 10); err != nil || len(msgs) != 2 || msgs[0].ID() != msg2.ID() {
+		t.Error("search msgs fail", err)
+	}
+	if msgs, err := u.Search("universe", 10); err != nil || len(msgs) != 1 || msgs[0].ID() != msg1.ID() {
+		t.Error("search msgs fail", err)
+	}
+}
+
+func TestUniverse_Hasher(t *testing.T) {
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg, _ := CreateMsg(Adam, value, priKeyAdam)
+	sha256ID := msg.ID()
+	blake3, _ := common.SelectHasher(common.HasherBLAKE3)
+	if m := *msg; m.SealWith(blake3) == sha256ID {
+		t.Error("ID should be changed by hasher")
+	}
+	if msg.ID() != sha256ID || Adam.Hasher().Name() != common.HasherSHA256 {
+		t.Error("msg and user of other universe should not be changed")
+	}
+
+	// gender of root users depend on ID, so find root users by blake3
+	var adam, eve *User
+	var priKeyA *crypto.PrivateKey
+	for adam == nil || eve == nil {
+		priKey, pubKey, _ := universeEngine.GenKey(crypto.MultipleSignatures, 3)
+		user := CreateRootUser(*pubKey, "name", "extra")
+		user.SetHasher(blake3)
+		if user.Gender() && adam == nil {
+			adam, priKeyA = user, priKey
+		} else if !user.Gender() && eve == nil {
+			eve = user
+		}
+	}
+	u, err := NewUniverseWithConfig(eve, adam, &UniverseConfig{Hasher: common.HasherBLAKE3})
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	if u.Hasher().Name() != common.HasherBLAKE3 {
+		t.Error("hasher should be", common.HasherBLAKE3)
+	}
+	msg, _ = CreateMsg(adam, value, priKeyA)
+	if msg.Hasher().Name() != common.HasherBLAKE3 {
+		t.Error("msg should be bound to the hasher of sender")
+	}
+	if err := u.AddMsg(msg); err != nil {
+		t.Error("add msg fail", err)
+	}
+	// msg decoded from JSON is sealed by the hasher of universe again
+	msgBytes, _ := json.Marshal(msg)
+	var decoded Message
+	if err := json.Unmarshal(msgBytes, &decoded); err != nil || decoded.ID() == msg.ID() {
+		t.Fatal("decoded msg should be bound to default hasher", err)
+	}
+	if err := u.AddMsg(&decoded); err != ErrMsgAlreadyExist {
+		t.Errorf("err should be %s, but get %v", ErrMsgAlreadyExist, err)
+	}
+	if decoded.ID() != msg.ID() {
+		t.Error("decoded msg should be sealed by hasher of universe")
+	}
+	if _, err := NewUniverseWithConfig(eve, adam, &UniverseConfig{Hasher: "md5"}); err != common.ErrHasherNotSupport {
+		t.Errorf("err should be %s, but get %s", common.ErrHasherNotSupport, err)
+	}
+}
+
+func TestUniverse_HasherBeforeGender(t *testing.T) {
+	sha256, _ := common.SelectHasher(common.HasherSHA256)
+	blake3, _ := common.SelectHasher(common.HasherBLAKE3)
+	genders := func(h common.Hasher, users ...User) (res []bool) {
+		for _, u := range users {
+			u.SetHasher(h)
+			res = append(res, u.Gender())
+		}
+		return res
+	}
+	// find root users of diff gender by sha256, but same gender by blake3
+	engine, _ := utils.SelectEngine(crypto.ETH)
+	var u0, u1 *User
+	for u0 == nil {
+		_, pk0, _ := engine.GenKey(crypto.Signature2PublicKey)
+		_, pk1, _ := engine.GenKey(crypto.Signature2PublicKey)
+		c0, c1 := CreateRootUser(*pk0, "u0", ""), CreateRootUser(*pk1, "u1", "")
+		g := append(genders(sha256, *c0, *c1), genders(blake3, *c0, *c1)...)
+		if g[0] != g[1] && g[2] == g[3] {
+			u0, u1 = c0, c1
+		}
+	}
+	if _, err := NewUniverseWithConfig(u0, u1, &UniverseConfig{Hasher: common.HasherBLAKE3}); err != ErrNotSupportYet {
+		t.Errorf("err should be %s, but get %v", ErrNotSupportYet, err)
+	}
+	if u0.Hasher().Name() != common.HasherSHA256 || u1.Hasher().Name() != common.HasherSHA256 {
+		t.Error("root users should not be changed if not valid")
+	}
+	if _, err := NewUniverseWithConfig(u0, u1, DefaultUniverseConfig()); err != nil {
+		t.Error("root users should be valid by sha256", err)
+	}
+}
+
+func TestUniverse_TimestampHint(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	now := time.Now()
+	u.now = func() time.Time { return now }
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg, _ := CreateMsg(Adam, value, priKeyAdam)
+	if hint, ok := msg.TimeHint(); !ok || hint.Unix() != int64(msg.Timestamp) {
+		t.Error("msg should have time hint")
+	}
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+
+	early := &Message{SenderID: Adam.ID(), Value: value, Timestamp: msg.Timestamp - 1,
+		Reference: []*MsgReference{{SenderID: Adam.ID(), MsgID: msg.ID()}}}
+	early.sign(priKeyAdam)
+	if err := u.AddMsg(early); err != ErrMsgTimestampTooEarly {
+		t.Errorf("err should be %s, but get %s", ErrMsgTimestampTooEarly, err)
+	}
+	if r := u.Reject(early, ErrMsgTimestampTooEarly); r.Code != RejectRule || r.Rule != RuleTimestampHint {
+		t.Error("rejection should be rule", RuleTimestampHint)
+	}
+
+	future := &Message{SenderID: Adam.ID(), Value: value, Timestamp: uint64(now.Unix()) + DefaultMaxTimestampDrift + 1,
+		Reference: []*MsgReference{{SenderID: Adam.ID(), MsgID: msg.ID()}}}
+	future.sign(priKeyAdam)
+	if _, err := u.Validate(future); err != ErrMsgTimestampDrift {
+		t.Errorf("err should be %s, but get %s", ErrMsgTimestampDrift, err)
+	}
+	future.Timestamp = uint64(now.Unix()) + DefaultMaxTimestampDrift
+	future.sign(priKeyAdam)
+	if err := u.AddMsg(future); err != nil {
+		t.Error("add msg fail", err)
+	}
+
+	noHint := &Message{SenderID: Adam.ID(), Value: value,
+		Reference: []*MsgReference{{SenderID: Adam.ID(), MsgID: future.ID()}}}
+	noHint.sign(priKeyAdam)
+	if _, ok := noHint.TimeHint(); ok {
+		t.Error("msg should not have time hint")
+	}
+	if err := u.AddMsg(noHint); err != nil {
+		t.Error("msg without time hint should be added", err)
+	}
+}
+
+func TestUniverse_PoW(t *testing.T) {
+	config := DefaultUniverseConfig()
+	config.PoWDifficulty = map[int]uint8{TypeText: 12}
+	u, err := NewUniverseWithConfig(Eve, Adam, config)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg, _ := CreateMsg(Adam, value, priKeyAdam)
+	if _, err := u.Validate(msg); err != ErrMsgPoWNotValid && leadingZeroBits(msg.PoWHash()) < 12 {
+		t.Errorf("err should be %s, but get %s", ErrMsgPoWNotValid, err)
+	}
+	if r := u.Reject(msg, ErrMsgPoWNotValid); r.Code != RejectRule || r.Rule != RuleProofOfWork {
+		t.Error("rejection should be rule", RuleProofOfWork)
+	}
+	msg, err = CreateMsgWithPoW(Adam, value, priKeyAdam, u.Config().Difficulty(TypeText))
+	if err != nil {
+		t.Fatal("create msg with PoW fail", err)
+	}
+	if leadingZeroBits(msg.PoWHash()) < 12 {
+		t.Error("PoW hash not meet difficulty")
+	}
+	if err := u.AddMsg(msg); err != nil {
+		t.Error("add msg fail", err)
+	}
+	if _, err := CreateMsgWithPoW(Adam, value, priKeyAdam, MaxPoWDifficulty+1); err != ErrPoWDifficultyTooHigh {
+		t.Errorf("err should be %s, but get %s", ErrPoWDifficultyTooHigh, err)
+	}
+}
+
+func TestUniverse_UserStateUpdate(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	msg, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKeyAdam)
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if len(u.GetUserIDs(Adam.ID())) != 2 {
+		t.Fatal("count of users should be 2")
+	}
+
+	var m *Message
+	updateState := func(sender *User, priKey *crypto.PrivateKey, userID common.Hash, state int) error {
+		content, _ := json.Marshal(&ContentUserStateUpdate{UserID: userID, State: state})
+		lastMsgID, _ := u.GetLastMsgID(Adam.ID())
+		m, _ = CreateMsg(sender, &MsgValue{ContentType: TypeUserStateUpdate, Content: content}, priKey, &MsgReference{SenderID: Adam.ID(), MsgID: lastMsgID})
+		return u.AddMsg(m)
+	}
+	if err := updateState(Adam, priKeyAdam, Eve.ID(), UserStatusHidden); err != nil {
+		t.Fatal("update user state fail", err)
+	}
+	if state, ok := u.GetUserState(Eve.ID(), Adam.ID()); !ok || state != UserStatusHidden {
+		t.Error("user state should be", UserStatusHidden)
+	}
+	if ids := u.GetUserIDs(Adam.ID()); len(ids) != 1 || ids[0] != Adam.ID() {
+		t.Error("hidden user should not be listed")
+	}
+	if err := updateState(Eve, priKeyEve, Adam.ID(), UserStatusBanned); err != ErrNotSpaceTimeOwner {
+		t.Errorf("err should be %s, but get %s", ErrNotSpaceTimeOwner, err)
+	}
+	if u.HasMsg(m.ID()) {
+		t.Error("rejected msg should not be added")
+	}
+	if err := updateState(Adam, priKeyAdam, Eve.ID(), UserStatusBanned+1); err != ErrUserStateNotValid {
+		t.Errorf("err should be %s, but get %s", ErrUserStateNotValid, err)
+	}
+	if u.HasMsg(m.ID()) {
+		t.Error("rejected msg should not be added")
+	}
+	if err := updateState(Adam, priKeyAdam, common.CreateHash(), UserStatusBanned); err != ErrUserNotExist {
+		t.Errorf("err should be %s, but get %s", ErrUserNotExist, err)
+	}
+	if _, err := CreateContentUserStateUpdate(Eve.ID(), UserStatusBanned+1); err != ErrUserStateNotValid {
+		t.Errorf("err should be %s, but get %s", ErrUserStateNotValid, err)
+	}
+	if err := updateState(Adam, priKeyAdam, Eve.ID(), UserStatusNormal); err != nil {
+		t.Error("update user state fail", err)
+	}
+	if len(u.GetUserIDs(Adam.ID())) != 2 {
+		t.Error("user should be listed again")
+	}
+}
+
+func TestUniverse_FileChunk(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	data := []byte("chunk of file")
+	content, _ := json.Marshal(&ContentFile{Name: "a.txt", Size: 100, Index: 2, Total: 2, Data: data, Hash: hashFileChunk(data)})
+	msg, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeFile, Content: content}, priKeyAdam)
+	if err := u.AddMsg(msg); err != ErrFileChunkNotValid {
+		t.Errorf("err should be %s, but get %s", ErrFileChunkNotValid, err)
+	}
+	if u.HasMsg(msg.ID()) {
+		t.Error("rejected msg should not be added")
+	}
+	cf, _ := CreateContentFile("a.txt", 100, 0, 2, data, nil)
+	content, _ = json.Marshal(cf)
+	msg, _ = CreateMsg(Adam, &MsgValue{ContentType: TypeFile, Content: content}, priKeyAdam)
+	if err := u.AddMsg(msg); err != nil {
+		t.Error("add msg fail", err)
+	}
+}
+
+func TestUniverse_Repost(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	original, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKeyAdam)
+	if err := u.AddMsg(original); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+
+	repost := func(sender *User, priKey *crypto.PrivateKey, cr *ContentRepost) (*Message, error) {
+		content, _ := json.Marshal(cr)
+		refs := []*MsgReference{{SenderID: Adam.ID(), MsgID: original.ID()}}
+		if lastMsgID, ok := u.GetLastMsgID(sender.ID()); ok && sender != Adam {
+			refs = append(refs, &MsgReference{SenderID: sender.ID(), MsgID: lastMsgID})
+		}
+		m, _ := CreateMsg(sender, &MsgValue{ContentType: TypeRepost, Content: content}, priKey, refs...)
+		return m, u.AddMsg(m)
+	}
+	cr, err := CreateContentRepost(original, "nice")
+	if err != nil || cr.MsgID != original.ID() || cr.AuthorID != Adam.ID() {
+		t.Fatal("create repost fail", err)
+	}
+	first, err := repost(Eve, priKeyEve, cr)
+	if err != nil {
+		t.Fatal("add repost fail", err)
+	}
+	if _, err := repost(Eve, priKeyEve, cr); err != ErrRepostAlreadyExist {
+		t.Errorf("err should be %s, but get %s", ErrRepostAlreadyExist, err)
+	}
+	if _, err := repost(Adam, priKeyAdam, &ContentRepost{MsgID: original.ID(), AuthorID: Eve.ID()}); err != ErrRepostNotValid {
+		t.Errorf("err should be %s, but get %s", ErrRepostNotValid, err)
+	}
+	if _, err := repost(Adam, priKeyAdam, &ContentRepost{MsgID: common.Bytes2Hash([]byte("not exist")), AuthorID: Eve.ID()}); err != ErrRepostOriginalNotFound {
+		t.Errorf("err should be %s, but get %s", ErrRepostOriginalNotFound, err)
+	}
+	if _, err := repost(Adam, priKeyAdam, &ContentRepost{MsgID: first.ID(), AuthorID: Eve.ID()}); err != ErrRepostNotValid {
+		t.Errorf("err should be %s, but get %s", ErrRepostNotValid, err)
+	}
+
+	// repost of repost share the original msg
+	cr, err = CreateContentRepost(first, "")
+	if err != nil || cr.MsgID != original.ID() || cr.AuthorID != Adam.ID() {
+		t.Fatal("create repost of repost fail", err)
+	}
+	second, err := repost(Adam, priKeyAdam, cr)
+	if err != nil {
+		t.Fatal("add repost fail", err)
+	}
+	if reposts := u.GetReposts(original.ID()); len(reposts) != 2 || reposts[0] != first.ID() || reposts[1] != second.ID() {
+		t.Error("reposts not match", reposts)
+	}
+	if u.RepostCount(original.ID()) != 2 || u.RepostCount(first.ID()) != 0 {
+		t.Error("count of reposts not match")
+	}
+}
+
+func TestUniverse_Edit(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	original, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("helo")}, priKeyAdam)
+	if err := u.AddMsg(original); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	edit := func(sender *User, priKey *crypto.PrivateKey, ce *ContentEdit) (*Message, error) {
+		content, _ := json.Marshal(ce)
+		lastMsgID, _ := u.GetLastMsgID(Adam.ID())
+		m, _ := CreateMsg(sender, &MsgValue{ContentType: TypeEdit, Content: content}, priKey, &MsgReference{SenderID: Adam.ID(), MsgID: lastMsgID})
+		return m, u.AddMsg(m)
+	}
+	ce, err := CreateContentEdit(original, []byte("hello"))
+	if err != nil || ce.MsgID != original.ID() {
+		t.Fatal("create edit fail", err)
+	}
+	if _, err := edit(Eve, priKeyEve, ce); err != ErrEditNotValid {
+		t.Errorf("err should be %s, but get %s", ErrEditNotValid, err)
+	}
+	if _, err := edit(Adam, priKeyAdam, &ContentEdit{MsgID: common.Bytes2Hash([]byte("not exist"))}); err != ErrEditOriginalNotFound {
+		t.Errorf("err should be %s, but get %s", ErrEditOriginalNotFound, err)
+	}
+	first, err := edit(Adam, priKeyAdam, ce)
+	if err != nil {
+		t.Fatal("add edit fail", err)
+	}
+	if _, err := edit(Adam, priKeyAdam, &ContentEdit{MsgID: first.ID()}); err != ErrEditNotValid {
+		t.Errorf("err should be %s, but get %s", ErrEditNotValid, err)
+	}
+
+	// edit of edit is added into the version chain of original
+	ce, err = CreateContentEdit(first, []byte("hello world"))
+	if err != nil || ce.MsgID != original.ID() {
+		t.Fatal("create edit of edit fail", err)
+	}
+	second, err := edit(Adam, priKeyAdam, ce)
+	if err != nil {
+		t.Fatal("add edit fail", err)
+	}
+	history := u.GetEditHistory(second.ID())
+	if len(history) != 3 || history[0].ID() != original.ID() || history[1].ID() != first.ID() || history[2].ID() != second.ID() {
+		t.Error("edit history not match", len(history))
+	}
+	if u.EditCount(original.ID()) != 2 {
+		t.Error("count of edits not match")
+	}
+	if msg := u.GetMsgByID(original.ID()); msg.ID() != original.ID() {
+		t.Error("original msg should not be changed")
+	}
+	if msg := u.GetMsgByID(original.ID(), true); msg.ID() != second.ID() {
+		t.Error("latest version should be returned")
+	}
+}
+
+func TestUniverse_NameClaim(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	first, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKeyAdam)
+	if err := u.AddMsg(first); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+
+	claim := func(sender *User, priKey *crypto.PrivateKey, handle string) error {
+		content, _ := json.Marshal(&ContentNameClaim{Handle: handle})
+		refs := []*MsgReference{{SenderID: Adam.ID(), MsgID: first.ID()}}
+		if lastMsgID, ok := u.GetLastMsgID(sender.ID()); ok && lastMsgID != first.ID() {
+			refs = append(refs, &MsgReference{SenderID: sender.ID(), MsgID: lastMsgID})
+		}
+		m, _ := CreateMsg(sender, &MsgValue{ContentType: TypeNameClaim, Content: content}, priKey, refs...)
+		return u.AddMsg(m)
+	}
+	if _, err := CreateContentNameClaim("@a"); err != ErrHandleNotValid {
+		t.Errorf("err should be %s, but get %s", ErrHandleNotValid, err)
+	}
+	if content, err := CreateContentNameClaim("@Alice"); err != nil || content.Handle != "alice" {
+		t.Fatal("create name claim fail", err)
+	}
+	if err := claim(Eve, priKeyEve, "@Alice"); err != ErrHandleNotValid {
+		t.Errorf("err should be %s, but get %s", ErrHandleNotValid, err)
+	}
+	if err := claim(Eve, priKeyEve, "alice"); err != nil {
+		t.Fatal("claim handle fail", err)
+	}
+	if err := claim(Adam, priKeyAdam, "alice"); err != nil {
+		t.Fatal("claim handle fail", err)
+	}
+	if owner, ok := u.LookupHandle("@alice", Adam.ID()); !ok || owner != Eve.ID() {
+		t.Error("owner of handle should be", Eve.ID())
+	}
+	if _, ok := u.GetHandle(Adam.ID(), Adam.ID()); ok {
+		t.Error("handle owned by other user should not be returned")
+	}
+
+	// owner release the handle by claim another one
+	if err := claim(Eve, priKeyEve, "eve"); err != nil {
+		t.Fatal("claim handle fail", err)
+	}
+	if handle, ok := u.GetHandle(Adam.ID(), Adam.ID()); !ok || handle != "alice" {
+		t.Error("handle should be alice")
+	}
+	if handle, ok := u.GetHandle(Eve.ID(), Adam.ID()); !ok || handle != "eve" {
+		t.Error("handle should be eve")
+	}
+	if _, ok := u.LookupHandle("eve", Eve.ID()); ok {
+		t.Error("handle should not exist in other space-time")
+	}
+
+	// the claim of banned user is ignored
+	content, _ := json.Marshal(&ContentUserStateUpdate{UserID: Eve.ID(), State: UserStatusBanned})
+	lastMsgID, _ := u.GetLastMsgID(Adam.ID())
+	m, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeUserStateUpdate, Content: content}, priKeyAdam, &MsgReference{SenderID: Adam.ID(), MsgID: lastMsgID})
+	if err := u.AddMsg(m); err != nil {
+		t.Fatal("update user state fail", err)
+	}
+	if _, ok := u.LookupHandle("eve", Adam.ID()); ok {
+		t.Error("handle of banned user should not be returned")
+	}
+}
+
+func TestUniverse_Follow(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	first, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKeyAdam)
+	if err := u.AddMsg(first); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+
+	send := func(sender *User, priKey *crypto.PrivateKey, value *MsgValue) (*Message, error) {
+		refs := []*MsgReference{{SenderID: Adam.ID(), MsgID: first.ID()}}
+		if lastMsgID, ok := u.GetLastMsgID(sender.ID()); ok && lastMsgID != first.ID() {
+			refs = append(refs, &MsgReference{SenderID: sender.ID(), MsgID: lastMsgID})
+		}
+		m, _ := CreateMsg(sender, value, priKey, refs...)
+		return m, u.AddMsg(m)
+	}
+	follow := func(contentType int, sender *User, priKey *crypto.PrivateKey, userID common.Hash) error {
+		content, _ := json.Marshal(CreateContentFollow(userID))
+		_, err := send(sender, priKey, &MsgValue{ContentType: contentType, Content: content})
+		return err
+	}
+	if err := follow(TypeFollow, Eve, priKeyEve, Eve.ID()); err != ErrFollowNotValid {
+		t.Errorf("err should be %s, but get %s", ErrFollowNotValid, err)
+	}
+	if err := follow(TypeFollow, Eve, priKeyEve, common.Bytes2Hash([]byte("not exist"))); err != ErrFollowNotValid {
+		t.Errorf("err should be %s, but get %s", ErrFollowNotValid, err)
+	}
+	if err := follow(TypeFollow, Eve, priKeyEve, Adam.ID()); err != nil {
+		t.Fatal("follow fail", err)
+	}
+	if ids := u.GetFollowing(Eve.ID()); len(ids) != 1 || ids[0] != Adam.ID() {
+		t.Error("following not match", ids)
+	}
+	if ids := u.GetFollowers(Adam.ID()); len(ids) != 1 || ids[0] != Eve.ID() {
+		t.Error("followers not match", ids)
+	}
+
+	second, err := send(Adam, priKeyAdam, &MsgValue{ContentType: TypeText, Content: []byte("world")})
+	if err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if msgs := u.GetTimeline(Eve.ID(), 10); len(msgs) != 2 || msgs[0].ID() != second.ID() || msgs[1].ID() != first.ID() {
+		t.Error("timeline not match", msgs)
+	}
+	if msgs := u.GetTimeline(Eve.ID(), 1); len(msgs) != 1 || msgs[0].ID() != second.ID() {
+		t.Error("timeline should be limited", msgs)
+	}
+	if msgs := u.GetTimeline(Adam.ID(), 10); len(msgs) != 0 {
+		t.Error("timeline should be empty", msgs)
+	}
+	// the page in view is not shifted by msg committed after
+	view := u.View()
+	third, err := send(Adam, priKeyAdam, &MsgValue{ContentType: TypeText, Content: []byte("again")})
+	if err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if msgs := u.GetTimelineAt(Eve.ID(), view, 1, 1); len(msgs) != 1 || msgs[0].ID() != first.ID() {
+		t.Error("page in view should not be shifted", msgs)
+	}
+	if msgs := u.GetTimelineAt(Eve.ID(), nil, 1, 1); len(msgs) != 1 || msgs[0].ID() != second.ID() {
+		t.Error("page of latest timeline not match", msgs)
+	}
+	if u.InView(view, third.ID()) || !u.InView(nil, third.ID()) || !u.InView(view, second.ID()) {
+		t.Error("msg committed after view should not be in view")
+	}
+
+	if err := follow(TypeUnfollow, Eve, priKeyEve, Adam.ID()); err != nil {
+		t.Fatal("unfollow fail", err)
+	}
+	if len(u.GetFollowing(Eve.ID())) != 0 || len(u.GetFollowers(Adam.ID())) != 0 {
+		t.Error("follow graph should be empty")
+	}
+}
+
+func TestNameClaim_Before(t *testing.T) {
+	a := nameClaim{msgID: common.Bytes2Hash([]byte("a")), seq: 2}
+	b := nameClaim{msgID: common.Bytes2Hash([]byte("b")), seq: 2}
+	if !a.before(b) || b.before(a) {
+		t.Error("smaller msg id should be earlier")
+	}
+	b.seq = 1
+	if a.before(b) || !b.before(a) {
+		t.Error("smaller sequence should be earlier")
+	}
+	b.seq = 0
+	if !a.before(b) || b.before(a) {
+		t.Error("claim not reach time proof should be later")
+	}
+}
+
+func TestUniverse_HasMsg(t *testing.T) {
+	if universe.MsgFilter() == nil {
+		t.Fatal("bloom filter should be enabled by default")
+	}
+	if !universe.HasMsg(firstMsgIDFromAdam) || !universe.HasMsg(firstMsgIDFromEve) {
+		t.Error("msg should exist")
+	}
+	if universe.HasMsg(common.Bytes2Hash([]byte("not exist"))) {
+		t.Error("msg should not exist")
+	}
+
+	u, err := NewUniverseWithConfig(Eve, Adam, &UniverseConfig{BloomCapacity: 100, BloomFPRate: 0.001})
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	if err := u.SetMsgFilter(universe.MsgFilter()); err != ErrMsgFilterNotMatch {
+		t.Errorf("err should be %s, but get %s", ErrMsgFilterNotMatch, err)
+	}
+	filter, _ := common.NewBloomFilter(100, 0.001)
+	filter.Add(firstMsgIDFromAdam[:])
+	if err := u.SetMsgFilter(filter); err != nil {
+		t.Error("set msg filter fail", err)
+	}
+	// msg in filter but not in universe
+	if u.HasMsg(firstMsgIDFromAdam) {
+		t.Error("msg should not exist")
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg, _ := CreateMsg(Adam, value, priKeyAdam)
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if !u.HasMsg(msg.ID()) || !u.MsgFilter().MayContain(common.Hash2Bytes(msg.ID())) {
+		t.Error("msg should exist")
+	}
+	if err := u.AddMsg(msg); err != ErrMsgAlreadyExist {
+		t.Errorf("err should be %s, but get %s", ErrMsgAlreadyExist, err)
+	}
+}
+
+func TestUniverse_AddValidator(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	errSpam := errors.New("spam")
+	u.AddValidator(ValidatorFunc(func(u *Universe, msg *Message) error {
+		if string(msg.Value.Content) == "spam" {
+			return errSpam
+		}
+		return nil
+	}))
+	value := &MsgValue{ContentType: TypeText, Content: []byte("spam")}
+	msg, _ := CreateMsg(Adam, value, priKeyAdam)
+	if err := u.AddMsg(msg); err != errSpam {
+		t.Errorf("err should be %s, but get %s", errSpam, err)
+	}
+	// signature not signed by sender
+	value = &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg, _ = CreateMsg(Eve, value, priKeyEve)
+	msg.SenderID = Adam.ID()
+	msg.Seal()
+	if err := u.AddMsg(msg); err != ErrMsgSignatureNotValid {
+		t.Errorf("err should be %s, but get %s", ErrMsgSignatureNotValid, err)
+	}
+	msg, _ = CreateMsg(Adam, value, priKeyAdam)
+	handled := false
+	u.SetContentHandler(TypeText, ContentHandlerFunc(func(u *Universe, msg *Message) error {
+		handled = true
+		return nil
+	}))
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	value = &MsgValue{ContentType: TypeText, Content: []byte("world")}
+	msg, _ = CreateMsg(Adam, value, priKeyAdam, &MsgReference{SenderID: Adam.ID(), MsgID: msg.ID()})
+	if err := u.AddMsg(msg); err != nil || !handled {
+		t.Error("msg should be handled by content handler", err)
+	}
+	// custom content type is handled by the handler set
+	customType, handled := typeEnd+1, false
+	u.SetContentHandler(customType, ContentHandlerFunc(func(u *Universe, msg *Message) error {
+		handled = true
+		return nil
+	}))
+	msg, _ = CreateMsg(Adam, &MsgValue{ContentType: customType, Content: []byte("custom")}, priKeyAdam, &MsgReference{SenderID: Adam.ID(), MsgID: msg.ID()})
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(msgBytes, &decoded); err != nil {
+		t.Fatal("msg of custom content type should be decoded", err)
+	}
+	if err := u.AddMsg(&decoded); err != nil || !handled {
+		t.Error("msg of custom content type should be handled by content handler", err)
+	}
+}
+
+func TestUniverse_ValidateAndCommit(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg1, _ := CreateMsg(Adam, value, priKeyAdam)
+	msg2, _ := CreateMsg(Eve, value, priKeyEve, &MsgReference{SenderID: Adam.ID(), MsgID: msg1.ID()})
+	// validate msgs before commit
+	receipt1, err := u.Validate(msg1)
+	if err != nil {
+		t.Fatal("validate msg fail", err)
+	}
+	receipt2, err := u.Validate(msg2)
+	if err != nil {
+		t.Fatal("validate msg fail", err)
+	}
+	if receipt2.MsgID != msg2.ID() || receipt2.Msg() != msg2 {
+		t.Error("receipt not match msg")
+	}
+	if err := u.Commit(receipt1); err != nil {
+		t.Fatal("commit msg fail", err)
+	}
+	if err := u.Commit(receipt2); err != nil {
+		t.Fatal("commit msg fail", err)
+	}
+	if err := u.Commit(receipt2); err != ErrMsgAlreadyExist {
+		t.Errorf("err should be %s, but get %s", ErrMsgAlreadyExist, err)
+	}
+	if err := u.Commit(&Receipt{MsgID: msg1.ID()}); err != ErrReceiptNotValid {
+		t.Errorf("err should be %s, but get %s", ErrReceiptNotValid, err)
+	}
+	msg3 := *msg1
+	msg3.Signature = nil
+	if _, err := u.Validate(&msg3); err != ErrMsgStructureNotValid {
+		t.Errorf("err should be %s, but get %s", ErrMsgStructureNotValid, err)
+	}
+}
+
+func TestUniverse_Reject(t *testing.T) {
+	u, err := NewUniverseWithConfig(Eve, Adam, &UniverseConfig{SelfRefRequired: true})
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg1, _ := CreateMsg(Adam, value, priKeyAdam)
+	if err := u.AddMsg(msg1); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if r := u.Reject(msg1, u.AddMsg(msg1)); r.Code != RejectDuplicate || r.MsgID != msg1.ID() {
+		t.Error("rejection code should be RejectDuplicate, but get", r.Code)
+	}
+	msg2, _ := CreateMsg(Eve, value, priKeyEve, &MsgReference{SenderID: Adam.ID(), MsgID: msg1.ID()})
+	u.AddMsg(msg2)
+	msg3, _ := CreateMsg(Adam, value, priKeyAdam, &MsgReference{SenderID: Eve.ID(), MsgID: msg2.ID()})
+	r := u.Reject(msg3, u.AddMsg(msg3))
+	if r.Code != RejectRule || r.Rule != RuleSelfRefRequired || r.Reference.MsgID != msg1.ID() {
+		t.Error("rejection should contain the rule and reference", r)
+	}
+	missing := &MsgReference{SenderID: Adam.ID(), MsgID: common.Bytes2Hash([]byte("missing"))}
+	msg4, _ := CreateMsg(Eve, value, priKeyEve, missing, &MsgReference{SenderID: Eve.ID(), MsgID: msg2.ID()})
+	r = u.Reject(msg4, ErrMsgNotFound)
+	if r.Code != RejectReference || r.Reference.MsgID != missing.MsgID {
+		t.Error("rejection should contain the missing reference", r)
+	}
+}
+
+func TestUniverse_Rollback(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	var msgIDs []common.Hash
+	var refs []*MsgReference
+	for i := 0; i < 6; i++ {
+		value := &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("tp:%d", i))}
+		msg, _ := CreateMsg(Adam, value, priKeyAdam, refs...)
+		if err := u.AddMsg(msg); err != nil {
+			t.Fatal("add msg fail", err)
+		}
+		msgIDs = append(msgIDs, msg.ID())
+		refs = []*MsgReference{{SenderID: Adam.ID(), MsgID: msg.ID()}}
+		if i == 1 {
+			// msg from Eve before checkpoint
+			msgEve, _ := CreateMsg(Eve, value, priKeyEve, refs...)
+			if err := u.AddMsg(msgEve); err != nil {
+				t.Fatal("add msg fail", err)
+			}
+		}
+	}
+	cp, err := u.CreateCheckpoint(Adam.ID(), 3)
+	if err != nil {
+		t.Fatal("create checkpoint fail", err)
+	}
+	if err := u.Rollback(&Checkpoint{MsgID: common.Bytes2Hash([]byte("missing"))}, nil); err != ErrMsgNotFound {
+		t.Errorf("err should be %s, but get %s", ErrMsgNotFound, err)
+	}
+	wrongCP := *cp
+	wrongCP.StateRoot = common.Hash{}
+	if err := u.Rollback(&wrongCP, nil); err != ErrCheckpointNotMatch {
+		t.Errorf("err should be %s, but get %s", ErrCheckpointNotMatch, err)
+	}
+	errCommit := errors.New("commit fail")
+	if err := u.Rollback(cp, func(nu *Universe) error {
+		if nu.GetMaxSeq(Adam.ID()) != 3 {
+			t.Error("max seq of rebuilt universe should be 3")
+		}
+		return errCommit
+	}); err != errCommit {
+		t.Errorf("err should be %s, but get %v", errCommit, err)
+	}
+	if u.GetMaxSeq(Adam.ID()) != 6 {
+		t.Error("universe should not be changed if rollback fail")
+	}
+	if err := u.Rollback(cp, nil); err != nil {
+		t.Fatal("rollback fail", err)
+	}
+	if maxSeq := u.GetMaxSeq(Adam.ID()); maxSeq != 3 {
+		t.Error("max seq should be 3 after rollback, but get", maxSeq)
+	}
+	if u.HasMsg(msgIDs[3]) || !u.HasMsg(msgIDs[2]) {
+		t.Error("msgs after checkpoint should be removed")
+	}
+	if lastMsgID, _ := u.GetLastMsgID(Adam.ID()); lastMsgID != msgIDs[2] {
+		t.Error("last msg of Adam should be the msg of checkpoint")
+	}
+	if _, ok := u.GetLastMsgID(Eve.ID()); !ok {
+		t.Error("msg from Eve before checkpoint should be kept")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	aw, err := NewArchiveWriter(&buf, Eve, Adam)
+	if err != nil {
+		t.Fatal("create archive writer fail", err)
+	}
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msg1, _ := CreateMsg(Adam, value, priKeyAdam)
+	msg2, _ := CreateMsg(Adam, value, priKeyAdam, &MsgReference{SenderID: Adam.ID(), MsgID: msg1.ID()})
+	msg3, _ := CreateMsg(Eve, value, priKeyEve, &MsgReference{SenderID: Adam.ID(), MsgID: msg2.ID()})
+	u, _ := NewUniverse(Eve, Adam)
+	for _, msg := range []*Message{msg1, msg2, msg3} {
+		u.AddMsg(msg)
+		aw.WriteMsg(msg)
+	}
+	// duplicate msg will be rejected
+	aw.WriteMsg(msg3)
+	cp, _ := u.CreateCheckpoint(Adam.ID(), 2)
+	aw.WriteCheckpoint(cp)
+	wrongCP := *cp
+	wrongCP.StateRoot = common.Hash{}
+	aw.WriteCheckpoint(&wrongCP)
+
+	ru, rejections, err := Replay(&buf, *DefaultUniverseConfig())
+	divergence, ok := err.(*ReplayDivergence)
+	if !ok {
+		t.Fatal("replay should stop at the wrong checkpoint", err)
+	}
+	if divergence.Step != 4 || divergence.StateRoot != cp.StateRoot {
+		t.Error("divergence not match", divergence)
+	}
+	if len(rejections) != 1 || rejections[0].Code != RejectDuplicate {
+		t.Error("duplicate msg should be rejected", rejections)
+	}
+	if ru.GetMaxSeq(Adam.ID()) != 2 || !ru.HasMsg(msg3.ID()) {
+		t.Error("msgs should be replayed")
+	}
+	if _, _, err := Replay(bytes.NewBufferString("{}"), *DefaultUniverseConfig()); err != ErrArchiveRootsMissing {
+		t.Errorf("err should be %s, but get %s", ErrArchiveRootsMissing, err)
+	}
+
+	// replay stop once ctx done
+	buf.Reset()
+	aw, _ = NewArchiveWriter(&buf, Eve, Adam)
+	aw.WriteMsg(msg1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if ru, _, err := ReplayContext(ctx, &buf, *DefaultUniverseConfig()); err != context.Canceled || ru == nil || ru.HasMsg(msg1.ID()) {
+		t.Errorf("replay should be canceled before msgs, but get %v", err)
+	}
+}
+
+func TestUniverseID(t *testing.T) {
+	Adam, Eve, _, _, err := createAdamAndEve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID() != UniverseID(Adam, Eve) {
+		t.Error("universe ID should not depend on the order of roots")
+	}
+	Adam2, Eve2, _, _, err := createAdamAndEve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if UniverseID(Eve2, Adam2) == u.ID() {
+		t.Error("universe with diff roots should have diff ID")
+	}
+}
+
+func TestNewUniverseFromGenesis(t *testing.T) {
+	Adam, Eve, priKeyAdam, _, err := createAdamAndEve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("genesis")}, priKeyAdam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultUniverseConfig()
+	config.SelfRefRequired = true
+	genesisBytes, err := json.Marshal(NewGenesis(Eve, Adam, first, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := LoadGenesis(bytes.NewReader(genesisBytes))
+	if err != nil {
+		t.Fatal("load genesis fail", err)
+	}
+	u, err := NewUniverseFromGenesis(genesis)
+	if err != nil {
+		t.Fatal("create universe from genesis fail", err)
+	}
+	if u.ID() != UniverseID(Eve, Adam) || u.GetUserByID(Adam.ID()).LifeTime != Adam.LifeTime {
+		t.Error("root users of genesis not match")
+	}
+	if u.GetMsgByID(first.ID()) == nil || u.GetMaxSeq(Adam.ID()) != 1 {
+		t.Error("first msg of genesis should create space-time")
+	}
+	if !u.config.SelfRefRequired {
+		t.Error("config of genesis not used")
+	}
+
+	genesis.Roots = genesis.Roots[:1]
+	if _, err := NewUniverseFromGenesis(genesis); err != ErrGenesisNotValid {
+		t.Errorf("err should be %s, but get %s", ErrGenesisNotValid, err)
+	}
+}
+
+func TestUniverse_Network(t *testing.T) {
+	engine, _ := utils.SelectEngine(crypto.ETH)
+	var Adam, Eve *User
+	var priKeyAdam *crypto.PrivateKey
+	for Adam == nil || Eve == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user := CreateRootUser(*pubKey, "name", "extra"); user.Gender() {
+			Adam, priKeyAdam = user, priKey
+		} else {
+			Eve = user
+		}
+	}
+	config := DefaultUniverseConfig()
+	config.NetworkID = NetworkTest
+	testU, err := NewUniverseWithConfig(Eve, Adam, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainU, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testU.ID() == mainU.ID() || mainU.ID() != UniverseID(Eve, Adam) {
+		t.Error("genesis hash should depend on network")
+	}
+
+	value := &MsgValue{ContentType: TypeText, Content: []byte("hello testnet")}
+	msg, err := CreateMsgOnNetwork(NetworkTest, Adam, value, priKeyAdam, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mainU.AddMsg(msg); err != ErrMsgNetworkNotMatch {
+		t.Errorf("%s expected, but get %v", ErrMsgNetworkNotMatch, err)
+	}
+	if r := mainU.Reject(msg, ErrMsgNetworkNotMatch); r.Code != RejectRule || r.Rule != RuleNetwork {
+		t.Error("rejection should be network rule", r)
+	}
+	// network is signed, msg can not be moved to main network
+	relabeled := *msg
+	relabeled.Network = NetworkMain
+	relabeled.Seal()
+	if err := mainU.AddMsg(&relabeled); err != ErrMsgSignatureNotValid {
+		t.Errorf("%s expected, but get %v", ErrMsgSignatureNotValid, err)
+	}
+	if err := testU.AddMsg(msg); err != nil {
+		t.Error("msg on test network should be accepted", err)
+	}
+	mainMsg, _ := CreateMsg(Adam, value, priKeyAdam)
+	if mainMsg.ID() == msg.ID() {
+		t.Error("msg on diff network should have diff ID")
+	}
+
+	for name, network := range map[string]uint64{"": NetworkMain, "main": NetworkMain, "test": NetworkTest, "dev": NetworkDev, "42": 42} {
+		if n, err := NetworkByName(name); err != nil || n != network {
+			t.Errorf("network of %s should be %d, but get %d %v", name, network, n, err)
+		}
+	}
+	if _, err := NetworkByName("moon"); err != ErrNetworkNotValid {
+		t.Errorf("%s expected, but get %v", ErrNetworkNotValid, err)
+	}
+}
+
+func TestUniverse_Expiry(t *testing.T) {
+	config := DefaultUniverseConfig()
+	config.SearchEnable = true
+	u, err := NewUniverseWithConfig(Eve, Adam, config)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	first, err := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("seq 1")}, priKeyAdam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddMsg(first); err != nil {
+		t.Fatal(err)
+	}
+	ref := &MsgReference{SenderID: Adam.ID(), MsgID: first.ID()}
+	story, err := CreateMsgWithExpiry(NetworkMain, 2, Eve, &MsgValue{ContentType: TypeText, Content: []byte("story")}, priKeyEve, 0, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storyChunk, err := ChunkHash([]*Message{story})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateMsgWithExpiry(NetworkMain, 0, Eve, story.Value, priKeyEve, 0, ref); err != ErrMsgExpiryNotValid {
+		t.Errorf("%s expected, but get %v", ErrMsgExpiryNotValid, err)
+	}
+	// content is covered by content hash, not the signature
+	tampered := *story
+	tampered.Value = &MsgValue{ContentType: TypeText, Content: []byte("fake")}
+	if tampered.ID() != story.ID() {
+		t.Error("ID of ephemeral msg should not depend on content")
+	}
+	if _, err := u.Validate(&tampered); err != ErrMsgExpiryNotValid {
+		t.Errorf("%s expected, but get %v", ErrMsgExpiryNotValid, err)
+	}
+	if r := u.Reject(&tampered, ErrMsgExpiryNotValid); r.Code != RejectRule || r.Rule != RuleExpiry {
+		t.Error("rejection should be expiry rule", r)
+	}
+	// content change state of universe can not be ephemeral
+	follow, _ := json.Marshal(&ContentFollow{UserID: Adam.ID()})
+	stateMsg, err := CreateMsgWithExpiry(NetworkMain, 2, Eve, &MsgValue{ContentType: TypeFollow, Content: follow}, priKeyEve, 0, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Validate(stateMsg); err != ErrMsgExpiryNotValid {
+		t.Errorf("%s expected, but get %v", ErrMsgExpiryNotValid, err)
+	}
+	if err := u.AddMsg(story); err != nil {
+		t.Fatal("add ephemeral msg fail", err)
+	}
+	if seq, err := u.ExpirySeq(story.ID()); err != nil || seq != 3 {
+		t.Error("story should expire at seq 3, but", seq, err)
+	}
+	if msgs, _ := u.Search("story", 10); len(msgs) != 1 {
+		t.Error("story should be searched before expired")
+	}
+
+	last := first
+	for seq := 2; seq <= 3; seq++ {
+		if u.IsExpired(story.ID()) || story.ContentDropped() {
+			t.Error("story should not expire before seq 3")
+		}
+		msg, err := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("seq %d", seq))}, priKeyAdam,
+			&MsgReference{SenderID: Adam.ID(), MsgID: last.ID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := u.AddMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+		last = msg
+		if dropped := u.DropExpired(); len(dropped) != seq-2 {
+			t.Error("dropped msgs not match at seq", seq, len(dropped))
+		}
+	}
+	if !u.IsExpired(story.ID()) || !u.GetMsgByID(story.ID()).ContentDropped() {
+		t.Error("story should be expired and dropped at seq 3")
+	}
+	if u.IsExpired(first.ID()) {
+		t.Error("msg without expiry should never expire")
+	}
+	if msgs, _ := u.Search("story", 10); len(msgs) != 0 {
+		t.Error("expired content should not be searched")
+	}
+
+	// stub without content is still valid in other universe
+	stubBytes, err := json.Marshal(u.GetMsgByID(story.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stub Message
+	if err := json.Unmarshal(stubBytes, &stub); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stubBytes, []byte("story")) || stub.ID() != story.ID() {
+		t.Error("stub should keep ID without content")
+	}
+	nu, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nu.AddMsg(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := nu.AddMsg(&stub); err != nil {
+		t.Error("stub of expired msg should be accepted", err)
+	}
+	if chunk, _ := ChunkHash([]*Message{&stub}); chunk != storyChunk {
+		t.Error("chunk hash should not depend on dropped content")
+	}
+}
+
+func TestUniverse_BirthReveal(t *testing.T) {
+	engine, _ := utils.SelectEngine(crypto.ETH)
+	_, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, disclosure, err := CreateHiddenContentBirth("Alice", "born in 2020", &Auth{PublicKey: *pubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !content.User.Hidden() || content.User.Name != "" || content.User.BirthExtra != "" {
+		t.Error("name and extra should be hidden in birth content")
+	}
+	if commitment, _ := disclosure.Commitment(); !bytes.Equal(commitment, content.User.Commitment) {
+		t.Error("commitment not match the disclosure")
+	}
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ContentBirth
+	if err := json.Unmarshal(contentBytes, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.User.ID() != content.User.ID() || !decoded.User.Hidden() {
+		t.Error("hidden user not match after decoded")
+	}
+	if bytes.Contains(contentBytes, []byte("Alice")) {
+		t.Error("name should not be in birth content")
+	}
+
+	// root user can also be hidden, so the reveal is tested without births
+	var hidden *User
+	var priKeyHidden *crypto.PrivateKey
+	for hidden == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := CreateRootUser(*pubKey, "", "")
+		user.Commitment = content.User.Commitment
+		if user.Gender() != Adam.Gender() {
+			hidden, priKeyHidden = user, priKey
+		}
+	}
+	u, err := NewUniverse(hidden, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	first, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKeyAdam)
+	if err := u.AddMsg(first); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	reveal := func(sender *User, priKey *crypto.PrivateKey, d *BirthDisclosure) error {
+		content, _ := json.Marshal(d)
+		refs := []*MsgReference{{SenderID: Adam.ID(), MsgID: first.ID()}}
+		if lastMsgID, ok := u.GetLastMsgID(sender.ID()); ok && lastMsgID != first.ID() {
+			refs = append(refs, &MsgReference{SenderID: sender.ID(), MsgID: lastMsgID})
+		}
+		m, _ := CreateMsg(sender, &MsgValue{ContentType: TypeBirthReveal, Content: content}, priKey, refs...)
+		return u.AddMsg(m)
+	}
+	guess := *disclosure
+	guess.Salt = make([]byte, BirthSaltSize)
+	if err := reveal(hidden, priKeyHidden, &guess); err != ErrBirthRevealNotValid {
+		t.Errorf("%s expected, but get %v", ErrBirthRevealNotValid, err)
+	}
+	if err := reveal(Adam, priKeyAdam, disclosure); err != ErrBirthRevealNotValid {
+		t.Errorf("user not hidden can not reveal, but get %v", err)
+	}
+	if u.GetBirthDisclosure(hidden.ID()) != nil {
+		t.Error("user should not be revealed yet")
+	}
+	if err := reveal(hidden, priKeyHidden, disclosure); err != nil {
+		t.Fatal("reveal fail", err)
+	}
+	if d := u.GetBirthDisclosure(hidden.ID()); d == nil || d.Name != "Alice" || d.Extra != "born in 2020" {
+		t.Error("disclosure not match", d)
+	}
+	if err := reveal(hidden, priKeyHidden, disclosure); err != ErrBirthRevealNotValid {
+		t.Errorf("user can reveal once, but get %v", err)
+	}
+}
+
+func TestUniverse_ConsentWindow(t *testing.T) {
+	config := DefaultUniverseConfig()
+	config.ConsentWindow = 2
+	u, err := NewUniverseWithConfig(Eve, Adam, config)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	// time proofs of Adam from seq 1 to 4
+	var tps []*MsgReference
+	for seq := 1; seq <= 4; seq++ {
+		var refs []*MsgReference
+		if len(tps) > 0 {
+			refs = append(refs, tps[len(tps)-1])
+		}
+		msg, err := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("seq %d", seq))}, priKeyAdam, refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := u.AddMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+		tps = append(tps, &MsgReference{SenderID: Adam.ID(), MsgID: msg.ID()})
+	}
+
+	_, pubKey, err := universeEngine.GenKey(crypto.MultipleSignatures, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consent := func(ref *MsgReference) *ContentBirth {
+		content, _ := CreateContentBirth("A4", "consent", &Auth{PublicKey: *pubKey})
+		content.SignByParentAt(Adam, *priKeyAdam, ref)
+		content.SignByParentAt(Eve, *priKeyEve, ref)
+		return content
+	}
+	birth := func(content *ContentBirth, seen *MsgReference) error {
+		value := &MsgValue{ContentType: TypeBirth}
+		value.Content, _ = json.Marshal(content)
+		msg, err := CreateMsg(Eve, value, priKeyEve, seen)
+		if err != nil {
+			return err
+		}
+		return validateConsent(u, msg)
+	}
+
+	if err := birth(consent(nil), tps[0]); err != ErrConsentNotValid {
+		t.Errorf("consent without time proof should be %s, but get %v", ErrConsentNotValid, err)
+	}
+	content := consent(tps[0])
+	if err := birth(content, tps[2]); err != nil {
+		t.Error("birth within consent window should be valid", err)
+	}
+	if err := birth(content, tps[3]); err != ErrConsentExpired {
+		t.Errorf("%s expected, but get %v", ErrConsentExpired, err)
+	}
+	if r := u.Reject(&Message{Value: &MsgValue{ContentType: TypeBirth}}, ErrConsentExpired); r.Rule != RuleConsentWindow {
+		t.Error("rejection should be consent window rule", r)
+	}
+	// ref is signed, can not be moved forward
+	moved := *content
+	moved.Parents[0].Ref, moved.Parents[1].Ref = tps[3], tps[3]
+	if err := birth(&moved, tps[3]); err != ErrConsentNotValid {
+		t.Errorf("%s expected, but get %v", ErrConsentNotValid, err)
+	}
+
+	// Eve revoke the consent before birth included
+	revoke, _ := json.Marshal(CreateContentConsentRevoke(content))
+	msg, err := CreateMsg(Eve, &MsgValue{ContentType: TypeConsentRevoke, Content: revoke}, priKeyEve, tps[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal("revoke consent fail", err)
+	}
+	if !u.IsConsentRevoked(content.ConsentID(), Eve.ID()) || u.IsConsentRevoked(content.ConsentID(), Adam.ID()) {
+		t.Error("consent should be revoked by Eve only")
+	}
+	if err := birth(content, tps[2]); err != ErrConsentRevoked {
+		t.Errorf("%s expected, but get %v", ErrConsentRevoked, err)
+	}
+	// consent can not be revoked after birth included
+	included := consent(tps[0])
+	u.born[included.ConsentID()] = true
+	revoke, _ = json.Marshal(CreateContentConsentRevoke(included))
+	msg, _ = CreateMsg(Adam, &MsgValue{ContentType: TypeConsentRevoke, Content: revoke}, priKeyAdam, tps[3])
+	if err := u.AddMsg(msg); err != ErrConsentRevokeNotValid {
+		t.Errorf("%s expected, but get %v", ErrConsentRevokeNotValid, err)
+	}
+}
+
+func TestUniverse_GetUserScore(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	if score, err := u.GetUserScore(Adam.ID()); err != nil || score.Score != 1 || score.Depth != 0 {
+		t.Error("root user should get full score", score, err)
+	}
+	if _, err := u.GetUserScore(common.Hash{}); err != ErrUserNotExist {
+		t.Errorf("%s expected, but get %v", ErrUserNotExist, err)
+	}
+	// time proofs of Adam, parents can reproduce after the interval
+	var last *MsgReference
+	tick := func(n uint64) {
+		for i := uint64(0); i < n; i++ {
+			var refs []*MsgReference
+			if last != nil {
+				refs = append(refs, last)
+			}
+			msg, _ := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte(fmt.Sprintf("tick %d", i))}, priKeyAdam, refs...)
+			if err := u.AddMsg(msg); err != nil {
+				t.Fatal("add msg fail", err)
+			}
+			last = &MsgReference{SenderID: Adam.ID(), MsgID: msg.ID()}
+		}
+	}
+	tick(rule.ReproductionInterval + 2)
+
+	_, pubKey, err := universeEngine.GenKey(crypto.MultipleSignatures, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := CreateContentBirth("A5", "score", &Auth{PublicKey: *pubKey})
+	content.SignByParent(Adam, *priKeyAdam)
+	content.SignByParent(Eve, *priKeyEve)
+	value := &MsgValue{ContentType: TypeBirth}
+	value.Content, _ = json.Marshal(content)
+	birth, _ := CreateMsg(Eve, value, priKeyEve, last)
+	if err := u.AddMsg(birth); err != nil {
+		t.Fatal("add birth msg fail", err)
+	}
+	child, err := CreateNewUser(u, birth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	score, err := u.GetUserScore(child.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score.Depth != 1 || score.Diversity != 1 || score.Age != 0 || score.Score != 0 {
+		t.Error("new born user should have no score", score)
+	}
+
+	tick(3)
+	score, _ = u.GetUserScore(child.ID())
+	if expect := ScoreDepthDecay * 3 / float64(ScoreMatureAge); score.Age != 3 || score.Score != expect {
+		t.Errorf("score should be %f, but get %v", expect, score)
+	}
+}
+
+func TestUniverse_DeviceAuth(t *testing.T) {
+	u, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	tick := func(last *Message) *Message {
+		var refs []*MsgReference
+		if last != nil {
+			refs = append(refs, &MsgReference{SenderID: Adam.ID(), MsgID: last.ID()})
+		}
+		msg, err := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("tick")}, priKeyAdam, refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := u.AddMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	tp1 := tick(nil)
+	ref := &MsgReference{SenderID: Adam.ID(), MsgID: tp1.ID()}
+
+	devicePriKey, devicePubKey, err := universeEngine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPriKey, otherPubKey, _ := universeEngine.GenKey(crypto.Signature2PublicKey)
+	text := &MsgValue{ContentType: TypeText, Content: []byte("from phone")}
+
+	// device not authorized yet
+	msg, err := CreateDeviceMsg(NetworkMain, Eve, devicePubKey, text, devicePriKey, 0, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddMsg(msg); err != ErrDeviceNotAuthorized {
+		t.Errorf("%s expected, but get %v", ErrDeviceNotAuthorized, err)
+	}
+	if r := u.Reject(msg, ErrDeviceNotAuthorized); r.Code != RejectSignature {
+		t.Error("rejection should be signature", r)
+	}
+
+	masterAuth, _ := json.Marshal(CreateContentDeviceAuth(&Eve.Auth.PublicKey, 0))
+	if m, _ := CreateMsg(Eve, &MsgValue{ContentType: TypeDeviceAuth, Content: masterAuth}, priKeyEve, ref); u.AddMsg(m) != ErrDeviceAuthNotValid {
+		t.Error("master key can not be authorized as device")
+	}
+	badScope, _ := json.Marshal(CreateContentDeviceAuth(devicePubKey, 0, TypeDeviceAuth))
+	if m, _ := CreateMsg(Eve, &MsgValue{ContentType: TypeDeviceAuth, Content: badScope}, priKeyEve, ref); u.AddMsg(m) != ErrDeviceAuthNotValid {
+		t.Error("device can not be authorized to sign master only types")
+	}
+	authContent, _ := json.Marshal(CreateContentDeviceAuth(devicePubKey, 2, TypeText))
+	authMsg, err := CreateMsg(Eve, &MsgValue{ContentType: TypeDeviceAuth, Content: authContent}, priKeyEve, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddMsg(authMsg); err != nil {
+		t.Fatal("add device auth fail", err)
+	}
+	if content, authMsgID := u.GetDeviceAuth(Eve.ID(), Auth{PublicKey: *devicePubKey}); content == nil || authMsgID != authMsg.ID() {
+		t.Error("device should be authorized by msg", authMsgID)
+	}
+
+	// the device signed msg is accepted after authorized, also by others
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal("device signed msg should be accepted", err)
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(msgBytes, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	nu, _ := NewUniverse(Eve, Adam)
+	for _, m := range []*Message{tp1, authMsg, &decoded} {
+		if err := nu.AddMsg(m); err != nil {
+			t.Fatal("device signed msg should be accepted by others", err)
+		}
+	}
+
+	follow, _ := json.Marshal(&ContentFollow{UserID: Adam.ID()})
+	if m, _ := CreateDeviceMsg(NetworkMain, Eve, devicePubKey, &MsgValue{ContentType: TypeFollow, Content: follow}, devicePriKey, 0, ref); u.AddMsg(m) != ErrDeviceNotAuthorized {
+		t.Error("msg out of scopes of device should be rejected")
+	}
+	if m, _ := CreateDeviceMsg(NetworkMain, Eve, devicePubKey, &MsgValue{ContentType: TypeDeviceAuth, Content: badScope}, devicePriKey, 0, ref); u.AddMsg(m) != ErrDeviceAuthNotValid {
+		t.Error("device can not authorize other device")
+	}
+	if m, _ := CreateDeviceMsg(NetworkMain, Eve, otherPubKey, &MsgValue{ContentType: TypeText, Content: []byte("from tablet")}, otherPriKey, 0, ref); u.AddMsg(m) != ErrDeviceNotAuthorized {
+		t.Error("msg signed by other device should be rejected")
+	}
+	if m, _ := CreateDeviceMsg(NetworkMain, Eve, devicePubKey, &MsgValue{ContentType: TypeText, Content: []byte("forged")}, otherPriKey, 0, ref); u.AddMsg(m) != ErrMsgSignatureNotValid {
+		t.Error("msg not signed by device key should be rejected")
+	}
+
+	// authorization expire after 2 sequences from seq 1
+	tp2 := tick(tp1)
+	m, _ := CreateDeviceMsg(NetworkMain, Eve, devicePubKey, &MsgValue{ContentType: TypeText, Content: []byte("seq 2")}, devicePriKey, 0,
+		&MsgReference{SenderID: Adam.ID(), MsgID: tp2.ID()})
+	if err := u.AddMsg(m); err != nil {
+		t.Error("device signed msg should be accepted before expired", err)
+	}
+	tp3 := tick(tp2)
+	m, _ = CreateDeviceMsg(NetworkMain, Eve, devicePubKey, &MsgValue{ContentType: TypeText, Content: []byte("seq 3")}, devicePriKey, 0,
+		&MsgReference{SenderID: Adam.ID(), MsgID: tp3.ID()})
+	if err := u.AddMsg(m); err != ErrDeviceAuthExpired {
+		t.Errorf("%s expected, but get %v", ErrDeviceAuthExpired, err)
+	}
+	if r := u.Reject(m, ErrDeviceAuthExpired); r.Code != RejectRule || r.Rule != RuleDeviceExpiry {
+		t.Error("rejection should be device expiry rule", r)
+	}
+}
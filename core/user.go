@@ -17,7 +17,7 @@
 package core
 
 import (
-	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/big"
@@ -44,6 +44,9 @@ type User struct {
 	Auth       *Auth    `json:"auth"`
 	BirthMsg   *Message `json:"birthMsg"`
 	LifeTime   uint64   `json:"lifeTime"`
+	Commitment []byte   `json:"commitment,omitempty"` // commitment of hidden name and extra, see BirthDisclosure
+
+	hasher common.Hasher // hasher of universe the user in, SHA-256 if nil
 }
 
 // CreateRootUser try to create root user by public key
@@ -67,6 +70,7 @@ func CreateNewUser(universe *Universe, msg *Message) (*User, error) {
 	}
 	newUser := contentBirth.User
 	newUser.BirthMsg = msg
+	newUser.hasher = universe.hasher
 	// calculate the life time of new user
 	p0 := universe.userD.GetVertex(contentBirth.Parents[0].UserID)
 	if p0 == nil {
@@ -90,10 +94,25 @@ func CreateNewUser(universe *Universe, msg *Message) (*User, error) {
 	return &newUser, nil
 }
 
+// Hasher return the hasher used to calculate the ID of user and the msgs
+// created by user, which is the hasher of universe the user in
+func (u User) Hasher() common.Hasher {
+	if u.hasher == nil {
+		return common.DefaultHasher()
+	}
+	return u.hasher
+}
+
+// SetHasher bind the user to the hasher of universe, should be called before
+// the ID of user be used, such as the root users created for new universe
+func (u *User) SetHasher(h common.Hasher) {
+	u.hasher = h
+}
+
 // ID return the vertex.id, related to parents and value of the vertex
 // ID cloud use as address of user account
 func (u User) ID() common.Hash {
-	hash := sha256.New()
+	hash := u.Hasher().New()
 	hash.Reset()
 
 	auth, _ := json.Marshal(u.Auth)
@@ -110,9 +129,19 @@ func (u User) ID() common.Hash {
 		birthMsg += fmt.Sprintf("%v%v", u.BirthMsg.Value.Content, u.BirthMsg.Value.ContentType)
 	}
 	hash.Write(append(append(append(append([]byte(u.Name), u.BirthExtra...), auth...), birthMsg...), lifeTime...))
+	// users created before commitment be introduced keep the ID
+	if u.Hidden() {
+		hash.Write([]byte(fmt.Sprintf("commitment%x", u.Commitment)))
+	}
 	return common.Bytes2Hash(hash.Sum(nil))
 }
 
+// Hidden return true if the name and extra of user are committed by hash,
+// instead of in cleartext
+func (u User) Hidden() bool {
+	return len(u.Commitment) > 0
+}
+
 // Gender return the gender of user, true = male = end of ID is odd
 func (u User) Gender() bool {
 	hashID := u.ID()
@@ -194,22 +223,48 @@ func (u User) ParentsID() [2]common.Hash {
 	return parentsID
 }
 
-// UnmarshalJSON is used to unmarshal json
+// UnmarshalJSON is used to unmarshal json, the fields are required and
+// validated by schema
 func (u *User) UnmarshalJSON(input []byte) error {
-	userMap := make(map[string]interface{})
-	err := json.Unmarshal(input, &userMap)
-	if err != nil {
+	var raw struct {
+		Name       *string `json:"name"`
+		BirthExtra *string `json:"birthExtra"`
+		LifeTime   *string `json:"lifeTime"`
+		BirthMsg   *string `json:"birthMsg"`
+		Auth       *string `json:"auth"`
+		Commitment string  `json:"commitment"`
+	}
+	if err := json.Unmarshal(input, &raw); err != nil {
 		return err
 	}
-	u.Name = userMap["name"].(string)
-	u.BirthExtra = userMap["birthExtra"].(string)
-	u.LifeTime, err = strconv.ParseUint(userMap["lifeTime"].(string), 0, 64)
-	if err != nil {
+	fields := []string{"name", "birthExtra", "lifeTime", "birthMsg", "auth"}
+	for i, v := range []*string{raw.Name, raw.BirthExtra, raw.LifeTime, raw.BirthMsg, raw.Auth} {
+		if v == nil {
+			return schemaError("User", fields[i], "is required")
+		}
+	}
+	var user User
+	var err error
+	user.Name = *raw.Name
+	user.BirthExtra = *raw.BirthExtra
+	if user.LifeTime, err = strconv.ParseUint(*raw.LifeTime, 0, 64); err != nil {
+		return schemaError("User", "lifeTime", "%s is not number", *raw.LifeTime)
+	}
+	if err := json.Unmarshal([]byte(*raw.BirthMsg), &user.BirthMsg); err != nil {
 		return err
 	}
-	json.Unmarshal([]byte(userMap["birthMsg"].(string)), &u.BirthMsg)
-	json.Unmarshal([]byte(userMap["auth"].(string)), &u.Auth)
-
+	if err := json.Unmarshal([]byte(*raw.Auth), &user.Auth); err != nil {
+		return err
+	}
+	if raw.Commitment != "" {
+		if user.Commitment, err = hex.DecodeString(raw.Commitment); err != nil {
+			return schemaError("User", "commitment", "is not hex")
+		}
+	}
+	if err := user.validateSchema(); err != nil {
+		return err
+	}
+	*u = user
 	return nil
 }
 
@@ -230,6 +285,9 @@ func (u *User) MarshalJSON() ([]byte, error) {
 		return []byte{}, err
 	}
 	userMap["birthMsg"] = string(birthMsg)
+	if u.Hidden() {
+		userMap["commitment"] = hex.EncodeToString(u.Commitment)
+	}
 
 	return json.Marshal(userMap)
 }
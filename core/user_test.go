@@ -208,3 +208,59 @@ func createRootUsers() (*User, crypto.PrivateKey, *User, crypto.PrivateKey) {
 	}
 	return Adam, APK, Eve, EPK
 }
+
+func TestUser_Schema(t *testing.T) {
+	userEngine, _ = utils.SelectEngine(defaultEngineName)
+	_, pubKey, err := userEngine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := CreateRootUser(*pubKey, "name", "extra")
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(userBytes, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for field, v := range map[string]interface{}{
+		"auth":       nil,
+		"lifeTime":   "forever",
+		"name":       string(make([]byte, MaxUserNameSize+1)),
+		"birthExtra": 1,
+		"commitment": "00",
+	} {
+		broken := make(map[string]interface{})
+		for k, fv := range fields {
+			broken[k] = fv
+		}
+		if v == nil {
+			delete(broken, field)
+		} else {
+			broken[field] = v
+		}
+		brokenBytes, _ := json.Marshal(broken)
+		var decoded User
+		if err := json.Unmarshal(brokenBytes, &decoded); err == nil {
+			t.Errorf("%s should not be valid", field)
+		}
+	}
+
+	cb, err := CreateContentBirth("name", "extra", user.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.Parents[0].Signature = []byte("sig")
+	cbBytes, err := json.Marshal(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ContentBirth
+	if err := json.Unmarshal(cbBytes, &decoded); err == nil {
+		t.Error("signature of parent without user should not be valid")
+	}
+	if err := json.Unmarshal([]byte(`{"Parents":[]}`), &decoded); err == nil {
+		t.Error("user of birth content is required")
+	}
+}
@@ -21,6 +21,10 @@ import "fmt"
 const (
 	// UserStatusNormal is the status of user, will be add more later, like punished...
 	UserStatusNormal = iota
+	// UserStatusHidden is the public state of user not be listed in space-time
+	UserStatusHidden
+	// UserStatusBanned is the public state of user banned by owner of space-time
+	UserStatusBanned
 )
 
 // UserInfo contain the information except pass by BirthMsg
@@ -31,6 +35,7 @@ type UserInfo struct {
 	natureLastCosign uint64 // last Birth cosign
 	natureLifeMaxSeq uint64 // max time sequence this use can use as reference in this space time
 	natureBirthSeq   uint64 // sequence of birth in this space time
+	publicState      int    // public state set by owner of this space time
 	localNickname    string
 }
 
@@ -40,7 +45,12 @@ func NewUserInfo(name string, life uint64, BirthSeq uint64) *UserInfo {
 	return &UserInfo{natureState: UserStatusNormal, natureLastCosign: BirthSeq, natureLifeMaxSeq: life, natureBirthSeq: BirthSeq, localNickname: name}
 }
 
+// PublicState return the public state of user set by owner of space time
+func (ui UserInfo) PublicState() int {
+	return ui.publicState
+}
+
 // String used to print user info
 func (ui UserInfo) String() string {
-	return fmt.Sprintf("localNickname:\t%s\tnatureState:\t%d\tnatureLastCosign:\t%d\tnatureLifeMaxSeq:\t%d\tnatureBirthSeq:\t%d\t", ui.localNickname, ui.natureState, ui.natureLastCosign, ui.natureLifeMaxSeq, ui.natureBirthSeq)
+	return fmt.Sprintf("localNickname:\t%s\tnatureState:\t%d\tnatureLastCosign:\t%d\tnatureLifeMaxSeq:\t%d\tnatureBirthSeq:\t%d\tpublicState:\t%d\t", ui.localNickname, ui.natureState, ui.natureLastCosign, ui.natureLifeMaxSeq, ui.natureBirthSeq, ui.publicState)
 }
@@ -0,0 +1,338 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// Validator is one stage of the validation pipeline in Universe.AddMsg,
+// the msg will be rejected if any validator return error. Custom validators,
+// such as spam filter or content policy, can be added by Universe.AddValidator.
+// Validators which only read the msg and the users, such as signature check, are
+// run in Universe.Validate, others depend on msgs in universe run in Universe.Commit.
+type Validator interface {
+	Validate(u *Universe, msg *Message) error
+}
+
+// ValidatorFunc is an adapter to allow the use of ordinary functions as Validator
+type ValidatorFunc func(u *Universe, msg *Message) error
+
+// Validate calls f(u, msg)
+func (f ValidatorFunc) Validate(u *Universe, msg *Message) error {
+	return f(u, msg)
+}
+
+// ContentHandler process the msg by content type, after msg passed all
+// validators and be added into universe. The msg can not be removed from
+// universe once added, so handler should not fail on the msg passed the
+// validators, the checks of content belong to the Validator of its type.
+type ContentHandler interface {
+	Handle(u *Universe, msg *Message) error
+}
+
+// ContentHandlerFunc is an adapter to allow the use of ordinary functions as ContentHandler
+type ContentHandlerFunc func(u *Universe, msg *Message) error
+
+// Handle calls f(u, msg)
+func (f ContentHandlerFunc) Handle(u *Universe, msg *Message) error {
+	return f(u, msg)
+}
+
+// defaultVerifiers return the validators run in Universe.Validate, in order of
+// structural check, network check, content hash of ephemeral msg, PoW check, signature check, the handle of name claim
+// and the structure of file chunk, which can be run in parallel.
+func defaultVerifiers() []Validator {
+	return []Validator{
+		ValidatorFunc(validateStructure),
+		ValidatorFunc(validateNetwork),
+		ValidatorFunc(validateExpiry),
+		ValidatorFunc(validatePoW),
+		ValidatorFunc(validateSignature),
+		ValidatorFunc(validateTimestampDrift),
+		ValidatorFunc(validateNameClaim),
+		ValidatorFunc(validateFile),
+	}
+}
+
+// defaultValidators return the validators run in Universe.Commit, in order of
+// sender validity, reference validation, timestamp hint order, the original
+// of repost and edit, the user followed, the new user born, the disclosure of hidden user, the consent
+// of parents and its revocation, the authorization of device signed msg, and the
+// owner of space-time which user state updated.
+func defaultValidators() []Validator {
+	return []Validator{
+		ValidatorFunc(validateSender),
+		ValidatorFunc(validateReference),
+		ValidatorFunc(validateTimestampOrder),
+		ValidatorFunc(validateRepost),
+		ValidatorFunc(validateEdit),
+		ValidatorFunc(validateFollow),
+		ValidatorFunc(validateBirth),
+		ValidatorFunc(validateBirthReveal),
+		ValidatorFunc(validateConsent),
+		ValidatorFunc(validateConsentRevoke),
+		ValidatorFunc(validateDevice),
+		ValidatorFunc(validateUserStateUpdate),
+	}
+}
+
+// validateStructure check if the fields of msg is complete
+func validateStructure(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Signature == nil {
+		return ErrMsgStructureNotValid
+	}
+	for _, r := range msg.Reference {
+		if r == nil {
+			return ErrMsgStructureNotValid
+		}
+	}
+	return nil
+}
+
+// defaultContentHandlers return the handler of content types which change universe state
+func defaultContentHandlers() map[int]ContentHandler {
+	return map[int]ContentHandler{
+		TypeBirth:           ContentHandlerFunc(handleBirth),
+		TypeUserStateUpdate: ContentHandlerFunc(handleUserStateUpdate),
+		TypeRepost:          ContentHandlerFunc(handleRepost),
+		TypeNameClaim:       ContentHandlerFunc(handleNameClaim),
+		TypeFollow:          ContentHandlerFunc(handleFollow),
+		TypeUnfollow:        ContentHandlerFunc(handleFollow),
+		TypeBirthReveal:     ContentHandlerFunc(handleBirthReveal),
+		TypeConsentRevoke:   ContentHandlerFunc(handleConsentRevoke),
+		TypeDeviceAuth:      ContentHandlerFunc(handleDeviceAuth),
+		TypeEdit:            ContentHandlerFunc(handleEdit),
+	}
+}
+
+// validateSignature verify the signature of msg by public key of sender, or
+// by the device key if msg signed by device, which authorization is checked
+// by validateDevice
+func validateSignature(u *Universe, msg *Message) error {
+	sender := u.GetUserByID(msg.SenderID)
+	if sender == nil {
+		return ErrUserNotExist
+	}
+	return verifyMsgBySender(msg, sender)
+}
+
+// verifyMsgBySender verify the signature of msg by public key of sender, or
+// by the device key if msg signed by device. The public key recovered from
+// compact signature is compared instead if engine support.
+func verifyMsgBySender(msg *Message, sender *User) error {
+	auth := sender.Auth
+	if msg.Device != nil {
+		auth = msg.Device
+	}
+	if pubKey, err := recoverMsgSigner(msg); err == nil {
+		if !auth.Equal(Auth{PublicKey: *pubKey}) {
+			return ErrMsgSignatureNotValid
+		}
+		return nil
+	} else if err != crypto.ErrSigNotRecoverable {
+		return ErrMsgSignatureNotValid
+	}
+	signature := *msg.Signature
+	signature.PubKey = auth.PubKey
+	m := *msg
+	m.Signature = &signature
+	if ok, err := VerifyMsg(m); err != nil || !ok {
+		return ErrMsgSignatureNotValid
+	}
+	return nil
+}
+
+// validateSender check if sender is validated in at least one space-time
+func validateSender(u *Universe, msg *Message) error {
+	if !u.CheckUserExist(msg.SenderID) {
+		return ErrUserNotExist
+	}
+	return nil
+}
+
+// validateReference check if msg already exist and the self reference rule
+func validateReference(u *Universe, msg *Message) error {
+	if u.HasMsg(msg.ID()) {
+		return ErrMsgAlreadyExist
+	}
+	return u.checkSelfRef(msg)
+}
+
+// validateNetwork check the msg is created on the network of universe
+func validateNetwork(u *Universe, msg *Message) error {
+	if msg.Network != u.config.NetworkID {
+		return ErrMsgNetworkNotMatch
+	}
+	return nil
+}
+
+// validateTimestampDrift check the timestamp hint of msg is not too far later
+// than the local clock. The check is loose, because the hint is only for display.
+func validateTimestampDrift(u *Universe, msg *Message) error {
+	if msg.Timestamp == 0 || u.config.MaxTimestampDrift == 0 {
+		return nil
+	}
+	if msg.Timestamp > uint64(u.now().Unix())+u.config.MaxTimestampDrift {
+		return ErrMsgTimestampDrift
+	}
+	return nil
+}
+
+// validateTimestampOrder check the timestamp hint of msg is not earlier than
+// the hints of referenced msgs, msgs without hint are skipped.
+func validateTimestampOrder(u *Universe, msg *Message) error {
+	if msg.Timestamp == 0 {
+		return nil
+	}
+	for _, r := range msg.Reference {
+		if ref := u.GetMsgByID(r.MsgID); ref != nil && ref.Timestamp > msg.Timestamp {
+			return ErrMsgTimestampTooEarly
+		}
+	}
+	return nil
+}
+
+// validateRepost check the original msg of repost exist in universe and
+// sent by the author in content, each user can repost the msg only once.
+func validateRepost(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeRepost {
+		return nil
+	}
+	var cr ContentRepost
+	if err := json.Unmarshal(msg.Value.Content, &cr); err != nil {
+		return err
+	}
+	original := u.GetMsgByID(cr.MsgID)
+	if original == nil {
+		return ErrRepostOriginalNotFound
+	}
+	if original.SenderID != cr.AuthorID || original.Value.ContentType == TypeRepost {
+		return ErrRepostNotValid
+	}
+	if u.hasRepost(cr.MsgID, msg.SenderID) {
+		return ErrRepostAlreadyExist
+	}
+	return nil
+}
+
+// validateEdit check the original msg of edit exist in universe, sent by
+// the sender self, and its content type can be edited.
+func validateEdit(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeEdit {
+		return nil
+	}
+	var ce ContentEdit
+	if err := json.Unmarshal(msg.Value.Content, &ce); err != nil {
+		return err
+	}
+	original := u.GetMsgByID(ce.MsgID)
+	if original == nil {
+		return ErrEditOriginalNotFound
+	}
+	if original.SenderID != msg.SenderID || !editable(original) {
+		return ErrEditNotValid
+	}
+	return nil
+}
+
+// validateFollow check the user followed or unfollowed exist and is not the sender
+func validateFollow(u *Universe, msg *Message) error {
+	if msg.Value == nil || (msg.Value.ContentType != TypeFollow && msg.Value.ContentType != TypeUnfollow) {
+		return nil
+	}
+	var content ContentFollow
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	if content.UserID == msg.SenderID || u.GetUserByID(content.UserID) == nil {
+		return ErrFollowNotValid
+	}
+	return nil
+}
+
+// validateNameClaim check the handle of name claim is normalized, the owner
+// of handle is decided after msg added, so the claim of handle already owned
+// by others is not rejected.
+func validateNameClaim(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeNameClaim {
+		return nil
+	}
+	var content ContentNameClaim
+	if err := json.Unmarshal(msg.Value.Content, &content); err != nil {
+		return err
+	}
+	if handle, err := NormalizeHandle(content.Handle); err != nil || handle != content.Handle {
+		return ErrHandleNotValid
+	}
+	return nil
+}
+
+// validateFile check the structure of file chunk, the data stored out of msg
+// is not resolved here
+func validateFile(u *Universe, msg *Message) error {
+	if msg.Value == nil || msg.Value.ContentType != TypeFile {
+		return nil
+	}
+	var cf ContentFile
+	if err := json.Unmarshal(msg.Value.Content, &cf); err != nil {
+		return err
+	}
+	return cf.check()
+}
+
+// handleBirth add the new user created by birth msg
+func handleBirth(u *Universe, msg *Message) error {
+	return u.addUserByMsg(msg)
+}
+
+// handleRepost add the repost into the repost index of original msg
+func handleRepost(u *Universe, msg *Message) error {
+	var cr ContentRepost
+	if err := json.Unmarshal(msg.Value.Content, &cr); err != nil {
+		return err
+	}
+	u.addRepost(cr.MsgID, msg)
+	return nil
+}
+
+// handleEdit add the edit into the version chain of original msg
+func handleEdit(u *Universe, msg *Message) error {
+	var ce ContentEdit
+	if err := json.Unmarshal(msg.Value.Content, &ce); err != nil {
+		return err
+	}
+	u.addEdit(ce.MsgID, msg)
+	return nil
+}
+
+// handleNameClaim add the claim of handle into the name index of space-times
+func handleNameClaim(u *Universe, msg *Message) error {
+	return u.addNameClaimByMsg(msg)
+}
+
+// handleFollow update the follow graph by follow or unfollow msg
+func handleFollow(u *Universe, msg *Message) error {
+	return u.updateFollowByMsg(msg)
+}
+
+// handleUserStateUpdate set the public state of user in space time of sender
+func handleUserStateUpdate(u *Universe, msg *Message) error {
+	return u.updateUserStateByMsg(msg)
+}
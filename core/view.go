@@ -0,0 +1,39 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "github.com/pdupub/go-pdu/common"
+
+// View is the handle of universe at the time it is taken, the msgs committed
+// after are hidden from the queries paging through it, so pages are not
+// shifted by msgs arriving concurrently. Nil view means the latest universe.
+// The msgs are committed by node in the order saved in db, so the view also
+// bound the msgs queried from db.
+type View struct {
+	Seq uint64 `json:"seq"` // count of msgs committed when taken
+}
+
+// View return the handle of current universe
+func (u Universe) View() *View {
+	return &View{Seq: uint64(len(u.commitSeqs))}
+}
+
+// InView return true if msg is committed before the view taken
+func (u Universe) InView(view *View, msgID common.Hash) bool {
+	seq, ok := u.commitSeqs[msgID]
+	return ok && (view == nil || seq <= view.Seq)
+}
@@ -19,6 +19,7 @@ package bitcoin
 import (
 	"crypto/ecdsa"
 	"encoding/hex"
+	"io"
 	"math/big"
 
 	btc "github.com/btcsuite/btcd/btcec"
@@ -140,12 +141,25 @@ func (e BEngine) Sign(hash []byte, priKey *crypto.PrivateKey) (*crypto.Signature
 	return crypto.Sign(e.name, hash, priKey, sign)
 }
 
+// isCompact check if the signature start with the recovery bit of compact signature,
+// the DER signature always start with 0x30, so they can not be confused
+func isCompact(sig []byte) bool {
+	return len(sig) > 0 && sig[0] >= 27 && sig[0] <= 34
+}
+
 func verify(hash []byte, pubKey interface{}, sig []byte) (bool, error) {
-	signature, err := btc.ParseSignature(sig, btc.S256())
+	pk, err := parsePubKey(pubKey)
 	if err != nil {
 		return false, err
 	}
-	pk, err := parsePubKey(pubKey)
+	if isCompact(sig) {
+		recoveredPubKey, err := recoverPubKey(hash, sig)
+		if err != nil {
+			return false, err
+		}
+		return recoveredPubKey.X.Cmp(pk.X) == 0 && recoveredPubKey.Y.Cmp(pk.Y) == 0, nil
+	}
+	signature, err := btc.ParseSignature(sig, btc.S256())
 	if err != nil {
 		return false, err
 	}
@@ -156,8 +170,11 @@ func parseMulSig(signature []byte) [][]byte {
 	currentSigLeft := -1
 	var currentSig []byte
 	var sigs [][]byte
-	for _, v := range signature {
-		if currentSigLeft == -1 {
+	for i, v := range signature {
+		if currentSigLeft == -1 && isCompact(signature[i:]) {
+			currentSigLeft = crypto.CompactSignatureSize - 1
+			currentSig = []byte{v}
+		} else if currentSigLeft == -1 {
 			currentSigLeft = 0
 			currentSig = []byte{}
 			currentSig = append(currentSig, v)
@@ -181,6 +198,50 @@ func (e BEngine) Verify(hash []byte, sig *crypto.Signature) (bool, error) {
 	return crypto.Verify(e.name, hash, sig, verify, parseMulSig)
 }
 
+func signCompact(hash []byte, privKey interface{}) ([]byte, *ecdsa.PublicKey, error) {
+	pk, err := parsePriKey(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := btc.SignCompact(btc.S256(), pk, hash, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signature, &pk.PublicKey, nil
+}
+
+// SignCompact is used to create compact signature with recovery bit, which is
+// 65 bytes [V || R || S] and can be verified by Verify as well
+func (e BEngine) SignCompact(hash []byte, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	return crypto.Sign(e.name, hash, priKey, signCompact)
+}
+
+func recoverPubKey(hash []byte, signature []byte) (*ecdsa.PublicKey, error) {
+	if !isCompact(signature) {
+		return nil, crypto.ErrSigNotRecoverable
+	}
+	pk, _, err := btc.RecoverCompact(btc.S256(), signature, hash)
+	if err != nil {
+		return nil, err
+	}
+	return pk.ToECDSA(), nil
+}
+
+// RecoverPubKey is used to recover the public key from compact signature
+func (e BEngine) RecoverPubKey(hash []byte, sig *crypto.Signature) (*crypto.PublicKey, error) {
+	return crypto.RecoverPubKey(e.name, hash, sig, recoverPubKey)
+}
+
+// SignStream is used to create signature of content from r, the content is hashed incrementally
+func (e BEngine) SignStream(r io.Reader, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	return crypto.SignStream(e.name, r, priKey, sign)
+}
+
+// VerifyStream is used to verify the signature created by SignStream
+func (e BEngine) VerifyStream(r io.Reader, sig *crypto.Signature) (bool, error) {
+	return crypto.VerifyStream(e.name, r, sig, verify, parseMulSig)
+}
+
 // Unmarshal unmarshal private & public key
 func (e BEngine) Unmarshal(privKeyBytes, pubKeyBytes []byte) (privKey *crypto.PrivateKey, pubKey *crypto.PublicKey, err error) {
 	return crypto.Unmarshal(e.name, privKeyBytes, pubKeyBytes, parseKey, parsePubKey)
@@ -212,6 +273,24 @@ func privKeyToKeyBytes(priKey interface{}) ([]byte, []byte, error) {
 	return keyBytes, address[:], nil
 }
 
+func toECDSA(priKey interface{}) (*ecdsa.PrivateKey, error) {
+	pk, err := parsePriKey(priKey)
+	if err != nil {
+		return nil, err
+	}
+	return pk.ToECDSA(), nil
+}
+
+// ExportKey is used to marshal the S2PK private key to DER in format of PKCS8 or SEC1
+func (e BEngine) ExportKey(priKey *crypto.PrivateKey, format string) ([]byte, error) {
+	return crypto.ExportKey(e.name, priKey, format, crypto.OIDNamedCurveSecp256k1, toECDSA)
+}
+
+// ImportKey is used to parse the DER in format of PKCS8 or SEC1 to S2PK key pair
+func (e BEngine) ImportKey(der []byte) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	return crypto.ImportKey(e.name, der, crypto.OIDNamedCurveSecp256k1, parseKey)
+}
+
 // DecryptKey decrypt private key from file
 func (e BEngine) DecryptKey(keyJSON []byte, pass string) (*crypto.PrivateKey, *crypto.PublicKey, error) {
 	return crypto.DecryptKey(e.name, keyJSON, pass, parseKey)
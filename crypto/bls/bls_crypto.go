@@ -0,0 +1,531 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package bls
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+
+	eth "github.com/ethereum/go-ethereum/crypto"
+	bls12381 "github.com/kilic/bls12-381"
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+const (
+	// privKeyLength is the length of private key in bytes
+	privKeyLength = 32
+	// signatureLength is the length of signature (compressed G1 point) in bytes
+	signatureLength = 48
+)
+
+var (
+	// ErrInvalidSignature is returned if the signature is not a point of G1
+	ErrInvalidSignature = common.NewError(common.ErrCodeCrypto+14, "invalid signature")
+
+	// ErrHashPubKeyNotMatch is returned if the count of hash and public key not match
+	ErrHashPubKeyNotMatch = common.NewError(common.ErrCodeCrypto+15, "count of hash and public key not match")
+
+	// ErrNothingToAggregate is returned if no signature is given to aggregate
+	ErrNothingToAggregate = common.NewError(common.ErrCodeCrypto+16, "nothing to aggregate")
+
+	// order is the order r of G1 and G2
+	order = bls12381.NewG1().Q()
+	// sigDST is the domain separation tag used to hash content to G1
+	sigDST = []byte("BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_")
+	// coefDST is the domain separation tag used to hash public keys to coefficients
+	coefDST = []byte("PDU_BLS_AGG_COEF_")
+)
+
+// LEngine is the engine of BLS
+type LEngine struct {
+	name string
+}
+
+// New create new LEngine
+func New() *LEngine {
+	return &LEngine{name: crypto.BLS}
+}
+
+// Name return the name of this engine (BLS)
+func (e LEngine) Name() string {
+	return e.name
+}
+
+// GenKey generate the private and public key pair
+func (e LEngine) GenKey(params ...interface{}) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	return crypto.GenKey(e.name, genKey, params...)
+}
+
+func genKey() (interface{}, interface{}, error) {
+	k, err := rand.Int(rand.Reader, new(big.Int).Sub(order, big.NewInt(1)))
+	if err != nil {
+		return nil, nil, err
+	}
+	k.Add(k, big.NewInt(1))
+	return k, pubKeyOf(k), nil
+}
+
+// pubKeyOf return the public key k*g2 of private key k
+func pubKeyOf(k *big.Int) *bls12381.PointG2 {
+	g := bls12381.NewG2()
+	return g.MulScalarBig(g.New(), g.One(), k)
+}
+
+// parseKey parse the private key, return private key and public key
+func parseKey(privKey interface{}) (interface{}, interface{}, error) {
+	pk, err := parsePriKey(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pk, pubKeyOf(pk), nil
+}
+
+func parseKeyToString(privKey interface{}) (string, string, error) {
+	pk, err := parsePriKey(privKey)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(privKeyBytes(pk)), hex.EncodeToString(pubKeyBytes(pubKeyOf(pk))), nil
+}
+
+func parsePubKeyToString(pubKey interface{}) (string, error) {
+	pk, err := parsePubKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(pubKeyBytes(pk)), nil
+}
+
+// parsePriKey parse the private key
+func parsePriKey(priKey interface{}) (*big.Int, error) {
+	var k *big.Int
+	switch priKey.(type) {
+	case *big.Int:
+		k = priKey.(*big.Int)
+	case big.Int:
+		v := priKey.(big.Int)
+		k = &v
+	case []byte:
+		k = new(big.Int).SetBytes(priKey.([]byte))
+	default:
+		return nil, crypto.ErrKeyTypeNotSupport
+	}
+	if k.Sign() <= 0 || k.Cmp(order) >= 0 {
+		return nil, crypto.ErrKeyTypeNotSupport
+	}
+	return k, nil
+}
+
+// parsePubKey parse the public key, the point at infinity is not valid
+func parsePubKey(pubKey interface{}) (*bls12381.PointG2, error) {
+	g := bls12381.NewG2()
+	var pk *bls12381.PointG2
+	switch pubKey.(type) {
+	case *bls12381.PointG2:
+		pk = pubKey.(*bls12381.PointG2)
+	case bls12381.PointG2:
+		v := pubKey.(bls12381.PointG2)
+		pk = &v
+	case []byte:
+		var err error
+		if pk, err = g.FromCompressed(pubKey.([]byte)); err != nil {
+			return nil, crypto.ErrInvalidPubkey
+		}
+	default:
+		return nil, crypto.ErrKeyTypeNotSupport
+	}
+	if g.IsZero(pk) {
+		return nil, crypto.ErrInvalidPubkey
+	}
+	return pk, nil
+}
+
+// parseSignature parse the signature into point of G1
+func parseSignature(sig []byte) (*bls12381.PointG1, error) {
+	if len(sig) != signatureLength {
+		return nil, ErrInvalidSignature
+	}
+	g := bls12381.NewG1()
+	s, err := g.FromCompressed(sig)
+	if err != nil || g.IsZero(s) {
+		return nil, ErrInvalidSignature
+	}
+	return s, nil
+}
+
+func pubKeyBytes(pk *bls12381.PointG2) []byte {
+	return bls12381.NewG2().ToCompressed(pk)
+}
+
+func privKeyBytes(k *big.Int) []byte {
+	b := k.Bytes()
+	if len(b) >= privKeyLength {
+		return b
+	}
+	ret := make([]byte, privKeyLength)
+	copy(ret[privKeyLength-len(b):], b)
+	return ret
+}
+
+// hashToG1 map the hash to point of G1, see hash to curve suite
+// BLS12381G1_XMD:SHA-256_SSWU_RO_
+func hashToG1(hash []byte) (*bls12381.PointG1, error) {
+	return bls12381.NewG1().HashToCurve(hash, sigDST)
+}
+
+// coefficients return the hashed exponents of public keys, t_i = H(pk_i, pks).
+// Keys are multiplied by them before added into one, so a rogue key chosen
+// from the keys of others can not forge the signature of aggregated key.
+func coefficients(pks []*bls12381.PointG2) []*big.Int {
+	var all []byte
+	for _, pk := range pks {
+		all = append(all, pubKeyBytes(pk)...)
+	}
+	res := make([]*big.Int, len(pks))
+	for i, pk := range pks {
+		h := sha256.New()
+		h.Write(coefDST)
+		h.Write(pubKeyBytes(pk))
+		h.Write(all)
+		res[i] = new(big.Int).SetBytes(h.Sum(nil))
+		res[i].Mod(res[i], order)
+	}
+	return res
+}
+
+// parsePubKeys parse the list of public keys, each item may be a list of
+// MultipleSignatures keys, which is aggregated into one.
+func parsePubKeys(pubKey interface{}) ([]*bls12381.PointG2, error) {
+	pks, ok := pubKey.([]interface{})
+	if !ok || len(pks) == 0 {
+		return nil, crypto.ErrInvalidPubkey
+	}
+	res := make([]*bls12381.PointG2, len(pks))
+	for i, v := range pks {
+		pk, err := aggregatePubKey(v)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = pk
+	}
+	return res, nil
+}
+
+// aggregatePubKey add all public keys into one point of G2, each key is
+// weighted by its coefficient, see coefficients.
+func aggregatePubKey(pubKey interface{}) (*bls12381.PointG2, error) {
+	if _, ok := pubKey.([]interface{}); !ok {
+		return parsePubKey(pubKey)
+	}
+	pks, err := parsePubKeys(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	g := bls12381.NewG2()
+	agg := g.Zero()
+	for i, t := range coefficients(pks) {
+		g.Add(agg, agg, g.MulScalarBig(g.New(), pks[i], t))
+	}
+	if g.IsZero(agg) {
+		return nil, crypto.ErrInvalidPubkey
+	}
+	return agg, nil
+}
+
+// Sign is used to create signature of content by private key, the signature
+// of MultipleSignatures is aggregated, so the size is same as S2PK.
+func (e LEngine) Sign(hash []byte, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	if priKey.Source != e.name {
+		return nil, crypto.ErrSourceNotMatch
+	}
+	h, err := hashToG1(hash)
+	if err != nil {
+		return nil, err
+	}
+	g := bls12381.NewG1()
+
+	switch priKey.SigType {
+	case crypto.Signature2PublicKey:
+		k, pubKey, err := parseKey(priKey.PriKey)
+		if err != nil {
+			return nil, err
+		}
+		return &crypto.Signature{
+			PublicKey: crypto.PublicKey{Source: e.name, SigType: priKey.SigType, PubKey: pubKey},
+			Signature: g.ToCompressed(g.MulScalarBig(g.New(), h, k.(*big.Int))),
+		}, nil
+	case crypto.MultipleSignatures:
+		pks, ok := priKey.PriKey.([]interface{})
+		if !ok || len(pks) == 0 {
+			return nil, crypto.ErrKeyTypeNotSupport
+		}
+		var keys []*big.Int
+		var pubKeys []interface{}
+		var points []*bls12381.PointG2
+		for _, item := range pks {
+			k, pubKey, err := parseKey(item)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k.(*big.Int))
+			pubKeys = append(pubKeys, pubKey)
+			points = append(points, pubKey.(*bls12381.PointG2))
+		}
+		// the signature of aggregated key is sum(t_i * k_i) * H(m)
+		sum := new(big.Int)
+		for i, t := range coefficients(points) {
+			sum.Add(sum, new(big.Int).Mul(t, keys[i]))
+		}
+		sum.Mod(sum, order)
+		return &crypto.Signature{
+			PublicKey: crypto.PublicKey{Source: e.name, SigType: priKey.SigType, PubKey: pubKeys},
+			Signature: g.ToCompressed(g.MulScalarBig(g.New(), h, sum)),
+		}, nil
+	default:
+		return nil, crypto.ErrSigTypeNotSupport
+	}
+}
+
+// Verify is used to verify the signature, the signature of MultipleSignatures
+// is verified against the weighted sum of public keys by one pairing check.
+func (e LEngine) Verify(hash []byte, sig *crypto.Signature) (bool, error) {
+	if sig.Source != e.name {
+		return false, crypto.ErrSourceNotMatch
+	}
+	if sig.SigType != crypto.Signature2PublicKey && sig.SigType != crypto.MultipleSignatures {
+		return false, crypto.ErrSigTypeNotSupport
+	}
+	s, err := parseSignature(sig.Signature)
+	if err != nil {
+		return false, err
+	}
+	pk, err := aggregatePubKey(sig.PubKey)
+	if err != nil {
+		return false, err
+	}
+	h, err := hashToG1(hash)
+	if err != nil {
+		return false, err
+	}
+	// e(H(m), pk) * e(-sig, g2) == 1
+	engine := bls12381.NewEngine()
+	engine.AddPair(h, pk)
+	engine.AddPairInv(s, engine.G2.One())
+	return engine.Check(), nil
+}
+
+// SignStream is used to create signature of content from r, the content is hashed incrementally
+func (e LEngine) SignStream(r io.Reader, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	digest, err := crypto.DigestStream(r)
+	if err != nil {
+		return nil, err
+	}
+	return e.Sign(digest, priKey)
+}
+
+// VerifyStream is used to verify the signature created by SignStream
+func (e LEngine) VerifyStream(r io.Reader, sig *crypto.Signature) (bool, error) {
+	digest, err := crypto.DigestStream(r)
+	if err != nil {
+		return false, err
+	}
+	return e.Verify(digest, sig)
+}
+
+// Aggregate add the signatures into one signature, the public key of result
+// is the list of public key of each signature (MS keys are added into one).
+// The public key of each signature is required, because each signature is
+// weighted by the coefficient of its key, see coefficients. If all signatures
+// are of the same hash, the result can be verified by Verify, otherwise by
+// VerifyAggregate.
+func Aggregate(sigs ...*crypto.Signature) (*crypto.Signature, error) {
+	if len(sigs) == 0 {
+		return nil, ErrNothingToAggregate
+	}
+	var ss []*bls12381.PointG1
+	var pks []*bls12381.PointG2
+	for _, sig := range sigs {
+		if sig.Source != crypto.BLS {
+			return nil, crypto.ErrSourceNotMatch
+		}
+		s, err := parseSignature(sig.Signature)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := aggregatePubKey(sig.PubKey)
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+		pks = append(pks, pk)
+	}
+	g := bls12381.NewG1()
+	agg := g.Zero()
+	var pubKeys []interface{}
+	for i, t := range coefficients(pks) {
+		g.Add(agg, agg, g.MulScalarBig(g.New(), ss[i], t))
+		pubKeys = append(pubKeys, pks[i])
+	}
+	return &crypto.Signature{
+		PublicKey: crypto.PublicKey{Source: crypto.BLS, SigType: crypto.MultipleSignatures, PubKey: pubKeys},
+		Signature: g.ToCompressed(agg),
+	}, nil
+}
+
+// VerifyAggregate verify the signature aggregated from signatures of different
+// hash, hashes[i] is signed by the key sig.PubKey[i]. All pairs are verified by
+// one pairing check.
+func VerifyAggregate(hashes [][]byte, sig *crypto.Signature) (bool, error) {
+	if sig.Source != crypto.BLS {
+		return false, crypto.ErrSourceNotMatch
+	}
+	if _, ok := sig.PubKey.([]interface{}); !ok {
+		return false, crypto.ErrSigTypeNotSupport
+	}
+	pks, err := parsePubKeys(sig.PubKey)
+	if err != nil {
+		return false, err
+	}
+	if len(pks) != len(hashes) || len(hashes) == 0 {
+		return false, ErrHashPubKeyNotMatch
+	}
+	s, err := parseSignature(sig.Signature)
+	if err != nil {
+		return false, err
+	}
+	engine := bls12381.NewEngine()
+	for i, t := range coefficients(pks) {
+		h, err := hashToG1(hashes[i])
+		if err != nil {
+			return false, err
+		}
+		engine.AddPair(h, engine.G2.MulScalarBig(engine.G2.New(), pks[i], t))
+	}
+	engine.AddPairInv(s, engine.G2.One())
+	return engine.Check(), nil
+}
+
+// Unmarshal unmarshal private & public key
+func (e LEngine) Unmarshal(privKeyBytes, pubKeyBytes []byte) (privKey *crypto.PrivateKey, pubKey *crypto.PublicKey, err error) {
+	if len(pubKeyBytes) > 0 {
+		var m struct {
+			Source  string          `json:"source"`
+			SigType string          `json:"sigType"`
+			PubKey  json.RawMessage `json:"pubKey"`
+		}
+		if err = json.Unmarshal(pubKeyBytes, &m); err != nil {
+			return
+		}
+		if m.Source != e.name {
+			return nil, nil, crypto.ErrSourceNotMatch
+		}
+		var keys interface{}
+		if keys, err = unmarshalKeys(m.SigType, m.PubKey, func(b []byte) (interface{}, error) { return parsePubKey(b) }); err != nil {
+			return nil, nil, err
+		}
+		pubKey = &crypto.PublicKey{Source: m.Source, SigType: m.SigType, PubKey: keys}
+	}
+	if len(privKeyBytes) > 0 {
+		var m struct {
+			Source  string          `json:"source"`
+			SigType string          `json:"sigType"`
+			PrivKey json.RawMessage `json:"privKey"`
+		}
+		if err = json.Unmarshal(privKeyBytes, &m); err != nil {
+			return
+		}
+		if m.Source != e.name {
+			return nil, nil, crypto.ErrSourceNotMatch
+		}
+		var keys interface{}
+		if keys, err = unmarshalKeys(m.SigType, m.PrivKey, func(b []byte) (interface{}, error) { return parsePriKey(b) }); err != nil {
+			return nil, nil, err
+		}
+		privKey = &crypto.PrivateKey{Source: m.Source, SigType: m.SigType, PriKey: keys}
+	}
+	return
+}
+
+// unmarshalKeys decode the hex key (S2PK) or list of hex keys (MS)
+func unmarshalKeys(sigType string, input json.RawMessage, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	switch sigType {
+	case crypto.Signature2PublicKey:
+		var s string
+		if err := json.Unmarshal(input, &s); err != nil {
+			return nil, err
+		}
+		d, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return parse(d)
+	case crypto.MultipleSignatures:
+		var ss []string
+		if err := json.Unmarshal(input, &ss); err != nil {
+			return nil, err
+		}
+		var keys []interface{}
+		for _, s := range ss {
+			d, err := hex.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			key, err := parse(d)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	default:
+		return nil, crypto.ErrSigTypeNotSupport
+	}
+}
+
+// Marshal marshal private & public key
+func (e LEngine) Marshal(privKey *crypto.PrivateKey, pubKey *crypto.PublicKey) (privKeyBytes []byte, pubKeyBytes []byte, err error) {
+	return crypto.Marshal(e.name, privKey, pubKey, parseKeyToString, parsePubKeyToString)
+}
+
+// MappingKey build private & public key content into map for display or marshal
+func (e LEngine) MappingKey(privKey *crypto.PrivateKey, pubKey *crypto.PublicKey) (map[string]interface{}, map[string]interface{}, error) {
+	return crypto.MappingKey(e.name, privKey, pubKey, parseKeyToString, parsePubKeyToString)
+}
+
+// EncryptKey encryptKey into file
+func (e LEngine) EncryptKey(priKey *crypto.PrivateKey, pass string) ([]byte, error) {
+	return crypto.EncryptKey(e.name, priKey, pass, privKeyToKeyBytes)
+}
+
+func privKeyToKeyBytes(priKey interface{}) ([]byte, []byte, error) {
+	k, err := parsePriKey(priKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	address := eth.Keccak256(pubKeyBytes(pubKeyOf(k)))[12:]
+	return privKeyBytes(k), address, nil
+}
+
+// DecryptKey decrypt private key from file
+func (e LEngine) DecryptKey(keyJSON []byte, pass string) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	return crypto.DecryptKey(e.name, keyJSON, pass, parseKey)
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package bls
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	bls12381 "github.com/kilic/bls12-381"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+func TestS2PKVerify(t *testing.T) {
+	E := New()
+	priKey, pubKey, err := E.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+	content := []byte("hello world")
+	sig, err := E.Sign(content, priKey)
+	if err != nil {
+		t.Fatal("sign fail", err)
+	}
+	if len(sig.Signature) != signatureLength {
+		t.Errorf("length of signature should be %d, but get %d", signatureLength, len(sig.Signature))
+	}
+	if ok, err := E.Verify(content, sig); err != nil || !ok {
+		t.Error("verify fail", err)
+	}
+	sig.PubKey = pubKey.PubKey
+	if ok, _ := E.Verify([]byte("hello world!"), sig); ok {
+		t.Error("verify should fail if content changed")
+	}
+	_, pubKey2, _ := E.GenKey(crypto.Signature2PublicKey)
+	sig.PubKey = pubKey2.PubKey
+	if ok, _ := E.Verify(content, sig); ok {
+		t.Error("verify should fail if public key not match")
+	}
+}
+
+func TestMSVerify(t *testing.T) {
+	E := New()
+	priKey, pubKey, err := E.GenKey(crypto.MultipleSignatures, 5)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+	content := []byte("hello world")
+	sig, err := E.Sign(content, priKey)
+	if err != nil {
+		t.Fatal("sign fail", err)
+	}
+	if len(sig.Signature) != signatureLength {
+		t.Errorf("length of signature should be %d, but get %d", signatureLength, len(sig.Signature))
+	}
+	sig.PubKey = pubKey.PubKey
+	if ok, err := E.Verify(content, sig); err != nil || !ok {
+		t.Error("verify fail", err)
+	}
+	sig.PubKey = pubKey.PubKey.([]interface{})[1:]
+	if ok, _ := E.Verify(content, sig); ok {
+		t.Error("verify should fail if public key missing")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	E := New()
+	var sigs []*crypto.Signature
+	var hashes [][]byte
+	for i := 0; i < 4; i++ {
+		priKey, _, err := E.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal("generate key fail", err)
+		}
+		hash := []byte{byte(i)}
+		sig, err := E.Sign(hash, priKey)
+		if err != nil {
+			t.Fatal("sign fail", err)
+		}
+		sigs = append(sigs, sig)
+		hashes = append(hashes, hash)
+	}
+	sig, err := Aggregate(sigs...)
+	if err != nil {
+		t.Fatal("aggregate fail", err)
+	}
+	if ok, err := VerifyAggregate(hashes, sig); err != nil || !ok {
+		t.Error("verify aggregate fail", err)
+	}
+	hashes[0], hashes[1] = hashes[1], hashes[0]
+	if ok, _ := VerifyAggregate(hashes, sig); ok {
+		t.Error("verify aggregate should fail if hash not match")
+	}
+	if _, err := VerifyAggregate(hashes[1:], sig); err != ErrHashPubKeyNotMatch {
+		t.Errorf("err should be %s, but get %s", ErrHashPubKeyNotMatch, err)
+	}
+	if _, err := Aggregate(); err != ErrNothingToAggregate {
+		t.Errorf("err should be %s, but get %s", ErrNothingToAggregate, err)
+	}
+}
+
+func TestAggregateSameHash(t *testing.T) {
+	E := New()
+	content := []byte("hello world")
+	var sigs []*crypto.Signature
+	for _, sigType := range []string{crypto.Signature2PublicKey, crypto.MultipleSignatures} {
+		priKey, _, err := E.GenKey(sigType, 3)
+		if err != nil {
+			t.Fatal("generate key fail", err)
+		}
+		sig, err := E.Sign(content, priKey)
+		if err != nil {
+			t.Fatal("sign fail", err)
+		}
+		sigs = append(sigs, sig)
+	}
+	sig, err := Aggregate(sigs...)
+	if err != nil {
+		t.Fatal("aggregate fail", err)
+	}
+	if ok, err := E.Verify(content, sig); err != nil || !ok {
+		t.Error("verify fail", err)
+	}
+	sigs[0].PubKey = nil
+	if _, err := Aggregate(sigs...); err != crypto.ErrKeyTypeNotSupport {
+		t.Errorf("err should be %s, but get %s", crypto.ErrKeyTypeNotSupport, err)
+	}
+}
+
+func TestRogueKey(t *testing.T) {
+	E := New()
+	_, victim, err := E.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+	// rogue key is x*g2 - victim, so the sum of two keys is x*g2
+	priKey, _, err := E.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+	g := bls12381.NewG2()
+	rogue := g.Sub(g.New(), pubKeyOf(priKey.PriKey.(*big.Int)), victim.PubKey.(*bls12381.PointG2))
+	content := []byte("hello world")
+	sig, err := E.Sign(content, priKey)
+	if err != nil {
+		t.Fatal("sign fail", err)
+	}
+	sig.SigType = crypto.MultipleSignatures
+	sig.PubKey = []interface{}{victim.PubKey, rogue}
+	if ok, _ := E.Verify(content, sig); ok {
+		t.Error("verify should fail if rogue key is aggregated")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	E := New()
+	for _, sigType := range []string{crypto.Signature2PublicKey, crypto.MultipleSignatures} {
+		priKey, pubKey, err := E.GenKey(sigType, 3)
+		if err != nil {
+			t.Fatal("generate key fail", err)
+		}
+		privKeyBytes, pubKeyBytes, err := E.Marshal(priKey, pubKey)
+		if err != nil {
+			t.Fatal("marshal fail", err)
+		}
+		priKey2, pubKey2, err := E.Unmarshal(privKeyBytes, pubKeyBytes)
+		if err != nil {
+			t.Fatal("unmarshal fail", err)
+		}
+		privKeyBytes2, pubKeyBytes2, err := E.Marshal(priKey2, pubKey2)
+		if err != nil {
+			t.Fatal("marshal fail", err)
+		}
+		if !bytes.Equal(privKeyBytes, privKeyBytes2) || !bytes.Equal(pubKeyBytes, pubKeyBytes2) {
+			t.Error("key not match after unmarshal")
+		}
+	}
+}
+
+func TestEncryptKey(t *testing.T) {
+	E := New()
+	priKey, pubKey, err := E.GenKey(crypto.MultipleSignatures, 2)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+	keyJSON, err := E.EncryptKey(priKey, "123")
+	if err != nil {
+		t.Fatal("encrypt key fail", err)
+	}
+	priKey2, pubKey2, err := E.DecryptKey(keyJSON, "123")
+	if err != nil {
+		t.Fatal("decrypt key fail", err)
+	}
+	_, pubKeyBytes, _ := E.Marshal(nil, pubKey)
+	privKeyBytes, pubKeyBytes2, _ := E.Marshal(priKey2, pubKey2)
+	if !bytes.Equal(pubKeyBytes, pubKeyBytes2) {
+		t.Error("public key not match after decrypt")
+	}
+	privKeyBytes2, _, _ := E.Marshal(priKey, nil)
+	if !bytes.Equal(privKeyBytes, privKeyBytes2) {
+		t.Error("private key not match after decrypt")
+	}
+}
+
+func TestSignStream(t *testing.T) {
+	E := New()
+	for _, sigType := range []string{crypto.Signature2PublicKey, crypto.MultipleSignatures} {
+		priKey, pubKey, err := E.GenKey(sigType, 3)
+		if err != nil {
+			t.Fatal("generate key fail", err)
+		}
+		content := bytes.Repeat([]byte("large content "), 100000)
+		sig, err := E.SignStream(bytes.NewReader(content), priKey)
+		if err != nil {
+			t.Fatal("sign stream fail", err)
+		}
+		sig.PubKey = pubKey.PubKey
+		if ok, err := E.VerifyStream(bytes.NewReader(content), sig); err != nil || !ok {
+			t.Error("verify stream fail", err)
+		}
+		if ok, _ := E.VerifyStream(bytes.NewReader(append(content, 'x')), sig); ok {
+			t.Error("verify stream should fail if content changed")
+		}
+		if sig.Source != crypto.BLS {
+			t.Errorf("signature source should be %s", crypto.BLS)
+		}
+	}
+}
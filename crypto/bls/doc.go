@@ -0,0 +1,23 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bls implements the BLS signature on the BLS12-381 curve. Signatures
+// are points of G1 and public keys are points of G2, so signatures can be
+// aggregated into one point: the MultipleSignatures identity signs with constant
+// size, and signatures of many users can be verified by one pairing check.
+// Public keys are weighted by hashed exponents before added into one, so the
+// aggregated key can not be forged by a rogue key.
+package bls
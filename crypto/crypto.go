@@ -18,35 +18,39 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
+	"io"
 
-	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/google/uuid"
+	"github.com/pdupub/go-pdu/common"
 )
 
 var (
 	// ErrParamsMissing is returned when params is not enough
-	ErrParamsMissing = errors.New("params missing")
+	ErrParamsMissing = common.NewError(common.ErrCodeCrypto+1, "params missing")
 
 	// ErrSourceNotMatch is returned if the source name of signature and key not match
-	ErrSourceNotMatch = errors.New("signature source not match")
+	ErrSourceNotMatch = common.NewError(common.ErrCodeCrypto+2, "signature source not match")
 
 	// ErrSigTypeNotSupport is returned if the signature is not MS or S2PK
-	ErrSigTypeNotSupport = errors.New("signature type not support")
+	ErrSigTypeNotSupport = common.NewError(common.ErrCodeCrypto+3, "signature type not support")
 
 	// ErrGenerateKeyFail is returned when generate key fail
-	ErrGenerateKeyFail = errors.New("generate key fail")
+	ErrGenerateKeyFail = common.NewError(common.ErrCodeCrypto+4, "generate key fail")
 
 	// ErrKeyTypeNotSupport is returned if key type not support
-	ErrKeyTypeNotSupport = errors.New("key type not support")
+	ErrKeyTypeNotSupport = common.NewError(common.ErrCodeCrypto+5, "key type not support")
 
 	// ErrSigPubKeyNotMatch is returned if the signature and key not match for MS
-	ErrSigPubKeyNotMatch = errors.New("count of signature and public key not match")
+	ErrSigPubKeyNotMatch = common.NewError(common.ErrCodeCrypto+6, "count of signature and public key not match")
 
 	// ErrInvalidPubkey is returned if the public key is invalid
-	ErrInvalidPubkey = errors.New("invalid public key")
+	ErrInvalidPubkey = common.NewError(common.ErrCodeCrypto+7, "invalid public key")
+
+	// ErrSigNotRecoverable is returned if the public key can not be recovered from signature
+	ErrSigNotRecoverable = common.NewError(common.ErrCodeCrypto+8, "signature not recoverable")
 )
 
 const (
@@ -61,6 +65,8 @@ const (
 	ETH = "ETH"
 	// PDU is symbol of PDU
 	PDU = "PDU"
+	// BLS is symbol of BLS aggregate signature
+	BLS = "BLS"
 )
 
 // PublicKey contains the source name, type and public key content
@@ -89,6 +95,8 @@ type Engine interface {
 	GenKey(params ...interface{}) (*PrivateKey, *PublicKey, error)
 	Sign([]byte, *PrivateKey) (*Signature, error)
 	Verify([]byte, *Signature) (bool, error)
+	SignStream(io.Reader, *PrivateKey) (*Signature, error)
+	VerifyStream(io.Reader, *Signature) (bool, error)
 	Unmarshal([]byte, []byte) (*PrivateKey, *PublicKey, error)
 	Marshal(*PrivateKey, *PublicKey) ([]byte, []byte, error)
 	EncryptKey(*PrivateKey, string) ([]byte, error)
@@ -96,6 +104,18 @@ type Engine interface {
 	MappingKey(*PrivateKey, *PublicKey) (map[string]interface{}, map[string]interface{}, error)
 }
 
+// CompactSignatureSize is the size of compact signature with recovery bit,
+// MS signature is the compact signatures of each key joined by order
+const CompactSignatureSize = 65
+
+// Recoverer is implemented by the engines of secp256k1, which can create
+// compact signature with recovery bit. The public key is recovered from the
+// signature, so it no need to be sent along with every signature.
+type Recoverer interface {
+	SignCompact([]byte, *PrivateKey) (*Signature, error)
+	RecoverPubKey([]byte, *Signature) (*PublicKey, error)
+}
+
 // EncryptedPrivateKey is encrypted private key in json
 type EncryptedPrivateKey struct {
 	Source  string              `json:"source"`
@@ -105,10 +125,10 @@ type EncryptedPrivateKey struct {
 
 // EncryptedKeyJSONV3 is from geth
 type EncryptedKeyJSONV3 struct {
-	Address string              `json:"address"`
-	Crypto  keystore.CryptoJSON `json:"crypto"`
-	ID      string              `json:"id"`
-	Version int                 `json:"version"`
+	Address string     `json:"address"`
+	Crypto  CryptoJSON `json:"crypto"`
+	ID      string     `json:"id"`
+	Version int        `json:"version"`
 }
 
 // EncryptedKeyJListV3 is for ms
@@ -126,6 +146,7 @@ type funcParsePubKey func(interface{}) (*ecdsa.PublicKey, error)
 type funcParseKeyToString func(interface{}) (string, string, error)
 type funcParsePubKeyToString func(interface{}) (string, error)
 type funcParseMulSig func(signature []byte) [][]byte
+type funcRecover func(hash []byte, signature []byte) (*ecdsa.PublicKey, error)
 
 // GenKey generate the private and public key pair
 func GenKey(source string, genKey funcGenKey, params ...interface{}) (*PrivateKey, *PublicKey, error) {
@@ -197,6 +218,69 @@ func Sign(source string, hash []byte, priKey *PrivateKey, sign funcSign) (*Signa
 	}
 }
 
+// RecoverPubKey recover the public key from compact signature, the type of
+// public key is same as signature, and the public key in sig is ignored.
+func RecoverPubKey(source string, hash []byte, sig *Signature, recover funcRecover) (*PublicKey, error) {
+	if sig.Source != source {
+		return nil, ErrSourceNotMatch
+	}
+	if len(sig.Signature) == 0 || len(sig.Signature)%CompactSignatureSize != 0 {
+		return nil, ErrSigNotRecoverable
+	}
+	switch sig.SigType {
+	case Signature2PublicKey:
+		if len(sig.Signature) != CompactSignatureSize {
+			return nil, ErrSigNotRecoverable
+		}
+		pubKey, err := recover(hash, sig.Signature)
+		if err != nil {
+			return nil, err
+		}
+		return &PublicKey{Source: source, SigType: Signature2PublicKey, PubKey: pubKey}, nil
+	case MultipleSignatures:
+		var pubKeys []interface{}
+		for i := 0; i < len(sig.Signature); i += CompactSignatureSize {
+			pubKey, err := recover(hash, sig.Signature[i:i+CompactSignatureSize])
+			if err != nil {
+				return nil, err
+			}
+			pubKeys = append(pubKeys, pubKey)
+		}
+		return &PublicKey{Source: source, SigType: MultipleSignatures, PubKey: pubKeys}, nil
+	default:
+		return nil, ErrSigTypeNotSupport
+	}
+}
+
+// DigestStream hash the content from r incrementally by sha256, so the
+// content no need to be loaded into memory at once.
+func DigestStream(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SignStream is used to create signature of the digest of content from r,
+// which is not same as the signature created by Sign with whole content.
+func SignStream(source string, r io.Reader, priKey *PrivateKey, sign funcSign) (*Signature, error) {
+	digest, err := DigestStream(r)
+	if err != nil {
+		return nil, err
+	}
+	return Sign(source, digest, priKey, sign)
+}
+
+// VerifyStream is used to verify the signature created by SignStream
+func VerifyStream(source string, r io.Reader, sig *Signature, verify funcVerify, parseMulSig funcParseMulSig) (bool, error) {
+	digest, err := DigestStream(r)
+	if err != nil {
+		return false, err
+	}
+	return Verify(source, digest, sig, verify, parseMulSig)
+}
+
 // Verify is used to verify the signature
 func Verify(source string, hash []byte, sig *Signature, verify funcVerify, parseMulSig funcParseMulSig) (bool, error) {
 	if sig.Source != source {
@@ -233,7 +317,7 @@ func DecryptKey(source string, keyJSON []byte, pass string, parseKey funcParseKe
 	}
 	var priKeys, pubKeys []interface{}
 	for _, v := range k.EPK {
-		keyBytes, err := keystore.DecryptDataV3(v.Crypto, pass)
+		keyBytes, err := DecryptDataV3(v.Crypto, pass)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -252,7 +336,7 @@ func DecryptKey(source string, keyJSON []byte, pass string, parseKey funcParseKe
 
 // EncryptSignleKey encrypt single private key
 func EncryptSignleKey(keyBytes, address []byte, pass string) (*EncryptedKeyJSONV3, error) {
-	cryptoStruct, err := keystore.EncryptDataV3(keyBytes, []byte(pass), keystore.StandardScryptN, keystore.StandardScryptP)
+	cryptoStruct, err := EncryptDataV3(keyBytes, []byte(pass), StandardScryptN, StandardScryptP)
 	if err != nil {
 		return nil, err
 	}
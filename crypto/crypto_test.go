@@ -15,3 +15,45 @@
 // along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
 
 package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+)
+
+func TestEncryptDataV3(t *testing.T) {
+	data := []byte("private key bytes")
+	pass := "123456"
+	cj, err := EncryptDataV3(data, []byte(pass), keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatal("encrypt data fail", err)
+	}
+	if res, err := DecryptDataV3(cj, pass); err != nil || !bytes.Equal(res, data) {
+		t.Error("decrypt data fail", err)
+	}
+	if _, err := DecryptDataV3(cj, "654321"); err != ErrDecrypt {
+		t.Errorf("err should be %s, but get %s", ErrDecrypt, err)
+	}
+
+	// keystore should be compatible with geth
+	cjBytes, _ := json.Marshal(cj)
+	var gethCJ keystore.CryptoJSON
+	if err := json.Unmarshal(cjBytes, &gethCJ); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := keystore.DecryptDataV3(gethCJ, pass); err != nil || !bytes.Equal(res, data) {
+		t.Error("decrypt data by geth fail", err)
+	}
+	gethCJ, _ = keystore.EncryptDataV3(data, []byte(pass), keystore.LightScryptN, keystore.LightScryptP)
+	cjBytes, _ = json.Marshal(gethCJ)
+	cj = CryptoJSON{}
+	if err := json.Unmarshal(cjBytes, &cj); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := DecryptDataV3(cj, pass); err != nil || !bytes.Equal(res, data) {
+		t.Error("decrypt data from geth fail", err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/hex"
 	"fmt"
+	"io"
 
 	eth "github.com/ethereum/go-ethereum/crypto"
 
@@ -73,12 +74,13 @@ func parsePubKeyToString(pubKey interface{}) (string, error) {
 	return hex.EncodeToString(eth.FromECDSAPub(pk)), nil
 }
 
-// parsePriKey parse the private key
+// parsePriKey parse the private key, the key is copied before the public key
+// is filled, so the key shared by goroutines is not changed
 func parsePriKey(priKey interface{}) (*ecdsa.PrivateKey, error) {
 	pk := new(ecdsa.PrivateKey)
 	switch priKey.(type) {
 	case *ecdsa.PrivateKey:
-		pk = priKey.(*ecdsa.PrivateKey)
+		*pk = *priKey.(*ecdsa.PrivateKey)
 	case ecdsa.PrivateKey:
 		*pk = priKey.(ecdsa.PrivateKey)
 	case []byte:
@@ -159,6 +161,31 @@ func (e EEngine) Verify(hash []byte, sig *crypto.Signature) (bool, error) {
 	return crypto.Verify(e.name, hash, sig, verify, parseMulSig)
 }
 
+// SignCompact is used to create compact signature with recovery bit, the signature
+// of ETH is always recoverable, so it is same as Sign
+func (e EEngine) SignCompact(hash []byte, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	return crypto.Sign(e.name, hash, priKey, sign)
+}
+
+func recoverPubKey(hash []byte, signature []byte) (*ecdsa.PublicKey, error) {
+	return eth.SigToPub(signHash(hash), signature)
+}
+
+// RecoverPubKey is used to recover the public key from compact signature
+func (e EEngine) RecoverPubKey(hash []byte, sig *crypto.Signature) (*crypto.PublicKey, error) {
+	return crypto.RecoverPubKey(e.name, hash, sig, recoverPubKey)
+}
+
+// SignStream is used to create signature of content from r, the content is hashed incrementally
+func (e EEngine) SignStream(r io.Reader, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	return crypto.SignStream(e.name, r, priKey, sign)
+}
+
+// VerifyStream is used to verify the signature created by SignStream
+func (e EEngine) VerifyStream(r io.Reader, sig *crypto.Signature) (bool, error) {
+	return crypto.VerifyStream(e.name, r, sig, verify, parseMulSig)
+}
+
 // Unmarshal unmarshal private & public key from json
 func (e EEngine) Unmarshal(privKeyBytes, pubKeyBytes []byte) (privKey *crypto.PrivateKey, pubKey *crypto.PublicKey, err error) {
 	return crypto.Unmarshal(e.name, privKeyBytes, pubKeyBytes, parseKey, parsePubKey)
@@ -189,6 +216,16 @@ func privKeyToKeyBytes(priKey interface{}) ([]byte, []byte, error) {
 	return keyBytes, address[:], nil
 }
 
+// ExportKey is used to marshal the S2PK private key to DER in format of PKCS8 or SEC1
+func (e EEngine) ExportKey(priKey *crypto.PrivateKey, format string) ([]byte, error) {
+	return crypto.ExportKey(e.name, priKey, format, crypto.OIDNamedCurveSecp256k1, parsePriKey)
+}
+
+// ImportKey is used to parse the DER in format of PKCS8 or SEC1 to S2PK key pair
+func (e EEngine) ImportKey(der []byte) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	return crypto.ImportKey(e.name, der, crypto.OIDNamedCurveSecp256k1, parseKey)
+}
+
 // DecryptKey decrypt private key from file
 func (e EEngine) DecryptKey(keyJSON []byte, pass string) (*crypto.PrivateKey, *crypto.PublicKey, error) {
 	return crypto.DecryptKey(e.name, keyJSON, pass, parseKey)
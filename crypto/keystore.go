@@ -0,0 +1,190 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// The keystore format is same as geth (web3 secret storage v3), it is
+// implemented here so the crypto package not depend on geth accounts,
+// which can not be built for js/wasm.
+
+// ErrDecrypt is returned if the key could not be decrypted with the pass
+var ErrDecrypt = common.NewError(common.ErrCodeCrypto+12, "could not decrypt key with given passphrase")
+
+const (
+	// StandardScryptN is the N parameter of Scrypt encryption algorithm, using 256MB
+	// memory and taking approximately 1s CPU time on a modern processor.
+	StandardScryptN = 1 << 18
+
+	// StandardScryptP is the P parameter of Scrypt encryption algorithm, using 256MB
+	// memory and taking approximately 1s CPU time on a modern processor.
+	StandardScryptP = 1
+
+	keyHeaderKDF = "scrypt"
+	scryptR      = 8
+	scryptDKLen  = 32
+)
+
+// CryptoJSON is the encrypted data in keystore
+type CryptoJSON struct {
+	Cipher       string                 `json:"cipher"`
+	CipherText   string                 `json:"ciphertext"`
+	CipherParams CipherParamsJSON       `json:"cipherparams"`
+	KDF          string                 `json:"kdf"`
+	KDFParams    map[string]interface{} `json:"kdfparams"`
+	MAC          string                 `json:"mac"`
+}
+
+// CipherParamsJSON is the params of cipher in keystore
+type CipherParamsJSON struct {
+	IV string `json:"iv"`
+}
+
+// EncryptDataV3 encrypts the data given as 'data' with the password 'auth'.
+func EncryptDataV3(data, auth []byte, scryptN, scryptP int) (CryptoJSON, error) {
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return CryptoJSON{}, err
+	}
+	derivedKey, err := scrypt.Key(auth, salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return CryptoJSON{}, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return CryptoJSON{}, err
+	}
+	cipherText, err := aesCTRXOR(derivedKey[:16], data, iv)
+	if err != nil {
+		return CryptoJSON{}, err
+	}
+	mac := keccak256(derivedKey[16:32], cipherText)
+
+	return CryptoJSON{
+		Cipher:       "aes-128-ctr",
+		CipherText:   hex.EncodeToString(cipherText),
+		CipherParams: CipherParamsJSON{IV: hex.EncodeToString(iv)},
+		KDF:          keyHeaderKDF,
+		KDFParams: map[string]interface{}{
+			"n":     scryptN,
+			"r":     scryptR,
+			"p":     scryptP,
+			"dklen": scryptDKLen,
+			"salt":  hex.EncodeToString(salt),
+		},
+		MAC: hex.EncodeToString(mac),
+	}, nil
+}
+
+// DecryptDataV3 decrypts the data encrypted by EncryptDataV3
+func DecryptDataV3(cj CryptoJSON, auth string) ([]byte, error) {
+	if cj.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("cipher not supported: %v", cj.Cipher)
+	}
+	mac, err := hex.DecodeString(cj.MAC)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(cj.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(cj.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	derivedKey, err := getKDFKey(cj, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(keccak256(derivedKey[16:32], cipherText), mac) {
+		return nil, ErrDecrypt
+	}
+	return aesCTRXOR(derivedKey[:16], cipherText, iv)
+}
+
+func getKDFKey(cj CryptoJSON, auth string) ([]byte, error) {
+	saltStr, ok := cj.KDFParams["salt"].(string)
+	if !ok {
+		return nil, ErrParamsMissing
+	}
+	salt, err := hex.DecodeString(saltStr)
+	if err != nil {
+		return nil, err
+	}
+	dkLen := ensureInt(cj.KDFParams["dklen"])
+	switch cj.KDF {
+	case keyHeaderKDF:
+		n := ensureInt(cj.KDFParams["n"])
+		r := ensureInt(cj.KDFParams["r"])
+		p := ensureInt(cj.KDFParams["p"])
+		return scrypt.Key([]byte(auth), salt, n, r, p, dkLen)
+	case "pbkdf2":
+		c := ensureInt(cj.KDFParams["c"])
+		if prf, _ := cj.KDFParams["prf"].(string); prf != "hmac-sha256" {
+			return nil, fmt.Errorf("unsupported PBKDF2 PRF: %s", prf)
+		}
+		return pbkdf2.Key([]byte(auth), salt, c, dkLen, sha256.New), nil
+	}
+	return nil, fmt.Errorf("unsupported KDF: %s", cj.KDF)
+}
+
+// ensureInt convert the number in params, which is float64 after json
+// unmarshal, into int
+func ensureInt(x interface{}) int {
+	switch v := x.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+func aesCTRXOR(key, inText, iv []byte) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(aesBlock, iv)
+	outText := make([]byte, len(inText))
+	stream.XORKeyStream(outText, inText)
+	return outText, nil
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, b := range data {
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
@@ -21,6 +21,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/hex"
+	"io"
 	"math/big"
 
 	eth "github.com/ethereum/go-ethereum/crypto"
@@ -173,6 +174,16 @@ func (e PEngine) Verify(hash []byte, sig *crypto.Signature) (bool, error) {
 	return crypto.Verify(e.name, hash, sig, verify, parseMulSig)
 }
 
+// SignStream is used to create signature of content from r, the content is hashed incrementally
+func (e PEngine) SignStream(r io.Reader, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	return crypto.SignStream(e.name, r, priKey, sign)
+}
+
+// VerifyStream is used to verify the signature created by SignStream
+func (e PEngine) VerifyStream(r io.Reader, sig *crypto.Signature) (bool, error) {
+	return crypto.VerifyStream(e.name, r, sig, verify, parseMulSig)
+}
+
 // Unmarshal unmarshal private & public key from json
 func (e PEngine) Unmarshal(privKeyBytes, pubKeyBytes []byte) (privKey *crypto.PrivateKey, pubKey *crypto.PublicKey, err error) {
 	return crypto.Unmarshal(e.name, privKeyBytes, pubKeyBytes, parseKey, parsePubKey)
@@ -203,6 +214,16 @@ func privKeyToKeyBytes(priKey interface{}) ([]byte, []byte, error) {
 	return keyBytes, address[:], nil
 }
 
+// ExportKey is used to marshal the S2PK private key to DER in format of PKCS8 or SEC1
+func (e PEngine) ExportKey(priKey *crypto.PrivateKey, format string) ([]byte, error) {
+	return crypto.ExportKey(e.name, priKey, format, crypto.OIDNamedCurveP256, parsePriKey)
+}
+
+// ImportKey is used to parse the DER in format of PKCS8 or SEC1 to S2PK key pair
+func (e PEngine) ImportKey(der []byte) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	return crypto.ImportKey(e.name, der, crypto.OIDNamedCurveP256, parseKey)
+}
+
 // DecryptKey decrypt private key from file
 func (e PEngine) DecryptKey(keyJSON []byte, pass string) (*crypto.PrivateKey, *crypto.PublicKey, error) {
 	return crypto.DecryptKey(e.name, keyJSON, pass, parseKey)
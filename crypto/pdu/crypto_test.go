@@ -17,7 +17,9 @@
 package pdu
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/x509"
 	"math/big"
 	"testing"
 
@@ -445,3 +447,77 @@ func TestMarshal(t *testing.T) {
 	}
 
 }
+
+func TestSignStream(t *testing.T) {
+	E := New()
+	for _, sigType := range []string{crypto.Signature2PublicKey, crypto.MultipleSignatures} {
+		priKey, pubKey, err := E.GenKey(sigType, 3)
+		if err != nil {
+			t.Fatal("generate key fail", err)
+		}
+		content := bytes.Repeat([]byte("large content "), 100000)
+		sig, err := E.SignStream(bytes.NewReader(content), priKey)
+		if err != nil {
+			t.Fatal("sign stream fail", err)
+		}
+		sig.PubKey = pubKey.PubKey
+		if ok, err := E.VerifyStream(bytes.NewReader(content), sig); err != nil || !ok {
+			t.Error("verify stream fail", err)
+		}
+		if ok, _ := E.VerifyStream(bytes.NewReader(append(content, 'x')), sig); ok {
+			t.Error("verify stream should fail if content changed")
+		}
+		if sig.Source != crypto.PDU {
+			t.Errorf("signature source should be %s", crypto.PDU)
+		}
+	}
+}
+
+func TestExportKey(t *testing.T) {
+	E := New()
+	pk, _, _ := tGenKey()
+	priKey := &crypto.PrivateKey{Source: crypto.PDU, SigType: crypto.Signature2PublicKey, PriKey: pk}
+
+	der, err := E.ExportKey(priKey, crypto.KeyFormatPKCS8)
+	if err != nil {
+		t.Fatal("export key fail", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		t.Fatal("parse PKCS8 by x509 fail", err)
+	}
+	if key.(*ecdsa.PrivateKey).D.Cmp(pk.D) != 0 {
+		t.Error("private key of PKCS8 not match")
+	}
+
+	der, err = E.ExportKey(priKey, crypto.KeyFormatSEC1)
+	if err != nil {
+		t.Fatal("export key fail", err)
+	}
+	ecKey, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		t.Fatal("parse SEC1 by x509 fail", err)
+	}
+	if ecKey.D.Cmp(pk.D) != 0 {
+		t.Error("private key of SEC1 not match")
+	}
+}
+
+func TestImportKey(t *testing.T) {
+	E := New()
+	pk, _, _ := tGenKey()
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(pk)
+	sec1, _ := x509.MarshalECPrivateKey(pk)
+	for _, der := range [][]byte{pkcs8, sec1} {
+		priKey, pubKey, err := E.ImportKey(der)
+		if err != nil {
+			t.Fatal("import key fail", err)
+		}
+		if priKey.PriKey.(*ecdsa.PrivateKey).D.Cmp(pk.D) != 0 {
+			t.Error("private key not match")
+		}
+		if pubKey.PubKey.(*ecdsa.PublicKey).X.Cmp(pk.X) != 0 {
+			t.Error("public key not match")
+		}
+	}
+}
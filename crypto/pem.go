@@ -0,0 +1,216 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// The single ECDSA key can be imported and exported as DER or PEM, the format
+// is same as openssl, so the key generated by other tooling can be used as
+// identity of PDU. Only the named curve is supported, and the private key
+// in PEM should not be encrypted.
+
+var (
+	// ErrKeyFormatNotSupport is returned if the format of key is not PKCS8 or SEC1
+	ErrKeyFormatNotSupport = common.NewError(common.ErrCodeCrypto+9, "key format not support")
+
+	// ErrCurveNotMatch is returned if the curve of key not match the engine
+	ErrCurveNotMatch = common.NewError(common.ErrCodeCrypto+10, "curve of key not match")
+
+	// ErrInvalidKeyDER is returned if the key can not be parsed as PKCS8 or SEC1
+	ErrInvalidKeyDER = common.NewError(common.ErrCodeCrypto+11, "invalid key der")
+
+	// OIDNamedCurveP256 is the oid of curve P-256 used by PDU
+	OIDNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+	// OIDNamedCurveSecp256k1 is the oid of curve secp256k1 used by ETH and BTC
+	OIDNamedCurveSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+)
+
+const (
+	// KeyFormatPKCS8 is the format of private key in PKCS#8
+	KeyFormatPKCS8 = "PKCS8"
+	// KeyFormatSEC1 is the format of private key in SEC 1 (EC PRIVATE KEY)
+	KeyFormatSEC1 = "SEC1"
+
+	pemTypePKCS8 = "PRIVATE KEY"
+	pemTypeSEC1  = "EC PRIVATE KEY"
+
+	ecPrivKeyVersion = 1
+)
+
+// KeyExporter is implemented by the engines of ECDSA, which can import and
+// export the S2PK private key as DER in PKCS8 or SEC1.
+type KeyExporter interface {
+	ExportKey(*PrivateKey, string) ([]byte, error)
+	ImportKey([]byte) (*PrivateKey, *PublicKey, error)
+}
+
+type funcToECDSA func(interface{}) (*ecdsa.PrivateKey, error)
+
+// ecPrivateKey is the ASN.1 structure of SEC 1 private key
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// pkcs8 is the ASN.1 structure of PKCS#8 private key
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// ExportKey marshal the S2PK private key to DER in format of PKCS8 or SEC1
+func ExportKey(source string, priKey *PrivateKey, format string, curveOID asn1.ObjectIdentifier, toECDSA funcToECDSA) ([]byte, error) {
+	if priKey.Source != source {
+		return nil, ErrSourceNotMatch
+	}
+	if priKey.SigType != Signature2PublicKey {
+		return nil, ErrSigTypeNotSupport
+	}
+	pk, err := toECDSA(priKey.PriKey)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case KeyFormatSEC1:
+		return marshalECPrivateKey(pk, curveOID)
+	case KeyFormatPKCS8:
+		oidBytes, err := asn1.Marshal(curveOID)
+		if err != nil {
+			return nil, err
+		}
+		// the curve is in algorithm identifier, so not repeated in the SEC1 key
+		keyBytes, err := marshalECPrivateKey(pk, nil)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8{
+			Algo: pkix.AlgorithmIdentifier{
+				Algorithm:  oidPublicKeyECDSA,
+				Parameters: asn1.RawValue{FullBytes: oidBytes},
+			},
+			PrivateKey: keyBytes,
+		})
+	default:
+		return nil, ErrKeyFormatNotSupport
+	}
+}
+
+func marshalECPrivateKey(pk *ecdsa.PrivateKey, curveOID asn1.ObjectIdentifier) ([]byte, error) {
+	size := (pk.Curve.Params().BitSize + 7) / 8
+	d := pk.D.Bytes()
+	if len(d) > size {
+		return nil, ErrKeyTypeNotSupport
+	}
+	privKey := make([]byte, size)
+	copy(privKey[size-len(d):], d)
+	return asn1.Marshal(ecPrivateKey{
+		Version:       ecPrivKeyVersion,
+		PrivateKey:    privKey,
+		NamedCurveOID: curveOID,
+		PublicKey:     asn1.BitString{Bytes: elliptic.Marshal(pk.Curve, pk.X, pk.Y)},
+	})
+}
+
+// ImportKey parse the DER in format of PKCS8 or SEC1 to S2PK key pair, the
+// curve of key must be same as curveOID.
+func ImportKey(source string, der []byte, curveOID asn1.ObjectIdentifier, parseKey funcParseKey) (*PrivateKey, *PublicKey, error) {
+	d, err := parseECPrivateKey(der, curveOID)
+	if err != nil {
+		return nil, nil, err
+	}
+	privKey, pubKey, err := parseKey(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &PrivateKey{Source: source, SigType: Signature2PublicKey, PriKey: privKey}, &PublicKey{Source: source, SigType: Signature2PublicKey, PubKey: pubKey}, nil
+}
+
+func parseECPrivateKey(der []byte, curveOID asn1.ObjectIdentifier) (*big.Int, error) {
+	var p8 pkcs8
+	if rest, err := asn1.Unmarshal(der, &p8); err == nil && len(rest) == 0 {
+		if !p8.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+			return nil, ErrKeyTypeNotSupport
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(p8.Algo.Parameters.FullBytes, &oid); err != nil {
+			return nil, ErrInvalidKeyDER
+		}
+		if !oid.Equal(curveOID) {
+			return nil, ErrCurveNotMatch
+		}
+		der = p8.PrivateKey
+	}
+
+	var key ecPrivateKey
+	if rest, err := asn1.Unmarshal(der, &key); err != nil || len(rest) != 0 {
+		return nil, ErrInvalidKeyDER
+	}
+	if key.Version != ecPrivKeyVersion {
+		return nil, ErrInvalidKeyDER
+	}
+	if len(key.NamedCurveOID) != 0 && !key.NamedCurveOID.Equal(curveOID) {
+		return nil, ErrCurveNotMatch
+	}
+	d := new(big.Int).SetBytes(key.PrivateKey)
+	if d.Sign() == 0 {
+		return nil, ErrInvalidKeyDER
+	}
+	return d, nil
+}
+
+// EncodePEM encode the DER of private key to PEM by format
+func EncodePEM(der []byte, format string) ([]byte, error) {
+	switch format {
+	case KeyFormatPKCS8:
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypePKCS8, Bytes: der}), nil
+	case KeyFormatSEC1:
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypeSEC1, Bytes: der}), nil
+	default:
+		return nil, ErrKeyFormatNotSupport
+	}
+}
+
+// DecodePEM return the DER of private key in PEM, other blocks such as
+// EC PARAMETERS are skipped. The data is returned directly if it is not PEM.
+func DecodePEM(data []byte) ([]byte, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return data, nil
+	}
+	for block != nil {
+		if block.Type == pemTypePKCS8 || block.Type == pemTypeSEC1 {
+			return block.Bytes, nil
+		}
+		block, rest = pem.Decode(rest)
+	}
+	return nil, ErrKeyFormatNotSupport
+}
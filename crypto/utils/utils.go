@@ -21,12 +21,17 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/crypto"
 	"github.com/pdupub/go-pdu/crypto/bitcoin"
+	"github.com/pdupub/go-pdu/crypto/bls"
 	"github.com/pdupub/go-pdu/crypto/ethereum"
 	"github.com/pdupub/go-pdu/crypto/pdu"
 )
 
+// ErrSourceMissing is returned if the source of serialized key is empty
+var ErrSourceMissing = common.NewError(common.ErrCodeCrypto+13, "key source missing")
+
 // SelectEngine return a new engine by source type
 func SelectEngine(source string) (crypto.Engine, error) {
 	var engine crypto.Engine
@@ -37,6 +42,8 @@ func SelectEngine(source string) (crypto.Engine, error) {
 		engine = pdu.New()
 	case crypto.ETH:
 		engine = ethereum.New()
+	case crypto.BLS:
+		engine = bls.New()
 	default:
 		return nil, crypto.ErrSourceNotMatch
 	}
@@ -45,20 +52,55 @@ func SelectEngine(source string) (crypto.Engine, error) {
 
 // DecryptKey decrypt private key from keyJSON file
 func DecryptKey(keyJSON []byte, passwd string) (*crypto.PrivateKey, *crypto.PublicKey, error) {
-	var engine crypto.Engine
-
-	keyJM := make(map[string]interface{})
-	if err := json.Unmarshal(keyJSON, &keyJM); err != nil {
+	engine, err := selectEngineByKey(keyJSON)
+	if err != nil {
 		return nil, nil, err
 	}
-	source := keyJM["source"].(string)
+	return engine.DecryptKey(keyJSON, passwd)
+}
+
+// selectEngineByKey return the engine by the source field of serialized key
+func selectEngineByKey(keyJSON []byte) (crypto.Engine, error) {
+	var key struct {
+		Source string `json:"source"`
+	}
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		return nil, err
+	}
+	if key.Source == "" {
+		return nil, ErrSourceMissing
+	}
+	return SelectEngine(key.Source)
+}
 
-	engine, err := SelectEngine(source)
+// ParsePublicKeyJSON parse the public key marshaled by any engine, the
+// engine is selected by the source field
+func ParsePublicKeyJSON(pubKeyJSON []byte) (*crypto.PublicKey, error) {
+	engine, err := selectEngineByKey(pubKeyJSON)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	_, pubKey, err := engine.Unmarshal(nil, pubKeyJSON)
+	return pubKey, err
+}
 
-	return engine.DecryptKey(keyJSON, passwd)
+// ParsePrivateKey parse the private key marshaled by any engine, and the
+// public key if pubKeyJSON is not empty, which should be same source
+func ParsePrivateKey(privKeyJSON, pubKeyJSON []byte) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	engine, err := selectEngineByKey(privKeyJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pubKeyJSON) > 0 {
+		pubEngine, err := selectEngineByKey(pubKeyJSON)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pubEngine.Name() != engine.Name() {
+			return nil, nil, crypto.ErrSourceNotMatch
+		}
+	}
+	return engine.Unmarshal(privKeyJSON, pubKeyJSON)
 }
 
 // DisplayKey decrypt private key from keyJSON file
@@ -0,0 +1,74 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+func TestParseKey(t *testing.T) {
+	var pubKeys [][]byte
+	for _, source := range []string{crypto.ETH, crypto.BTC} {
+		engine, err := SelectEngine(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priKey, pubKey, err := engine.GenKey(crypto.MultipleSignatures, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priKeyBytes, pubKeyBytes, err := engine.Marshal(priKey, pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsedPub, err := ParsePublicKeyJSON(pubKeyBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedPub.Source != source || parsedPub.SigType != crypto.MultipleSignatures {
+			t.Error("public key type not match", parsedPub.Source, parsedPub.SigType)
+		}
+		parsedPri, parsedPub, err := ParsePrivateKey(priKeyBytes, pubKeyBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priKeyBytes2, pubKeyBytes2, err := engine.Marshal(parsedPri, parsedPub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(priKeyBytes, priKeyBytes2) || !bytes.Equal(pubKeyBytes, pubKeyBytes2) {
+			t.Error("key not match after parsed", source)
+		}
+		pubKeys = append(pubKeys, pubKeyBytes)
+	}
+
+	if _, err := ParsePublicKeyJSON([]byte(`{"sigType":"S2PK","pubKey":"00"}`)); err != ErrSourceMissing {
+		t.Errorf("%s expected, but get %v", ErrSourceMissing, err)
+	}
+	if _, err := ParsePublicKeyJSON([]byte(`{"source":"XRP","sigType":"S2PK","pubKey":"00"}`)); err != crypto.ErrSourceNotMatch {
+		t.Errorf("%s expected, but get %v", crypto.ErrSourceNotMatch, err)
+	}
+	engine, _ := SelectEngine(crypto.ETH)
+	priKey, _, _ := engine.GenKey(crypto.Signature2PublicKey)
+	priKeyBytes, _, _ := engine.Marshal(priKey, nil)
+	if _, _, err := ParsePrivateKey(priKeyBytes, pubKeys[1]); err != crypto.ErrSourceNotMatch {
+		t.Errorf("key pair of diff sources should be %s, but get %v", crypto.ErrSourceNotMatch, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/anchor"
+	"github.com/pdupub/go-pdu/common"
+)
+
+// SaveAnchor save the anchor of checkpoint published to chain
+func SaveAnchor(udb UDB, a *anchor.Anchor) error {
+	aBytes, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return udb.Set(BucketAnchor, checkpointKey(a.SpaceTimeID, a.Seq), aBytes)
+}
+
+// GetAnchor return the anchor of checkpoint at seq, nil if not published
+func GetAnchor(udb UDB, spaceTimeID common.Hash, seq uint64) (*anchor.Anchor, error) {
+	aBytes, err := udb.Get(BucketAnchor, checkpointKey(spaceTimeID, seq))
+	if err != nil || aBytes == nil {
+		return nil, err
+	}
+	var a anchor.Anchor
+	if err := json.Unmarshal(aBytes, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GetAnchors return the anchors of all space-times, order by space-time and seq
+func GetAnchors(udb UDB, skip, limit int) (anchors []*anchor.Anchor, err error) {
+	rows, err := udb.Find(BucketAnchor, "", skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		var a anchor.Anchor
+		if err := json.Unmarshal(row.V, &a); err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, &a)
+	}
+	return anchors, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// AuditEntry is the record of state-changing operation of node, such as msg
+// accepted or rejected, user created, user state changed and content pruned.
+// The entries are append only, and never changed after saved.
+type AuditEntry struct {
+	ID      uint64      `json:"id"`
+	Kind    string      `json:"kind"`
+	Time    int64       `json:"time"`
+	Peer    string      `json:"peer,omitempty"` // originating peer address, local or outbound
+	MsgID   common.Hash `json:"msgID"`
+	UserID  common.Hash `json:"userID"`
+	Reason  string      `json:"reason,omitempty"`
+	Rule    string      `json:"rule,omitempty"`
+	Details string      `json:"details,omitempty"`
+}
+
+// AppendAudit save the entry at the end of audit log, the id is assigned in
+// order of appended
+func AppendAudit(udb UDB, e *AuditEntry) error {
+	lastID, err := udb.Get(BucketConfig, ConfigAuditID)
+	if err != nil {
+		return err
+	}
+	e.ID = new(big.Int).SetBytes(lastID).Uint64() + 1
+	eBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := udb.Set(BucketAudit, orderKey(e.ID), eBytes); err != nil {
+		return err
+	}
+	return udb.Set(BucketConfig, ConfigAuditID, new(big.Int).SetUint64(e.ID).Bytes())
+}
+
+// auditPage is the number of audit entries read from db at once
+const auditPage = 1024
+
+// walkAudit call fn with the audit entries from id in order, until fn
+// return false or error. The ids start from 1 and have no gap, so entries
+// before from are skipped by count.
+func walkAudit(udb UDB, from uint64, fn func(e *AuditEntry) (bool, error)) error {
+	skip := 0
+	if from > 1 {
+		skip = int(from - 1)
+	}
+	for ; ; skip += auditPage {
+		rows, err := udb.Find(BucketAudit, "", skip, auditPage)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var e AuditEntry
+			if err := json.Unmarshal(row.V, &e); err != nil {
+				return err
+			}
+			if next, err := fn(&e); err != nil || !next {
+				return err
+			}
+		}
+		if len(rows) < auditPage {
+			return nil
+		}
+	}
+}
+
+// GetAuditEntries return at most limit audit entries from id in order, the
+// entries of other kinds are skipped if kind is not empty
+func GetAuditEntries(udb UDB, from uint64, limit int, kind string) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	err := walkAudit(udb, from, func(e *AuditEntry) (bool, error) {
+		if len(entries) >= limit {
+			return false, nil
+		}
+		if kind == "" || e.Kind == kind {
+			entries = append(entries, e)
+		}
+		return true, nil
+	})
+	return entries, err
+}
+
+// ExportAudit write all audit entries into w as json lines, return the
+// count written
+func ExportAudit(udb UDB, w io.Writer) (int, error) {
+	cnt := 0
+	enc := json.NewEncoder(w)
+	err := walkAudit(udb, 1, func(e *AuditEntry) (bool, error) {
+		if err := enc.Encode(e); err != nil {
+			return false, err
+		}
+		cnt++
+		return true, nil
+	})
+	return cnt, err
+}
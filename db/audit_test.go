@@ -0,0 +1,63 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestAudit(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketAudit); err != nil {
+		t.Fatal(err)
+	}
+	for i, kind := range []string{"accept", "reject", "accept", "user", "accept"} {
+		e := &db.AuditEntry{Kind: kind, Peer: "local", MsgID: common.Bytes2Hash([]byte{byte(i)})}
+		if err := db.AppendAudit(udb, e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != uint64(i+1) {
+			t.Errorf("id should be %d, but get %d", i+1, e.ID)
+		}
+	}
+	entries, err := db.GetAuditEntries(udb, 2, 2, "")
+	if err != nil || len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 3 {
+		t.Fatal("entries not match", err)
+	}
+	if entries, _ := db.GetAuditEntries(udb, 0, 10, "accept"); len(entries) != 3 || entries[2].ID != 5 {
+		t.Error("entries of other kinds should be skipped")
+	}
+
+	var buf bytes.Buffer
+	if cnt, err := db.ExportAudit(udb, &buf); err != nil || cnt != 5 {
+		t.Fatal("export audit fail", cnt, err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	for id := uint64(1); scanner.Scan(); id++ {
+		var e db.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.ID != id {
+			t.Error("json line not match", id, scanner.Text())
+		}
+	}
+}
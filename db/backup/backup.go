@@ -0,0 +1,388 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// ManifestFile is the name of manifest in backup dir
+	ManifestFile = "manifest.json"
+
+	// KindFull is the entry of full snapshot of db
+	KindFull = "full"
+
+	// KindDelta is the entry of msgs accepted in one day
+	KindDelta = "delta"
+
+	dayLayout = "20060102"
+)
+
+var (
+	// ErrChecksumNotMatch returns when the file in backup dir is changed or broken
+	ErrChecksumNotMatch = errors.New("checksum of backup file not match")
+
+	// ErrFullSnapshotMissing returns when restore from dir without full snapshot
+	ErrFullSnapshotMissing = errors.New("full snapshot missing in backup")
+
+	// ErrBackupGap returns when msgs between two entries are missing
+	ErrBackupGap = errors.New("msgs missing between backup entries")
+
+	// ErrTargetExist returns when restore into a db file already exist
+	ErrTargetExist = errors.New("restore target already exist")
+)
+
+// now is replaced in test to simulate backups in different days
+var now = time.Now
+
+// Entry is one file in backup dir. Msgs in entry are in order [From, To),
+// the full snapshot always starts from 0.
+type Entry struct {
+	File     string    `json:"file"`
+	Kind     string    `json:"kind"`
+	Day      string    `json:"day"`
+	From     uint64    `json:"from"`
+	To       uint64    `json:"to"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum"`
+	Created  time.Time `json:"created"`
+}
+
+// Manifest is the list of entries in backup dir, in the order they be created
+type Manifest struct {
+	Entries []*Entry `json:"entries"`
+}
+
+// Full return the full snapshot entry, nil if not exist
+func (m *Manifest) Full() *Entry {
+	for _, e := range m.Entries {
+		if e.Kind == KindFull {
+			return e
+		}
+	}
+	return nil
+}
+
+// Last return the entry with max To, nil if manifest is empty
+func (m *Manifest) Last() *Entry {
+	var last *Entry
+	for _, e := range m.Entries {
+		if last == nil || e.To > last.To {
+			last = e
+		}
+	}
+	return last
+}
+
+// LoadManifest load manifest from backup dir, empty manifest returns if the
+// dir have no backup yet
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return &Manifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func saveManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, ManifestFile), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Backup write the storage of src into dir. The full snapshot is written if
+// dir have none, otherwise msgs accepted after last entry are written into
+// the delta of today. The delta of today is replaced if it exists, so there
+// is at most one delta for each day. Nil entry returns if no new msg.
+func Backup(src Source, dir string) (*Entry, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	created := now()
+	day := created.Format(dayLayout)
+
+	if m.Full() == nil {
+		entry := &Entry{Kind: KindFull, Day: day, Created: created}
+		entry.File = fmt.Sprintf("%s-%s.db", KindFull, day)
+		entry.Size, entry.Checksum, err = writeEntryFile(dir, entry.File, func(w io.Writer) (err error) {
+			entry.To, err = src.Snapshot(w)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		m.Entries = []*Entry{entry}
+		return entry, saveManifest(dir, m)
+	}
+
+	count, err := src.MsgCount()
+	if err != nil {
+		return nil, err
+	}
+	last := m.Last()
+	if count <= last.To {
+		return nil, nil
+	}
+	entry := &Entry{Kind: KindDelta, Day: day, From: last.To, To: count, Created: created}
+	var replaced *Entry
+	if last.Kind == KindDelta && last.Day == day {
+		replaced, entry.From = last, last.From
+	}
+	// file name carry the end order, so the replaced delta is still valid
+	// until the new manifest be saved
+	entry.File = fmt.Sprintf("%s-%s-%d.jsonl", KindDelta, day, entry.To)
+	entry.Size, entry.Checksum, err = writeEntryFile(dir, entry.File, func(w io.Writer) error {
+		return src.WriteMsgs(w, entry.From, entry.To)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if replaced != nil {
+		m.Entries = m.Entries[:len(m.Entries)-1]
+	}
+	m.Entries = append(m.Entries, entry)
+	if err := saveManifest(dir, m); err != nil {
+		return nil, err
+	}
+	if replaced != nil {
+		os.Remove(filepath.Join(dir, replaced.File))
+	}
+	return entry, nil
+}
+
+// Verify check the checksum of all entries in backup dir
+func Verify(dir string) (*Manifest, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.Full() == nil {
+		return nil, ErrFullSnapshotMissing
+	}
+	for _, e := range m.Entries {
+		checksum, err := fileChecksum(filepath.Join(dir, e.File))
+		if err != nil {
+			return nil, err
+		}
+		if checksum != e.Checksum {
+			return nil, ErrChecksumNotMatch
+		}
+	}
+	return m, nil
+}
+
+// Restore rebuild the db at dbPath from backup dir. All checksums are verified
+// first, then the full snapshot is copied to dbPath and deltas are applied in
+// order by open the db with open. Msgs already in db are skipped.
+func Restore(dir, dbPath string, open func(string) (db.UDB, error)) (count uint64, err error) {
+	m, err := Verify(dir)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		return 0, ErrTargetExist
+	}
+	if err := copyFile(filepath.Join(dir, m.Full().File), dbPath); err != nil {
+		return 0, err
+	}
+	udb, err := open(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer udb.Close()
+	// msg.ID is calculated by the hasher of universe
+	hasher, err := db.GetUniverseHasher(udb)
+	if err != nil {
+		return 0, err
+	}
+
+	deltas := make([]*Entry, 0, len(m.Entries))
+	for _, e := range m.Entries {
+		if e.Kind == KindDelta {
+			deltas = append(deltas, e)
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].From < deltas[j].From })
+	for _, e := range deltas {
+		cnt, err := db.GetMsgCount(udb)
+		if err != nil {
+			return 0, err
+		}
+		if e.From > cnt.Uint64() {
+			return 0, ErrBackupGap
+		}
+		if err := applyDelta(udb, filepath.Join(dir, e.File), hasher); err != nil {
+			return 0, err
+		}
+	}
+	cnt, err := db.GetMsgCount(udb)
+	if err != nil {
+		return 0, err
+	}
+	return cnt.Uint64(), nil
+}
+
+func applyDelta(udb db.UDB, file string, hasher common.Hasher) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var msg core.Message
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg.SealWith(hasher)
+		if _, _, err := db.GetOrderCntByMsg(udb, msg.ID()); err == nil {
+			continue
+		} else if err != db.ErrMessageNotFound {
+			return err
+		}
+		if err := db.SaveMsg(udb, &msg); err != nil {
+			return err
+		}
+	}
+}
+
+// writeMsgs write msgs in order [from, to) as json lines
+func writeMsgs(w io.Writer, udb db.UDB, from, to uint64) error {
+	enc := json.NewEncoder(w)
+	for i := from; i < to; i++ {
+		msgs := db.GetMsgByOrder(udb, new(big.Int).SetUint64(i), 1)
+		if len(msgs) == 0 {
+			return db.ErrMessageNotFound
+		}
+		if err := enc.Encode(msgs[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntryFile write the entry file and return the size and checksum of it
+func writeEntryFile(dir, name string, write func(io.Writer) error) (size int64, checksum string, err error) {
+	h := sha256.New()
+	cw := &countWriter{}
+	err = writeFileAtomic(filepath.Join(dir, name), func(w io.Writer) error {
+		return write(io.MultiWriter(w, h, cw))
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return cw.n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFileAtomic write into temp file and rename it, so no half written file
+// is left in backup dir
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/bolt"
+)
+
+func openDB(path string) (db.UDB, error) {
+	return bolt.NewDB(path)
+}
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdu_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backupDir := filepath.Join(dir, "backup")
+
+	udb, err := openDB(filepath.Join(dir, "u.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udb.Close()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketUser, db.BucketMsg, db.BucketMID, db.BucketMOD,
+		db.BucketLastMID, db.BucketSenderMID, db.BucketTypeMID); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	priKey, pubKey, err := ethereum.New().GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.CreateRootUser(*pubKey, "name", "extra")
+	var msgs []*core.Message
+	saveMsg := func(text string) {
+		msg, err := core.CreateMsg(user, &core.MsgValue{ContentType: core.TypeText, Content: []byte(text)}, priKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	day := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	now = func() time.Time { return day }
+	defer func() { now = time.Now }()
+	src := NewLocalSource(udb)
+
+	saveMsg("hello")
+	entry, err := Backup(src, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Kind != KindFull || entry.To != 1 {
+		t.Errorf("full snapshot with 1 msg expected, but get %s %d", entry.Kind, entry.To)
+	}
+
+	// deltas in same day are merged
+	saveMsg("world")
+	first, err := Backup(src, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveMsg("again")
+	entry, err = Backup(src, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Kind != KindDelta || entry.From != 1 || entry.To != 3 {
+		t.Errorf("delta [1, 3) expected, but get %s [%d, %d)", entry.Kind, entry.From, entry.To)
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, first.File)); !os.IsNotExist(err) {
+		t.Error("replaced delta should be removed")
+	}
+	if entry, err = Backup(src, backupDir); err != nil || entry != nil {
+		t.Error("no entry expected without new msg", err)
+	}
+
+	day = day.AddDate(0, 0, 1)
+	saveMsg("tomorrow")
+	if entry, err = Backup(src, backupDir); err != nil {
+		t.Fatal(err)
+	} else if entry.From != 3 || entry.To != 4 {
+		t.Errorf("delta [3, 4) expected, but get [%d, %d)", entry.From, entry.To)
+	}
+	m, err := Verify(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 3 {
+		t.Errorf("entries count should be 3, but get %d", len(m.Entries))
+	}
+
+	restorePath := filepath.Join(dir, "restore", "u.db")
+	os.MkdirAll(filepath.Dir(restorePath), 0700)
+	count, err := Restore(backupDir, restorePath, openDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != uint64(len(msgs)) {
+		t.Errorf("restored msgs count should be %d, but get %d", len(msgs), count)
+	}
+	rdb, err := openDB(restorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, msg := range msgs {
+		res := db.GetMsgByOrder(rdb, big.NewInt(int64(i)), 1)
+		if len(res) != 1 || res[0].ID() != msg.ID() {
+			t.Errorf("msg of order %d not match", i)
+		}
+	}
+	rdb.Close()
+	if _, err := Restore(backupDir, restorePath, openDB); err != ErrTargetExist {
+		t.Errorf("%s expected, but get %v", ErrTargetExist, err)
+	}
+
+	// broken file found by checksum
+	if err := ioutil.WriteFile(filepath.Join(backupDir, entry.File), []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(backupDir); err != ErrChecksumNotMatch {
+		t.Errorf("%s expected, but get %v", ErrChecksumNotMatch, err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// SnapshotPath is the path of node to get the full snapshot of db
+	SnapshotPath = "/backup/snapshot"
+
+	// MsgsPath is the path of node to get msgs by order, ?from=&to=
+	MsgsPath = "/backup/msgs"
+
+	// CountPath is the path of node to get the msg count
+	CountPath = "/backup/count"
+
+	// MsgCountHeader is the header carry msg count in snapshot
+	MsgCountHeader = "X-Pdu-Msg-Count"
+)
+
+var (
+	// ErrSnapshotNotSupported returns when the db can not write snapshot
+	ErrSnapshotNotSupported = errors.New("snapshot not supported by db")
+
+	errMsgCountMissing = errors.New("msg count missing in response")
+)
+
+// Source is the storage to backup. The running node is used as source, so
+// backup is taken without stopping it, otherwise the db is opened directly.
+type Source interface {
+	// Snapshot write the consistent copy of db, and return the msg count in it
+	Snapshot(w io.Writer) (uint64, error)
+	// MsgCount return the current msg count
+	MsgCount() (uint64, error)
+	// WriteMsgs write msgs in order [from, to) as json lines
+	WriteMsgs(w io.Writer, from, to uint64) error
+}
+
+// WriteMsgs write msgs in udb in order [from, to) as json lines
+func WriteMsgs(w io.Writer, udb db.UDB, from, to uint64) error {
+	return writeMsgs(w, udb, from, to)
+}
+
+// LocalSource is the db opened by backup itself, the node should be stopped
+type LocalSource struct {
+	udb db.UDB
+}
+
+// NewLocalSource create the source from db
+func NewLocalSource(udb db.UDB) *LocalSource {
+	return &LocalSource{udb: udb}
+}
+
+// Snapshot write the copy of db
+func (s *LocalSource) Snapshot(w io.Writer) (uint64, error) {
+	snapshotter, ok := s.udb.(db.Snapshotter)
+	if !ok {
+		return 0, ErrSnapshotNotSupported
+	}
+	count, err := s.MsgCount()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := snapshotter.Snapshot(w); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MsgCount return the msg count in db
+func (s *LocalSource) MsgCount() (uint64, error) {
+	count, err := db.GetMsgCount(s.udb)
+	if err != nil {
+		return 0, err
+	}
+	return count.Uint64(), nil
+}
+
+// WriteMsgs write msgs in order [from, to)
+func (s *LocalSource) WriteMsgs(w io.Writer, from, to uint64) error {
+	return writeMsgs(w, s.udb, from, to)
+}
+
+// RemoteSource is the running node, reached by the backup paths of its local
+// http serve
+type RemoteSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewRemoteSource create the source from the url of node, such as
+// http://127.0.0.1:1088
+func NewRemoteSource(url string) *RemoteSource {
+	return &RemoteSource{url: url, client: &http.Client{}}
+}
+
+func (s *RemoteSource) get(path string) (*http.Response, error) {
+	resp, err := s.client.Get(s.url + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// Snapshot download the snapshot of node db, the node aborts the response if
+// the snapshot fail, so a broken snapshot returns error here
+func (s *RemoteSource) Snapshot(w io.Writer) (uint64, error) {
+	resp, err := s.get(SnapshotPath)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	count, err := strconv.ParseUint(resp.Header.Get(MsgCountHeader), 10, 64)
+	if err != nil {
+		return 0, errMsgCountMissing
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MsgCount return the msg count of node
+func (s *RemoteSource) MsgCount() (uint64, error) {
+	resp, err := s.get(CountPath)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(body), 10, 64)
+}
+
+// WriteMsgs download msgs in order [from, to) from node
+func (s *RemoteSource) WriteMsgs(w io.Writer, from, to uint64) error {
+	resp, err := s.get(fmt.Sprintf("%s?from=%d&to=%d", MsgsPath, from, to))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
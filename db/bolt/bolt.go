@@ -19,6 +19,7 @@ package bolt
 import (
 	"bytes"
 	"errors"
+	"io"
 	"time"
 
 	"github.com/pdupub/go-pdu/db"
@@ -54,38 +55,29 @@ func (u *UBoltDB) Close() error {
 // CreateBucket create new bucket by name
 func (u *UBoltDB) CreateBucket(bucketName string) error {
 	return u.db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte(bucketName))
-		return err
+		return (&txDB{tx}).CreateBucket(bucketName)
 	})
 }
 
 // DeleteBucket delete the bucket by name
 func (u *UBoltDB) DeleteBucket(bucketName string) error {
 	return u.db.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(bucketName))
+		return (&txDB{tx}).DeleteBucket(bucketName)
 	})
 }
 
 // Set key/val into bucket
 func (u *UBoltDB) Set(bucketName, key string, val []byte) error {
 	return u.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
-		}
-		return b.Put([]byte(key), val)
+		return (&txDB{tx}).Set(bucketName, key, val)
 	})
 }
 
 // Get val by key from bucket
 func (u *UBoltDB) Get(bucketName, key string) (val []byte, err error) {
 	err = u.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
-		}
-		val = b.Get([]byte(key))
-		return nil
+		val, err = (&txDB{tx}).Get(bucketName, key)
+		return err
 	})
 	return val, err
 }
@@ -93,16 +85,82 @@ func (u *UBoltDB) Get(bucketName, key string) (val []byte, err error) {
 // Del val by key from bucket
 func (u *UBoltDB) Del(bucketName, key string) error {
 	return u.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
-		}
-		return b.Delete([]byte(key))
+		return (&txDB{tx}).Del(bucketName, key)
 	})
 }
 
 // Find the rows from bucket by prefix
 func (u *UBoltDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row, err error) {
+	err = u.db.View(func(tx *bolt.Tx) error {
+		rows, err = (&txDB{tx}).Find(bucketName, prefix, args...)
+		return err
+	})
+	return rows, err
+}
+
+// Update run fn in one read-write tx, all changes made by fn are rolled back
+// if fn return error
+func (u *UBoltDB) Update(fn func(tx db.UDB) error) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		return fn(&txDB{tx})
+	})
+}
+
+// txDB is the UDB on one bolt tx, used by Update
+type txDB struct {
+	tx *bolt.Tx
+}
+
+// Close do nothing, the tx is closed by Update
+func (t *txDB) Close() error {
+	return nil
+}
+
+// CreateBucket create new bucket by name
+func (t *txDB) CreateBucket(bucketName string) error {
+	_, err := t.tx.CreateBucket([]byte(bucketName))
+	return err
+}
+
+// DeleteBucket delete the bucket by name
+func (t *txDB) DeleteBucket(bucketName string) error {
+	return t.tx.DeleteBucket([]byte(bucketName))
+}
+
+// Set key/val into bucket
+func (t *txDB) Set(bucketName, key string, val []byte) error {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return errBucketNotExist
+	}
+	return b.Put([]byte(key), val)
+}
+
+// Get val by key from bucket, the val is copied since it is only valid
+// during the tx
+func (t *txDB) Get(bucketName, key string) ([]byte, error) {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return nil, errBucketNotExist
+	}
+	val := b.Get([]byte(key))
+	if val == nil {
+		return nil, nil
+	}
+	return append([]byte{}, val...), nil
+}
+
+// Del val by key from bucket
+func (t *txDB) Del(bucketName, key string) error {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return errBucketNotExist
+	}
+	return b.Delete([]byte(key))
+}
+
+// Find the rows from bucket by prefix
+func (t *txDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row, err error) {
 	var skip, limit int
 	if len(args) == 0 {
 		return rows, errFindMissingLimit
@@ -116,24 +174,31 @@ func (u *UBoltDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row,
 		return rows, errFindArgsNumberNotCorrect
 	}
 
-	err = u.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return rows, errBucketNotExist
+	}
+	c := b.Cursor()
+	prefixBytes := []byte(prefix)
+	count := 0
+	for k, v := c.Seek(prefixBytes); k != nil && bytes.HasPrefix(k, prefixBytes); k, v = c.Next() {
+		if count >= skip+limit {
+			break
 		}
-		c := b.Cursor()
-		prefixBytes := []byte(prefix)
-		count := 0
-		for k, v := c.Seek(prefixBytes); k != nil && bytes.HasPrefix(k, prefixBytes); k, v = c.Next() {
-			if count >= skip+limit {
-				break
-			}
-			if count >= skip {
-				rows = append(rows, &db.Row{K: string(k), V: v})
-			}
-			count++
+		if count >= skip {
+			rows = append(rows, &db.Row{K: string(k), V: append([]byte{}, v...)})
 		}
-		return nil
+		count++
+	}
+	return rows, nil
+}
+
+// Snapshot write the whole db into w in one read tx, so the copy is consistent
+// while other goroutines keep writing
+func (u *UBoltDB) Snapshot(w io.Writer) (n int64, err error) {
+	err = u.db.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
 	})
-	return rows, err
+	return n, err
 }
@@ -17,12 +17,16 @@
 package bolt
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path"
 	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/db"
 )
 
 func TestNewDB(t *testing.T) {
@@ -78,3 +82,135 @@ func TestNewDB(t *testing.T) {
 	// clear test file
 	os.Remove(filePath)
 }
+
+func TestUpdate(t *testing.T) {
+	bucketName := "testBucket"
+	errFail := errors.New("fail")
+	dir, _ := os.Getwd()
+	filePath := path.Join(dir, "update_test.db")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+
+	u, err := NewDB(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if err := u.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Set(bucketName, "a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Update(func(tx db.UDB) error {
+		if err := tx.Del(bucketName, "a"); err != nil {
+			return err
+		}
+		if err := tx.Set(bucketName, "b", []byte("b")); err != nil {
+			return err
+		}
+		return errFail
+	}); err != errFail {
+		t.Errorf("err should be %s, but get %v", errFail, err)
+	}
+	if val, _ := u.Get(bucketName, "a"); string(val) != "a" {
+		t.Error("val should not be deleted by failed update")
+	}
+	if val, _ := u.Get(bucketName, "b"); val != nil {
+		t.Error("val should not be set by failed update")
+	}
+	if err := u.Update(func(tx db.UDB) error {
+		if err := tx.Del(bucketName, "a"); err != nil {
+			return err
+		}
+		return tx.Set(bucketName, "b", []byte("b"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := u.Find(bucketName, "", 10); len(rows) != 1 || rows[0].K != "b" {
+		t.Error("update should be applied")
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	dir, _ := os.Getwd()
+	filePath := path.Join(dir, "migrate_test.db")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+	defer os.Remove(db.MigrationBackupFile(filePath, 0))
+	open := func(p string) (db.UDB, error) { return NewDB(p) }
+
+	// db created by old version, without index buckets and schema version
+	u, err := NewDB(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucketName := range []string{db.BucketConfig, db.BucketMsg, db.BucketMID, db.BucketMOD} {
+		if err := u.CreateBucket(bucketName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveUniverseHasher(u, common.HasherBLAKE3); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+
+	checkVersion := func(expect uint64) {
+		u, err := NewDB(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer u.Close()
+		if version, err := db.GetSchemaVersion(u); err != nil || version != expect {
+			t.Errorf("schema version should be %d, but get %d %v", expect, version, err)
+		}
+	}
+
+	version, applied, err := db.MigrateFile(filePath, open, true)
+	if err != nil || version != 0 || len(applied) != int(db.SchemaVersion()) {
+		t.Errorf("dry run should apply all migrations, but get %d %v", len(applied), err)
+	}
+	checkVersion(0)
+	if _, err := os.Stat(db.MigrationBackupFile(filePath, 0)); !os.IsNotExist(err) {
+		t.Error("no backup expected in dry run")
+	}
+
+	// migrate stop before any migration if ctx done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	u, err = NewDB(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := db.MigrateContext(ctx, u); err != context.Canceled || len(applied) != 0 {
+		t.Errorf("migrate should be canceled, but get %d %v", len(applied), err)
+	}
+	u.Close()
+	checkVersion(0)
+
+	if _, applied, err = db.MigrateFile(filePath, open, false); err != nil || len(applied) != int(db.SchemaVersion()) {
+		t.Errorf("all migrations should be applied, but get %d %v", len(applied), err)
+	}
+	checkVersion(db.SchemaVersion())
+	if _, applied, err = db.MigrateFile(filePath, open, false); err != nil || len(applied) != 0 {
+		t.Errorf("no migration expected, but get %d %v", len(applied), err)
+	}
+
+	if version, err := db.RollbackFile(filePath); err != nil || version != 0 {
+		t.Errorf("rollback to version 0 expected, but get %d %v", version, err)
+	}
+	checkVersion(0)
+
+	u, err = NewDB(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SaveSchemaVersion(u, db.SchemaVersion()+1)
+	if _, err := db.PendingMigrations(u); err != db.ErrSchemaTooNew {
+		t.Errorf("%s expected, but get %v", db.ErrSchemaTooNew, err)
+	}
+	u.Close()
+}
@@ -0,0 +1,369 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// ContentDedupSize is the min size of msg content stored once by its hash
+// in BucketContent, smaller content is kept in msg, since the hash and ref
+// count cost more than the content itself.
+const ContentDedupSize = 64
+
+// pruneContentPage is the count of contents checked at once by PruneContents
+const pruneContentPage = 1024
+
+// envelopePrefix is the prefix of msg saved without content, the content
+// is stored in BucketContent by contentHash
+var envelopePrefix = []byte(`{"contentHash":"`)
+
+var (
+	// ErrContentNotFound returns when the content of msg is missing in db
+	ErrContentNotFound = errors.New("content not found")
+	// ErrArchiveNotSupported returns when the db can not archive value
+	ErrArchiveNotSupported = errors.New("archive not supported by db")
+)
+
+// msgEnvelope is the msg saved in BucketMsg, the content of Msg is removed
+type msgEnvelope struct {
+	ContentHash string        `json:"contentHash"`
+	Msg         *core.Message `json:"msg"`
+}
+
+// contentKey is the key of content in BucketContent, sha256 of content is
+// used, so the key not depends on the hasher of universe
+func contentKey(content []byte) string {
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+// encodeMsg return the bytes of msg saved in BucketMsg, the content not
+// smaller than ContentDedupSize is saved into BucketContent and referred
+func encodeMsg(udb UDB, msg *core.Message) ([]byte, error) {
+	if msg.Value == nil || len(msg.Value.Content) < ContentDedupSize {
+		return json.Marshal(msg)
+	}
+	key := contentKey(msg.Value.Content)
+	if err := putContent(udb, key, msg.Value.Content); err != nil {
+		return nil, err
+	}
+	if err := addContentRef(udb, key, 1); err != nil {
+		return nil, err
+	}
+	return marshalEnvelope(msg, key)
+}
+
+func marshalEnvelope(msg *core.Message, key string) ([]byte, error) {
+	envelope := *msg
+	envelope.Value = &core.MsgValue{ContentType: msg.Value.ContentType}
+	return json.Marshal(&msgEnvelope{ContentHash: key, Msg: &envelope})
+}
+
+// sealMsg seal the msg decoded from db by the hasher of universe, see
+// core.Message.SealWith
+func sealMsg(udb UDB, msg *core.Message) error {
+	hasher, err := GetUniverseHasher(udb)
+	if err != nil {
+		return err
+	}
+	msg.SealWith(hasher)
+	return nil
+}
+
+// decodeMsg decode the msg saved in BucketMsg, the content is loaded from
+// BucketContent if it is stored by hash. The msg is sealed by the hasher of
+// universe.
+func decodeMsg(udb UDB, msgBytes []byte) (*core.Message, error) {
+	if !bytes.HasPrefix(msgBytes, envelopePrefix) {
+		var msg core.Message
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			return nil, err
+		}
+		if err := sealMsg(udb, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+	var envelope msgEnvelope
+	if err := json.Unmarshal(msgBytes, &envelope); err != nil {
+		return nil, err
+	}
+	content, err := udb.Get(BucketContent, envelope.ContentHash)
+	if err != nil {
+		return nil, err
+	} else if content == nil {
+		return nil, ErrContentNotFound
+	}
+	msg := envelope.Msg
+	msg.Value.Content = content
+	// ID is cached when decoded without content
+	if err := sealMsg(udb, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GetMsg return the msg by ID
+func GetMsg(udb UDB, msgID common.Hash) (*core.Message, error) {
+	msgBytes, err := udb.Get(BucketMsg, common.Hash2String(msgID))
+	if err != nil {
+		return nil, err
+	} else if msgBytes == nil {
+		return nil, ErrMessageNotFound
+	}
+	return decodeMsg(udb, msgBytes)
+}
+
+// deleteMsg remove the msg from BucketMsg, the content stored by hash is
+// removed if no other msg refer it
+func deleteMsg(udb UDB, msgID common.Hash) error {
+	msgBytes, err := udb.Get(BucketMsg, common.Hash2String(msgID))
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(msgBytes, envelopePrefix) {
+		var envelope msgEnvelope
+		if err := json.Unmarshal(msgBytes, &envelope); err != nil {
+			return err
+		}
+		if err := addContentRef(udb, envelope.ContentHash, -1); err != nil {
+			return err
+		}
+	}
+	return udb.Del(BucketMsg, common.Hash2String(msgID))
+}
+
+// DropMsgContent remove the content of expired ephemeral msg saved in BucketMsg,
+// the msg is kept with its content hash, so it still can be validated and synced.
+func DropMsgContent(udb UDB, msgID common.Hash) error {
+	msgBytes, err := udb.Get(BucketMsg, common.Hash2String(msgID))
+	if err != nil {
+		return err
+	} else if msgBytes == nil {
+		return ErrMessageNotFound
+	}
+	if !bytes.HasPrefix(msgBytes, envelopePrefix) {
+		var msg core.Message
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			return err
+		}
+		if err := sealMsg(udb, &msg); err != nil {
+			return err
+		}
+		return saveMsgStub(udb, &msg)
+	}
+	var envelope msgEnvelope
+	if err := json.Unmarshal(msgBytes, &envelope); err != nil {
+		return err
+	}
+	if envelope.Msg.Expiry == 0 {
+		return nil
+	}
+	if err := sealMsg(udb, envelope.Msg); err != nil {
+		return err
+	}
+	if err := addContentRef(udb, envelope.ContentHash, -1); err != nil {
+		return err
+	}
+	return saveMsgStub(udb, envelope.Msg)
+}
+
+// saveMsgStub save the ephemeral msg without content
+func saveMsgStub(udb UDB, msg *core.Message) error {
+	if msg.Expiry == 0 {
+		return nil
+	}
+	msg.DropContent()
+	stubBytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return udb.Set(BucketMsg, common.Hash2String(msg.ID()), stubBytes)
+}
+
+// putContent save the content if not exist
+func putContent(udb UDB, key string, content []byte) error {
+	if exist, err := udb.Get(BucketContent, key); err != nil || exist != nil {
+		return err
+	}
+	return udb.Set(BucketContent, key, content)
+}
+
+// addContentRef change the ref count of content, the content is removed
+// when the count reach 0
+func addContentRef(udb UDB, key string, delta int64) error {
+	refs, err := GetContentRefs(udb, key)
+	if err != nil {
+		return err
+	}
+	count := new(big.Int).SetUint64(refs)
+	count.Add(count, big.NewInt(delta))
+	if count.Sign() <= 0 {
+		if err := udb.Del(BucketContentRef, key); err != nil {
+			return err
+		}
+		return udb.Del(BucketContent, key)
+	}
+	return udb.Set(BucketContentRef, key, count.Bytes())
+}
+
+// GetContentRefs return the count of msgs refer the content by key
+func GetContentRefs(udb UDB, key string) (uint64, error) {
+	refBytes, err := udb.Get(BucketContentRef, key)
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(refBytes).Uint64(), nil
+}
+
+// DedupContents move the content of msgs saved inline before into
+// BucketContent, then rebuild the ref counts, safe to run again.
+func DedupContents(udb UDB) error {
+	count, err := GetMsgCount(udb)
+	if err != nil {
+		return err
+	}
+	refs := make(map[string]uint64)
+	for i := uint64(0); i < count.Uint64(); i++ {
+		mid, err := udb.Get(BucketMID, new(big.Int).SetUint64(i).String())
+		if err != nil {
+			return err
+		} else if mid == nil {
+			return ErrMessageNotFound
+		}
+		msgBytes, err := udb.Get(BucketMsg, common.Bytes2String(mid))
+		if err != nil {
+			return err
+		} else if msgBytes == nil {
+			return ErrMessageNotFound
+		}
+		if bytes.HasPrefix(msgBytes, envelopePrefix) {
+			var envelope msgEnvelope
+			if err := json.Unmarshal(msgBytes, &envelope); err != nil {
+				return err
+			}
+			refs[envelope.ContentHash]++
+			continue
+		}
+		var msg core.Message
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			return err
+		}
+		if msg.Value == nil || len(msg.Value.Content) < ContentDedupSize {
+			continue
+		}
+		key := contentKey(msg.Value.Content)
+		if err := putContent(udb, key, msg.Value.Content); err != nil {
+			return err
+		}
+		envelopeBytes, err := marshalEnvelope(&msg, key)
+		if err != nil {
+			return err
+		}
+		if err := udb.Set(BucketMsg, common.Bytes2String(mid), envelopeBytes); err != nil {
+			return err
+		}
+		refs[key]++
+	}
+	for key, n := range refs {
+		if err := udb.Set(BucketContentRef, key, new(big.Int).SetUint64(n).Bytes()); err != nil {
+			return err
+		}
+	}
+	_, err = PruneContents(udb)
+	return err
+}
+
+// PruneContents remove the contents not referred by any msg, such as the
+// content left by interrupted write, return the count removed
+func PruneContents(udb UDB) (int, error) {
+	removed, kept := 0, 0
+	for {
+		rows, err := udb.Find(BucketContent, "", kept, pruneContentPage)
+		if err != nil || len(rows) == 0 {
+			return removed, err
+		}
+		for _, row := range rows {
+			refs, err := GetContentRefs(udb, row.K)
+			if err != nil {
+				return removed, err
+			}
+			if refs > 0 {
+				kept++
+				continue
+			}
+			if err := udb.Del(BucketContent, row.K); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+}
+
+// ArchiveContents move the content of msgs received before the last keep
+// msgs to cold storage by Archiver, at most limit msgs are checked, return
+// the count of msgs which content is archived. The order of next msg is saved, so the
+// contents are archived in batches. The content kept in msg is not moved.
+func ArchiveContents(udb UDB, keep uint64, limit int) (int, error) {
+	archiver, ok := udb.(Archiver)
+	if !ok {
+		return 0, ErrArchiveNotSupported
+	}
+	count, err := GetMsgCount(udb)
+	if err != nil {
+		return 0, err
+	}
+	orderBytes, err := udb.Get(BucketConfig, ConfigArchiveOrder)
+	if err != nil {
+		return 0, err
+	}
+	order := new(big.Int).SetBytes(orderBytes).Uint64()
+	archived := 0
+	for ; order+keep < count.Uint64() && limit > 0; order, limit = order+1, limit-1 {
+		mid, err := udb.Get(BucketMID, new(big.Int).SetUint64(order).String())
+		if err != nil {
+			return archived, err
+		} else if mid == nil {
+			return archived, ErrMessageNotFound
+		}
+		msgBytes, err := udb.Get(BucketMsg, common.Bytes2String(mid))
+		if err != nil {
+			return archived, err
+		}
+		if !bytes.HasPrefix(msgBytes, envelopePrefix) {
+			continue
+		}
+		var envelope msgEnvelope
+		if err := json.Unmarshal(msgBytes, &envelope); err != nil {
+			return archived, err
+		}
+		if err := archiver.Archive(BucketContent, envelope.ContentHash); err != nil {
+			return archived, err
+		}
+		archived++
+	}
+	return archived, udb.Set(BucketConfig, ConfigArchiveOrder, new(big.Int).SetUint64(order).Bytes())
+}
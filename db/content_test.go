@@ -0,0 +1,212 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestContentDedup(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketMsg, db.BucketMID, db.BucketMOD, db.BucketLastMID,
+		db.BucketSenderMID, db.BucketTypeMID, db.BucketContent, db.BucketContentRef); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	priKey, pubKey, err := ethereum.New().GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.CreateRootUser(*pubKey, "name", "extra")
+	shared := bytes.Repeat([]byte("repost "), 20)
+	h := sha256.Sum256(shared)
+	key := hex.EncodeToString(h[:])
+
+	var msgs []*core.Message
+	for _, content := range [][]byte{shared, shared, []byte("hi")} {
+		var refs []*core.MsgReference
+		if len(msgs) > 0 {
+			last := msgs[len(msgs)-1]
+			refs = append(refs, &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()})
+		}
+		msg, err := core.CreateMsg(user, &core.MsgValue{ContentType: core.TypeText, Content: content}, priKey, refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if refs, err := db.GetContentRefs(udb, key); err != nil || refs != 2 {
+		t.Error("shared content should have 2 refs, but", refs, err)
+	}
+	if rows, _ := udb.Find(db.BucketContent, "", 10); len(rows) != 1 {
+		t.Error("only shared content should be stored by hash, but", len(rows))
+	}
+	for i, msg := range db.GetMsgByOrder(udb, big.NewInt(0), 3) {
+		if msg.ID() != msgs[i].ID() || !bytes.Equal(msg.Value.Content, msgs[i].Value.Content) {
+			t.Error("msg not match after loaded", i)
+		}
+		loaded, _ := json.Marshal(msg)
+		saved, _ := json.Marshal(msgs[i])
+		if !bytes.Equal(loaded, saved) {
+			t.Error("msg loaded not same as saved", i)
+		}
+	}
+
+	// refs released when msgs removed
+	if err := db.TruncateMsgs(udb, msgs[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := db.GetContentRefs(udb, key); refs != 1 {
+		t.Error("shared content should have 1 ref after truncate, but", refs)
+	}
+
+	// msg saved inline by old release is moved into content bucket
+	msgBytes, err := json.Marshal(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][3]string{
+		{db.BucketMsg, common.Hash2String(msgs[0].ID()), string(msgBytes)},
+		{db.BucketContentRef, key, ""},
+		{db.BucketContent, "orphan", "left by interrupted write"},
+	} {
+		if kv[2] == "" {
+			err = udb.Del(kv[0], kv[1])
+		} else {
+			err = udb.Set(kv[0], kv[1], []byte(kv[2]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DedupContents(udb); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := db.GetContentRefs(udb, key); refs != 1 {
+		t.Error("refs should be rebuilt, but", refs)
+	}
+	if orphan, _ := udb.Get(db.BucketContent, "orphan"); orphan != nil {
+		t.Error("orphan content should be pruned")
+	}
+	if msgBytes, _ := udb.Get(db.BucketMsg, common.Hash2String(msgs[0].ID())); bytes.Contains(msgBytes, []byte("repost")) {
+		t.Error("content should not be kept in msg")
+	}
+	if msg, err := db.GetMsg(udb, msgs[0].ID()); err != nil || msg.ID() != msgs[0].ID() {
+		t.Error("msg not loaded after dedup", err)
+	}
+}
+
+func TestDropMsgContent(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketMsg, db.BucketMID, db.BucketMOD, db.BucketLastMID,
+		db.BucketSenderMID, db.BucketTypeMID, db.BucketContent, db.BucketContentRef); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	priKey, pubKey, err := ethereum.New().GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.CreateRootUser(*pubKey, "name", "extra")
+	story := bytes.Repeat([]byte("story "), 20)
+	h := sha256.Sum256(story)
+	key := hex.EncodeToString(h[:])
+
+	var msgs []*core.Message
+	for _, expiry := range []uint64{0, 3, 3} {
+		var refs []*core.MsgReference
+		if len(msgs) > 0 {
+			last := msgs[len(msgs)-1]
+			refs = append(refs, &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()})
+		}
+		value := &core.MsgValue{ContentType: core.TypeText, Content: story}
+		var msg *core.Message
+		if expiry == 0 {
+			msg, err = core.CreateMsg(user, value, priKey, refs...)
+		} else {
+			msg, err = core.CreateMsgWithExpiry(core.NetworkMain, expiry, user, value, priKey, 0, refs...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if refs, _ := db.GetContentRefs(udb, key); refs != 3 {
+		t.Error("story should have 3 refs, but", refs)
+	}
+
+	// msg without expiry is not changed
+	if err := db.DropMsgContent(udb, msgs[0].ID()); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs[1:] {
+		if err := db.DropMsgContent(udb, msg.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refs, _ := db.GetContentRefs(udb, key); refs != 1 {
+		t.Error("story should have 1 ref after dropped, but", refs)
+	}
+	for i, msg := range msgs {
+		loaded, err := db.GetMsg(udb, msg.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.ID() != msg.ID() {
+			t.Error("msg ID changed after content dropped", i)
+		}
+		if dropped := i > 0; loaded.ContentDropped() != dropped || bytes.Equal(loaded.Value.Content, story) == dropped {
+			t.Error("content of msg should be dropped only if ephemeral", i)
+		}
+		signature := *loaded.Signature
+		signature.PubKey = user.Auth.PubKey
+		loaded.Signature = &signature
+		if ok, err := core.VerifyMsg(*loaded); err != nil || !ok {
+			t.Error("msg should still be verified", i, err)
+		}
+	}
+
+	// drop again is safe
+	if err := db.DropMsgContent(udb, msgs[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := db.GetContentRefs(udb, key); refs != 1 {
+		t.Error("ref should not change when dropped again, but", refs)
+	}
+}
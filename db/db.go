@@ -16,6 +16,8 @@
 
 package db
 
+import "io"
+
 const (
 	// BucketUser is used to save all users
 	BucketUser = "user"
@@ -38,6 +40,49 @@ const (
 	// BucketPeer is used to save the peer information
 	BucketPeer = "peer"
 
+	// BucketCheckpoint is used to save checkpoints of space-time (spacetime.ID+seq/checkpoint)
+	BucketCheckpoint = "checkpoint"
+
+	// BucketSenderMID is used to save msg.ID by sender and order (sender.ID+order/msg.ID)
+	BucketSenderMID = "smid"
+
+	// BucketTypeMID is used to save msg.ID by content type and order (contentType+order/msg.ID)
+	BucketTypeMID = "tmid"
+
+	// BucketContent is used to save msg content not smaller than ContentDedupSize
+	// by its hash, shared by msgs with same content (sha256(content)/content)
+	BucketContent = "content"
+
+	// BucketContentRef is used to save the count of msgs refer the content (sha256(content)/count)
+	BucketContentRef = "cref"
+
+	// BucketNotify is used to save notifications of local users (user.ID+id/notification)
+	BucketNotify = "notify"
+
+	// BucketAudit is used to save the audit log of state-changing operations (id/entry)
+	BucketAudit = "audit"
+
+	// BucketOutbox is used to save the msgs drafted by local user until be submitted (id/item)
+	BucketOutbox = "outbox"
+
+	// BucketOutboxEvent is used to save the status transitions of outbox items (id/event)
+	BucketOutboxEvent = "oevent"
+
+	// BucketDelivery is used to save the msgs of local user sent until acked by peer (waveID/delivery)
+	BucketDelivery = "delivery"
+
+	// BucketArchive is used to mark the values moved to cold storage by Archiver (bucket+key/size)
+	BucketArchive = "archive"
+
+	// BucketFeed is used to save msgs of space-time from new to old (spacetime.ID+^seq+^order/msg.ID)
+	BucketFeed = "feed"
+
+	// BucketAnchor is used to save checkpoints published to external chain (spacetime.ID+seq/anchor)
+	BucketAnchor = "anchor"
+
+	// BucketLabel is used to save labels of msgs and users set by local user (target.ID+label/label)
+	BucketLabel = "label"
+
 	// ConfigRoot0 root user which gender is 0
 	ConfigRoot0 = "root0"
 
@@ -55,6 +100,9 @@ const (
 	// ConfigLocalNodeKey is the local node key
 	ConfigLocalNodeKey = "local_node_key"
 
+	// ConfigNodeKeyPair is the key pair of local node, local node key is derived from its public key
+	ConfigNodeKeyPair = "node_key_pair"
+
 	// ConfigUniverseDimension is universe dimension, depends on how your view the universe,
 	// just related to calculate the distance between two common.Hash in this universe.
 	ConfigUniverseDimension = "universe_dimension"
@@ -64,6 +112,46 @@ const (
 
 	// ConfigUniverseRedshiftConstant is local constant for dynamice universe model.
 	ConfigUniverseRedshiftConstant = "universe_red_shift"
+
+	// ConfigTimeProofPolicy is the trusted and primary space-time chosen by local user
+	ConfigTimeProofPolicy = "time_proof_policy"
+
+	// ConfigMsgFilter is the bloom filter of msg.ID in local universe
+	ConfigMsgFilter = "msg_filter"
+
+	// ConfigUniverseHasher is the name of hash function chosen when universe be created
+	ConfigUniverseHasher = "universe_hasher"
+
+	// ConfigNetworkID is the network of universe, main network if not be saved
+	ConfigNetworkID = "network_id"
+
+	// ConfigSchemaVersion is the version of on-disk layout, db created before
+	// migrations be introduced have no version (0)
+	ConfigSchemaVersion = "schema_version"
+
+	// ConfigAPIKeys is the api keys of local apis, secrets are saved as hash
+	ConfigAPIKeys = "api_keys"
+
+	// ConfigNotifyID is the id of last notification saved
+	ConfigNotifyID = "notify_id"
+
+	// ConfigAuditID is the id of last audit entry saved
+	ConfigAuditID = "audit_id"
+
+	// ConfigOutboxID is the id of last outbox item saved
+	ConfigOutboxID = "outbox_id"
+
+	// ConfigOutboxEventID is the id of last outbox event saved
+	ConfigOutboxEventID = "outbox_event_id"
+
+	// ConfigDeliveryID is the id of last delivery saved
+	ConfigDeliveryID = "delivery_id"
+
+	// ConfigArchiveOrder is the order of msg which content to be archived next
+	ConfigArchiveOrder = "archive_order"
+
+	// ConfigFeedCursor is the prefix of the order of msg to be added into feed of space-time next
+	ConfigFeedCursor = "feed_cursor_"
 )
 
 const (
@@ -90,3 +178,22 @@ type UDB interface {
 	Del(string, string) error
 	Find(string, string, ...int) ([]*Row, error)
 }
+
+// Snapshotter is implemented by UDB which can write a consistent copy of
+// the whole db, used by backup
+type Snapshotter interface {
+	Snapshot(w io.Writer) (int64, error)
+}
+
+// Updater is implemented by UDB which can apply many changes in one
+// transaction. The changes made by fn on tx are all dropped if fn return
+// error, and tx should not be used after fn returned.
+type Updater interface {
+	Update(fn func(tx UDB) error) error
+}
+
+// Archiver is implemented by UDB which can move the value to cold storage,
+// such as object storage, the value archived is still returned by Get
+type Archiver interface {
+	Archive(bucket, key string) error
+}
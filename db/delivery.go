@@ -0,0 +1,99 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// Delivery is the msg of local user sent to peers in the wave asking ack,
+// it is kept until acked by any peer, so it is sent again with same wave
+// ID after reconnect or restart, and the peer received it before only ack.
+type Delivery struct {
+	ID       uint64        `json:"id"`
+	WaveID   common.Hash   `json:"waveID"`
+	Msg      *core.Message `json:"msg"`
+	Attempts int           `json:"attempts"`
+	Created  int64         `json:"created"`
+	Updated  int64         `json:"updated"`
+}
+
+// SaveDelivery save the delivery by its wave ID, the id is assigned in
+// order of added if delivery is new
+func SaveDelivery(udb UDB, d *Delivery) error {
+	if d.ID == 0 {
+		id, err := nextID(udb, ConfigDeliveryID)
+		if err != nil {
+			return err
+		}
+		d.ID = id
+		if err := udb.Set(BucketConfig, ConfigDeliveryID, new(big.Int).SetUint64(id).Bytes()); err != nil {
+			return err
+		}
+	}
+	dBytes, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return udb.Set(BucketDelivery, common.Hash2String(d.WaveID), dBytes)
+}
+
+// GetDelivery return the delivery of wave, nil if not exist
+func GetDelivery(udb UDB, waveID common.Hash) (*Delivery, error) {
+	dBytes, err := udb.Get(BucketDelivery, common.Hash2String(waveID))
+	if err != nil || dBytes == nil {
+		return nil, err
+	}
+	var d Delivery
+	if err := json.Unmarshal(dBytes, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DeleteDelivery remove the delivery of wave after acked
+func DeleteDelivery(udb UDB, waveID common.Hash) error {
+	return udb.Del(BucketDelivery, common.Hash2String(waveID))
+}
+
+// GetDeliveries return all deliveries not acked in order of added
+func GetDeliveries(udb UDB) ([]*Delivery, error) {
+	deliveries := []*Delivery{}
+	for skip := 0; ; skip += outboxPage {
+		rows, err := udb.Find(BucketDelivery, "", skip, outboxPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			var d Delivery
+			if err := json.Unmarshal(row.V, &d); err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, &d)
+		}
+		if len(rows) < outboxPage {
+			break
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestDelivery(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketDelivery); err != nil {
+		t.Fatal(err)
+	}
+	var waveIDs []common.Hash
+	for i := 0; i < 3; i++ {
+		msg := &core.Message{SenderID: common.Bytes2Hash([]byte{byte(i)}), Value: &core.MsgValue{ContentType: core.TypeText, Content: []byte("post")},
+			Signature: &crypto.Signature{Signature: []byte("sig")}}
+		d := &db.Delivery{WaveID: common.CreateHash(), Msg: msg, Attempts: 1}
+		if err := db.SaveDelivery(udb, d); err != nil {
+			t.Fatal(err)
+		}
+		if d.ID != uint64(i+1) {
+			t.Errorf("id should be %d, but get %d", i+1, d.ID)
+		}
+		waveIDs = append(waveIDs, d.WaveID)
+	}
+
+	d, err := db.GetDelivery(udb, waveIDs[1])
+	if err != nil || d == nil || d.ID != 2 || string(d.Msg.Value.Content) != "post" {
+		t.Fatal("get delivery fail", err)
+	}
+	d.Attempts++
+	if err := db.SaveDelivery(udb, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteDelivery(udb, waveIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := db.GetDelivery(udb, waveIDs[0]); err != nil || d != nil {
+		t.Error("delivery should be deleted", err)
+	}
+
+	deliveries, err := db.GetDeliveries(udb)
+	if err != nil || len(deliveries) != 2 || deliveries[0].ID != 2 || deliveries[1].ID != 3 {
+		t.Fatal("deliveries should be in order of added", err)
+	}
+	if deliveries[0].Attempts != 2 || deliveries[0].WaveID != waveIDs[1] {
+		t.Error("delivery should be updated", deliveries[0])
+	}
+}
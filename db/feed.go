@@ -0,0 +1,86 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// FeedEntry is the msg in the feed of space-time, the entries are ordered
+// by the sequence of msg in space-time, then by received order, from new
+// to old
+type FeedEntry struct {
+	Seq   uint64      `json:"seq"`
+	Order uint64      `json:"order"`
+	MsgID common.Hash `json:"msgID"`
+}
+
+// feedKey is the key of entry in BucketFeed, seq and order are inverted so
+// the entries are found from new to old
+func feedKey(spaceTimeID common.Hash, seq, order uint64) string {
+	return common.Hash2String(spaceTimeID) + orderKey(math.MaxUint64-seq) + orderKey(math.MaxUint64-order)
+}
+
+// SaveFeedEntry add the msg into the feed of space-time
+func SaveFeedEntry(udb UDB, spaceTimeID common.Hash, e *FeedEntry) error {
+	return udb.Set(BucketFeed, feedKey(spaceTimeID, e.Seq, e.Order), e.MsgID[:])
+}
+
+// GetFeed return the entries of space-time feed from new to old
+func GetFeed(udb UDB, spaceTimeID common.Hash, skip, limit int) ([]*FeedEntry, error) {
+	prefix := common.Hash2String(spaceTimeID)
+	rows, err := udb.Find(BucketFeed, prefix, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	size := len(orderKey(0))
+	entries := []*FeedEntry{}
+	for _, row := range rows {
+		if len(row.K) != len(prefix)+2*size {
+			continue
+		}
+		seq, err := strconv.ParseUint(row.K[len(prefix):len(prefix)+size], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		order, err := strconv.ParseUint(row.K[len(prefix)+size:], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &FeedEntry{Seq: math.MaxUint64 - seq, Order: math.MaxUint64 - order, MsgID: common.Bytes2Hash(row.V)})
+	}
+	return entries, nil
+}
+
+// GetFeedCursor return the order of msg to be added into the feed of
+// space-time next, which is the count of msgs materialized
+func GetFeedCursor(udb UDB, spaceTimeID common.Hash) (uint64, error) {
+	cursor, err := udb.Get(BucketConfig, ConfigFeedCursor+common.Hash2String(spaceTimeID))
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(cursor).Uint64(), nil
+}
+
+// SaveFeedCursor save the order of msg to be added into the feed next
+func SaveFeedCursor(udb UDB, spaceTimeID common.Hash, cursor uint64) error {
+	return udb.Set(BucketConfig, ConfigFeedCursor+common.Hash2String(spaceTimeID), new(big.Int).SetUint64(cursor).Bytes())
+}
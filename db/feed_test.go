@@ -0,0 +1,71 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestFeed(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketFeed); err != nil {
+		t.Fatal(err)
+	}
+	st, other := common.Bytes2Hash([]byte("st")), common.Bytes2Hash([]byte("other"))
+	for _, e := range []*db.FeedEntry{
+		{Seq: 1, Order: 0, MsgID: common.Bytes2Hash([]byte("a"))},
+		{Seq: 3, Order: 1, MsgID: common.Bytes2Hash([]byte("b"))},
+		{Seq: 2, Order: 2, MsgID: common.Bytes2Hash([]byte("c"))},
+		{Seq: 3, Order: 3, MsgID: common.Bytes2Hash([]byte("d"))},
+	} {
+		if err := db.SaveFeedEntry(udb, st, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveFeedEntry(udb, other, &db.FeedEntry{Seq: 9, MsgID: common.Bytes2Hash([]byte("e"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.GetFeed(udb, st, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids string
+	for _, e := range entries {
+		ids += string(e.MsgID[len(e.MsgID)-1])
+	}
+	if ids != "dbca" || entries[0].Seq != 3 || entries[0].Order != 3 {
+		t.Error("feed should be ordered from new to old", ids)
+	}
+	if entries, err := db.GetFeed(udb, st, 1, 2); err != nil || len(entries) != 2 || entries[0].Order != 1 {
+		t.Error("feed page fail", err, entries)
+	}
+
+	if cursor, err := db.GetFeedCursor(udb, st); err != nil || cursor != 0 {
+		t.Error("cursor should be 0 before saved", cursor, err)
+	}
+	if err := db.SaveFeedCursor(udb, st, 4); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err := db.GetFeedCursor(udb, st); err != nil || cursor != 4 {
+		t.Error("cursor should be saved", cursor, err)
+	}
+}
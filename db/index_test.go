@@ -0,0 +1,165 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+// typeCustom is the content type defined by app, indexed as built-in types
+const typeCustom = 200
+
+func TestMsgIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	boltDB, err := bolt.NewDB(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltDB.Close()
+	for name, udb := range map[string]db.UDB{"memdb": memdb.New(), "bolt": boltDB} {
+		testMsgIndex(t, name, udb)
+	}
+}
+
+func testMsgIndex(t *testing.T, name string, udb db.UDB) {
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketMsg, db.BucketMID, db.BucketMOD, db.BucketLastMID,
+		db.BucketSenderMID, db.BucketTypeMID, db.BucketContent, db.BucketContentRef); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	var users [2]*core.User
+	var keys [2]*crypto.PrivateKey
+	for i := range users {
+		priKey, pubKey, err := ethereum.New().GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[i], keys[i] = core.CreateRootUser(*pubKey, "name", "extra"), priKey
+	}
+
+	// msgs of user 0 are text, custom, text; msgs of user 1 are text, custom
+	var msgs []*core.Message
+	for i, c := range []struct{ sender, contentType int }{{0, core.TypeText}, {1, core.TypeText}, {0, typeCustom}, {1, typeCustom}, {0, core.TypeText}} {
+		var refs []*core.MsgReference
+		if len(msgs) > 0 {
+			last := msgs[len(msgs)-1]
+			refs = append(refs, &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()})
+		}
+		value := &core.MsgValue{ContentType: c.contentType, Content: []byte{byte(i)}}
+		msg, err := core.CreateMsg(users[c.sender], value, keys[c.sender], refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	check := func(stage string) {
+		stage = name + " " + stage
+		for _, c := range []struct {
+			name        string
+			get         func(skip, limit int) ([]*core.Message, error)
+			skip, limit int
+			expect      []*core.Message
+		}{
+			{"sender 0", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsBySender(udb, users[0].ID(), skip, limit)
+			}, 0, 10, []*core.Message{msgs[0], msgs[2], msgs[4]}},
+			{"sender 1", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsBySender(udb, users[1].ID(), skip, limit)
+			}, 0, 10, []*core.Message{msgs[1], msgs[3]}},
+			{"sender 0 paged", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsBySender(udb, users[0].ID(), skip, limit)
+			}, 1, 1, []*core.Message{msgs[2]}},
+			{"sender unknown", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsBySender(udb, common.CreateHash(), skip, limit)
+			}, 0, 10, nil},
+			{"type text", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsByType(udb, core.TypeText, skip, limit)
+			}, 0, 10, []*core.Message{msgs[0], msgs[1], msgs[4]}},
+			{"type custom", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsByType(udb, typeCustom, skip, limit)
+			}, 0, 10, []*core.Message{msgs[2], msgs[3]}},
+			{"type custom paged", func(skip, limit int) ([]*core.Message, error) {
+				return db.GetMsgsByType(udb, typeCustom, skip, limit)
+			}, 1, 5, []*core.Message{msgs[3]}},
+		} {
+			res, err := c.get(c.skip, c.limit)
+			if err != nil {
+				t.Fatal(stage, c.name, err)
+			}
+			if len(res) != len(c.expect) {
+				t.Errorf("%s %s: should get %d msgs, but %d", stage, c.name, len(c.expect), len(res))
+				continue
+			}
+			for i := range res {
+				if res[i].ID() != c.expect[i].ID() {
+					t.Errorf("%s %s: msg %d not match", stage, c.name, i)
+				}
+			}
+		}
+	}
+
+	// indexes are written with each msg saved
+	for _, bucketName := range []string{db.BucketSenderMID, db.BucketTypeMID} {
+		if rows, err := udb.Find(bucketName, "", 100); err != nil || len(rows) != len(msgs) {
+			t.Errorf("%s %s should have %d rows, but %d %v", name, bucketName, len(msgs), len(rows), err)
+		}
+	}
+	check("saved")
+	if err := db.RebuildMsgIndex(udb); err != nil {
+		t.Fatal(name, err)
+	}
+	check("rebuilt when exist")
+
+	// indexes wiped, as msgs saved before the index buckets exist
+	for _, bucketName := range []string{db.BucketSenderMID, db.BucketTypeMID} {
+		if err := udb.DeleteBucket(bucketName); err != nil {
+			t.Fatal(err)
+		}
+		if err := udb.CreateBucket(bucketName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res, err := db.GetMsgsBySender(udb, users[0].ID(), 0, 10); err != nil || len(res) != 0 {
+		t.Error(name, "no msg should be found before rebuilt", len(res), err)
+	}
+	if err := db.RebuildMsgIndex(udb); err != nil {
+		t.Fatal(name, err)
+	}
+	check("rebuilt")
+}
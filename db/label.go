@@ -0,0 +1,127 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// Labels set by local user on msgs and users, kept in local db only and
+// never gossiped to peers
+const (
+	// LabelSpam is the msg marked as spam, learned by spam filter
+	LabelSpam = "spam"
+	// LabelFavorite is the msg kept by local user
+	LabelFavorite = "favorite"
+	// LabelHidden is the msg local user does not want to see
+	LabelHidden = "hidden"
+	// LabelTrusted is the user whose msgs are always accepted
+	LabelTrusted = "trusted"
+	// LabelBlocked is the user whose msgs are always rejected
+	LabelBlocked = "blocked"
+)
+
+// ErrLabelNotValid returns if the label is not one of known labels
+var ErrLabelNotValid = errors.New("label not valid")
+
+// Label is the annotation of msg or user set by local user
+type Label struct {
+	Target  common.Hash `json:"target"`
+	Label   string      `json:"label"`
+	Created int64       `json:"created"`
+}
+
+// IsMsgLabel return true if the label is set on msgs
+func IsMsgLabel(label string) bool {
+	return label == LabelSpam || label == LabelFavorite || label == LabelHidden
+}
+
+// IsUserLabel return true if the label is set on users
+func IsUserLabel(label string) bool {
+	return label == LabelTrusted || label == LabelBlocked
+}
+
+// labelKey build the key of label, keep the labels of same target together
+func labelKey(target common.Hash, label string) string {
+	return common.Hash2String(target) + label
+}
+
+// SaveLabel save the label of msg or user, the label saved before is
+// replaced
+func SaveLabel(udb UDB, l *Label) error {
+	if !IsMsgLabel(l.Label) && !IsUserLabel(l.Label) {
+		return ErrLabelNotValid
+	}
+	lBytes, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return udb.Set(BucketLabel, labelKey(l.Target, l.Label), lBytes)
+}
+
+// DeleteLabel remove the label of msg or user, nothing changed if not set
+func DeleteLabel(udb UDB, target common.Hash, label string) error {
+	return udb.Del(BucketLabel, labelKey(target, label))
+}
+
+// GetLabels return the labels of msg or user
+func GetLabels(udb UDB, target common.Hash) ([]*Label, error) {
+	labels := []*Label{}
+	err := walkLabels(udb, common.Hash2String(target), func(l *Label) error {
+		labels = append(labels, l)
+		return nil
+	})
+	return labels, err
+}
+
+// GetAllLabels return the labels of all msgs and users, order by target
+func GetAllLabels(udb UDB) ([]*Label, error) {
+	labels := []*Label{}
+	err := walkLabels(udb, "", func(l *Label) error {
+		labels = append(labels, l)
+		return nil
+	})
+	return labels, err
+}
+
+// labelPage is the number of labels read from db at once
+const labelPage = 1024
+
+// walkLabels call fn with the labels which key has the prefix
+func walkLabels(udb UDB, prefix string, fn func(l *Label) error) error {
+	for skip := 0; ; skip += labelPage {
+		rows, err := udb.Find(BucketLabel, prefix, skip, labelPage)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var l Label
+			if err := json.Unmarshal(row.V, &l); err != nil {
+				return err
+			}
+			if err := fn(&l); err != nil {
+				return err
+			}
+		}
+		if len(rows) < labelPage {
+			return nil
+		}
+	}
+}
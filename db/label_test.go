@@ -0,0 +1,70 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestLabel(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketLabel); err != nil {
+		t.Fatal(err)
+	}
+	msgID, userID := common.Bytes2Hash([]byte("msg")), common.Bytes2Hash([]byte("user"))
+	for _, l := range []*db.Label{
+		{Target: msgID, Label: db.LabelSpam, Created: 1},
+		{Target: msgID, Label: db.LabelHidden, Created: 2},
+		{Target: userID, Label: db.LabelBlocked, Created: 3},
+	} {
+		if err := db.SaveLabel(udb, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveLabel(udb, &db.Label{Target: msgID, Label: "important"}); err != db.ErrLabelNotValid {
+		t.Error("unknown label should not be saved", err)
+	}
+
+	labels, err := db.GetLabels(udb, msgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels[0].Label != db.LabelHidden || labels[1].Label != db.LabelSpam || labels[1].Created != 1 {
+		t.Errorf("labels of msg not match %+v", labels)
+	}
+	if labels, err := db.GetAllLabels(udb); err != nil || len(labels) != 3 {
+		t.Error("all labels should be returned", labels, err)
+	}
+
+	if err := db.DeleteLabel(udb, msgID, db.LabelSpam); err != nil {
+		t.Fatal(err)
+	}
+	if labels, err := db.GetLabels(udb, msgID); err != nil || len(labels) != 1 || labels[0].Label != db.LabelHidden {
+		t.Error("label should be deleted", labels, err)
+	}
+	if labels, err := db.GetLabels(udb, userID); err != nil || len(labels) != 1 || labels[0].Label != db.LabelBlocked {
+		t.Error("labels of user should be kept", labels, err)
+	}
+
+	if !db.IsMsgLabel(db.LabelFavorite) || db.IsMsgLabel(db.LabelTrusted) || !db.IsUserLabel(db.LabelTrusted) || db.IsUserLabel(db.LabelSpam) {
+		t.Error("kind of label not match")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+var (
+	errFindMissingLimit         = errors.New("find operate missing limit")
+	errFindArgsNumberNotCorrect = errors.New("find operate number not correct")
+	errBucketNotExist           = errors.New("bucket not exist")
+	errBucketExist              = errors.New("bucket already exist")
+)
+
+// MemDB is the db struct in memory
+type MemDB struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// New create the empty db in memory
+func New() *MemDB {
+	return &MemDB{buckets: make(map[string]map[string][]byte)}
+}
+
+// Close the db, all data is dropped
+func (m *MemDB) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets = make(map[string]map[string][]byte)
+	return nil
+}
+
+// CreateBucket create new bucket by name
+func (m *MemDB) CreateBucket(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[bucketName]; ok {
+		return errBucketExist
+	}
+	m.buckets[bucketName] = make(map[string][]byte)
+	return nil
+}
+
+// DeleteBucket delete the bucket by name
+func (m *MemDB) DeleteBucket(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[bucketName]; !ok {
+		return errBucketNotExist
+	}
+	delete(m.buckets, bucketName)
+	return nil
+}
+
+// Set key/val into bucket
+func (m *MemDB) Set(bucketName, key string, val []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return errBucketNotExist
+	}
+	b[key] = append([]byte{}, val...)
+	return nil
+}
+
+// Get val by key from bucket, nil if key not exist
+func (m *MemDB) Get(bucketName, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, errBucketNotExist
+	}
+	val, ok := b[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, val...), nil
+}
+
+// Del val by key from bucket
+func (m *MemDB) Del(bucketName, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return errBucketNotExist
+	}
+	delete(b, key)
+	return nil
+}
+
+// Find the rows from bucket by prefix in order of key, same as bolt
+func (m *MemDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row, err error) {
+	var skip, limit int
+	if len(args) == 0 {
+		return rows, errFindMissingLimit
+	} else if len(args) == 1 {
+		skip = 0
+		limit = args[0]
+	} else if len(args) == 2 {
+		skip = args[0]
+		limit = args[1]
+	} else {
+		return rows, errFindArgsNumberNotCorrect
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return rows, errBucketNotExist
+	}
+	var keys []string
+	for k := range b {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i >= skip+limit {
+			break
+		}
+		if i >= skip {
+			rows = append(rows, &db.Row{K: k, V: append([]byte{}, b[k]...)})
+		}
+	}
+	return rows, nil
+}
+
+// Update run fn on the copy of db, the copy replace db only if fn succeed.
+// Other goroutines wait until fn returned, and fn should only use tx.
+func (m *MemDB) Update(fn func(tx db.UDB) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := &MemDB{buckets: make(map[string]map[string][]byte, len(m.buckets))}
+	for name, b := range m.buckets {
+		nb := make(map[string][]byte, len(b))
+		for k, v := range b {
+			nb[k] = v
+		}
+		tx.buckets[name] = nb
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.buckets = tx.buckets
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+func TestMemDB(t *testing.T) {
+	bucketName := "testBucket"
+	keyPrefix := "key"
+	valPrefix := []byte("val")
+
+	u := New()
+	if err := u.CreateBucket(bucketName); err != nil {
+		t.Error(err)
+	}
+	if err := u.CreateBucket(bucketName); err == nil {
+		t.Error("bucket already exist")
+	}
+	for i := int64(0); i < 10; i++ {
+		if err := u.Set(bucketName,
+			fmt.Sprintf("%s%d", keyPrefix, i),
+			append(valPrefix, big.NewInt(i).Bytes()...)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	val, err := u.Get(bucketName, fmt.Sprintf("%s%d", keyPrefix, 5))
+	if err != nil {
+		t.Error(err)
+	}
+	if string(val) != fmt.Sprintf("%s%s", valPrefix, big.NewInt(5).Bytes()) {
+		t.Error("val not equal")
+	}
+	if val, err := u.Get(bucketName, "notExist"); err != nil || val != nil {
+		t.Error("val should be nil")
+	}
+
+	rows, err := u.Find(bucketName, keyPrefix, 2, 3)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(rows) != 3 || rows[0].K != keyPrefix+"2" {
+		t.Error("result not match")
+	}
+
+	if err := u.Del(bucketName, rows[0].K); err != nil {
+		t.Error(err)
+	}
+	if rows, _ := u.Find(bucketName, keyPrefix, 100); len(rows) != 9 {
+		t.Error("result number not match")
+	}
+
+	if err := u.DeleteBucket(bucketName); err != nil {
+		t.Error(err)
+	}
+	if _, err := u.Get(bucketName, keyPrefix); err != errBucketNotExist {
+		t.Error("bucket should not exist")
+	}
+	if err := u.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemDB_Update(t *testing.T) {
+	bucketName := "testBucket"
+	errFail := errors.New("fail")
+	u := New()
+	if err := u.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Set(bucketName, "a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Update(func(tx db.UDB) error {
+		if err := tx.Del(bucketName, "a"); err != nil {
+			return err
+		}
+		if err := tx.Set(bucketName, "b", []byte("b")); err != nil {
+			return err
+		}
+		return errFail
+	}); err != errFail {
+		t.Errorf("err should be %s, but get %v", errFail, err)
+	}
+	if val, _ := u.Get(bucketName, "a"); string(val) != "a" {
+		t.Error("val should not be deleted by failed update")
+	}
+	if val, _ := u.Get(bucketName, "b"); val != nil {
+		t.Error("val should not be set by failed update")
+	}
+	if err := u.Update(func(tx db.UDB) error {
+		if err := tx.Del(bucketName, "a"); err != nil {
+			return err
+		}
+		return tx.Set(bucketName, "b", []byte("b"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := u.Find(bucketName, "", 10); len(rows) != 1 || rows[0].K != "b" {
+		t.Error("update should be applied")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	// ErrSchemaTooNew returns when the db is written by newer release
+	ErrSchemaTooNew = errors.New("db schema is newer than this release")
+
+	// ErrMigrationBackupMissing returns when rollback without any backup made by migration
+	ErrMigrationBackupMissing = errors.New("migration backup missing")
+)
+
+// Migration upgrade the on-disk layout from Version-1 to Version, such as
+// re-keying indexes or re-encoding msgs. Up should be safe to run again if
+// it is interrupted, the version is only saved after Up returns.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      func(UDB) error
+}
+
+// migrations are all layout changes in order, new release append to it
+var migrations = []*Migration{
+	{Version: 1, Name: "create checkpoint and msg index buckets", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketCheckpoint, BucketSenderMID, BucketTypeMID)
+	}},
+	{Version: 2, Name: "index msgs by sender and content type", Up: RebuildMsgIndex},
+	{Version: 3, Name: "store msg contents once by hash", Up: func(udb UDB) error {
+		if err := CreateMissingBuckets(udb, BucketContent, BucketContentRef); err != nil {
+			return err
+		}
+		return DedupContents(udb)
+	}},
+	{Version: 4, Name: "create notification bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketNotify)
+	}},
+	{Version: 5, Name: "create audit bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketAudit)
+	}},
+	{Version: 6, Name: "create outbox buckets", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketOutbox, BucketOutboxEvent)
+	}},
+	{Version: 7, Name: "create delivery bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketDelivery)
+	}},
+	{Version: 8, Name: "create archive bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketArchive)
+	}},
+	{Version: 9, Name: "create feed bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketFeed)
+	}},
+	{Version: 10, Name: "create anchor bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketAnchor)
+	}},
+	{Version: 11, Name: "create label bucket", Up: func(udb UDB) error {
+		return CreateMissingBuckets(udb, BucketLabel)
+	}},
+}
+
+// SchemaVersion return the on-disk layout version of this release
+func SchemaVersion() uint64 {
+	return migrations[len(migrations)-1].Version
+}
+
+// GetSchemaVersion return the layout version of db, 0 if not be saved before
+func GetSchemaVersion(udb UDB) (uint64, error) {
+	version, err := udb.Get(BucketConfig, ConfigSchemaVersion)
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(version).Uint64(), nil
+}
+
+// SaveSchemaVersion save the layout version of db, new db should be saved
+// with SchemaVersion, so no migration run on it
+func SaveSchemaVersion(udb UDB, version uint64) error {
+	return udb.Set(BucketConfig, ConfigSchemaVersion, new(big.Int).SetUint64(version).Bytes())
+}
+
+// PendingMigrations return the migrations not be applied on db
+func PendingMigrations(udb UDB) ([]*Migration, error) {
+	version, err := GetSchemaVersion(udb)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion() {
+		return nil, ErrSchemaTooNew
+	}
+	var pending []*Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate apply the pending migrations on db in place, the version is saved
+// after each migration, so the failed one is run again next time.
+func Migrate(udb UDB) ([]*Migration, error) {
+	return MigrateContext(context.Background(), udb)
+}
+
+// MigrateContext is same as Migrate, but stop before the next migration
+// once ctx done, the migrations applied are returned with ctx.Err().
+func MigrateContext(ctx context.Context, udb UDB) ([]*Migration, error) {
+	pending, err := PendingMigrations(udb)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	for i, m := range pending {
+		if err := ctx.Err(); err != nil {
+			return pending[:i], err
+		}
+		if err := m.Up(udb); err != nil {
+			return pending[:i], err
+		}
+		if err := SaveSchemaVersion(udb, m.Version); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// MigrationBackupFile return the copy of db file made before migrating from version
+func MigrationBackupFile(path string, version uint64) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// MigrateFile apply the pending migrations on the db file. The file is copied
+// (see MigrationBackupFile) before any change, and restored if migration fail.
+// With dryRun, migrations run on a temp copy and the db file is not changed.
+// The version of db before migrate and the applied migrations are returned.
+func MigrateFile(path string, open func(string) (UDB, error), dryRun bool) (uint64, []*Migration, error) {
+	udb, err := open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	version, err := GetSchemaVersion(udb)
+	if err != nil {
+		udb.Close()
+		return 0, nil, err
+	}
+	pending, err := PendingMigrations(udb)
+	if err := udb.Close(); err != nil {
+		return version, nil, err
+	}
+	if err != nil || len(pending) == 0 {
+		return version, nil, err
+	}
+
+	target := path
+	backupFile := MigrationBackupFile(path, version)
+	if dryRun {
+		target = path + ".dryrun"
+		defer os.Remove(target)
+		if err := copyFile(path, target); err != nil {
+			return version, nil, err
+		}
+	} else if err := copyFile(path, backupFile); err != nil {
+		return version, nil, err
+	}
+
+	udb, err = open(target)
+	if err != nil {
+		return version, nil, err
+	}
+	applied, err := Migrate(udb)
+	if closeErr := udb.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && !dryRun {
+		if rbErr := copyFile(backupFile, path); rbErr != nil {
+			return version, applied, rbErr
+		}
+	}
+	return version, applied, err
+}
+
+// RollbackFile restore the db file from the latest backup made by
+// MigrateFile, the version of restored db is returned
+func RollbackFile(path string) (uint64, error) {
+	matches, err := filepath.Glob(path + ".v*.bak")
+	if err != nil {
+		return 0, err
+	}
+	var versions []uint64
+	for _, match := range matches {
+		var version uint64
+		if _, err := fmt.Sscanf(match[len(path):], ".v%d.bak", &version); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return 0, ErrMigrationBackupMissing
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if err := copyFile(MigrationBackupFile(path, versions[0]), path); err != nil {
+		return 0, err
+	}
+	return versions[0], nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
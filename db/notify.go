@@ -0,0 +1,144 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// Notification is the msg concerned local user, such as reference of the
+// msg from user, saved in the queue of user until be read
+type Notification struct {
+	ID       uint64      `json:"id"`
+	UserID   common.Hash `json:"userID"`
+	Kind     string      `json:"kind"`
+	MsgID    common.Hash `json:"msgID"`
+	SenderID common.Hash `json:"senderID"`
+	Created  int64       `json:"created"`
+	Read     bool        `json:"read"`
+}
+
+// notifyKey build the key of notification, keep the notifications of same
+// user be sorted by id.
+func notifyKey(userID common.Hash, id uint64) string {
+	return common.Hash2String(userID) + orderKey(id)
+}
+
+// AddNotification save the notification into the queue of user, the id is
+// assigned in order of added
+func AddNotification(udb UDB, n *Notification) error {
+	lastID, err := udb.Get(BucketConfig, ConfigNotifyID)
+	if err != nil {
+		return err
+	}
+	n.ID = new(big.Int).SetBytes(lastID).Uint64() + 1
+	nBytes, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if err := udb.Set(BucketNotify, notifyKey(n.UserID, n.ID), nBytes); err != nil {
+		return err
+	}
+	return udb.Set(BucketConfig, ConfigNotifyID, new(big.Int).SetUint64(n.ID).Bytes())
+}
+
+// notifyPage is the number of notifications read from db at once
+const notifyPage = 1024
+
+// walkNotifications call fn with the notifications of user order by id,
+// until fn return false or error
+func walkNotifications(udb UDB, userID common.Hash, fn func(key string, n *Notification) (bool, error)) error {
+	for skip := 0; ; skip += notifyPage {
+		rows, err := udb.Find(BucketNotify, common.Hash2String(userID), skip, notifyPage)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var n Notification
+			if err := json.Unmarshal(row.V, &n); err != nil {
+				return err
+			}
+			if next, err := fn(row.K, &n); err != nil || !next {
+				return err
+			}
+		}
+		if len(rows) < notifyPage {
+			return nil
+		}
+	}
+}
+
+// GetNotifications return at most limit notifications of user order by id,
+// the read ones are skipped if unreadOnly
+func GetNotifications(udb UDB, userID common.Hash, unreadOnly bool, limit int) ([]*Notification, error) {
+	notifications := []*Notification{}
+	err := walkNotifications(udb, userID, func(key string, n *Notification) (bool, error) {
+		if len(notifications) >= limit {
+			return false, nil
+		}
+		if !unreadOnly || !n.Read {
+			notifications = append(notifications, n)
+		}
+		return true, nil
+	})
+	return notifications, err
+}
+
+// MarkNotificationsRead mark the notifications of user as read, all unread
+// notifications of user are marked if ids is empty. The number of
+// notifications changed is returned.
+func MarkNotificationsRead(udb UDB, userID common.Hash, ids ...uint64) (int, error) {
+	// notifications are collected before updated, db may not allow write while reading
+	var keys []string
+	var unread []*Notification
+	if len(ids) == 0 {
+		if err := walkNotifications(udb, userID, func(key string, n *Notification) (bool, error) {
+			if !n.Read {
+				keys, unread = append(keys, key), append(unread, n)
+			}
+			return true, nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	for _, id := range ids {
+		key := notifyKey(userID, id)
+		nBytes, err := udb.Get(BucketNotify, key)
+		if err != nil {
+			return 0, err
+		}
+		var n Notification
+		if nBytes == nil || json.Unmarshal(nBytes, &n) != nil || n.Read {
+			continue
+		}
+		keys, unread = append(keys, key), append(unread, &n)
+	}
+	for i, n := range unread {
+		n.Read = true
+		nBytes, err := json.Marshal(n)
+		if err != nil {
+			return i, err
+		}
+		if err := udb.Set(BucketNotify, keys[i], nBytes); err != nil {
+			return i, err
+		}
+	}
+	return len(unread), nil
+}
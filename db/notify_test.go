@@ -0,0 +1,61 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestNotifications(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketNotify); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := common.Bytes2Hash([]byte("alice")), common.Bytes2Hash([]byte("bob"))
+	for i, userID := range []common.Hash{alice, bob, alice, alice} {
+		n := &db.Notification{UserID: userID, Kind: "reference", MsgID: common.Bytes2Hash([]byte{byte(i)})}
+		if err := db.AddNotification(udb, n); err != nil {
+			t.Fatal(err)
+		}
+		if n.ID != uint64(i+1) {
+			t.Errorf("id should be %d, but get %d", i+1, n.ID)
+		}
+	}
+	ns, err := db.GetNotifications(udb, alice, false, 2)
+	if err != nil || len(ns) != 2 || ns[0].ID != 1 || ns[1].ID != 3 {
+		t.Fatal("notifications not match", err)
+	}
+	if count, err := db.MarkNotificationsRead(udb, alice, 1, 2); err != nil || count != 1 {
+		t.Error("only notification of user should be marked", count, err)
+	}
+	if ns, _ := db.GetNotifications(udb, alice, true, 10); len(ns) != 2 || ns[0].ID != 3 {
+		t.Error("read notification should be skipped")
+	}
+	if count, err := db.MarkNotificationsRead(udb, alice); err != nil || count != 2 {
+		t.Error("all unread notifications should be marked", count, err)
+	}
+	if ns, _ := db.GetNotifications(udb, alice, true, 10); len(ns) != 0 {
+		t.Error("all notifications should be read")
+	}
+	if ns, _ := db.GetNotifications(udb, bob, true, 10); len(ns) != 1 || ns[0].Read {
+		t.Error("notifications of other user should not be changed")
+	}
+}
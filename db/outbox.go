@@ -0,0 +1,153 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// Status of outbox item
+const (
+	// OutboxQueued is the item waiting to be submitted, or retried after fail
+	OutboxQueued = "queued"
+	// OutboxSent is the item submitted into local universe and broadcast
+	OutboxSent = "sent"
+	// OutboxFailed is the item given up after too many attempts
+	OutboxFailed = "failed"
+)
+
+// OutboxItem is the msg value drafted by local user, it is queued until node
+// connected with peers, then signed with fresh references and submitted.
+type OutboxItem struct {
+	ID       uint64         `json:"id"`
+	UserID   common.Hash    `json:"userID"`
+	Value    *core.MsgValue `json:"value"`
+	Status   string         `json:"status"`
+	Attempts int            `json:"attempts"`
+	MsgID    common.Hash    `json:"msgID"`
+	Error    string         `json:"error,omitempty"`
+	Created  int64          `json:"created"`
+	Updated  int64          `json:"updated"`
+}
+
+// OutboxEvent is the status transition of outbox item, the events are
+// append only, so clients can follow them from the id seen last time.
+type OutboxEvent struct {
+	ID     uint64      `json:"id"`
+	ItemID uint64      `json:"itemID"`
+	Status string      `json:"status"`
+	MsgID  common.Hash `json:"msgID"`
+	Error  string      `json:"error,omitempty"`
+	Time   int64       `json:"time"`
+}
+
+// nextID return the id after the last one saved in config key
+func nextID(udb UDB, key string) (uint64, error) {
+	lastID, err := udb.Get(BucketConfig, key)
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(lastID).Uint64() + 1, nil
+}
+
+// SaveOutboxItem save the item and append the event of its status, the id
+// is assigned in order of added if item is new
+func SaveOutboxItem(udb UDB, item *OutboxItem) error {
+	if item.ID == 0 {
+		id, err := nextID(udb, ConfigOutboxID)
+		if err != nil {
+			return err
+		}
+		item.ID = id
+		if err := udb.Set(BucketConfig, ConfigOutboxID, new(big.Int).SetUint64(id).Bytes()); err != nil {
+			return err
+		}
+	}
+	itemBytes, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := udb.Set(BucketOutbox, orderKey(item.ID), itemBytes); err != nil {
+		return err
+	}
+
+	e := &OutboxEvent{ItemID: item.ID, Status: item.Status, MsgID: item.MsgID, Error: item.Error, Time: item.Updated}
+	if e.ID, err = nextID(udb, ConfigOutboxEventID); err != nil {
+		return err
+	}
+	eBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := udb.Set(BucketOutboxEvent, orderKey(e.ID), eBytes); err != nil {
+		return err
+	}
+	return udb.Set(BucketConfig, ConfigOutboxEventID, new(big.Int).SetUint64(e.ID).Bytes())
+}
+
+// outboxPage is the number of outbox items or events read from db at once
+const outboxPage = 1024
+
+// GetOutboxItems return the items in order of added, the items of other
+// status are skipped if status is not empty
+func GetOutboxItems(udb UDB, status string) ([]*OutboxItem, error) {
+	items := []*OutboxItem{}
+	for skip := 0; ; skip += outboxPage {
+		rows, err := udb.Find(BucketOutbox, "", skip, outboxPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			var item OutboxItem
+			if err := json.Unmarshal(row.V, &item); err != nil {
+				return nil, err
+			}
+			if status == "" || item.Status == status {
+				items = append(items, &item)
+			}
+		}
+		if len(rows) < outboxPage {
+			return items, nil
+		}
+	}
+}
+
+// GetOutboxEvents return at most limit events from id in order. The ids
+// start from 1 and have no gap, so events before from are skipped by count.
+func GetOutboxEvents(udb UDB, from uint64, limit int) ([]*OutboxEvent, error) {
+	skip := 0
+	if from > 1 {
+		skip = int(from - 1)
+	}
+	rows, err := udb.Find(BucketOutboxEvent, "", skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	events := []*OutboxEvent{}
+	for _, row := range rows {
+		var e OutboxEvent
+		if err := json.Unmarshal(row.V, &e); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
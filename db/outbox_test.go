@@ -0,0 +1,78 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+func TestOutbox(t *testing.T) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketOutbox, db.BucketOutboxEvent); err != nil {
+		t.Fatal(err)
+	}
+	var items []*db.OutboxItem
+	for i := 0; i < 3; i++ {
+		item := &db.OutboxItem{Value: &core.MsgValue{ContentType: core.TypeText, Content: []byte("draft")}, Status: db.OutboxQueued}
+		if err := db.SaveOutboxItem(udb, item); err != nil {
+			t.Fatal(err)
+		}
+		if item.ID != uint64(i+1) {
+			t.Errorf("id should be %d, but get %d", i+1, item.ID)
+		}
+		items = append(items, item)
+	}
+
+	items[1].Status, items[1].MsgID = db.OutboxSent, common.Bytes2Hash([]byte{1})
+	if err := db.SaveOutboxItem(udb, items[1]); err != nil {
+		t.Fatal(err)
+	}
+	items[2].Attempts, items[2].Error = 1, "not connected"
+	if err := db.SaveOutboxItem(udb, items[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := db.GetOutboxItems(udb, db.OutboxQueued)
+	if err != nil || len(queued) != 2 || queued[0].ID != 1 || queued[1].ID != 3 {
+		t.Fatal("queued items not match", err)
+	}
+	if queued[1].Attempts != 1 || string(queued[1].Value.Content) != "draft" {
+		t.Error("item should be updated", queued[1])
+	}
+	if all, _ := db.GetOutboxItems(udb, ""); len(all) != 3 {
+		t.Error("all items should be returned", len(all))
+	}
+
+	events, err := db.GetOutboxEvents(udb, 4, 10)
+	if err != nil || len(events) != 2 {
+		t.Fatal("events not match", err, len(events))
+	}
+	if events[0].ID != 4 || events[0].ItemID != 2 || events[0].Status != db.OutboxSent || events[0].MsgID != items[1].MsgID {
+		t.Error("event of sent not match", events[0])
+	}
+	if events[1].ItemID != 3 || events[1].Status != db.OutboxQueued || events[1].Error != "not connected" {
+		t.Error("event of retry not match", events[1])
+	}
+	if events, _ := db.GetOutboxEvents(udb, 1, 2); len(events) != 2 || events[1].ID != 2 {
+		t.Error("events should be limited")
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package sqlite implements the sqlite mirror of universe, the accepted msgs
+// and users are written into sqlite (see Schema) for ad-hoc query.
+//
+// The sqlite driver needs cgo, so the mirror is only built with the sqlite
+// tag, such as make build_tags=sqlite, NewMirror return ErrNotSupported
+// otherwise.
+package sqlite
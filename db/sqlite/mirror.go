@@ -0,0 +1,142 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build sqlite
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+
+	// register sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Mirror write the accepted msgs and users into sqlite, so they can be
+// queried by sql. Mirror is write only from node, rows are never updated.
+type Mirror struct {
+	db *sql.DB
+}
+
+// NewMirror open or create the sqlite db in path, and create the tables
+func NewMirror(path string) (*Mirror, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(Schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Mirror{db: db}, nil
+}
+
+// DB return the sql.DB of mirror, used for query
+func (m *Mirror) DB() *sql.DB {
+	return m.db
+}
+
+// Close the sqlite db
+func (m *Mirror) Close() error {
+	return m.db.Close()
+}
+
+// SaveUser write user into mirror, nothing happen if user already exist
+func (m *Mirror) SaveUser(user *core.User) error {
+	return saveUser(m.db, user)
+}
+
+// SaveMsg write the msg accepted by universe with its references and
+// sequences into mirror, the sender and the user created by birth msg
+// are also written.
+func (m *Mirror) SaveMsg(u *core.Universe, msg *core.Message, order uint64) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := saveMsg(tx, u, msg, order); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func saveMsg(tx execer, u *core.Universe, msg *core.Message, order uint64) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	msgID := common.Hash2String(msg.ID())
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO messages (id, ord, sender_id, content_type, content, timestamp, raw) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		msgID, int64(order), common.Hash2String(msg.SenderID), msg.Value.ContentType, msg.Value.Content, int64(msg.Timestamp), string(raw)); err != nil {
+		return err
+	}
+	for _, ref := range msg.Reference {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO msg_references (msg_id, ref_sender_id, ref_msg_id) VALUES (?, ?, ?)`,
+			msgID, common.Hash2String(ref.SenderID), common.Hash2String(ref.MsgID)); err != nil {
+			return err
+		}
+	}
+	for _, stID := range u.GetSpaceTimeIDs() {
+		seq, err := u.GetSeq(msg.ID(), stID)
+		if err != nil {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO sequences (msg_id, spacetime_id, seq) VALUES (?, ?, ?)`,
+			msgID, common.Hash2String(stID), int64(seq)); err != nil {
+			return err
+		}
+	}
+	if sender := u.GetUserByID(msg.SenderID); sender != nil {
+		if err := saveUser(tx, sender); err != nil {
+			return err
+		}
+	}
+	if msg.Value.ContentType == core.TypeBirth {
+		if user, err := core.CreateNewUser(u, msg); err == nil && u.GetUserByID(user.ID()) != nil {
+			if err := saveUser(tx, user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func saveUser(tx execer, user *core.User) error {
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	var parent0, parent1, birthMsgID interface{}
+	if user.BirthMsg != nil {
+		parents := user.ParentsID()
+		parent0, parent1 = common.Hash2String(parents[0]), common.Hash2String(parents[1])
+		birthMsgID = common.Hash2String(user.BirthMsg.ID())
+	}
+	userID := user.ID()
+	_, err = tx.Exec(`INSERT OR IGNORE INTO users (id, address, name, extra, gender, life_time, parent0_id, parent1_id, birth_msg_id, raw) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		common.Hash2String(userID), common.EncodeAddress(userID), user.Name, user.BirthExtra, user.Gender(), int64(user.LifeTime), parent0, parent1, birthMsgID, string(raw))
+	return err
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !sqlite
+// +build !sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/pdupub/go-pdu/core"
+)
+
+// Mirror is the sqlite mirror, only available if built with the sqlite tag
+type Mirror struct{}
+
+// NewMirror always return ErrNotSupported without the sqlite tag
+func NewMirror(path string) (*Mirror, error) {
+	return nil, ErrNotSupported
+}
+
+// DB return nil without the sqlite tag
+func (m *Mirror) DB() *sql.DB {
+	return nil
+}
+
+// Close do nothing without the sqlite tag
+func (m *Mirror) Close() error {
+	return nil
+}
+
+// SaveUser always return ErrNotSupported without the sqlite tag
+func (m *Mirror) SaveUser(user *core.User) error {
+	return ErrNotSupported
+}
+
+// SaveMsg always return ErrNotSupported without the sqlite tag
+func (m *Mirror) SaveMsg(u *core.Universe, msg *core.Message, order uint64) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build sqlite
+// +build sqlite
+
+package sqlite
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+)
+
+func createRootUsers(t *testing.T) (*core.User, *core.User, *crypto.PrivateKey) {
+	engine := ethereum.New()
+	var male, female *core.User
+	var priKeyMale *crypto.PrivateKey
+	for male == nil || female == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := core.CreateRootUser(*pubKey, "name", "extra")
+		if user.Gender() && male == nil {
+			male, priKeyMale = user, priKey
+		} else if !user.Gender() && female == nil {
+			female = user
+		}
+	}
+	return male, female, priKeyMale
+}
+
+func TestMirror(t *testing.T) {
+	dir, _ := os.Getwd()
+	filePath := path.Join(dir, "mirror_test.db")
+	os.Remove(filePath)
+	defer os.Remove(filePath)
+
+	m, err := NewMirror(filePath)
+	if err != nil {
+		t.Fatal("create mirror fail", err)
+	}
+	defer m.Close()
+
+	male, female, priKey := createRootUsers(t)
+	u, err := core.NewUniverse(female, male)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveUser(female); err != nil {
+		t.Fatal("save user fail", err)
+	}
+
+	msg, _ := core.CreateMsg(male, &core.MsgValue{ContentType: core.TypeText, Content: []byte("hello")}, priKey)
+	if err := u.AddMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	ref := &core.MsgReference{SenderID: male.ID(), MsgID: msg.ID()}
+	msg2, _ := core.CreateMsg(male, &core.MsgValue{ContentType: core.TypeText, Content: []byte("world")}, priKey, ref)
+	if err := u.AddMsg(msg2); err != nil {
+		t.Fatal(err)
+	}
+	for i, item := range []*core.Message{msg, msg2, msg} {
+		if err := m.SaveMsg(u, item, uint64(i)); err != nil {
+			t.Fatal("save msg fail", err)
+		}
+	}
+
+	var cnt int
+	m.DB().QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&cnt)
+	if cnt != 2 {
+		t.Errorf("count of messages should be 2, but get %d", cnt)
+	}
+	m.DB().QueryRow(`SELECT COUNT(*) FROM users`).Scan(&cnt)
+	if cnt != 2 {
+		t.Errorf("count of users should be 2, but get %d", cnt)
+	}
+	var refMsgID string
+	m.DB().QueryRow(`SELECT ref_msg_id FROM msg_references WHERE msg_id = ?`, common.Hash2String(msg2.ID())).Scan(&refMsgID)
+	if refMsgID != common.Hash2String(msg.ID()) {
+		t.Error("reference of msg not match")
+	}
+	var seq int64
+	if err := m.DB().QueryRow(`SELECT seq FROM sequences WHERE msg_id = ? AND spacetime_id = ?`,
+		common.Hash2String(msg2.ID()), common.Hash2String(male.ID())).Scan(&seq); err != nil || seq != 2 {
+		t.Errorf("sequence should be 2, but get %d %v", seq, err)
+	}
+	var content string
+	m.DB().QueryRow(`SELECT content FROM messages m JOIN users u ON m.sender_id = u.id WHERE u.address = ? ORDER BY ord DESC`, common.EncodeAddress(male.ID())).Scan(&content)
+	if content != "world" {
+		t.Errorf("content should be world, but get %s", content)
+	}
+}
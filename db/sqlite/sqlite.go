@@ -0,0 +1,73 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package sqlite
+
+import "errors"
+
+// ErrNotSupported is returned by NewMirror if pdu is built without the sqlite
+// tag, the sqlite driver needs cgo, so it is not built by default
+var ErrNotSupported = errors.New("sqlite mirror not supported, build with -tags sqlite")
+
+// Schema is the tables of mirror. IDs are in hex (same as Hash2String),
+// raw is the json of msg or user, which can be queried by json functions.
+//
+//	messages       all msgs accepted, ord is the order in local node
+//	users          root users and users created by birth msgs
+//	msg_references the references of msgs, one row for each reference
+//	sequences      the sequence of msgs in each space-time when accepted
+const Schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id           TEXT PRIMARY KEY,
+	ord          INTEGER NOT NULL,
+	sender_id    TEXT NOT NULL,
+	content_type INTEGER NOT NULL,
+	content      BLOB,
+	timestamp    INTEGER NOT NULL DEFAULT 0,
+	raw          TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages (sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_type ON messages (content_type);
+
+CREATE TABLE IF NOT EXISTS users (
+	id           TEXT PRIMARY KEY,
+	address      TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	extra        TEXT NOT NULL,
+	gender       INTEGER NOT NULL,
+	life_time    INTEGER NOT NULL,
+	parent0_id   TEXT,
+	parent1_id   TEXT,
+	birth_msg_id TEXT,
+	raw          TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS msg_references (
+	msg_id        TEXT NOT NULL,
+	ref_sender_id TEXT NOT NULL,
+	ref_msg_id    TEXT NOT NULL,
+	PRIMARY KEY (msg_id, ref_msg_id)
+);
+CREATE INDEX IF NOT EXISTS idx_msg_references_ref ON msg_references (ref_msg_id);
+
+CREATE TABLE IF NOT EXISTS sequences (
+	msg_id       TEXT NOT NULL,
+	spacetime_id TEXT NOT NULL,
+	seq          INTEGER NOT NULL,
+	PRIMARY KEY (msg_id, spacetime_id)
+);
+CREATE INDEX IF NOT EXISTS idx_sequences_seq ON sequences (spacetime_id, seq);
+`
@@ -0,0 +1,146 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package tier
+
+import (
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+var (
+	errValueNotFound        = errors.New("value not found")
+	errObjectMissing        = errors.New("archived object missing in store")
+	errSnapshotNotSupported = errors.New("snapshot not supported by local db")
+)
+
+// ObjectStore keep the values moved out of local db by key
+type ObjectStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error) // nil if not exist
+	Delete(key string) error
+}
+
+// DB is the local db with values archived into object store, the values
+// archived are marked in db.BucketArchive and not returned by Find. Value
+// set again is kept in local db, and the object archived before is removed.
+type DB struct {
+	db.UDB
+	store ObjectStore
+}
+
+// New create the tiered db on local db, the archive bucket is created if
+// not exist
+func New(local db.UDB, store ObjectStore) (*DB, error) {
+	if err := db.CreateMissingBuckets(local, db.BucketArchive); err != nil {
+		return nil, err
+	}
+	return &DB{UDB: local, store: store}, nil
+}
+
+// objectKey is the key of value in object store and archive bucket
+func objectKey(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// archived return true if the value of key have been archived
+func (t *DB) archived(bucket, key string) (bool, error) {
+	if bucket == db.BucketArchive {
+		return false, nil
+	}
+	size, err := t.UDB.Get(db.BucketArchive, objectKey(bucket, key))
+	return size != nil, err
+}
+
+// Get the value from local db, or from object store if it is archived
+func (t *DB) Get(bucket, key string) ([]byte, error) {
+	val, err := t.UDB.Get(bucket, key)
+	if err != nil || val != nil {
+		return val, err
+	}
+	if archived, err := t.archived(bucket, key); err != nil || !archived {
+		return nil, err
+	}
+	val, err = t.store.Get(objectKey(bucket, key))
+	if err != nil {
+		return nil, err
+	} else if val == nil {
+		return nil, errObjectMissing
+	}
+	return val, nil
+}
+
+// Set the value into local db, the object archived before is removed
+func (t *DB) Set(bucket, key string, val []byte) error {
+	if err := t.UDB.Set(bucket, key, val); err != nil {
+		return err
+	}
+	return t.unarchive(bucket, key)
+}
+
+// Del the value from local db and object store
+func (t *DB) Del(bucket, key string) error {
+	if err := t.UDB.Del(bucket, key); err != nil {
+		return err
+	}
+	return t.unarchive(bucket, key)
+}
+
+// unarchive remove the object and its mark if the value is archived
+func (t *DB) unarchive(bucket, key string) error {
+	if archived, err := t.archived(bucket, key); err != nil || !archived {
+		return err
+	}
+	if err := t.store.Delete(objectKey(bucket, key)); err != nil {
+		return err
+	}
+	return t.UDB.Del(db.BucketArchive, objectKey(bucket, key))
+}
+
+// Archive move the value from local db into object store, the value is
+// marked before removed from local db, so it is never lost if interrupted.
+// Value archived already is skipped.
+func (t *DB) Archive(bucket, key string) error {
+	val, err := t.UDB.Get(bucket, key)
+	if err != nil {
+		return err
+	} else if val == nil {
+		if archived, err := t.archived(bucket, key); err != nil || archived {
+			return err
+		}
+		return errValueNotFound
+	}
+	if err := t.store.Put(objectKey(bucket, key), val); err != nil {
+		return err
+	}
+	size := new(big.Int).SetInt64(int64(len(val))).Bytes()
+	if err := t.UDB.Set(db.BucketArchive, objectKey(bucket, key), size); err != nil {
+		return err
+	}
+	return t.UDB.Del(bucket, key)
+}
+
+// Snapshot write the copy of local db, the values archived are not included
+func (t *DB) Snapshot(w io.Writer) (int64, error) {
+	snapshotter, ok := t.UDB.(db.Snapshotter)
+	if !ok {
+		return 0, errSnapshotNotSupported
+	}
+	return snapshotter.Snapshot(w)
+}
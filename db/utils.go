@@ -19,12 +19,17 @@ package db
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
+	"strconv"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/core"
 )
 
+// maxFindCount is the limit used to find all rows with prefix
+const maxFindCount = int(^uint(0) >> 1)
+
 var (
 	// ErrMessageNotFound returns when the message not be found
 	ErrMessageNotFound = errors.New("message can not be found")
@@ -62,7 +67,7 @@ func SaveRootUsers(udb UDB, users []*core.User) (err error) {
 	return nil
 }
 
-// GetRootUsers get two root users from db
+// GetRootUsers get two root users from db, bound to the hasher of universe
 func GetRootUsers(udb UDB) (*core.User, *core.User, error) {
 	var user0, user1 core.User
 	var err error
@@ -80,12 +85,18 @@ func GetRootUsers(udb UDB) (*core.User, *core.User, error) {
 	if err := json.Unmarshal(root1, &user1); err != nil {
 		return nil, nil, err
 	}
+	hasher, err := GetUniverseHasher(udb)
+	if err != nil {
+		return nil, nil, err
+	}
+	user0.SetHasher(hasher)
+	user1.SetHasher(hasher)
 	return &user0, &user1, nil
 }
 
 // SaveMsg save new msg to db
 func SaveMsg(udb UDB, msg *core.Message) error {
-	msgBytes, err := json.Marshal(msg)
+	msgBytes, err := encodeMsg(udb, msg)
 	if err != nil {
 		return err
 	}
@@ -106,6 +117,9 @@ func SaveMsg(udb UDB, msg *core.Message) error {
 	if err != nil {
 		return err
 	}
+	if err := saveMsgIndex(udb, msg, count.Uint64()); err != nil {
+		return err
+	}
 	count = count.Add(count, big.NewInt(1))
 	err = udb.Set(BucketConfig, ConfigMsgCount, count.Bytes())
 	if err != nil {
@@ -119,9 +133,175 @@ func SaveMsg(udb UDB, msg *core.Message) error {
 	return nil
 }
 
+// senderIndexPrefix is the key prefix of msgs from sender in BucketSenderMID
+func senderIndexPrefix(senderID common.Hash) string {
+	return common.Hash2String(senderID)
+}
+
+// typeIndexPrefix is the key prefix of msgs by content type in BucketTypeMID
+func typeIndexPrefix(contentType int) string {
+	return fmt.Sprintf("%03d", contentType)
+}
+
+// orderKey keep the keys with same prefix be sorted by order
+func orderKey(order uint64) string {
+	return fmt.Sprintf("%020d", order)
+}
+
+// saveMsgIndex save the secondary indexes of msg, so msgs from same sender
+// or with same content type can be read from a contiguous range of keys.
+func saveMsgIndex(udb UDB, msg *core.Message, order uint64) error {
+	mid := common.Hash2Bytes(msg.ID())
+	if err := udb.Set(BucketSenderMID, senderIndexPrefix(msg.SenderID)+orderKey(order), mid); err != nil {
+		return err
+	}
+	return udb.Set(BucketTypeMID, typeIndexPrefix(msg.Value.ContentType)+orderKey(order), mid)
+}
+
+// RebuildMsgIndex build the secondary indexes for msgs saved before the
+// index buckets exist, do nothing if indexes already be built.
+func RebuildMsgIndex(udb UDB) error {
+	count, err := GetMsgCount(udb)
+	if err != nil {
+		return err
+	}
+	if count.Uint64() == 0 {
+		return nil
+	}
+	if rows, err := udb.Find(BucketSenderMID, "", 1); err != nil || len(rows) > 0 {
+		return err
+	}
+	for i := uint64(0); i < count.Uint64(); i++ {
+		msgs := GetMsgByOrder(udb, new(big.Int).SetUint64(i), 1)
+		if len(msgs) == 0 {
+			return ErrMessageNotFound
+		}
+		if err := saveMsgIndex(udb, msgs[0], i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMsgsByIndex load the msgs by the msg.ID saved in index bucket with prefix,
+// the key of index end with the order of msg, so msgs not in view are skipped
+func getMsgsByIndex(udb UDB, bucketName, prefix string, view *core.View, skip, limit int) (msgs []*core.Message, err error) {
+	rows, err := udb.Find(bucketName, prefix, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if view != nil && !inView(view, row.K) {
+			break
+		}
+		msg, err := GetMsg(udb, common.Bytes2Hash(row.V))
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// inView return true if the order in the end of index key is in view
+func inView(view *core.View, key string) bool {
+	if len(key) < len(orderKey(0)) {
+		return false
+	}
+	order, err := strconv.ParseUint(key[len(key)-len(orderKey(0)):], 10, 64)
+	return err == nil && order < view.Seq
+}
+
+// TruncateMsgs remove the msgs which order after the order of msg, the
+// msg order, secondary indexes and last msg of senders are updated as well.
+func TruncateMsgs(udb UDB, msgID common.Hash) error {
+	order, count, err := GetOrderCntByMsg(udb, msgID)
+	if err != nil {
+		return err
+	}
+	senders := make(map[common.Hash]struct{})
+	for i := count.Uint64() - 1; i > order.Uint64(); i-- {
+		msgs := GetMsgByOrder(udb, new(big.Int).SetUint64(i), 1)
+		if len(msgs) == 0 {
+			return ErrMessageNotFound
+		}
+		msg := msgs[0]
+		senders[msg.SenderID] = struct{}{}
+		if err := deleteMsg(udb, msg.ID()); err != nil {
+			return err
+		}
+		for _, kv := range [][2]string{
+			{BucketMOD, common.Hash2String(msg.ID())},
+			{BucketMID, new(big.Int).SetUint64(i).String()},
+			{BucketSenderMID, senderIndexPrefix(msg.SenderID) + orderKey(i)},
+			{BucketTypeMID, typeIndexPrefix(msg.Value.ContentType) + orderKey(i)},
+		} {
+			if err := udb.Del(kv[0], kv[1]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := udb.Set(BucketConfig, ConfigMsgCount, new(big.Int).Add(order, big.NewInt(1)).Bytes()); err != nil {
+		return err
+	}
+	// update the last msg of senders by sender index
+	for senderID := range senders {
+		rows, err := udb.Find(BucketSenderMID, senderIndexPrefix(senderID), int(order.Uint64()+1))
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			err = udb.Del(BucketLastMID, common.Hash2String(senderID))
+		} else {
+			err = udb.Set(BucketLastMID, common.Hash2String(senderID), rows[len(rows)-1].V)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCheckpointsAfter remove the checkpoints of space-time which seq larger than seq
+func DeleteCheckpointsAfter(udb UDB, spaceTimeID common.Hash, seq uint64) error {
+	rows, err := udb.Find(BucketCheckpoint, common.Hash2String(spaceTimeID), maxFindCount)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.K > checkpointKey(spaceTimeID, seq) {
+			if err := udb.Del(BucketCheckpoint, row.K); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetMsgsBySender return the msgs from sender order by received sequence
+func GetMsgsBySender(udb UDB, senderID common.Hash, skip, limit int) ([]*core.Message, error) {
+	return GetMsgsBySenderAt(udb, senderID, nil, skip, limit)
+}
+
+// GetMsgsBySenderAt return the msgs from sender in view order by received
+// sequence, the msgs received after view taken are not included
+func GetMsgsBySenderAt(udb UDB, senderID common.Hash, view *core.View, skip, limit int) ([]*core.Message, error) {
+	return getMsgsByIndex(udb, BucketSenderMID, senderIndexPrefix(senderID), view, skip, limit)
+}
+
+// GetMsgsByType return the msgs with content type order by received sequence
+func GetMsgsByType(udb UDB, contentType int, skip, limit int) ([]*core.Message, error) {
+	return GetMsgsByTypeAt(udb, contentType, nil, skip, limit)
+}
+
+// GetMsgsByTypeAt return the msgs with content type in view order by received
+// sequence, the msgs received after view taken are not included
+func GetMsgsByTypeAt(udb UDB, contentType int, view *core.View, skip, limit int) ([]*core.Message, error) {
+	return getMsgsByIndex(udb, BucketTypeMID, typeIndexPrefix(contentType), view, skip, limit)
+}
+
 // GetLastMsg get the last message by order from db
 func GetLastMsg(udb UDB) (*core.Message, error) {
-	var msg core.Message
 	countBytes, err := udb.Get(BucketConfig, ConfigMsgCount)
 	if err != nil {
 		return nil, err
@@ -133,17 +313,7 @@ func GetLastMsg(udb UDB) (*core.Message, error) {
 	} else if mid == nil {
 		return nil, ErrMessageNotFound
 	}
-	msgBytes, err := udb.Get(BucketMsg, common.Bytes2String(mid))
-	if err != nil {
-		return nil, err
-	} else if msgBytes == nil {
-		return nil, ErrMessageNotFound
-	}
-	err = json.Unmarshal(msgBytes, &msg)
-	if err != nil {
-		return nil, err
-	}
-	return &msg, nil
+	return GetMsg(udb, common.Bytes2Hash(mid))
 }
 
 // GetMsgByOrder get the message by order, for sync message between peers
@@ -153,16 +323,11 @@ func GetMsgByOrder(udb UDB, start *big.Int, size int) (msgs []*core.Message) {
 		if err != nil || mid == nil {
 			continue
 		}
-		msgBytes, err := udb.Get(BucketMsg, common.Bytes2String(mid))
-		if err != nil || msgBytes == nil {
-			continue
-		}
-		var msg core.Message
-		err = json.Unmarshal(msgBytes, &msg)
+		msg, err := GetMsg(udb, common.Bytes2Hash(mid))
 		if err != nil {
 			continue
 		}
-		msgs = append(msgs, &msg)
+		msgs = append(msgs, msg)
 		start = start.Add(start, big.NewInt(1))
 	}
 	return msgs
@@ -197,23 +362,150 @@ func GetMsgCount(udb UDB) (count *big.Int, err error) {
 
 // GetLastMsgByUser return the last message by userID
 func GetLastMsgByUser(udb UDB, userID common.Hash) (*core.Message, error) {
-	var msg core.Message
 	lastMsgBytes, err := udb.Get(BucketLastMID, common.Hash2String(userID))
 	if err != nil {
 		return nil, err
 	} else if lastMsgBytes == nil {
 		return nil, ErrMessageNotFound
 	}
-	msgBytes, err := udb.Get(BucketMsg, common.Bytes2String(lastMsgBytes))
+	return GetMsg(udb, common.Bytes2Hash(lastMsgBytes))
+}
+
+// SaveTimeProofPolicy save the time proof policy of local universe
+func SaveTimeProofPolicy(udb UDB, policy *core.TimeProofPolicy) error {
+	policyBytes, err := json.Marshal(policy)
 	if err != nil {
+		return err
+	}
+	return udb.Set(BucketConfig, ConfigTimeProofPolicy, policyBytes)
+}
+
+// GetTimeProofPolicy return the time proof policy, nil if not be saved before
+func GetTimeProofPolicy(udb UDB) (*core.TimeProofPolicy, error) {
+	policyBytes, err := udb.Get(BucketConfig, ConfigTimeProofPolicy)
+	if err != nil || policyBytes == nil {
+		return nil, err
+	}
+	var policy core.TimeProofPolicy
+	if err := json.Unmarshal(policyBytes, &policy); err != nil {
 		return nil, err
-	} else if msgBytes == nil {
-		return nil, ErrMessageNotFound
 	}
-	err = json.Unmarshal(msgBytes, &msg)
+	return &policy, nil
+}
+
+// SaveMsgFilter save the bloom filter of msg.ID in local universe
+func SaveMsgFilter(udb UDB, filter *common.BloomFilter) error {
+	filterBytes, err := json.Marshal(filter)
 	if err != nil {
+		return err
+	}
+	return udb.Set(BucketConfig, ConfigMsgFilter, filterBytes)
+}
+
+// GetMsgFilter return the bloom filter of msg.ID, nil if not be saved before
+func GetMsgFilter(udb UDB) (*common.BloomFilter, error) {
+	filterBytes, err := udb.Get(BucketConfig, ConfigMsgFilter)
+	if err != nil || filterBytes == nil {
 		return nil, err
 	}
+	var filter common.BloomFilter
+	if err := json.Unmarshal(filterBytes, &filter); err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// SaveUniverseHasher save the name of hasher used by universe
+func SaveUniverseHasher(udb UDB, name string) error {
+	return udb.Set(BucketConfig, ConfigUniverseHasher, []byte(name))
+}
 
-	return &msg, nil
+// GetUniverseHasher return the hasher used by universe, SHA-256 if not be saved before
+func GetUniverseHasher(udb UDB) (common.Hasher, error) {
+	name, err := udb.Get(BucketConfig, ConfigUniverseHasher)
+	if err != nil {
+		return nil, err
+	}
+	return common.SelectHasher(string(name))
+}
+
+// SaveNetworkID save the network of universe
+func SaveNetworkID(udb UDB, network uint64) error {
+	return udb.Set(BucketConfig, ConfigNetworkID, new(big.Int).SetUint64(network).Bytes())
+}
+
+// GetNetworkID return the network of universe, main network if not be saved before
+func GetNetworkID(udb UDB) (uint64, error) {
+	network, err := udb.Get(BucketConfig, ConfigNetworkID)
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(network).Uint64(), nil
+}
+
+// CreateMissingBuckets create the buckets which not exist in db, used when
+// new bucket be added after the db have been initialized.
+func CreateMissingBuckets(udb UDB, bucketNames ...string) error {
+	for _, bucketName := range bucketNames {
+		if _, err := udb.Find(bucketName, "", 1); err == nil {
+			continue
+		}
+		if err := udb.CreateBucket(bucketName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update run fn in one transaction if udb is Updater, otherwise fn run on
+// udb directly, and the changes made before fn fail are kept
+func Update(udb UDB, fn func(tx UDB) error) error {
+	if updater, ok := udb.(Updater); ok {
+		return updater.Update(fn)
+	}
+	return fn(udb)
+}
+
+// checkpointKey build the key of checkpoint, keep the checkpoints of same
+// space-time be sorted by seq.
+func checkpointKey(spaceTimeID common.Hash, seq uint64) string {
+	return common.Hash2String(spaceTimeID) + orderKey(seq)
+}
+
+// SaveCheckpoint save the checkpoint of space-time
+func SaveCheckpoint(udb UDB, cp *core.Checkpoint) error {
+	cpBytes, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return udb.Set(BucketCheckpoint, checkpointKey(cp.SpaceTimeID, cp.Seq), cpBytes)
+}
+
+// GetCheckpoint return the checkpoint of space-time at seq, nil if not exist
+func GetCheckpoint(udb UDB, spaceTimeID common.Hash, seq uint64) (*core.Checkpoint, error) {
+	cpBytes, err := udb.Get(BucketCheckpoint, checkpointKey(spaceTimeID, seq))
+	if err != nil || cpBytes == nil {
+		return nil, err
+	}
+	var cp core.Checkpoint
+	if err := json.Unmarshal(cpBytes, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// GetCheckpoints return the checkpoints of space-time order by seq
+func GetCheckpoints(udb UDB, spaceTimeID common.Hash, skip, limit int) (cps []*core.Checkpoint, err error) {
+	rows, err := udb.Find(BucketCheckpoint, common.Hash2String(spaceTimeID), skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		var cp core.Checkpoint
+		if err := json.Unmarshal(row.V, &cp); err != nil {
+			return nil, err
+		}
+		cps = append(cps, &cp)
+	}
+	return cps, nil
 }
@@ -0,0 +1,239 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pdupub/go-pdu/core"
+)
+
+const (
+	// DefaultFlagScore is the score from which msg is flagged
+	DefaultFlagScore = 0.9
+	// DefaultRejectScore is the score from which msg is rejected
+	DefaultRejectScore = 0.99
+	// DefaultMinTrained is the count of msgs each class should be trained
+	// with, all msgs are accepted before that
+	DefaultMinTrained = 5
+
+	minTokenLen = 2
+	maxTokenLen = 32
+)
+
+var (
+	// ErrContentNotText returns if the msg trained is not TypeText
+	ErrContentNotText = errors.New("content of msg is not text")
+	// ErrScoreNotValid returns if the flag or reject score not in (0, 1]
+	ErrScoreNotValid = errors.New("score should be in (0, 1]")
+)
+
+// bayesModel is the counts learned by Bayes, saved as json
+type bayesModel struct {
+	FlagScore   float64           `json:"flagScore"`
+	RejectScore float64           `json:"rejectScore"`
+	MinTrained  uint64            `json:"minTrained"`
+	SpamMsgs    uint64            `json:"spamMsgs"`
+	HamMsgs     uint64            `json:"hamMsgs"`
+	Spam        map[string]uint64 `json:"spam"`
+	Ham         map[string]uint64 `json:"ham"`
+}
+
+// BayesStats is the size of model and the scores in use
+type BayesStats struct {
+	FlagScore   float64 `json:"flagScore"`
+	RejectScore float64 `json:"rejectScore"`
+	MinTrained  uint64  `json:"minTrained"`
+	SpamMsgs    uint64  `json:"spamMsgs"`
+	HamMsgs     uint64  `json:"hamMsgs"`
+	Tokens      int     `json:"tokens"`
+}
+
+// Bayes is the naive Bayes classifier of text msgs, the score is the
+// probability of msg being spam by the words it contains. Msgs of other
+// content types are always accepted.
+type Bayes struct {
+	mu    sync.RWMutex
+	model bayesModel
+}
+
+// NewBayes create the classifier without any msg trained
+func NewBayes() *Bayes {
+	return &Bayes{model: bayesModel{
+		FlagScore:   DefaultFlagScore,
+		RejectScore: DefaultRejectScore,
+		MinTrained:  DefaultMinTrained,
+		Spam:        make(map[string]uint64),
+		Ham:         make(map[string]uint64),
+	}}
+}
+
+// LoadBayes read the classifier saved by Save
+func LoadBayes(fileName string) (*Bayes, error) {
+	modelBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	b := NewBayes()
+	if err := json.Unmarshal(modelBytes, &b.model); err != nil {
+		return nil, err
+	}
+	if b.model.Spam == nil {
+		b.model.Spam = make(map[string]uint64)
+	}
+	if b.model.Ham == nil {
+		b.model.Ham = make(map[string]uint64)
+	}
+	return b, nil
+}
+
+// Save write the classifier into file, the file is replaced by rename so
+// no half written model is left
+func (b *Bayes) Save(fileName string) error {
+	b.mu.RLock()
+	modelBytes, err := json.Marshal(&b.model)
+	b.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, modelBytes, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// SetScores set the score from which msg is flagged and rejected, 1 means
+// only the msg certainly spam
+func (b *Bayes) SetScores(flagScore, rejectScore float64) error {
+	if flagScore <= 0 || flagScore > 1 || rejectScore <= 0 || rejectScore > 1 {
+		return ErrScoreNotValid
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.model.FlagScore, b.model.RejectScore = flagScore, rejectScore
+	return nil
+}
+
+// SetMinTrained set the count of msgs each class should be trained with
+// before any msg is flagged or rejected
+func (b *Bayes) SetMinTrained(minTrained uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.model.MinTrained = minTrained
+}
+
+// Stats return the size of model and the scores in use
+func (b *Bayes) Stats() *BayesStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	tokens := len(b.model.Spam)
+	for token := range b.model.Ham {
+		if _, ok := b.model.Spam[token]; !ok {
+			tokens++
+		}
+	}
+	return &BayesStats{
+		FlagScore:   b.model.FlagScore,
+		RejectScore: b.model.RejectScore,
+		MinTrained:  b.model.MinTrained,
+		SpamMsgs:    b.model.SpamMsgs,
+		HamMsgs:     b.model.HamMsgs,
+		Tokens:      tokens,
+	}
+}
+
+// Train count the words of text msg into spam or ham
+func (b *Bayes) Train(msg *core.Message, spam bool) error {
+	if !isText(msg) {
+		return ErrContentNotText
+	}
+	tokens := tokenize(string(msg.Value.Content))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts := b.model.Ham
+	if spam {
+		counts = b.model.Spam
+		b.model.SpamMsgs++
+	} else {
+		b.model.HamMsgs++
+	}
+	for _, token := range tokens {
+		counts[token]++
+	}
+	return nil
+}
+
+// Score return the probability of text msg being spam, and the verdict by
+// flag and reject scores. The score is 0 before both classes trained
+// with enough msgs.
+func (b *Bayes) Score(msg *core.Message) (float64, Verdict) {
+	if !isText(msg) {
+		return 0, Accept
+	}
+	tokens := tokenize(string(msg.Value.Content))
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	m := &b.model
+	if m.SpamMsgs == 0 || m.HamMsgs == 0 || m.SpamMsgs < m.MinTrained || m.HamMsgs < m.MinTrained {
+		return 0, Accept
+	}
+	// log probability with Laplace smoothing, word present in msg or not
+	total := float64(m.SpamMsgs + m.HamMsgs)
+	logSpam := math.Log(float64(m.SpamMsgs) / total)
+	logHam := math.Log(float64(m.HamMsgs) / total)
+	for _, token := range tokens {
+		logSpam += math.Log(float64(m.Spam[token]+1) / float64(m.SpamMsgs+2))
+		logHam += math.Log(float64(m.Ham[token]+1) / float64(m.HamMsgs+2))
+	}
+	score := 1 / (1 + math.Exp(logHam-logSpam))
+	switch {
+	case score >= m.RejectScore:
+		return score, Reject
+	case score >= m.FlagScore:
+		return score, Flag
+	}
+	return score, Accept
+}
+
+func isText(msg *core.Message) bool {
+	return msg.Value != nil && msg.Value.ContentType == core.TypeText
+}
+
+// tokenize split the text into lower case words, each word counted once
+func tokenize(text string) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len(word) < minTokenLen || len(word) > maxTokenLen || seen[word] {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
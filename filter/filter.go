@@ -0,0 +1,76 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package filter
+
+import (
+	"github.com/pdupub/go-pdu/core"
+)
+
+// Verdict is the decision of filter on msg
+type Verdict int
+
+const (
+	// Accept the msg as usual
+	Accept Verdict = iota
+	// Flag accept the msg, but keep it for review of operator
+	Flag
+	// Reject the msg before committed into universe
+	Reject
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Accept:
+		return "accept"
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+// Filter score the msg, higher score means more likely spam, and decide
+// the verdict by score
+type Filter interface {
+	Score(msg *core.Message) (float64, Verdict)
+}
+
+// Trainer is the filter learns from the msgs marked by operator
+type Trainer interface {
+	Train(msg *core.Message, spam bool) error
+}
+
+// Func is an adapter to allow the use of ordinary functions as Filter
+type Func func(msg *core.Message) (float64, Verdict)
+
+// Score calls f(msg)
+func (f Func) Score(msg *core.Message) (float64, Verdict) {
+	return f(msg)
+}
+
+// Validator return the validator which reject the msg if verdict of filter
+// is Reject, so filter can be added into universe by Universe.AddValidator.
+// The msg flagged is accepted by it.
+func Validator(f Filter) core.Validator {
+	return core.ValidatorFunc(func(u *core.Universe, msg *core.Message) error {
+		if _, verdict := f.Score(msg); verdict == Reject {
+			return core.ErrMsgFiltered
+		}
+		return nil
+	})
+}
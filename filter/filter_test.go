@@ -0,0 +1,152 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdupub/go-pdu/core"
+)
+
+func textMsg(text string) *core.Message {
+	return &core.Message{Value: &core.MsgValue{ContentType: core.TypeText, Content: []byte(text)}}
+}
+
+var (
+	spamTexts = []string{
+		"buy cheap pills now, limited offer",
+		"cheap watches, buy now and win",
+		"win free money now, click the link",
+		"limited offer: free pills, buy now",
+		"click now to win cheap money",
+	}
+	hamTexts = []string{
+		"the meeting is moved to friday afternoon",
+		"new release of the node is out, please upgrade",
+		"thanks for the review of the checkpoint code",
+		"see you at the meetup on friday",
+		"the universe synced after the upgrade",
+	}
+)
+
+func TestBayes_Score(t *testing.T) {
+	b := NewBayes()
+	for _, text := range spamTexts[:4] {
+		if err := b.Train(textMsg(text), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, text := range hamTexts {
+		if err := b.Train(textMsg(text), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// all msgs accepted before both classes trained with enough msgs
+	if score, verdict := b.Score(textMsg("buy cheap pills now")); score != 0 || verdict != Accept {
+		t.Errorf("msg should be accepted before trained, got %v %s", score, verdict)
+	}
+	if err := b.Train(textMsg(spamTexts[4]), true); err != nil {
+		t.Fatal(err)
+	}
+	if score, verdict := b.Score(textMsg("buy cheap pills now, click to win")); verdict != Reject {
+		t.Errorf("spam should be rejected, got %v %s", score, verdict)
+	}
+	if score, verdict := b.Score(textMsg("the node upgrade on friday")); verdict != Accept || score > 0.5 {
+		t.Errorf("ham should be accepted, got %v %s", score, verdict)
+	}
+	// raise the reject score, so spam is only flagged
+	if err := b.SetScores(0.5, 1); err != nil {
+		t.Fatal(err)
+	}
+	if score, verdict := b.Score(textMsg("cheap offer now")); verdict != Flag {
+		t.Errorf("spam should be flagged, got %v %s", score, verdict)
+	}
+	if err := b.SetScores(0, 1); err != ErrScoreNotValid {
+		t.Errorf("score 0 should not be valid, got %v", err)
+	}
+
+	// msgs other than text are not scored nor trained
+	birth := &core.Message{Value: &core.MsgValue{ContentType: core.TypeBirth, Content: []byte("buy cheap pills now")}}
+	if _, verdict := b.Score(birth); verdict != Accept {
+		t.Error("msg not text should be accepted")
+	}
+	if err := b.Train(birth, true); err != ErrContentNotText {
+		t.Errorf("msg not text should not be trained, got %v", err)
+	}
+	if stats := b.Stats(); stats.SpamMsgs != 5 || stats.HamMsgs != 5 || stats.Tokens == 0 {
+		t.Errorf("stats not match %+v", stats)
+	}
+}
+
+func TestBayes_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "bayes.json")
+
+	b := NewBayes()
+	for i := range spamTexts {
+		b.Train(textMsg(spamTexts[i]), true)
+		b.Train(textMsg(hamTexts[i]), false)
+	}
+	b.SetMinTrained(1)
+	if err := b.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBayes(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded.Stats() != *b.Stats() {
+		t.Errorf("stats of loaded not match, %+v %+v", loaded.Stats(), b.Stats())
+	}
+	msg := textMsg("free money, buy now")
+	score, verdict := b.Score(msg)
+	if loadedScore, loadedVerdict := loaded.Score(msg); loadedScore != score || loadedVerdict != verdict {
+		t.Errorf("score of loaded not match, %v %v", loadedScore, score)
+	}
+	if _, err := LoadBayes(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("missing file should not be loaded, got %v", err)
+	}
+}
+
+func TestValidator(t *testing.T) {
+	f := Func(func(msg *core.Message) (float64, Verdict) {
+		switch string(msg.Value.Content) {
+		case "spam":
+			return 1, Reject
+		case "maybe":
+			return 0.9, Flag
+		}
+		return 0, Accept
+	})
+	v := Validator(f)
+	if err := v.Validate(nil, textMsg("spam")); err != core.ErrMsgFiltered {
+		t.Errorf("msg rejected by filter should not be valid, got %v", err)
+	}
+	if err := v.Validate(nil, textMsg("maybe")); err != nil {
+		t.Errorf("msg flagged should be valid, got %v", err)
+	}
+	if err := v.Validate(nil, textMsg("hello")); err != nil {
+		t.Errorf("msg accepted should be valid, got %v", err)
+	}
+}
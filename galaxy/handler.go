@@ -0,0 +1,144 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"fmt"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ErrWaveNotHandled is returned by BaseHandler for the waves which
+// handler not care about
+var ErrWaveNotHandled = common.NewError(common.ErrCodeGalaxy+6, "wave not handled")
+
+// Handler is used to process the waves received, one method for each
+// type of wave
+type Handler interface {
+	HandleQuestion(w *WaveQuestion) error
+	HandleVersion(w *WaveVersion) error
+	HandleRoots(w *WaveRoots) error
+	HandleMessages(w *WaveMessages) error
+	HandlePing(w *WavePing) error
+	HandlePong(w *WavePong) error
+	HandleUser(w *WaveUser) error
+	HandlePeers(w *WavePeers) error
+	HandleErr(w *WaveErr) error
+	HandleCheckpoints(w *WaveCheckpoints) error
+	HandleRejections(w *WaveRejections) error
+	HandleSnapshot(w *WaveSnapshot) error
+	HandleAck(w *WaveAck) error
+	HandleInv(w *WaveInv) error
+	HandleGetMsgs(w *WaveGetMsgs) error
+}
+
+// BaseHandler implements Handler and return ErrWaveNotHandled for all
+// waves, embed it to only implement the methods needed
+type BaseHandler struct{}
+
+// HandleQuestion implements Handler
+func (BaseHandler) HandleQuestion(w *WaveQuestion) error { return ErrWaveNotHandled }
+
+// HandleVersion implements Handler
+func (BaseHandler) HandleVersion(w *WaveVersion) error { return ErrWaveNotHandled }
+
+// HandleRoots implements Handler
+func (BaseHandler) HandleRoots(w *WaveRoots) error { return ErrWaveNotHandled }
+
+// HandleMessages implements Handler
+func (BaseHandler) HandleMessages(w *WaveMessages) error { return ErrWaveNotHandled }
+
+// HandlePing implements Handler
+func (BaseHandler) HandlePing(w *WavePing) error { return ErrWaveNotHandled }
+
+// HandlePong implements Handler
+func (BaseHandler) HandlePong(w *WavePong) error { return ErrWaveNotHandled }
+
+// HandleUser implements Handler
+func (BaseHandler) HandleUser(w *WaveUser) error { return ErrWaveNotHandled }
+
+// HandlePeers implements Handler
+func (BaseHandler) HandlePeers(w *WavePeers) error { return ErrWaveNotHandled }
+
+// HandleErr implements Handler
+func (BaseHandler) HandleErr(w *WaveErr) error { return ErrWaveNotHandled }
+
+// HandleCheckpoints implements Handler
+func (BaseHandler) HandleCheckpoints(w *WaveCheckpoints) error { return ErrWaveNotHandled }
+
+// HandleRejections implements Handler
+func (BaseHandler) HandleRejections(w *WaveRejections) error { return ErrWaveNotHandled }
+
+// HandleSnapshot implements Handler
+func (BaseHandler) HandleSnapshot(w *WaveSnapshot) error { return ErrWaveNotHandled }
+
+// HandleAck implements Handler
+func (BaseHandler) HandleAck(w *WaveAck) error { return ErrWaveNotHandled }
+
+// HandleInv implements Handler
+func (BaseHandler) HandleInv(w *WaveInv) error { return ErrWaveNotHandled }
+
+// HandleGetMsgs implements Handler
+func (BaseHandler) HandleGetMsgs(w *WaveGetMsgs) error { return ErrWaveNotHandled }
+
+// CustomHandler is implemented by the handler which also process the waves
+// registered by RegisterWave
+type CustomHandler interface {
+	HandleCustom(w Wave) error
+}
+
+// Dispatch call the method of handler by the type of wave, the waves not
+// built-in are passed to HandleCustom if handler implements CustomHandler
+func Dispatch(h Handler, wave Wave) error {
+	switch w := wave.(type) {
+	case *WaveQuestion:
+		return h.HandleQuestion(w)
+	case *WaveVersion:
+		return h.HandleVersion(w)
+	case *WaveRoots:
+		return h.HandleRoots(w)
+	case *WaveMessages:
+		return h.HandleMessages(w)
+	case *WavePing:
+		return h.HandlePing(w)
+	case *WavePong:
+		return h.HandlePong(w)
+	case *WaveUser:
+		return h.HandleUser(w)
+	case *WavePeers:
+		return h.HandlePeers(w)
+	case *WaveErr:
+		return h.HandleErr(w)
+	case *WaveCheckpoints:
+		return h.HandleCheckpoints(w)
+	case *WaveRejections:
+		return h.HandleRejections(w)
+	case *WaveSnapshot:
+		return h.HandleSnapshot(w)
+	case *WaveAck:
+		return h.HandleAck(w)
+	case *WaveInv:
+		return h.HandleInv(w)
+	case *WaveGetMsgs:
+		return h.HandleGetMsgs(w)
+	default:
+		if ch, ok := h.(CustomHandler); ok {
+			return ch.HandleCustom(wave)
+		}
+		return fmt.Errorf("unhandled command [%s]", wave.Command())
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// NodeSignature is the signature of node on the wave by the key of node,
+// so the answers of node can not be forged by others. The key of node is
+// not the key of any user.
+type NodeSignature struct {
+	PubKey    core.Auth `json:"pubKey"`
+	Signature []byte    `json:"signature"`
+}
+
+// SignedData build the data signed by node, the wave id chosen by asker is
+// included, so the signature can not be replayed as the answer of others.
+func SignedData(waveID common.Hash, parts ...[]byte) []byte {
+	data := append([]byte{}, waveID[:]...)
+	size := make([]byte, 8)
+	for _, part := range parts {
+		binary.BigEndian.PutUint64(size, uint64(len(part)))
+		data = append(data, size...)
+		data = append(data, part...)
+	}
+	return data
+}
+
+// SignedData return the data signed by node, which is the peers answered
+func (w *WavePeers) SignedData() []byte {
+	return SignedData(w.WaveID, w.Peers...)
+}
+
+// SignedData return the data signed by node, which is the universe, the
+// hasher, and the first msg and config of genesis if answered
+func (w *WaveRoots) SignedData() []byte {
+	parts := [][]byte{w.Universe[:], []byte(w.Hasher)}
+	if w.Genesis != nil {
+		if w.Genesis.FirstMsg != nil {
+			msgID := w.Genesis.FirstMsg.ID()
+			parts = append(parts, msgID[:])
+		}
+		if w.Genesis.Config != nil {
+			config, _ := json.Marshal(w.Genesis.Config)
+			parts = append(parts, config)
+		}
+	}
+	return SignedData(w.WaveID, parts...)
+}
+
+// SignedData return the data signed by node, which is the space-time,
+// sequence, msg and state root of checkpoints answered
+func (w *WaveCheckpoints) SignedData() []byte {
+	var parts [][]byte
+	seq := make([]byte, 8)
+	for _, cp := range w.Checkpoints {
+		binary.BigEndian.PutUint64(seq, cp.Seq)
+		part := append([]byte{}, cp.SpaceTimeID[:]...)
+		part = append(part, seq...)
+		part = append(part, cp.MsgID[:]...)
+		part = append(part, cp.StateRoot[:]...)
+		parts = append(parts, part)
+	}
+	return SignedData(w.WaveID, parts...)
+}
@@ -0,0 +1,361 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+var (
+	// ErrQuestionAlreadyRegistered is returned when register a question which
+	// is built-in or already registered
+	ErrQuestionAlreadyRegistered = common.NewError(common.ErrCodeGalaxy+7, "question already registered")
+	// ErrQuestionNotSupported is returned when decode the question not built-in
+	// or registered
+	ErrQuestionNotSupported = common.NewError(common.ErrCodeGalaxy+8, "question not supported")
+	// ErrQuestionArgsNotValid is returned when the args of question not match
+	// the schema of question
+	ErrQuestionArgsNotValid = common.NewError(common.ErrCodeGalaxy+9, "question args not valid")
+)
+
+// Question is the typed args of WaveQuestion. The args are encoded into the
+// WaveQuestion by position, so typed questions can be answered by the nodes
+// which still read the raw args of wave.
+type Question interface {
+	// Cmd return the cmd of question in WaveQuestion
+	Cmd() string
+	// Answer return an empty wave of the type answered
+	Answer() Wave
+	// EncodeArgs return the args of question by position
+	EncodeArgs() ([][]byte, error)
+	// DecodeArgs set the question by args, ErrQuestionArgsNotValid is
+	// returned if args not match the schema of question
+	DecodeArgs(args [][]byte) error
+}
+
+// QuestionFactory create an empty question which the args of received
+// WaveQuestion will be decoded into
+type QuestionFactory func() Question
+
+var (
+	customQuestionsLock sync.RWMutex
+	customQuestions     = make(map[string]QuestionFactory)
+)
+
+// RegisterQuestion register custom question, so the WaveQuestion of this cmd
+// can be decoded into typed question same as the built-in questions.
+func RegisterQuestion(cmd string, factory QuestionFactory) error {
+	if len(cmd) == 0 {
+		return ErrWaveCommandNotValid
+	}
+	if factory == nil {
+		return ErrWaveFactoryNil
+	}
+	if _, err := makeBuiltinQuestion(cmd); err == nil {
+		return ErrQuestionAlreadyRegistered
+	}
+	customQuestionsLock.Lock()
+	defer customQuestionsLock.Unlock()
+	if _, ok := customQuestions[cmd]; ok {
+		return ErrQuestionAlreadyRegistered
+	}
+	customQuestions[cmd] = factory
+	return nil
+}
+
+// NewQuestion return an empty question of cmd, built-in or registered
+func NewQuestion(cmd string) (Question, error) {
+	if q, err := makeBuiltinQuestion(cmd); err == nil {
+		return q, nil
+	}
+	customQuestionsLock.RLock()
+	factory, ok := customQuestions[cmd]
+	customQuestionsLock.RUnlock()
+	if !ok {
+		return nil, ErrQuestionNotSupported
+	}
+	return factory(), nil
+}
+
+func makeBuiltinQuestion(cmd string) (Question, error) {
+	var q Question
+	switch cmd {
+	case CmdRoots:
+		q = &RootsQuestion{}
+	case CmdPeers:
+		q = &PeersQuestion{}
+	case CmdMessages:
+		q = &MessagesQuestion{}
+	case CmdCheckpoints:
+		q = &CheckpointsQuestion{}
+	case CmdSnapshot:
+		q = &SnapshotQuestion{}
+	case QuestionMsgRange:
+		q = &MsgRangeQuestion{}
+	case QuestionSnapshotChunk:
+		q = &SnapshotChunkQuestion{}
+	case QuestionGenesis:
+		q = &GenesisQuestion{}
+	default:
+		return nil, ErrQuestionNotSupported
+	}
+	return q, nil
+}
+
+// NewWaveQuestion build the WaveQuestion of typed question
+func NewWaveQuestion(waveID common.Hash, q Question) (*WaveQuestion, error) {
+	args, err := q.EncodeArgs()
+	if err != nil {
+		return nil, err
+	}
+	return &WaveQuestion{WaveID: waveID, Cmd: q.Cmd(), Args: args}, nil
+}
+
+// Question decode the args of wave into the typed question of its cmd
+func (w *WaveQuestion) Question() (Question, error) {
+	q, err := NewQuestion(w.Cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.DecodeArgs(w.Args); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// RootsQuestion ask the roots of universe, no args
+type RootsQuestion struct{}
+
+// Cmd implements Question
+func (q *RootsQuestion) Cmd() string { return CmdRoots }
+
+// Answer implements Question
+func (q *RootsQuestion) Answer() Wave { return &WaveRoots{} }
+
+// EncodeArgs implements Question
+func (q *RootsQuestion) EncodeArgs() ([][]byte, error) { return nil, nil }
+
+// DecodeArgs implements Question
+func (q *RootsQuestion) DecodeArgs(args [][]byte) error { return nil }
+
+// PeersQuestion is the handshake of node, ask the peers of node answered
+// with the peer of asker in JSON, which is signed by the node of asker
+type PeersQuestion struct {
+	Peer []byte         // the peer of asker in JSON
+	Auth *NodeSignature // signature on wave id and peer, nil by legacy node
+}
+
+// Cmd implements Question
+func (q *PeersQuestion) Cmd() string { return CmdPeers }
+
+// Answer implements Question
+func (q *PeersQuestion) Answer() Wave { return &WavePeers{} }
+
+// EncodeArgs implements Question
+func (q *PeersQuestion) EncodeArgs() ([][]byte, error) {
+	auth, err := json.Marshal(q.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{q.Peer, auth}, nil
+}
+
+// DecodeArgs implements Question
+func (q *PeersQuestion) DecodeArgs(args [][]byte) error {
+	if len(args) == 0 || !json.Valid(args[0]) {
+		return ErrQuestionArgsNotValid
+	}
+	q.Peer = args[0]
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &q.Auth); err != nil {
+			return ErrQuestionArgsNotValid
+		}
+	}
+	return nil
+}
+
+// MessagesQuestion ask the msgs after the last msg of asker, from the first
+// msg if LastMsgID is empty
+type MessagesQuestion struct {
+	LastMsgID common.Hash
+}
+
+// Cmd implements Question
+func (q *MessagesQuestion) Cmd() string { return CmdMessages }
+
+// Answer implements Question
+func (q *MessagesQuestion) Answer() Wave { return &WaveMessages{} }
+
+// EncodeArgs implements Question
+func (q *MessagesQuestion) EncodeArgs() ([][]byte, error) {
+	return [][]byte{common.Hash2Bytes(q.LastMsgID)}, nil
+}
+
+// DecodeArgs implements Question
+func (q *MessagesQuestion) DecodeArgs(args [][]byte) (err error) {
+	if len(args) < 1 {
+		return ErrQuestionArgsNotValid
+	}
+	q.LastMsgID, err = decodeHash(args[0])
+	return err
+}
+
+// CheckpointsQuestion ask the checkpoints of space-time
+type CheckpointsQuestion struct {
+	SpaceTimeID common.Hash
+}
+
+// Cmd implements Question
+func (q *CheckpointsQuestion) Cmd() string { return CmdCheckpoints }
+
+// Answer implements Question
+func (q *CheckpointsQuestion) Answer() Wave { return &WaveCheckpoints{} }
+
+// EncodeArgs implements Question
+func (q *CheckpointsQuestion) EncodeArgs() ([][]byte, error) {
+	return [][]byte{common.Hash2Bytes(q.SpaceTimeID)}, nil
+}
+
+// DecodeArgs implements Question
+func (q *CheckpointsQuestion) DecodeArgs(args [][]byte) (err error) {
+	if len(args) < 1 {
+		return ErrQuestionArgsNotValid
+	}
+	q.SpaceTimeID, err = decodeHash(args[0])
+	return err
+}
+
+// SnapshotQuestion ask the snapshot of primary space-time, no args
+type SnapshotQuestion struct{}
+
+// Cmd implements Question
+func (q *SnapshotQuestion) Cmd() string { return CmdSnapshot }
+
+// Answer implements Question
+func (q *SnapshotQuestion) Answer() Wave { return &WaveSnapshot{} }
+
+// EncodeArgs implements Question
+func (q *SnapshotQuestion) EncodeArgs() ([][]byte, error) { return nil, nil }
+
+// DecodeArgs implements Question
+func (q *SnapshotQuestion) DecodeArgs(args [][]byte) error { return nil }
+
+// MsgRangeQuestion ask the msgs by order range, see QuestionMsgRange
+type MsgRangeQuestion struct {
+	From  uint64
+	Count uint64
+}
+
+// Cmd implements Question
+func (q *MsgRangeQuestion) Cmd() string { return QuestionMsgRange }
+
+// Answer implements Question
+func (q *MsgRangeQuestion) Answer() Wave { return &WaveMessages{} }
+
+// EncodeArgs implements Question
+func (q *MsgRangeQuestion) EncodeArgs() ([][]byte, error) {
+	return [][]byte{encodeUint64(q.From), encodeUint64(q.Count)}, nil
+}
+
+// DecodeArgs implements Question
+func (q *MsgRangeQuestion) DecodeArgs(args [][]byte) (err error) {
+	if len(args) < 2 {
+		return ErrQuestionArgsNotValid
+	}
+	if q.From, err = decodeUint64(args[0]); err != nil {
+		return err
+	}
+	q.Count, err = decodeUint64(args[1])
+	return err
+}
+
+// SnapshotChunkQuestion ask one chunk of snapshot, see QuestionSnapshotChunk
+type SnapshotChunkQuestion struct {
+	SpaceTimeID common.Hash
+	Seq         uint64
+	Root        common.Hash
+	Index       uint64
+}
+
+// Cmd implements Question
+func (q *SnapshotChunkQuestion) Cmd() string { return QuestionSnapshotChunk }
+
+// Answer implements Question
+func (q *SnapshotChunkQuestion) Answer() Wave { return &WaveMessages{} }
+
+// EncodeArgs implements Question
+func (q *SnapshotChunkQuestion) EncodeArgs() ([][]byte, error) {
+	return [][]byte{common.Hash2Bytes(q.SpaceTimeID), encodeUint64(q.Seq), common.Hash2Bytes(q.Root), encodeUint64(q.Index)}, nil
+}
+
+// DecodeArgs implements Question
+func (q *SnapshotChunkQuestion) DecodeArgs(args [][]byte) (err error) {
+	if len(args) < 4 {
+		return ErrQuestionArgsNotValid
+	}
+	if q.SpaceTimeID, err = decodeHash(args[0]); err != nil {
+		return err
+	}
+	if q.Seq, err = decodeUint64(args[1]); err != nil {
+		return err
+	}
+	if q.Root, err = decodeHash(args[2]); err != nil {
+		return err
+	}
+	q.Index, err = decodeUint64(args[3])
+	return err
+}
+
+// GenesisQuestion ask the genesis of universe, see QuestionGenesis
+type GenesisQuestion struct{}
+
+// Cmd implements Question
+func (q *GenesisQuestion) Cmd() string { return QuestionGenesis }
+
+// Answer implements Question
+func (q *GenesisQuestion) Answer() Wave { return &WaveRoots{} }
+
+// EncodeArgs implements Question
+func (q *GenesisQuestion) EncodeArgs() ([][]byte, error) { return nil, nil }
+
+// DecodeArgs implements Question
+func (q *GenesisQuestion) DecodeArgs(args [][]byte) error { return nil }
+
+// decodeHash decode the hash arg, which is always HashLength bytes
+func decodeHash(arg []byte) (common.Hash, error) {
+	if len(arg) != common.HashLength {
+		return common.Hash{}, ErrQuestionArgsNotValid
+	}
+	return common.Bytes2Hash(arg), nil
+}
+
+// encodeUint64 encode the number arg as the bytes of big.Int, same as
+// the number args of SendQuestion
+func encodeUint64(v uint64) []byte {
+	return new(big.Int).SetUint64(v).Bytes()
+}
+
+func decodeUint64(arg []byte) (uint64, error) {
+	if len(arg) > 8 {
+		return 0, ErrQuestionArgsNotValid
+	}
+	return new(big.Int).SetBytes(arg).Uint64(), nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+type testQuestion struct {
+	Query string
+}
+
+func (q *testQuestion) Cmd() string                    { return cmdTestQuery }
+func (q *testQuestion) Answer() Wave                   { return &waveTestQuery{} }
+func (q *testQuestion) EncodeArgs() ([][]byte, error)  { return [][]byte{[]byte(q.Query)}, nil }
+func (q *testQuestion) DecodeArgs(args [][]byte) error { q.Query = string(args[0]); return nil }
+
+func TestQuestion(t *testing.T) {
+	questions := []Question{
+		&RootsQuestion{},
+		&PeersQuestion{Peer: []byte(`{"ip":"127.0.0.1"}`)},
+		&MessagesQuestion{LastMsgID: common.CreateHash()},
+		&CheckpointsQuestion{SpaceTimeID: common.CreateHash()},
+		&SnapshotQuestion{},
+		&MsgRangeQuestion{From: 0, Count: 1000},
+		&SnapshotChunkQuestion{SpaceTimeID: common.CreateHash(), Seq: 7, Root: common.CreateHash(), Index: 1},
+		&GenesisQuestion{},
+	}
+	for _, sent := range questions {
+		wq, err := NewWaveQuestion(common.CreateHash(), sent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := SendWave(&buf, wq); err != nil {
+			t.Fatal(err)
+		}
+		w, err := ReceiveWave(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, err := w.(*WaveQuestion).Question()
+		if err != nil {
+			t.Fatal(sent.Cmd(), err)
+		}
+		if !reflect.DeepEqual(received, sent) {
+			t.Errorf("question %s not match, %+v", sent.Cmd(), received)
+		}
+	}
+
+	// args sent by position without schema
+	stID, root := common.CreateHash(), common.CreateHash()
+	wq := &WaveQuestion{Cmd: QuestionSnapshotChunk, Args: [][]byte{common.Hash2Bytes(stID), big.NewInt(3).Bytes(), common.Hash2Bytes(root), []byte{}}}
+	q, err := wq.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk := q.(*SnapshotChunkQuestion); chunk.SpaceTimeID != stID || chunk.Seq != 3 || chunk.Root != root || chunk.Index != 0 {
+		t.Errorf("snapshot chunk question not match %+v", chunk)
+	}
+
+	for _, wq := range []*WaveQuestion{
+		{Cmd: CmdMessages},
+		{Cmd: CmdMessages, Args: [][]byte{[]byte("short")}},
+		{Cmd: CmdPeers, Args: [][]byte{[]byte("not json")}},
+		{Cmd: CmdPeers, Args: [][]byte{[]byte("{}"), []byte("not json")}},
+		{Cmd: QuestionMsgRange, Args: [][]byte{{1}}},
+		{Cmd: QuestionMsgRange, Args: [][]byte{{1}, make([]byte, 9)}},
+	} {
+		if _, err := wq.Question(); err != ErrQuestionArgsNotValid {
+			t.Errorf("question %s %q should not be valid, but get %v", wq.Cmd, wq.Args, err)
+		}
+	}
+	if _, err := (&WaveQuestion{Cmd: "unknown"}).Question(); err != ErrQuestionNotSupported {
+		t.Errorf("err should be %s, but get %s", ErrQuestionNotSupported, err)
+	}
+}
+
+func TestRegisterQuestion(t *testing.T) {
+	factory := func() Question { return &testQuestion{} }
+	if err := RegisterQuestion(QuestionMsgRange, factory); err != ErrQuestionAlreadyRegistered {
+		t.Errorf("err should be %s, but get %s", ErrQuestionAlreadyRegistered, err)
+	}
+	if err := RegisterQuestion("", factory); err != ErrWaveCommandNotValid {
+		t.Errorf("err should be %s, but get %s", ErrWaveCommandNotValid, err)
+	}
+	if err := RegisterQuestion(cmdTestQuery, nil); err != ErrWaveFactoryNil {
+		t.Errorf("err should be %s, but get %s", ErrWaveFactoryNil, err)
+	}
+	if err := RegisterQuestion(cmdTestQuery, factory); err != nil {
+		t.Fatal("register question fail", err)
+	}
+	if err := RegisterQuestion(cmdTestQuery, factory); err != ErrQuestionAlreadyRegistered {
+		t.Errorf("err should be %s, but get %s", ErrQuestionAlreadyRegistered, err)
+	}
+	wq, err := NewWaveQuestion(common.CreateHash(), &testQuestion{Query: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := wq.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received, ok := q.(*testQuestion); !ok || received.Query != "hello" {
+		t.Error("registered question not match")
+	}
+}
@@ -18,16 +18,24 @@ package galaxy
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
 )
 
 // WaveSize is the number of bytes in a wave
 // WaveHeaderSize 24 bytes + waveBody
 const WaveSize = 1048 * 64
 
+// MaxBulkWaveSize is the max number of bytes in a bulk wave, such as the
+// messages transfer, the body of bulk wave is decoded as stream
+const MaxBulkWaveSize = 1024 * 1024 * 16
+
 // WaveHeaderSize is the number of bytes in a wave header
 // command 12 bytes + length 4 bytes + checksum 4 bytes
 const WaveHeaderSize = 24
@@ -37,28 +45,107 @@ const CommandSize = 12
 
 // Commands used in wave which describe the type of wave.
 const (
-	CmdQuestion = "question"
-	CmdVersion  = "version"
-	CmdRoots    = "roots"
-	CmdMessages = "messages"
-	CmdPing     = "ping"
-	CmdPong     = "pong"
-	CmdUser     = "user"
-	CmdPeers    = "peers"
-	CmdErr      = "error"
+	CmdQuestion    = "question"
+	CmdVersion     = "version"
+	CmdRoots       = "roots"
+	CmdMessages    = "messages"
+	CmdPing        = "ping"
+	CmdPong        = "pong"
+	CmdUser        = "user"
+	CmdPeers       = "peers"
+	CmdErr         = "error"
+	CmdCheckpoints = "checkpoints"
+	CmdRejections  = "rejections"
+	CmdSnapshot    = "snapshot"
+	CmdAck         = "ack"
+	CmdInv         = "inv"
+	CmdGetMsgs     = "getmsgs"
 )
 
+// QuestionMsgRange is the question for msgs by order range, the args are
+// the order of first msg and the count. It is not a wave command, the msgs
+// are answered by WaveMessages with the msg count of peer.
+const QuestionMsgRange = "msgrange"
+
+// QuestionSnapshotChunk is the question for one chunk of snapshot, the args
+// are the space-time ID and seq of checkpoint, the root of snapshot and the
+// index of chunk. The msgs of chunk are answered by WaveMessages, no msgs
+// if the snapshot of peer at this checkpoint has different root.
+const QuestionSnapshotChunk = "snapchunk"
+
+// QuestionGenesis is the question for the genesis of universe, no args. The
+// roots, the first msg and the config are answered by WaveRoots signed by node.
+const QuestionGenesis = "genesis"
+
 var (
-	errWaveLengthTooLong = errors.New("wave length too long")
-	errWaveHeaderMissing = errors.New("wave header missing")
+	// ErrWaveAlreadyRegistered is returned when register a command which is
+	// built-in or already registered
+	ErrWaveAlreadyRegistered = common.NewError(common.ErrCodeGalaxy+1, "wave command already registered")
+	// ErrWaveCommandNotValid is returned when register an empty or too long command
+	ErrWaveCommandNotValid = common.NewError(common.ErrCodeGalaxy+2, "wave command not valid")
+	// ErrWaveFactoryNil is returned when register a command without factory
+	ErrWaveFactoryNil = common.NewError(common.ErrCodeGalaxy+3, "wave factory is nil")
+
+	errWaveLengthTooLong = common.NewError(common.ErrCodeGalaxy+4, "wave length too long")
+	errWaveHeaderMissing = common.NewError(common.ErrCodeGalaxy+5, "wave header missing")
 )
 
+// WaveFactory create an empty wave which the received wave body will be
+// unmarshaled into
+type WaveFactory func() Wave
+
+var (
+	customWavesLock sync.RWMutex
+	customWaves     = make(map[string]WaveFactory)
+)
+
+// RegisterWave register custom type of wave, so the wave with this command
+// can be sent and received same as the built-in waves. The factory should
+// return a pointer, and the wave should carry a WaveID so the answer can be
+// matched to the question.
+func RegisterWave(command string, factory WaveFactory) error {
+	if len(command) == 0 || len(command) > CommandSize {
+		return ErrWaveCommandNotValid
+	}
+	if factory == nil {
+		return ErrWaveFactoryNil
+	}
+	if _, err := makeBuiltinWave(command); err == nil {
+		return ErrWaveAlreadyRegistered
+	}
+	customWavesLock.Lock()
+	defer customWavesLock.Unlock()
+	if _, ok := customWaves[command]; ok {
+		return ErrWaveAlreadyRegistered
+	}
+	customWaves[command] = factory
+	return nil
+}
+
 // Wave is an interface that describes a galaxy information.
 type Wave interface {
 	Command() string
 }
 
+// NewWave return an empty wave of command, built-in or registered
+func NewWave(command string) (Wave, error) {
+	return makeEmptyWave(command)
+}
+
 func makeEmptyWave(command string) (Wave, error) {
+	if wave, err := makeBuiltinWave(command); err == nil {
+		return wave, nil
+	}
+	customWavesLock.RLock()
+	factory, ok := customWaves[command]
+	customWavesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unhandled command [%s]", command)
+	}
+	return factory(), nil
+}
+
+func makeBuiltinWave(command string) (Wave, error) {
 	var wave Wave
 	switch command {
 	case CmdQuestion:
@@ -79,13 +166,26 @@ func makeEmptyWave(command string) (Wave, error) {
 		wave = &WavePeers{}
 	case CmdErr:
 		wave = &WaveErr{}
+	case CmdCheckpoints:
+		wave = &WaveCheckpoints{}
+	case CmdRejections:
+		wave = &WaveRejections{}
+	case CmdSnapshot:
+		wave = &WaveSnapshot{}
+	case CmdAck:
+		wave = &WaveAck{}
+	case CmdInv:
+		wave = &WaveInv{}
+	case CmdGetMsgs:
+		wave = &WaveGetMsgs{}
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
 	return wave, nil
 }
 
-// SendWave send a wave message to w
+// SendWave send a wave message to w. The wave is encoded into a pooled
+// buffer, which is put back after written, so w should not keep the bytes.
 func SendWave(w io.Writer, wave Wave) (int, error) {
 	var magic, waveLen, checkSum [4]byte
 	var command [CommandSize]byte
@@ -95,37 +195,46 @@ func SendWave(w io.Writer, wave Wave) (int, error) {
 	}
 	copy(command[:], []byte(cmd))
 	copy(magic[:], []byte(""))
-	copy(waveLen[:], []byte(""))
 	copy(checkSum[:], []byte(""))
 
-	waveHeader := bytes.NewBuffer(make([]byte, 0, WaveHeaderSize))
-	waveHeader.Write((magic[:]))
-	waveHeader.Write(command[:])
-	waveHeader.Write(waveLen[:])
-	waveHeader.Write(checkSum[:])
-	waveBody, err := json.Marshal(wave)
-	if err != nil {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.Write(magic[:])
+	buf.Write(command[:])
+	buf.Write(waveLen[:])
+	buf.Write(checkSum[:])
+	if err := json.NewEncoder(buf).Encode(wave); err != nil {
 		return 0, err
 	}
-	waveBytes := append(waveHeader.Bytes(), waveBody...)
-	if len(waveBytes) > WaveSize {
+	// drop the newline appended by encoder, same as json.Marshal
+	buf.Truncate(buf.Len() - 1)
+	bodyLen := buf.Len() - WaveHeaderSize
+	if bodyLen > maxWaveBodySize(cmd) {
 		return 0, errWaveLengthTooLong
 	}
-	return w.Write(waveBytes)
+	binary.BigEndian.PutUint32(buf.Bytes()[CommandSize+4:CommandSize+8], uint32(bodyLen))
+	return w.Write(buf.Bytes())
 }
 
-// ReceiveWave receive a wave message from r
+// maxWaveBodySize return the max number of bytes of wave body by command
+func maxWaveBodySize(command string) int {
+	if command == CmdMessages {
+		return MaxBulkWaveSize - WaveHeaderSize
+	}
+	return WaveSize - WaveHeaderSize
+}
+
+// ReceiveWave receive a wave message from r. The length in header is
+// checked before the body be read, and the body is decoded from r
+// directly, so no buffer is allocated by the length peer declared.
 func ReceiveWave(r io.Reader) (Wave, error) {
-	waveBytes := make([]byte, WaveSize)
-	n, err := r.Read(waveBytes)
-	if err != nil {
+	waveHeader := make([]byte, WaveHeaderSize)
+	if _, err := io.ReadFull(r, waveHeader); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errWaveHeaderMissing
+		}
 		return nil, err
 	}
-	if n <= WaveHeaderSize {
-		return nil, errWaveHeaderMissing
-	}
-	waveHeader := waveBytes[:WaveHeaderSize]
-	waveBody := waveBytes[WaveHeaderSize:n]
 
 	// Strip trailing zeros from command string.
 	command := string(bytes.TrimRight(waveHeader[4:CommandSize+4], string(0)))
@@ -134,7 +243,32 @@ func ReceiveWave(r io.Reader) (Wave, error) {
 		return nil, err
 	}
 
-	if err := json.Unmarshal(waveBody, msg); err != nil {
+	waveLen := int64(binary.BigEndian.Uint32(waveHeader[CommandSize+4 : CommandSize+8]))
+	if waveLen == 0 {
+		// wave from old version without length, body is the rest of this read
+		buf := getBuffer()
+		defer putBuffer(buf)
+		buf.Grow(WaveSize - WaveHeaderSize)
+		waveBody := buf.Bytes()[:WaveSize-WaveHeaderSize]
+		n, err := r.Read(waveBody)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(waveBody[:n], msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	if waveLen > int64(maxWaveBodySize(command)) {
+		return nil, errWaveLengthTooLong
+	}
+
+	body := io.LimitReader(r, waveLen)
+	if err := json.NewDecoder(body).Decode(msg); err != nil {
+		return nil, err
+	}
+	// drop the rest of body, so the next wave start from its header
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
 		return nil, err
 	}
 	return msg, nil
@@ -15,3 +15,158 @@
 // along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
 
 package galaxy
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+const cmdTestQuery = "testquery"
+
+type waveTestQuery struct {
+	WaveID common.Hash `json:"waveID"`
+	Query  string      `json:"query"`
+}
+
+func (w *waveTestQuery) Command() string {
+	return cmdTestQuery
+}
+
+type testCustomHandler struct {
+	BaseHandler
+	query string
+}
+
+func (h *testCustomHandler) HandleCustom(w Wave) error {
+	h.query = w.(*waveTestQuery).Query
+	return nil
+}
+
+func TestRegisterWave(t *testing.T) {
+	factory := func() Wave { return &waveTestQuery{} }
+	if err := RegisterWave(CmdPing, factory); err != ErrWaveAlreadyRegistered {
+		t.Errorf("err should be %s, but get %s", ErrWaveAlreadyRegistered, err)
+	}
+	if err := RegisterWave("", factory); err != ErrWaveCommandNotValid {
+		t.Errorf("err should be %s, but get %s", ErrWaveCommandNotValid, err)
+	}
+	if err := RegisterWave("commandtoolong", factory); err != ErrWaveCommandNotValid {
+		t.Errorf("err should be %s, but get %s", ErrWaveCommandNotValid, err)
+	}
+	if err := RegisterWave(cmdTestQuery, nil); err != ErrWaveFactoryNil {
+		t.Errorf("err should be %s, but get %s", ErrWaveFactoryNil, err)
+	}
+	if err := RegisterWave(cmdTestQuery, factory); err != nil {
+		t.Fatal("register wave fail", err)
+	}
+	if err := RegisterWave(cmdTestQuery, factory); err != ErrWaveAlreadyRegistered {
+		t.Errorf("err should be %s, but get %s", ErrWaveAlreadyRegistered, err)
+	}
+
+	var buf bytes.Buffer
+	sent := &waveTestQuery{WaveID: common.CreateHash(), Query: "hello"}
+	if _, err := SendWave(&buf, sent); err != nil {
+		t.Fatal("send wave fail", err)
+	}
+	w, err := ReceiveWave(&buf)
+	if err != nil {
+		t.Fatal("receive wave fail", err)
+	}
+	received, ok := w.(*waveTestQuery)
+	if !ok || received.WaveID != sent.WaveID || received.Query != sent.Query {
+		t.Error("received wave not match")
+	}
+
+	h := &testCustomHandler{}
+	if err := Dispatch(h, w); err != nil || h.query != sent.Query {
+		t.Error("dispatch custom wave fail", err)
+	}
+	if err := Dispatch(BaseHandler{}, w); err == nil {
+		t.Error("dispatch custom wave without CustomHandler should fail")
+	}
+}
+
+func TestReceiveWave_Length(t *testing.T) {
+	var buf bytes.Buffer
+	ping := &WavePing{WaveID: common.CreateHash()}
+	errWave := &WaveErr{WaveID: common.CreateHash(), Err: "error"}
+	SendWave(&buf, ping)
+	SendWave(&buf, errWave)
+	if w, err := ReceiveWave(&buf); err != nil || w.(*WavePing).WaveID != ping.WaveID {
+		t.Error("receive first wave fail", err)
+	}
+	if w, err := ReceiveWave(&buf); err != nil || w.(*WaveErr).Err != errWave.Err {
+		t.Error("receive second wave fail", err)
+	}
+
+	// only the bulk wave can be larger than WaveSize
+	large := string(bytes.Repeat([]byte("a"), WaveSize))
+	if _, err := SendWave(&buf, &WaveErr{Err: large}); err != errWaveLengthTooLong {
+		t.Errorf("err should be %s, but get %s", errWaveLengthTooLong, err)
+	}
+	bulk := &WaveMessages{WaveID: common.CreateHash(), Msgs: [][]byte{[]byte(large), []byte(large)}}
+	if _, err := SendWave(&buf, bulk); err != nil {
+		t.Fatal("send bulk wave fail", err)
+	}
+	w, err := ReceiveWave(&buf)
+	if err != nil {
+		t.Fatal("receive bulk wave fail", err)
+	}
+	if msgs := w.(*WaveMessages).Msgs; len(msgs) != 2 || string(msgs[1]) != large {
+		t.Error("bulk wave not match")
+	}
+
+	// the declared length is checked before body be read
+	header := make([]byte, WaveHeaderSize)
+	copy(header[4:], CmdPing)
+	header[CommandSize+4] = 0xff
+	if _, err := ReceiveWave(bytes.NewReader(header)); err != errWaveLengthTooLong {
+		t.Errorf("err should be %s, but get %s", errWaveLengthTooLong, err)
+	}
+	if _, err := ReceiveWave(bytes.NewReader(header[:10])); err != errWaveHeaderMissing {
+		t.Errorf("err should be %s, but get %s", errWaveHeaderMissing, err)
+	}
+}
+
+func TestReceiveWave_Reuse(t *testing.T) {
+	var buf bytes.Buffer
+	first := &WaveMessages{WaveID: common.CreateHash(), Msgs: [][]byte{[]byte("first")}}
+	second := &WaveMessages{WaveID: common.CreateHash(), Msgs: [][]byte{[]byte("second")}}
+	SendWave(&buf, first)
+	w1, err := ReceiveWave(&buf)
+	if err != nil {
+		t.Fatal("receive first wave fail", err)
+	}
+	// the pooled buffer is reused by the next wave
+	SendWave(&buf, second)
+	w2, err := ReceiveWave(&buf)
+	if err != nil {
+		t.Fatal("receive second wave fail", err)
+	}
+	if string(w1.(*WaveMessages).Msgs[0]) != "first" || string(w2.(*WaveMessages).Msgs[0]) != "second" {
+		t.Error("received waves should not share bytes")
+	}
+	// body shorter than the declared length
+	SendWave(&buf, first)
+	buf.Truncate(buf.Len() - 1)
+	if _, err := ReceiveWave(&buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("err should be %s, but get %s", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func BenchmarkSendReceiveWave(b *testing.B) {
+	var buf bytes.Buffer
+	wave := &WaveMessages{WaveID: common.CreateHash(), Msgs: [][]byte{bytes.Repeat([]byte("a"), 1024)}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := SendWave(&buf, wave); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ReceiveWave(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
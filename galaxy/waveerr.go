@@ -20,8 +20,15 @@ import "github.com/pdupub/go-pdu/common"
 
 // WaveErr return err of wave questions
 type WaveErr struct {
-	WaveID common.Hash `json:"waveID"`
-	Err    string      `json:"err"`
+	WaveID common.Hash      `json:"waveID"`
+	Err    string           `json:"err"`
+	Code   common.ErrorCode `json:"code,omitempty"` // stable code of err, see common.Error
+}
+
+// AsError return the err with code, which can be matched with the sentinel
+// errors of same code by errors.Is
+func (w *WaveErr) AsError() error {
+	return common.NewError(w.Code, w.Err)
 }
 
 // Command returns the protocol command string for the wave.
@@ -22,6 +22,8 @@ import "github.com/pdupub/go-pdu/common"
 type WaveMessages struct {
 	WaveID common.Hash `json:"waveID"`
 	Msgs   [][]byte    `json:"msgs"`
+	Total  uint64      `json:"total,omitempty"` // msg count of sender, set in answer of QuestionMsg and QuestionMsgRange
+	Ack    bool        `json:"ack,omitempty"`   // receiver answer WaveAck for the msgs accepted
 }
 
 // Command returns the protocol command string for the wave.
@@ -20,8 +20,9 @@ import "github.com/pdupub/go-pdu/common"
 
 // WavePeers implements the Wave interface and represent node peers.
 type WavePeers struct {
-	WaveID common.Hash `json:"waveID"`
-	Peers  [][]byte    `json:"peers"`
+	WaveID common.Hash    `json:"waveID"`
+	Peers  [][]byte       `json:"peers"`
+	Auth   *NodeSignature `json:"auth,omitempty"` // signed by the node answered
 }
 
 // Command returns the protocol command string for the wave.
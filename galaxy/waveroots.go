@@ -23,8 +23,13 @@ import (
 
 // WaveRoots implements the Wave interface and represents a getRoots message.
 type WaveRoots struct {
-	WaveID common.Hash   `json:"waveID"`
-	Users  [2]*core.User `json:"users"`
+	WaveID   common.Hash    `json:"waveID"`
+	Users    [2]*core.User  `json:"users"`
+	Hasher   string         `json:"hasher,omitempty"`
+	Network  uint64         `json:"network,omitempty"`  // network of universe, main network if not set
+	Universe common.Hash    `json:"universe,omitempty"` // genesis hash of users on network, not checked if zero
+	Genesis  *core.Genesis  `json:"genesis,omitempty"`  // set in answer of QuestionGenesis
+	Auth     *NodeSignature `json:"auth,omitempty"`     // signed by the node answered
 }
 
 // Command returns the protocol command string for the wave.
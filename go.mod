@@ -13,6 +13,8 @@ require (
 	github.com/ethereum/go-ethereum v1.9.7
 	github.com/google/uuid v1.0.0
 	github.com/howeyc/gopass v0.0.0-20190910152052-7cb4b85ec19c
+	github.com/kilic/bls12-381 v0.1.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pborman/uuid v1.2.0 // indirect
 	github.com/pdupub/go-dag v0.0.0-20210210033342-8e67f398f6d9
@@ -22,5 +24,7 @@ require (
 	github.com/steakknife/bloomfilter v0.0.0-20180922174646-6819c0d2a570 // indirect
 	github.com/steakknife/hamming v0.0.0-20180906055917-c99c65617cd3 // indirect
 	github.com/syndtr/goleveldb v1.0.0 // indirect
+	golang.org/x/crypto v0.0.0-20190404164418-38d8ce5564a5
 	golang.org/x/net v0.0.0-20190912160710-24e19bdeb0f2
+	golang.org/x/sys v0.10.0 // indirect
 )
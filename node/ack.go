@@ -0,0 +1,227 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultAckTimeout is the time waiting for the ack of msg broadcast
+	// before it be sent again, doubled after each attempt
+	DefaultAckTimeout = time.Second * 5
+
+	// MaxBroadcastAttempts is the max times a msg be sent to one peer
+	// without ack, then the peer is given up for this msg
+	MaxBroadcastAttempts = 5
+)
+
+// AckStats is the count of msgs broadcast with ack asked
+type AckStats struct {
+	Pending  int    `json:"pending"`
+	Acked    uint64 `json:"acked"`
+	Rejected uint64 `json:"rejected"`
+	Retried  uint64 `json:"retried"`
+	Dropped  uint64 `json:"dropped"` // no ack after MaxBroadcastAttempts
+}
+
+// pendingAck is the msg broadcast to peer, waiting for the ack of wave
+type pendingAck struct {
+	peerKey  common.Hash
+	msg      *core.Message
+	attempts int
+	deadline time.Time
+}
+
+// acker track the msgs broadcast until acked or rejected by peers
+type acker struct {
+	lock    sync.Mutex
+	enable  bool
+	timeout time.Duration
+	pending map[common.Hash]*pendingAck // wave ID : msg waiting for ack
+	stats   AckStats
+}
+
+func newAcker() *acker {
+	return &acker{timeout: DefaultAckTimeout, pending: make(map[common.Hash]*pendingAck)}
+}
+
+func (a *acker) enabled() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.enable
+}
+
+func (a *acker) ackTimeout() time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.timeout
+}
+
+func (a *acker) add(waveID, peerKey common.Hash, msg *core.Message, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.pending[waveID] = &pendingAck{peerKey: peerKey, msg: msg, attempts: 1, deadline: now.Add(a.timeout)}
+}
+
+// ack remove the msg of wave if acked by peer, return false if the wave
+// is not waiting for ack
+func (a *acker) ack(waveID common.Hash, msgIDs []common.Hash) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	pa, ok := a.pending[waveID]
+	if !ok || !containsHash(msgIDs, pa.msg.ID()) {
+		return false
+	}
+	delete(a.pending, waveID)
+	a.stats.Acked++
+	return true
+}
+
+// reject remove the msg of wave if rejected by peer for the reason which
+// will not change by sending again
+func (a *acker) reject(waveID common.Hash, r *core.Rejection) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	pa, ok := a.pending[waveID]
+	if !ok || pa.msg.ID() != r.MsgID || retryable(r) {
+		return
+	}
+	delete(a.pending, waveID)
+	a.stats.Rejected++
+}
+
+// retryable return true if the msg rejected may be accepted by sending
+// again, such as the reference or sender missing in peer, or rate limited
+func retryable(r *core.Rejection) bool {
+	switch r.Code {
+	case core.RejectUnknown, core.RejectSender, core.RejectReference:
+		return true
+	}
+	return false
+}
+
+// due return the waves not acked before deadline, the waves attempted too
+// many times are dropped, the others are waiting for the next deadline
+// with backoff
+func (a *acker) due(now time.Time) map[common.Hash]*pendingAck {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	waves := make(map[common.Hash]*pendingAck)
+	for waveID, pa := range a.pending {
+		if now.Before(pa.deadline) {
+			continue
+		}
+		if pa.attempts >= MaxBroadcastAttempts {
+			delete(a.pending, waveID)
+			a.stats.Dropped++
+			continue
+		}
+		pa.attempts++
+		pa.deadline = now.Add(a.timeout << uint(pa.attempts-1))
+		a.stats.Retried++
+		waves[waveID] = pa
+	}
+	return waves
+}
+
+func (a *acker) forget(waveID common.Hash) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.pending[waveID]; ok {
+		delete(a.pending, waveID)
+		a.stats.Dropped++
+	}
+}
+
+func (a *acker) copyStats() *AckStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	stats := a.stats
+	stats.Pending = len(a.pending)
+	return &stats
+}
+
+// EnableBroadcastAck ask peers to ack the msgs broadcast, the msg not acked
+// in timeout is sent again with backoff, up to MaxBroadcastAttempts. The
+// peers of old version never ack, so msgs to them are sent repeatedly. The
+// timeout is also used by the msgs of local user, which always ask ack.
+func (n *Node) EnableBroadcastAck(timeout time.Duration) {
+	n.acker.lock.Lock()
+	defer n.acker.lock.Unlock()
+	n.acker.enable = true
+	if timeout > 0 {
+		n.acker.timeout = timeout
+	}
+}
+
+// BroadcastAckStats return the count of msgs broadcast with ack asked, nil
+// if not enabled
+func (n Node) BroadcastAckStats() *AckStats {
+	if !n.acker.enabled() {
+		return nil
+	}
+	return n.acker.copyStats()
+}
+
+// sendBroadcast send msg to peer, and wait for ack if enabled
+func (n Node) sendBroadcast(k common.Hash, p *peer.Peer, msg *core.Message) error {
+	waveID := common.CreateHash()
+	if !n.acker.enabled() {
+		return p.SendMsg(waveID, msg)
+	}
+	n.acker.add(waveID, k, msg, time.Now())
+	return p.SendMsgAck(waveID, msg)
+}
+
+// retryBroadcast send the msgs not acked in time again with same wave ID,
+// the msgs to peers removed are given up
+func (n Node) retryBroadcast() {
+	if !n.acker.enabled() {
+		return
+	}
+	peers := n.copyPeers()
+	for waveID, pa := range n.acker.due(time.Now()) {
+		p, ok := peers[pa.peerKey]
+		if !ok {
+			n.acker.forget(waveID)
+			continue
+		}
+		if !p.Connected() {
+			continue
+		}
+		if err := p.SendMsgAck(waveID, pa.msg); err != nil {
+			log.Error("Broadcast again to peer", common.Hash2String(pa.peerKey), err)
+		}
+	}
+}
+
+func (n *Node) handleAck(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveAck)
+	if !n.acker.ack(wm.WaveID, wm.MsgIDs) {
+		n.delivered(wm.WaveID, wm.MsgIDs)
+	}
+	return wm.WaveID, nil
+}
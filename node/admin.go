@@ -0,0 +1,787 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backup"
+	"github.com/pdupub/go-pdu/filter"
+)
+
+const (
+	// AdminPath is the path of admin json-rpc on the admin listener
+	AdminPath = "/admin"
+	// AdminTokenFile is the default name of token file in data dir
+	AdminTokenFile = "admin.token"
+
+	adminOpTimeout     = 5 * time.Second        // node loop should take the op in time
+	adminShutdownDelay = 100 * time.Millisecond // the answer of shutdown is written before stop
+	maxAdminRequest    = 64 * 1024
+)
+
+// json-rpc 2.0 error codes
+const (
+	AdminErrParse          = -32700
+	AdminErrMethodNotFound = -32601
+	AdminErrInvalidParams  = -32602
+	AdminErrInternal       = -32603
+	AdminErrUnauthorized   = -32001
+)
+
+var (
+	errAdminTokenMissing   = errors.New("admin token required if not served on unix socket")
+	errAdminUnauthorized   = errors.New("admin token not valid")
+	errAdminMethodNotFound = errors.New("admin method not found")
+	errAdminParams         = errors.New("admin params not valid")
+	errAdminBusy           = errors.New("node busy, admin op not taken")
+	errAdminNotAbsPath     = errors.New("admin path should be absolute")
+)
+
+// AdminRequest is the json-rpc 2.0 request of admin apis, such as
+// {"jsonrpc":"2.0","id":1,"method":"admin_setLogLevel","params":["debug"]}
+type AdminRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  []string        `json:"params"`
+}
+
+// AdminResponse is the json-rpc 2.0 response of admin apis
+type AdminResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *AdminError     `json:"error,omitempty"`
+}
+
+// AdminError is the error of admin apis
+type AdminError struct {
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	ErrCode common.ErrorCode `json:"errCode,omitempty"` // stable code of error, see common.Error
+}
+
+// AdminPeer is the peer returned by admin_peers
+type AdminPeer struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	UserID    string `json:"userID"`
+	Connected bool   `json:"connected"`
+	Pending   int    `json:"pending"` // waves queued to write
+	Inbound   bool   `json:"inbound"` // learned by its handshake
+	Anchor    bool   `json:"anchor"`  // never evicted by peer policy
+
+	RTT        int64   `json:"rtt"`        // moving average of ping in ms, 0 if not measured
+	Throughput float64 `json:"throughput"` // moving average of msgs per second in sync ranges
+	Ranges     uint64  `json:"ranges"`     // sync ranges answered
+}
+
+// AdminHandle is the handle and its owner in space-time, returned by
+// admin_lookupHandle and admin_getHandle
+type AdminHandle struct {
+	Handle    string `json:"handle"`
+	UserID    string `json:"userID"`
+	SpaceTime string `json:"spacetime"`
+}
+
+type adminMethod func(params []string) (interface{}, error)
+
+// adminMethods return the methods of admin namespace, all methods are
+// called by name with string params
+func (n *Node) adminMethods() map[string]adminMethod {
+	return map[string]adminMethod{
+		"admin_peers":             n.adminPeers,
+		"admin_addPeer":           n.adminAddPeer,
+		"admin_removePeer":        n.adminRemovePeer,
+		"admin_bandwidth":         n.adminBandwidth,
+		"admin_setLogLevel":       n.adminSetLogLevel,
+		"admin_backup":            n.adminBackup,
+		"admin_shutdown":          n.adminShutdown,
+		"admin_apiKeys":           n.adminAPIKeys,
+		"admin_createAPIKey":      n.adminCreateAPIKey,
+		"admin_revokeAPIKey":      n.adminRevokeAPIKey,
+		"admin_relayPolicy":       n.adminRelayPolicy,
+		"admin_setRelayPolicy":    n.adminSetRelayPolicy,
+		"admin_reloadRelayPolicy": n.adminReloadRelayPolicy,
+		"admin_lookupHandle":      n.adminReadUniverse(n.adminLookupHandle),
+		"admin_getHandle":         n.adminReadUniverse(n.adminGetHandle),
+		"admin_notifications":     n.adminNotifications,
+		"admin_markRead":          n.adminMarkRead,
+		"admin_audit":             n.adminAudit,
+		"admin_exportAudit":       n.adminExportAudit,
+		"admin_draft":             n.adminDraft,
+		"admin_outbox":            n.adminOutbox,
+		"admin_outboxEvents":      n.adminOutboxEvents,
+		"admin_broadcastAcks":     n.adminBroadcastAcks,
+		"admin_announces":         n.adminAnnounces,
+		"admin_seen":              n.adminSeen,
+		"admin_syncStatus":        n.adminSyncStatus,
+		"admin_timeProof":         n.adminTimeProof,
+		"admin_deliveries":        n.adminDeliveries,
+		"admin_health":            n.adminHealth,
+		"admin_ready":             n.adminReady,
+		"admin_retention":         n.adminRetention,
+		"admin_enforceRetention":  n.adminEnforceRetention,
+		"admin_archive":           n.adminArchive,
+		"admin_archiveContents":   n.adminArchiveContents,
+		"admin_cluster":           n.adminCluster,
+		"admin_feeds":             n.adminFeeds,
+		"admin_anchor":            n.adminAnchor,
+		"admin_publishAnchor":     n.adminPublishAnchor,
+		"admin_verifyAnchors":     n.adminVerifyAnchors,
+		"admin_filter":            n.adminFilter,
+		"admin_trainFilter":       n.adminTrainFilter,
+		"admin_setLabel":          n.adminSetLabel,
+		"admin_removeLabel":       n.adminRemoveLabel,
+		"admin_labels":            n.adminLabels,
+		"admin_labeled":           n.adminLabeled,
+	}
+}
+
+// adminReadUniverse hold the read lock of universe while m called, same as
+// withUniverse of the read apis
+func (n *Node) adminReadUniverse(m adminMethod) adminMethod {
+	return func(params []string) (interface{}, error) {
+		n.msgLock.RLock()
+		defer n.msgLock.RUnlock()
+		return m(params)
+	}
+}
+
+// SetAdmin serve the admin apis on the listener, separated from the public
+// apis of local port. The token is required in the Authorization header as
+// "Bearer token", it can be empty only if listener is unix socket, which
+// is protected by the file permission
+func (n *Node) SetAdmin(l net.Listener, token string) error {
+	if token == "" && l.Addr().Network() != "unix" {
+		return errAdminTokenMissing
+	}
+	n.adminListener = l
+	n.adminToken = token
+	return nil
+}
+
+// Stop the node started by Run, same as the signal sent to Run
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() { close(n.stop) })
+}
+
+func (n *Node) runAdminServe() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPath, n.adminHandler)
+	if err := http.Serve(n.adminListener, mux); err != nil {
+		log.Info("Admin serve stopped", err)
+	}
+}
+
+func (n *Node) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !n.adminAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(adminFail(nil, AdminErrUnauthorized, errAdminUnauthorized))
+		return
+	}
+	var req AdminRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequest)).Decode(&req); err != nil {
+		json.NewEncoder(w).Encode(adminFail(nil, AdminErrParse, err))
+		return
+	}
+	json.NewEncoder(w).Encode(n.callAdmin(&req))
+}
+
+func (n *Node) adminAuthorized(r *http.Request) bool {
+	if n.adminToken == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(n.adminToken)) == 1 {
+		return true
+	}
+	// api key of admin role is accepted as token
+	return n.apiKeys.verify(token, RoleAdmin) == nil
+}
+
+func (n *Node) callAdmin(req *AdminRequest) *AdminResponse {
+	method, ok := n.adminMethods()[req.Method]
+	if !ok {
+		method, ok = n.pduMethods()[req.Method]
+	}
+	if !ok {
+		return adminFail(req.ID, AdminErrMethodNotFound, errAdminMethodNotFound)
+	}
+	log.Info("Admin call", req.Method, strings.Join(req.Params, " "))
+	result, err := method(req.Params)
+	switch err {
+	case nil:
+		return &AdminResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+	case errAdminParams, errAdminNotAbsPath, errParseNodeAddressFail, log.ErrLevelNotValid,
+		errRoleNotValid, errRateNotValid, errAPIKeyNotExist, errRelayActionNotValid,
+		core.ErrHandleNotValid, common.ErrHashNotValid, errPostRefNotFound,
+		errFilterMsgNotFound, errFilterLabelNotValid, filter.ErrContentNotText,
+		db.ErrLabelNotValid, errLabelTargetNotFound:
+		return adminFail(req.ID, AdminErrInvalidParams, err)
+	default:
+		return adminFail(req.ID, AdminErrInternal, err)
+	}
+}
+
+func adminFail(id json.RawMessage, code int, err error) *AdminResponse {
+	return &AdminResponse{JSONRPC: "2.0", ID: id, Error: &AdminError{Code: code, Message: err.Error(), ErrCode: common.CodeOf(err)}}
+}
+
+// adminPeers return all peers of node
+func (n *Node) adminPeers(params []string) (interface{}, error) {
+	peers := []*AdminPeer{}
+	for k, p := range n.copyPeers() {
+		stat := n.stats.get(k)
+		peers = append(peers, &AdminPeer{
+			ID:         common.Hash2String(k),
+			URL:        p.Url(),
+			UserID:     common.Hash2String(p.UserID),
+			Connected:  p.Connected(),
+			Pending:    p.Pending(),
+			Inbound:    n.isInbound(k),
+			Anchor:     n.peerPolicy != nil && n.peerPolicy.isAnchor(p),
+			RTT:        int64(stat.rtt / time.Millisecond),
+			Throughput: stat.throughput,
+			Ranges:     stat.answered,
+		})
+	}
+	return peers, nil
+}
+
+// adminBandwidth return the bytes and rates sent and received by node in
+// total and by each conn open
+func (n *Node) adminBandwidth(params []string) (interface{}, error) {
+	return n.Bandwidth(), nil
+}
+
+// adminAddPeer add the peers [userid@ip:port/nodeKey], the node loop dial
+// them in next round
+func (n *Node) adminAddPeer(params []string) (interface{}, error) {
+	if len(params) == 0 {
+		return nil, errAdminParams
+	}
+	if err := n.SetNodes(strings.Join(params, ",")); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminRemovePeer close and remove the peer by ID, peers are removed by
+// node loop, so the sync and loop counters of peer are cleared together
+func (n *Node) adminRemovePeer(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	k, err := common.HashFromString(params[0])
+	if err != nil {
+		return nil, errAdminParams
+	}
+	if _, err := n.getPeer(k); err != nil {
+		return nil, err
+	}
+	select {
+	case n.adminRemove <- k:
+		return true, nil
+	case <-time.After(adminOpTimeout):
+		return nil, errAdminBusy
+	}
+}
+
+// adminSetLogLevel set the max log level printed, return the level before
+func (n *Node) adminSetLogLevel(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	lvl, err := log.ParseLevel(params[0])
+	if err != nil {
+		return nil, err
+	}
+	old := log.LevelName(log.Level())
+	log.SetLevel(lvl)
+	return old, nil
+}
+
+// adminBackup backup the db into dir on node host, full snapshot first and
+// delta of today after, same as pdu backup
+func (n *Node) adminBackup(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	if !filepath.IsAbs(params[0]) {
+		return nil, errAdminNotAbsPath
+	}
+	return backup.Backup(&lockedSource{src: backup.NewLocalSource(n.udb), lock: n.storeLock}, params[0])
+}
+
+// adminShutdown stop the node after the answer is written
+func (n *Node) adminShutdown(params []string) (interface{}, error) {
+	time.AfterFunc(adminShutdownDelay, n.Stop)
+	return true, nil
+}
+
+// adminAPIKeys return all api keys, without secrets
+func (n *Node) adminAPIKeys(params []string) (interface{}, error) {
+	return n.APIKeys(), nil
+}
+
+// adminCreateAPIKey create the api key by [role, rate, burst], rate and
+// burst are optional, return the key which can not be got again
+func (n *Node) adminCreateAPIKey(params []string) (interface{}, error) {
+	if len(params) == 0 || len(params) > 3 {
+		return nil, errAdminParams
+	}
+	var rate float64
+	var burst int
+	var err error
+	if len(params) > 1 {
+		if rate, err = strconv.ParseFloat(params[1], 64); err != nil {
+			return nil, errRateNotValid
+		}
+	}
+	if len(params) > 2 {
+		if burst, err = strconv.Atoi(params[2]); err != nil {
+			return nil, errRateNotValid
+		}
+	}
+	key, info, err := n.CreateAPIKey(params[0], rate, burst)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"key": key, "info": info}, nil
+}
+
+// adminRevokeAPIKey revoke the api key by ID
+func (n *Node) adminRevokeAPIKey(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	if err := n.RevokeAPIKey(params[0]); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminRelayPolicy return the relay policy in use and the counts of msgs
+func (n *Node) adminRelayPolicy(params []string) (interface{}, error) {
+	policy, stats := n.RelayPolicy()
+	return map[string]interface{}{"policy": policy, "stats": stats}, nil
+}
+
+// adminSetRelayPolicy replace the relay policy by [json], the policy is
+// not written into the policy file
+func (n *Node) adminSetRelayPolicy(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	var policy RelayPolicy
+	if err := json.Unmarshal([]byte(params[0]), &policy); err != nil {
+		return nil, errAdminParams
+	}
+	if err := n.SetRelayPolicy(&policy); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminReloadRelayPolicy read the relay policy file set at start again
+func (n *Node) adminReloadRelayPolicy(params []string) (interface{}, error) {
+	if err := n.ReloadRelayPolicy(); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminSpaceTime return the space-time in params, primary space-time of
+// universe if not given
+func (n *Node) adminSpaceTime(params []string) (common.Hash, error) {
+	if len(params) > 0 {
+		return common.ParseUserID(params[0])
+	}
+	return n.universe.GetPrimarySpaceTime(), nil
+}
+
+// adminLookupHandle return the owner of handle by [handle, spacetime]
+func (n *Node) adminLookupHandle(params []string) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, errAdminParams
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	handle, err := core.NormalizeHandle(params[0])
+	if err != nil {
+		return nil, err
+	}
+	stID, err := n.adminSpaceTime(params[1:])
+	if err != nil {
+		return nil, err
+	}
+	userID, ok := n.universe.LookupHandle(handle, stID)
+	if !ok {
+		return nil, errHandleNotExist
+	}
+	return &AdminHandle{Handle: handle, UserID: common.Hash2String(userID), SpaceTime: common.Hash2String(stID)}, nil
+}
+
+// adminGetHandle return the handle owned by user by [userid, spacetime]
+func (n *Node) adminGetHandle(params []string) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, errAdminParams
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	userID, err := common.ParseUserID(params[0])
+	if err != nil {
+		return nil, err
+	}
+	stID, err := n.adminSpaceTime(params[1:])
+	if err != nil {
+		return nil, err
+	}
+	handle, ok := n.universe.GetHandle(userID, stID)
+	if !ok {
+		return nil, errHandleNotExist
+	}
+	return &AdminHandle{Handle: handle, UserID: common.Hash2String(userID), SpaceTime: common.Hash2String(stID)}, nil
+}
+
+// adminNotifications return the notifications of local user by
+// [userid, unread, limit], all notifications are returned if unread is
+// not true, limit is DefaultNotifyLimit if not given
+func (n *Node) adminNotifications(params []string) (interface{}, error) {
+	if len(params) < 1 || len(params) > 3 {
+		return nil, errAdminParams
+	}
+	userID, err := common.ParseUserID(params[0])
+	if err != nil {
+		return nil, err
+	}
+	unreadOnly, limit := false, DefaultNotifyLimit
+	if len(params) > 1 {
+		if unreadOnly, err = strconv.ParseBool(params[1]); err != nil {
+			return nil, errAdminParams
+		}
+	}
+	if len(params) > 2 {
+		if limit, err = strconv.Atoi(params[2]); err != nil || limit <= 0 {
+			return nil, errAdminParams
+		}
+	}
+	return n.GetNotifications(userID, unreadOnly, limit)
+}
+
+// adminMarkRead mark the notifications of local user as read by
+// [userid, id...], all notifications are marked if no id given
+func (n *Node) adminMarkRead(params []string) (interface{}, error) {
+	if len(params) < 1 {
+		return nil, errAdminParams
+	}
+	userID, err := common.ParseUserID(params[0])
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, param := range params[1:] {
+		id, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return nil, errAdminParams
+		}
+		ids = append(ids, id)
+	}
+	return n.MarkRead(userID, ids...)
+}
+
+// adminAudit return the audit log by [from, limit, kind], all params are
+// optional, entries of all kinds from the first one are returned by default
+func (n *Node) adminAudit(params []string) (interface{}, error) {
+	if len(params) > 3 {
+		return nil, errAdminParams
+	}
+	from, limit, kind := uint64(1), DefaultAuditLimit, ""
+	var err error
+	if len(params) > 0 {
+		if from, err = strconv.ParseUint(params[0], 10, 64); err != nil {
+			return nil, errAdminParams
+		}
+	}
+	if len(params) > 1 {
+		if limit, err = strconv.Atoi(params[1]); err != nil || limit <= 0 {
+			return nil, errAdminParams
+		}
+	}
+	if len(params) > 2 {
+		kind = params[2]
+	}
+	return n.GetAuditLog(from, limit, kind)
+}
+
+// adminExportAudit write the audit log into [path] as json lines, the path
+// should be absolute and not exist
+func (n *Node) adminExportAudit(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	if !filepath.IsAbs(params[0]) {
+		return nil, errAdminNotAbsPath
+	}
+	return n.ExportAuditLog(params[0])
+}
+
+// adminDraft queue the msg by [contentType, content] into outbox of the
+// user unlocked by node, the content is text of msg value
+func (n *Node) adminDraft(params []string) (interface{}, error) {
+	if len(params) != 2 {
+		return nil, errAdminParams
+	}
+	contentType, err := strconv.Atoi(params[0])
+	if err != nil {
+		return nil, errAdminParams
+	}
+	return n.Draft(&core.MsgValue{ContentType: contentType, Content: []byte(params[1])})
+}
+
+// adminOutbox return the outbox items by [status], status is optional
+func (n *Node) adminOutbox(params []string) (interface{}, error) {
+	if len(params) > 1 {
+		return nil, errAdminParams
+	}
+	status := ""
+	if len(params) > 0 {
+		status = params[0]
+	}
+	return n.GetOutbox(status)
+}
+
+// adminOutboxEvents return the status transitions of outbox items by
+// [from, limit], all params are optional, clients poll with the id after
+// the last event seen
+func (n *Node) adminOutboxEvents(params []string) (interface{}, error) {
+	if len(params) > 2 {
+		return nil, errAdminParams
+	}
+	from, limit := uint64(1), DefaultOutboxEventLimit
+	var err error
+	if len(params) > 0 {
+		if from, err = strconv.ParseUint(params[0], 10, 64); err != nil {
+			return nil, errAdminParams
+		}
+	}
+	if len(params) > 1 {
+		if limit, err = strconv.Atoi(params[1]); err != nil || limit <= 0 {
+			return nil, errAdminParams
+		}
+	}
+	return n.GetOutboxEvents(from, limit)
+}
+
+// adminBroadcastAcks return the count of msgs broadcast with ack asked,
+// null if ack of peers not enabled
+func (n *Node) adminBroadcastAcks(params []string) (interface{}, error) {
+	return n.BroadcastAckStats(), nil
+}
+
+// adminAnnounces return the count of msgs announced and asked, null if
+// announcement not enabled
+func (n *Node) adminAnnounces(params []string) (interface{}, error) {
+	return n.AnnounceStats(), nil
+}
+
+// adminSeen return the count of msgs suppressed by the window of msgs seen
+func (n *Node) adminSeen(params []string) (interface{}, error) {
+	return n.SeenStats(), nil
+}
+
+// adminSyncStatus return the progress of sync and whether the local view
+// is current compared with peers
+func (n *Node) adminSyncStatus(params []string) (interface{}, error) {
+	return n.SyncStatus(), nil
+}
+
+// adminTimeProof return the schedule of time proof msgs, and the failures
+// if alert raised
+func (n *Node) adminTimeProof(params []string) (interface{}, error) {
+	return n.TimeProofStats(), nil
+}
+
+// adminDeliveries return the msgs of local user sent but not acked by any
+// peer yet
+func (n *Node) adminDeliveries(params []string) (interface{}, error) {
+	return n.GetDeliveries()
+}
+
+// adminHealth answer ok while the process is up, same as /healthz
+func (n *Node) adminHealth(params []string) (interface{}, error) {
+	return "ok", nil
+}
+
+// adminReady return the readiness of node, same as /readyz
+func (n *Node) adminReady(params []string) (interface{}, error) {
+	return n.Ready(), nil
+}
+
+// adminRetention return the retention policy in use and its total result
+func (n *Node) adminRetention(params []string) (interface{}, error) {
+	return map[string]interface{}{"policy": n.RetentionPolicy(), "stats": n.RetentionStats()}, nil
+}
+
+// adminEnforceRetention enforce the retention policy now
+func (n *Node) adminEnforceRetention(params []string) (interface{}, error) {
+	return n.EnforceRetention(), nil
+}
+
+// adminArchive return the result of contents archived into cold storage
+func (n *Node) adminArchive(params []string) (interface{}, error) {
+	return n.ArchiveStats(), nil
+}
+
+// adminArchiveContents archive the contents of old msgs now
+func (n *Node) adminArchiveContents(params []string) (interface{}, error) {
+	return n.ArchiveContents()
+}
+
+// adminCluster return the role of node in cluster, and the progress of replica
+func (n *Node) adminCluster(params []string) (interface{}, error) {
+	return n.ClusterStatus(), nil
+}
+
+// adminFeeds materialize the next batch of space-time feeds, and return
+// the progress of them
+func (n *Node) adminFeeds(params []string) (interface{}, error) {
+	return n.MaterializeFeeds()
+}
+
+// adminAnchor return the result of checkpoints anchored to chain
+func (n *Node) adminAnchor(params []string) (interface{}, error) {
+	return n.AnchorStats(), nil
+}
+
+// adminPublishAnchor publish the latest checkpoint to chain now
+func (n *Node) adminPublishAnchor(params []string) (interface{}, error) {
+	return n.PublishAnchor()
+}
+
+// adminVerifyAnchors verify the anchors published before, return the count
+// of anchors verified
+func (n *Node) adminVerifyAnchors(params []string) (interface{}, error) {
+	return n.VerifyAnchors()
+}
+
+// adminFilter return the count of msgs by verdict of filter, and the msgs
+// flagged for review
+func (n *Node) adminFilter(params []string) (interface{}, error) {
+	return n.FilterStats(), nil
+}
+
+// adminTrainFilter mark the msg as spam or not by [msgID, spam|ham], the
+// filter learn from it
+func (n *Node) adminTrainFilter(params []string) (interface{}, error) {
+	if len(params) != 2 {
+		return nil, errAdminParams
+	}
+	msgID, err := common.HashFromString(params[0])
+	if err != nil {
+		return nil, err
+	}
+	var spam bool
+	switch params[1] {
+	case "spam":
+		spam = true
+	case "ham":
+	default:
+		return nil, errFilterLabelNotValid
+	}
+	if err := n.TrainFilter(msgID, spam); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminSetLabel label the msg or user by [id, label], label is spam,
+// favorite or hidden for msg, trusted or blocked for user, id is hex or
+// address (pdu1...) of user
+func (n *Node) adminSetLabel(params []string) (interface{}, error) {
+	if len(params) != 2 {
+		return nil, errAdminParams
+	}
+	target, err := common.ParseUserID(params[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := n.SetLabel(target, params[1]); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminRemoveLabel remove the label of msg or user by [id, label]
+func (n *Node) adminRemoveLabel(params []string) (interface{}, error) {
+	if len(params) != 2 {
+		return nil, errAdminParams
+	}
+	target, err := common.ParseUserID(params[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := n.RemoveLabel(target, params[1]); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// adminLabels return the labels of msg or user by [id]
+func (n *Node) adminLabels(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	target, err := common.ParseUserID(params[0])
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, n.GetLabels(target)...), nil
+}
+
+// adminLabeled return the msgs or users with label by [label]
+func (n *Node) adminLabeled(params []string) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errAdminParams
+	}
+	return n.GetLabeled(params[0])
+}
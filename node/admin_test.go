@@ -0,0 +1,266 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/db/tier"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+// memStore is the object store in memory for archive
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memStore) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte{}, data...)
+	return nil
+}
+
+func (s *memStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key], nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// memChain is the chain in memory for anchor
+type memChain struct {
+	txs map[string][]byte
+}
+
+func (c *memChain) Name() string {
+	return "mem"
+}
+
+func (c *memChain) Publish(payload []byte) (string, error) {
+	txID := strconv.Itoa(len(c.txs))
+	c.txs[txID] = payload
+	return txID, nil
+}
+
+func (c *memChain) Lookup(txID string) ([]byte, error) {
+	return c.txs[txID], nil
+}
+
+// callAdminHTTP call the admin method with token as the client of admin listener
+func callAdminHTTP(t *testing.T, n *Node, token, method string, params ...string) (*AdminResponse, int) {
+	reqBytes, _ := json.Marshal(&AdminRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: params})
+	req := httptest.NewRequest(http.MethodPost, AdminPath, bytes.NewReader(reqBytes))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	n.adminHandler(w, req)
+	var res AdminResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(method, w.Code, w.Body.String())
+	}
+	return &res, w.Code
+}
+
+func TestNode_AdminMethods(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := bolt.NewDB(filepath.Join(dir, "pdu.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	roots, keys := initTestUDB(t, local, common.HasherSHA256)
+	udb, err := tier.New(local, &memStore{objects: make(map[string][]byte)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := New(udb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.adminToken = "secret"
+	defer log.SetLevel(log.Level())
+
+	// features called by admin methods
+	n.SetCheckpointSigner(roots[0], keys[0])
+	n.SetNotifyUsers(roots[0].ID())
+	n.SetAnchor(&memChain{txs: make(map[string][]byte)}, 0)
+	if err := n.SetArchive(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.LoadFilter(filepath.Join(dir, "bayes.json")); err != nil {
+		t.Fatal(err)
+	}
+	relayFile := filepath.Join(dir, "relay.json")
+	if err := ioutil.WriteFile(relayFile, []byte(`{"default":"relay"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.LoadRelayPolicy(relayFile); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := n.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, _ := json.Marshal(&core.ContentNameClaim{Handle: "alice"})
+	if _, err := n.Post(&core.MsgValue{ContentType: core.TypeNameClaim, Content: claim}); err != nil {
+		t.Fatal(err)
+	}
+	readKey, readInfo, err := n.CreateAPIKey(RoleRead, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminKey, _, err := n.CreateAPIKey(RoleAdmin, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all methods require the token or api key of admin role
+	methods := n.adminMethods()
+	for name, method := range n.pduMethods() {
+		methods[name] = method
+	}
+	for name := range methods {
+		for _, token := range []string{"", "wrong", readKey} {
+			res, code := callAdminHTTP(t, n, token, name)
+			if code != http.StatusUnauthorized || res.Error == nil || res.Error.Code != AdminErrUnauthorized {
+				t.Errorf("%s should be unauthorized by token %q, but get %d", name, token, code)
+			}
+		}
+	}
+	if res, code := callAdminHTTP(t, n, adminKey, "admin_health"); code != http.StatusOK || res.Error != nil {
+		t.Error("api key of admin role should be authorized", code, res.Error)
+	}
+
+	rootID := common.Hash2String(roots[0].ID())
+	msgID := common.Hash2String(msg.ID())
+	p, _ := peer.New("127.0.0.1", 30000, "nodekey")
+	go func() { <-n.adminRemove }()
+	cases := []struct {
+		method string
+		params []string
+	}{
+		{"admin_peers", nil},
+		{"admin_addPeer", []string{rootID + "@127.0.0.1:30000/nodekey"}},
+		{"admin_removePeer", []string{common.Hash2String(p.ID())}},
+		{"admin_bandwidth", nil},
+		{"admin_setLogLevel", []string{"info"}},
+		{"admin_backup", []string{filepath.Join(dir, "backup")}},
+		{"admin_apiKeys", nil},
+		{"admin_createAPIKey", []string{RoleSubmit, "1", "2"}},
+		{"admin_revokeAPIKey", []string{readInfo.ID}},
+		{"admin_relayPolicy", nil},
+		{"admin_setRelayPolicy", []string{`{"default":"drop"}`}},
+		{"admin_reloadRelayPolicy", nil},
+		{"admin_lookupHandle", []string{"@alice", rootID}},
+		{"admin_getHandle", []string{rootID, rootID}},
+		{"admin_notifications", []string{rootID, "true", "10"}},
+		{"admin_markRead", []string{rootID}},
+		{"admin_audit", []string{"1", "10"}},
+		{"admin_exportAudit", []string{filepath.Join(dir, "audit.jsonl")}},
+		{"admin_draft", []string{strconv.Itoa(core.TypeText), "draft"}},
+		{"admin_outbox", []string{db.OutboxQueued}},
+		{"admin_outboxEvents", []string{"1", "10"}},
+		{"admin_broadcastAcks", nil},
+		{"admin_announces", nil},
+		{"admin_seen", nil},
+		{"admin_syncStatus", nil},
+		{"admin_timeProof", nil},
+		{"admin_deliveries", nil},
+		{"admin_health", nil},
+		{"admin_ready", nil},
+		{"admin_retention", nil},
+		{"admin_enforceRetention", nil},
+		{"admin_archive", nil},
+		{"admin_archiveContents", nil},
+		{"admin_cluster", nil},
+		{"admin_feeds", nil},
+		{"admin_anchor", nil},
+		{"admin_publishAnchor", nil},
+		{"admin_verifyAnchors", nil},
+		{"admin_filter", nil},
+		{"admin_trainFilter", []string{msgID, "ham"}},
+		{"admin_setLabel", []string{msgID, db.LabelFavorite}},
+		{"admin_labels", []string{msgID}},
+		{"admin_labeled", []string{db.LabelFavorite}},
+		{"admin_removeLabel", []string{msgID, db.LabelFavorite}},
+		{"pdu_post", []string{"posted", msgID}},
+		{"admin_shutdown", nil},
+	}
+	results := make(map[string]interface{})
+	for _, c := range cases {
+		res, code := callAdminHTTP(t, n, "secret", c.method, c.params...)
+		if code != http.StatusOK || res.Error != nil {
+			t.Errorf("call %s fail %d %+v", c.method, code, res.Error)
+			continue
+		}
+		results[c.method] = res.Result
+	}
+	for name := range methods {
+		if _, ok := results[name]; !ok {
+			t.Error("method should be called successfully", name)
+		}
+	}
+
+	if handle, ok := results["admin_lookupHandle"].(map[string]interface{}); !ok || handle["userID"] != rootID {
+		t.Error("owner of handle not match", results["admin_lookupHandle"])
+	}
+	if handle, ok := results["admin_getHandle"].(map[string]interface{}); !ok || handle["handle"] != "alice" {
+		t.Error("handle of user not match", results["admin_getHandle"])
+	}
+	if labels, ok := results["admin_labels"].([]interface{}); !ok || len(labels) != 1 || labels[0] != db.LabelFavorite {
+		t.Error("label of msg not match", results["admin_labels"])
+	}
+	if policy, _ := n.RelayPolicy(); policy.Default != RelayAllow {
+		t.Error("relay policy should be reloaded from file", policy.Default)
+	}
+	if len(n.APIKeys()) != 3 || n.apiKeys.verify(readKey, RoleRead) == nil {
+		t.Error("api key should be created and revoked")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "audit.jsonl")); err != nil {
+		t.Error("audit log should be exported", err)
+	}
+	if items, err := n.GetOutbox(db.OutboxQueued); err != nil || len(items) != 1 {
+		t.Error("draft should be queued", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/anchor"
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// DefaultAnchorInterval is the interval checkpoints are published to chain
+	DefaultAnchorInterval = time.Hour
+	// anchorBatch is the max number of anchors loaded at once
+	anchorBatch = 1000
+)
+
+var (
+	errAnchorNotEnabled = errors.New("anchor not enabled")
+	errAnchorNotMatch   = errors.New("anchor not match")
+)
+
+// AnchorStats is the result of checkpoints published and verified
+type AnchorStats struct {
+	Chain     string         `json:"chain"`
+	Published int            `json:"published"`
+	Verified  int            `json:"verified"`
+	Mismatch  int            `json:"mismatch"`
+	Last      *anchor.Anchor `json:"last,omitempty"`
+}
+
+// anchorer publish the checkpoints to chain in background
+type anchorer struct {
+	mu       sync.Mutex
+	chain    anchor.Chain
+	interval time.Duration
+	stats    AnchorStats
+	lastRun  time.Time
+}
+
+// SetAnchor publish the latest checkpoint of primary space-time to chain
+// every interval, DefaultAnchorInterval if 0. Should be set before Run.
+func (n *Node) SetAnchor(chain anchor.Chain, interval time.Duration) {
+	if interval == 0 {
+		interval = DefaultAnchorInterval
+	}
+	n.anchorer.mu.Lock()
+	defer n.anchorer.mu.Unlock()
+	n.anchorer.chain = chain
+	n.anchorer.interval = interval
+	n.anchorer.stats.Chain = chain.Name()
+}
+
+// AnchorStats return the total result of checkpoints anchored
+func (n Node) AnchorStats() AnchorStats {
+	n.anchorer.mu.Lock()
+	defer n.anchorer.mu.Unlock()
+	return n.anchorer.stats
+}
+
+// anchorEnabled return true if chain is set
+func (n Node) anchorEnabled() bool {
+	n.anchorer.mu.Lock()
+	defer n.anchorer.mu.Unlock()
+	return n.anchorer.chain != nil
+}
+
+// checkAnchor publish the checkpoint if interval passed since last run
+func (n Node) checkAnchor() {
+	n.anchorer.mu.Lock()
+	due := n.anchorer.chain != nil && time.Since(n.anchorer.lastRun) >= n.anchorer.interval
+	n.anchorer.mu.Unlock()
+	if due {
+		if _, err := n.PublishAnchor(); err != nil {
+			log.Error("Publish anchor fail", err)
+		}
+	}
+}
+
+// PublishAnchor publish the latest checkpoint of primary space-time to chain
+// now, nil if no checkpoint or the checkpoint already published
+func (n Node) PublishAnchor() (*anchor.Anchor, error) {
+	n.anchorer.mu.Lock()
+	defer n.anchorer.mu.Unlock()
+	if n.anchorer.chain == nil {
+		return nil, errAnchorNotEnabled
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	n.anchorer.lastRun = time.Now()
+	n.msgLock.RLock()
+	stID := n.universe.GetPrimarySpaceTime()
+	seq := n.universe.GetMaxSeq(stID) / n.cpInterval * n.cpInterval
+	n.msgLock.RUnlock()
+	if seq == 0 {
+		return nil, nil
+	}
+	n.storeLock.RLock()
+	cp, err := db.GetCheckpoint(n.udb, stID, seq)
+	var published *anchor.Anchor
+	if err == nil && cp != nil {
+		published, err = db.GetAnchor(n.udb, stID, seq)
+	}
+	n.storeLock.RUnlock()
+	if err != nil || cp == nil || published != nil {
+		return nil, err
+	}
+
+	a, err := anchor.Publish(n.anchorer.chain, cp)
+	if err != nil {
+		return nil, err
+	}
+	n.storeLock.Lock()
+	err = db.SaveAnchor(n.udb, a)
+	n.storeLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Checkpoint", seq, "of space-time", common.Hash2String(stID), "anchored by", a.Chain, a.TxID)
+	n.anchorer.stats.Published++
+	n.anchorer.stats.Last = a
+	return a, nil
+}
+
+// VerifyAnchors check the anchors published before are still on chain, and
+// match the state root of local universe, errAnchorNotMatch is returned if any
+// of them not match, which means the local db or the chain be tampered.
+func (n Node) VerifyAnchors() (int, error) {
+	n.anchorer.mu.Lock()
+	defer n.anchorer.mu.Unlock()
+	if n.anchorer.chain == nil {
+		return 0, errAnchorNotEnabled
+	}
+	if n.universe == nil {
+		return 0, errUniverseNotExist
+	}
+	verified, mismatch := 0, 0
+	for skip := 0; ; skip += anchorBatch {
+		n.storeLock.RLock()
+		anchors, err := db.GetAnchors(n.udb, skip, anchorBatch)
+		n.storeLock.RUnlock()
+		if err != nil {
+			return verified, err
+		}
+		for _, a := range anchors {
+			if a.Chain != n.anchorer.chain.Name() {
+				continue
+			}
+			ok, err := anchor.Verify(n.anchorer.chain, a)
+			if err != nil && err != anchor.ErrTxNotFound {
+				return verified, err
+			}
+			if ok {
+				n.msgLock.RLock()
+				root, err := n.universe.StateRoot(a.SpaceTimeID, a.Seq)
+				n.msgLock.RUnlock()
+				ok = err == nil && root == a.StateRoot
+			}
+			if !ok {
+				log.Error("Anchor of checkpoint", a.Seq, "of space-time", common.Hash2String(a.SpaceTimeID), "not match", a.Chain, a.TxID)
+				mismatch++
+				continue
+			}
+			verified++
+		}
+		if len(anchors) < anchorBatch {
+			break
+		}
+	}
+	n.anchorer.stats.Verified = verified
+	n.anchorer.stats.Mismatch = mismatch
+	if mismatch > 0 {
+		return verified, errAnchorNotMatch
+	}
+	return verified, nil
+}
+
+// verifyAnchors verify the anchors on start
+func (n Node) verifyAnchors() {
+	if verified, err := n.VerifyAnchors(); err != nil {
+		log.Error("Verify anchors fail", err)
+	} else {
+		log.Info("All", verified, "anchors verified")
+	}
+}
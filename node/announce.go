@@ -0,0 +1,240 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultAnnounceTimeout is the time waiting for the msgs asked from the
+// peer announced them, then the msgs can be asked from other peers
+const DefaultAnnounceTimeout = time.Second * 5
+
+var errAnnounceNotFound = errors.New("announcement not found")
+
+// AnnounceStats is the count of msgs announced and asked between peers
+type AnnounceStats struct {
+	Announced uint64 `json:"announced"` // msg IDs announced to peers
+	Asked     uint64 `json:"asked"`     // msg IDs missing and asked from peers
+	Known     uint64 `json:"known"`     // msg IDs announced by peers already exist or being asked
+	Served    uint64 `json:"served"`    // msgs sent to peers asked
+}
+
+// announcement is the inv wave sent to peer, the peer ask the msgs by the
+// wave ID of it
+type announcement struct {
+	peerKey  common.Hash
+	deadline time.Time
+}
+
+// announcer track the inv waves sent to peers and the msgs asked from peers,
+// so the msg announced by many peers is only asked from one of them
+type announcer struct {
+	lock    sync.Mutex
+	enable  bool
+	timeout time.Duration
+	sent    map[common.Hash]*announcement // wave ID : inv sent to peer
+	asking  map[common.Hash]time.Time     // msg ID : deadline of msg asked
+	stats   AnnounceStats
+}
+
+func newAnnouncer() *announcer {
+	return &announcer{
+		timeout: DefaultAnnounceTimeout,
+		sent:    make(map[common.Hash]*announcement),
+		asking:  make(map[common.Hash]time.Time),
+	}
+}
+
+func (a *announcer) enabled() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.enable
+}
+
+func (a *announcer) add(waveID, peerKey common.Hash, count int, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sent[waveID] = &announcement{peerKey: peerKey, deadline: now.Add(a.timeout)}
+	a.stats.Announced += uint64(count)
+}
+
+// peerOf return the key of peer which the inv wave sent to
+func (a *announcer) peerOf(waveID common.Hash) (common.Hash, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	an, ok := a.sent[waveID]
+	if !ok {
+		return common.Hash{}, false
+	}
+	return an.peerKey, true
+}
+
+// ask return the msgs announced which not exist and not being asked from
+// other peers, they are marked as being asked until timeout
+func (a *announcer) ask(msgIDs []common.Hash, has func(common.Hash) bool, now time.Time) []common.Hash {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var missing []common.Hash
+	for _, msgID := range msgIDs {
+		if deadline, ok := a.asking[msgID]; (ok && now.Before(deadline)) || has(msgID) {
+			a.stats.Known++
+			continue
+		}
+		a.asking[msgID] = now.Add(a.timeout)
+		missing = append(missing, msgID)
+	}
+	a.stats.Asked += uint64(len(missing))
+	return missing
+}
+
+func (a *announcer) served(count int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.stats.Served += uint64(count)
+}
+
+// expire forget the inv waves and msgs asked after timeout
+func (a *announcer) expire(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for waveID, an := range a.sent {
+		if now.After(an.deadline) {
+			delete(a.sent, waveID)
+		}
+	}
+	for msgID, deadline := range a.asking {
+		if now.After(deadline) {
+			delete(a.asking, msgID)
+		}
+	}
+}
+
+func (a *announcer) copyStats() *AnnounceStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	stats := a.stats
+	return &stats
+}
+
+// EnableAnnounce announce the IDs of msgs broadcast to peers instead of the
+// full msgs, peers ask the msgs they missing, so the msgs gossiped in well
+// connected nodes are not transmitted repeatedly. Ack of broadcast is not
+// used for the msgs announced. All peers should support announcement.
+func (n *Node) EnableAnnounce(timeout time.Duration) {
+	n.announcer.lock.Lock()
+	defer n.announcer.lock.Unlock()
+	n.announcer.enable = true
+	if timeout > 0 {
+		n.announcer.timeout = timeout
+	}
+}
+
+// AnnounceStats return the count of msgs announced and asked, nil if not
+// enabled
+func (n Node) AnnounceStats() *AnnounceStats {
+	if !n.announcer.enabled() {
+		return nil
+	}
+	return n.announcer.copyStats()
+}
+
+// sendInv announce the msg to peer
+func (n Node) sendInv(k common.Hash, p *peer.Peer, msg *core.Message) error {
+	waveID := common.CreateHash()
+	n.announcer.add(waveID, k, 1, time.Now())
+	return p.SendInv(waveID, msg.ID())
+}
+
+// checkAnnounces forget the announcements not answered in time
+func (n Node) checkAnnounces() {
+	n.announcer.expire(time.Now())
+}
+
+// handleInv ask the msgs announced which local node missing, the msgs are
+// answered by the peer with same wave ID. The inv not from ws is ignored,
+// as the msgs sent back by peer are received by ws.
+func (n *Node) handleInv(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wi := w.(*galaxy.WaveInv)
+	if ws == nil || !n.wsAcceptMsg {
+		return wi.WaveID, nil
+	}
+	if n.universe == nil {
+		return wi.WaveID, errUniverseNotExist
+	}
+	n.msgLock.RLock()
+	missing := n.announcer.ask(wi.MsgIDs, n.universe.HasMsg, time.Now())
+	n.msgLock.RUnlock()
+	if len(missing) == 0 {
+		return wi.WaveID, nil
+	}
+	p := n.wsPeer(ws)
+	return wi.WaveID, p.SendGetMsgs(wi.WaveID, missing...)
+}
+
+// handleGetMsgs send the msgs asked back to the peer, by ws if asked from
+// ws, otherwise by the peer which the inv of same wave ID sent to. The msgs
+// not found are skipped.
+func (n *Node) handleGetMsgs(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wg := w.(*galaxy.WaveGetMsgs)
+	var p *peer.Peer
+	if ws != nil {
+		p = n.wsPeer(ws)
+	} else {
+		k, ok := n.announcer.peerOf(wg.WaveID)
+		if !ok {
+			return wg.WaveID, errAnnounceNotFound
+		}
+		if p, ok = n.copyPeers()[k]; !ok {
+			return wg.WaveID, errAnnounceNotFound
+		}
+	}
+	msgIDs := wg.MsgIDs
+	if len(msgIDs) > peer.MaxInvCountPerWave {
+		msgIDs = msgIDs[:peer.MaxInvCountPerWave]
+	}
+	var msgs []*core.Message
+	for _, msgID := range msgIDs {
+		msg, err := db.GetMsg(n.udb, msgID)
+		if err != nil {
+			log.Trace("Msg asked not found", common.Hash2String(msgID), err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	for start := 0; start < len(msgs); start += peer.MaxMsgCountPerWave {
+		end := start + peer.MaxMsgCountPerWave
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		if err := p.SendMsgs(wg.WaveID, msgs[start:end]); err != nil {
+			return wg.WaveID, err
+		}
+		n.announcer.served(end - start)
+	}
+	return wg.WaveID, nil
+}
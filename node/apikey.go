@@ -0,0 +1,319 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+// Role is the access level of api key, a role can do all of roles before
+const (
+	// RoleRead can query the local apis
+	RoleRead = "read"
+	// RoleSubmit can also submit msgs by ws
+	RoleSubmit = "submit"
+	// RoleAdmin can also call the admin apis
+	RoleAdmin = "admin"
+)
+
+const (
+	// APIKeyHeader is the header of api key, or set it by query apikey=
+	// if header not supported, such as ws from browser
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyQuery     = "apikey"
+	apiKeyIDSize    = 8
+	apiKeySecretLen = 32
+)
+
+var (
+	errAPIKeyMissing     = errors.New("api key missing")
+	errAPIKeyNotValid    = errors.New("api key not valid")
+	errAPIKeyRevoked     = errors.New("api key revoked")
+	errAPIKeyRole        = errors.New("api key role not allowed")
+	errAPIKeyRateLimited = errors.New("api key rate limited")
+	errAPIKeyNotExist    = errors.New("api key not exist")
+	errRoleNotValid      = errors.New("role not valid")
+	errRateNotValid      = errors.New("rate limit not valid")
+)
+
+var roleRank = map[string]int{RoleRead: 1, RoleSubmit: 2, RoleAdmin: 3}
+
+// APIKey is the api key saved in db, only the hash of secret is saved, so
+// the key can not be shown again after created
+type APIKey struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash,omitempty"`
+	Role    string    `json:"role"`
+	Rate    float64   `json:"rate"`  // requests per second, 0 if not limited
+	Burst   int       `json:"burst"` // requests allowed at once
+	Created time.Time `json:"created"`
+	Revoked time.Time `json:"revoked,omitempty"`
+}
+
+// apiKeyStore check the api keys of requests. Requests without key are
+// allowed to read if public, and all requests are allowed if not enabled.
+type apiKeyStore struct {
+	mu       sync.Mutex
+	enabled  bool
+	public   bool
+	keys     map[string]*APIKey
+	limiters map[string]*rateLimiter
+}
+
+func newAPIKeyStore() *apiKeyStore {
+	return &apiKeyStore{keys: make(map[string]*APIKey), limiters: make(map[string]*rateLimiter)}
+}
+
+// rateLimiter is the token bucket of api key
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (l *rateLimiter) allow(now time.Time) bool {
+	if l.rate == 0 {
+		return true
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// EnableAPIKeys require api keys for local apis and msgs submitted by ws,
+// the local apis can still be queried without key if public
+func (n *Node) EnableAPIKeys(public bool) {
+	n.apiKeys.mu.Lock()
+	defer n.apiKeys.mu.Unlock()
+	n.apiKeys.enabled = true
+	n.apiKeys.public = public
+}
+
+// CreateAPIKey create the api key of role, rate is the requests per second
+// allowed with burst, 0 if not limited. The key returned is id.secret,
+// which can not be got again.
+func (n *Node) CreateAPIKey(role string, rate float64, burst int) (string, *APIKey, error) {
+	if _, ok := roleRank[role]; !ok {
+		return "", nil, errRoleNotValid
+	}
+	if rate < 0 || burst < 0 {
+		return "", nil, errRateNotValid
+	}
+	if rate > 0 && burst == 0 {
+		burst = int(rate) + 1
+	}
+	id, err := randomHex(apiKeyIDSize)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(apiKeySecretLen)
+	if err != nil {
+		return "", nil, err
+	}
+	key := &APIKey{ID: id, Hash: hashSecret(secret), Role: role, Rate: rate, Burst: burst, Created: time.Now().UTC()}
+	n.apiKeys.mu.Lock()
+	defer n.apiKeys.mu.Unlock()
+	n.apiKeys.keys[id] = key
+	if err := n.saveAPIKeys(); err != nil {
+		delete(n.apiKeys.keys, id)
+		return "", nil, err
+	}
+	info := *key
+	info.Hash = ""
+	return id + "." + secret, &info, nil
+}
+
+// RevokeAPIKey revoke the api key by ID, the key is kept in db as revoked
+func (n *Node) RevokeAPIKey(id string) error {
+	n.apiKeys.mu.Lock()
+	defer n.apiKeys.mu.Unlock()
+	key, ok := n.apiKeys.keys[id]
+	if !ok {
+		return errAPIKeyNotExist
+	}
+	if !key.Revoked.IsZero() {
+		return errAPIKeyRevoked
+	}
+	key.Revoked = time.Now().UTC()
+	delete(n.apiKeys.limiters, id)
+	if err := n.saveAPIKeys(); err != nil {
+		key.Revoked = time.Time{}
+		return err
+	}
+	return nil
+}
+
+// APIKeys return all api keys created, ordered by created time, the hash
+// of secret is not returned
+func (n *Node) APIKeys() []*APIKey {
+	n.apiKeys.mu.Lock()
+	defer n.apiKeys.mu.Unlock()
+	keys := make([]*APIKey, 0, len(n.apiKeys.keys))
+	for _, key := range n.apiKeys.keys {
+		info := *key
+		info.Hash = ""
+		keys = append(keys, &info)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys
+}
+
+// loadAPIKeys load the api keys saved in db
+func (n *Node) loadAPIKeys() error {
+	keysBytes, err := n.udb.Get(db.BucketConfig, db.ConfigAPIKeys)
+	if err != nil || keysBytes == nil {
+		return err
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(keysBytes, &keys); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		n.apiKeys.keys[key.ID] = key
+	}
+	return nil
+}
+
+// saveAPIKeys save all api keys into db, apiKeys.mu should be held
+func (n *Node) saveAPIKeys() error {
+	keys := make([]*APIKey, 0, len(n.apiKeys.keys))
+	for _, key := range n.apiKeys.keys {
+		keys = append(keys, key)
+	}
+	keysBytes, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return n.udb.Set(db.BucketConfig, db.ConfigAPIKeys, keysBytes)
+}
+
+// authorize check the key for the role if api keys enabled
+func (s *apiKeyStore) authorize(key, role string) error {
+	s.mu.Lock()
+	enabled, public := s.enabled, s.public
+	s.mu.Unlock()
+	if !enabled || (key == "" && public && role == RoleRead) {
+		return nil
+	}
+	return s.verify(key, role)
+}
+
+// verify check the key for the role, and count it into rate limit
+func (s *apiKeyStore) verify(key, role string) error {
+	if key == "" {
+		return errAPIKeyMissing
+	}
+	sep := strings.IndexByte(key, '.')
+	if sep < 0 {
+		return errAPIKeyNotValid
+	}
+	id, secret := key[:sep], key[sep+1:]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) != 1 {
+		return errAPIKeyNotValid
+	}
+	if !k.Revoked.IsZero() {
+		return errAPIKeyRevoked
+	}
+	if roleRank[k.Role] < roleRank[role] {
+		return errAPIKeyRole
+	}
+	l, ok := s.limiters[id]
+	if !ok {
+		l = newRateLimiter(k.Rate, k.Burst)
+		s.limiters[id] = l
+	}
+	if !l.allow(time.Now()) {
+		return errAPIKeyRateLimited
+	}
+	return nil
+}
+
+// apiKeyOf return the api key of request from header or query
+func apiKeyOf(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(apiKeyQuery)
+}
+
+// withRole only serve the requests with api key of role
+func (n *Node) withRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := n.apiKeys.authorize(apiKeyOf(r), role); err != nil {
+			http.Error(w, err.Error(), apiKeyStatus(err))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func apiKeyStatus(err error) int {
+	switch err {
+	case errAPIKeyRole:
+		return http.StatusForbidden
+	case errAPIKeyRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
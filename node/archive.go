@@ -0,0 +1,98 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// DefaultArchiveInterval is the interval old contents are archived
+	DefaultArchiveInterval = 10 * time.Minute
+	// DefaultArchiveKeep is the count of last msgs which content kept in local db
+	DefaultArchiveKeep = 100000
+	// archiveBatch is the max number of msgs checked in one run
+	archiveBatch = 10000
+)
+
+// ArchiveStats is the result of contents archived into cold storage
+type ArchiveStats struct {
+	Keep     uint64 `json:"keep"`
+	Archived int    `json:"archived"`
+	LastRun  int64  `json:"lastRun,omitempty"`
+}
+
+// archiver move the content of old msgs to cold storage in background
+type archiver struct {
+	mu      sync.Mutex
+	enable  bool
+	stats   ArchiveStats
+	lastRun time.Time
+}
+
+// SetArchive archive the content of msgs except the last keep msgs, the db
+// of node should be db.Archiver, such as tier.DB. Should be set before Run.
+func (n *Node) SetArchive(keep uint64) error {
+	if _, ok := n.udb.(db.Archiver); !ok {
+		return db.ErrArchiveNotSupported
+	}
+	n.archiver.mu.Lock()
+	defer n.archiver.mu.Unlock()
+	n.archiver.enable = true
+	n.archiver.stats.Keep = keep
+	return nil
+}
+
+// ArchiveStats return the total result of contents archived
+func (n Node) ArchiveStats() ArchiveStats {
+	n.archiver.mu.Lock()
+	defer n.archiver.mu.Unlock()
+	return n.archiver.stats
+}
+
+// checkArchive archive the contents if interval passed since last run
+func (n Node) checkArchive() {
+	n.archiver.mu.Lock()
+	due := n.archiver.enable && time.Since(n.archiver.lastRun) >= DefaultArchiveInterval
+	n.archiver.mu.Unlock()
+	if due {
+		if _, err := n.ArchiveContents(); err != nil {
+			log.Error("Archive contents fail", err)
+		}
+	}
+}
+
+// ArchiveContents move the contents of old msgs to cold storage now, return
+// the count of msgs which content archived in this run
+func (n Node) ArchiveContents() (int, error) {
+	n.archiver.mu.Lock()
+	defer n.archiver.mu.Unlock()
+	if !n.archiver.enable {
+		return 0, db.ErrArchiveNotSupported
+	}
+	n.storeLock.Lock()
+	archived, err := db.ArchiveContents(n.udb, n.archiver.stats.Keep, archiveBatch)
+	n.storeLock.Unlock()
+	n.archiver.lastRun = time.Now()
+	n.archiver.stats.Archived += archived
+	n.archiver.stats.LastRun = n.archiver.lastRun.Unix()
+	return archived, err
+}
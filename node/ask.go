@@ -18,6 +18,7 @@ package node
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/db"
@@ -25,13 +26,20 @@ import (
 )
 
 func (n *Node) askPeers(pid common.Hash) error {
-	p := n.peers[pid]
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
 	localPeerBytes, err := json.Marshal(n.localPeer())
 	if err != nil {
 		return err
 	}
 	waveID := common.CreateHash()
-	if err := p.SendQuestion(waveID, galaxy.CmdPeers, localPeerBytes); err != nil {
+	sig, err := n.identity.Sign(galaxy.SignedData(waveID, localPeerBytes))
+	if err != nil {
+		return err
+	}
+	if err := p.AskPeers(waveID, localPeerBytes, sig); err != nil {
 		return err
 	}
 	if err := n.recordQuestion(pid, waveID); err != nil {
@@ -41,7 +49,10 @@ func (n *Node) askPeers(pid common.Hash) error {
 }
 
 func (n *Node) askPing(pid common.Hash) error {
-	p := n.peers[pid]
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
 	// ping each of peer
 	waveID := common.CreateHash()
 	if err := p.SendPing(waveID); err != nil {
@@ -55,9 +66,20 @@ func (n *Node) askPing(pid common.Hash) error {
 }
 
 func (n *Node) askRoots(pid common.Hash) error {
-	p := n.peers[pid]
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
+	var q galaxy.Question = &galaxy.RootsQuestion{}
+	if n.genesisSync {
+		// the genesis is only fetched from pinned nodes
+		if !n.pinned(p) {
+			return errPeerNotPinned
+		}
+		q = &galaxy.GenesisQuestion{}
+	}
 	waveID := common.CreateHash()
-	if err := p.SendQuestion(waveID, galaxy.CmdRoots); err != nil {
+	if err := p.Ask(waveID, q); err != nil {
 		return err
 	}
 	if err := n.recordQuestion(pid, waveID); err != nil {
@@ -68,7 +90,10 @@ func (n *Node) askRoots(pid common.Hash) error {
 }
 
 func (n *Node) askMsg(pid common.Hash) error {
-	p := n.peers[pid]
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
 	// get current last message
 	lastMsg, err := db.GetLastMsg(n.udb)
 	var lastMsgID common.Hash
@@ -84,7 +109,7 @@ func (n *Node) askMsg(pid common.Hash) error {
 	}
 
 	waveID := common.CreateHash()
-	if err := p.SendQuestion(waveID, galaxy.CmdMessages, lastMsgID); err != nil {
+	if err := p.AskMessages(waveID, lastMsgID); err != nil {
 		return err
 	}
 	if err := n.recordQuestion(pid, waveID); err != nil {
@@ -125,9 +150,31 @@ func (n *Node) recordQuestion(peerID, waveID common.Hash) error {
 
 func (n *Node) recordPing(peerID, waveID common.Hash) error {
 	if _, ok := n.pingpongRecord[waveID]; !ok {
-		n.pingpongRecord[waveID] = &Record{pid: peerID, delay: 0}
+		n.pingpongRecord[waveID] = &Record{pid: peerID, delay: 0, sent: time.Now()}
 	} else {
 		return errDuplicateWaveID
 	}
 	return nil
 }
+
+func (n *Node) askCheckpoints(pid common.Hash) error {
+	if n.universe == nil {
+		return errUniverseNotExist
+	}
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
+	// checkpoints of all followed space-times, so the sequences of peers
+	// can be compared in sync status
+	for _, spaceTimeID := range n.universe.GetTrustedSpaceTimeIDs() {
+		waveID := common.CreateHash()
+		if err := p.AskCheckpoints(waveID, spaceTimeID); err != nil {
+			return err
+		}
+		if err := n.recordQuestion(pid, waveID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"golang.org/x/net/websocket"
+)
+
+// Kinds of audit entry
+const (
+	// AuditAccept is the msg accepted into local universe
+	AuditAccept = "accept"
+	// AuditReject is the msg rejected, duplicate msg is not recorded
+	AuditReject = "reject"
+	// AuditUser is the user created by birth msg accepted
+	AuditUser = "user"
+	// AuditUserState is the public state of user changed by msg accepted
+	AuditUserState = "userState"
+	// AuditPrune is the content of expired msg dropped
+	AuditPrune = "prune"
+)
+
+// Origins of msg audited, other than the address of peer sent it by ws
+const (
+	// originLocal is the msg created by local node
+	originLocal = "local"
+	// originOutbound is the msg received from the peer dialed by node
+	originOutbound = "outbound"
+	// originLeader is the msg committed by leader and followed by replica
+	originLeader = "leader"
+	// originImport is the msg imported from identity bundle
+	originImport = "import"
+)
+
+// DefaultAuditLimit is the max number of audit entries returned at once
+const DefaultAuditLimit = 100
+
+// msgOrigin return the address of peer msg received from by ws, or outbound
+func (n Node) msgOrigin(ws *websocket.Conn) string {
+	if ws != nil && ws.Request() != nil {
+		return n.clientAddr(ws.Request())
+	}
+	return originOutbound
+}
+
+// audit append the entry to the audit log, storeLock should be held
+func (n Node) audit(e *db.AuditEntry) {
+	e.Time = time.Now().Unix()
+	if err := db.AppendAudit(n.udb, e); err != nil {
+		log.Error("Append audit log fail", e.Kind, err)
+	}
+}
+
+// auditCommit record the msg accepted, and the user created or user state
+// changed by it, storeLock should be held
+func (n Node) auditCommit(msg *core.Message, origin string) {
+	n.audit(&db.AuditEntry{Kind: AuditAccept, Peer: origin, MsgID: msg.ID(), UserID: msg.SenderID})
+	switch msg.Value.ContentType {
+	case core.TypeBirth:
+		if user, err := core.CreateNewUser(n.universe, msg); err == nil {
+			n.audit(&db.AuditEntry{Kind: AuditUser, Peer: origin, MsgID: msg.ID(), UserID: user.ID()})
+		}
+	case core.TypeUserStateUpdate:
+		var cs core.ContentUserStateUpdate
+		if json.Unmarshal(msg.Value.Content, &cs) == nil {
+			n.audit(&db.AuditEntry{Kind: AuditUserState, Peer: origin, MsgID: msg.ID(), UserID: cs.UserID,
+				Details: fmt.Sprintf("state %d", cs.State)})
+		}
+	}
+}
+
+// auditReject record the msg rejected, duplicate msg is skipped
+func (n Node) auditReject(rejection *core.Rejection, origin string) {
+	if rejection.Code == core.RejectDuplicate {
+		return
+	}
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	n.audit(&db.AuditEntry{Kind: AuditReject, Peer: origin, MsgID: rejection.MsgID, Reason: rejection.Reason, Rule: rejection.Rule})
+}
+
+// GetAuditLog return at most limit audit entries from id in order, the
+// entries of other kinds are skipped if kind is not empty
+func (n Node) GetAuditLog(from uint64, limit int, kind string) ([]*db.AuditEntry, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	return db.GetAuditEntries(n.udb, from, limit, kind)
+}
+
+// ExportAuditLog write all audit entries into file as json lines for
+// compliance review, return the count written
+func (n Node) ExportAuditLog(fileName string) (int, error) {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n.storeLock.RLock()
+	cnt, err := db.ExportAudit(n.udb, f)
+	n.storeLock.RUnlock()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return cnt, err
+}
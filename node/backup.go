@@ -0,0 +1,146 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backup"
+)
+
+var (
+	errBackupNotLoopback = errors.New("backup only allowed from loopback")
+	errBackupRange       = errors.New("backup range not valid")
+)
+
+// registerBackup add the backup paths into local serve, only requests from
+// loopback are accepted, so the local pdu backup can copy the db of running
+// node without stopping it
+func (n *Node) registerBackup(mux *http.ServeMux) {
+	mux.HandleFunc(backup.SnapshotPath, n.loopbackOnly(n.backupSnapshotHandler))
+	mux.HandleFunc(backup.MsgsPath, n.loopbackOnly(n.backupMsgsHandler))
+	mux.HandleFunc(backup.CountPath, n.loopbackOnly(n.backupCountHandler))
+}
+
+func (n *Node) loopbackOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request forwarded by proxy is not local, even it is from loopback
+		if ip := n.clientIP(r); ip == nil || !ip.IsLoopback() || n.forwarded(r) {
+			http.Error(w, errBackupNotLoopback.Error(), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// backupSnapshotHandler write the full snapshot of db, msgs are not committed
+// while the snapshot is written, so the msg count in header match the snapshot
+func (n *Node) backupSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshotter, ok := n.udb.(db.Snapshotter)
+	if !ok {
+		http.Error(w, backup.ErrSnapshotNotSupported.Error(), http.StatusNotImplemented)
+		return
+	}
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(backup.MsgCountHeader, count.String())
+	if _, err := snapshotter.Snapshot(w); err != nil {
+		log.Error("Write backup snapshot fail", err)
+		// abort the response, so the broken snapshot is not taken as finished
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// backupMsgsHandler write msgs by order as json lines, such as
+// /backup/msgs?from=100&to=200
+func (n *Node) backupMsgsHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.ParseUint(r.URL.Query().Get("to"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if from > to || to > count.Uint64() {
+		http.Error(w, errBackupRange.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := backup.WriteMsgs(w, n.udb, from, to); err != nil {
+		log.Error("Write backup msgs fail", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// backupCountHandler return the msg count in db
+func (n *Node) backupCountHandler(w http.ResponseWriter, r *http.Request) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(count.String()))
+}
+
+// lockedSource is the db of running node, msgs are not committed while
+// it is read, used by admin_backup
+type lockedSource struct {
+	src  *backup.LocalSource
+	lock *sync.RWMutex
+}
+
+func (s *lockedSource) Snapshot(w io.Writer) (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.src.Snapshot(w)
+}
+
+func (s *lockedSource) MsgCount() (uint64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.src.MsgCount()
+}
+
+func (s *lockedSource) WriteMsgs(w io.Writer, from, to uint64) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.src.WriteMsgs(w, from, to)
+}
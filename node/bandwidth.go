@@ -0,0 +1,182 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+var errBandwidthNotValid = errors.New("bandwidth limit should not be negative")
+
+// BandwidthLimit is the bytes per second can be sent and received by node
+// in total and by each peer, 0 means no limit. The waves are delayed but
+// never dropped, so home connection nodes are not saturated by serving
+// bulk sync to peers.
+type BandwidthLimit struct {
+	SendRate     int64 `json:"sendRate,omitempty"`
+	RecvRate     int64 `json:"recvRate,omitempty"`
+	PeerSendRate int64 `json:"peerSendRate,omitempty"`
+	PeerRecvRate int64 `json:"peerRecvRate,omitempty"`
+}
+
+// PeerBandwidth is the bandwidth used by the conn of peer, the conns
+// dialed by peers are inbound and keyed by remote address
+type PeerBandwidth struct {
+	Key     string `json:"key"`
+	Inbound bool   `json:"inbound"`
+	peer.MeterStats
+}
+
+// Bandwidth is the bandwidth used by node in total and by the conns open
+type Bandwidth struct {
+	Total peer.MeterStats `json:"total"`
+	Peers []PeerBandwidth `json:"peers"`
+}
+
+// bandwidth keep the meters of conns open, all of them count into total
+type bandwidth struct {
+	lock    sync.Mutex
+	limit   BandwidthLimit
+	total   *peer.Meter
+	meters  map[string]*peer.Meter
+	inbound map[*websocket.Conn]string
+	tap     *peer.Wiretap // record waves of all meters if not nil
+}
+
+func newBandwidth() *bandwidth {
+	return &bandwidth{
+		total:   peer.NewMeter(nil, 0, 0),
+		meters:  make(map[string]*peer.Meter),
+		inbound: make(map[*websocket.Conn]string),
+	}
+}
+
+// SetBandwidthLimit set the limits of node and each peer, should be set before Run
+func (n *Node) SetBandwidthLimit(limit *BandwidthLimit) error {
+	if limit.SendRate < 0 || limit.RecvRate < 0 || limit.PeerSendRate < 0 || limit.PeerRecvRate < 0 {
+		return errBandwidthNotValid
+	}
+	b := n.bandwidth
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.limit = *limit
+	b.total = peer.NewMeter(nil, limit.SendRate, limit.RecvRate)
+	return nil
+}
+
+// SetWiretap record all waves sent and received into the rotating files in
+// dir, used to debug interop of node versions, should be set before Run
+func (n *Node) SetWiretap(dir string) error {
+	tap, err := peer.NewWiretap(dir, peer.DefaultWiretapFileSize, peer.DefaultWiretapFiles)
+	if err != nil {
+		return err
+	}
+	b := n.bandwidth
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tap = tap
+	return nil
+}
+
+func (b *bandwidth) closeWiretap() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tap == nil {
+		return
+	}
+	if err := b.tap.Err(); err != nil {
+		log.Error("Wiretap stopped", err)
+	}
+	b.tap.Close()
+}
+
+// Bandwidth return the bandwidth used by node and the conns open, sorted by key
+func (n Node) Bandwidth() *Bandwidth {
+	b := n.bandwidth
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	res := &Bandwidth{Total: b.total.Stats(), Peers: []PeerBandwidth{}}
+	for key, m := range b.meters {
+		res.Peers = append(res.Peers, PeerBandwidth{Key: key, Inbound: isInboundKey(key), MeterStats: m.Stats()})
+	}
+	sort.Slice(res.Peers, func(i, j int) bool { return res.Peers[i].Key < res.Peers[j].Key })
+	return res
+}
+
+const inboundKeyPrefix = "in/"
+
+func isInboundKey(key string) bool {
+	return len(key) > len(inboundKeyPrefix) && key[:len(inboundKeyPrefix)] == inboundKeyPrefix
+}
+
+// meter return the meter of key, created if not exist
+func (b *bandwidth) meter(key string) *peer.Meter {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	m, ok := b.meters[key]
+	if !ok {
+		m = peer.NewMeter(b.total, b.limit.PeerSendRate, b.limit.PeerRecvRate)
+		if b.tap != nil {
+			m.SetWiretap(b.tap, key)
+		}
+		b.meters[key] = m
+	}
+	return m
+}
+
+func (b *bandwidth) remove(key string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.meters, key)
+}
+
+// openInbound create the meter of conn dialed by peer
+func (b *bandwidth) openInbound(ws *websocket.Conn) *peer.Meter {
+	key := inboundKeyPrefix + ws.Request().RemoteAddr
+	m := b.meter(key)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.inbound[ws] = key
+	return m
+}
+
+func (b *bandwidth) closeInbound(ws *websocket.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.meters, b.inbound[ws])
+	delete(b.inbound, ws)
+}
+
+// wsPeer return the peer answer the questions on conn dialed by peer, the
+// bytes sent are counted by the meter of conn
+func (n Node) wsPeer(ws *websocket.Conn) *peer.Peer {
+	p := &peer.Peer{Conn: ws}
+	p.SetFaults(n.faults)
+	b := n.bandwidth
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if key, ok := b.inbound[ws]; ok {
+		p.SetMeter(b.meters[key])
+	}
+	return p
+}
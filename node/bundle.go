@@ -0,0 +1,76 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// IdentityImport is the result of identity bundle imported, each msg in
+// bundle is added, duplicated or rejected by local universe
+type IdentityImport struct {
+	UserID     common.Hash       `json:"userID"`
+	Added      []common.Hash     `json:"added"`
+	Duplicated []common.Hash     `json:"duplicated"`
+	Rejected   []*core.Rejection `json:"rejected"`
+}
+
+// ExportIdentity return the portable bundle of user with its ancestors and
+// all msgs sent by it, so the user can migrate to another node or client
+func (n Node) ExportIdentity(userID common.Hash) (*core.IdentityBundle, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	return n.universe.ExportIdentity(userID)
+}
+
+// ImportIdentity verify all signatures and lineage links in the bundle
+// against local universe, nothing is inserted if any of them not valid.
+// Then the msgs are inserted one by one, the birth msgs of ancestors first,
+// and the msg rejected by universe does not stop the others.
+func (n Node) ImportIdentity(b *core.IdentityBundle) (*IdentityImport, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	n.msgLock.Lock()
+	err := n.universe.VerifyIdentity(b)
+	n.msgLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	r := &IdentityImport{UserID: b.User.ID()}
+	for _, msg := range b.Items() {
+		n.msgLock.Lock()
+		receipt, err := n.universe.Validate(msg)
+		if err == nil {
+			err = n.commitMsg(receipt, originImport)
+		}
+		switch err {
+		case nil:
+			r.Added = append(r.Added, msg.ID())
+		case core.ErrMsgAlreadyExist:
+			r.Duplicated = append(r.Duplicated, msg.ID())
+		default:
+			r.Rejected = append(r.Rejected, n.universe.Reject(msg, err))
+		}
+		n.msgLock.Unlock()
+	}
+	return r, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	errCheckpointNotMatch = errors.New("checkpoint not match")
+	errCheckpointNotExist = errors.New("checkpoint not exist")
+)
+
+// recordCheckpoint create and save the checkpoint if the time proof sequence
+// of space-time created by sender reach the checkpoint interval, the checkpoint
+// will be signed if time proof user is unlocked.
+func (n Node) recordCheckpoint(msg *core.Message) error {
+	seq := n.universe.GetMaxSeq(msg.SenderID)
+	if seq == 0 || seq%n.cpInterval != 0 {
+		return nil
+	}
+	if cp, err := db.GetCheckpoint(n.udb, msg.SenderID, seq); err != nil || cp != nil {
+		return err
+	}
+	cp, err := n.universe.CreateCheckpoint(msg.SenderID, seq)
+	if err != nil {
+		return err
+	}
+	if n.tpUnlockedUser != nil {
+		if err := cp.Sign(n.tpUnlockedUser, n.tpUnlockedPrivateKey); err != nil {
+			return err
+		}
+	}
+	log.Info("Checkpoint", seq, "of space-time", common.Hash2String(msg.SenderID), "be recorded")
+	return db.SaveCheckpoint(n.udb, cp)
+}
+
+func (n Node) handleQuestionCheckpoints(ws *websocket.Conn, wq *galaxy.WaveQuestion, q *galaxy.CheckpointsQuestion) (common.Hash, error) {
+	p := n.wsPeer(ws)
+	cps, err := db.GetCheckpoints(n.udb, q.SpaceTimeID, 0, maxLoadCheckpoints)
+	if err != nil {
+		return wq.WaveID, err
+	}
+	if err := p.SendCheckpoints(wq.WaveID, cps, n.identity); err != nil {
+		return wq.WaveID, err
+	}
+	return wq.WaveID, nil
+}
+
+// handleCheckpoints compare the checkpoints from peer with local universe,
+// the signed checkpoint which not exist in local db will be saved after verified.
+func (n *Node) handleCheckpoints(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveCheckpoints)
+	if n.universe == nil {
+		return wm.WaveID, errUniverseNotExist
+	}
+	if err := n.verifyAnswer(ws, wm.WaveID, wm.Auth, wm.SignedData()); err != nil {
+		return wm.WaveID, err
+	}
+	pid := n.questionRecord[wm.WaveID].pid
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	for _, cp := range wm.Checkpoints {
+		n.syncer.observeSeq(pid, cp.SpaceTimeID, cp.Seq)
+		localCP, err := n.universe.CreateCheckpoint(cp.SpaceTimeID, cp.Seq)
+		if err == core.ErrSeqNotFound {
+			// local universe not reach this sequence yet
+			continue
+		} else if err != nil {
+			return wm.WaveID, err
+		}
+		if !localCP.MsgID.Equal(cp.MsgID) || !localCP.StateRoot.Equal(cp.StateRoot) {
+			log.Warn("Checkpoint", cp.Seq, "of space-time", common.Hash2String(cp.SpaceTimeID), "not match")
+			return wm.WaveID, errCheckpointNotMatch
+		}
+		if cp.Signature == nil {
+			continue
+		}
+		signer := n.universe.GetUserByID(cp.SignerID)
+		if signer == nil {
+			continue
+		}
+		if ok, err := core.VerifyCheckpoint(*cp, signer); err != nil || !ok {
+			log.Warn("Checkpoint", cp.Seq, "signature not valid", err)
+			continue
+		}
+		if saved, err := db.GetCheckpoint(n.udb, cp.SpaceTimeID, cp.Seq); err != nil {
+			return wm.WaveID, err
+		} else if saved == nil || saved.Signature == nil {
+			if err := db.SaveCheckpoint(n.udb, cp); err != nil {
+				return wm.WaveID, err
+			}
+		}
+	}
+	return wm.WaveID, nil
+}
+
+// Rollback unwind the local universe and msgs in db back to the checkpoint
+// recorded before, the checkpoints after it are removed as well. The db is
+// changed in one transaction before the universe replaced, so the universe
+// and db are kept same if any step fail.
+func (n *Node) Rollback(spacetimeID common.Hash, seq uint64) error {
+	if n.universe == nil {
+		return errUniverseNotExist
+	}
+	cp, err := db.GetCheckpoint(n.udb, spacetimeID, seq)
+	if err != nil {
+		return err
+	} else if cp == nil {
+		return errCheckpointNotExist
+	}
+	// readers of universe hold the read lock, see Universe.Rollback
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	stIDs := n.universe.GetSpaceTimeIDs()
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	if err := n.universe.Rollback(cp, func(nu *core.Universe) error {
+		return db.Update(n.udb, func(tx db.UDB) error {
+			if err := db.TruncateMsgs(tx, cp.MsgID); err != nil {
+				return err
+			}
+			for _, stID := range stIDs {
+				if err := db.DeleteCheckpointsAfter(tx, stID, nu.GetMaxSeq(stID)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	log.Info("Rollback to checkpoint", seq, "of space-time", common.Hash2String(spacetimeID))
+	return nil
+}
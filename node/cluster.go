@@ -0,0 +1,431 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backup"
+	"github.com/pdupub/go-pdu/filter"
+	"github.com/pdupub/go-pdu/galaxy"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// ClusterChangesPath is the path of leader to wait and get the msgs
+	// committed after from, such as /cluster/changes?from=100&wait=30
+	ClusterChangesPath = "/cluster/changes"
+
+	// ClusterSubmitPath is the path of leader to commit the msgs forwarded
+	// by replica, the body is json array of msgs
+	ClusterSubmitPath = "/cluster/submit"
+
+	// ClusterLeader is the role of node which commit msgs into universe
+	ClusterLeader = "leader"
+
+	// ClusterReplica is the role of node which follow the msgs of leader
+	ClusterReplica = "replica"
+
+	// MaxClusterWait is the max time replica wait for changes of leader
+	MaxClusterWait = 30 * time.Second
+
+	clusterBatch         = 256
+	clusterRetryInterval = time.Second
+)
+
+var (
+	errLeaderURLNotValid = errors.New("leader url should be http(s)://host:port")
+	errChangesRange      = errors.New("changes range not valid")
+)
+
+// ClusterStatus is the state of node in cluster. Replica commit the msgs of
+// leader in same order, and forward the msgs received from peers to leader.
+type ClusterStatus struct {
+	Role        string `json:"role"`
+	Leader      string `json:"leader,omitempty"`
+	LeaderCount uint64 `json:"leaderCount,omitempty"` // msg count of leader seen last
+	Applied     uint64 `json:"applied,omitempty"`     // msgs of leader committed
+	Forwarded   uint64 `json:"forwarded,omitempty"`   // msgs forwarded to leader
+	LastError   string `json:"lastError,omitempty"`
+}
+
+// clusterSubmitResult is the answer of leader to msgs forwarded, the msgs
+// in universe of leader are accepted, including the duplicate ones
+type clusterSubmitResult struct {
+	Accepted []string `json:"accepted"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// cluster hold the leader of replica, and notify the replicas waiting for
+// changes when msg committed by leader
+type cluster struct {
+	mu      sync.Mutex
+	leader  string // url of leader, empty if node is leader
+	apiKey  string
+	client  *http.Client
+	changed chan struct{} // closed and replaced when msg committed
+	status  ClusterStatus
+}
+
+func newCluster() *cluster {
+	return &cluster{changed: make(chan struct{}), status: ClusterStatus{Role: ClusterLeader}}
+}
+
+// notify wake the replicas waiting for changes
+func (c *cluster) notify() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait return the chan closed when next msg committed
+func (c *cluster) wait() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.changed
+}
+
+func (c *cluster) replica() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader != ""
+}
+
+func (c *cluster) update(f func(s *ClusterStatus)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&c.status)
+}
+
+// request send the request to leader with api key
+func (c *cluster) request(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	c.mu.Lock()
+	leader, apiKey, client := c.leader, c.apiKey, c.client
+	c.mu.Unlock()
+	req, err := http.NewRequest(method, leader+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("leader %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// SetLeader run node as read replica of leader, such as http://10.0.0.1:1088,
+// the api key should be admin of leader if api keys enabled on it. The msgs
+// are committed by leader only, replica follow them in same order and serve
+// the queries, so the query load is scaled by replicas. Should be set
+// before Run, and time proof should not be enabled on replica.
+func (n *Node) SetLeader(leaderURL, apiKey string) error {
+	u, err := url.Parse(leaderURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errLeaderURLNotValid
+	}
+	n.cluster.mu.Lock()
+	defer n.cluster.mu.Unlock()
+	n.cluster.leader = strings.TrimRight(leaderURL, "/")
+	n.cluster.apiKey = apiKey
+	n.cluster.client = &http.Client{Timeout: MaxClusterWait + 10*time.Second}
+	n.cluster.status.Role = ClusterReplica
+	n.cluster.status.Leader = n.cluster.leader
+	return nil
+}
+
+// ClusterStatus return the role of node and the progress of replica
+func (n Node) ClusterStatus() ClusterStatus {
+	n.cluster.mu.Lock()
+	defer n.cluster.mu.Unlock()
+	return n.cluster.status
+}
+
+// registerCluster add the paths of leader served to replicas
+func (n *Node) registerCluster(mux *http.ServeMux) {
+	mux.HandleFunc(ClusterChangesPath, n.withRole(RoleAdmin, n.clusterChangesHandler))
+	mux.HandleFunc(ClusterSubmitPath, n.withRole(RoleAdmin, n.clusterSubmitHandler))
+}
+
+// msgCount return the msg count in db
+func (n Node) msgCount() (uint64, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		return 0, err
+	}
+	return count.Uint64(), nil
+}
+
+// clusterChangesHandler write the msgs committed after from as json lines,
+// the request wait until new msg committed if no msg after from
+func (n *Node) clusterChangesHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wait := MaxClusterWait
+	if s, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && s >= 0 && time.Duration(s)*time.Second < wait {
+		wait = time.Duration(s) * time.Second
+	}
+	changed := n.cluster.wait()
+	count, err := n.msgCount()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count <= from && wait > 0 {
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	total, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	count = total.Uint64()
+	if from > count {
+		http.Error(w, errChangesRange.Error(), http.StatusBadRequest)
+		return
+	}
+	to := count
+	if to-from > clusterBatch {
+		to = from + clusterBatch
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(backup.MsgCountHeader, strconv.FormatUint(count, 10))
+	if err := backup.WriteMsgs(w, n.udb, from, to); err != nil {
+		log.Error("Write changes fail", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// clusterSubmitHandler commit the msgs forwarded by replica, and gossip
+// them to peers as msgs received by ws
+func (n *Node) clusterSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	var msgs []*core.Message
+	if err := json.NewDecoder(r.Body).Decode(&msgs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	n.sealMsgs(msgs...)
+	res := &clusterSubmitResult{Accepted: []string{}}
+	if err := n.commitForwarded(msgs, n.clientAddr(r), res); err != nil {
+		res.Error = err.Error()
+	}
+	resBytes, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resBytes)
+}
+
+// commitForwarded commit the msgs one by one until the msg rejected
+func (n *Node) commitForwarded(msgs []*core.Message, origin string, res *clusterSubmitResult) error {
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	for _, msg := range msgs {
+		if !n.universe.HasMsg(msg.ID()) {
+			receipt, err := n.universe.Validate(msg)
+			if err != nil {
+				return n.rejectMsg(nil, common.Hash{}, msg, err)
+			}
+			verdict := n.filterer.check(msg, n.labeler)
+			if verdict == filter.Reject {
+				return n.rejectMsg(nil, common.Hash{}, msg, core.ErrMsgFiltered)
+			}
+			if err := n.commitMsg(receipt, origin); err != nil {
+				return n.rejectMsg(nil, common.Hash{}, msg, err)
+			}
+			if verdict == filter.Flag {
+				n.filterer.flag(msg.ID())
+			} else if n.relayer.relay(msg, n.universe, n.labeler.of(msg)) {
+				if err := n.broadcastMsg(msg); err != nil {
+					log.Error("Broadcast forwarded msg fail", err)
+				}
+			}
+		}
+		res.Accepted = append(res.Accepted, common.Hash2String(msg.ID()))
+	}
+	return nil
+}
+
+// forwardMessages send the msgs received by replica to leader, and ack the
+// msgs accepted by leader to peer. The msgs are committed by replica after
+// they are followed from leader.
+func (n *Node) forwardMessages(ws *websocket.Conn, wm *galaxy.WaveMessages) (common.Hash, error) {
+	msgs := make([]json.RawMessage, len(wm.Msgs))
+	for i, msg := range wm.Msgs {
+		msgs[i] = msg
+	}
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		return wm.WaveID, err
+	}
+	resp, err := n.cluster.request(context.Background(), http.MethodPost, ClusterSubmitPath, body)
+	if err != nil {
+		return wm.WaveID, err
+	}
+	defer resp.Body.Close()
+	var res clusterSubmitResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return wm.WaveID, err
+	}
+	n.cluster.update(func(s *ClusterStatus) { s.Forwarded += uint64(len(wm.Msgs)) })
+	if wm.Ack && ws != nil && len(res.Accepted) > 0 {
+		var acked []common.Hash
+		for _, id := range res.Accepted {
+			if msgID, err := common.HashFromString(id); err == nil {
+				acked = append(acked, msgID)
+			}
+		}
+		p := n.wsPeer(ws)
+		if err := p.SendAck(wm.WaveID, acked...); err != nil {
+			log.Error("Send ack fail", err)
+		}
+	}
+	if res.Error != "" {
+		return wm.WaveID, errors.New(res.Error)
+	}
+	return wm.WaveID, nil
+}
+
+// runReplica follow the msgs committed by leader until stopped
+func (n *Node) runReplica(sig <-chan struct{}, wait chan<- struct{}) {
+	defer close(wait)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-sig
+		cancel()
+	}()
+	for {
+		err := n.followLeader(ctx)
+		if ctx.Err() != nil {
+			log.Info("Stop replica")
+			return
+		}
+		if err == nil {
+			continue
+		}
+		log.Error("Follow leader fail", err)
+		n.cluster.update(func(s *ClusterStatus) { s.LastError = err.Error() })
+		select {
+		case <-ctx.Done():
+			log.Info("Stop replica")
+			return
+		case <-time.After(clusterRetryInterval):
+		}
+	}
+}
+
+// followLeader wait and commit the msgs of leader after local msgs
+func (n *Node) followLeader(ctx context.Context) error {
+	from, err := n.msgCount()
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s?from=%d&wait=%d", ClusterChangesPath, from, int(MaxClusterWait/time.Second))
+	resp, err := n.cluster.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	leaderCount, _ := strconv.ParseUint(resp.Header.Get(backup.MsgCountHeader), 10, 64)
+	var msgs []*core.Message
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg core.Message
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		msgs = append(msgs, &msg)
+	}
+	applied, err := n.applyLeaderMsgs(msgs)
+	n.cluster.update(func(s *ClusterStatus) {
+		s.LeaderCount = leaderCount
+		s.Applied += applied
+		if err == nil {
+			s.LastError = ""
+		}
+	})
+	return err
+}
+
+// applyLeaderMsgs commit the msgs of leader in order
+func (n *Node) applyLeaderMsgs(msgs []*core.Message) (applied uint64, err error) {
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	if n.universe == nil {
+		return 0, errUniverseNotExist
+	}
+	n.sealMsgs(msgs...)
+	for _, msg := range msgs {
+		if n.universe.HasMsg(msg.ID()) {
+			continue
+		}
+		receipt, err := n.universe.Validate(msg)
+		if err != nil {
+			return applied, err
+		}
+		if err := n.commitMsg(receipt, originLeader); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
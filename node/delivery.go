@@ -0,0 +1,189 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// MaxDeliveryBackoff is the max interval between two sends of the msg
+	// of local user not acked
+	MaxDeliveryBackoff = time.Minute
+
+	// MaxAckedWaves is the number of waves acked recently, which are only
+	// acked again if sent by peer again
+	MaxAckedWaves = 4096
+)
+
+// ackedWaves is the msgs acked by wave ID recently, so the wave sent again
+// by peer is not processed twice
+type ackedWaves struct {
+	lock  sync.Mutex
+	waves map[common.Hash][]common.Hash
+	order []common.Hash
+}
+
+func newAckedWaves() *ackedWaves {
+	return &ackedWaves{waves: make(map[common.Hash][]common.Hash)}
+}
+
+func (a *ackedWaves) get(waveID common.Hash) ([]common.Hash, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msgIDs, ok := a.waves[waveID]
+	return msgIDs, ok
+}
+
+func (a *ackedWaves) add(waveID common.Hash, msgIDs []common.Hash) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.waves[waveID]; ok {
+		return
+	}
+	if len(a.order) >= MaxAckedWaves {
+		delete(a.waves, a.order[0])
+		a.order = a.order[1:]
+	}
+	a.waves[waveID] = msgIDs
+	a.order = append(a.order, waveID)
+}
+
+// deliverer remember whether node was connected, so the deliveries are
+// sent again at once after reconnect
+type deliverer struct {
+	lock      sync.Mutex
+	connected bool
+}
+
+// reconnected update the state of connection, return true if node is
+// connected again
+func (d *deliverer) reconnected(connected bool) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	reconnected := connected && !d.connected
+	d.connected = connected
+	return reconnected
+}
+
+// GetDeliveries return the msgs of local user sent but not acked by any
+// peer yet, in order of sent
+func (n Node) GetDeliveries() ([]*db.Delivery, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	return db.GetDeliveries(n.udb)
+}
+
+// deliverMsg save the msg of local user into db before broadcast, the msg
+// is sent to peers again until any peer ack it, even after node restart
+func (n Node) deliverMsg(msg *core.Message) error {
+	now := time.Now().Unix()
+	d := &db.Delivery{WaveID: common.CreateHash(), Msg: msg, Attempts: 1, Created: now, Updated: now}
+	n.storeLock.Lock()
+	err := db.SaveDelivery(n.udb, d)
+	n.storeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	n.sendDelivery(d)
+	return nil
+}
+
+// sendDelivery send msg to all connected peers in the wave of delivery
+func (n Node) sendDelivery(d *db.Delivery) {
+	for k, p := range n.copyPeers() {
+		if !p.Connected() {
+			continue
+		}
+		if err := p.SendMsgAck(d.WaveID, d.Msg); err != nil {
+			log.Error("Deliver to peer", common.Hash2String(k), err)
+		}
+	}
+}
+
+// resendDeliveries send the deliveries not acked in time again with
+// backoff, all of them are sent at once after node connected again
+func (n Node) resendDeliveries() {
+	connected := n.Connected()
+	reconnected := n.deliverer.reconnected(connected)
+	if !connected {
+		return
+	}
+	deliveries, err := n.GetDeliveries()
+	if err != nil {
+		log.Error("Load deliveries fail", err)
+		return
+	}
+	now, timeout := time.Now(), n.acker.ackTimeout()
+	for _, d := range deliveries {
+		backoff := timeout << uint(d.Attempts-1)
+		if backoff > MaxDeliveryBackoff || backoff <= 0 {
+			backoff = MaxDeliveryBackoff
+		}
+		if !reconnected && now.Before(time.Unix(d.Updated, 0).Add(backoff)) {
+			continue
+		}
+		d.Attempts++
+		d.Updated = now.Unix()
+		n.storeLock.Lock()
+		err := db.SaveDelivery(n.udb, d)
+		n.storeLock.Unlock()
+		if err != nil {
+			log.Error("Save delivery fail", common.Hash2String(d.WaveID), err)
+			continue
+		}
+		n.sendDelivery(d)
+	}
+}
+
+// delivered remove the delivery of wave if msg acked by peer
+func (n Node) delivered(waveID common.Hash, msgIDs []common.Hash) {
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	d, err := db.GetDelivery(n.udb, waveID)
+	if err != nil || d == nil || !containsHash(msgIDs, d.Msg.ID()) {
+		return
+	}
+	if err := db.DeleteDelivery(n.udb, waveID); err != nil {
+		log.Error("Delete delivery fail", common.Hash2String(waveID), err)
+		return
+	}
+	log.Info("Msg", common.Hash2String(d.Msg.ID()), "is delivered after", d.Attempts, "attempts")
+}
+
+// undeliverable remove the delivery of wave if msg rejected by peer for
+// the reason which will not change by sending again
+func (n Node) undeliverable(waveID common.Hash, r *core.Rejection) {
+	if retryable(r) {
+		return
+	}
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	d, err := db.GetDelivery(n.udb, waveID)
+	if err != nil || d == nil || d.Msg.ID() != r.MsgID {
+		return
+	}
+	if err := db.DeleteDelivery(n.udb, waveID); err != nil {
+		log.Error("Delete delivery fail", common.Hash2String(waveID), err)
+	}
+}
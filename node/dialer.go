@@ -0,0 +1,153 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+// DefaultDialWorkers is the default number of peers dialed at same time
+const DefaultDialWorkers = 16
+
+// dialQueueSize is the max number of peers waiting to be dialed
+const dialQueueSize = maxLoadPeersCount
+
+type dialJob struct {
+	k common.Hash
+	p *peer.Peer
+}
+
+type dialResult struct {
+	dialJob
+	err error
+}
+
+// dialer dial the peers by a pool of workers, so the node loop is not
+// blocked by the peers not reachable. The dialed peers are handled in node
+// loop by the results.
+type dialer struct {
+	lock    sync.Mutex
+	workers int
+	dialing map[common.Hash]bool
+	jobs    chan dialJob
+	results chan dialResult
+}
+
+func newDialer() *dialer {
+	return &dialer{
+		workers: DefaultDialWorkers,
+		dialing: make(map[common.Hash]bool),
+		jobs:    make(chan dialJob, dialQueueSize),
+		results: make(chan dialResult),
+	}
+}
+
+// start the workers, which stop once ctx done
+func (d *dialer) start(ctx context.Context) {
+	for i := 0; i < d.workers; i++ {
+		go d.work(ctx)
+	}
+}
+
+func (d *dialer) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-d.jobs:
+			err := job.p.DialContext(ctx)
+			select {
+			case d.results <- dialResult{dialJob: job, err: err}:
+			case <-ctx.Done():
+				if err == nil {
+					job.p.Close()
+				}
+				return
+			}
+		}
+	}
+}
+
+// submit the peer to be dialed, false if the peer is dialing or queue is full
+func (d *dialer) submit(k common.Hash, p *peer.Peer) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.dialing[k] {
+		return false
+	}
+	select {
+	case d.jobs <- dialJob{k: k, p: p}:
+		d.dialing[k] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// isDialing return true if the peer is submitted but its result not handled
+func (d *dialer) isDialing(k common.Hash) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.dialing[k]
+}
+
+func (d *dialer) done(k common.Hash) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.dialing, k)
+}
+
+// SetDialWorkers set the number of peers dialed at same time, should be set
+// before Run
+func (n *Node) SetDialWorkers(workers int) error {
+	if workers <= 0 {
+		return errDialWorkersNotValid
+	}
+	n.dialer.workers = workers
+	return nil
+}
+
+// SetPeerTimeouts set the timeouts of peers dialed later
+func (n *Node) SetPeerTimeouts(t peer.Timeouts) {
+	n.peerTimeouts = t
+}
+
+// dialed handle the result of dial in node loop, the peer failed is removed
+func (n *Node) dialed(r dialResult, chanWave chan<- galaxy.Wave, chanWSig chan<- common.Hash) {
+	n.dialer.done(r.k)
+	if r.err != nil {
+		log.Error(r.err)
+		n.removePeer(r.k)
+		return
+	}
+	// peer may be evicted or removed while dialing
+	if p, err := n.getPeer(r.k); err != nil || p != r.p {
+		r.p.Close()
+		return
+	}
+	if err := n.askPeers(r.k); err != nil {
+		log.Error(err)
+		return
+	}
+	go n.serveReceiveWave(r.p.Reader(), r.k, chanWave, chanWSig)
+}
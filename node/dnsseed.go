@@ -0,0 +1,179 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+const (
+	// SeedRecordPrefix is the prefix of TXT records of dns seed, other TXT
+	// records of the domain are ignored
+	SeedRecordPrefix = "pdu-seed="
+
+	seedSigSeparator = ";sig="
+	dnsSeedTimeout   = 10 * time.Second
+)
+
+var (
+	errSeedRecordNotValid    = errors.New("seed record not valid")
+	errSeedSignatureNotValid = errors.New("seed signature not valid")
+	errSeedKeyMissing        = errors.New("seed key missing")
+)
+
+// Resolver lookup the TXT records of dns seed, net.Resolver is used by default
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// dnsSeeds is the domains resolved on start to get the initial peers, the
+// records must be signed by the seed key
+type dnsSeeds struct {
+	domains  []string
+	key      *crypto.PublicKey
+	resolver Resolver
+}
+
+// SetDNSSeeds set the domains split by comma, the TXT records of them are
+// resolved on start, the nodes signed by seed key are added as peers. So
+// nodes can be bootstrapped without the hard-coded address of nodes.
+func (n *Node) SetDNSSeeds(domains string, seedKey *crypto.PublicKey) error {
+	if seedKey == nil {
+		return errSeedKeyMissing
+	}
+	seeds := &dnsSeeds{key: seedKey, resolver: net.DefaultResolver}
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			seeds.domains = append(seeds.domains, domain)
+		}
+	}
+	if n.dnsSeeds != nil {
+		seeds.resolver = n.dnsSeeds.resolver
+	}
+	n.dnsSeeds = seeds
+	return nil
+}
+
+// SetResolver set the resolver of dns seeds, should be set after SetDNSSeeds
+func (n *Node) SetResolver(r Resolver) {
+	if n.dnsSeeds != nil {
+		n.dnsSeeds.resolver = r
+	}
+}
+
+// resolveDNSSeeds add the nodes in TXT records of dns seeds as peers, the
+// records not signed by seed key are skipped
+func (n *Node) resolveDNSSeeds() {
+	if n.dnsSeeds == nil {
+		return
+	}
+	for _, domain := range n.dnsSeeds.domains {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsSeedTimeout)
+		records, err := n.dnsSeeds.resolver.LookupTXT(ctx, domain)
+		cancel()
+		if err != nil {
+			log.Warn("Resolve dns seed", domain, "fail", err)
+			continue
+		}
+		count := 0
+		for _, record := range records {
+			if !strings.HasPrefix(record, SeedRecordPrefix) {
+				continue
+			}
+			p, err := ParseSeedRecord(record, n.dnsSeeds.key)
+			if err != nil {
+				log.Warn("Seed record of", domain, "skipped", err)
+				continue
+			}
+			if err := n.AddPeer(p); err != nil && err != errPeerAlreadyExist {
+				log.Warn("Seed node", p.Url(), "not added", err)
+				continue
+			}
+			count++
+		}
+		log.Info("Dns seed", domain, "resolved", count, "nodes")
+	}
+}
+
+// CreateSeedRecord create the TXT record of node address [userid@ip:port/nodeKey]
+// signed by the seed key, published under the domain of dns seed
+func CreateSeedRecord(address string, priKey *crypto.PrivateKey) (string, error) {
+	if _, err := parseNodeAddress(address); err != nil {
+		return "", err
+	}
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return "", err
+	}
+	sig, err := engine.Sign([]byte(address), priKey)
+	if err != nil {
+		return "", err
+	}
+	return SeedRecordPrefix + address + seedSigSeparator + hex.EncodeToString(sig.Signature), nil
+}
+
+// ParseSeedRecord verify the TXT record by seed key, and return the peer of it
+func ParseSeedRecord(record string, seedKey *crypto.PublicKey) (*peer.Peer, error) {
+	res := strings.Split(strings.TrimPrefix(record, SeedRecordPrefix), seedSigSeparator)
+	if !strings.HasPrefix(record, SeedRecordPrefix) || len(res) != 2 {
+		return nil, errSeedRecordNotValid
+	}
+	sigBytes, err := hex.DecodeString(res[1])
+	if err != nil {
+		return nil, errSeedRecordNotValid
+	}
+	engine, err := utils.SelectEngine(seedKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	signature := &crypto.Signature{PublicKey: *seedKey, Signature: sigBytes}
+	if ok, err := engine.Verify([]byte(res[0]), signature); err != nil || !ok {
+		return nil, errSeedSignatureNotValid
+	}
+	return parseNodeAddress(res[0])
+}
+
+// ParseSeedKey parse the seed key from the hex of public key (ETH)
+func ParseSeedKey(s string) (*crypto.PublicKey, error) {
+	pubKeyBytes, err := json.Marshal(map[string]string{"source": crypto.ETH, "sigType": crypto.Signature2PublicKey, "pubKey": strings.TrimPrefix(s, "0x")})
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParsePublicKeyJSON(pubKeyBytes)
+}
+
+// SeedKeyString return the hex of seed key, used as the seed key of nodes
+func SeedKeyString(pubKey *crypto.PublicKey) (string, error) {
+	_, pubKeyM, err := ethereum.New().MappingKey(nil, pubKey)
+	if err != nil {
+		return "", err
+	}
+	s, _ := pubKeyM["pubKey"].(string)
+	return s, nil
+}
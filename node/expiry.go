@@ -0,0 +1,110 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+// MsgView is the msg returned by query apis, the ephemeral msg is marked
+// expired after its content be dropped
+type MsgView struct {
+	*core.Message
+	Expired bool     `json:"expired,omitempty"`
+	Edits   int      `json:"edits,omitempty"`  // number of edits, history served on /edits
+	Labels  []string `json:"labels,omitempty"` // labels set by local user
+}
+
+// viewMsgs mark the expired, edited and labeled msgs in local universe
+func (n Node) viewMsgs(msgs []*core.Message) []*MsgView {
+	views := make([]*MsgView, len(msgs))
+	for i, msg := range msgs {
+		views[i] = &MsgView{Message: msg, Expired: msg.ContentDropped() || n.universe.IsExpired(msg.ID()), Edits: n.universe.EditCount(msg.ID()), Labels: n.labeler.get(msg.ID())}
+	}
+	return views
+}
+
+// dropExpired drop the content of ephemeral msgs expired in local universe,
+// the msgs in udb are rewritten without content, so expired content is never
+// loaded or synced to peers again. Each drop is recorded in audit log.
+func (n Node) dropExpired() {
+	for _, msg := range n.universe.DropExpired() {
+		if err := db.DropMsgContent(n.udb, msg.ID()); err != nil {
+			log.Error("Drop expired content fail", common.Hash2String(msg.ID()), err)
+			continue
+		}
+		n.audit(&db.AuditEntry{Kind: AuditPrune, Peer: originLocal, MsgID: msg.ID(), UserID: msg.SenderID, Reason: "expired"})
+	}
+}
+
+// msgHandler return the msg by id in json, the latest edit of msg is returned
+// if latest is true, such as /msg?id=...&latest=true
+func (n Node) msgHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	msgID, err := common.HashFromString(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := n.universe.GetMsgByID(msgID, r.URL.Query().Get("latest") == "true")
+	if msg == nil {
+		http.Error(w, errMsgNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	res, err := json.Marshal(n.viewMsgs([]*core.Message{msg})[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// editsHandler return the version chain of msg, the original msg first and
+// then the edits, such as /edits?id=...
+func (n Node) editsHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	msgID, err := common.HashFromString(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	history := n.universe.GetEditHistory(msgID)
+	if history == nil {
+		http.Error(w, errMsgNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	res, err := json.Marshal(n.viewMsgs(history))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
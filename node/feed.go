@@ -0,0 +1,233 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+// feedBatch is the max number of msgs materialized into a feed at once
+const feedBatch = 1000
+
+// FeedStatus is the progress of space-time feed materialized
+type FeedStatus struct {
+	SpaceTime    common.Hash `json:"spaceTime"`
+	Materialized uint64      `json:"materialized"` // count of msgs added into feed
+	Total        uint64      `json:"total"`        // count of msgs in db
+}
+
+// FeedPage is the page of space-time feed, the seqs are the sequences of
+// msgs in space-time
+type FeedPage struct {
+	FeedStatus
+	View *core.View `json:"view"`
+	Seqs []uint64   `json:"seqs"`
+	Msgs []*MsgView `json:"msgs"`
+}
+
+// feeds keep the sequences of msgs calculated for each space-time, so the
+// msgs added next get their sequences from references directly
+type feeds struct {
+	mu    sync.Mutex
+	memos map[common.Hash]map[common.Hash]uint64
+}
+
+func newFeeds() *feeds {
+	return &feeds{memos: make(map[common.Hash]map[common.Hash]uint64)}
+}
+
+func (f *feeds) memo(spaceTimeID common.Hash) map[common.Hash]uint64 {
+	if f.memos[spaceTimeID] == nil {
+		f.memos[spaceTimeID] = make(map[common.Hash]uint64)
+	}
+	return f.memos[spaceTimeID]
+}
+
+// MaterializeFeeds add the msgs received into the feeds of trusted
+// space-times in batch, the feed of space-time trusted later is filled from
+// the first msg. Return the progress of feeds.
+func (n Node) MaterializeFeeds() ([]*FeedStatus, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	n.feeds.mu.Lock()
+	defer n.feeds.mu.Unlock()
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		return nil, err
+	}
+	var status []*FeedStatus
+	for _, stID := range n.universe.GetTrustedSpaceTimeIDs() {
+		cursor, err := n.materializeFeed(stID, count.Uint64())
+		if err != nil {
+			return status, err
+		}
+		status = append(status, &FeedStatus{SpaceTime: stID, Materialized: cursor, Total: count.Uint64()})
+	}
+	return status, nil
+}
+
+// materializeFeed add the next batch of msgs into feed, msgLock and
+// storeLock are held
+func (n Node) materializeFeed(stID common.Hash, total uint64) (uint64, error) {
+	cursor, err := db.GetFeedCursor(n.udb, stID)
+	if err != nil {
+		return 0, err
+	}
+	if cursor > total {
+		// msgs after cursor are rolled back, entries of them are skipped by query
+		cursor = total
+	}
+	size := total - cursor
+	if size > feedBatch {
+		size = feedBatch
+	}
+	memo := n.feeds.memo(stID)
+	for i, msg := range db.GetMsgByOrder(n.udb, new(big.Int).SetUint64(cursor), int(size)) {
+		seq, err := n.universe.GetSeqWithMemo(msg.ID(), stID, memo)
+		if err == core.ErrMsgNotFound {
+			continue
+		} else if err != nil {
+			return cursor, err
+		}
+		if err := db.SaveFeedEntry(n.udb, stID, &db.FeedEntry{Seq: seq, Order: cursor + uint64(i), MsgID: msg.ID()}); err != nil {
+			return cursor, err
+		}
+	}
+	cursor += size
+	return cursor, db.SaveFeedCursor(n.udb, stID, cursor)
+}
+
+// checkFeeds materialize the feeds in background of node loop
+func (n Node) checkFeeds() {
+	if _, err := n.MaterializeFeeds(); err != nil && err != errUniverseNotExist {
+		log.Error("Materialize feeds fail", err)
+	}
+}
+
+// feedHandler return the page of space-time feed from new to old, primary
+// space-time if st not given, the msgs are only from users followed by
+// user if given and pass the label filter, such as
+// /feed?st=...&user=...&skip=20&limit=20&view=100&without=spam
+func (n Node) feedHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	stID := n.universe.GetPrimarySpaceTime()
+	if s := q.Get("st"); s != "" {
+		id, err := common.ParseUserID(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stID = id
+	}
+	var following map[common.Hash]bool
+	if s := q.Get("user"); s != "" {
+		userID, err := common.ParseUserID(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		following = make(map[common.Hash]bool)
+		for _, id := range n.universe.GetFollowing(userID) {
+			following[id] = true
+		}
+	}
+	view, skip, limit, err := n.parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lf, err := parseLabelFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := n.feedPage(stID, following, lf, view, skip, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := json.Marshal(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// feedPage read the entries of feed until the page is full, the entries
+// not in view, not from following users or not pass label filter are skipped
+func (n Node) feedPage(stID common.Hash, following map[common.Hash]bool, lf *LabelFilter, view *core.View, skip, limit int) (*FeedPage, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	page := &FeedPage{View: view, Seqs: []uint64{}}
+	page.SpaceTime = stID
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		return nil, err
+	}
+	page.Total = count.Uint64()
+	if page.Materialized, err = db.GetFeedCursor(n.udb, stID); err != nil {
+		return nil, err
+	}
+	var msgs []*core.Message
+	for offset := 0; len(msgs) < limit; offset += feedBatch {
+		entries, err := db.GetFeed(n.udb, stID, offset, feedBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !n.universe.InView(view, e.MsgID) {
+				continue
+			}
+			msg := n.universe.GetMsgByID(e.MsgID)
+			if msg == nil || (following != nil && !following[msg.SenderID]) || !n.labeler.match(msg, lf) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			page.Seqs = append(page.Seqs, e.Seq)
+			if msgs = append(msgs, msg); len(msgs) == limit {
+				break
+			}
+		}
+		if len(entries) < feedBatch {
+			break
+		}
+	}
+	page.Msgs = n.viewMsgs(msgs)
+	return page, nil
+}
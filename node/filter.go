@@ -0,0 +1,195 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/filter"
+)
+
+// maxFlaggedMsgs is the count of msgs flagged recently kept for review
+const maxFlaggedMsgs = 1000
+
+var (
+	errFilterNotTrainer    = errors.New("filter can not be trained")
+	errFilterMsgNotFound   = errors.New("msg to train filter not found")
+	errFilterLabelNotValid = errors.New("label of msg should be spam or ham")
+)
+
+// FilterStats is the count of msgs from peers by verdict of filter, the
+// msgs flagged recently and not reviewed yet, and the model of Bayes filter
+type FilterStats struct {
+	Enabled     bool               `json:"enabled"`
+	Accepted    uint64             `json:"accepted"`
+	Flagged     uint64             `json:"flagged"`
+	Rejected    uint64             `json:"rejected"`
+	FlaggedMsgs []common.Hash      `json:"flaggedMsgs"`
+	Bayes       *filter.BayesStats `json:"bayes,omitempty"`
+}
+
+// filterer hold the filter which can be replaced while node running
+type filterer struct {
+	mu          sync.Mutex
+	filter      filter.Filter
+	file        string // Bayes saved into it after trained if not empty
+	accepted    uint64
+	flagged     uint64
+	rejected    uint64
+	flaggedMsgs []common.Hash
+}
+
+func newFilterer() *filterer {
+	return &filterer{}
+}
+
+func (f *filterer) get() (filter.Filter, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.filter, f.file
+}
+
+func (f *filterer) set(flt filter.Filter, fileName string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.filter, f.file = flt, fileName
+}
+
+// check score the msg by filter and count the verdict, msg from user
+// blocked by local user is rejected and from user trusted is accepted
+// without scoring, other msgs are accepted if no filter set
+func (f *filterer) check(msg *core.Message, labels *labeler) filter.Verdict {
+	var score float64
+	var verdict filter.Verdict
+	switch flt, _ := f.get(); {
+	case labels.has(msg.SenderID, db.LabelBlocked):
+		score, verdict = 1, filter.Reject
+	case flt == nil:
+		return filter.Accept
+	case labels.has(msg.SenderID, db.LabelTrusted):
+		verdict = filter.Accept
+	default:
+		score, verdict = flt.Score(msg)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch verdict {
+	case filter.Flag:
+		f.flagged++
+	case filter.Reject:
+		f.rejected++
+	default:
+		f.accepted++
+		return filter.Accept
+	}
+	log.Info("Msg", common.Hash2String(msg.ID()), "scored", score, "by filter", verdict)
+	return verdict
+}
+
+// flag keep the msg committed for review, the oldest is dropped if too many
+func (f *filterer) flag(msgID common.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flaggedMsgs = append(f.flaggedMsgs, msgID)
+	if len(f.flaggedMsgs) > maxFlaggedMsgs {
+		f.flaggedMsgs = f.flaggedMsgs[len(f.flaggedMsgs)-maxFlaggedMsgs:]
+	}
+}
+
+// reviewed remove the msg from flagged msgs
+func (f *filterer) reviewed(msgID common.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, id := range f.flaggedMsgs {
+		if id == msgID {
+			f.flaggedMsgs = append(f.flaggedMsgs[:i], f.flaggedMsgs[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetFilter set the filter scoring the msgs from peers before committed,
+// the msg rejected by it is sent back as rejection, the msg flagged is
+// committed but not gossiped onward, and kept for review. Nil filter
+// accept all msgs, except msgs from users blocked by local user.
+func (n *Node) SetFilter(flt filter.Filter) {
+	n.filterer.set(flt, "")
+}
+
+// LoadFilter set the Bayes filter read from file, the empty one is created
+// if file not exist. The filter is saved into file after trained.
+func (n *Node) LoadFilter(fileName string) (*filter.Bayes, error) {
+	bayes, err := filter.LoadBayes(fileName)
+	if os.IsNotExist(err) {
+		bayes = filter.NewBayes()
+	} else if err != nil {
+		return nil, err
+	}
+	n.filterer.set(bayes, fileName)
+	return bayes, nil
+}
+
+// FilterStats return the count of msgs by verdict of filter, and msgs
+// flagged not reviewed
+func (n *Node) FilterStats() *FilterStats {
+	flt, _ := n.filterer.get()
+	n.filterer.mu.Lock()
+	defer n.filterer.mu.Unlock()
+	stats := &FilterStats{
+		Enabled:     flt != nil,
+		Accepted:    n.filterer.accepted,
+		Flagged:     n.filterer.flagged,
+		Rejected:    n.filterer.rejected,
+		FlaggedMsgs: append([]common.Hash{}, n.filterer.flaggedMsgs...),
+	}
+	if bayes, ok := flt.(*filter.Bayes); ok {
+		stats.Bayes = bayes.Stats()
+	}
+	return stats
+}
+
+// TrainFilter mark the msg in local db as spam or not, the filter learn
+// from it if it is Trainer, and the msg is removed from flagged msgs
+func (n *Node) TrainFilter(msgID common.Hash, spam bool) error {
+	flt, fileName := n.filterer.get()
+	trainer, ok := flt.(filter.Trainer)
+	if !ok {
+		return errFilterNotTrainer
+	}
+	n.storeLock.Lock()
+	msg, err := db.GetMsg(n.udb, msgID)
+	n.storeLock.Unlock()
+	if err == db.ErrMessageNotFound {
+		return errFilterMsgNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := trainer.Train(msg, spam); err != nil {
+		return err
+	}
+	n.filterer.reviewed(msgID)
+	if bayes, ok := flt.(*filter.Bayes); ok && fileName != "" {
+		return bayes.Save(fileName)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+var errPeerNotPinned = errors.New("genesis only fetched from pinned nodes")
+
+// EnableGenesisSync fetch the genesis (roots, first msg and config) from the
+// pinned nodes if local universe not exist, instead of only the roots. The
+// genesis is verified and the universe is created from it. Should be set
+// before Run.
+func (n *Node) EnableGenesisSync() {
+	n.genesisSync = true
+}
+
+// pinned return true if the node key of peer is pinned, so its answers are
+// signed by the node trusted
+func (n Node) pinned(p *peer.Peer) bool {
+	_, ok := n.pinnedNodes[fmt.Sprintf("%s:%d", p.IP, p.Port)]
+	return ok
+}
+
+// genesis return the genesis of local universe, the first msg is the msg
+// of order 0 in db
+func (n Node) genesis() (*core.Genesis, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	user0, user1, err := db.GetRootUsers(n.udb)
+	if err != nil {
+		return nil, err
+	}
+	msgs := db.GetMsgByOrder(n.udb, big.NewInt(0), 1)
+	if len(msgs) == 0 {
+		return nil, errMsgNotExist
+	}
+	config := n.universe.Config()
+	return core.NewGenesis(user0, user1, msgs[0], &config), nil
+}
+
+func (n Node) handleQuestionGenesis(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
+	genesis, err := n.genesis()
+	if err != nil {
+		return wq.WaveID, err
+	}
+	hasher, err := db.GetUniverseHasher(n.udb)
+	if err != nil {
+		return wq.WaveID, err
+	}
+	p := n.wsPeer(ws)
+	if err := p.SendGenesis(wq.WaveID, genesis, hasher.Name(), n.identity); err != nil {
+		return wq.WaveID, err
+	}
+	return wq.WaveID, nil
+}
+
+// initGenesis create the local universe from the genesis answered by pinned
+// node, the roots must be same as the users of wave and the first msg must
+// be valid in the universe of roots, then they are saved into db as the
+// universe created by genesis file.
+func (n *Node) initGenesis(ws *websocket.Conn, wm *galaxy.WaveRoots) error {
+	if err := n.verifyAnswer(ws, wm.WaveID, wm.Auth, wm.SignedData()); err != nil {
+		return err
+	}
+	p, err := n.getPeer(n.questionRecord[wm.WaveID].pid)
+	if err != nil {
+		return err
+	}
+	if !n.pinned(p) {
+		return errPeerNotPinned
+	}
+	genesis := wm.Genesis
+	config := core.DefaultUniverseConfig()
+	if genesis.Config != nil {
+		*config = *genesis.Config
+	}
+	if config.Hasher == "" {
+		config.Hasher = wm.Hasher
+	}
+	if config.NetworkID != n.network {
+		return errNetworkNotMatch
+	}
+	hasher, err := common.SelectHasher(config.Hasher)
+	if err != nil {
+		return err
+	}
+	users, err := genesis.RootUsers()
+	if err != nil {
+		return err
+	}
+	users[0].SetHasher(hasher)
+	users[1].SetHasher(hasher)
+	if users[0].ID() != wm.Users[0].ID() || users[1].ID() != wm.Users[1].ID() || genesis.FirstMsg == nil {
+		return core.ErrGenesisNotValid
+	}
+	// the signature of first msg is verified once added into universe
+	universe, err := core.NewUniverseFromGenesis(&core.Genesis{Roots: genesis.Roots, FirstMsg: genesis.FirstMsg, Config: config})
+	if err != nil {
+		return err
+	}
+	if universe.ID() != wm.Universe {
+		return core.ErrUniverseNotMatch
+	}
+	if err := db.SaveUniverseHasher(n.udb, hasher.Name()); err != nil {
+		return err
+	}
+	if err := db.SaveRootUsers(n.udb, users); err != nil {
+		return err
+	}
+	if err := db.SaveMsg(n.udb, genesis.FirstMsg); err != nil {
+		return err
+	}
+	log.Info("Universe", common.Hash2String(universe.ID()), "created from genesis of", p.Url())
+	n.initStep = db.StepRootsSaved
+	n.universe = universe
+	if n.searchEnable {
+		n.universe.EnableSearch()
+	}
+	return nil
+}
@@ -23,11 +23,13 @@ import (
 	"io"
 	"math/big"
 	"strings"
+	"time"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/common/log"
 	"github.com/pdupub/go-pdu/core"
 	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/filter"
 	"github.com/pdupub/go-pdu/galaxy"
 	"github.com/pdupub/go-pdu/peer"
 	"golang.org/x/net/websocket"
@@ -39,48 +41,206 @@ const (
 
 var (
 	errQuestionUnsupport = errors.New("question unsupport")
+	errMsgRejected       = errors.New("msg rejected")
 )
 
 func (n *Node) handleMessages(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WaveMessages)
+	// msgs are committed by leader, replica follow them after
+	if n.cluster.replica() {
+		return n.forwardMessages(ws, wm)
+	}
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	var acked []common.Hash
+	if wm.Ack && ws != nil {
+		// the wave sent again by peer without ack received is only acked
+		if msgIDs, ok := n.ackedWaves.get(wm.WaveID); ok {
+			p := n.wsPeer(ws)
+			return wm.WaveID, p.SendAck(wm.WaveID, msgIDs...)
+		}
+		// ack the msgs accepted before the wave stopped by rejection
+		defer func() {
+			if len(acked) == 0 {
+				return
+			}
+			if len(acked) == len(wm.Msgs) {
+				n.ackedWaves.add(wm.WaveID, acked)
+			}
+			p := n.wsPeer(ws)
+			if err := p.SendAck(wm.WaveID, acked...); err != nil {
+				log.Error("Send ack fail", err)
+			}
+		}()
+	}
+	var msgs []*core.Message
 	for _, wmsg := range wm.Msgs {
 		var msg core.Message
 		if err := json.Unmarshal(wmsg, &msg); err != nil {
 			return wm.WaveID, err
 		}
+		n.sealMsgs(&msg)
+		// msg processed recently from any peer is not verified again
+		if rejection, ok := n.seen.get(msg.ID(), time.Now()); ok {
+			if rejection == nil {
+				acked = append(acked, msg.ID())
+				continue
+			}
+			return wm.WaveID, n.rejectAgain(ws, wm.WaveID, rejection)
+		}
+		// reject duplicate msg before validation
+		if n.universe != nil && n.universe.HasMsg(msg.ID()) {
+			// sender need not send it again
+			acked = append(acked, msg.ID())
+			return wm.WaveID, n.rejectMsg(ws, wm.WaveID, &msg, core.ErrMsgAlreadyExist)
+		}
+		// msgs from senders with low score are limited first
+		if ws != nil && n.universe != nil && !n.senders.allow(msg.SenderID, senderScore(n.universe, msg.SenderID), time.Now()) {
+			return wm.WaveID, n.rejectMsg(ws, wm.WaveID, &msg, errSenderRateLimited)
+		}
+		msgs = append(msgs, &msg)
+	}
+	if n.universe == nil {
+		return wm.WaveID, errUniverseNotExist
+	}
+	// validate in parallel, then commit one by one
+	receipts, errs := n.validateMsgs(msgs)
+	for i, msg := range msgs {
+		if errs[i] == core.ErrUserNotExist {
+			// sender may be created by the msg before in same wave
+			receipts[i], errs[i] = n.universe.Validate(msg)
+		}
+		if errs[i] != nil {
+			return wm.WaveID, n.rejectMsg(ws, wm.WaveID, msg, errs[i])
+		}
+		verdict := n.filterer.check(msg, n.labeler)
+		if verdict == filter.Reject {
+			return wm.WaveID, n.rejectMsg(ws, wm.WaveID, msg, core.ErrMsgFiltered)
+		}
 		// save msg (universe & udb)
-		if err := n.saveMsg(&msg); err != nil {
-			return wm.WaveID, err
-		} else if err := n.broadcastMsg(&msg); err != nil {
-			return wm.WaveID, err
+		if err := n.commitMsg(receipts[i], n.msgOrigin(ws)); err != nil {
+			return wm.WaveID, n.rejectMsg(ws, wm.WaveID, msg, err)
+		}
+		acked = append(acked, msg.ID())
+		// msg flagged by filter is kept for review, not gossiped
+		if verdict == filter.Flag {
+			n.filterer.flag(msg.ID())
+			continue
+		}
+		// gossip onward if allowed by relay policy
+		if n.relayer.relay(msg, n.universe, n.labeler.of(msg)) {
+			if err := n.broadcastMsg(msg); err != nil {
+				return wm.WaveID, err
+			}
 		}
 	}
 	return wm.WaveID, nil
 }
 
+// rejectMsg record the reason why msg be rejected, and send it back to the
+// peer if msg received from ws, duplicate msg is only recorded.
+func (n Node) rejectMsg(ws *websocket.Conn, waveID common.Hash, msg *core.Message, err error) error {
+	rejection := n.universe.Reject(msg, err)
+	peerAddr := n.msgOrigin(ws)
+	if _, ok := n.rejectionCnt[peerAddr]; !ok {
+		n.rejectionCnt[peerAddr] = make(map[int]uint64)
+	}
+	n.rejectionCnt[peerAddr][rejection.Code]++
+	n.auditReject(rejection, peerAddr)
+	if rejection.Code == core.RejectDuplicate {
+		return err
+	}
+	if !retryable(rejection) {
+		n.seen.reject(rejection, time.Now())
+	}
+	log.Warn("Msg", common.Hash2String(rejection.MsgID), "from", peerAddr, "rejected", rejection.Code, rejection.Reason)
+	if ws == nil {
+		return err
+	}
+	p := n.wsPeer(ws)
+	if sendErr := p.SendRejections(waveID, rejection); sendErr != nil {
+		return sendErr
+	}
+	return errMsgRejected
+}
+
+// rejectAgain send the rejection of msg seen recently back to the peer, the
+// msg is not verified and recorded again
+func (n Node) rejectAgain(ws *websocket.Conn, waveID common.Hash, rejection *core.Rejection) error {
+	if ws == nil {
+		return errMsgRejected
+	}
+	p := n.wsPeer(ws)
+	if err := p.SendRejections(waveID, rejection); err != nil {
+		return err
+	}
+	return errMsgRejected
+}
+
+func (n *Node) handleRejections(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveRejections)
+	for _, r := range wm.Rejections {
+		log.Warn("Msg", common.Hash2String(r.MsgID), "rejected by peer", r.Code, r.Reason, r.Rule)
+		n.acker.reject(wm.WaveID, r)
+		n.undeliverable(wm.WaveID, r)
+	}
+	return wm.WaveID, nil
+}
+
 func (n Node) handlePing(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WavePing)
-	p := peer.Peer{Conn: ws}
+	p := n.wsPeer(ws)
 	return wm.WaveID, p.SendPong(wm.WaveID)
 }
 
 func (n *Node) handlePong(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WavePong)
+	if r, ok := n.pingpongRecord[wm.WaveID]; ok {
+		n.stats.observeRTT(r.pid, time.Since(r.sent))
+	}
 	return wm.WaveID, nil
 }
 
 func (n *Node) handleRoots(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WaveRoots)
+	// peer on other network is never synced, even if the roots are same
+	if wm.Network != n.network {
+		return wm.WaveID, errNetworkNotMatch
+	}
+	// IDs of roots are calculated by the hasher of universe answered
+	hasher, err := common.SelectHasher(wm.Hasher)
+	if err != nil {
+		return wm.WaveID, err
+	}
+	wm.Users[0].SetHasher(hasher)
+	wm.Users[1].SetHasher(hasher)
+	id := core.GenesisHash(wm.Network, wm.Users[0], wm.Users[1])
+	if (!wm.Universe.IsZero() && wm.Universe != id) || !n.universeMatch(id) {
+		n.rejectUniverse(wm.WaveID)
+		return wm.WaveID, core.ErrUniverseNotMatch
+	}
+	if n.initStep < db.StepRootsSaved && wm.Genesis != nil {
+		return wm.WaveID, n.initGenesis(ws, wm)
+	}
 	if n.initStep < db.StepRootsSaved {
 		user0 := wm.Users[0]
 		user1 := wm.Users[1]
+		config := core.DefaultUniverseConfig()
+		config.Hasher = wm.Hasher
+		config.NetworkID = n.network
+		universe, err := core.NewUniverseWithConfig(user0, user1, config)
+		if err != nil {
+			return wm.WaveID, err
+		}
 		log.Info("user0", common.Hash2String(user0.ID()))
 		log.Info("user1", common.Hash2String(user1.ID()))
 		// update init step
-		var err error
 		n.initStep = db.StepRootsSaved
-		n.universe, err = core.NewUniverse(user0, user1)
-		if err != nil {
+		n.universe = universe
+		if n.searchEnable {
+			n.universe.EnableSearch()
+		}
+		if err := db.SaveUniverseHasher(n.udb, hasher.Name()); err != nil {
 			return wm.WaveID, err
 		}
 		if err := db.SaveRootUsers(n.udb, wm.Users[:]); err != nil {
@@ -92,12 +252,24 @@ func (n *Node) handleRoots(ws *websocket.Conn, w galaxy.Wave) (common.Hash, erro
 
 func (n *Node) handlePeers(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WavePeers)
-	for _, peerBytes := range wm.Peers {
+	if err := n.verifyAnswer(ws, wm.WaveID, wm.Auth, wm.SignedData()); err != nil {
+		return wm.WaveID, err
+	}
+	for i, peerBytes := range wm.Peers {
 		var targetPeer peer.Peer
 		err := json.Unmarshal(peerBytes, &targetPeer)
 		if err != nil {
 			return wm.WaveID, err
 		}
+		// the answering peer is the last one, other peers in different
+		// universe are just skipped
+		if !n.universeMatch(targetPeer.Universe) {
+			if i == len(wm.Peers)-1 {
+				n.rejectUniverse(wm.WaveID)
+				return wm.WaveID, core.ErrUniverseNotMatch
+			}
+			continue
+		}
 
 		if err := n.AddPeer(&targetPeer); err != nil {
 			if err != errPeerAlreadyExist {
@@ -111,48 +283,67 @@ func (n *Node) handlePeers(ws *websocket.Conn, w galaxy.Wave) (common.Hash, erro
 
 func (n *Node) handleErr(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WaveErr)
-	log.Error("Received waveErr", wm.Err, "by wave", common.Hash2String(wm.WaveID))
+	log.Error("Received waveErr", wm.Err, "code", wm.Code, "by wave", common.Hash2String(wm.WaveID))
+	// the handshake is rejected by peer in other universe
+	if wm.Code == core.ErrUniverseNotMatch.Code {
+		n.rejectUniverse(wm.WaveID)
+	}
 	return wm.WaveID, nil
 }
 
 func (n Node) handleQuestionRoots(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
-	p := peer.Peer{Conn: ws}
+	p := n.wsPeer(ws)
 	user0, user1, err := db.GetRootUsers(n.udb)
 	if err != nil {
 		return wq.WaveID, err
 	}
-	if err = p.SendRoots(wq.WaveID, user0, user1); err != nil {
+	hasher, err := db.GetUniverseHasher(n.udb)
+	if err != nil {
+		return wq.WaveID, err
+	}
+	if err = p.SendRoots(wq.WaveID, user0, user1, hasher.Name(), n.network); err != nil {
 		return wq.WaveID, err
 	}
 	return wq.WaveID, nil
 }
 
-func (n Node) handleQuestionPeers(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
-	p := peer.Peer{Conn: ws}
-	if err := p.SendPeers(wq.WaveID, n.peers, n.localPeer()); err != nil {
+func (n Node) handleQuestionPeers(ws *websocket.Conn, wq *galaxy.WaveQuestion, q *galaxy.PeersQuestion) (common.Hash, error) {
+	p := n.wsPeer(ws)
+	var remotePeer peer.Peer
+	if err := json.Unmarshal(q.Peer, &remotePeer); err != nil {
+		return wq.WaveID, err
+	}
+	// peers in other universe are never answered
+	if !n.universeMatch(remotePeer.Universe) {
+		return wq.WaveID, core.ErrUniverseNotMatch
+	}
+	if err := p.SendPeers(wq.WaveID, n.copyPeers(), n.localPeer(), n.identity); err != nil {
 		return wq.WaveID, err
 	}
 	// add request peer to node.peers
-	var remotePeer peer.Peer
-	if err := json.Unmarshal(wq.Args[0], &remotePeer); err != nil {
+	// get remote ip address, forwarded by trusted proxy if exist
+	if ip := n.clientIP(ws.Request()); ip != nil {
+		remotePeer.IP = ip.String()
+	} else {
+		remotePeer.IP = strings.Split(ws.Request().RemoteAddr, ":")[0]
+	}
+	// the handshake is signed by request node, nodes with legacy key not sign
+	if err := n.verifyPeer(&remotePeer, q.Auth, galaxy.SignedData(wq.WaveID, q.Peer)); err != nil {
 		return wq.WaveID, err
 	}
-	// get remote ip address
-	remoteAddr := strings.Split(ws.Request().RemoteAddr, ":")
-	remotePeer.IP = remoteAddr[0]
-	if err := n.AddPeer(&remotePeer); err != nil {
+	if err := n.addPeer(&remotePeer, true); err != nil {
 		return wq.WaveID, err
 	}
 	return wq.WaveID, nil
 }
 
-func (n Node) handleQuestionMsg(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
-	p := peer.Peer{Conn: ws}
+func (n Node) handleQuestionMsg(ws *websocket.Conn, wq *galaxy.WaveQuestion, q *galaxy.MessagesQuestion) (common.Hash, error) {
+	p := n.wsPeer(ws)
 
 	var order, count *big.Int
 	var err error
 	var msgs []*core.Message
-	msgID := common.Bytes2Hash(wq.Args[0])
+	msgID := q.LastMsgID
 
 	if msgID != common.Bytes2Hash([]byte{}) {
 		order, count, err = db.GetOrderCntByMsg(n.udb, msgID)
@@ -168,24 +359,67 @@ func (n Node) handleQuestionMsg(ws *websocket.Conn, wq *galaxy.WaveQuestion) (co
 		}
 	}
 
-	if order != nil && count != nil && count.Uint64()-order.Uint64() > peer.MaxMsgCountPerWave {
+	if order != nil && count != nil && count.Cmp(order) > 0 {
 		//log.Debug("Send msg from order", order, "size", peer.MaxMsgCountPerWave)
 		msgs = db.GetMsgByOrder(n.udb, order, peer.MaxMsgCountPerWave)
 	}
-	if err = p.SendMsgs(wq.WaveID, msgs); err != nil {
+	var total uint64
+	if count != nil {
+		total = count.Uint64()
+	}
+	if err = p.SendMsgRange(wq.WaveID, msgs, total); err != nil {
+		return wq.WaveID, err
+	}
+	return wq.WaveID, nil
+}
+
+// handleQuestionMsgRange answer the msgs by order range with the msg count
+// of local node, the count of msgs is limited by MaxSyncMsgCountPerWave
+func (n Node) handleQuestionMsgRange(ws *websocket.Conn, wq *galaxy.WaveQuestion, q *galaxy.MsgRangeQuestion) (common.Hash, error) {
+	from := new(big.Int).SetUint64(q.From)
+	count := new(big.Int).SetUint64(q.Count)
+	if count.Cmp(big.NewInt(peer.MaxSyncMsgCountPerWave)) > 0 {
+		count.SetInt64(peer.MaxSyncMsgCountPerWave)
+	}
+	total, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		return wq.WaveID, err
+	}
+	var msgs []*core.Message
+	if total.Cmp(from) > 0 {
+		msgs = db.GetMsgByOrder(n.udb, from, int(count.Int64()))
+	}
+	p := n.wsPeer(ws)
+	if err := p.SendMsgRange(wq.WaveID, msgs, total.Uint64()); err != nil {
 		return wq.WaveID, err
 	}
 	return wq.WaveID, nil
 }
+
 func (n Node) handleQuestion(ws *websocket.Conn, w galaxy.Wave) (waveID common.Hash, err error) {
 	waveQuestion := w.(*galaxy.WaveQuestion)
-	switch waveQuestion.Cmd {
-	case galaxy.CmdRoots:
+	// args are checked by the schema of question before answered
+	question, err := waveQuestion.Question()
+	if err != nil {
+		return waveQuestion.WaveID, err
+	}
+	switch q := question.(type) {
+	case *galaxy.RootsQuestion:
 		waveID, err = n.handleQuestionRoots(ws, waveQuestion)
-	case galaxy.CmdPeers:
-		waveID, err = n.handleQuestionPeers(ws, waveQuestion)
-	case galaxy.CmdMessages:
-		waveID, err = n.handleQuestionMsg(ws, waveQuestion)
+	case *galaxy.PeersQuestion:
+		waveID, err = n.handleQuestionPeers(ws, waveQuestion, q)
+	case *galaxy.MessagesQuestion:
+		waveID, err = n.handleQuestionMsg(ws, waveQuestion, q)
+	case *galaxy.CheckpointsQuestion:
+		waveID, err = n.handleQuestionCheckpoints(ws, waveQuestion, q)
+	case *galaxy.MsgRangeQuestion:
+		waveID, err = n.handleQuestionMsgRange(ws, waveQuestion, q)
+	case *galaxy.SnapshotQuestion:
+		waveID, err = n.handleQuestionSnapshot(ws, waveQuestion)
+	case *galaxy.SnapshotChunkQuestion:
+		waveID, err = n.handleQuestionSnapshotChunk(ws, waveQuestion, q)
+	case *galaxy.GenesisQuestion:
+		waveID, err = n.handleQuestionGenesis(ws, waveQuestion)
 	default:
 		waveID, err = waveQuestion.WaveID, errQuestionUnsupport
 	}
@@ -197,6 +431,13 @@ func (n *Node) handleWave(ws *websocket.Conn, w galaxy.Wave, alwaysTrue bool) (w
 	case galaxy.CmdMessages:
 		if !alwaysTrue && !n.wsAcceptMsg {
 			waveID, err = w.(*galaxy.WaveMessages).WaveID, nil
+		} else if !alwaysTrue && ws != nil {
+			// msgs submitted by ws need api key of submit role if enabled
+			if err = n.apiKeys.authorize(apiKeyOf(ws.Request()), RoleSubmit); err != nil {
+				waveID = w.(*galaxy.WaveMessages).WaveID
+			} else {
+				waveID, err = n.handleMessages(ws, w)
+			}
 		} else {
 			waveID, err = n.handleMessages(ws, w)
 		}
@@ -212,6 +453,18 @@ func (n *Node) handleWave(ws *websocket.Conn, w galaxy.Wave, alwaysTrue bool) (w
 		waveID, err = n.handlePeers(ws, w)
 	case galaxy.CmdErr:
 		waveID, err = n.handleErr(ws, w)
+	case galaxy.CmdCheckpoints:
+		waveID, err = n.handleCheckpoints(ws, w)
+	case galaxy.CmdRejections:
+		waveID, err = n.handleRejections(ws, w)
+	case galaxy.CmdSnapshot:
+		waveID, err = n.handleSnapshot(ws, w)
+	case galaxy.CmdAck:
+		waveID, err = n.handleAck(ws, w)
+	case galaxy.CmdInv:
+		waveID, err = n.handleInv(ws, w)
+	case galaxy.CmdGetMsgs:
+		waveID, err = n.handleGetMsgs(ws, w)
 	default:
 		waveID, err = common.Hash{}, fmt.Errorf("unhandled command [%s]", w.Command())
 	}
@@ -224,29 +477,53 @@ func (n Node) serveReceiveWave(r io.Reader, kh common.Hash, chanWave chan<- gala
 		w, err := galaxy.ReceiveWave(r)
 		if err != nil {
 			log.Error("Serve receive wave fail", err)
-			chanSig <- kh
+			select {
+			case chanSig <- kh:
+			case <-n.ctx.Done():
+			}
 			log.Trace("Stop receive wave", common.Hash2String(kh))
 			break
 		}
-		chanWave <- w
+		select {
+		case chanWave <- w:
+		case <-n.ctx.Done():
+			return
+		}
 	}
 }
 
 func (n Node) wsHandler(ws *websocket.Conn) {
 	chanWave := make(chan galaxy.Wave)
 	chanSig := make(chan common.Hash)
-	p := peer.Peer{Conn: ws}
-	go n.serveReceiveWave(ws, common.Hash{}, chanWave, chanSig)
+	m := n.bandwidth.openInbound(ws)
+	defer n.bandwidth.closeInbound(ws)
+	p := n.wsPeer(ws)
+	// the conn is closed once node stopping, so the blocked read and write return
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-n.ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+	go n.serveReceiveWave(m.Reader(ws), common.Hash{}, chanWave, chanSig)
 	for {
 		select {
 		case w := <-chanWave:
 			waveID, err := n.handleWave(ws, w, false)
-			if err != nil {
+			if err == errMsgRejected {
+				// rejections already be sent
+				continue
+			} else if err != nil {
 				log.Error("Socket Handler", err)
 				p.SendErr(waveID, err)
 			}
 		case <-chanSig:
 			return
+		case <-n.ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,138 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// DefaultReadyMinPeers is the number of peers connected before node ready
+	DefaultReadyMinPeers = 1
+
+	// DefaultReadyMaxLag is the number of msgs node can be behind peers
+	// while ready
+	DefaultReadyMaxLag = 100
+)
+
+// Names of readiness checks
+const (
+	CheckStorage   = "storage"
+	CheckUniverse  = "universe"
+	CheckPeers     = "peers"
+	CheckSync      = "sync"
+	CheckTimeProof = "timeproof"
+)
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Readiness is whether node can serve peers and clients, ready only if
+// all checks ok
+type Readiness struct {
+	Ready  bool           `json:"ready"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// SetReadiness set the min number of peers connected and the max number of
+// msgs behind peers, checked by Ready, should be set before Run
+func (n *Node) SetReadiness(minPeers int, maxLag uint64) {
+	n.readyMinPeers = minPeers
+	n.readyMaxLag = maxLag
+}
+
+// Ready check the storage is open, universe is loaded, enough peers are
+// connected and msgs are synced from peers
+func (n Node) Ready() *Readiness {
+	r := &Readiness{Ready: true}
+	add := func(name string, err error, detail string) {
+		c := &HealthCheck{Name: name, OK: err == nil, Detail: detail}
+		if err != nil {
+			c.Detail = err.Error()
+			r.Ready = false
+		}
+		r.Checks = append(r.Checks, c)
+	}
+
+	n.storeLock.RLock()
+	_, err := db.GetMsgCount(n.udb)
+	n.storeLock.RUnlock()
+	add(CheckStorage, err, "")
+
+	if n.universe == nil {
+		add(CheckUniverse, errUniverseNotExist, "")
+	} else {
+		add(CheckUniverse, nil, "")
+	}
+
+	connected := 0
+	for _, p := range n.copyPeers() {
+		if p.Connected() {
+			connected++
+		}
+	}
+	if connected < n.readyMinPeers {
+		add(CheckPeers, fmt.Errorf("%d of %d peers connected", connected, n.readyMinPeers), "")
+	} else {
+		add(CheckPeers, nil, fmt.Sprintf("%d peers connected", connected))
+	}
+
+	// the lag is the msgs behind the max msg count of peers known by sync
+	lag := n.SyncStatus().Remaining
+	if lag > n.readyMaxLag {
+		add(CheckSync, fmt.Errorf("%d msgs behind peers", lag), "")
+	} else {
+		add(CheckSync, nil, fmt.Sprintf("%d msgs behind peers", lag))
+	}
+
+	// the time proof is only checked on node publishing it
+	if tp := n.TimeProofStats(); tp.Alert {
+		add(CheckTimeProof, fmt.Errorf("%d time proofs failed, %s", tp.Failures, tp.LastError), "")
+	} else if tp.Enabled {
+		add(CheckTimeProof, nil, fmt.Sprintf("%d time proofs published", tp.Published))
+	}
+	return r
+}
+
+// healthzHandler answer ok while the process is up
+func (n Node) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+// readyzHandler answer the readiness, the status is 503 if not ready
+func (n Node) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := n.Ready()
+	res, err := json.Marshal(readiness)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !readiness.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(res)
+}
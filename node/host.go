@@ -0,0 +1,233 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+// UniversePathPrefix is the path prefix of apis of hosted universe, such as
+// /u/{universeID}/search?q=hello, the universeID is in hex
+const UniversePathPrefix = "/u/"
+
+var (
+	errUniverseAlreadyHosted = errors.New("universe already hosted")
+	errNodeAlreadyHosted     = errors.New("node already hosted")
+)
+
+// Host serve many nodes in one process on one port, each node host one
+// universe with its own db, peers and apis. Peers dial the node by its
+// node key as usual, apis are routed by universe ID.
+// Universes hosted can use diff hashers, each is bound to its own universe.
+type Host struct {
+	port   uint64
+	mu     sync.RWMutex
+	nodes  map[common.Hash]*Node        // universe ID : node
+	routes map[common.Hash]http.Handler // universe ID : handler of node
+	keys   map[string]common.Hash       // node key : universe ID
+	prefix string                       // all paths are also served under it
+}
+
+// NewHost create the host listen on port
+func NewHost(port uint64) *Host {
+	return &Host{
+		port:   port,
+		nodes:  make(map[common.Hash]*Node),
+		routes: make(map[common.Hash]http.Handler),
+		keys:   make(map[string]common.Hash),
+	}
+}
+
+// SetPathPrefix mount all paths of host under the prefix, such as /pdu if
+// proxy pass /pdu/ to host without strip
+func (h *Host) SetPathPrefix(prefix string) {
+	h.prefix = strings.TrimRight(prefix, "/")
+}
+
+// NewNode create the node of udb to be added into host
+func (h *Host) NewNode(udb db.UDB) (*Node, error) {
+	return New(udb)
+}
+
+// AddNode add node into host before Run, the roots of node universe should
+// be loaded. The node should be created by NewNode.
+func (h *Host) AddNode(n *Node) error {
+	if n.universe == nil {
+		return errUniverseNotExist
+	}
+	if n.host != nil {
+		return errNodeAlreadyHosted
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := n.universe.ID()
+	if _, ok := h.nodes[id]; ok {
+		return errUniverseAlreadyHosted
+	}
+	n.host = h
+	n.localPort = h.port
+	h.nodes[id] = n
+	h.routes[id] = n.Handler()
+	h.keys[n.localNodeKey] = id
+	if n.legacyNodeKey != "" {
+		h.keys[n.legacyNodeKey] = id
+	}
+	// peers saved before may be the nodes of other universes on this host
+	for _, hn := range h.nodes {
+		hn.peerLock.Lock()
+		for k, p := range hn.peers {
+			if !h.acceptPeerLocked(hn, p) {
+				delete(hn.peers, k)
+			}
+		}
+		hn.peerLock.Unlock()
+	}
+	log.Info("Host universe", common.Hash2String(id), "node key", n.localNodeKey)
+	return nil
+}
+
+// Node return the node host the universe, nil if not exist
+func (h *Host) Node(universeID common.Hash) *Node {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.nodes[universeID]
+}
+
+// UniverseIDs return the IDs of all universes hosted
+func (h *Host) UniverseIDs() []common.Hash {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var ids []common.Hash
+	for id := range h.nodes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// acceptPeer return false if the peer is other node on this host, which
+// must be in other universe
+func (h *Host) acceptPeer(n *Node, p *peer.Peer) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.acceptPeerLocked(n, p)
+}
+
+func (h *Host) acceptPeerLocked(n *Node, p *peer.Peer) bool {
+	id, ok := h.keys[p.NodeKey]
+	return !ok || n.universe == nil || id == n.universe.ID()
+}
+
+// ServeHTTP route the request to the node. /u/{universeID}/... is routed by
+// universe ID with the prefix removed, /{nodeKey} is the ws of the node,
+// and / list the universes hosted.
+func (h *Host) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		var ids []string
+		for _, id := range h.UniverseIDs() {
+			ids = append(ids, common.Hash2String(id))
+		}
+		res, err := json.Marshal(ids)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(res)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, UniversePathPrefix) {
+		h.mu.RLock()
+		id, ok := h.keys[strings.TrimPrefix(r.URL.Path, "/")]
+		handler := h.routes[id]
+		h.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, UniversePathPrefix)
+	idStr, subPath := rest, "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		idStr, subPath = rest[:i], rest[i:]
+	}
+	id, err := common.HashFromString(idStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.RLock()
+	handler, ok := h.routes[id]
+	h.mu.RUnlock()
+	if !ok {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = subPath
+	r2.URL.RawPath = ""
+	handler.ServeHTTP(w, r2)
+}
+
+// Run all nodes and serve them on port of host, stop all nodes when
+// signal received from c
+func (h *Host) Run(c <-chan os.Signal) {
+	h.mu.RLock()
+	nodes := make([]*Node, 0, len(h.nodes))
+	for _, n := range h.nodes {
+		nodes = append(nodes, n)
+	}
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	sigs := make([]chan os.Signal, len(nodes))
+	for i, n := range nodes {
+		sigs[i] = make(chan os.Signal, 1)
+		wg.Add(1)
+		go func(n *Node, c <-chan os.Signal) {
+			defer wg.Done()
+			n.Run(c)
+		}(n, sigs[i])
+	}
+	server := &http.Server{Addr: fmt.Sprintf(":%d", h.port), Handler: withPathPrefix(h.prefix, h)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Start host serve fail", err)
+		}
+	}()
+	log.Info("Host", len(nodes), "universes on port", h.port)
+
+	sig := <-c
+	for _, s := range sigs {
+		s <- sig
+	}
+	wg.Wait()
+	server.Close()
+	log.Info("Stop host")
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+// initTestUDB create the buckets and save the root users into empty udb,
+// the universe in it use the hasher. The roots and their keys are returned.
+func initTestUDB(t testing.TB, udb db.UDB, hasher string) ([2]*core.User, [2]*crypto.PrivateKey) {
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketUser, db.BucketMsg, db.BucketMID,
+		db.BucketMOD, db.BucketLastMID, db.BucketPeer, db.BucketCheckpoint, db.BucketSenderMID, db.BucketTypeMID,
+		db.BucketContent, db.BucketContentRef, db.BucketNotify, db.BucketAudit, db.BucketOutbox,
+		db.BucketOutboxEvent, db.BucketDelivery, db.BucketArchive, db.BucketFeed, db.BucketAnchor,
+		db.BucketLabel); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSchemaVersion(udb, db.SchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveUniverseHasher(udb, hasher); err != nil {
+		t.Fatal(err)
+	}
+	h, err := common.SelectHasher(hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := ethereum.New()
+	var roots [2]*core.User
+	var keys [2]*crypto.PrivateKey
+	for roots[0] == nil || roots[1] == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// gender of root user is decided by its ID under the hasher
+		user := core.CreateRootUser(*pubKey, "host", "")
+		user.SetHasher(h)
+		i := 0
+		if user.Gender() {
+			i = 1
+		}
+		roots[i], keys[i] = user, priKey
+	}
+	if err := db.SaveRootUsers(udb, roots[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepRootsSaved).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return roots, keys
+}
+
+func TestHost_AddNodeDiffHasher(t *testing.T) {
+	h := NewHost(DefaultLocalPort)
+	hashers := []string{common.HasherSHA256, common.HasherBLAKE3}
+	nodes := make([]*Node, len(hashers))
+	for i, hasher := range hashers {
+		udb := memdb.New()
+		initTestUDB(t, udb, hasher)
+		n, err := h.NewNode(udb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.AddNode(n); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+	}
+	for i, n := range nodes {
+		if n.universe.Hasher().Name() != hashers[i] {
+			t.Errorf("hasher of universe should be %s, but get %s", hashers[i], n.universe.Hasher().Name())
+		}
+		if h.nodes[n.universe.ID()] != n {
+			t.Error("node should be hosted by its universe ID")
+		}
+	}
+}
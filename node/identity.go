@@ -0,0 +1,249 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+// nodeKeyLength is the length of node key derived from public key of node,
+// node keys created before are shorter and not verified
+const nodeKeyLength = sha256.Size * 2
+
+var (
+	errNodeSignatureMissing  = errors.New("node signature missing")
+	errNodeSignatureNotValid = errors.New("node signature not valid")
+	errNodeKeyNotMatch       = errors.New("node key not match")
+	errNodeKeyNotValid       = errors.New("node key not valid")
+	errAnswerNotAsked        = errors.New("answer not asked")
+)
+
+// nodeIdentity is the key pair of node, separated from the keys of users.
+// The node signs the handshake, peers and checkpoints it answered.
+type nodeIdentity struct {
+	priKey *crypto.PrivateKey
+	pubKey *crypto.PublicKey
+}
+
+// nodeKeyPair is the key pair of node saved in db
+type nodeKeyPair struct {
+	PriKey json.RawMessage `json:"priKey"`
+	PubKey json.RawMessage `json:"pubKey"`
+}
+
+// Sign the data by the private key of node, safe for concurrent use since
+// the engines do not change the key while signing
+func (id *nodeIdentity) Sign(data []byte) (*galaxy.NodeSignature, error) {
+	engine, err := utils.SelectEngine(id.priKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := engine.Sign(data, id.priKey)
+	if err != nil {
+		return nil, err
+	}
+	return &galaxy.NodeSignature{PubKey: core.Auth{PublicKey: *id.pubKey}, Signature: sig.Signature}, nil
+}
+
+// NodeKeyOf return the node key of public key, which is the hex of sha256
+// of public key, used in the url of node
+func NodeKeyOf(pubKey *crypto.PublicKey) (string, error) {
+	pkBytes, err := core.Auth{PublicKey: *pubKey}.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(pkBytes)
+	return common.Bytes2String(h[:]), nil
+}
+
+// isNodeKey return true if the key is derived from public key of node
+func isNodeKey(key string) bool {
+	if len(key) != nodeKeyLength {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// verifyNodeSignature check the data is signed by the node of node key
+func verifyNodeSignature(sig *galaxy.NodeSignature, data []byte, nodeKey string) error {
+	if sig == nil {
+		return errNodeSignatureMissing
+	}
+	if key, err := NodeKeyOf(&sig.PubKey.PublicKey); err != nil || !strings.EqualFold(key, nodeKey) {
+		return errNodeKeyNotMatch
+	}
+	engine, err := utils.SelectEngine(sig.PubKey.Source)
+	if err != nil {
+		return err
+	}
+	signature := &crypto.Signature{PublicKey: sig.PubKey.PublicKey, Signature: sig.Signature}
+	if ok, err := engine.Verify(data, signature); err != nil || !ok {
+		return errNodeSignatureNotValid
+	}
+	return nil
+}
+
+// setLocalNodeKey load the key pair of node, or create it if not exist. The
+// node key created before node has key pair is still served as legacy key.
+func (n *Node) setLocalNodeKey() error {
+	pairBytes, err := n.udb.Get(db.BucketConfig, db.ConfigNodeKeyPair)
+	if err != nil {
+		return err
+	}
+	if pairBytes == nil {
+		if n.identity, err = n.createNodeKeyPair(); err != nil {
+			return err
+		}
+	} else if n.identity, err = parseNodeKeyPair(pairBytes); err != nil {
+		return err
+	}
+	if n.localNodeKey, err = NodeKeyOf(n.identity.pubKey); err != nil {
+		return err
+	}
+	legacyKey, err := n.udb.Get(db.BucketConfig, db.ConfigLocalNodeKey)
+	if err != nil {
+		return err
+	}
+	if legacyKey == nil {
+		keyBytes, err := hex.DecodeString(n.localNodeKey)
+		if err != nil {
+			return err
+		}
+		if err := n.udb.Set(db.BucketConfig, db.ConfigLocalNodeKey, keyBytes); err != nil {
+			return err
+		}
+	} else if key := common.Bytes2String(legacyKey); key != n.localNodeKey {
+		n.legacyNodeKey = key
+		log.Info("Legacy local node key", n.legacyNodeKey)
+	}
+	log.Info("Load local node key", n.localNodeKey)
+	return nil
+}
+
+func (n *Node) createNodeKeyPair() (*nodeIdentity, error) {
+	engine := ethereum.New()
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	priKeyBytes, pubKeyBytes, err := engine.Marshal(priKey, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	pairBytes, err := json.Marshal(&nodeKeyPair{PriKey: priKeyBytes, PubKey: pubKeyBytes})
+	if err != nil {
+		return nil, err
+	}
+	if err := n.udb.Set(db.BucketConfig, db.ConfigNodeKeyPair, pairBytes); err != nil {
+		return nil, err
+	}
+	log.Info("Create new local node key pair")
+	return &nodeIdentity{priKey: priKey, pubKey: pubKey}, nil
+}
+
+func parseNodeKeyPair(pairBytes []byte) (*nodeIdentity, error) {
+	var pair nodeKeyPair
+	if err := json.Unmarshal(pairBytes, &pair); err != nil {
+		return nil, err
+	}
+	priKey, pubKey, err := utils.ParsePrivateKey(pair.PriKey, pair.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeIdentity{priKey: priKey, pubKey: pubKey}, nil
+}
+
+// LocalNodeKey return the node key of local node, which is derived from
+// public key of node, used in the url of node and to pin the node by peers
+func (n Node) LocalNodeKey() string {
+	return n.localNodeKey
+}
+
+// isLocalNodeKey return true if the key is the key of local node
+func (n Node) isLocalNodeKey(key string) bool {
+	return key == n.localNodeKey || (n.legacyNodeKey != "" && key == n.legacyNodeKey)
+}
+
+// PinNodes pin the node keys of nodes [userid@ip:port/nodeKey] split by comma,
+// the answers from ip:port must be signed by the key of pinned node, so the
+// well-known nodes can not be impersonated even if dialed by legacy key.
+func (n *Node) PinNodes(nodes string) error {
+	if n.pinnedNodes == nil {
+		n.pinnedNodes = make(map[string]string)
+	}
+	for _, nodeStr := range strings.Split(nodes, ",") {
+		p, err := parseNodeAddress(nodeStr)
+		if err != nil {
+			return err
+		}
+		if !isNodeKey(p.NodeKey) {
+			return errNodeKeyNotValid
+		}
+		n.pinnedNodes[fmt.Sprintf("%s:%d", p.IP, p.Port)] = p.NodeKey
+	}
+	return nil
+}
+
+// verifyPeer check the data is signed by the node of peer. The key pinned
+// for the address of peer is used if exist, then the key in url of peer.
+// Peers with legacy key and not pinned are not verified.
+func (n Node) verifyPeer(p *peer.Peer, sig *galaxy.NodeSignature, data []byte) error {
+	nodeKey := p.NodeKey
+	if pinned, ok := n.pinnedNodes[fmt.Sprintf("%s:%d", p.IP, p.Port)]; ok {
+		nodeKey = pinned
+	}
+	if !isNodeKey(nodeKey) {
+		return nil
+	}
+	return verifyNodeSignature(sig, data, nodeKey)
+}
+
+// verifyAnswer check the answer is signed by the peer asked, the answers
+// are only accepted from the peers dialed by local node
+func (n *Node) verifyAnswer(ws *websocket.Conn, waveID common.Hash, sig *galaxy.NodeSignature, data []byte) error {
+	r, ok := n.questionRecord[waveID]
+	if !ok || ws != nil {
+		return errAnswerNotAsked
+	}
+	p, err := n.getPeer(r.pid)
+	if err != nil {
+		return err
+	}
+	if err := n.verifyPeer(p, sig, data); err != nil {
+		log.Warn("Answer from", p.Url(), "not signed by node", err)
+		n.removePeer(r.pid)
+		return err
+	}
+	return nil
+}
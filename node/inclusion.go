@@ -0,0 +1,115 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+// InclusionResult is the inclusion proof and the checkpoint its state root
+// from, the checkpoint is signed if recorded by time proof user
+type InclusionResult struct {
+	Proof      *core.InclusionProof `json:"proof"`
+	Checkpoint *core.Checkpoint     `json:"checkpoint"`
+}
+
+// ProveInclusion create the proof that msg or user is included by the state
+// root at the sequence of space-time, the latest sequence if seq is 0.
+func (n Node) ProveInclusion(spaceTimeID common.Hash, seq uint64, kind string, id common.Hash) (*InclusionResult, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	if seq == 0 {
+		seq = n.universe.GetMaxSeq(spaceTimeID)
+	}
+	proof, err := n.universe.ProveInclusion(spaceTimeID, seq, kind, id)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := db.GetCheckpoint(n.udb, spaceTimeID, seq)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil || cp.MsgID != proof.MsgID {
+		if cp, err = n.universe.CreateCheckpoint(spaceTimeID, seq); err != nil {
+			return nil, err
+		}
+	}
+	return &InclusionResult{Proof: proof, Checkpoint: cp}, nil
+}
+
+// inclusionHandler return the inclusion proof of msg or user, primary
+// space-time if st not given, such as /inclusion?kind=msg&id=...&st=...&seq=10
+func (n Node) inclusionHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	var id common.Hash
+	var err error
+	if kind == core.InclusionUser {
+		id, err = common.ParseUserID(q.Get("id"))
+	} else {
+		id, err = common.HashFromString(q.Get("id"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stID := n.universe.GetPrimarySpaceTime()
+	if s := q.Get("st"); s != "" {
+		if stID, err = common.ParseUserID(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var seq uint64
+	if s := q.Get("seq"); s != "" {
+		if seq, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := n.ProveInclusion(stID, seq, kind, id)
+	switch err {
+	case nil:
+	case core.ErrInclusionKindNotValid:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case core.ErrNotIncluded, core.ErrSpaceTimeNotExist, core.ErrSeqNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
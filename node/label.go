@@ -0,0 +1,273 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/filter"
+)
+
+var errLabelTargetNotFound = errors.New("msg or user of label not found")
+
+// defaultWithout is the labels of msgs not returned by query apis if the
+// labels to exclude not given
+var defaultWithout = []string{db.LabelHidden, db.LabelBlocked}
+
+// LabelFilter select msgs by the labels of msg and its sender, the msg
+// should have any label of With if set, and none of Without
+type LabelFilter struct {
+	With    []string `json:"with,omitempty"`
+	Without []string `json:"without,omitempty"`
+}
+
+// labeler cache the labels in local db, so msgs can be filtered by labels
+// of msg and its sender without reading db
+type labeler struct {
+	mu     sync.RWMutex
+	labels map[common.Hash]map[string]int64 // target -> label -> created
+}
+
+func newLabeler() *labeler {
+	return &labeler{labels: make(map[common.Hash]map[string]int64)}
+}
+
+func (l *labeler) set(lb *db.Label) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.labels[lb.Target]; !ok {
+		l.labels[lb.Target] = make(map[string]int64)
+	}
+	l.labels[lb.Target][lb.Label] = lb.Created
+}
+
+func (l *labeler) remove(target common.Hash, label string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.labels[target], label)
+	if len(l.labels[target]) == 0 {
+		delete(l.labels, target)
+	}
+}
+
+func (l *labeler) has(target common.Hash, label string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.labels[target][label]
+	return ok
+}
+
+// get return the labels of msg or user in order of name
+func (l *labeler) get(target common.Hash) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var labels []string
+	for label := range l.labels[target] {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// of return the labels of msg and its sender
+func (l *labeler) of(msg *core.Message) []string {
+	return append(l.get(msg.ID()), l.get(msg.SenderID)...)
+}
+
+// labeled return the msgs or users with label from new to old
+func (l *labeler) labeled(label string) []*db.Label {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	labels := []*db.Label{}
+	for target, created := range l.labels {
+		if c, ok := created[label]; ok {
+			labels = append(labels, &db.Label{Target: target, Label: label, Created: c})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Created != labels[j].Created {
+			return labels[i].Created > labels[j].Created
+		}
+		return common.Hash2String(labels[i].Target) < common.Hash2String(labels[j].Target)
+	})
+	return labels
+}
+
+// match return true if the labels of msg and its sender pass the filter
+func (l *labeler) match(msg *core.Message, lf *LabelFilter) bool {
+	if lf == nil {
+		return true
+	}
+	labels := l.of(msg)
+	if len(lf.With) > 0 && !containsAny(labels, lf.With) {
+		return false
+	}
+	return !containsAny(labels, lf.Without)
+}
+
+func containsAny(labels, expect []string) bool {
+	for _, label := range labels {
+		for _, e := range expect {
+			if label == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadLabels read all labels from db into cache
+func (n *Node) loadLabels() error {
+	labels, err := db.GetAllLabels(n.udb)
+	if err != nil {
+		return err
+	}
+	for _, l := range labels {
+		n.labeler.set(l)
+	}
+	return nil
+}
+
+// SetLabel label the msg (spam, favorite, hidden) or user (trusted,
+// blocked) in local db, the labels are never gossiped. The msg labeled
+// spam or favorite train the spam filter as spam or not, msgs from user
+// blocked are rejected and from user trusted are accepted without filter.
+func (n Node) SetLabel(target common.Hash, label string) error {
+	if n.universe == nil {
+		return errUniverseNotExist
+	}
+	n.msgLock.RLock()
+	var found bool
+	switch {
+	case db.IsMsgLabel(label):
+		found = n.universe.HasMsg(target)
+	case db.IsUserLabel(label):
+		found = n.universe.GetUserByID(target) != nil
+	default:
+		n.msgLock.RUnlock()
+		return db.ErrLabelNotValid
+	}
+	n.msgLock.RUnlock()
+	if !found {
+		return errLabelTargetNotFound
+	}
+	l := &db.Label{Target: target, Label: label, Created: time.Now().Unix()}
+	n.storeLock.Lock()
+	err := db.SaveLabel(n.udb, l)
+	n.storeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	n.labeler.set(l)
+	if label == db.LabelSpam || label == db.LabelFavorite {
+		// the filter learn from the msgs labeled if it can be trained
+		switch err := n.TrainFilter(target, label == db.LabelSpam); err {
+		case nil, errFilterNotTrainer, filter.ErrContentNotText:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveLabel remove the label of msg or user
+func (n Node) RemoveLabel(target common.Hash, label string) error {
+	if !db.IsMsgLabel(label) && !db.IsUserLabel(label) {
+		return db.ErrLabelNotValid
+	}
+	n.storeLock.Lock()
+	err := db.DeleteLabel(n.udb, target, label)
+	n.storeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	n.labeler.remove(target, label)
+	return nil
+}
+
+// GetLabels return the labels of msg or user
+func (n Node) GetLabels(target common.Hash) []string {
+	return n.labeler.get(target)
+}
+
+// GetLabeled return the msgs or users with label from new to old
+func (n Node) GetLabeled(label string) ([]*db.Label, error) {
+	if !db.IsMsgLabel(label) && !db.IsUserLabel(label) {
+		return nil, db.ErrLabelNotValid
+	}
+	return n.labeler.labeled(label), nil
+}
+
+// parseLabelFilter parse the labels of msgs returned from query, such as
+// label=favorite,trusted&without=spam, msgs labeled hidden or from user
+// blocked are excluded if without not given, empty without include all
+func parseLabelFilter(r *http.Request) (*LabelFilter, error) {
+	q := r.URL.Query()
+	lf := &LabelFilter{Without: defaultWithout}
+	if s := q.Get("label"); s != "" {
+		lf.With = strings.Split(s, ",")
+	}
+	if without, ok := q["without"]; ok {
+		lf.Without = nil
+		if len(without) > 0 && without[0] != "" {
+			lf.Without = strings.Split(without[0], ",")
+		}
+	}
+	for _, label := range append(append([]string{}, lf.With...), lf.Without...) {
+		if !db.IsMsgLabel(label) && !db.IsUserLabel(label) {
+			return nil, db.ErrLabelNotValid
+		}
+	}
+	return lf, nil
+}
+
+// filterLabeled return the msgs pass the label filter
+func (n Node) filterLabeled(msgs []*core.Message, lf *LabelFilter) []*core.Message {
+	filtered := make([]*core.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if n.labeler.match(msg, lf) {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+// labeledHandler return the msgs or users with label from new to old, such
+// as /labeled?label=favorite
+func (n Node) labeledHandler(w http.ResponseWriter, r *http.Request) {
+	labels, err := n.GetLabeled(r.URL.Query().Get("label"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := json.Marshal(labels)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
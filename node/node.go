@@ -17,16 +17,19 @@
 package node
 
 import (
-	"crypto/md5"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pdupub/go-pdu/common"
@@ -47,57 +50,187 @@ const (
 	maxQuestionDelayCnt = 10
 	maxPeerLoopCnt      = 4
 	syncMsgLoopCnt      = 100
+	maxLoadCheckpoints  = 1000
+	defaultSearchLimit  = 20
+	maxSearchLimit      = 100
 )
 
 var (
 	errParseNodeAddressFail = errors.New("parse node address fail")
 	errPeerAlreadyExist     = errors.New("peer already exist")
+	errPeerNotExist         = errors.New("peer not exist")
 	errDuplicateWaveID      = errors.New("duplicate wave id")
 	errTargetWaveIDMissing  = errors.New("target wave id missing")
 	errNoNewMsgSync         = errors.New("no new message sync")
+	errUniverseNotExist     = errors.New("universe not exist")
+	errUserNotExist         = errors.New("user not exist")
+	errMsgNotExist          = errors.New("message not exist")
+	errMsgNotFile           = errors.New("message is not file")
+	errHandleNotExist       = errors.New("handle not exist")
+	errPeerNotInUniverse    = errors.New("peer not in same universe")
+	errNetworkNotMatch      = errors.New("network of peer not match")
+	errDialWorkersNotValid  = errors.New("dial workers should be positive")
 )
 
 // Record is the struct of wave request
 type Record struct {
 	pid   common.Hash
 	delay int
+	sent  time.Time
 }
 
 // Node is struct of node
 type Node struct {
 	udb                  db.UDB
 	tpEnable             bool
-	tpInterval           uint64
+	cpInterval           uint64
 	universe             *core.Universe
 	tpUnlockedUser       *core.User
 	tpUnlockedPrivateKey *crypto.PrivateKey
 	localPort            uint64
 	localNodeKey         string
+	legacyNodeKey        string
+	identity             *nodeIdentity
+	pinnedNodes          map[string]string
+	dnsSeeds             *dnsSeeds
+	peerPolicy           *PeerPolicy
+	inboundPeers         map[common.Hash]bool // peers learned by handshake, guarded by peerLock
+	bandwidth            *bandwidth
 	peers                map[common.Hash]*peer.Peer
 	initStep             uint64
 	pingpongRecord       map[common.Hash]*Record
 	questionRecord       map[common.Hash]*Record
 	wsAcceptMsg          bool
+	searchEnable         bool
 	peerSyncCnt          map[common.Hash]int
 	lastSyncMsg          common.Hash
 	standardLoopCnt      map[common.Hash]uint64
+	rejectionCnt         map[string]map[int]uint64 // peer address : rejection code : count
+	contentResolver      core.ContentResolver
+	pinContent           bool
+	webhooks             *webhookDispatcher
+	notifyUsers          map[common.Hash]bool
+	mirror               Mirror
+	storeLock            *sync.RWMutex // hold by backup to get consistent snapshot
+	host                 *Host         // not nil if served by host with other universes
+	network              uint64
+	peerLock             *sync.RWMutex // peers are added by ws handlers and removed by node loop
+	msgLock              *sync.RWMutex // msgs are committed one by one, universe is read under RLock
+	postLock             *sync.Mutex   // msgs of unlocked user are referenced, signed and saved one by one
+	transport            peer.Transport
+	listener             net.Listener // serve on it instead of local port if not nil
+	loopInterval         time.Duration
+	syncer               *syncer       // initial sync from all peers
+	snapshot             *snapshotSync // snapshot sync for new node, and snapshot served to peers
+	adminListener        net.Listener  // serve admin apis on it if not nil
+	adminToken           string        // required by admin apis if not empty
+	adminRemove          chan common.Hash
+	stop                 chan struct{} // closed by Stop, same as signal sent to Run
+	stopOnce             *sync.Once
+	apiKeys              *apiKeyStore   // checked by local apis and msgs submitted by ws
+	corsOrigins          []string       // origins allowed to call local apis from browser
+	trustedProxies       []*net.IPNet   // client address of requests from them is forwarded
+	pathPrefix           string         // local apis and ws are also served under it
+	relayer              *relayer       // decide which accepted msgs are gossiped onward
+	senders              *senderLimiter // rate of msgs submitted by ws from each sender
+	outboxLock           *sync.Mutex    // outbox items are submitted one by one
+	acker                *acker         // msgs broadcast waiting for ack of peers
+	ackedWaves           *ackedWaves    // waves of peers acked recently
+	deliverer            *deliverer     // msgs of local user are sent again after reconnect
+	readyMinPeers        int            // peers connected before ready
+	readyMaxLag          uint64         // msgs behind peers while ready
+	janitor              *janitor       // enforce the retention policy of content
+	archiver             *archiver      // move the content of old msgs to cold storage
+	cluster              *cluster       // leader of read replica, and changes notified to replicas
+	feeds                *feeds         // sequences of msgs materialized into space-time feeds
+	anchorer             *anchorer      // checkpoints published to external chain
+
+	ctx          context.Context     // done once node stopping, cancel the dials and blocked writes
+	cancel       context.CancelFunc  // cancel ctx
+	dialer       *dialer             // pool of workers dialing peers
+	peerTimeouts peer.Timeouts       // dial, read and write timeouts of peers
+	genesisSync  bool                // fetch the genesis from pinned nodes if no universe
+	stats        *peerStats          // rtt and sync throughput of peers
+	announcer    *announcer          // msg IDs announced to peers and msgs asked
+	seen         *seenMsgs           // msgs accepted, rejected or relayed recently
+	faults       *peer.FaultInjector // faults injected into waves written to peers, only for tests
+	tpScheduler  *tpScheduler        // cadence and result of time proof msgs
+	filterer     *filterer           // score msgs from peers, such as spam filter
+	labeler      *labeler            // labels of msgs and users set by local user
+}
+
+// Mirror keeps a copy of accepted msgs and users out of node db for query,
+// such as the sqlite mirror
+type Mirror interface {
+	SaveUser(user *core.User) error
+	SaveMsg(u *core.Universe, msg *core.Message, order uint64) error
 }
 
-// New is used to create new node
+// New is used to create new node, the universe in udb is loaded with its own
+// hasher, see Host.NewNode for the nodes hosted in one process.
 func New(udb db.UDB) (node *Node, err error) {
 	node = &Node{
 		udb:             udb,
-		tpInterval:      uint64(1),
+		cpInterval:      DefaultCheckpointInterval,
 		localPort:       DefaultLocalPort,
 		peers:           make(map[common.Hash]*peer.Peer),
+		inboundPeers:    make(map[common.Hash]bool),
 		pingpongRecord:  make(map[common.Hash]*Record),
 		questionRecord:  make(map[common.Hash]*Record),
 		wsAcceptMsg:     false,
 		peerSyncCnt:     make(map[common.Hash]int),
 		lastSyncMsg:     common.Hash{},
 		standardLoopCnt: make(map[common.Hash]uint64),
+		rejectionCnt:    make(map[string]map[int]uint64),
+		storeLock:       new(sync.RWMutex),
+		peerLock:        new(sync.RWMutex),
+		msgLock:         new(sync.RWMutex),
+		postLock:        new(sync.Mutex),
+		outboxLock:      new(sync.Mutex),
+		loopInterval:    time.Second * time.Duration(checkPeerInterval),
+		syncer:          newSyncer(),
+		snapshot:        newSnapshotSync(),
+		adminRemove:     make(chan common.Hash),
+		stop:            make(chan struct{}),
+		stopOnce:        new(sync.Once),
+		apiKeys:         newAPIKeyStore(),
+		relayer:         newRelayer(),
+		senders:         newSenderLimiter(),
+		bandwidth:       newBandwidth(),
+		acker:           newAcker(),
+		ackedWaves:      newAckedWaves(),
+		deliverer:       new(deliverer),
+		readyMinPeers:   DefaultReadyMinPeers,
+		readyMaxLag:     DefaultReadyMaxLag,
+		janitor:         newJanitor(),
+		archiver:        new(archiver),
+		cluster:         newCluster(),
+		feeds:           newFeeds(),
+		anchorer:        new(anchorer),
+		dialer:          newDialer(),
+		peerTimeouts:    peer.DefaultTimeouts,
+		stats:           newPeerStats(),
+		announcer:       newAnnouncer(),
+		seen:            newSeenMsgs(),
+		tpScheduler:     newTPScheduler(),
+		filterer:        newFilterer(),
+		labeler:         newLabeler(),
 	}
+	node.ctx, node.cancel = context.WithCancel(context.Background())
 	rand.Seed(time.Now().UnixNano())
+	// upgrade db created by old version, usually done by pdu start before
+	if _, err := db.Migrate(udb); err != nil {
+		return nil, err
+	}
+	if node.network, err = db.GetNetworkID(udb); err != nil {
+		return nil, err
+	}
+	if err := node.loadAPIKeys(); err != nil {
+		return nil, err
+	}
+	if err := node.loadLabels(); err != nil {
+		return nil, err
+	}
 	if err := node.loadUniverse(); err != nil {
 		return nil, err
 	}
@@ -109,61 +242,172 @@ func New(udb db.UDB) (node *Node, err error) {
 	return node, nil
 }
 
+// Network return the network of node, loaded from db
+func (n Node) Network() uint64 {
+	return n.network
+}
+
 // SetLocalPort set local listen port
 func (n *Node) SetLocalPort(port uint64) {
 	n.localPort = port
 }
 
+// SetCheckpointInterval set the number of time proof sequence between two checkpoints
+func (n *Node) SetCheckpointInterval(interval uint64) {
+	if interval > 0 {
+		n.cpInterval = interval
+	}
+}
+
+// RejectionCount return the count of rejected msgs by peer address and rejection code
+func (n Node) RejectionCount() map[string]map[int]uint64 {
+	cnt := make(map[string]map[int]uint64)
+	for addr, codes := range n.rejectionCnt {
+		cnt[addr] = make(map[int]uint64)
+		for code, c := range codes {
+			cnt[addr][code] = c
+		}
+	}
+	return cnt
+}
+
+// SetContentResolver set the resolver of file chunks stored out of msgs,
+// the chunks are pinned when msgs committed if pin is true.
+func (n *Node) SetContentResolver(resolver core.ContentResolver, pin bool) {
+	n.contentResolver = resolver
+	n.pinContent = pin
+}
+
+// SetMirror set the mirror of universe, the root users and msgs already in
+// local db are written into mirror first.
+func (n *Node) SetMirror(mirror Mirror) error {
+	n.mirror = mirror
+	if n.universe == nil {
+		return nil
+	}
+	user0, user1, err := db.GetRootUsers(n.udb)
+	if err != nil {
+		return err
+	}
+	for _, user := range []*core.User{user0, user1} {
+		if err := mirror.SaveUser(user); err != nil {
+			return err
+		}
+	}
+	msgCount, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < msgCount.Uint64(); i++ {
+		for _, msg := range db.GetMsgByOrder(n.udb, new(big.Int).SetUint64(i), 1) {
+			if err := mirror.SaveMsg(n.universe, msg, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EnableSearch create the search index of local universe, the index will
+// be created after the universe loaded if universe not exist yet.
+func (n *Node) EnableSearch() {
+	n.searchEnable = true
+	if n.universe != nil {
+		n.universe.EnableSearch()
+	}
+}
+
+// SetTransport set the transport used to dial peers, such as in memory
+// pipes when many nodes run in one process
+func (n *Node) SetTransport(t peer.Transport) {
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+	n.transport = t
+	for _, p := range n.peers {
+		p.SetTransport(t)
+	}
+}
+
+// SetListener set the listener to serve the ws and apis, instead of listen
+// on the local port, should be set before Run
+func (n *Node) SetListener(l net.Listener) {
+	n.listener = l
+}
+
+// SetLoopInterval set the interval between two loops of ping, sync and
+// checkpoints comparison with peers, should be set before Run
+func (n *Node) SetLoopInterval(interval time.Duration) {
+	n.loopInterval = interval
+}
+
+// SetFaults inject the faults into the waves written to peers, used to
+// verify nodes converge under adverse network, nil means no faults. Should
+// be set before Run.
+func (n *Node) SetFaults(fi *peer.FaultInjector) {
+	n.faults = fi
+}
+
+// EnableWSMsg accept the msgs sent by ws clients without being asked, so
+// apps can submit msgs to node directly
+func (n *Node) EnableWSMsg() {
+	n.wsAcceptMsg = true
+}
+
 // AddPeer add peer to local node peers
 func (n *Node) AddPeer(p *peer.Peer) error {
-	if po, ok := n.peers[p.ID()]; (!ok || po.Url() != p.Url()) && p.NodeKey != n.localNodeKey {
+	return n.addPeer(p, false)
+}
+
+// addPeer add the peer checked by peer policy, inbound is true if the peer
+// is learned by its handshake
+func (n *Node) addPeer(p *peer.Peer, inbound bool) error {
+	if n.host != nil && !n.host.acceptPeer(n, p) {
+		return errPeerNotInUniverse
+	}
+	evicted, err := n.addPeerLocked(p, inbound)
+	if !evicted.IsZero() {
+		n.dropSnapshotPeer(evicted)
+		n.dropSyncPeer(evicted)
+	}
+	return err
+}
+
+func (n *Node) addPeerLocked(p *peer.Peer, inbound bool) (evicted common.Hash, err error) {
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+	if po, ok := n.peers[p.ID()]; (!ok || po.Url() != p.Url()) && !n.isLocalNodeKey(p.NodeKey) {
+		if evicted, err = n.admitPeerLocked(p, inbound); err != nil {
+			return evicted, err
+		}
 		p.Conn = nil
+		p.SetTransport(n.transport)
 		peerBytes, err := json.Marshal(p)
 		if err != nil {
-			return err
+			return common.Hash{}, err
 		}
 		err = n.udb.Set(db.BucketPeer, common.Hash2String(p.ID()), peerBytes)
 		if err != nil {
-			return err
+			return common.Hash{}, err
+		}
+		if !evicted.IsZero() {
+			n.evictPeerLocked(evicted)
 		}
 		n.peers[p.ID()] = p
-		return nil
+		if inbound {
+			n.inboundPeers[p.ID()] = true
+		}
+		return evicted, nil
 	}
-	return errPeerAlreadyExist
+	return common.Hash{}, errPeerAlreadyExist
 }
 
-// SetNodes set the target nodes [userid@ip:port/nodeKey]
+// SetNodes set the target nodes [userid@ip:port/nodeKey], userid is address or hex
 func (n *Node) SetNodes(nodes string) error {
 	for _, nodeStr := range strings.Split(nodes, ",") {
-		var userID, ip, nodeKey string
-		res := strings.Split(nodeStr, "@")
-		if len(res) != 2 {
-			return errParseNodeAddressFail
-		}
-		userID = res[0]
-		res = strings.Split(res[1], ":")
-		if len(res) != 2 {
-			return errParseNodeAddressFail
-		}
-		ip = res[0]
-		res = strings.Split(res[1], "/")
-		if len(res) != 2 {
-			return errParseNodeAddressFail
-		}
-		nodeKey = res[1]
-		port, err := strconv.ParseUint(res[0], 10, 64)
+		currentPeer, err := parseNodeAddress(nodeStr)
 		if err != nil {
 			return err
 		}
-		currentPeer, err := peer.New(ip, port, nodeKey)
-		if err != nil {
-			return err
-		}
-		userIDHash, err := common.String2Hash(userID)
-		if err != nil {
-			return err
-		}
-		currentPeer.SetUserID(userIDHash)
 		err = n.AddPeer(currentPeer)
 		if err != nil {
 			return err
@@ -173,6 +417,40 @@ func (n *Node) SetNodes(nodes string) error {
 	return nil
 }
 
+// parseNodeAddress parse the node address [userid@ip:port/nodeKey] into peer
+func parseNodeAddress(nodeStr string) (*peer.Peer, error) {
+	var userID, ip, nodeKey string
+	res := strings.Split(nodeStr, "@")
+	if len(res) != 2 {
+		return nil, errParseNodeAddressFail
+	}
+	userID = res[0]
+	res = strings.Split(res[1], ":")
+	if len(res) != 2 {
+		return nil, errParseNodeAddressFail
+	}
+	ip = res[0]
+	res = strings.Split(res[1], "/")
+	if len(res) != 2 {
+		return nil, errParseNodeAddressFail
+	}
+	nodeKey = res[1]
+	port, err := strconv.ParseUint(res[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	currentPeer, err := peer.New(ip, port, nodeKey)
+	if err != nil {
+		return nil, err
+	}
+	userIDHash, err := common.ParseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	currentPeer.SetUserID(userIDHash)
+	return currentPeer, nil
+}
+
 func (n *Node) initNetwork() error {
 	// set local node key
 	if err := n.setLocalNodeKey(); err != nil {
@@ -199,81 +477,115 @@ func (n *Node) loadPeers() error {
 			log.Error(err)
 			continue
 		}
-		h, err := common.String2Hash(row.K)
+		h, err := common.HashFromString(row.K)
 		if err != nil {
 			log.Error(err)
 			continue
 		}
-		if newPeer.NodeKey != n.localNodeKey {
+		if !n.isLocalNodeKey(newPeer.NodeKey) && (n.host == nil || n.host.acceptPeer(n, &newPeer)) {
+			newPeer.SetTransport(n.transport)
+			n.peerLock.Lock()
 			n.peers[h] = &newPeer
+			n.peerLock.Unlock()
 			log.Info("Peers load", newPeer.Url(), "peerID", common.Hash2String(h))
 		}
 	}
 	return nil
 }
 
-// setLocalNodeKey set the local node key
-func (n *Node) setLocalNodeKey() error {
-	nodeKey, err := n.udb.Get(db.BucketConfig, db.ConfigLocalNodeKey)
-	if err != nil {
-		return err
-	}
-
-	if nodeKey == nil {
-		h := md5.New()
-		currentTimestamp := time.Now().UnixNano()
-		h.Write([]byte(fmt.Sprintf("%d", currentTimestamp)))
-		newNodeKey := h.Sum(nil)
-		n.localNodeKey = common.Bytes2String(newNodeKey)
-		n.udb.Set(db.BucketConfig, db.ConfigLocalNodeKey, newNodeKey)
-		log.Info("Create new local node key", n.localNodeKey)
-	} else {
-		n.localNodeKey = common.Bytes2String(nodeKey)
-		log.Info("Load local node key", n.localNodeKey)
-	}
-	return nil
-}
-
 // EnableTP set the time proof settings
 func (n *Node) EnableTP(user *core.User, priKey *crypto.PrivateKey, val uint64) error {
 	n.tpEnable = true
 	n.tpUnlockedUser = user
 	n.tpUnlockedPrivateKey = priKey
-	n.tpInterval = val
+	n.tpScheduler.mu.Lock()
+	n.tpScheduler.interval = time.Duration(val) * time.Second
+	n.tpScheduler.mu.Unlock()
 
 	return nil
 }
 
+// SetCheckpointSigner set the user to sign the checkpoints and snapshots of
+// its space-time, without creating time proof msgs as EnableTP
+func (n *Node) SetCheckpointSigner(user *core.User, priKey *crypto.PrivateKey) {
+	n.tpUnlockedUser = user
+	n.tpUnlockedPrivateKey = priKey
+}
+
 // Run the node
 func (n *Node) Run(c <-chan os.Signal) {
 	sigN, waitN := make(chan struct{}), make(chan struct{})
 	sigTP, waitTP := make(chan struct{}), make(chan struct{})
+	sigR, waitR := make(chan struct{}), make(chan struct{})
+	n.resolveDNSSeeds()
+	n.dialer.start(n.ctx)
 	go n.runNode(sigN, waitN)
 	log.Info("Start node server")
-	go n.runLocalServe()
-	log.Info("Start listen on port", n.localPort)
+	if n.host == nil {
+		go n.runLocalServe()
+		log.Info("Start listen on port", n.localPort)
+	}
 
 	if n.tpEnable {
 		go n.runTimeProof(sigTP, waitTP)
 		log.Info("Start time proof server")
 	}
 
-	for {
-		select {
-		case <-c:
-			close(sigN)
-			close(sigTP)
+	if n.anchorEnabled() {
+		go n.verifyAnchors()
+	}
 
-			if n.tpEnable {
-				<-waitTP
-			}
+	replica := n.cluster.replica()
+	if replica {
+		go n.runReplica(sigR, waitR)
+		log.Info("Start replica of leader", n.ClusterStatus().Leader)
+	}
 
-			<-waitN
-			log.Info("Stop node")
-			return
+	if n.adminListener != nil {
+		go n.runAdminServe()
+		log.Info("Start admin server on", n.adminListener.Addr())
+	}
 
+	if n.webhooks != nil {
+		n.webhooks.start(n.ctx)
+	}
+
+	select {
+	case <-c:
+	case <-n.stop:
+	}
+	n.cancel()
+	if n.adminListener != nil {
+		n.adminListener.Close()
+	}
+	close(sigN)
+	close(sigTP)
+	close(sigR)
+
+	if n.tpEnable {
+		<-waitTP
+	}
+	if replica {
+		<-waitR
+	}
+
+	<-waitN
+	if n.webhooks != nil {
+		n.webhooks.wait()
+	}
+	for _, p := range n.copyPeers() {
+		p.Close()
+	}
+	// msgs may still be committed by apis, the filter is saved under RLock
+	n.msgLock.RLock()
+	if n.universe != nil && n.universe.MsgFilter() != nil {
+		if err := db.SaveMsgFilter(n.udb, n.universe.MsgFilter()); err != nil {
+			log.Error("Save msg filter fail", err)
 		}
 	}
+	n.msgLock.RUnlock()
+	n.bandwidth.closeWiretap()
+	log.Info("Stop node")
 }
 
 func (n Node) nodeHandler(w http.ResponseWriter, r *http.Request) {
@@ -288,19 +600,197 @@ func (n Node) nodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// searchHandler return the msgs match the query and label filter in json,
+// such as /search?q=hello&limit=10&label=favorite
+func (n Node) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	limit := defaultSearchLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	lf, err := parseLabelFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, err := n.universe.Search(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := json.Marshal(n.viewMsgs(n.filterLabeled(msgs, lf)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// userHandler return the user in json, id is address (pdu1...) or hex, such as /user?id=pdu1...
+func (n Node) userHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	userID, err := common.ParseUserID(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := n.universe.GetUserByID(userID)
+	if user == nil {
+		http.Error(w, errUserNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	res, err := json.Marshal(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// fileHandler return the data of file chunk in msg, such as /file?id=...
+func (n Node) fileHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	msgID, err := common.HashFromString(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := n.universe.GetMsgByID(msgID)
+	if msg == nil {
+		http.Error(w, errMsgNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	if msg.Value.ContentType != core.TypeFile {
+		http.Error(w, errMsgNotFile.Error(), http.StatusBadRequest)
+		return
+	}
+	var cf core.ContentFile
+	if err := json.Unmarshal(msg.Value.Content, &cf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := cf.Resolve(n.contentResolver)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// repostsHandler return the repost msgs of msg, such as /reposts?id=...
+func (n Node) repostsHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	msgID, err := common.HashFromString(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !n.universe.HasMsg(msgID) {
+		http.Error(w, errMsgNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	reposts := n.universe.GetReposts(msgID)
+	res, err := json.Marshal(struct {
+		MsgID   common.Hash   `json:"msgID"`
+		Count   int           `json:"count"`
+		Reposts []common.Hash `json:"reposts"`
+	}{msgID, len(reposts), reposts})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// withUniverse hold the read lock of universe while h serving, so the msgs
+// committed from peers at the same time do not change the universe read by h.
+// The read handlers of universe are routed through it, and should not lock
+// msgLock again.
+func (n Node) withUniverse(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n.msgLock.RLock()
+		defer n.msgLock.RUnlock()
+		h(w, r)
+	}
+}
+
+// Handler return the http handler of node, serve the ws of peers on
+// /nodeKey and the local apis, used by Host to serve many nodes on one port
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/"+n.localNodeKey, websocket.Handler(n.wsHandler))
+	if n.legacyNodeKey != "" {
+		mux.Handle("/"+n.legacyNodeKey, websocket.Handler(n.wsHandler))
+	}
+	mux.HandleFunc("/node", n.withRole(RoleRead, n.nodeHandler))
+	mux.HandleFunc("/search", n.withRole(RoleRead, n.withUniverse(n.searchHandler)))
+	mux.HandleFunc("/msg", n.withRole(RoleRead, n.withUniverse(n.msgHandler)))
+	mux.HandleFunc("/edits", n.withRole(RoleRead, n.withUniverse(n.editsHandler)))
+	mux.HandleFunc("/user", n.withRole(RoleRead, n.withUniverse(n.userHandler)))
+	mux.HandleFunc("/file", n.withRole(RoleRead, n.withUniverse(n.fileHandler)))
+	mux.HandleFunc("/reposts", n.withRole(RoleRead, n.withUniverse(n.repostsHandler)))
+	mux.HandleFunc("/msgs", n.withRole(RoleRead, n.withUniverse(n.msgsHandler)))
+	mux.HandleFunc("/timeline", n.withRole(RoleRead, n.withUniverse(n.timelineHandler)))
+	mux.HandleFunc("/feed", n.withRole(RoleRead, n.withUniverse(n.feedHandler)))
+	mux.HandleFunc("/labeled", n.withRole(RoleRead, n.labeledHandler))
+	mux.HandleFunc("/proof", n.withRole(RoleRead, n.withUniverse(n.seqProofHandler)))
+	mux.HandleFunc("/inclusion", n.withRole(RoleRead, n.withUniverse(n.inclusionHandler)))
+	mux.HandleFunc("/sync", n.withRole(RoleRead, n.syncHandler))
+	// probes of orchestrators need no api key
+	mux.HandleFunc("/healthz", n.healthzHandler)
+	mux.HandleFunc("/readyz", n.readyzHandler)
+	n.registerBackup(mux)
+	n.registerCluster(mux)
+	return n.withCORS(mux)
+}
+
 func (n *Node) runLocalServe() {
-	http.Handle("/"+n.localNodeKey, websocket.Handler(n.wsHandler))
-	http.HandleFunc("/node", n.nodeHandler)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", n.localPort), nil); err != nil {
+	var err error
+	if n.listener != nil {
+		err = http.Serve(n.listener, withPathPrefix(n.pathPrefix, n.Handler()))
+	} else {
+		err = http.ListenAndServe(fmt.Sprintf(":%d", n.localPort), withPathPrefix(n.pathPrefix, n.Handler()))
+	}
+	if err != nil {
 		log.Error("Start local ws serve fail", err)
 	}
 }
 
 func (n *Node) removePeer(k common.Hash) {
+	n.dropSnapshotPeer(k)
+	n.dropSyncPeer(k)
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+	// stop the writer and close conn
+	if p, ok := n.peers[k]; ok {
+		p.Close()
+		n.bandwidth.remove(p.Url())
+	}
 	// remove fail conn from n.peers
 	delete(n.peers, k)
+	delete(n.inboundPeers, k)
 	//
 	delete(n.peerSyncCnt, k)
+	n.stats.remove(k)
 	// remove fail conn from db
 	n.udb.Del(db.BucketPeer, common.Hash2String(k))
 }
@@ -326,19 +816,41 @@ func (n *Node) checkRecord() {
 	}
 }
 
-func (n *Node) standardLoop(chanWave chan<- galaxy.Wave, chanWSig chan<- common.Hash) {
+// getPeer return the peer by ID, the peer may be removed since asked
+func (n Node) getPeer(pid common.Hash) (*peer.Peer, error) {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+	if p, ok := n.peers[pid]; ok {
+		return p, nil
+	}
+	return nil, errPeerNotExist
+}
+
+// copyPeers return the copy of peers, so peers can be added or removed
+// while the copy is iterated
+func (n Node) copyPeers() map[common.Hash]*peer.Peer {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+	peers := make(map[common.Hash]*peer.Peer, len(n.peers))
 	for k, p := range n.peers {
+		peers[k] = p
+	}
+	return peers
+}
+
+func (n *Node) standardLoop() {
+	n.checkSnapshot()
+	n.checkSyncRanges()
+	for k, p := range n.copyPeers() {
+		if n.dialer.isDialing(k) {
+			continue
+		}
 		if !p.Connected() {
-			if err := p.Dial(); err != nil {
-				log.Error(err)
-				n.removePeer(k)
-				continue
-			}
-			if err := n.askPeers(k); err != nil {
-				log.Error(err)
-				continue
-			}
-			go n.serveReceiveWave(p.Conn, k, chanWave, chanWSig)
+			// dialed by the workers, handled in node loop once connected
+			p.SetMeter(n.bandwidth.meter(p.Url()))
+			p.SetTimeouts(n.peerTimeouts)
+			p.SetFaults(n.faults)
+			n.dialer.submit(k, p)
 		} else {
 			if loopCnt, ok := n.standardLoopCnt[k]; !ok || loopCnt >= maxPeerLoopCnt {
 				n.standardLoopCnt[k] = 0
@@ -359,8 +871,16 @@ func (n *Node) standardLoop(chanWave chan<- galaxy.Wave, chanWSig chan<- common.
 				break // done for this loop
 			}
 
-			// sync from peers,
-			if n.standardLoopCnt[k] == 1 {
+			// snapshot sync if enabled, then initial sync from all peers,
+			// then sync from peers by last msg
+			if !n.snapshotFromPeer(k) {
+				if err := n.syncFromPeer(k); err != nil {
+					log.Error(err)
+				}
+			}
+			if n.snapshot.running() || n.syncer.running() {
+				n.peerSyncCnt[k] = 0
+			} else if n.standardLoopCnt[k] == 1 {
 				log.Trace("Start to sync from other peer ")
 				n.peerSyncCnt[k] = syncMsgLoopCnt
 				if err := n.askMsg(k); err != nil {
@@ -371,6 +891,14 @@ func (n *Node) standardLoop(chanWave chan<- galaxy.Wave, chanWSig chan<- common.
 				n.peerSyncCnt[k] = 0
 			}
 
+			// compare checkpoints of primary space-time with peer
+			if n.standardLoopCnt[k] == maxPeerLoopCnt {
+				if err := n.askCheckpoints(k); err != nil {
+					log.Error(err)
+					continue
+				}
+			}
+
 		}
 	}
 }
@@ -379,21 +907,41 @@ func (n *Node) runNode(sig <-chan struct{}, wait chan<- struct{}) {
 	// run node
 	chanWave := make(chan galaxy.Wave)
 	chanWSig := make(chan common.Hash)
-	n.standardLoopCnt = make(map[common.Hash]uint64)
 
 	for {
 		select {
-		case <-time.After(time.Second * time.Duration(checkPeerInterval)):
+		case <-time.After(n.loopInterval):
 			log.Info("Update information from peers")
 			n.checkRecord()
-			n.standardLoop(chanWave, chanWSig)
+			n.standardLoop()
+			n.flushOutbox()
+			n.retryBroadcast()
+			n.checkAnnounces()
+			n.resendDeliveries()
+			n.checkRetention()
+			n.checkArchive()
+			n.checkFeeds()
+			n.checkAnchor()
+		case r := <-n.dialer.results:
+			n.dialed(r, chanWave, chanWSig)
 		case k := <-chanWSig:
 			n.removePeer(k)
+		case k := <-n.adminRemove:
+			n.removePeer(k)
 		case <-sig:
 			log.Info("Stop server")
+			n.syncer.stop()
+			n.snapshot.stop()
 			close(wait)
 			return
 		case w := <-chanWave:
+			if wm, ok := w.(*galaxy.WaveMessages); ok && (n.snapshotAnswer(wm) || n.syncAnswer(wm)) {
+				continue
+			} else if ok {
+				if r, ok := n.questionRecord[wm.WaveID]; ok {
+					n.syncer.observeMsgs(r.pid, len(wm.Msgs), wm.Total)
+				}
+			}
 			waveID, err := n.handleWave(nil, w, true)
 			if err != nil {
 				//log.Trace("Peer handler fail", err, "waveID", common.Hash2String(waveID))
@@ -405,77 +953,159 @@ func (n *Node) runNode(sig <-chan struct{}, wait chan<- struct{}) {
 	}
 }
 
-func (n *Node) runTimeProof(sig <-chan struct{}, wait chan<- struct{}) {
-	for {
-		select {
-		case <-sig:
-			log.Info("Stop time proof server")
-			close(wait)
-			return
-		case <-time.After(time.Second * time.Duration(n.tpInterval)):
-			var refs []*core.MsgReference
-			// load last msg in universe
-			lastMsg, err := db.GetLastMsg(n.udb)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			refs = append(refs, &core.MsgReference{SenderID: lastMsg.SenderID, MsgID: lastMsg.ID()})
-			// load last msg from unlock user if exist
-			lastMsgByUser, err := db.GetLastMsgByUser(n.udb, n.tpUnlockedUser.ID())
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			if lastMsg.ID() != lastMsgByUser.ID() {
-				refs = append(refs, &core.MsgReference{SenderID: lastMsgByUser.SenderID, MsgID: lastMsgByUser.ID()})
-			}
-			// create new msg, use 1.2 as reference
-			tpMsgValue := &core.MsgValue{ContentType: core.TypeText, Content: []byte(strconv.Itoa(rand.Intn(100000)))}
-			tpMsg, err := core.CreateMsg(n.tpUnlockedUser, tpMsgValue, n.tpUnlockedPrivateKey, refs...)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			// save msg into udb,
-			if err := n.saveMsg(tpMsg); err != nil {
-				log.Error(err)
-				continue
-			}
-			// 5. broadcast the new msg
-			if err := n.broadcastMsg(tpMsg); err != nil {
-				log.Error(err)
-				continue
-			}
-
-			log.Info("A new message", common.Hash2String(tpMsg.ID()), "just be created and broadcast")
-		}
+// freshRefs return the references of new msg from user, the last msg in
+// universe and the last msg from user if exist and they are different
+func (n Node) freshRefs(userID common.Hash) ([]*core.MsgReference, error) {
+	lastMsg, err := db.GetLastMsg(n.udb)
+	if err != nil {
+		return nil, err
+	}
+	refs := []*core.MsgReference{{SenderID: lastMsg.SenderID, MsgID: lastMsg.ID()}}
+	lastMsgByUser, err := db.GetLastMsgByUser(n.udb, userID)
+	if err == db.ErrMessageNotFound {
+		return refs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if lastMsg.ID() != lastMsgByUser.ID() {
+		refs = append(refs, &core.MsgReference{SenderID: lastMsgByUser.SenderID, MsgID: lastMsgByUser.ID()})
 	}
+	return refs, nil
 }
 
+// broadcastMsg queue the msg to all connected peers, a slow or broken
+// peer is skipped without blocking others, and sent again later if ack
+// of peers enabled. Only the msg ID is sent if announcement enabled. The
+// msg already relayed in the seen window is not sent again.
 func (n Node) broadcastMsg(msg *core.Message) error {
-	for _, p := range n.peers {
+	if !n.seen.relay(msg.ID(), time.Now()) {
+		return nil
+	}
+	announce := n.announcer.enabled()
+	for k, p := range n.copyPeers() {
 		if !p.Connected() {
 			continue
 		}
 
-		if err := p.SendMsg(common.CreateHash(), msg); err != nil {
-			return err
+		var err error
+		if announce {
+			err = n.sendInv(k, p, msg)
+		} else {
+			err = n.sendBroadcast(k, p, msg)
+		}
+		if err != nil {
+			log.Error("Broadcast to peer", common.Hash2String(k), err)
 		}
 	}
 	return nil
 }
 
 func (n Node) saveMsg(msg *core.Message) error {
-	if err := n.universe.AddMsg(msg); err != nil {
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	receipt, err := n.universe.Validate(msg)
+	if err != nil {
 		return err
 	}
+	return n.commitMsg(receipt, originLocal)
+}
+
+// sealMsgs seal the msgs decoded from peers by the hasher of universe, so
+// their IDs can be checked before validated, see core.Message.SealWith
+func (n Node) sealMsgs(msgs ...*core.Message) {
+	if n.universe == nil {
+		return
+	}
+	for _, msg := range msgs {
+		msg.SealWith(n.universe.Hasher())
+	}
+}
+
+// commitMsg commit the validated msg into universe and save into udb, origin
+// is where the msg from, recorded in audit log
+func (n Node) commitMsg(receipt *core.Receipt, origin string) error {
+	if err := n.universe.Commit(receipt); err != nil {
+		return err
+	}
+	msg := receipt.Msg()
+	n.storeLock.Lock()
 	if err := db.SaveMsg(n.udb, msg); err != nil {
+		n.storeLock.Unlock()
 		return err
 	}
+	n.dropExpired()
+	if err := n.recordCheckpoint(msg); err != nil {
+		log.Error("Record checkpoint fail", err)
+	}
+	if err := n.notifyLocalUsers(msg); err != nil {
+		log.Error("Save notification fail", err)
+	}
+	n.auditCommit(msg, origin)
+	n.seen.accept(msg.ID(), time.Now())
+	n.storeLock.Unlock()
+	n.cluster.notify()
+	if err := n.pinFile(msg); err != nil {
+		log.Error("Pin file chunk fail", err)
+	}
+	n.notifyWebhooks(msg)
+	if err := n.mirrorMsg(msg); err != nil {
+		log.Error("Mirror msg fail", err)
+	}
 	return nil
 }
 
+// mirrorMsg write the committed msg into mirror
+func (n Node) mirrorMsg(msg *core.Message) error {
+	if n.mirror == nil {
+		return nil
+	}
+	order, _, err := db.GetOrderCntByMsg(n.udb, msg.ID())
+	if err != nil {
+		return err
+	}
+	return n.mirror.SaveMsg(n.universe, msg, order.Uint64())
+}
+
+// pinFile pin the file chunk stored out of msg, so it is kept by resolver
+func (n Node) pinFile(msg *core.Message) error {
+	if !n.pinContent || n.contentResolver == nil || msg.Value.ContentType != core.TypeFile {
+		return nil
+	}
+	var cf core.ContentFile
+	if err := json.Unmarshal(msg.Value.Content, &cf); err != nil {
+		return err
+	}
+	if !cf.Offloaded() {
+		return nil
+	}
+	return n.contentResolver.Pin(cf.CID)
+}
+
+// validateMsgs validate msgs in parallel by worker pool, the receipts and errors
+// are in the same order as msgs.
+func (n Node) validateMsgs(msgs []*core.Message) ([]*core.Receipt, []error) {
+	receipts := make([]*core.Receipt, len(msgs))
+	errs := make([]error, len(msgs))
+	jobs := make(chan int, len(msgs))
+	for i := range msgs {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU() && w < len(msgs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				receipts[i], errs[i] = n.universe.Validate(msgs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return receipts, errs
+}
+
 func (n *Node) loadUniverse() (err error) {
 	stepBytes, err := n.udb.Get(db.BucketConfig, db.ConfigCurrentStep)
 	if err != nil {
@@ -490,35 +1120,43 @@ func (n *Node) loadUniverse() (err error) {
 	if err != nil {
 		return err
 	}
+	hasher, err := db.GetUniverseHasher(n.udb)
+	if err != nil {
+		return err
+	}
 	// update init step
 	n.initStep = db.StepRootsSaved
+	config := core.DefaultUniverseConfig()
+	config.Hasher = hasher.Name()
+	config.NetworkID = n.network
+	n.universe, err = core.NewUniverseWithConfig(user0, user1, config)
+	if err != nil {
+		return err
+	}
 	log.Info("root0", common.Hash2String(user0.ID()))
 	log.Info("root1", common.Hash2String(user1.ID()))
-	n.universe, err = core.NewUniverse(user0, user1)
-	if err != nil {
+	if filter, err := db.GetMsgFilter(n.udb); err != nil {
 		return err
+	} else if filter != nil {
+		if err := n.universe.SetMsgFilter(filter); err != nil {
+			log.Warn("Msg filter not be loaded, rebuild it", err)
+		}
 	}
 	msgCount, err := db.GetMsgCount(n.udb)
 	if err != nil {
 		return err
 	}
 	for i := uint64(0); i < msgCount.Uint64(); i++ {
-		// todo : replace by db.GetMsgByOrder()
 		mid, err := n.udb.Get(db.BucketMID, new(big.Int).SetUint64(i).String())
 		if err != nil {
 			return err
 		}
-		msgBytes, err := n.udb.Get(db.BucketMsg, common.Bytes2String(mid))
-		if err != nil {
-			return err
-		}
-		var msg core.Message
-		err = json.Unmarshal(msgBytes, &msg)
+		msg, err := db.GetMsg(n.udb, common.Bytes2Hash(mid))
 		if err != nil {
 			return err
 		}
 
-		err = n.universe.AddMsg(&msg)
+		err = n.universe.AddMsg(msg)
 		if err != nil {
 			return err
 		}
@@ -527,13 +1165,55 @@ func (n *Node) loadUniverse() (err error) {
 		}
 	}
 	log.Info("All", msgCount, "messages already be loaded")
+
+	policy, err := db.GetTimeProofPolicy(n.udb)
+	if err != nil {
+		return err
+	}
+	if policy != nil {
+		if err := n.universe.SetTimeProofPolicy(policy); err != nil {
+			log.Error("Time proof policy not be loaded", err)
+		}
+	}
+	log.Info("Primary space time", common.Hash2String(n.universe.GetPrimarySpaceTime()))
+	n.dropExpired()
 	return nil
 }
 
+// SetTimeProofPolicy set the trusted and primary space-time of local universe,
+// and save the choice into db.
+func (n *Node) SetTimeProofPolicy(policy *core.TimeProofPolicy) error {
+	if n.universe == nil {
+		return errUniverseNotExist
+	}
+	if err := n.universe.SetTimeProofPolicy(policy); err != nil {
+		return err
+	}
+	return db.SaveTimeProofPolicy(n.udb, policy)
+}
+
 func (n Node) localPeer() *peer.Peer {
 	localPeer := &peer.Peer{IP: localIPAddress, Port: n.localPort, NodeKey: n.localNodeKey}
 	if n.tpUnlockedUser != nil {
 		localPeer.UserID = n.tpUnlockedUser.ID()
 	}
+	if n.universe != nil {
+		localPeer.Universe = n.universe.ID()
+	}
 	return localPeer
 }
+
+// universeMatch return false only if both the local universe and the
+// universe of peer are known and they are different
+func (n Node) universeMatch(id common.Hash) bool {
+	return n.universe == nil || id.IsZero() || id == n.universe.ID()
+}
+
+// rejectUniverse remove the peer asked by waveID, which is found in
+// other universe
+func (n *Node) rejectUniverse(waveID common.Hash) {
+	if r, ok := n.questionRecord[waveID]; ok {
+		log.Warn("Peer", common.Hash2String(r.pid), "not in same universe, removed")
+		n.removePeer(r.pid)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+// Kinds of notification
+const (
+	// NotifyReference is the msg reference the msg from local user, such as reply or mention
+	NotifyReference = "reference"
+	// NotifyRepost is the repost of msg from local user
+	NotifyRepost = "repost"
+	// NotifyFollow is the follow of local user
+	NotifyFollow = "follow"
+	// NotifyBirth is the birth of new user signed by local user as parent
+	NotifyBirth = "birth"
+)
+
+// DefaultNotifyLimit is the max number of notifications returned at once
+const DefaultNotifyLimit = 100
+
+// SetNotifyUsers set the local users whose notifications are saved, the msgs
+// accepted after that and concerned the users are added into their queue.
+func (n *Node) SetNotifyUsers(userIDs ...common.Hash) {
+	n.notifyUsers = make(map[common.Hash]bool)
+	for _, userID := range userIDs {
+		n.notifyUsers[userID] = true
+	}
+}
+
+// GetNotifications return at most limit notifications of local user in order
+// of msgs accepted, the read ones are skipped if unreadOnly
+func (n Node) GetNotifications(userID common.Hash, unreadOnly bool, limit int) ([]*db.Notification, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	return db.GetNotifications(n.udb, userID, unreadOnly, limit)
+}
+
+// MarkRead mark the notifications of local user as read, all notifications
+// are marked if ids is empty. The number of notifications changed is returned.
+func (n Node) MarkRead(userID common.Hash, ids ...uint64) (int, error) {
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	return db.MarkNotificationsRead(n.udb, userID, ids...)
+}
+
+// notifyLocalUsers add the notification of msg into the queue of each local
+// user concerned, the msgs from the user self are skipped
+func (n Node) notifyLocalUsers(msg *core.Message) error {
+	for userID := range n.notifyUsers {
+		if userID == msg.SenderID {
+			continue
+		}
+		if kind := notifyKind(msg, userID); kind != "" {
+			if err := db.AddNotification(n.udb, &db.Notification{
+				UserID:   userID,
+				Kind:     kind,
+				MsgID:    msg.ID(),
+				SenderID: msg.SenderID,
+				Created:  time.Now().Unix(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// notifyKind return the kind of notification of msg for user, empty if msg
+// not concern the user
+func notifyKind(msg *core.Message, userID common.Hash) string {
+	switch msg.Value.ContentType {
+	case core.TypeBirth:
+		var cb core.ContentBirth
+		if json.Unmarshal(msg.Value.Content, &cb) == nil {
+			for _, p := range cb.Parents {
+				if p.UserID == userID {
+					return NotifyBirth
+				}
+			}
+		}
+	case core.TypeRepost:
+		var cr core.ContentRepost
+		if json.Unmarshal(msg.Value.Content, &cr) == nil && cr.AuthorID == userID {
+			return NotifyRepost
+		}
+	case core.TypeFollow:
+		var cf core.ContentFollow
+		if json.Unmarshal(msg.Value.Content, &cf) == nil && cf.UserID == userID {
+			return NotifyFollow
+		}
+	}
+	for _, r := range msg.Reference {
+		if r.SenderID == userID {
+			return NotifyReference
+		}
+	}
+	return ""
+}
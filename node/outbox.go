@@ -0,0 +1,142 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+// MaxOutboxAttempts is the number of submissions of outbox item before it
+// is marked as failed
+const MaxOutboxAttempts = 5
+
+// DefaultOutboxEventLimit is the max number of outbox events returned at once
+const DefaultOutboxEventLimit = 100
+
+var errOutboxNoUser = errors.New("no user unlocked to sign outbox msgs")
+
+// Draft queue the msg value into outbox of the user unlocked by node. The
+// msg is not created until node connected with peers, then it is signed
+// with fresh references, so the msg drafted offline still refer the latest
+// msgs. The item is submitted at once if node is connected.
+func (n Node) Draft(value *core.MsgValue) (*db.OutboxItem, error) {
+	if n.tpUnlockedUser == nil || n.tpUnlockedPrivateKey == nil {
+		return nil, errOutboxNoUser
+	}
+	now := time.Now().Unix()
+	item := &db.OutboxItem{UserID: n.tpUnlockedUser.ID(), Value: value, Status: db.OutboxQueued, Created: now, Updated: now}
+	n.storeLock.Lock()
+	err := db.SaveOutboxItem(n.udb, item)
+	n.storeLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	n.flushOutbox()
+	return item, nil
+}
+
+// GetOutbox return the outbox items in order of drafted, the items of
+// other status are skipped if status is not empty
+func (n Node) GetOutbox(status string) ([]*db.OutboxItem, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	return db.GetOutboxItems(n.udb, status)
+}
+
+// GetOutboxEvents return at most limit status transitions of outbox items
+// from id in order
+func (n Node) GetOutboxEvents(from uint64, limit int) ([]*db.OutboxEvent, error) {
+	n.storeLock.RLock()
+	defer n.storeLock.RUnlock()
+	return db.GetOutboxEvents(n.udb, from, limit)
+}
+
+// Connected return true if any peer is connected, the outbox items are only
+// submitted when node is connected
+func (n Node) Connected() bool {
+	for _, p := range n.copyPeers() {
+		if p.Connected() {
+			return true
+		}
+	}
+	return false
+}
+
+// flushOutbox submit the queued outbox items in order of drafted if node
+// is connected, each item is retried until MaxOutboxAttempts.
+func (n Node) flushOutbox() {
+	if n.tpUnlockedUser == nil || !n.Connected() {
+		return
+	}
+	n.outboxLock.Lock()
+	defer n.outboxLock.Unlock()
+	items, err := n.GetOutbox(db.OutboxQueued)
+	if err != nil {
+		log.Error("Load outbox fail", err)
+		return
+	}
+	for _, item := range items {
+		if item.UserID != n.tpUnlockedUser.ID() {
+			continue
+		}
+		item.Attempts++
+		if msg, err := n.submitOutbox(item); err != nil {
+			item.Error = err.Error()
+			if item.Attempts >= MaxOutboxAttempts {
+				item.Status = db.OutboxFailed
+			}
+		} else {
+			item.Status, item.MsgID, item.Error = db.OutboxSent, msg.ID(), ""
+			log.Info("Outbox msg", common.Hash2String(msg.ID()), "is sent")
+		}
+		item.Updated = time.Now().Unix()
+		n.storeLock.Lock()
+		err := db.SaveOutboxItem(n.udb, item)
+		n.storeLock.Unlock()
+		if err != nil {
+			log.Error("Save outbox item fail", item.ID, err)
+		}
+	}
+}
+
+// submitOutbox create the msg of item with fresh references, save it into
+// local universe and deliver to peers until acked
+func (n Node) submitOutbox(item *db.OutboxItem) (*core.Message, error) {
+	n.postLock.Lock()
+	defer n.postLock.Unlock()
+	n.storeLock.RLock()
+	refs, err := n.freshRefs(item.UserID)
+	n.storeLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	difficulty := n.universe.Config().Difficulty(item.Value.ContentType)
+	msg, err := core.CreateMsgOnNetwork(n.network, n.tpUnlockedUser, item.Value, n.tpUnlockedPrivateKey, difficulty, refs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.saveMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, n.deliverMsg(msg)
+}
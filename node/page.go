@@ -0,0 +1,138 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+var errPageNotValid = errors.New("skip, limit or view not valid")
+
+// MsgPage is the page of msgs queried in view, the view is taken by the
+// query of first page if not given, and should be passed to the queries of
+// next pages, so pages are not shifted by msgs arriving concurrently
+type MsgPage struct {
+	View *core.View `json:"view"`
+	Msgs []*MsgView `json:"msgs"`
+}
+
+// parsePage parse the view, skip and limit of page from query, the view of
+// current universe is taken if not given
+func (n Node) parsePage(r *http.Request) (view *core.View, skip, limit int, err error) {
+	q := r.URL.Query()
+	if s := q.Get("view"); s != "" {
+		seq, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, 0, 0, errPageNotValid
+		}
+		view = &core.View{Seq: seq}
+	} else {
+		view = n.universe.View()
+	}
+	if s := q.Get("skip"); s != "" {
+		if skip, err = strconv.Atoi(s); err != nil || skip < 0 {
+			return nil, 0, 0, errPageNotValid
+		}
+	}
+	limit = defaultSearchLimit
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return nil, 0, 0, errPageNotValid
+		}
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	return view, skip, limit, nil
+}
+
+// writePage write the msgs in view as json
+func (n Node) writePage(w http.ResponseWriter, view *core.View, msgs []*core.Message) {
+	res, err := json.Marshal(&MsgPage{View: view, Msgs: n.viewMsgs(msgs)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// msgsHandler return the page of msgs from sender order by received
+// sequence, such as /msgs?sender=...&skip=20&limit=20&view=100, the msgs
+// not pass the label filter are dropped from page
+func (n Node) msgsHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	senderID, err := common.ParseUserID(r.URL.Query().Get("sender"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view, skip, limit, err := n.parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lf, err := parseLabelFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.storeLock.RLock()
+	msgs, err := db.GetMsgsBySenderAt(n.udb, senderID, view, skip, limit)
+	n.storeLock.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n.writePage(w, view, n.filterLabeled(msgs, lf))
+}
+
+// timelineHandler return the page of msgs sent by users followed by the
+// user from new to old, such as /timeline?id=...&skip=20&limit=20&view=100,
+// the msgs not pass the label filter are dropped from page
+func (n Node) timelineHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	userID, err := common.ParseUserID(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view, skip, limit, err := n.parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lf, err := parseLabelFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.writePage(w, view, n.filterLabeled(n.universe.GetTimelineAt(userID, view, skip, limit), lf))
+}
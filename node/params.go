@@ -20,6 +20,13 @@ const (
 	// DefaultTimeProofInterval is the default interval for time proof message
 	DefaultTimeProofInterval = 1 // 1 seconds
 
+	// DefaultCheckpointInterval is the default number of time proof sequence between two checkpoints
+	DefaultCheckpointInterval = 100
+
 	// DefaultLocalPort is the default port of local serve
 	DefaultLocalPort = 8341
+
+	// DefaultSnapshotChunkSize is the number of msgs in one chunk of snapshot
+	// served to peers, no more than peer.MaxSyncMsgCountPerWave
+	DefaultSnapshotChunkSize = 256
 )
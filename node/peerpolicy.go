@@ -0,0 +1,165 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+var (
+	errPeerLimitReached    = errors.New("peer limit reached")
+	errPeerPolicyNotValid  = errors.New("peer policy not valid")
+	errAnchorNotValid      = errors.New("anchor should be ip:port")
+	errInboundOverMaxPeers = errors.New("max inbound should not over max peers")
+)
+
+// PeerPolicy limit the peers of node, so node accept arbitrary inbound
+// peers can not be eclipsed by peers of same network. The subnet is /16 of
+// IPv4 and /32 of IPv6. Inbound peers are learned by their handshake, and
+// evicted first when outbound peer is added to full node. Anchors [ip:port]
+// are never limited nor evicted. The zero value limit nothing.
+type PeerPolicy struct {
+	MaxPeers     int      `json:"maxPeers,omitempty"`
+	MaxInbound   int      `json:"maxInbound,omitempty"`
+	MaxPerIP     int      `json:"maxPerIP,omitempty"`
+	MaxPerSubnet int      `json:"maxPerSubnet,omitempty"`
+	Anchors      []string `json:"anchors,omitempty"`
+}
+
+// Validate check the limits and anchors of policy
+func (pp PeerPolicy) Validate() error {
+	if pp.MaxPeers < 0 || pp.MaxInbound < 0 || pp.MaxPerIP < 0 || pp.MaxPerSubnet < 0 {
+		return errPeerPolicyNotValid
+	}
+	if pp.MaxPeers > 0 && pp.MaxInbound > pp.MaxPeers {
+		return errInboundOverMaxPeers
+	}
+	for _, anchor := range pp.Anchors {
+		if _, _, err := net.SplitHostPort(anchor); err != nil {
+			return errAnchorNotValid
+		}
+	}
+	return nil
+}
+
+func (pp PeerPolicy) isAnchor(p *peer.Peer) bool {
+	addr := fmt.Sprintf("%s:%d", p.IP, p.Port)
+	for _, anchor := range pp.Anchors {
+		if anchor == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// subnetOf return the subnet of ip, the host name is taken as its own subnet
+func subnetOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// SetPeerPolicy set the policy of peers added after, should be set before Run
+func (n *Node) SetPeerPolicy(policy *PeerPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	n.peerPolicy = policy
+	return nil
+}
+
+// admitPeerLocked check the new peer by policy, return the inbound peer
+// should be evicted to make room for it if node is full. peerLock is held.
+func (n *Node) admitPeerLocked(p *peer.Peer, inbound bool) (common.Hash, error) {
+	pp := n.peerPolicy
+	if pp == nil || pp.isAnchor(p) {
+		return common.Hash{}, nil
+	}
+	subnet := subnetOf(p.IP)
+	ipCnt, subnetCnt, inboundCnt := 0, 0, 0
+	crowded := make(map[string]int)
+	var candidates []common.Hash
+	for k, op := range n.peers {
+		if op.IP == p.IP {
+			ipCnt++
+		}
+		if subnetOf(op.IP) == subnet {
+			subnetCnt++
+		}
+		if n.inboundPeers[k] {
+			inboundCnt++
+			if !pp.isAnchor(op) {
+				crowded[subnetOf(op.IP)]++
+				candidates = append(candidates, k)
+			}
+		}
+	}
+	if (pp.MaxPerIP > 0 && ipCnt >= pp.MaxPerIP) || (pp.MaxPerSubnet > 0 && subnetCnt >= pp.MaxPerSubnet) {
+		return common.Hash{}, errPeerLimitReached
+	}
+	if inbound && pp.MaxInbound > 0 && inboundCnt >= pp.MaxInbound {
+		return common.Hash{}, errPeerLimitReached
+	}
+	if pp.MaxPeers == 0 || len(n.peers) < pp.MaxPeers {
+		return common.Hash{}, nil
+	}
+	// the peers known already are kept for inbound peer, and the inbound
+	// peer in most crowded subnet is evicted for outbound peer
+	if inbound || len(candidates) == 0 {
+		return common.Hash{}, errPeerLimitReached
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := crowded[subnetOf(n.peers[candidates[i]].IP)], crowded[subnetOf(n.peers[candidates[j]].IP)]
+		if ci != cj {
+			return ci > cj
+		}
+		return common.Hash2String(candidates[i]) < common.Hash2String(candidates[j])
+	})
+	return candidates[0], nil
+}
+
+// isInbound return true if the peer is learned by its handshake
+func (n Node) isInbound(k common.Hash) bool {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+	return n.inboundPeers[k]
+}
+
+// evictPeerLocked close and remove the peer, peerLock is held
+func (n *Node) evictPeerLocked(k common.Hash) {
+	if p, ok := n.peers[k]; ok {
+		log.Info("Peer", p.Url(), "evicted by peer policy")
+		p.Close()
+		n.bandwidth.remove(p.Url())
+	}
+	delete(n.peers, k)
+	delete(n.inboundPeers, k)
+	n.udb.Del(db.BucketPeer, common.Hash2String(k))
+}
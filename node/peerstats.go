@@ -0,0 +1,154 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+const (
+	statWeight    = 0.25 // weight of new sample in the moving average of rtt and throughput
+	slowPeerRatio = 4    // peers slower than the fastest by this ratio are kept for gossip only
+)
+
+// peerStat is the round trip time and sync throughput measured of peer
+type peerStat struct {
+	rtt        time.Duration // moving average of ping, 0 if not measured
+	throughput float64       // moving average of msgs per second answered in sync ranges
+	answered   uint64        // sync ranges answered
+	timeouts   uint64        // sync ranges not answered in time
+}
+
+// score is the throughput weighted by the ratio of ranges answered, -1 if
+// no range answered yet
+func (s peerStat) score() float64 {
+	if s.answered == 0 {
+		return -1
+	}
+	return s.throughput * float64(s.answered) / float64(s.answered+s.timeouts)
+}
+
+// peerStats keep the stat of peers, which is used to choose the peers for
+// bulk sync, the stats are dropped once peer removed
+type peerStats struct {
+	lock  sync.Mutex
+	stats map[common.Hash]*peerStat
+}
+
+func newPeerStats() *peerStats {
+	return &peerStats{stats: make(map[common.Hash]*peerStat)}
+}
+
+func (ps *peerStats) stat(pid common.Hash) *peerStat {
+	s, ok := ps.stats[pid]
+	if !ok {
+		s = &peerStat{}
+		ps.stats[pid] = s
+	}
+	return s
+}
+
+// observeRTT add the round trip time of ping into the stat of peer
+func (ps *peerStats) observeRTT(pid common.Hash, rtt time.Duration) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	s := ps.stat(pid)
+	if s.rtt == 0 {
+		s.rtt = rtt
+	} else {
+		s.rtt = time.Duration(float64(s.rtt)*(1-statWeight) + float64(rtt)*statWeight)
+	}
+}
+
+// observeRange add the msgs answered in elapsed into the throughput of peer
+func (ps *peerStats) observeRange(pid common.Hash, msgs int, elapsed time.Duration) {
+	if elapsed <= 0 {
+		elapsed = time.Millisecond
+	}
+	rate := float64(msgs) / elapsed.Seconds()
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	s := ps.stat(pid)
+	if s.answered == 0 {
+		s.throughput = rate
+	} else {
+		s.throughput = s.throughput*(1-statWeight) + rate*statWeight
+	}
+	s.answered++
+}
+
+// observeTimeout record the range not answered by peer in time
+func (ps *peerStats) observeTimeout(pid common.Hash) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.stat(pid).timeouts++
+}
+
+func (ps *peerStats) get(pid common.Hash) peerStat {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if s, ok := ps.stats[pid]; ok {
+		return *s
+	}
+	return peerStat{}
+}
+
+func (ps *peerStats) remove(pid common.Hash) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	delete(ps.stats, pid)
+}
+
+// rank return the peers ordered by score, the peers not measured by sync
+// are ordered by rtt after them. fast is false for the peers slower than
+// the fastest by slowPeerRatio, which are not asked for bulk sync.
+func (ps *peerStats) rank(pids []common.Hash) (ranked []common.Hash, fast map[common.Hash]bool) {
+	ps.lock.Lock()
+	stats := make(map[common.Hash]peerStat)
+	for _, pid := range pids {
+		if s, ok := ps.stats[pid]; ok {
+			stats[pid] = *s
+		}
+	}
+	ps.lock.Unlock()
+
+	ranked = append(ranked, pids...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := stats[ranked[i]], stats[ranked[j]]
+		if si.score() != sj.score() {
+			return si.score() > sj.score()
+		}
+		if si.rtt == 0 || sj.rtt == 0 {
+			return si.rtt != 0
+		}
+		return si.rtt < sj.rtt
+	})
+	var best float64
+	if len(ranked) > 0 {
+		best = stats[ranked[0]].score()
+	}
+	fast = make(map[common.Hash]bool)
+	for _, pid := range ranked {
+		score := stats[pid].score()
+		fast[pid] = score < 0 || score*slowPeerRatio >= best
+	}
+	return ranked, fast
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// DefaultPostRefs is the number of references recommended by universe for
+// msg posted by node
+const DefaultPostRefs = 4
+
+var (
+	errPostNoUser      = errors.New("no user unlocked to sign posts")
+	errPostRefNotFound = errors.New("reference of post not found")
+)
+
+// Post create the msg of value signed by the user unlocked by node, then
+// save it into local universe and deliver to peers until acked. The msgs
+// referenced are recommended by universe if refIDs not set, so the app can
+// post without knowing the DAG. The postLock is held until msg saved, so the
+// last msg of user recommended is not changed by time proof or outbox.
+func (n Node) Post(value *core.MsgValue, refIDs ...common.Hash) (*core.Message, error) {
+	if n.tpUnlockedUser == nil || n.tpUnlockedPrivateKey == nil {
+		return nil, errPostNoUser
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	n.postLock.Lock()
+	defer n.postLock.Unlock()
+	var refs []*core.MsgReference
+	n.msgLock.Lock()
+	if len(refIDs) == 0 {
+		for _, ref := range n.universe.RecommendRefs(n.tpUnlockedUser.ID(), DefaultPostRefs) {
+			refs = append(refs, &core.MsgReference{SenderID: ref.SenderID, MsgID: ref.MsgID})
+		}
+	}
+	for _, msgID := range refIDs {
+		msg := n.universe.GetMsgByID(msgID)
+		if msg == nil {
+			n.msgLock.Unlock()
+			return nil, errPostRefNotFound
+		}
+		refs = append(refs, &core.MsgReference{SenderID: msg.SenderID, MsgID: msgID})
+	}
+	difficulty := n.universe.Config().Difficulty(value.ContentType)
+	n.msgLock.Unlock()
+
+	msg, err := core.CreateMsgOnNetwork(n.network, n.tpUnlockedUser, value, n.tpUnlockedPrivateKey, difficulty, refs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.saveMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, n.deliverMsg(msg)
+}
+
+// pduMethods return the methods of pdu namespace for apps, served with the
+// admin apis since the msgs are signed by the user unlocked by node
+func (n *Node) pduMethods() map[string]adminMethod {
+	return map[string]adminMethod{
+		"pdu_post": n.pduPost,
+	}
+}
+
+// pduPost post the text by [content, refs...], the refs are msg IDs (hex)
+// and optional, return the ID of msg posted
+func (n *Node) pduPost(params []string) (interface{}, error) {
+	if len(params) == 0 || params[0] == "" {
+		return nil, errAdminParams
+	}
+	var refIDs []common.Hash
+	for _, param := range params[1:] {
+		msgID, err := common.HashFromString(param)
+		if err != nil {
+			return nil, err
+		}
+		refIDs = append(refIDs, msgID)
+	}
+	msg, err := n.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte(params[0])}, refIDs...)
+	if err != nil {
+		return nil, err
+	}
+	return common.Hash2String(msg.ID()), nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ForwardedForHeader is the header of client address set by reverse proxy
+	ForwardedForHeader = "X-Forwarded-For"
+	// RealIPHeader is the header of client ip set by reverse proxy, such as
+	// proxy_set_header X-Real-IP $remote_addr of nginx
+	RealIPHeader = "X-Real-IP"
+
+	corsAllowHeaders = "Content-Type, Authorization, " + APIKeyHeader
+	corsAllowMethods = "GET, POST, OPTIONS"
+	corsMaxAge       = "600"
+)
+
+var errProxyNotValid = errors.New("trusted proxy should be ip or cidr")
+
+// SetCORSOrigins set the origins allowed to call local apis from browser,
+// such as https://app.pdu.pub, * allow all origins
+func (n *Node) SetCORSOrigins(origins ...string) {
+	n.corsOrigins = nil
+	for _, origin := range origins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			n.corsOrigins = append(n.corsOrigins, origin)
+		}
+	}
+}
+
+// SetTrustedProxies set the reverse proxies by ip or cidr, the client
+// address of requests from them is taken from X-Forwarded-For or X-Real-IP
+func (n *Node) SetTrustedProxies(proxies ...string) error {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return errProxyNotValid
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return errProxyNotValid
+		}
+		nets = append(nets, ipNet)
+	}
+	n.trustedProxies = nets
+	return nil
+}
+
+// SetPathPrefix mount the local apis and ws under the prefix, such as /pdu
+// if proxy pass /pdu/ to node without strip. Paths without prefix are still
+// served, so peers can dial the node directly.
+func (n *Node) SetPathPrefix(prefix string) {
+	n.pathPrefix = strings.TrimRight(prefix, "/")
+}
+
+func (n Node) trustedProxy(ip net.IP) bool {
+	for _, ipNet := range n.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP return the ip of client, the address forwarded is only used if
+// the request is from trusted proxy. X-Forwarded-For is read from right,
+// the first address not trusted is the client.
+func (n Node) clientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !n.trustedProxy(ip) {
+		return ip
+	}
+	if xff := r.Header.Values(ForwardedForHeader); len(xff) > 0 {
+		addrs := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			forwarded := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if forwarded == nil {
+				break
+			}
+			ip = forwarded
+			if !n.trustedProxy(ip) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get(RealIPHeader))); realIP != nil {
+		return realIP
+	}
+	return ip
+}
+
+// clientAddr return the remote address of request, or the client ip
+// forwarded if the request is from trusted proxy
+func (n Node) clientAddr(r *http.Request) string {
+	if ip := remoteIP(r); ip == nil || !n.trustedProxy(ip) {
+		return r.RemoteAddr
+	}
+	return n.clientIP(r).String()
+}
+
+// forwarded return true if request is forwarded by proxy not trusted, the
+// client address of it is unknown
+func (n Node) forwarded(r *http.Request) bool {
+	if ip := remoteIP(r); ip != nil && n.trustedProxy(ip) {
+		return false
+	}
+	return r.Header.Get(ForwardedForHeader) != "" || r.Header.Get(RealIPHeader) != ""
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (n Node) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range n.corsOrigins {
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// withCORS add the cors headers for the origins allowed, and answer the
+// preflight requests before api key checked
+func (n *Node) withCORS(h http.Handler) http.Handler {
+	if len(n.corsOrigins) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if allowed, ok := n.allowOrigin(origin); origin != "" && ok {
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			header.Set("Access-Control-Allow-Origin", allowed)
+			header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			header.Set("Access-Control-Max-Age", corsMaxAge)
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withPathPrefix strip the prefix from path if exist
+func withPathPrefix(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, prefix)
+		if len(rest) == len(r.URL.Path) || (rest != "" && rest[0] != '/') {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
+}
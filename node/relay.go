@@ -0,0 +1,251 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// RelayAllow gossip the msg matched to peers
+	RelayAllow = "relay"
+	// RelayDrop keep the msg matched in local universe only
+	RelayDrop = "drop"
+)
+
+var (
+	errRelayActionNotValid = errors.New("relay action should be relay or drop")
+	errRelayFileMissing    = errors.New("relay policy file missing")
+)
+
+// RelayRule decide whether the accepted msgs matched are gossiped onward.
+// Msg match if sender in Senders, content type in ContentTypes, content
+// size not over MaxSize, and it is the time proof of any space-time if
+// TimeProofs, or of space-time in SpaceTimes, and score of sender not less
+// than MinScore and not over MaxScore if set, and msg or its sender has any
+// of Labels set by local user. The empty rule match all.
+type RelayRule struct {
+	Action       string        `json:"action"`
+	Senders      []common.Hash `json:"senders,omitempty"`
+	ContentTypes []int         `json:"contentTypes,omitempty"`
+	MaxSize      int           `json:"maxSize,omitempty"`
+	TimeProofs   bool          `json:"timeProofs,omitempty"`
+	SpaceTimes   []common.Hash `json:"spaceTimes,omitempty"`
+	MinScore     float64       `json:"minScore,omitempty"`
+	MaxScore     float64       `json:"maxScore,omitempty"`
+	Labels       []string      `json:"labels,omitempty"`
+}
+
+// RelayPolicy is the rules checked by order, the action of first rule
+// matched is taken, or Default if none matched. The empty policy relay all,
+// such as {"default":"drop"} for archive-only node, and
+// {"default":"drop","rules":[{"action":"relay","contentTypes":[0]}]} for
+// text-only relay node.
+type RelayPolicy struct {
+	Default string       `json:"default,omitempty"`
+	Rules   []*RelayRule `json:"rules,omitempty"`
+}
+
+// RelayStats is the count of accepted msgs relayed or dropped by policy
+type RelayStats struct {
+	Relayed uint64 `json:"relayed"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Match return true if msg match all filters of rule, u is the universe
+// msg accepted by, labels are of msg and its sender
+func (r RelayRule) Match(msg *core.Message, u *core.Universe, labels []string) bool {
+	if len(r.Senders) > 0 && !containsHash(r.Senders, msg.SenderID) {
+		return false
+	}
+	if len(r.ContentTypes) > 0 {
+		found := false
+		for _, t := range r.ContentTypes {
+			if t == msg.Value.ContentType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.MaxSize > 0 && len(msg.Value.Content) > r.MaxSize {
+		return false
+	}
+	if r.TimeProofs || len(r.SpaceTimes) > 0 {
+		// time proof is the msg created by owner of space-time
+		if u == nil || !containsHash(u.GetSpaceTimeIDs(), msg.SenderID) {
+			return false
+		}
+		if len(r.SpaceTimes) > 0 && !containsHash(r.SpaceTimes, msg.SenderID) {
+			return false
+		}
+	}
+	if r.MinScore > 0 || r.MaxScore > 0 {
+		score := senderScore(u, msg.SenderID)
+		if score < r.MinScore || (r.MaxScore > 0 && score > r.MaxScore) {
+			return false
+		}
+	}
+	if len(r.Labels) > 0 && !containsAny(labels, r.Labels) {
+		return false
+	}
+	return true
+}
+
+// Validate check the actions of policy
+func (p RelayPolicy) Validate() error {
+	if p.Default != "" && p.Default != RelayAllow && p.Default != RelayDrop {
+		return errRelayActionNotValid
+	}
+	for _, r := range p.Rules {
+		if r.Action != RelayAllow && r.Action != RelayDrop {
+			return errRelayActionNotValid
+		}
+		for _, label := range r.Labels {
+			if !db.IsMsgLabel(label) && !db.IsUserLabel(label) {
+				return db.ErrLabelNotValid
+			}
+		}
+	}
+	return nil
+}
+
+// Relay return true if msg should be gossiped onward, labels are of msg
+// and its sender
+func (p RelayPolicy) Relay(msg *core.Message, u *core.Universe, labels []string) bool {
+	for _, r := range p.Rules {
+		if r.Match(msg, u, labels) {
+			return r.Action == RelayAllow
+		}
+	}
+	return p.Default != RelayDrop
+}
+
+// LoadRelayPolicy read the policy from json file
+func LoadRelayPolicy(fileName string) (*RelayPolicy, error) {
+	policyBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var policy RelayPolicy
+	if err := json.Unmarshal(policyBytes, &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// relayer hold the policy which can be replaced while node running
+type relayer struct {
+	relayed uint64 // updated atomically, keep 64-bit aligned
+	dropped uint64
+	mu      sync.RWMutex
+	policy  *RelayPolicy
+	file    string // policy is reloaded from it if not empty
+}
+
+func newRelayer() *relayer {
+	return &relayer{policy: &RelayPolicy{}}
+}
+
+func (r *relayer) setPolicy(policy *RelayPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+}
+
+func (r *relayer) getPolicy() *RelayPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.policy
+}
+
+// relay return true if msg should be gossiped, and count it
+func (r *relayer) relay(msg *core.Message, u *core.Universe, labels []string) bool {
+	if r.getPolicy().Relay(msg, u, labels) {
+		atomic.AddUint64(&r.relayed, 1)
+		return true
+	}
+	atomic.AddUint64(&r.dropped, 1)
+	return false
+}
+
+func (r *relayer) stats() *RelayStats {
+	return &RelayStats{Relayed: atomic.LoadUint64(&r.relayed), Dropped: atomic.LoadUint64(&r.dropped)}
+}
+
+// SetRelayPolicy replace the policy deciding which accepted msgs from
+// peers are gossiped onward, msgs created by node are always gossiped
+func (n *Node) SetRelayPolicy(policy *RelayPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	n.relayer.setPolicy(policy)
+	return nil
+}
+
+// LoadRelayPolicy set the policy from json file, the file is read again
+// by ReloadRelayPolicy
+func (n *Node) LoadRelayPolicy(fileName string) error {
+	policy, err := LoadRelayPolicy(fileName)
+	if err != nil {
+		return err
+	}
+	n.relayer.mu.Lock()
+	defer n.relayer.mu.Unlock()
+	n.relayer.policy = policy
+	n.relayer.file = fileName
+	return nil
+}
+
+// ReloadRelayPolicy read the policy file set by LoadRelayPolicy again, the
+// policy in use is kept if the file is not valid
+func (n *Node) ReloadRelayPolicy() error {
+	n.relayer.mu.RLock()
+	fileName := n.relayer.file
+	n.relayer.mu.RUnlock()
+	if fileName == "" {
+		return errRelayFileMissing
+	}
+	return n.LoadRelayPolicy(fileName)
+}
+
+// RelayPolicy return the policy in use and the counts of msgs by it
+func (n *Node) RelayPolicy() (*RelayPolicy, *RelayStats) {
+	return n.relayer.getPolicy(), n.relayer.stats()
+}
+
+func containsHash(hashes []common.Hash, h common.Hash) bool {
+	for _, hash := range hashes {
+		if hash == h {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// DefaultRetentionInterval is the interval the retention policy enforced
+	DefaultRetentionInterval = time.Hour
+	// retentionBatch is the max number of msgs of each type checked in one run
+	retentionBatch = 1000
+)
+
+var errRetentionPolicyNotValid = errors.New("retention policy not valid")
+
+// RetentionRule keep the content of msgs with content types for days since
+// created, 0 days means forever.
+type RetentionRule struct {
+	ContentTypes []int   `json:"contentTypes"`
+	Days         float64 `json:"days"`
+}
+
+// RetentionPolicy decide how long the content of msgs is kept by local node,
+// such as {"rules":[{"contentTypes":[0],"days":0},{"contentTypes":[4],"days":90}]}.
+// The first rule match the content type is used, and the types not in any
+// rule are kept forever. Only the content can be dropped without breaking
+// the msg ID is dropped, that is the content of ephemeral msg, and the file
+// chunk stored out of msg is unpinned from content resolver. Other content
+// is signed into msg ID, so it is kept to validate and sync the msgs.
+type RetentionPolicy struct {
+	Rules []*RetentionRule `json:"rules,omitempty"`
+}
+
+// Validate check the days and content types of rules
+func (p RetentionPolicy) Validate() error {
+	for _, rule := range p.Rules {
+		if rule == nil || rule.Days < 0 || len(rule.ContentTypes) == 0 {
+			return errRetentionPolicyNotValid
+		}
+		for _, contentType := range rule.ContentTypes {
+			if contentType < 0 {
+				return errRetentionPolicyNotValid
+			}
+		}
+	}
+	return nil
+}
+
+// MaxAge return how long the content of type is kept, 0 means forever
+func (p RetentionPolicy) MaxAge(contentType int) time.Duration {
+	for _, rule := range p.Rules {
+		for _, t := range rule.ContentTypes {
+			if t == contentType {
+				return time.Duration(rule.Days * float64(24*time.Hour))
+			}
+		}
+	}
+	return 0
+}
+
+// LoadRetentionPolicy read the policy from json file
+func LoadRetentionPolicy(fileName string) (*RetentionPolicy, error) {
+	policyBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var policy RetentionPolicy
+	if err := json.Unmarshal(policyBytes, &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// RetentionStats is the result of retention policy enforced
+type RetentionStats struct {
+	Checked  int   `json:"checked"`
+	Dropped  int   `json:"dropped"`  // content of ephemeral msgs dropped
+	Unpinned int   `json:"unpinned"` // file chunks unpinned from content resolver
+	Kept     int   `json:"kept"`     // content signed into msg ID
+	LastRun  int64 `json:"lastRun,omitempty"`
+}
+
+// janitor enforce the retention policy in background, the msgs of each type
+// are checked from the cursor in received order
+type janitor struct {
+	mu       sync.Mutex
+	policy   *RetentionPolicy
+	interval time.Duration
+	cursors  map[int]int
+	stats    RetentionStats
+	lastRun  time.Time
+}
+
+func newJanitor() *janitor {
+	return &janitor{policy: &RetentionPolicy{}, interval: DefaultRetentionInterval, cursors: make(map[int]int)}
+}
+
+// SetRetentionPolicy set the policy enforced every interval, the msgs are
+// checked again from the first one
+func (n *Node) SetRetentionPolicy(policy *RetentionPolicy, interval time.Duration) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = DefaultRetentionInterval
+	}
+	n.janitor.mu.Lock()
+	defer n.janitor.mu.Unlock()
+	n.janitor.policy = policy
+	n.janitor.interval = interval
+	n.janitor.cursors = make(map[int]int)
+	return nil
+}
+
+// LoadRetentionPolicy set the policy from json file, enforced every
+// DefaultRetentionInterval
+func (n *Node) LoadRetentionPolicy(fileName string) error {
+	policy, err := LoadRetentionPolicy(fileName)
+	if err != nil {
+		return err
+	}
+	return n.SetRetentionPolicy(policy, DefaultRetentionInterval)
+}
+
+// RetentionPolicy return the policy in use
+func (n Node) RetentionPolicy() *RetentionPolicy {
+	n.janitor.mu.Lock()
+	defer n.janitor.mu.Unlock()
+	return n.janitor.policy
+}
+
+// RetentionStats return the total result of retention policy enforced
+func (n Node) RetentionStats() RetentionStats {
+	n.janitor.mu.Lock()
+	defer n.janitor.mu.Unlock()
+	return n.janitor.stats
+}
+
+// checkRetention enforce the policy if interval passed since last run
+func (n Node) checkRetention() {
+	n.janitor.mu.Lock()
+	due := len(n.janitor.policy.Rules) > 0 && time.Since(n.janitor.lastRun) >= n.janitor.interval
+	n.janitor.mu.Unlock()
+	if due {
+		n.EnforceRetention()
+	}
+}
+
+// EnforceRetention drop the content of msgs kept longer than the policy now,
+// return the result of this run. The content dropped is removed from local
+// universe and udb together, so the content shared by other msgs is kept by
+// its reference count.
+func (n Node) EnforceRetention() RetentionStats {
+	n.janitor.mu.Lock()
+	defer n.janitor.mu.Unlock()
+	now := time.Now()
+	stats := RetentionStats{LastRun: now.Unix()}
+	if n.universe == nil {
+		return stats
+	}
+	for _, rule := range n.janitor.policy.Rules {
+		for _, contentType := range rule.ContentTypes {
+			maxAge := n.janitor.policy.MaxAge(contentType)
+			if maxAge == 0 {
+				continue
+			}
+			n.enforceType(contentType, now.Add(-maxAge), &stats)
+		}
+	}
+	n.janitor.lastRun = now
+	n.janitor.stats.Checked += stats.Checked
+	n.janitor.stats.Dropped += stats.Dropped
+	n.janitor.stats.Unpinned += stats.Unpinned
+	n.janitor.stats.Kept += stats.Kept
+	n.janitor.stats.LastRun = stats.LastRun
+	return stats
+}
+
+// enforceType check the msgs of type from cursor until the msg created after
+// deadline, janitor lock is held
+func (n Node) enforceType(contentType int, deadline time.Time, stats *RetentionStats) {
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	cursor := n.janitor.cursors[contentType]
+	msgs, err := db.GetMsgsByType(n.udb, contentType, cursor, retentionBatch)
+	if err != nil {
+		log.Error("Get msgs by type fail", contentType, err)
+		return
+	}
+	for _, msg := range msgs {
+		if created, ok := msg.TimeHint(); ok && created.After(deadline) {
+			break
+		} else if ok {
+			n.expireContent(msg, stats)
+		}
+		cursor++
+	}
+	n.janitor.cursors[contentType] = cursor
+}
+
+// expireContent drop or unpin the content of msg expired by retention policy
+func (n Node) expireContent(msg *core.Message, stats *RetentionStats) {
+	stats.Checked++
+	if msg.ContentDropped() {
+		return
+	}
+	if msg.Expiry > 0 {
+		if n.universe.DropContent(msg.ID()) == nil {
+			return
+		}
+		if err := db.DropMsgContent(n.udb, msg.ID()); err != nil {
+			log.Error("Drop retained content fail", common.Hash2String(msg.ID()), err)
+			return
+		}
+		n.audit(&db.AuditEntry{Kind: AuditPrune, Peer: originLocal, MsgID: msg.ID(), UserID: msg.SenderID, Reason: "retention"})
+		stats.Dropped++
+		return
+	}
+	if msg.Value.ContentType == core.TypeFile && n.contentResolver != nil {
+		var cf core.ContentFile
+		if err := json.Unmarshal(msg.Value.Content, &cf); err == nil && cf.Offloaded() {
+			if err := n.contentResolver.Unpin(cf.CID); err != nil {
+				log.Error("Unpin file chunk fail", cf.CID, err)
+			}
+			stats.Unpinned++
+			return
+		}
+	}
+	stats.Kept++
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// minSenderScale is the least part of sender rate kept for user with low
+// score, so new born user can still send msgs slowly
+const minSenderScale = 0.1
+
+var (
+	errSenderRateNotValid = errors.New("sender rate should not be negative")
+	errSenderRateLimited  = errors.New("sender rate limited")
+)
+
+// senderLimiter is the token buckets of senders, the rate of each sender is
+// scaled by its score, so mass created users are dampened
+type senderLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // msgs per second of user with full score, 0 if not limited
+	burst    int
+	limiters map[common.Hash]*rateLimiter
+}
+
+func newSenderLimiter() *senderLimiter {
+	return &senderLimiter{limiters: make(map[common.Hash]*rateLimiter)}
+}
+
+func (l *senderLimiter) set(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, burst
+	l.limiters = make(map[common.Hash]*rateLimiter)
+}
+
+// allow return true if sender with score can send one more msg now
+func (l *senderLimiter) allow(senderID common.Hash, score float64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return true
+	}
+	if score < minSenderScale {
+		score = minSenderScale
+	}
+	limiter, ok := l.limiters[senderID]
+	if !ok {
+		burst := int(float64(l.burst) * score)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = newRateLimiter(l.rate*score, burst)
+		l.limiters[senderID] = limiter
+	}
+	// score changes with the age of sender
+	limiter.rate = l.rate * score
+	return limiter.allow(now)
+}
+
+// senderScore return the score of sender in universe, 0 if not exist
+func senderScore(u *core.Universe, senderID common.Hash) float64 {
+	if u == nil {
+		return 0
+	}
+	score, err := u.GetUserScore(senderID)
+	if err != nil {
+		return 0
+	}
+	return score.Score
+}
+
+// SetSenderRateLimit limit the msgs per second submitted by ws from each
+// sender, rate is for user with full score and scaled down by the score of
+// sender, 0 if not limited
+func (n *Node) SetSenderRateLimit(rate float64, burst int) error {
+	if rate < 0 || burst < 0 {
+		return errSenderRateNotValid
+	}
+	n.senders.set(rate, burst)
+	return nil
+}
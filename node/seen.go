@@ -0,0 +1,155 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+const (
+	// DefaultSeenWindow is how long the msgs processed are remembered, the
+	// same msg received from other peers in the window is not verified again
+	DefaultSeenWindow = time.Minute * 10
+
+	// MaxSeenMsgs is the max number of msgs remembered, the oldest are
+	// forgotten first
+	MaxSeenMsgs = 65536
+)
+
+// SeenStats is the count of msgs suppressed by the window of msgs seen
+type SeenStats struct {
+	Size         int    `json:"size"`
+	Suppressed   uint64 `json:"suppressed"`   // msgs received again and not verified
+	RelaySkipped uint64 `json:"relaySkipped"` // msgs not forwarded to peers again
+}
+
+// seenMsg is the msg processed or relayed, rejection is the reason if the
+// msg is rejected and will be rejected again
+type seenMsg struct {
+	deadline  time.Time
+	accepted  bool
+	rejection *core.Rejection
+	relayed   bool
+}
+
+// seenMsgs is the msgs accepted, rejected or relayed recently, shared by
+// all peer conns, so the msg gossiped by many peers is verified and
+// forwarded once in dense topologies
+type seenMsgs struct {
+	lock   sync.Mutex
+	window time.Duration
+	msgs   map[common.Hash]*seenMsg
+	order  []common.Hash
+	stats  SeenStats
+}
+
+func newSeenMsgs() *seenMsgs {
+	return &seenMsgs{window: DefaultSeenWindow, msgs: make(map[common.Hash]*seenMsg)}
+}
+
+// get return true if the msg is accepted or rejected in window, the
+// rejection is nil if accepted
+func (s *seenMsgs) get(msgID common.Hash, now time.Time) (*core.Rejection, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sm := s.msgs[msgID]
+	if sm == nil || now.After(sm.deadline) || (!sm.accepted && sm.rejection == nil) {
+		return nil, false
+	}
+	s.stats.Suppressed++
+	return sm.rejection, true
+}
+
+func (s *seenMsgs) accept(msgID common.Hash, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sm := s.add(msgID, now); sm != nil {
+		sm.accepted, sm.rejection = true, nil
+	}
+}
+
+func (s *seenMsgs) reject(r *core.Rejection, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sm := s.add(r.MsgID, now); sm != nil {
+		sm.accepted, sm.rejection = false, r
+	}
+}
+
+// relay return false if the msg already relayed in window, otherwise the
+// msg is marked as relayed
+func (s *seenMsgs) relay(msgID common.Hash, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sm := s.msgs[msgID]; sm != nil && sm.relayed && !now.After(sm.deadline) {
+		s.stats.RelaySkipped++
+		return false
+	}
+	if sm := s.add(msgID, now); sm != nil {
+		sm.relayed = true
+	}
+	return true
+}
+
+// add return the msg in window with deadline renewed, the expired msgs and
+// the oldest over MaxSeenMsgs are forgotten. nil if window is 0.
+func (s *seenMsgs) add(msgID common.Hash, now time.Time) *seenMsg {
+	if s.window <= 0 {
+		return nil
+	}
+	sm := s.msgs[msgID]
+	if sm != nil && now.After(sm.deadline) {
+		*sm = seenMsg{}
+	}
+	if sm == nil {
+		for len(s.order) > 0 && (len(s.order) >= MaxSeenMsgs || now.After(s.msgs[s.order[0]].deadline)) {
+			delete(s.msgs, s.order[0])
+			s.order = s.order[1:]
+		}
+		sm = &seenMsg{}
+		s.msgs[msgID] = sm
+		s.order = append(s.order, msgID)
+	}
+	sm.deadline = now.Add(s.window)
+	return sm
+}
+
+func (s *seenMsgs) copyStats() *SeenStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := s.stats
+	stats.Size = len(s.msgs)
+	return &stats
+}
+
+// SetSeenWindow set how long the msgs processed are remembered, the msg
+// received again in window is not verified and not forwarded to peers
+// again. 0 disable the window. Should be set before Run.
+func (n *Node) SetSeenWindow(window time.Duration) {
+	n.seen.lock.Lock()
+	defer n.seen.lock.Unlock()
+	n.seen.window = window
+}
+
+// SeenStats return the count of msgs suppressed by the window of msgs seen
+func (n Node) SeenStats() *SeenStats {
+	return n.seen.copyStats()
+}
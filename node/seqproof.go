@@ -0,0 +1,91 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+// ProveSeq create the proof of msg sequence in space-time, the chain of proof
+// start from the first checkpoint recorded after the msg, or the latest time
+// proof msg if the checkpoint not be recorded yet.
+func (n Node) ProveSeq(msgID common.Hash, spaceTimeID common.Hash) (*core.SeqProof, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	proof, err := n.universe.ProveSeq(msgID, spaceTimeID)
+	if err != nil {
+		return nil, err
+	}
+	cpSeq := (proof.Seq + n.cpInterval - 1) / n.cpInterval * n.cpInterval
+	if cpSeq <= proof.AnchorSeq {
+		cp, err := db.GetCheckpoint(n.udb, spaceTimeID, cpSeq)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil && cp.MsgID == proof.Chain[proof.AnchorSeq-cpSeq].ID() {
+			if err := proof.Trim(cpSeq); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return proof, nil
+}
+
+// seqProofHandler return the proof of msg sequence in space-time, primary
+// space-time if st not given, such as /proof?id=...&st=...
+func (n Node) seqProofHandler(w http.ResponseWriter, r *http.Request) {
+	if n.universe == nil {
+		http.Error(w, errUniverseNotExist.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	msgID, err := common.HashFromString(q.Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stID := n.universe.GetPrimarySpaceTime()
+	if s := q.Get("st"); s != "" {
+		if stID, err = common.ParseUserID(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	proof, err := n.ProveSeq(msgID, stID)
+	switch err {
+	case nil:
+	case core.ErrMsgNotFound, core.ErrSpaceTimeNotExist, core.ErrSeqNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := json.Marshal(proof)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
@@ -0,0 +1,624 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+// snapshotOfferTimeout is the time to wait for the snapshots offered by peers
+const snapshotOfferTimeout = 5 * time.Second
+
+var (
+	errSnapshotNotOffered  = errors.New("no snapshot offered by peers")
+	errSnapshotNoPeer      = errors.New("no peer serve the snapshot")
+	errSnapshotNotMatchDB  = errors.New("local msgs not match snapshot")
+	errSnapshotChunkBroken = errors.New("snapshot chunk broken in local db")
+)
+
+// SnapshotStatus is the progress of snapshot sync. The snapshot signed by the
+// owner of space-time is chosen from the snapshots offered by peers, and its
+// chunks are fetched from all peers serving the same snapshot, then replayed
+// into a staging universe, which replace the local universe only if the state
+// match the checkpoint of snapshot.
+type SnapshotStatus struct {
+	Running     bool        `json:"running"`
+	Done        bool        `json:"done"`
+	Applied     bool        `json:"applied"` // snapshot replaced local universe
+	Peers       int         `json:"peers"`   // peers serving the chosen snapshot
+	SpaceTimeID common.Hash `json:"spacetimeID"`
+	Seq         uint64      `json:"seq"`
+	MsgCount    uint64      `json:"msgCount"`
+	Chunks      int         `json:"chunks"`
+	Fetched     int         `json:"fetched"`  // chunks verified by hash
+	Replayed    int         `json:"replayed"` // chunks committed into staging universe
+	Err         string      `json:"err,omitempty"`
+	StartTime   time.Time   `json:"startTime"`
+	EndTime     time.Time   `json:"endTime"`
+}
+
+// snapshotChunk is the chunk asked from peer
+type snapshotChunk struct {
+	pid   common.Hash
+	index int
+	asked time.Time
+}
+
+// snapshotSync keep the state of snapshot sync, the snapshot is offered and
+// the chunks are answered in node loop, and replayed by the worker. The
+// snapshot of local universe served to peers is cached as well.
+type snapshotSync struct {
+	lock    sync.Mutex
+	enable  bool
+	status  SnapshotStatus
+	offers  map[common.Hash]common.Hash    // peer ID by the wave ID asked for snapshot
+	offered map[common.Hash]*core.Snapshot // snapshot by peer ID, nil if peer has none
+	chosen  *core.Snapshot
+	root    common.Hash
+	builder *core.SnapshotBuilder
+	peers   map[common.Hash]*syncPeer      // peers serving the chosen snapshot
+	chunks  map[common.Hash]*snapshotChunk // asked chunks by wave ID
+	next    int
+	retry   []int
+	fetched map[int][]*core.Message // chunks verified but not replayed yet
+	wake    chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+
+	servedLock sync.Mutex
+	served     *core.Snapshot // snapshot of local universe served to peers
+}
+
+func newSnapshotSync() *snapshotSync {
+	return &snapshotSync{
+		offers:  make(map[common.Hash]common.Hash),
+		offered: make(map[common.Hash]*core.Snapshot),
+		peers:   make(map[common.Hash]*syncPeer),
+		chunks:  make(map[common.Hash]*snapshotChunk),
+		fetched: make(map[int][]*core.Message),
+		wake:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+}
+
+// EnableSnapshotSync fetch the snapshot of universe from peers before the
+// initial sync, used by new node of large universe, should be set before Run
+func (n *Node) EnableSnapshotSync() {
+	n.snapshot.enable = true
+}
+
+// SnapshotStatus return the progress of snapshot sync, nil if not enabled
+func (n Node) SnapshotStatus() *SnapshotStatus {
+	s := n.snapshot
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.enable {
+		return nil
+	}
+	status := s.status
+	status.Peers = 0
+	for _, sp := range s.peers {
+		if !sp.failed {
+			status.Peers++
+		}
+	}
+	return &status
+}
+
+func (s *snapshotSync) running() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.status.Running
+}
+
+func (s *snapshotSync) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *snapshotSync) stop() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+// snapshotFromPeer ask the snapshot offered by peer, and fetch chunks from
+// it if the snapshot is chosen. Return false if snapshot sync is not enabled
+// or already done, so the msgs should be synced from peer instead.
+func (n *Node) snapshotFromPeer(pid common.Hash) bool {
+	s := n.snapshot
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.enable || s.status.Done {
+		return false
+	}
+	if !s.status.Running {
+		s.status.Running = true
+		s.status.StartTime = time.Now()
+		log.Info("Start snapshot sync")
+		go n.runSnapshot()
+	}
+	if _, ok := s.offered[pid]; !ok && !s.asking(pid) {
+		if err := n.askSnapshot(pid); err != nil {
+			log.Error("Ask snapshot fail", common.Hash2String(pid), err)
+		}
+	}
+	n.askChunks()
+	return true
+}
+
+// asking return true if snapshot is asked from peer but not answered
+func (s *snapshotSync) asking(pid common.Hash) bool {
+	for _, asked := range s.offers {
+		if asked == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) askSnapshot(pid common.Hash) error {
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
+	waveID := common.CreateHash()
+	if err := p.AskSnapshot(waveID); err != nil {
+		return err
+	}
+	n.snapshot.offers[waveID] = pid
+	return nil
+}
+
+// handleSnapshot take the snapshot offered by peer, the peer serve chunks
+// if its snapshot is same as the chosen one
+func (n *Node) handleSnapshot(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveSnapshot)
+	s := n.snapshot
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pid, ok := s.offers[wm.WaveID]
+	if !ok {
+		return wm.WaveID, nil
+	}
+	delete(s.offers, wm.WaveID)
+	s.offered[pid] = wm.Snapshot
+	if s.chosen == nil {
+		n.chooseSnapshot(false)
+	} else if wm.Snapshot != nil && wm.Snapshot.Root() == s.root {
+		s.peers[pid] = &syncPeer{}
+	}
+	n.askChunks()
+	return wm.WaveID, nil
+}
+
+// chooseSnapshot choose the snapshot with most msgs from the offered ones,
+// which must be signed by the owner of space-time. The snapshot sync is done
+// without snapshot if none can be chosen after timeout. Should be called
+// with lock of snapshotSync.
+func (n *Node) chooseSnapshot(timeout bool) {
+	s := n.snapshot
+	if s.chosen != nil || !s.status.Running || n.universe == nil {
+		return
+	}
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		n.finishSnapshot(err)
+		return
+	}
+	var best *core.Snapshot
+	for _, snap := range s.offered {
+		if snap == nil || snap.Signature == nil || snap.SignerID != snap.Checkpoint.SpaceTimeID ||
+			snap.ChunkSize == 0 || snap.ChunkSize > peer.MaxSyncMsgCountPerWave || snap.MsgCount <= count.Uint64() {
+			continue
+		}
+		if best == nil || snap.MsgCount > best.MsgCount {
+			best = snap
+		}
+	}
+	if best == nil {
+		if timeout {
+			n.finishSnapshot(errSnapshotNotOffered)
+		}
+		return
+	}
+	builder, err := n.universe.NewSnapshotBuilder(best)
+	if err != nil {
+		log.Warn("Snapshot offered not valid", err)
+		for pid, snap := range s.offered {
+			if snap == best {
+				s.offered[pid] = nil
+			}
+		}
+		return
+	}
+	s.chosen, s.root, s.builder = best, best.Root(), builder
+	for pid, snap := range s.offered {
+		if snap != nil && snap.Root() == s.root {
+			s.peers[pid] = &syncPeer{}
+		}
+	}
+	cp := best.Checkpoint
+	s.status.SpaceTimeID, s.status.Seq = cp.SpaceTimeID, cp.Seq
+	s.status.MsgCount, s.status.Chunks = best.MsgCount, len(best.Chunks)
+	log.Info("Snapshot at seq", cp.Seq, "of space-time", common.Hash2String(cp.SpaceTimeID), "chosen,", best.MsgCount, "msgs in", len(best.Chunks), "chunks")
+}
+
+// askChunks ask chunks from each peer serving the chosen snapshot until the
+// window of peer is full, should be called with lock of snapshotSync
+func (n *Node) askChunks() {
+	s := n.snapshot
+	if s.chosen == nil || s.status.Done {
+		return
+	}
+	for pid, sp := range s.peers {
+		for !sp.failed && sp.inFlight < syncWindow {
+			i, ok := s.nextChunk()
+			if !ok {
+				break
+			}
+			if err := n.askChunk(pid, i); err != nil {
+				s.retry = append(s.retry, i)
+				if err != peer.ErrPeerBusy {
+					log.Error("Ask snapshot chunk fail", common.Hash2String(pid), err)
+					sp.failed = true
+				}
+				break
+			}
+			sp.inFlight++
+		}
+	}
+}
+
+// nextChunk return the index of chunk asked next, the chunks not answered
+// by others go first
+func (s *snapshotSync) nextChunk() (int, bool) {
+	if len(s.retry) > 0 {
+		i := s.retry[0]
+		s.retry = s.retry[1:]
+		return i, true
+	}
+	if s.next < len(s.chosen.Chunks) {
+		s.next++
+		return s.next - 1, true
+	}
+	return 0, false
+}
+
+func (n *Node) askChunk(pid common.Hash, i int) error {
+	p, err := n.getPeer(pid)
+	if err != nil {
+		return err
+	}
+	s := n.snapshot
+	cp := s.chosen.Checkpoint
+	waveID := common.CreateHash()
+	if err := p.AskSnapshotChunk(waveID, cp.SpaceTimeID, cp.Seq, s.root, uint64(i)); err != nil {
+		return err
+	}
+	s.chunks[waveID] = &snapshotChunk{pid: pid, index: i, asked: time.Now()}
+	return nil
+}
+
+// snapshotAnswer take the msgs answered to the question of snapshot chunk,
+// the chunk is verified by its hash, and asked from other peers if not valid.
+// Return false if wave is not asked by snapshot sync.
+func (n *Node) snapshotAnswer(wm *galaxy.WaveMessages) bool {
+	s := n.snapshot
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.chunks[wm.WaveID]
+	if !ok {
+		return false
+	}
+	delete(s.chunks, wm.WaveID)
+	sp := s.peers[c.pid]
+	if sp != nil {
+		sp.inFlight--
+	}
+	msgs, err := decodeMsgs(wm.Msgs)
+	if err == nil {
+		err = s.chosen.VerifyChunk(c.index, msgs)
+	}
+	if err != nil {
+		log.Warn("Snapshot chunk", c.index, "from", common.Hash2String(c.pid), "not valid", err)
+		if sp != nil {
+			sp.failed = true
+		}
+		s.retry = append(s.retry, c.index)
+	} else {
+		s.fetched[c.index] = msgs
+		s.status.Fetched++
+		s.signal()
+	}
+	n.askChunks()
+	return true
+}
+
+func decodeMsgs(msgsB [][]byte) ([]*core.Message, error) {
+	msgs := make([]*core.Message, len(msgsB))
+	for i, msgBytes := range msgsB {
+		msgs[i] = new(core.Message)
+		if err := json.Unmarshal(msgBytes, msgs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// checkSnapshot choose the snapshot after the offers timeout, and ask the
+// chunks not answered in time from other peers, the snapshot sync is done
+// without snapshot if all peers fail to serve it
+func (n *Node) checkSnapshot() {
+	s := n.snapshot
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.status.Running {
+		return
+	}
+	if s.chosen == nil {
+		n.chooseSnapshot(time.Since(s.status.StartTime) > snapshotOfferTimeout)
+		n.askChunks()
+		return
+	}
+	for waveID, c := range s.chunks {
+		if time.Since(c.asked) < syncRangeTimeout {
+			continue
+		}
+		delete(s.chunks, waveID)
+		if sp, ok := s.peers[c.pid]; ok {
+			sp.inFlight--
+			sp.failed = true
+		}
+		s.retry = append(s.retry, c.index)
+	}
+	n.askChunks()
+	// wait for peers to connect again if all peers are removed
+	if len(s.peers) == 0 || (len(s.retry) == 0 && s.next == len(s.chosen.Chunks)) {
+		return
+	}
+	for _, sp := range s.peers {
+		if !sp.failed {
+			return
+		}
+	}
+	n.finishSnapshot(errSnapshotNoPeer)
+}
+
+// dropSnapshotPeer remove the peer from snapshot sync, the chunks asked from
+// it are asked from other peers
+func (n *Node) dropSnapshotPeer(pid common.Hash) {
+	s := n.snapshot
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.status.Running {
+		return
+	}
+	delete(s.offered, pid)
+	delete(s.peers, pid)
+	for waveID, asked := range s.offers {
+		if asked == pid {
+			delete(s.offers, waveID)
+		}
+	}
+	for waveID, c := range s.chunks {
+		if c.pid == pid {
+			delete(s.chunks, waveID)
+			s.retry = append(s.retry, c.index)
+		}
+	}
+	n.askChunks()
+}
+
+// finishSnapshot mark the snapshot sync done, the msgs after the snapshot
+// or all msgs if failed are synced by initial sync. Should be called with
+// lock of snapshotSync.
+func (n *Node) finishSnapshot(err error) {
+	s := n.snapshot
+	s.status.Running, s.status.Done = false, true
+	s.status.EndTime = time.Now()
+	if err != nil {
+		s.status.Err = err.Error()
+		log.Warn("Snapshot sync fail, sync msgs from peers", err)
+	} else {
+		s.status.Applied = true
+		log.Info("Snapshot sync done,", s.status.MsgCount, "msgs in", s.status.EndTime.Sub(s.status.StartTime))
+	}
+	s.builder, s.fetched, s.chunks, s.retry = nil, nil, nil, nil
+	s.signal()
+}
+
+// runSnapshot is the worker of snapshot sync, replay the fetched chunks in
+// order, and apply the snapshot after all chunks replayed
+func (n *Node) runSnapshot() {
+	s := n.snapshot
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.wake:
+		}
+		for {
+			s.lock.Lock()
+			b := s.builder
+			if s.status.Done {
+				s.lock.Unlock()
+				return
+			} else if b == nil {
+				s.lock.Unlock()
+				break
+			}
+			if b.Done() {
+				s.lock.Unlock()
+				err := n.applySnapshot(b, s.chosen)
+				s.lock.Lock()
+				n.finishSnapshot(err)
+				s.lock.Unlock()
+				return
+			}
+			msgs, ok := s.fetched[b.Next()]
+			if !ok {
+				s.lock.Unlock()
+				break
+			}
+			delete(s.fetched, b.Next())
+			s.lock.Unlock()
+			err := b.AddChunk(msgs)
+			s.lock.Lock()
+			if err != nil {
+				n.finishSnapshot(err)
+				s.lock.Unlock()
+				return
+			}
+			s.status.Replayed++
+			s.lock.Unlock()
+		}
+	}
+}
+
+// applySnapshot replace the local universe by the snapshot replayed, and
+// save the msgs into db. The msgs in local db should be the head of msgs in
+// snapshot, such as the genesis msg.
+func (n *Node) applySnapshot(b *core.SnapshotBuilder, snap *core.Snapshot) error {
+	n.msgLock.Lock()
+	defer n.msgLock.Unlock()
+	n.storeLock.Lock()
+	defer n.storeLock.Unlock()
+	count, err := db.GetMsgCount(n.udb)
+	if err != nil {
+		return err
+	}
+	msgs := b.Msgs()
+	if count.Uint64() > uint64(len(msgs)) {
+		return errSnapshotNotMatchDB
+	}
+	local := db.GetMsgByOrder(n.udb, big.NewInt(0), int(count.Int64()))
+	if len(local) != int(count.Int64()) {
+		return errSnapshotNotMatchDB
+	}
+	for i, msg := range local {
+		if msg.ID() != msgs[i].ID() {
+			return errSnapshotNotMatchDB
+		}
+	}
+	if err := b.Apply(n.universe); err != nil {
+		return err
+	}
+	for _, msg := range msgs[len(local):] {
+		if err := db.SaveMsg(n.udb, msg); err != nil {
+			return err
+		}
+		if err := n.mirrorMsg(msg); err != nil {
+			log.Error("Mirror msg fail", err)
+		}
+	}
+	return db.SaveCheckpoint(n.udb, &snap.Checkpoint)
+}
+
+// servedSnapshot return the snapshot of local universe at the checkpoint,
+// nil if checkpoint not recorded. The snapshot is cached until other
+// checkpoint asked, and signed if the time proof user own the space-time.
+func (n Node) servedSnapshot(spacetimeID common.Hash, seq uint64) (*core.Snapshot, error) {
+	s := n.snapshot
+	s.servedLock.Lock()
+	defer s.servedLock.Unlock()
+	if snap := s.served; snap != nil && snap.Checkpoint.SpaceTimeID == spacetimeID && snap.Checkpoint.Seq == seq {
+		return snap, nil
+	}
+	cp, err := db.GetCheckpoint(n.udb, spacetimeID, seq)
+	if err != nil || cp == nil {
+		return nil, err
+	}
+	order, _, err := db.GetOrderCntByMsg(n.udb, cp.MsgID)
+	if err != nil {
+		return nil, err
+	}
+	snap := &core.Snapshot{Checkpoint: *cp, MsgCount: order.Uint64() + 1, ChunkSize: DefaultSnapshotChunkSize}
+	for i := 0; i < core.ChunkCount(snap.MsgCount, snap.ChunkSize); i++ {
+		from, count := snap.ChunkRange(i)
+		msgs := db.GetMsgByOrder(n.udb, new(big.Int).SetUint64(from), int(count))
+		if uint64(len(msgs)) != count {
+			return nil, errSnapshotChunkBroken
+		}
+		hash, err := core.ChunkHash(msgs)
+		if err != nil {
+			return nil, err
+		}
+		snap.Chunks = append(snap.Chunks, hash)
+	}
+	if n.tpUnlockedUser != nil && n.tpUnlockedUser.ID() == spacetimeID {
+		if err := snap.Sign(n.tpUnlockedUser, n.tpUnlockedPrivateKey); err != nil {
+			return nil, err
+		}
+	}
+	s.served = snap
+	return snap, nil
+}
+
+// handleQuestionSnapshot answer the snapshot at the latest checkpoint of
+// primary space-time
+func (n Node) handleQuestionSnapshot(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
+	var snap *core.Snapshot
+	if n.universe != nil {
+		stID := n.universe.GetPrimarySpaceTime()
+		seq := n.universe.GetMaxSeq(stID)
+		var err error
+		if snap, err = n.servedSnapshot(stID, seq-seq%n.cpInterval); err != nil {
+			return wq.WaveID, err
+		}
+	}
+	p := n.wsPeer(ws)
+	if err := p.SendSnapshot(wq.WaveID, snap); err != nil {
+		return wq.WaveID, err
+	}
+	return wq.WaveID, nil
+}
+
+// handleQuestionSnapshotChunk answer the msgs of chunk, no msgs if the
+// snapshot of local universe at the checkpoint is not same as asked
+func (n Node) handleQuestionSnapshotChunk(ws *websocket.Conn, wq *galaxy.WaveQuestion, q *galaxy.SnapshotChunkQuestion) (common.Hash, error) {
+	stID, seq, root := q.SpaceTimeID, q.Seq, q.Root
+	i := new(big.Int).SetUint64(q.Index)
+	snap, err := n.servedSnapshot(stID, seq)
+	if err != nil {
+		return wq.WaveID, err
+	}
+	var msgs []*core.Message
+	var total uint64
+	if snap != nil && snap.Root() == root && i.Cmp(big.NewInt(int64(len(snap.Chunks)))) < 0 {
+		from, count := snap.ChunkRange(int(i.Int64()))
+		msgs = db.GetMsgByOrder(n.udb, new(big.Int).SetUint64(from), int(count))
+		total = snap.MsgCount
+	}
+	p := n.wsPeer(ws)
+	if err := p.SendMsgRange(wq.WaveID, msgs, total); err != nil {
+		return wq.WaveID, err
+	}
+	return wq.WaveID, nil
+}
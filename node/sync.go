@@ -0,0 +1,720 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/filter"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+const (
+	syncWindow       = 4                // ranges asked from one peer at same time
+	syncRangeTimeout = 10 * time.Second // range is asked from other peers if not answered in time
+	syncRateWindow   = 10 * time.Second // download rate is measured in it, and peers downloaded from are active
+)
+
+// SyncStatus is the progress of initial sync, which download msgs by order
+// ranges from all peers in parallel, verify the msgs by worker pool, and
+// commit them in topological order. After initial sync, the msg count and
+// sequences answered by peers are compared with local universe, so users
+// know whether the local view is current.
+type SyncStatus struct {
+	Running    bool      `json:"running"`
+	Done       bool      `json:"done"`
+	Peers      int       `json:"peers"`
+	Target     uint64    `json:"target"`    // max msg count of peers
+	Requested  uint64    `json:"requested"` // msgs before this order are asked
+	InFlight   int       `json:"inFlight"`  // ranges asked but not answered yet
+	Downloaded uint64    `json:"downloaded"`
+	Committed  uint64    `json:"committed"`
+	Duplicated uint64    `json:"duplicated"` // already in universe
+	Rejected   uint64    `json:"rejected"`
+	Pending    int       `json:"pending"` // wait for the msgs they refer
+	Rate       float64   `json:"rate"`    // committed msgs per second
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+
+	Current      bool             `json:"current"`      // no msgs or sequences known by peers missing in local
+	LocalCount   uint64           `json:"localCount"`   // msg count of local db
+	Remaining    uint64           `json:"remaining"`    // estimated msgs of peers not in local
+	DownloadRate float64          `json:"downloadRate"` // msgs downloaded per second recently
+	ActivePeers  []string         `json:"activePeers"`  // urls of peers msgs downloaded from recently
+	SpaceTimes   []*SpaceTimeSync `json:"spaceTimes"`   // followed space-times, primary first
+
+	Snapshot *SnapshotStatus `json:"snapshot,omitempty"` // nil if snapshot sync not enabled
+}
+
+// SpaceTimeSync is the time proof sequence of followed space-time in local
+// universe, and the highest one in checkpoints answered by peers
+type SpaceTimeSync struct {
+	SpaceTimeID common.Hash `json:"spaceTimeID"`
+	LocalSeq    uint64      `json:"localSeq"`
+	PeerSeq     uint64      `json:"peerSeq"`
+}
+
+// peerView is the msg count and sequences answered by peer lately, kept
+// after initial sync until peer removed
+type peerView struct {
+	total      uint64
+	seqs       map[common.Hash]uint64 // space-time ID : highest seq of checkpoints
+	downloaded time.Time              // last time msgs downloaded from peer
+}
+
+// rateSample is the msgs downloaded at one time
+type rateSample struct {
+	at    time.Time
+	count int
+}
+
+// syncRange is the msgs by order [from, from+count) in peer
+type syncRange struct {
+	pid   common.Hash
+	from  uint64
+	count uint64
+	asked time.Time
+}
+
+type syncPeer struct {
+	total    uint64
+	known    bool // total is answered by peer
+	inFlight int
+	failed   bool
+}
+
+// syncItem is the downloaded msg waiting to be committed, the receipt is
+// nil if the sender not exist when msg verified, err is set if msg failed
+// to be verified
+type syncItem struct {
+	msg     *core.Message
+	receipt *core.Receipt
+	err     error
+}
+
+// syncer keep the state of initial sync. The ranges are asked and answered
+// in node loop, and the msgs are verified and committed by the worker of
+// syncer, so the download go on while msgs are committed.
+type syncer struct {
+	lock    sync.Mutex
+	status  SyncStatus
+	peers   map[common.Hash]*syncPeer
+	ranges  map[common.Hash]*syncRange // asked ranges by wave ID
+	retry   []*syncRange               // ranges not answered, asked from other peers
+	answers [][][]byte                 // msgs answered but not handled by worker
+	views   map[common.Hash]*peerView  // views of peers, also updated after initial sync
+	samples []rateSample               // msgs downloaded in rate window
+	wake    chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+
+	// used by worker only
+	waiting map[common.Hash][]*syncItem // by the ID of msg referred but not committed
+	queued  map[common.Hash]bool        // ID of msgs in waiting
+}
+
+func newSyncer() *syncer {
+	return &syncer{
+		peers:   make(map[common.Hash]*syncPeer),
+		ranges:  make(map[common.Hash]*syncRange),
+		views:   make(map[common.Hash]*peerView),
+		wake:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		waiting: make(map[common.Hash][]*syncItem),
+		queued:  make(map[common.Hash]bool),
+	}
+}
+
+// SyncStatus return the progress of initial sync, and whether the local
+// view is current compared with peers
+func (n Node) SyncStatus() SyncStatus {
+	snapshot := n.SnapshotStatus()
+	var local uint64
+	n.storeLock.RLock()
+	if count, err := db.GetMsgCount(n.udb); err == nil {
+		local = count.Uint64()
+	}
+	n.storeLock.RUnlock()
+	var followed []common.Hash
+	localSeqs := make(map[common.Hash]uint64)
+	if n.universe != nil {
+		n.msgLock.RLock()
+		followed = n.universe.GetTrustedSpaceTimeIDs()
+		for _, id := range followed {
+			localSeqs[id] = n.universe.GetMaxSeq(id)
+		}
+		n.msgLock.RUnlock()
+	}
+	urls := make(map[common.Hash]string)
+	for k, p := range n.copyPeers() {
+		urls[k] = p.Url()
+	}
+
+	s := n.syncer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	status := s.status
+	status.Snapshot = snapshot
+	status.InFlight = len(s.ranges)
+	end := status.EndTime
+	if end.IsZero() {
+		end = now
+	}
+	if elapsed := end.Sub(status.StartTime).Seconds(); !status.StartTime.IsZero() && elapsed > 0 {
+		status.Rate = float64(status.Committed) / elapsed
+	}
+
+	status.LocalCount = local
+	total := status.Target
+	peerSeqs := make(map[common.Hash]uint64)
+	for pid, v := range s.views {
+		if v.total > total {
+			total = v.total
+		}
+		for id, seq := range v.seqs {
+			if seq > peerSeqs[id] {
+				peerSeqs[id] = seq
+			}
+		}
+		if url, ok := urls[pid]; ok && now.Sub(v.downloaded) < syncRateWindow {
+			status.ActivePeers = append(status.ActivePeers, url)
+		}
+	}
+	sort.Strings(status.ActivePeers)
+	if total > local {
+		status.Remaining = total - local
+	}
+	status.Current = len(s.views) > 0 && status.Remaining == 0 && !status.Running
+	for _, id := range followed {
+		st := &SpaceTimeSync{SpaceTimeID: id, LocalSeq: localSeqs[id], PeerSeq: peerSeqs[id]}
+		if st.PeerSeq > st.LocalSeq {
+			status.Current = false
+		}
+		status.SpaceTimes = append(status.SpaceTimes, st)
+	}
+	var downloaded int
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) < syncRateWindow {
+			downloaded += sample.count
+		}
+	}
+	status.DownloadRate = float64(downloaded) / syncRateWindow.Seconds()
+	return status
+}
+
+// view return the view of peer, created if not exist. Should be called
+// with lock of syncer.
+func (s *syncer) view(pid common.Hash) *peerView {
+	v, ok := s.views[pid]
+	if !ok {
+		v = &peerView{seqs: make(map[common.Hash]uint64)}
+		s.views[pid] = v
+	}
+	return v
+}
+
+// downloaded record the msgs answered by peer with its msg count, the
+// total is 0 if not answered by peer. Should be called with lock of syncer.
+func (s *syncer) downloaded(pid common.Hash, count int, total uint64) {
+	now := time.Now()
+	v := s.view(pid)
+	if total > 0 {
+		v.total = total
+	}
+	if count == 0 {
+		return
+	}
+	v.downloaded = now
+	for len(s.samples) > 0 && now.Sub(s.samples[0].at) >= syncRateWindow {
+		s.samples = s.samples[1:]
+	}
+	s.samples = append(s.samples, rateSample{at: now, count: count})
+}
+
+// observeMsgs record the msgs answered by peer out of initial sync
+func (s *syncer) observeMsgs(pid common.Hash, count int, total uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.downloaded(pid, count, total)
+}
+
+// observeSeq record the highest sequence of space-time in the checkpoints
+// answered by peer
+func (s *syncer) observeSeq(pid, spaceTimeID common.Hash, seq uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v := s.view(pid); seq > v.seqs[spaceTimeID] {
+		v.seqs[spaceTimeID] = seq
+	}
+}
+
+func (s *syncer) running() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.status.Running
+}
+
+func (s *syncer) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *syncer) stop() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+// syncFromPeer add the connected peer into initial sync, the sync start
+// from the msg count of local db when the first peer added, and peers can
+// join until the sync done
+func (n *Node) syncFromPeer(pid common.Hash) error {
+	// replica follow the msgs of leader in order instead
+	if n.cluster.replica() {
+		return nil
+	}
+	s := n.syncer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.status.Done {
+		return nil
+	}
+	if !s.status.Running {
+		count, err := db.GetMsgCount(n.udb)
+		if err != nil {
+			return err
+		}
+		s.status.Running = true
+		s.status.StartTime = time.Now()
+		s.status.Requested = count.Uint64()
+		log.Info("Start initial sync from msg", s.status.Requested)
+		go n.runSync()
+	}
+	if _, ok := s.peers[pid]; !ok {
+		s.peers[pid] = &syncPeer{}
+		s.status.Peers = len(s.peers)
+	}
+	n.askRanges()
+	return nil
+}
+
+// askRanges ask ranges from each peer until the window of peer is full,
+// the fast peers are asked first, and the peers much slower than others
+// are not asked unless only they have the msgs, they are kept for gossip.
+// Should be called with lock of syncer.
+func (n *Node) askRanges() {
+	s := n.syncer
+	var pids []common.Hash
+	for pid := range s.peers {
+		pids = append(pids, pid)
+	}
+	ranked, fast := n.stats.rank(pids)
+	// slow peers are still asked if they have more msgs than fast peers
+	var covered uint64
+	for _, pid := range pids {
+		if sp := s.peers[pid]; fast[pid] && !sp.failed {
+			if !sp.known {
+				covered = ^uint64(0)
+			} else if sp.total > covered {
+				covered = sp.total
+			}
+		}
+	}
+	for _, pid := range ranked {
+		sp := s.peers[pid]
+		if !fast[pid] && (!sp.known || sp.total <= covered) {
+			continue
+		}
+		for !sp.failed && sp.inFlight < syncWindow {
+			r := s.nextRange(pid, sp)
+			if r == nil {
+				break
+			}
+			if err := n.askRange(r); err != nil {
+				s.retry = append(s.retry, r)
+				if err != peer.ErrPeerBusy {
+					log.Error("Ask msg range fail", common.Hash2String(pid), err)
+					sp.failed = true
+				}
+				break
+			}
+			sp.inFlight++
+		}
+	}
+}
+
+// nextRange return the range asked from peer next, the ranges not answered
+// by others go first. Only one range is asked before the peer answer its
+// msg count.
+func (s *syncer) nextRange(pid common.Hash, sp *syncPeer) *syncRange {
+	for i, r := range s.retry {
+		if !sp.known || sp.total > r.from {
+			s.retry = append(s.retry[:i], s.retry[i+1:]...)
+			r.pid = pid
+			return r
+		}
+	}
+	if (sp.known && sp.total <= s.status.Requested) || (!sp.known && sp.inFlight > 0) {
+		return nil
+	}
+	count := uint64(peer.MaxSyncMsgCountPerWave)
+	if sp.known && sp.total-s.status.Requested < count {
+		count = sp.total - s.status.Requested
+	}
+	r := &syncRange{pid: pid, from: s.status.Requested, count: count}
+	s.status.Requested += count
+	return r
+}
+
+func (n *Node) askRange(r *syncRange) error {
+	p, err := n.getPeer(r.pid)
+	if err != nil {
+		return err
+	}
+	waveID := common.CreateHash()
+	if err := p.AskMsgRange(waveID, r.from, r.count); err != nil {
+		return err
+	}
+	r.asked = time.Now()
+	n.syncer.ranges[waveID] = r
+	return nil
+}
+
+// dropRetry drop the ranges which no peer can answer, the ranges are kept
+// if no peer in sync, should be called with lock of syncer
+func (s *syncer) dropRetry() {
+	if len(s.peers) == 0 {
+		return
+	}
+	var retry []*syncRange
+	for _, r := range s.retry {
+		for _, sp := range s.peers {
+			if !sp.failed && (!sp.known || sp.total > r.from) {
+				retry = append(retry, r)
+				break
+			}
+		}
+	}
+	s.retry = retry
+}
+
+// syncAnswer take the msgs answered to the question of msg range, and ask
+// more ranges from peers. Return false if wave is not asked by sync.
+func (n *Node) syncAnswer(wm *galaxy.WaveMessages) bool {
+	s := n.syncer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.ranges[wm.WaveID]
+	if !ok {
+		return false
+	}
+	delete(s.ranges, wm.WaveID)
+	if sp, ok := s.peers[r.pid]; ok {
+		sp.inFlight--
+		sp.total, sp.known = wm.Total, true
+	}
+	n.stats.observeRange(r.pid, len(wm.Msgs), time.Since(r.asked))
+	s.downloaded(r.pid, len(wm.Msgs), wm.Total)
+	if wm.Total > s.status.Target {
+		s.status.Target = wm.Total
+	}
+	msgs := wm.Msgs
+	if uint64(len(msgs)) > r.count {
+		msgs = msgs[:r.count]
+	}
+	if received := uint64(len(msgs)); received < r.count {
+		s.retry = append(s.retry, &syncRange{from: r.from + received, count: r.count - received})
+	}
+	if len(msgs) > 0 {
+		s.answers = append(s.answers, msgs)
+		s.status.Downloaded += uint64(len(msgs))
+	}
+	s.dropRetry()
+	n.askRanges()
+	s.signal()
+	return true
+}
+
+// checkSyncRanges ask the ranges not answered in time from other peers,
+// the peer not answer is not asked again
+func (n *Node) checkSyncRanges() {
+	s := n.syncer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.status.Running {
+		return
+	}
+	for waveID, r := range s.ranges {
+		if time.Since(r.asked) < syncRangeTimeout {
+			continue
+		}
+		delete(s.ranges, waveID)
+		if sp, ok := s.peers[r.pid]; ok {
+			sp.inFlight--
+			sp.failed = true
+		}
+		n.stats.observeTimeout(r.pid)
+		s.retry = append(s.retry, r)
+	}
+	s.dropRetry()
+	n.askRanges()
+	s.signal()
+}
+
+// dropSyncPeer remove the peer from initial sync, the ranges asked from it
+// are asked from other peers, and the peer join again if connected later
+func (n *Node) dropSyncPeer(pid common.Hash) {
+	s := n.syncer
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.views, pid)
+	if _, ok := s.peers[pid]; !ok || !s.status.Running {
+		return
+	}
+	delete(s.peers, pid)
+	s.status.Peers = len(s.peers)
+	for waveID, r := range s.ranges {
+		if r.pid == pid {
+			delete(s.ranges, waveID)
+			s.retry = append(s.retry, r)
+		}
+	}
+	s.dropRetry()
+	n.askRanges()
+	s.signal()
+}
+
+// finished return true if all ranges be answered and handled, or no peer
+// can be asked. The sync wait for peers to join again if all peers are
+// removed. Should be called with lock of syncer.
+func (s *syncer) finished() bool {
+	if len(s.peers) == 0 || len(s.ranges) > 0 || len(s.answers) > 0 {
+		return false
+	}
+	for _, sp := range s.peers {
+		if !sp.failed && (!sp.known || sp.total > s.status.Requested) {
+			return false
+		}
+	}
+	return len(s.retry) == 0
+}
+
+// runSync is the worker of syncer, handle the answered msgs one range by
+// one range until sync finished or node stopped
+func (n *Node) runSync() {
+	s := n.syncer
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.wake:
+		}
+		for {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			s.lock.Lock()
+			if len(s.answers) == 0 {
+				done := s.finished()
+				if done {
+					s.status.Running, s.status.Done = false, true
+					s.status.EndTime = time.Now()
+					s.status.Pending = len(s.queued)
+					s.retry = nil
+				}
+				s.lock.Unlock()
+				if done {
+					// msgs still waiting are left to the sync by last msg
+					s.waiting, s.queued = nil, nil
+					status := n.SyncStatus()
+					log.Info("Initial sync done, committed", status.Committed, "rejected", status.Rejected, "pending", status.Pending, "rate", int(status.Rate), "msgs/s")
+					return
+				}
+				break
+			}
+			msgsB := s.answers[0]
+			s.answers = s.answers[1:]
+			s.lock.Unlock()
+			n.syncMsgs(msgsB)
+		}
+	}
+}
+
+// syncMsgs verify the msgs in parallel, and commit them in topological
+// order, the msg wait until all msgs it refer are committed. Only the commits
+// hold the write lock of universe, the msgs are verified before by worker
+// pool under the read lock, so the readers and the msgs gossiped from peers
+// are not blocked by the signature verify of whole range.
+func (n *Node) syncMsgs(msgsB [][]byte) {
+	s := n.syncer
+	var committed, rejected uint64
+	var msgs []*core.Message
+	for _, msgBytes := range msgsB {
+		var msg core.Message
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			rejected++
+			continue
+		}
+		msgs = append(msgs, &msg)
+	}
+	items, failed, duplicated := n.verifySynced(msgs)
+
+	n.msgLock.Lock()
+	if n.universe == nil {
+		n.msgLock.Unlock()
+		return
+	}
+	for _, item := range failed {
+		n.rejectMsg(nil, common.Hash{}, item.msg, item.err)
+		rejected++
+	}
+	// the msgs referred may be committed by broadcast instead of sync
+	for ref, waiting := range s.waiting {
+		if n.universe.HasMsg(ref) {
+			delete(s.waiting, ref)
+			items = append(items, waiting...)
+		}
+	}
+	for _, item := range items {
+		c, r := n.commitSyncItem(item)
+		committed, rejected = committed+c, rejected+r
+	}
+	n.msgLock.Unlock()
+
+	s.lock.Lock()
+	s.status.Committed += committed
+	s.status.Duplicated += duplicated
+	s.status.Rejected += rejected
+	s.status.Pending = len(s.queued)
+	s.lock.Unlock()
+}
+
+// verifySynced verify the msgs not committed or queued by worker pool under
+// the read lock of universe, the msgs failed are returned with their errors
+// and rejected later under the write lock.
+func (n *Node) verifySynced(msgs []*core.Message) (items, failed []*syncItem, duplicated uint64) {
+	s := n.syncer
+	n.msgLock.RLock()
+	defer n.msgLock.RUnlock()
+	if n.universe == nil {
+		return nil, nil, 0
+	}
+	n.sealMsgs(msgs...)
+	var fresh []*core.Message
+	for _, msg := range msgs {
+		if n.universe.HasMsg(msg.ID()) || s.queued[msg.ID()] {
+			duplicated++
+			continue
+		}
+		fresh = append(fresh, msg)
+	}
+	receipts, errs := n.validateMsgs(fresh)
+	for i, msg := range fresh {
+		if errs[i] != nil && errs[i] != core.ErrUserNotExist {
+			failed = append(failed, &syncItem{msg: msg, err: errs[i]})
+			continue
+		}
+		items = append(items, &syncItem{msg: msg, receipt: receipts[i]})
+	}
+	return items, failed, duplicated
+}
+
+// commitSyncItem commit the msg if all msgs it refer are committed, and
+// then the msgs waiting for it. The count of committed and rejected msgs
+// are returned.
+func (n *Node) commitSyncItem(item *syncItem) (committed, rejected uint64) {
+	s := n.syncer
+	queue := []*syncItem{item}
+	for len(queue) > 0 {
+		item, queue = queue[0], queue[1:]
+		msgID := item.msg.ID()
+		if missing, ok := n.missingRef(item.msg); ok {
+			s.waiting[missing] = append(s.waiting[missing], item)
+			s.queued[msgID] = true
+			continue
+		}
+		delete(s.queued, msgID)
+		if !n.universe.HasMsg(msgID) {
+			if err := n.commitSynced(item); err != nil {
+				n.rejectMsg(nil, common.Hash{}, item.msg, err)
+				rejected++
+				continue
+			}
+			committed++
+		}
+		queue = append(queue, s.waiting[msgID]...)
+		delete(s.waiting, msgID)
+	}
+	return committed, rejected
+}
+
+// missingRef return the first msg referred by msg not in universe
+func (n *Node) missingRef(msg *core.Message) (common.Hash, bool) {
+	for _, ref := range msg.Reference {
+		if !n.universe.HasMsg(ref.MsgID) {
+			return ref.MsgID, true
+		}
+	}
+	return common.Hash{}, false
+}
+
+// commitSynced commit the msg, verify it again if the sender not exist
+// when verified by worker pool. The msg is scored by filter same as the
+// msgs gossiped from peers.
+func (n *Node) commitSynced(item *syncItem) error {
+	receipt := item.receipt
+	if receipt == nil {
+		var err error
+		if receipt, err = n.universe.Validate(item.msg); err != nil {
+			return err
+		}
+	}
+	verdict := n.filterer.check(item.msg, n.labeler)
+	if verdict == filter.Reject {
+		return core.ErrMsgFiltered
+	}
+	if err := n.commitMsg(receipt, originOutbound); err != nil {
+		return err
+	}
+	if verdict == filter.Flag {
+		n.filterer.flag(item.msg.ID())
+	}
+	return nil
+}
+
+// syncHandler return the progress of initial sync
+func (n Node) syncHandler(w http.ResponseWriter, r *http.Request) {
+	statusBytes, err := json.Marshal(n.SyncStatus())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(statusBytes)
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db/memdb"
+)
+
+// syncBatchSize is the msgs in one answered range of benchmark
+const syncBatchSize = 100
+
+// BenchmarkNode_SyncMsgs measure the throughput of initial sync, the msgs
+// answered are verified by worker pool and committed one by one into
+// universe and udb. The target is 5k msgs/s.
+func BenchmarkNode_SyncMsgs(b *testing.B) {
+	defer log.SetLevel(log.Level())
+	log.SetLevel(log.LvlWarn)
+	udb := memdb.New()
+	roots, keys := initTestUDB(b, udb, common.HasherSHA256)
+	n, err := New(udb)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var batches [][][]byte
+	var last *core.MsgReference
+	for i := 0; i < b.N; i++ {
+		if i%syncBatchSize == 0 {
+			batches = append(batches, nil)
+		}
+		value := &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("msg %d", i))}
+		var refs []*core.MsgReference
+		if last != nil {
+			refs = append(refs, last)
+		}
+		msg, err := core.CreateMsgOnNetwork(n.network, roots[0], value, keys[0], 0, refs...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		msgBytes, err := json.Marshal(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], msgBytes)
+		last = &core.MsgReference{SenderID: msg.SenderID, MsgID: msg.ID()}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for _, msgsB := range batches {
+		n.syncMsgs(msgsB)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+	b.StopTimer()
+	if status := n.SyncStatus(); status.Committed != uint64(b.N) {
+		b.Fatalf("committed msgs should be %d, but get %d (rejected %d)", b.N, status.Committed, status.Rejected)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+)
+
+const (
+	// DefaultTimeProofAlert is the number of consecutive time proof msgs
+	// failed to publish before alert
+	DefaultTimeProofAlert = 3
+
+	// maxTimeProofRefs is the max number of tips referenced by time proof msg
+	maxTimeProofRefs = 8
+)
+
+// TimeProofStats is the result of time proof msgs published by scheduler.
+// Alert is set once Failures reach the threshold, and cleared after the
+// next time proof msg published.
+type TimeProofStats struct {
+	Enabled   bool          `json:"enabled"`
+	Interval  time.Duration `json:"interval"`
+	Jitter    time.Duration `json:"jitter"`
+	Published uint64        `json:"published"`
+	Failed    uint64        `json:"failed"`
+	Failures  int           `json:"failures"` // consecutive failures since last published
+	Alert     bool          `json:"alert"`
+	LastMsgID common.Hash   `json:"lastMsgID"`
+	LastTime  time.Time     `json:"lastTime"`
+	LastError string        `json:"lastError,omitempty"`
+	NextTime  time.Time     `json:"nextTime"`
+}
+
+// tpScheduler decide when the time proof msgs are published, the interval
+// is randomized by jitter so the sequences of space-times advance steadily
+// without all nodes posting at same time
+type tpScheduler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	jitter   time.Duration
+	alert    int
+	stats    TimeProofStats
+}
+
+func newTPScheduler() *tpScheduler {
+	return &tpScheduler{interval: DefaultTimeProofInterval * time.Second, alert: DefaultTimeProofAlert}
+}
+
+// SetTimeProofSchedule set the cadence of time proof msgs, each one is
+// published after interval plus or minus a random duration in jitter. The
+// alert is raised after alert failures in a row, never if 0. The interval
+// set by EnableTP is kept if interval is 0. Should be set before Run.
+func (n *Node) SetTimeProofSchedule(interval, jitter time.Duration, alert int) {
+	s := n.tpScheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if interval > 0 {
+		s.interval = interval
+	}
+	if jitter > s.interval {
+		jitter = s.interval
+	}
+	s.jitter = jitter
+	s.alert = alert
+}
+
+// TimeProofStats return the result of time proof msgs published
+func (n Node) TimeProofStats() TimeProofStats {
+	s := n.tpScheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Enabled = n.tpEnable
+	stats.Interval, stats.Jitter = s.interval, s.jitter
+	return stats
+}
+
+// next return the duration before the next time proof msg
+func (s *tpScheduler) next() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.interval
+	if s.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*s.jitter)+1)) - s.jitter
+	}
+	s.stats.NextTime = time.Now().Add(d)
+	return d
+}
+
+// done record the result of time proof msg, log the alert once the
+// failures reach the threshold
+func (s *tpScheduler) done(msg *core.Message, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.stats.Failed++
+		s.stats.Failures++
+		s.stats.LastError = err.Error()
+		if s.alert > 0 && s.stats.Failures == s.alert {
+			s.stats.Alert = true
+			log.Warn("Time proof not published for", s.stats.Failures, "times, sequence of space-time stalled", err)
+		}
+		return
+	}
+	if s.stats.Alert {
+		log.Info("Time proof published again after", s.stats.Failures, "failures")
+	}
+	s.stats.Published++
+	s.stats.Failures = 0
+	s.stats.Alert = false
+	s.stats.LastError = ""
+	s.stats.LastMsgID = msg.ID()
+	s.stats.LastTime = time.Now()
+}
+
+// runTimeProof publish the time proof msgs by schedule until stopped
+func (n *Node) runTimeProof(sig <-chan struct{}, wait chan<- struct{}) {
+	for {
+		select {
+		case <-sig:
+			log.Info("Stop time proof server")
+			close(wait)
+			return
+		case <-time.After(n.tpScheduler.next()):
+			msg, err := n.publishTimeProof()
+			n.tpScheduler.done(msg, err)
+			if err != nil {
+				log.Error("Publish time proof fail", err)
+				continue
+			}
+			log.Info("A new message", common.Hash2String(msg.ID()), "just be created and broadcast")
+		}
+	}
+}
+
+// publishTimeProof create the heartbeat msg of time proof user referencing
+// the latest tips of universe, save and broadcast it
+func (n Node) publishTimeProof() (*core.Message, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	userID := n.tpUnlockedUser.ID()
+	n.postLock.Lock()
+	defer n.postLock.Unlock()
+	n.msgLock.Lock()
+	recommended := n.universe.RecommendRefs(userID, maxTimeProofRefs)
+	seq := n.universe.GetMaxSeq(userID) + 1
+	value := &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("heartbeat %d", seq))}
+	difficulty := n.universe.Config().Difficulty(value.ContentType)
+	n.msgLock.Unlock()
+
+	var refs []*core.MsgReference
+	for i := range recommended {
+		refs = append(refs, &recommended[i])
+	}
+	if len(refs) == 0 {
+		var err error
+		if refs, err = n.freshRefs(userID); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := core.CreateMsgOnNetwork(n.network, n.tpUnlockedUser, value, n.tpUnlockedPrivateKey, difficulty, refs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.saveMsg(msg); err != nil {
+		return nil, err
+	}
+	if err := n.broadcastMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
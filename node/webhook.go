@@ -0,0 +1,252 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+)
+
+const (
+	// DefaultWebhookRetry is the max number of retries to POST one msg
+	DefaultWebhookRetry = 5
+
+	webhookQueueSize    = 1024
+	webhookTimeout      = 10 * time.Second
+	webhookBackoffStart = time.Second
+	webhookBackoffMax   = time.Minute
+)
+
+var (
+	errWebhookURLMissing = errors.New("webhook url missing")
+	errWebhookQueueFull  = errors.New("webhook queue full")
+)
+
+// Webhook is the url which the accepted msgs matched filter are POST to.
+// Msg match if sender in Senders, content type in ContentTypes and content
+// contains any of Keywords, the empty filter match all.
+type Webhook struct {
+	URL          string        `json:"url"`
+	Senders      []common.Hash `json:"senders,omitempty"`
+	ContentTypes []int         `json:"contentTypes,omitempty"`
+	Keywords     []string      `json:"keywords,omitempty"`
+}
+
+// WebhookPayload is the body POST to webhook
+type WebhookPayload struct {
+	MsgID common.Hash   `json:"msgID"`
+	Msg   *core.Message `json:"msg"`
+}
+
+// Match return true if msg match all filters of webhook
+func (wh Webhook) Match(msg *core.Message) bool {
+	if len(wh.Senders) > 0 {
+		found := false
+		for _, s := range wh.Senders {
+			if s == msg.SenderID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(wh.ContentTypes) > 0 {
+		found := false
+		for _, t := range wh.ContentTypes {
+			if t == msg.Value.ContentType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(wh.Keywords) > 0 {
+		content := strings.ToLower(string(msg.Value.Content))
+		for _, k := range wh.Keywords {
+			if strings.Contains(content, strings.ToLower(k)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// webhookWorker POST the msgs to one webhook in order, each webhook has its
+// own queue, so a dead webhook only delays and drops its own msgs.
+type webhookWorker struct {
+	hook *Webhook
+	jobs chan []byte
+}
+
+// webhookDispatcher POST the msgs to webhooks in background, retry with
+// exponential backoff if POST fail, so slow webhooks never block the node.
+// The workers are stopped when the context of start is done.
+type webhookDispatcher struct {
+	workers      []*webhookWorker
+	client       *http.Client
+	queueSize    int
+	retry        int
+	backoffStart time.Duration
+	wg           sync.WaitGroup
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		client:       &http.Client{Timeout: webhookTimeout},
+		queueSize:    webhookQueueSize,
+		retry:        DefaultWebhookRetry,
+		backoffStart: webhookBackoffStart,
+	}
+}
+
+// add the webhook, should be called before start
+func (d *webhookDispatcher) add(hook *Webhook) {
+	d.workers = append(d.workers, &webhookWorker{hook: hook, jobs: make(chan []byte, d.queueSize)})
+}
+
+// start the worker of each webhook, the msgs queued before are POST then
+func (d *webhookDispatcher) start(ctx context.Context) {
+	for _, w := range d.workers {
+		d.wg.Add(1)
+		go d.work(ctx, w)
+	}
+}
+
+// wait until all workers stopped
+func (d *webhookDispatcher) wait() {
+	d.wg.Wait()
+}
+
+// notify queue the msg for all webhooks it matched, errWebhookQueueFull is
+// returned if the msg is dropped by any webhook
+func (d *webhookDispatcher) notify(msg *core.Message) error {
+	var payload []byte
+	var dropped error
+	for _, w := range d.workers {
+		if !w.hook.Match(msg) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(&WebhookPayload{MsgID: msg.ID(), Msg: msg}); err != nil {
+				return err
+			}
+		}
+		select {
+		case w.jobs <- payload:
+		default:
+			dropped = errWebhookQueueFull
+		}
+	}
+	return dropped
+}
+
+func (d *webhookDispatcher) work(ctx context.Context, w *webhookWorker) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-w.jobs:
+			d.deliver(ctx, w.hook, payload)
+		}
+	}
+}
+
+// deliver POST the payload to webhook, retry until success, d.retry times
+// failed or ctx done
+func (d *webhookDispatcher) deliver(ctx context.Context, hook *Webhook, payload []byte) {
+	backoff := d.backoffStart
+	for i := 0; ; i++ {
+		err := d.post(ctx, hook, payload)
+		if err == nil {
+			return
+		}
+		if i >= d.retry {
+			log.Error("Webhook", hook.URL, "fail", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > webhookBackoffMax {
+			backoff = webhookBackoffMax
+		}
+	}
+}
+
+func (d *webhookDispatcher) post(ctx context.Context, hook *Webhook, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook response %s", resp.Status)
+	}
+	return nil
+}
+
+// AddWebhook add webhook, the msgs accepted after that and matched filters
+// of webhook will be POST to it in json (WebhookPayload). Webhooks should be
+// added before node run.
+func (n *Node) AddWebhook(hook *Webhook) error {
+	if hook.URL == "" {
+		return errWebhookURLMissing
+	}
+	if n.webhooks == nil {
+		n.webhooks = newWebhookDispatcher()
+	}
+	n.webhooks.add(hook)
+	return nil
+}
+
+// notifyWebhooks queue the accepted msg to webhooks
+func (n Node) notifyWebhooks(msg *core.Message) {
+	if n.webhooks == nil {
+		return
+	}
+	if err := n.webhooks.notify(msg); err != nil {
+		log.Error("Notify webhooks fail", err)
+	}
+}
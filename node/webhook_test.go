@@ -0,0 +1,158 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+func newWebhookTestMsg(t *testing.T) *core.Message {
+	engine, err := utils.SelectEngine(crypto.ETH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.CreateRootUser(*pubKey, "name", "extra")
+	msg, err := core.CreateMsg(user, &core.MsgValue{ContentType: core.TypeText, Content: []byte("hello")}, priKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// newWebhookTestServer return the server reply status by the count of
+// requests received, and the channel of msg ID received with status 200
+func newWebhookTestServer(status func(cnt int32) int) (*httptest.Server, <-chan WebhookPayload) {
+	var cnt int32
+	received := make(chan WebhookPayload, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := status(atomic.AddInt32(&cnt, 1))
+		if code == http.StatusOK {
+			var payload WebhookPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+				received <- payload
+			}
+		}
+		w.WriteHeader(code)
+	}))
+	return srv, received
+}
+
+func TestWebhook_Deliver(t *testing.T) {
+	dead, _ := newWebhookTestServer(func(int32) int { return http.StatusInternalServerError })
+	defer dead.Close()
+	alive, received := newWebhookTestServer(func(int32) int { return http.StatusOK })
+	defer alive.Close()
+
+	d := newWebhookDispatcher()
+	d.backoffStart = time.Hour
+	d.add(&Webhook{URL: dead.URL})
+	d.add(&Webhook{URL: alive.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	d.start(ctx)
+
+	msg := newWebhookTestMsg(t)
+	if err := d.notify(msg); err != nil {
+		t.Fatal("notify fail", err)
+	}
+	// the dead webhook is waiting to retry, should not block the other one
+	select {
+	case payload := <-received:
+		if payload.MsgID != msg.ID() {
+			t.Error("msg ID not match")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("msg should be delivered to alive webhook")
+	}
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		d.wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("workers should stop after context done")
+	}
+}
+
+func TestWebhook_Retry(t *testing.T) {
+	srv, received := newWebhookTestServer(func(cnt int32) int {
+		if cnt <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	defer srv.Close()
+
+	d := newWebhookDispatcher()
+	d.backoffStart = time.Millisecond
+	d.add(&Webhook{URL: srv.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.start(ctx)
+
+	msg := newWebhookTestMsg(t)
+	if err := d.notify(msg); err != nil {
+		t.Fatal("notify fail", err)
+	}
+	select {
+	case payload := <-received:
+		if payload.MsgID != msg.ID() {
+			t.Error("msg ID not match")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("msg should be delivered after retry")
+	}
+}
+
+func TestWebhook_Drop(t *testing.T) {
+	d := newWebhookDispatcher()
+	d.queueSize = 1
+	d.add(&Webhook{URL: "http://127.0.0.1:0"})
+	d.add(&Webhook{URL: "http://127.0.0.1:0", ContentTypes: []int{core.TypeBirth}})
+	d.queueSize = 2
+	d.add(&Webhook{URL: "http://127.0.0.1:0"})
+
+	msg := newWebhookTestMsg(t)
+	if err := d.notify(msg); err != nil {
+		t.Fatal("notify fail", err)
+	}
+	// the first webhook is full, the msg is still queued for the last one
+	if err := d.notify(msg); err != errWebhookQueueFull {
+		t.Errorf("err should be %s, but get %v", errWebhookQueueFull, err)
+	}
+	for i, cnt := range []int{1, 0, 2} {
+		if len(d.workers[i].jobs) != cnt {
+			t.Errorf("webhook %d should have %d msgs queued, but get %d", i, cnt, len(d.workers[i].jobs))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+var errFaultDisconnect = common.NewError(common.ErrCodePeer+6, "conn closed by fault injected")
+
+// Faults is the faults injected into the waves written to peers, the zero
+// value inject nothing. It is only used to verify the sync and gossip of
+// nodes converge under adverse network, such as by simnet.
+type Faults struct {
+	Drop       float64       `json:"drop"`       // probability of wave dropped silently
+	Duplicate  float64       `json:"duplicate"`  // probability of wave written twice
+	Disconnect float64       `json:"disconnect"` // probability of conn closed instead of wave written
+	Delay      time.Duration `json:"delay"`      // min delay before wave written
+	Jitter     time.Duration `json:"jitter"`     // max delay added to Delay, uniform distributed
+}
+
+// FaultStats is the count of waves affected by faults
+type FaultStats struct {
+	Dropped      uint64 `json:"dropped"`
+	Duplicated   uint64 `json:"duplicated"`
+	Disconnected uint64 `json:"disconnected"`
+	Delayed      uint64 `json:"delayed"`
+}
+
+// FaultInjector inject the faults into the writers of peers, the faults
+// can be changed while the conns are open, so network can be healed
+type FaultInjector struct {
+	mu     sync.Mutex
+	faults Faults
+	rand   *rand.Rand
+	stats  FaultStats
+}
+
+// NewFaultInjector create the injector without faults, the seed is used to
+// decide which waves affected
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Set the faults injected into the waves written later
+func (fi *FaultInjector) Set(f Faults) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults = f
+}
+
+// Stats return the count of waves affected by faults
+func (fi *FaultInjector) Stats() FaultStats {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.stats
+}
+
+// Writer return the writer inject faults into the waves written to w, c is
+// closed when disconnect injected, SendWave write one whole wave at once
+func (fi *FaultInjector) Writer(w io.Writer, c io.Closer) io.Writer {
+	return &faultWriter{w: w, c: c, fi: fi}
+}
+
+// fault is the faults decided for one wave
+type fault struct {
+	drop       bool
+	duplicate  bool
+	disconnect bool
+	delay      time.Duration
+}
+
+func (fi *FaultInjector) next() fault {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var f fault
+	if fi.faults.Disconnect > 0 && fi.rand.Float64() < fi.faults.Disconnect {
+		f.disconnect = true
+		fi.stats.Disconnected++
+		return f
+	}
+	if fi.faults.Drop > 0 && fi.rand.Float64() < fi.faults.Drop {
+		f.drop = true
+		fi.stats.Dropped++
+		return f
+	}
+	if fi.faults.Duplicate > 0 && fi.rand.Float64() < fi.faults.Duplicate {
+		f.duplicate = true
+		fi.stats.Duplicated++
+	}
+	f.delay = fi.faults.Delay
+	if fi.faults.Jitter > 0 {
+		f.delay += time.Duration(fi.rand.Int63n(int64(fi.faults.Jitter) + 1))
+	}
+	if f.delay > 0 {
+		fi.stats.Delayed++
+	}
+	return f
+}
+
+type faultWriter struct {
+	w  io.Writer
+	c  io.Closer
+	fi *FaultInjector
+}
+
+// Write the wave with the faults decided by injector, the dropped wave is
+// reported as written
+func (fw *faultWriter) Write(p []byte) (int, error) {
+	f := fw.fi.next()
+	if f.disconnect {
+		fw.Close()
+		return 0, errFaultDisconnect
+	}
+	if f.drop {
+		return len(p), nil
+	}
+	time.Sleep(f.delay)
+	if f.duplicate {
+		if _, err := fw.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return fw.w.Write(p)
+}
+
+// Close the conn if closable, so writer of outbox can close the conn
+func (fw *faultWriter) Close() error {
+	if fw.c != nil {
+		return fw.c.Close()
+	}
+	return nil
+}
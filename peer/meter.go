@@ -0,0 +1,211 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateWindow is the seconds of the rolling window the rates are measured in
+const RateWindow = 10
+
+// MeterStats is the bytes sent and received, and the rates (bytes per
+// second) of the last RateWindow seconds
+type MeterStats struct {
+	Sent     uint64  `json:"sent"`
+	Received uint64  `json:"received"`
+	SendRate float64 `json:"sendRate"`
+	RecvRate float64 `json:"recvRate"`
+}
+
+// counter count the bytes in total and in buckets of each second
+type counter struct {
+	total   uint64
+	mu      sync.Mutex
+	buckets [RateWindow]uint64
+	seconds [RateWindow]int64
+}
+
+func (c *counter) add(n int, now time.Time) {
+	atomic.AddUint64(&c.total, uint64(n))
+	sec := now.Unix()
+	i := sec % RateWindow
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seconds[i] != sec {
+		c.seconds[i], c.buckets[i] = sec, 0
+	}
+	c.buckets[i] += uint64(n)
+}
+
+func (c *counter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sum uint64
+	for i, s := range c.seconds {
+		if sec-s < RateWindow {
+			sum += c.buckets[i]
+		}
+	}
+	return float64(sum) / RateWindow
+}
+
+// Limiter is the token bucket of bytes, the burst is the bytes of one
+// second. The wave larger than burst is allowed, and following waves wait
+// until the debt is paid, so bulk waves are never rejected.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter create the limiter of bytes per second, nil if rate is 0
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// Wait block until n bytes are allowed
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// Meter count and limit the bytes sent to and received from peer, the
+// bytes are counted and limited by parent as well, such as the meter of
+// all peers.
+type Meter struct {
+	parent    *Meter
+	sent      counter
+	received  counter
+	sendLimit *Limiter
+	recvLimit *Limiter
+	tap       *Wiretap
+	tapPeer   string
+}
+
+// NewMeter create the meter with the limits of bytes per second, 0 means
+// no limit
+func NewMeter(parent *Meter, sendRate, recvRate int64) *Meter {
+	return &Meter{parent: parent, sendLimit: NewLimiter(sendRate), recvLimit: NewLimiter(recvRate)}
+}
+
+func (m *Meter) waitSend(n int) {
+	for ; m != nil; m = m.parent {
+		m.sendLimit.Wait(n)
+		m.sent.add(n, time.Now())
+	}
+}
+
+func (m *Meter) waitRecv(n int) {
+	for ; m != nil; m = m.parent {
+		m.recvLimit.Wait(n)
+		m.received.add(n, time.Now())
+	}
+}
+
+// SetWiretap record the waves sent and received by meter as of peer,
+// should be set before Reader and Writer
+func (m *Meter) SetWiretap(t *Wiretap, peer string) {
+	m.tap, m.tapPeer = t, peer
+}
+
+// Stats return the bytes and rates of meter
+func (m *Meter) Stats() MeterStats {
+	now := time.Now()
+	return MeterStats{
+		Sent:     atomic.LoadUint64(&m.sent.total),
+		Received: atomic.LoadUint64(&m.received.total),
+		SendRate: m.sent.rate(now),
+		RecvRate: m.received.rate(now),
+	}
+}
+
+// Writer return the writer wait for the send limits before write to w
+func (m *Meter) Writer(w io.Writer) io.Writer {
+	return &meteredWriter{w: w, m: m}
+}
+
+// Reader return the reader read from r, and wait for the receive limits
+// after read, so the peer is slowed down by the conn not read
+func (m *Meter) Reader(r io.Reader) io.Reader {
+	return &meteredReader{r: r, m: m}
+}
+
+type meteredWriter struct {
+	w io.Writer
+	m *Meter
+}
+
+// Write the bytes of wave, SendWave write one whole wave at once
+func (mw *meteredWriter) Write(p []byte) (int, error) {
+	mw.m.waitSend(len(p))
+	n, err := mw.w.Write(p)
+	if mw.m.tap != nil && n > 0 {
+		mw.m.tap.Record(WiretapSent, mw.m.tapPeer, append([]byte{}, p[:n]...))
+	}
+	return n, err
+}
+
+// Close the writer if closable, so writer of outbox can close the conn
+func (mw *meteredWriter) Close() error {
+	if c, ok := mw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type meteredReader struct {
+	r     io.Reader
+	m     *Meter
+	waves waveSplitter
+}
+
+func (mr *meteredReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	if n > 0 {
+		mr.m.waitRecv(n)
+		if mr.m.tap != nil {
+			for _, wave := range mr.waves.write(p[:n]) {
+				mr.m.tap.Record(WiretapReceived, mr.m.tapPeer, wave)
+			}
+		}
+	}
+	return n, err
+}
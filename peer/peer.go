@@ -17,11 +17,13 @@
 package peer
 
 import (
-	"crypto/sha256"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"net"
+	"sync"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/core"
@@ -30,24 +32,223 @@ import (
 )
 
 var (
-	errPeerNotReachable = errors.New("this peer not reachable right now")
-	errArgsNotSupport   = errors.New("arguments not support")
-	errMsgsNeedSplit    = errors.New("messages need split into waves")
+	// ErrPeerBusy is returned when the outbound queue of peer is full, the
+	// wave is dropped and caller should retry later or skip this peer
+	ErrPeerBusy = common.NewError(common.ErrCodePeer+1, "outbound queue of peer is full")
+
+	errPeerNotReachable = common.NewError(common.ErrCodePeer+2, "this peer not reachable right now")
+	errPeerClosed       = common.NewError(common.ErrCodePeer+3, "peer already closed")
+	errArgsNotSupport   = common.NewError(common.ErrCodePeer+4, "arguments not support")
+	errMsgsNeedSplit    = common.NewError(common.ErrCodePeer+5, "messages need split into waves")
 )
 
 const (
 	// MaxMsgCountPerWave is the max number of msg per wave
 	MaxMsgCountPerWave = 2
+
+	// MaxSyncMsgCountPerWave is the max number of msg per wave answered to
+	// the question of msg range, used by initial sync
+	MaxSyncMsgCountPerWave = 256
+
+	// MaxCheckpointCountPerWave is the max number of checkpoint per wave
+	MaxCheckpointCountPerWave = 16
+
+	// MaxInvCountPerWave is the max number of msg IDs announced or asked
+	// by one wave
+	MaxInvCountPerWave = 256
+
+	// OutboundQueueSize is the max number of waves waiting to be written
+	// to one peer, the control and bulk waves are queued separately and
+	// each queue holds up to OutboundQueueSize waves
+	OutboundQueueSize = 64
 )
 
+// Signer sign the answers sent to peer by the key of local node
+type Signer interface {
+	Sign(data []byte) (*galaxy.NodeSignature, error)
+}
+
 // Peer contain the info of websocket connection
 type Peer struct {
-	IP       string      `json:"ip"`
-	Port     uint64      `json:"port"`
-	NodeKey  string      `json:"nodeKey"`
-	UserID   common.Hash `json:"userID"`
-	Verified bool        `json:"verified"`
-	Conn     *websocket.Conn
+	IP       string          `json:"ip"`
+	Port     uint64          `json:"port"`
+	NodeKey  string          `json:"nodeKey"`
+	UserID   common.Hash     `json:"userID"`
+	Universe common.Hash     `json:"universe"` // ID of universe the peer in, zero if not known
+	Verified bool            `json:"verified"`
+	Conn     *websocket.Conn `json:"-"` // set before the peer shared, or by Dial under lock
+
+	lock      sync.RWMutex // guard Conn and out, the peer may be dialed and used at same time
+	out       *outbox
+	transport Transport
+	meter     *Meter
+	timeouts  *Timeouts
+	faults    *FaultInjector
+}
+
+// Transport build the ws connection to peer, DefaultTransport dial the
+// url of peer by network, others such as in memory pipes can be used in
+// tests of many nodes in one process. The dial should be canceled once
+// ctx done.
+type Transport interface {
+	Dial(ctx context.Context, p *Peer) (*websocket.Conn, error)
+}
+
+// wsTransport dial the peer by websocket over tcp
+type wsTransport struct{}
+
+func (wsTransport) Dial(ctx context.Context, p *Peer) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig(p.Url(), p.Origin())
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", config.Location.Host)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(ctx, config, conn)
+}
+
+// NewClient do the websocket handshake over conn, the conn is closed and
+// ctx.Err() returned if ctx done before the handshake finished
+func NewClient(ctx context.Context, config *websocket.Config, conn io.ReadWriteCloser) (*websocket.Conn, error) {
+	stop := closeOnDone(ctx, conn)
+	ws, err := websocket.NewClient(config, conn)
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// closeOnDone close c if ctx done before stop is called, so the blocked read
+// or write on c returns. stop return false if c is closed by ctx.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return true }
+	}
+	done, closed := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+			closed <- true
+		case <-done:
+			closed <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return !<-closed
+	}
+}
+
+// DefaultTransport is used by peer without transport be set
+var DefaultTransport Transport = wsTransport{}
+
+// outbox is the outbound queue of a dialed peer, all waves in it are
+// written to the conn by one writer goroutine. Control waves (ping, pong,
+// questions, errors, acks ...) and bulk waves (messages and snapshots) are
+// queued separately, the control waves are always written first, so they
+// wait at most one bulk wave being written instead of the whole bulk queue.
+type outbox struct {
+	control chan galaxy.Wave
+	bulk    chan galaxy.Wave
+	quit    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	err     error
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		control: make(chan galaxy.Wave, OutboundQueueSize),
+		bulk:    make(chan galaxy.Wave, OutboundQueueSize),
+		quit:    make(chan struct{}),
+	}
+}
+
+// IsBulk return true if the wave carry the msgs or snapshot, which can be
+// large and is sent with lower priority than the control waves
+func IsBulk(wave galaxy.Wave) bool {
+	switch wave.(type) {
+	case *galaxy.WaveMessages, *galaxy.WaveSnapshot:
+		return true
+	default:
+		return false
+	}
+}
+
+// queue return the queue of outbox the wave should be put into
+func (o *outbox) queue(wave galaxy.Wave) chan galaxy.Wave {
+	if IsBulk(wave) {
+		return o.bulk
+	}
+	return o.control
+}
+
+// pending return the number of waves in both queues
+func (o *outbox) pending() int {
+	return len(o.control) + len(o.bulk)
+}
+
+// stop the outbox with the reason, only the first reason is kept
+func (o *outbox) stop(err error) {
+	o.once.Do(func() {
+		o.mu.Lock()
+		o.err = err
+		o.mu.Unlock()
+		close(o.quit)
+	})
+}
+
+func (o *outbox) error() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+// writeLoop write the waves in outbox to w until the outbox stopped or
+// any write error
+func (o *outbox) writeLoop(w io.Writer) {
+	for {
+		wave, ok := o.next()
+		if !ok {
+			return
+		}
+		if _, err := galaxy.SendWave(w, wave); err != nil {
+			o.stop(err)
+			if c, ok := w.(io.Closer); ok {
+				c.Close()
+			}
+			return
+		}
+	}
+}
+
+// next wait for the next wave to be written, the control wave is returned
+// before any bulk wave if both queues are not empty. ok is false once the
+// outbox stopped.
+func (o *outbox) next() (wave galaxy.Wave, ok bool) {
+	select {
+	case wave = <-o.control:
+		return wave, true
+	case <-o.quit:
+		return nil, false
+	default:
+	}
+	select {
+	case wave = <-o.control:
+		return wave, true
+	case wave = <-o.bulk:
+		return wave, true
+	case <-o.quit:
+		return nil, false
+	}
 }
 
 // New create new Peer
@@ -57,7 +258,7 @@ func New(ip string, port uint64, nodeKey string) (*Peer, error) {
 
 // ID return key of peer
 func (p *Peer) ID() common.Hash {
-	hash := sha256.New()
+	hash := common.NewHash()
 	hash.Reset()
 	hash.Write([]byte(p.Url()))
 	return common.Bytes2Hash(hash.Sum(nil))
@@ -76,53 +277,206 @@ func (p *Peer) SetVerified() {
 	p.Verified = true
 }
 
+// SetTransport set the transport used by Dial, nil means DefaultTransport
+func (p *Peer) SetTransport(t Transport) {
+	p.transport = t
+}
+
+// SetMeter set the meter counting and limiting the bytes of conn, should
+// be set before Dial
+func (p *Peer) SetMeter(m *Meter) {
+	p.meter = m
+}
+
+// SetFaults set the injector of faults into the waves written to peer, nil
+// means no faults, should be set before Dial
+func (p *Peer) SetFaults(fi *FaultInjector) {
+	p.faults = fi
+}
+
+// Reader return the reader of conn, metered if meter be set, and limited by
+// the read timeout
+func (p *Peer) Reader() io.Reader {
+	conn, _ := p.link()
+	r := p.withReadTimeout(conn)
+	if p.meter == nil {
+		return r
+	}
+	return p.meter.Reader(r)
+}
+
+// writer return the writer of conn, metered if meter be set, and limited by
+// the write timeout, the faults are injected if set
+func (p *Peer) writer(w io.Writer) io.Writer {
+	c, _ := w.(io.Closer)
+	w = p.withWriteTimeout(w)
+	if p.faults != nil {
+		w = p.faults.Writer(w, c)
+	}
+	if p.meter == nil {
+		return w
+	}
+	return p.meter.Writer(w)
+}
+
 // Dial build ws connection
 func (p *Peer) Dial() error {
-	conn, err := websocket.Dial(p.Url(), "", p.origin())
+	return p.DialContext(context.Background())
+}
+
+// DialContext build ws connection, the dial is canceled if ctx done or dial
+// timeout reached before connected. The conn is not affected by ctx once dialed.
+func (p *Peer) DialContext(ctx context.Context) error {
+	if timeout := p.Timeouts().Dial; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	t := p.transport
+	if t == nil {
+		t = DefaultTransport
+	}
+	conn, err := t.Dial(ctx, p)
 	if err != nil {
 		return err
 	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.Conn = conn
+	p.startWriter(conn)
 	return nil
 }
 
-// Close the ws connection,
+// startWriter replace the outbox of peer and start the writer goroutine,
+// should be called under lock
+func (p *Peer) startWriter(w io.Writer) {
+	if p.out != nil {
+		p.out.stop(errPeerClosed)
+	}
+	p.out = newOutbox()
+	go p.out.writeLoop(p.writer(w))
+}
+
+// link return the conn and outbox of peer under lock, they are replaced
+// together once the peer dialed again
+func (p *Peer) link() (*websocket.Conn, *outbox) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.Conn, p.out
+}
+
+// Close the ws connection, the waves still in queue are dropped
 func (p *Peer) Close() error {
-	if p.Conn != nil {
-		return p.Conn.Close()
+	conn, out := p.link()
+	if out != nil {
+		out.stop(errPeerClosed)
+	}
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
+// Err return the error which stopped the writer of this peer, nil if the
+// writer still running or peer never be dialed
+func (p *Peer) Err() error {
+	if _, out := p.link(); out != nil {
+		return out.error()
+	}
+	return nil
+}
+
+// Pending return the number of waves waiting in the outbound queue
+func (p *Peer) Pending() int {
+	if _, out := p.link(); out != nil {
+		return out.pending()
+	}
+	return 0
+}
+
 // Url show the Peer ws url address
-func (p Peer) Url() string {
+func (p *Peer) Url() string {
 	return fmt.Sprintf("ws://%s:%d/%s", p.IP, p.Port, p.NodeKey)
 }
 
-// Address is UserID@IP:port/nodeKey
-func (p Peer) Address() string {
+// Address is UserID@IP:port/nodeKey, UserID is in address format (pdu1...)
+func (p *Peer) Address() string {
 	// todo : address without p.UserID or not verified
-	return fmt.Sprintf("%s@%s:%d/%s", common.Hash2String(p.UserID), p.IP, p.Port, p.NodeKey)
+	return fmt.Sprintf("%s@%s:%d/%s", common.EncodeAddress(p.UserID), p.IP, p.Port, p.NodeKey)
 }
 
 // Connected return true if this peer is connected right now
 func (p *Peer) Connected() bool {
-	if p.Conn != nil {
-		return true
+	conn, out := p.link()
+	if conn == nil {
+		return false
 	}
-	return false
+	return out == nil || out.error() == nil
 }
 
+// send put the wave into outbound queue if peer is dialed, ErrPeerBusy is
+// returned instead of blocking when queue is full, the bulk waves never fill
+// up the queue of control waves. Peer without outbox
+// (such as the peer built on incoming conn) write wave directly.
 func (p *Peer) send(wave galaxy.Wave) error {
-	_, err := galaxy.SendWave(p.Conn, wave)
-	if err != nil {
-		p.Conn = nil
+	conn, out := p.link()
+	if out == nil {
+		_, err := galaxy.SendWave(p.writer(conn), wave)
+		if err != nil {
+			p.lock.Lock()
+			if p.Conn == conn {
+				p.Conn = nil
+			}
+			p.lock.Unlock()
+			return err
+		}
+		return nil
+	}
+	if err := out.error(); err != nil {
 		return err
 	}
-	return nil
+	select {
+	case out.queue(wave) <- wave:
+		return nil
+	case <-out.quit:
+		return out.error()
+	default:
+		return ErrPeerBusy
+	}
 }
 
-// SendQuestion is used to send question to peer
+// SendContext send the wave to peer, and wait for the room in outbound
+// queue instead of returning ErrPeerBusy. The peer without outbox write the
+// wave directly, its conn is closed if ctx done before the wave written, so
+// the blocked write returns with ctx.Err().
+func (p *Peer) SendContext(ctx context.Context, wave galaxy.Wave) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	conn, out := p.link()
+	if out == nil {
+		stop := closeOnDone(ctx, conn)
+		err := p.send(wave)
+		if !stop() {
+			return ctx.Err()
+		}
+		return err
+	}
+	if err := out.error(); err != nil {
+		return err
+	}
+	select {
+	case out.queue(wave) <- wave:
+		return nil
+	case <-out.quit:
+		return out.error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendQuestion is used to send question to peer, the args are encoded by
+// type without schema, Ask is used for the typed questions
 func (p *Peer) SendQuestion(waveID common.Hash, cmd string, args ...interface{}) error {
 	if !p.Connected() {
 		return errPeerNotReachable
@@ -140,7 +494,7 @@ func (p *Peer) SendQuestion(waveID common.Hash, cmd string, args ...interface{})
 	return p.send(wave)
 }
 
-func (p Peer) buildArgs(args ...interface{}) (result [][]byte, err error) {
+func (p *Peer) buildArgs(args ...interface{}) (result [][]byte, err error) {
 	for _, arg := range args {
 		var item []byte
 		switch arg.(type) {
@@ -169,35 +523,75 @@ func (p *Peer) SendMsg(waveID common.Hash, msg *core.Message) error {
 
 // SendMsgs is used to send mulitiple msgs
 func (p *Peer) SendMsgs(waveID common.Hash, msgs []*core.Message) error {
+	return p.sendMsgs(waveID, msgs, false)
+}
+
+// SendMsgAck is used to send msg and ask peer to answer the WaveAck with
+// same waveID when msg accepted
+func (p *Peer) SendMsgAck(waveID common.Hash, msg *core.Message) error {
+	return p.sendMsgs(waveID, []*core.Message{msg}, true)
+}
+
+func (p *Peer) sendMsgs(waveID common.Hash, msgs []*core.Message, ack bool) error {
 	if len(msgs) > MaxMsgCountPerWave {
 		msgs = msgs[:MaxMsgCountPerWave]
 	}
 	if !p.Connected() {
 		return errPeerNotReachable
 	}
+	msgsB, err := encodeMsgs(msgs)
+	if err != nil {
+		return err
+	}
+	wave := &galaxy.WaveMessages{
+		WaveID: waveID,
+		Msgs:   msgsB,
+		Ack:    ack,
+	}
+	return p.send(wave)
+}
+
+func encodeMsgs(msgs []*core.Message) ([][]byte, error) {
 	var msgsB [][]byte
 	for _, msg := range msgs {
 		msgBytes, err := json.Marshal(msg)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		msgsB = append(msgsB, msgBytes)
 	}
+	return msgsB, nil
+}
+
+// SendMsgRange is used to answer the question of msgs or msg range, with the
+// msg count of local node, so the peer know how many msgs can be asked
+func (p *Peer) SendMsgRange(waveID common.Hash, msgs []*core.Message, total uint64) error {
+	if len(msgs) > MaxSyncMsgCountPerWave {
+		msgs = msgs[:MaxSyncMsgCountPerWave]
+	}
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	msgsB, err := encodeMsgs(msgs)
+	if err != nil {
+		return err
+	}
 	wave := &galaxy.WaveMessages{
 		WaveID: waveID,
 		Msgs:   msgsB,
+		Total:  total,
 	}
 	return p.send(wave)
 }
 
-// SendPeers is used to send peers of local node
-func (p *Peer) SendPeers(waveID common.Hash, pm map[common.Hash]*Peer, localPeer *Peer) error {
+// SendPeers is used to send peers of local node, signed by signer if not nil
+func (p *Peer) SendPeers(waveID common.Hash, pm map[common.Hash]*Peer, localPeer *Peer, signer Signer) error {
 	if !p.Connected() {
 		return errPeerNotReachable
 	}
 	var targetPeers [][]byte
 	for _, item := range pm {
-		if item.Conn == nil {
+		if conn, _ := item.link(); conn == nil {
 			continue
 		}
 		nodeAddress, err := json.Marshal(item)
@@ -216,11 +610,17 @@ func (p *Peer) SendPeers(waveID common.Hash, pm map[common.Hash]*Peer, localPeer
 		WaveID: waveID,
 		Peers:  targetPeers,
 	}
+	if signer != nil {
+		if wave.Auth, err = signer.Sign(wave.SignedData()); err != nil {
+			return err
+		}
+	}
 	return p.send(wave)
 }
 
-// SendRoots is used to send 2 roots to peer
-func (p *Peer) SendRoots(waveID common.Hash, user0, user1 *core.User) error {
+// SendRoots is used to send 2 roots, the name of hasher and the network to peer,
+// the universe ID of the roots is sent too
+func (p *Peer) SendRoots(waveID common.Hash, user0, user1 *core.User, hasher string, network uint64) error {
 	if !p.Connected() {
 		return errPeerNotReachable
 	}
@@ -228,10 +628,134 @@ func (p *Peer) SendRoots(waveID common.Hash, user0, user1 *core.User) error {
 	users[0] = user0
 	users[1] = user1
 	wave := &galaxy.WaveRoots{
+		WaveID:   waveID,
+		Users:    users,
+		Hasher:   hasher,
+		Network:  network,
+		Universe: core.GenesisHash(network, user0, user1),
+	}
+
+	return p.send(wave)
+}
+
+// SendGenesis is used to send the roots with the genesis of universe, signed
+// by signer if not nil, the network of genesis config is main if not set
+func (p *Peer) SendGenesis(waveID common.Hash, genesis *core.Genesis, hasher string, signer Signer) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	users, err := genesis.RootUsers()
+	if err != nil {
+		return err
+	}
+	network := core.NetworkMain
+	if genesis.Config != nil {
+		network = genesis.Config.NetworkID
+	}
+	wave := &galaxy.WaveRoots{
+		WaveID:   waveID,
+		Users:    [2]*core.User{users[0], users[1]},
+		Hasher:   hasher,
+		Network:  network,
+		Universe: core.GenesisHash(network, users[0], users[1]),
+		Genesis:  genesis,
+	}
+	if signer != nil {
+		if wave.Auth, err = signer.Sign(wave.SignedData()); err != nil {
+			return err
+		}
+	}
+	return p.send(wave)
+}
+
+// SendCheckpoints is used to send checkpoints of space-time to peer, signed
+// by signer if not nil
+func (p *Peer) SendCheckpoints(waveID common.Hash, cps []*core.Checkpoint, signer Signer) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	if len(cps) > MaxCheckpointCountPerWave {
+		cps = cps[len(cps)-MaxCheckpointCountPerWave:]
+	}
+	wave := &galaxy.WaveCheckpoints{
+		WaveID:      waveID,
+		Checkpoints: cps,
+	}
+	if signer != nil {
+		var err error
+		if wave.Auth, err = signer.Sign(wave.SignedData()); err != nil {
+			return err
+		}
+	}
+	return p.send(wave)
+}
+
+// SendSnapshot is used to send the snapshot of local universe to peer, nil
+// if no snapshot can be served
+func (p *Peer) SendSnapshot(waveID common.Hash, snap *core.Snapshot) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	wave := &galaxy.WaveSnapshot{
+		WaveID:   waveID,
+		Snapshot: snap,
+	}
+	return p.send(wave)
+}
+
+// SendRejections is used to send the reasons why msgs be rejected to peer
+func (p *Peer) SendRejections(waveID common.Hash, rejections ...*core.Rejection) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	wave := &galaxy.WaveRejections{
+		WaveID:     waveID,
+		Rejections: rejections,
+	}
+	return p.send(wave)
+}
+
+// SendAck is used to acknowledge the msgs accepted from the wave of peer
+func (p *Peer) SendAck(waveID common.Hash, msgIDs ...common.Hash) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	wave := &galaxy.WaveAck{
 		WaveID: waveID,
-		Users:  users,
+		MsgIDs: msgIDs,
 	}
+	return p.send(wave)
+}
 
+// SendInv is used to announce the IDs of msgs to peer, the peer ask the
+// msgs it missing by WaveGetMsgs with same waveID
+func (p *Peer) SendInv(waveID common.Hash, msgIDs ...common.Hash) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	if len(msgIDs) > MaxInvCountPerWave {
+		msgIDs = msgIDs[:MaxInvCountPerWave]
+	}
+	wave := &galaxy.WaveInv{
+		WaveID: waveID,
+		MsgIDs: msgIDs,
+	}
+	return p.send(wave)
+}
+
+// SendGetMsgs is used to ask the msgs announced by peer, waveID should be
+// same as the announcement
+func (p *Peer) SendGetMsgs(waveID common.Hash, msgIDs ...common.Hash) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	if len(msgIDs) > MaxInvCountPerWave {
+		msgIDs = msgIDs[:MaxInvCountPerWave]
+	}
+	wave := &galaxy.WaveGetMsgs{
+		WaveID: waveID,
+		MsgIDs: msgIDs,
+	}
 	return p.send(wave)
 }
 
@@ -253,8 +777,41 @@ func (p *Peer) SendPong(waveID common.Hash) error {
 	return p.send(wave)
 }
 
-// origin used when peer dial
-func (p Peer) origin() string {
+// Serve read waves from the conn of peer and dispatch them to handler
+// until the conn is broken or handler return an error, the error is
+// returned. Serve blocks, so it usually runs in its own goroutine.
+func (p *Peer) Serve(handler galaxy.Handler) error {
+	return p.ServeContext(context.Background(), handler)
+}
+
+// ServeContext is same as Serve, but the peer is closed once ctx done, so
+// the blocked read returns and ctx.Err() is returned.
+func (p *Peer) ServeContext(ctx context.Context, handler galaxy.Handler) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	stop := closeOnDone(ctx, p)
+	err := serve(p.Reader(), handler)
+	if !stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
+func serve(r io.Reader, handler galaxy.Handler) error {
+	for {
+		wave, err := galaxy.ReceiveWave(r)
+		if err != nil {
+			return err
+		}
+		if err := galaxy.Dispatch(handler, wave); err != nil {
+			return err
+		}
+	}
+}
+
+// Origin used when peer dial
+func (p *Peer) Origin() string {
 	return fmt.Sprintf("http://%s:%d/", p.IP, p.Port)
 }
 
@@ -266,6 +823,7 @@ func (p *Peer) SendErr(waveID common.Hash, err error) error {
 	wave := &galaxy.WaveErr{
 		WaveID: waveID,
 		Err:    err.Error(),
+		Code:   common.CodeOf(err),
 	}
 	return p.send(wave)
 }
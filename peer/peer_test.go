@@ -0,0 +1,476 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/galaxy"
+	"golang.org/x/net/websocket"
+)
+
+func TestPeer_SendQueue(t *testing.T) {
+	r, w := io.Pipe()
+	// conn is never used by the writer, only mark the peer as dialed
+	p := &Peer{Conn: &websocket.Conn{}}
+	p.startWriter(w)
+
+	// nobody read the pipe, so the writer blocks on the first wave and
+	// the rest fill up the queue
+	if err := p.SendPing(common.CreateHash()); err != nil {
+		t.Fatal("send ping fail", err)
+	}
+	for i := 0; i < 100 && p.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < OutboundQueueSize; i++ {
+		if err := p.SendPing(common.CreateHash()); err != nil {
+			t.Fatal("send ping fail", i, err)
+		}
+	}
+	if err := p.send(&galaxy.WavePing{WaveID: common.CreateHash()}); err != ErrPeerBusy {
+		t.Errorf("err should be %s, but get %s", ErrPeerBusy, err)
+	}
+	if p.Pending() != OutboundQueueSize {
+		t.Errorf("pending should be %d, but get %d", OutboundQueueSize, p.Pending())
+	}
+
+	// read all waves back
+	for i := 0; i <= OutboundQueueSize; i++ {
+		wave, err := galaxy.ReceiveWave(r)
+		if err != nil {
+			t.Fatal("receive wave fail", err)
+		}
+		if wave.Command() != galaxy.CmdPing {
+			t.Errorf("command should be %s, but get %s", galaxy.CmdPing, wave.Command())
+		}
+	}
+
+	// write error stop the writer and is returned by later send
+	errBroken := errors.New("broken pipe")
+	r.CloseWithError(errBroken)
+	p.SendPing(common.CreateHash())
+	for i := 0; i < 100 && p.Err() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.Err() != errBroken {
+		t.Errorf("err should be %s, but get %s", errBroken, p.Err())
+	}
+	if err := p.send(&galaxy.WavePing{WaveID: common.CreateHash()}); err != errBroken {
+		t.Errorf("err should be %s, but get %s", errBroken, err)
+	}
+	if err := p.SendPing(common.CreateHash()); err != errPeerNotReachable {
+		t.Errorf("err should be %s, but get %s", errPeerNotReachable, err)
+	}
+	if p.Connected() {
+		t.Error("peer should not be connected after write error")
+	}
+}
+
+func TestPeer_SendPriority(t *testing.T) {
+	r, w := io.Pipe()
+	p := &Peer{Conn: &websocket.Conn{}}
+	p.startWriter(w)
+
+	// block the writer on the first wave, then fill up the bulk queue
+	if err := p.SendPing(common.CreateHash()); err != nil {
+		t.Fatal("send ping fail", err)
+	}
+	for i := 0; i < 100 && p.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < OutboundQueueSize; i++ {
+		if err := p.send(&galaxy.WaveMessages{WaveID: common.CreateHash()}); err != nil {
+			t.Fatal("send msgs fail", i, err)
+		}
+	}
+	if err := p.send(&galaxy.WaveMessages{WaveID: common.CreateHash()}); err != ErrPeerBusy {
+		t.Errorf("err should be %s, but get %s", ErrPeerBusy, err)
+	}
+
+	// control waves are still accepted and written before the bulk waves
+	if err := p.SendPong(common.CreateHash()); err != nil {
+		t.Fatal("send pong fail", err)
+	}
+	if err := p.AskMsgRange(common.CreateHash(), 0, 1); err != nil {
+		t.Fatal("send question fail", err)
+	}
+	if p.Pending() != OutboundQueueSize+2 {
+		t.Errorf("pending should be %d, but get %d", OutboundQueueSize+2, p.Pending())
+	}
+	cmds := []string{galaxy.CmdPing, galaxy.CmdPong, galaxy.CmdQuestion}
+	for i := 0; i < OutboundQueueSize; i++ {
+		cmds = append(cmds, galaxy.CmdMessages)
+	}
+	for i, cmd := range cmds {
+		wave, err := galaxy.ReceiveWave(r)
+		if err != nil {
+			t.Fatal("receive wave fail", err)
+		}
+		if wave.Command() != cmd {
+			t.Fatalf("command of wave %d should be %s, but get %s", i, cmd, wave.Command())
+		}
+	}
+	p.out.stop(errPeerClosed)
+}
+
+type testHandler struct {
+	galaxy.BaseHandler
+	pings int
+	pongs int
+}
+
+var errStopServe = errors.New("stop serve")
+
+func (h *testHandler) HandlePing(w *galaxy.WavePing) error {
+	h.pings++
+	return nil
+}
+
+func (h *testHandler) HandlePong(w *galaxy.WavePong) error {
+	h.pongs++
+	return nil
+}
+
+func (h *testHandler) HandleErr(w *galaxy.WaveErr) error {
+	return errStopServe
+}
+
+func TestPeer_Serve(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		waves := []galaxy.Wave{
+			&galaxy.WavePing{WaveID: common.CreateHash()},
+			&galaxy.WavePong{WaveID: common.CreateHash()},
+			&galaxy.WavePing{WaveID: common.CreateHash()},
+			&galaxy.WaveErr{WaveID: common.CreateHash(), Err: "stop"},
+		}
+		for _, wave := range waves {
+			galaxy.SendWave(w, wave)
+		}
+	}()
+
+	h := &testHandler{}
+	if err := serve(r, h); err != errStopServe {
+		t.Errorf("err should be %s, but get %s", errStopServe, err)
+	}
+	if h.pings != 2 || h.pongs != 1 {
+		t.Errorf("pings and pongs should be 2 and 1, but get %d and %d", h.pings, h.pongs)
+	}
+
+	go galaxy.SendWave(w, &galaxy.WaveRoots{WaveID: common.CreateHash()})
+	if err := serve(r, h); err != galaxy.ErrWaveNotHandled {
+		t.Errorf("err should be %s, but get %s", galaxy.ErrWaveNotHandled, err)
+	}
+
+	w.Close()
+	if err := serve(r, h); err != io.EOF {
+		t.Errorf("err should be %s, but get %s", io.EOF, err)
+	}
+	if err := (&Peer{}).Serve(h); err != errPeerNotReachable {
+		t.Errorf("err should be %s, but get %s", errPeerNotReachable, err)
+	}
+}
+
+// failTransport count the dials and always fail
+type failTransport struct {
+	dials *int
+}
+
+var errDialFail = errors.New("dial fail")
+
+func (t failTransport) Dial(ctx context.Context, p *Peer) (*websocket.Conn, error) {
+	*t.dials++
+	return nil, errDialFail
+}
+
+func TestPeer_SetTransport(t *testing.T) {
+	p, _ := New("127.0.0.1", 8341, "nodeKey")
+	var dials int
+	p.SetTransport(failTransport{dials: &dials})
+	if err := p.Dial(); err != errDialFail {
+		t.Errorf("err should be %s, but get %s", errDialFail, err)
+	}
+	if dials != 1 || p.Connected() {
+		t.Error("peer should be dialed by transport once and not connected")
+	}
+}
+
+// connTransport return the conn which is never written, only mark the peer
+// as dialed
+type connTransport struct{}
+
+func (connTransport) Dial(ctx context.Context, p *Peer) (*websocket.Conn, error) {
+	return &websocket.Conn{}, nil
+}
+
+func TestPeer_DialWhileUsed(t *testing.T) {
+	p, _ := New("127.0.0.1", 8341, "nodeKey")
+	p.SetTransport(connTransport{})
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 20; j++ {
+				if err := p.Dial(); err != nil {
+					t.Error("dial fail", err)
+				}
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 4; {
+		select {
+		case <-done:
+			i++
+		default:
+			p.Connected()
+			p.Pending()
+			p.Err()
+		}
+	}
+	if !p.Connected() {
+		t.Error("peer should be connected")
+	}
+}
+
+func TestMeter_Limit(t *testing.T) {
+	total := NewMeter(nil, 0, 0)
+	m := NewMeter(total, 1000, 0)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	start := time.Now()
+	// the burst of one second is sent at once, then wait for the rate
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Error("send should be limited, but elapsed", elapsed)
+	}
+	if _, err := io.Copy(ioutil.Discard, m.Reader(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, stats := range []MeterStats{m.Stats(), total.Stats()} {
+		if stats.Sent != 1500 || stats.Received != 1500 {
+			t.Error("bytes not counted", stats)
+		}
+		if stats.SendRate != 1500.0/RateWindow {
+			t.Error("send rate not match", stats.SendRate)
+		}
+	}
+}
+
+// closeBuffer is the buffer records whether closed
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeBuffer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestFaultInjector(t *testing.T) {
+	fi := NewFaultInjector(1)
+	var buf closeBuffer
+	w := fi.Writer(&buf, &buf)
+	wave := []byte("wave")
+	cases := []struct {
+		faults Faults
+		bytes  int
+		err    error
+	}{
+		{Faults{}, 4, nil},
+		{Faults{Drop: 1}, 0, nil},
+		{Faults{Duplicate: 1, Delay: 10 * time.Millisecond}, 8, nil},
+		{Faults{Disconnect: 1}, 0, errFaultDisconnect},
+	}
+	for i, c := range cases {
+		fi.Set(c.faults)
+		buf.Reset()
+		start := time.Now()
+		if n, err := w.Write(wave); err != c.err {
+			t.Errorf("case %d err should be %v, but get %v", i, c.err, err)
+		} else if err == nil && n != len(wave) {
+			t.Errorf("case %d wave should be reported written, but get %d", i, n)
+		}
+		if buf.Len() != c.bytes {
+			t.Errorf("case %d bytes written should be %d, but get %d", i, c.bytes, buf.Len())
+		}
+		if elapsed := time.Since(start); elapsed < c.faults.Delay {
+			t.Errorf("case %d wave should be delayed %s, but get %s", i, c.faults.Delay, elapsed)
+		}
+	}
+	if !buf.closed {
+		t.Error("conn should be closed by disconnect")
+	}
+	stats := fi.Stats()
+	if stats.Dropped != 1 || stats.Duplicated != 1 || stats.Disconnected != 1 || stats.Delayed != 1 {
+		t.Errorf("faults not counted %+v", stats)
+	}
+}
+
+// chunkReader return at most size bytes in each read
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.size {
+		p = p[:c.size]
+	}
+	return c.r.Read(p)
+}
+
+func TestWiretap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wiretap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tap, err := NewWiretap(dir, 512, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMeter(nil, 0, 0)
+	m.SetWiretap(tap, "127.0.0.1:8341")
+
+	// waves sent are recorded by each write, received by the length in header
+	var buf bytes.Buffer
+	waveIDs := []common.Hash{common.CreateHash(), common.CreateHash(), common.CreateHash()}
+	for _, waveID := range waveIDs {
+		if _, err := galaxy.SendWave(m.Writer(&buf), &galaxy.WavePing{WaveID: waveID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := m.Reader(&chunkReader{r: &buf, size: 7})
+	for range waveIDs {
+		if _, err := galaxy.ReceiveWave(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tap.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest file is removed after rotated
+	files, err := WiretapFiles(dir)
+	if err != nil || len(files) != 2 {
+		t.Fatal("wiretap files should be rotated", files, err)
+	}
+	var records []*WiretapRecord
+	for _, fileName := range files {
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wr := NewWiretapReader(f)
+		for {
+			r, err := wr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+		f.Close()
+	}
+	if len(records) == 0 || len(records) >= 2*len(waveIDs) {
+		t.Fatal("records in files removed should be dropped", len(records))
+	}
+	last := records[len(records)-1]
+	if last.Dir != WiretapReceived || last.Peer != "127.0.0.1:8341" || last.Command != galaxy.CmdPing {
+		t.Error("record not match", last.Dir, last.Peer, last.Command)
+	}
+	w, err := last.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.(*galaxy.WavePing).WaveID != waveIDs[len(waveIDs)-1] {
+		t.Error("decoded wave not match")
+	}
+}
+
+// pipeTransport do the handshake over pipe which never answered
+type pipeTransport struct{}
+
+func (pipeTransport) Dial(ctx context.Context, p *Peer) (*websocket.Conn, error) {
+	c, _ := net.Pipe()
+	config, err := websocket.NewConfig(p.Url(), p.Origin())
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(ctx, config, c)
+}
+
+func TestPeer_DialContext(t *testing.T) {
+	p, err := New("127.0.0.1", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTransport(pipeTransport{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.DialContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("dial should be canceled, but get %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("dial should return once ctx done")
+	}
+	if p.Connected() {
+		t.Error("peer should not be connected")
+	}
+}
+
+func TestPeer_Timeouts(t *testing.T) {
+	p, err := New("127.0.0.1", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Timeouts() != DefaultTimeouts {
+		t.Error("default timeouts should be used")
+	}
+	p.SetTimeouts(Timeouts{Read: 50 * time.Millisecond, Write: 50 * time.Millisecond})
+	c, _ := net.Pipe()
+	defer c.Close()
+	start := time.Now()
+	// nobody read or write on the other side
+	if _, err := p.writer(c).Write([]byte("wave")); err == nil {
+		t.Error("write should be timeout")
+	}
+	if _, err := p.withReadTimeout(c).Read(make([]byte, 4)); err == nil {
+		t.Error("read should be timeout")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("timeouts should not be blocked")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/galaxy"
+)
+
+// Ask is used to send the typed question to peer, the args are encoded by
+// the question, so they match the schema checked by the node answered
+func (p *Peer) Ask(waveID common.Hash, q galaxy.Question) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	wave, err := galaxy.NewWaveQuestion(waveID, q)
+	if err != nil {
+		return err
+	}
+	return p.send(wave)
+}
+
+// AskRoots ask the roots of universe, answered by WaveRoots
+func (p *Peer) AskRoots(waveID common.Hash) error {
+	return p.Ask(waveID, &galaxy.RootsQuestion{})
+}
+
+// AskPeers send the handshake with local peer in JSON signed by auth,
+// answered by WavePeers
+func (p *Peer) AskPeers(waveID common.Hash, localPeer []byte, auth *galaxy.NodeSignature) error {
+	return p.Ask(waveID, &galaxy.PeersQuestion{Peer: localPeer, Auth: auth})
+}
+
+// AskMessages ask the msgs after lastMsgID, answered by WaveMessages
+func (p *Peer) AskMessages(waveID common.Hash, lastMsgID common.Hash) error {
+	return p.Ask(waveID, &galaxy.MessagesQuestion{LastMsgID: lastMsgID})
+}
+
+// AskCheckpoints ask the checkpoints of space-time, answered by WaveCheckpoints
+func (p *Peer) AskCheckpoints(waveID common.Hash, stID common.Hash) error {
+	return p.Ask(waveID, &galaxy.CheckpointsQuestion{SpaceTimeID: stID})
+}
+
+// AskSnapshot ask the snapshot offered, answered by WaveSnapshot
+func (p *Peer) AskSnapshot(waveID common.Hash) error {
+	return p.Ask(waveID, &galaxy.SnapshotQuestion{})
+}
+
+// AskMsgRange ask count msgs from order, answered by WaveMessages
+func (p *Peer) AskMsgRange(waveID common.Hash, from, count uint64) error {
+	return p.Ask(waveID, &galaxy.MsgRangeQuestion{From: from, Count: count})
+}
+
+// AskSnapshotChunk ask the chunk of snapshot at the checkpoint of space-time,
+// answered by WaveMessages
+func (p *Peer) AskSnapshotChunk(waveID common.Hash, stID common.Hash, seq uint64, root common.Hash, index uint64) error {
+	return p.Ask(waveID, &galaxy.SnapshotChunkQuestion{SpaceTimeID: stID, Seq: seq, Root: root, Index: index})
+}
+
+// AskGenesis ask the genesis of universe, answered by WaveRoots
+func (p *Peer) AskGenesis(waveID common.Hash) error {
+	return p.Ask(waveID, &galaxy.GenesisQuestion{})
+}
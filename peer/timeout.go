@@ -0,0 +1,100 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"io"
+	"time"
+)
+
+// Timeouts of the conn of peer, zero means no timeout
+type Timeouts struct {
+	Dial  time.Duration `json:"dial,omitempty"`  // max time to connect and handshake
+	Read  time.Duration `json:"read,omitempty"`  // max time waiting for the bytes from peer
+	Write time.Duration `json:"write,omitempty"` // max time the write to peer blocked
+}
+
+// DefaultTimeouts is used by peer without timeouts be set. The read is not
+// limited by default, because peer may be idle between pings.
+var DefaultTimeouts = Timeouts{Dial: 10 * time.Second, Write: 30 * time.Second}
+
+// SetTimeouts set the timeouts of peer, should be set before Dial
+func (p *Peer) SetTimeouts(t Timeouts) {
+	p.timeouts = &t
+}
+
+// Timeouts return the timeouts of peer, DefaultTimeouts if not set
+func (p *Peer) Timeouts() Timeouts {
+	if p.timeouts == nil {
+		return DefaultTimeouts
+	}
+	return *p.timeouts
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// deadlineReader set the read deadline of conn before each read, so the read
+// blocked longer than timeout fail
+type deadlineReader struct {
+	r       io.Reader
+	conn    readDeadliner
+	timeout time.Duration
+}
+
+func (dr *deadlineReader) Read(b []byte) (int, error) {
+	dr.conn.SetReadDeadline(time.Now().Add(dr.timeout))
+	return dr.r.Read(b)
+}
+
+// deadlineWriter set the write deadline of conn before each write, so the
+// write blocked longer than timeout fail
+type deadlineWriter struct {
+	w       io.Writer
+	conn    writeDeadliner
+	timeout time.Duration
+}
+
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	dw.conn.SetWriteDeadline(time.Now().Add(dw.timeout))
+	return dw.w.Write(b)
+}
+
+// withReadTimeout wrap r by deadlineReader if read timeout set and conn support deadline
+func (p *Peer) withReadTimeout(r io.Reader) io.Reader {
+	if t := p.Timeouts().Read; t > 0 {
+		if conn, ok := r.(readDeadliner); ok {
+			return &deadlineReader{r: r, conn: conn, timeout: t}
+		}
+	}
+	return r
+}
+
+// withWriteTimeout wrap w by deadlineWriter if write timeout set and conn support deadline
+func (p *Peer) withWriteTimeout(w io.Writer) io.Writer {
+	if t := p.Timeouts().Write; t > 0 {
+		if conn, ok := w.(writeDeadliner); ok {
+			return &deadlineWriter{w: w, conn: conn, timeout: t}
+		}
+	}
+	return w
+}
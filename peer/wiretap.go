@@ -0,0 +1,245 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/galaxy"
+)
+
+// Directions of waves recorded by wiretap
+const (
+	WiretapSent     = "sent"
+	WiretapReceived = "received"
+)
+
+const (
+	// DefaultWiretapFileSize is the bytes of wiretap file before rotated
+	DefaultWiretapFileSize = 64 * 1024 * 1024
+
+	// DefaultWiretapFiles is the number of wiretap files kept in dir, the
+	// oldest one is removed after rotated
+	DefaultWiretapFiles = 8
+
+	wiretapPrefix = "wiretap-"
+	wiretapSuffix = ".jsonl"
+)
+
+// WiretapRecord is one wave sent to or received from peer, Wave is the
+// bytes on the wire with header, so it can be decoded again by the
+// version of receiver.
+type WiretapRecord struct {
+	Time    time.Time `json:"time"`
+	Dir     string    `json:"dir"`
+	Peer    string    `json:"peer"`
+	Command string    `json:"command"`
+	Wave    []byte    `json:"wave"`
+}
+
+// Decode the wave in record, same as received from peer
+func (r *WiretapRecord) Decode() (galaxy.Wave, error) {
+	return galaxy.ReceiveWave(bytes.NewReader(r.Wave))
+}
+
+// Wiretap record the waves of meters into the json lines files in dir, a
+// new file is created when the file is larger than maxSize, and only the
+// newest maxFiles are kept. Record never block or break the conn, the
+// error stop recording and is returned by Err.
+type Wiretap struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	err  error
+}
+
+// NewWiretap create the wiretap write into dir, the default size and
+// number of files are used if not positive
+func NewWiretap(dir string, maxSize int64, maxFiles int) (*Wiretap, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultWiretapFileSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultWiretapFiles
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	t := &Wiretap{dir: dir, maxSize: maxSize, maxFiles: maxFiles}
+	if err := t.rotate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Record write the wave sent to or received from peer
+func (t *Wiretap) Record(dir, peer string, wave []byte) {
+	r := &WiretapRecord{Time: time.Now(), Dir: dir, Peer: peer, Command: waveCommand(wave), Wave: wave}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	if t.size > 0 && t.size+int64(len(line)) > t.maxSize {
+		if t.err = t.rotate(); t.err != nil {
+			return
+		}
+	}
+	n, err := t.file.Write(line)
+	t.size += int64(n)
+	t.err = err
+}
+
+// Err return the error stopped recording
+func (t *Wiretap) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Close the file in use, no more waves are recorded
+func (t *Wiretap) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = os.ErrClosed
+	}
+	return t.file.Close()
+}
+
+// rotate close the file in use and create a new one, the files named by
+// time of created, so the oldest are removed first
+func (t *Wiretap) rotate() error {
+	if t.file != nil {
+		if err := t.file.Close(); err != nil {
+			return err
+		}
+	}
+	fileName := fmt.Sprintf("%s%020d%s", wiretapPrefix, time.Now().UnixNano(), wiretapSuffix)
+	f, err := os.OpenFile(filepath.Join(t.dir, fileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	t.file, t.size = f, 0
+	files, err := WiretapFiles(t.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > t.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// WiretapFiles return the wiretap files in dir from oldest to newest
+func WiretapFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() && strings.HasPrefix(name, wiretapPrefix) && strings.HasSuffix(name, wiretapSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// WiretapReader read the records from wiretap file in order
+type WiretapReader struct {
+	dec *json.Decoder
+}
+
+// NewWiretapReader create the reader of records from r
+func NewWiretapReader(r io.Reader) *WiretapReader {
+	return &WiretapReader{dec: json.NewDecoder(r)}
+}
+
+// Next return the next record, io.EOF if no more records
+func (wr *WiretapReader) Next() (*WiretapRecord, error) {
+	var r WiretapRecord
+	if err := wr.dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// waveCommand return the command in header of wave, empty if no header
+func waveCommand(wave []byte) string {
+	if len(wave) < galaxy.WaveHeaderSize {
+		return ""
+	}
+	return string(bytes.TrimRight(wave[4:galaxy.CommandSize+4], "\x00"))
+}
+
+// waveSplitter collect the bytes received until a whole wave, by the
+// length in header of wave
+type waveSplitter struct {
+	buf []byte
+}
+
+// write append the bytes received, and return the whole waves in them
+func (s *waveSplitter) write(p []byte) [][]byte {
+	s.buf = append(s.buf, p...)
+	var waves [][]byte
+	for len(s.buf) >= galaxy.WaveHeaderSize {
+		waveLen := int(binary.BigEndian.Uint32(s.buf[galaxy.CommandSize+4 : galaxy.CommandSize+8]))
+		if waveLen == 0 {
+			// wave from old version without length, body is the next read
+			if len(s.buf) == galaxy.WaveHeaderSize {
+				break
+			}
+			waves = append(waves, s.buf)
+			s.buf = nil
+			break
+		}
+		if len(s.buf) < galaxy.WaveHeaderSize+waveLen {
+			break
+		}
+		wave := make([]byte, galaxy.WaveHeaderSize+waveLen)
+		copy(wave, s.buf)
+		waves = append(waves, wave)
+		s.buf = s.buf[len(wave):]
+	}
+	return waves
+}
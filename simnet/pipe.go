@@ -0,0 +1,225 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errConnClosed     = errors.New("conn closed")
+	errListenerClosed = errors.New("listener closed")
+)
+
+// pipe is one direction of conn, the written bytes are buffered like the
+// buffer of tcp socket, so writer never wait for reader. The bytes can be
+// read after the latency of pipe.
+type pipe struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	closed   bool
+	deadline time.Time
+	timer    *time.Timer
+	latency  time.Duration
+	pending  []delayedWrite // written but not arrived yet
+}
+
+// delayedWrite is the bytes written, which arrive at the time
+type delayedWrite struct {
+	at time.Time
+	b  []byte
+}
+
+// timeoutError is returned by read after deadline, http server set the
+// deadline to abort the read before ws hijack the conn
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func newPipe() *pipe {
+	p := &pipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.arrive(); p.buf.Len() == 0 && !p.closed && !p.expired(); p.arrive() {
+		p.cond.Wait()
+	}
+	if p.closed {
+		return 0, io.EOF
+	}
+	if p.expired() {
+		return 0, timeoutError{}
+	}
+	return p.buf.Read(b)
+}
+
+// arrive move the bytes written before latency into buffer
+func (p *pipe) arrive() {
+	now := time.Now()
+	for len(p.pending) > 0 && !now.Before(p.pending[0].at) {
+		p.buf.Write(p.pending[0].b)
+		p.pending = p.pending[1:]
+	}
+}
+
+// setLatency delay the bytes written after
+func (p *pipe) setLatency(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency = latency
+}
+
+func (p *pipe) expired() bool {
+	return !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+}
+
+// setDeadline wake the reader at deadline, zero time means no deadline
+func (p *pipe) setDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.deadline = t
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, errConnClosed
+	}
+	// bytes written after the latency changed never arrive before others
+	if p.latency > 0 || len(p.pending) > 0 {
+		p.pending = append(p.pending, delayedWrite{at: time.Now().Add(p.latency), b: append([]byte{}, b...)})
+		time.AfterFunc(p.latency, func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+		return len(b), nil
+	}
+	n, err := p.buf.Write(b)
+	p.cond.Broadcast()
+	return n, err
+}
+
+// close drop the bytes not be read yet, as the link is broken
+func (p *pipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.buf.Reset()
+	p.pending = nil
+	p.cond.Broadcast()
+}
+
+// conn is one end of the link between two nodes, from is the index of
+// node which dial, to is the index of node which accept.
+type conn struct {
+	r, w          *pipe
+	local, remote net.Addr
+	from, to      int
+}
+
+// newConnPair return the conn of dialer and the conn of listener
+func newConnPair(from, to int, fromAddr, toAddr net.Addr) (*conn, *conn) {
+	p0, p1 := newPipe(), newPipe()
+	return &conn{r: p0, w: p1, local: fromAddr, remote: toAddr, from: from, to: to},
+		&conn{r: p1, w: p0, local: toAddr, remote: fromAddr, from: from, to: to}
+}
+
+func (c *conn) Read(b []byte) (int, error)  { return c.r.read(b) }
+func (c *conn) Write(b []byte) (int, error) { return c.w.write(b) }
+
+// Close both directions, so the other end is closed as well
+func (c *conn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *conn) closed() bool {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	return c.r.closed
+}
+
+func (c *conn) LocalAddr() net.Addr                { return c.local }
+func (c *conn) RemoteAddr() net.Addr               { return c.remote }
+func (c *conn) SetDeadline(t time.Time) error      { return c.SetReadDeadline(t) }
+func (c *conn) SetReadDeadline(t time.Time) error  { c.r.setDeadline(t); return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// listener accept the conns dialed to node by other nodes in network
+type listener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newListener(addr net.Addr) *listener {
+	return &listener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+// push the conn to Accept, fail if listener closed
+func (l *listener) push(c net.Conn) error {
+	select {
+	case l.conns <- c:
+		return nil
+	case <-l.done:
+		return errListenerClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return l.addr }
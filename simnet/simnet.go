@@ -0,0 +1,736 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/ethereum"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memdb"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultLoopInterval is the loop interval of nodes in network, much
+	// shorter than real network, as all links are in memory
+	DefaultLoopInterval = 20 * time.Millisecond
+
+	// host is the ip of all nodes, same as the local peer ip told by node
+	host = "127.0.0.1"
+
+	// basePort is the port of node 0, node i use basePort+i, the port is
+	// only used to tell nodes apart, nothing listen on it
+	basePort = 20000
+
+	// clockNode is the node which time proof msgs be posted to
+	clockNode = 0
+
+	connectTimeout = 5 * time.Second
+	acceptTimeout  = 5 * time.Second
+	pollInterval   = 5 * time.Millisecond
+)
+
+var (
+	errNetworkTooSmall = errors.New("network need at least two nodes")
+	errNodeNotExist    = errors.New("node not exist")
+	errLinkDown        = errors.New("link between nodes is partitioned")
+	errNotConnected    = errors.New("nodes not connected")
+)
+
+// Node is one node of network and the db of it
+type Node struct {
+	*node.Node
+	UDB db.UDB
+
+	index    int
+	port     uint64
+	key      string
+	listener *listener
+	stop     chan os.Signal
+	done     chan struct{}
+	client   *peer.Peer // post msgs into node, never be partitioned
+}
+
+// State is the state of node compared with others, nodes converged when
+// all of them have same state
+type State struct {
+	MsgCount  uint64
+	Seq       uint64      // sequence of last time proof, 0 if not reached by node
+	StateRoot common.Hash // state root recorded in checkpoint at Seq
+}
+
+// Network is the nodes in one universe connected by in memory pipes. Root 0
+// post time proof msgs on the clock node (node 0), so the space-time has no
+// fork. Root 1 post text msgs on random nodes.
+type Network struct {
+	mu           sync.Mutex
+	rand         *rand.Rand
+	nodes        []*Node
+	groups       []int                 // partition group of each node, nil if not partitioned
+	holes        map[int]bool          // nodes black-holed, dials to them hang until canceled
+	latency      map[int]time.Duration // delay of the bytes written by node
+	faults       *peer.FaultInjector   // faults injected into waves written by all nodes
+	conns        []*conn
+	roots        [2]*core.User
+	keys         [2]*crypto.PrivateKey
+	genesis      *core.Message
+	lastTP       *core.Message
+	seq          uint64
+	loopInterval time.Duration
+	running      bool
+}
+
+// transport dial other nodes in network for the node by index, index -1
+// is used by the clients which post msgs
+type transport struct {
+	net  *Network
+	from int
+}
+
+// Dial build the ws connection over in memory conn
+func (t transport) Dial(ctx context.Context, p *peer.Peer) (*websocket.Conn, error) {
+	if t.net.blackholed(p) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c, err := t.net.connect(t.from, p)
+	if err != nil {
+		return nil, err
+	}
+	config, err := websocket.NewConfig(p.Url(), p.Origin())
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return peer.NewClient(ctx, config, c)
+}
+
+// New create the network of size nodes in same new universe, the seed is
+// used to choose the nodes of traffic and partitions, nodes are not started.
+func New(size int, seed int64) (*Network, error) {
+	if size < 2 {
+		return nil, errNetworkTooSmall
+	}
+	sn := &Network{
+		rand:         rand.New(rand.NewSource(seed)),
+		faults:       peer.NewFaultInjector(seed),
+		loopInterval: DefaultLoopInterval,
+	}
+	if err := sn.createRoots(); err != nil {
+		return nil, err
+	}
+	genesis, err := core.CreateMsgOnNetwork(core.NetworkDev, sn.roots[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte("simnet")}, sn.keys[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	sn.genesis, sn.lastTP, sn.seq = genesis, genesis, 1
+	for i := 0; i < size; i++ {
+		n, err := sn.createNode(i, genesis)
+		if err != nil {
+			return nil, err
+		}
+		sn.nodes = append(sn.nodes, n)
+	}
+	return sn, nil
+}
+
+// createRoots create two root users with diff gender
+func (sn *Network) createRoots() error {
+	engine := ethereum.New()
+	for sn.roots[0] == nil || sn.roots[1] == nil {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			return err
+		}
+		user := core.CreateRootUser(*pubKey, "simnet", "")
+		i := 0
+		if user.Gender() {
+			i = 1
+		}
+		if sn.roots[i] == nil {
+			sn.roots[i], sn.keys[i] = user, priKey
+		}
+	}
+	return nil
+}
+
+// createNode init the universe with genesis msg in memory db, and create
+// the node on it, the universe is not created if genesis is nil
+func (sn *Network) createNode(index int, genesis *core.Message) (*Node, error) {
+	udb := memdb.New()
+	if err := db.CreateMissingBuckets(udb, db.BucketConfig, db.BucketUser, db.BucketMsg, db.BucketMID,
+		db.BucketMOD, db.BucketLastMID, db.BucketPeer, db.BucketCheckpoint, db.BucketSenderMID, db.BucketTypeMID,
+		db.BucketContent, db.BucketContentRef, db.BucketNotify, db.BucketAudit, db.BucketOutbox,
+		db.BucketOutboxEvent, db.BucketDelivery, db.BucketArchive, db.BucketFeed, db.BucketAnchor,
+		db.BucketLabel); err != nil {
+		return nil, err
+	}
+	if err := db.SaveSchemaVersion(udb, db.SchemaVersion()); err != nil {
+		return nil, err
+	}
+	if err := db.SaveUniverseHasher(udb, common.HasherSHA256); err != nil {
+		return nil, err
+	}
+	if err := db.SaveNetworkID(udb, core.NetworkDev); err != nil {
+		return nil, err
+	}
+	if genesis != nil {
+		if err := db.SaveRootUsers(udb, sn.roots[:]); err != nil {
+			return nil, err
+		}
+		if err := db.SaveMsg(udb, genesis); err != nil {
+			return nil, err
+		}
+		if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepRootsSaved).Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	pn, err := node.New(udb)
+	if err != nil {
+		return nil, err
+	}
+	key, err := udb.Get(db.BucketConfig, db.ConfigLocalNodeKey)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		Node:  pn,
+		UDB:   udb,
+		index: index,
+		port:  uint64(basePort + index),
+		key:   common.Bytes2String(key),
+	}
+	n.listener = newListener(n.addr())
+	n.SetLocalPort(n.port)
+	n.SetListener(n.listener)
+	n.SetTransport(transport{net: sn, from: index})
+	n.SetFaults(sn.faults)
+	n.SetLoopInterval(sn.loopInterval)
+	// checkpoint on each time proof, so state root can be compared at any seq
+	n.SetCheckpointInterval(1)
+	if index == clockNode {
+		// the owner of space-time sign checkpoints and snapshots
+		n.SetCheckpointSigner(sn.roots[0], sn.keys[0])
+	}
+	n.EnableWSMsg()
+	return n, nil
+}
+
+func (n *Node) addr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: int(n.port)}
+}
+
+func (n *Node) peer() *peer.Peer {
+	p, _ := peer.New(host, n.port, n.key)
+	return p
+}
+
+// Size return the number of nodes
+func (sn *Network) Size() int {
+	return len(sn.nodes)
+}
+
+// Node return the node by index, nil if not exist
+func (sn *Network) Node(i int) *Node {
+	if i < 0 || i >= len(sn.nodes) {
+		return nil
+	}
+	return sn.nodes[i]
+}
+
+// SetLoopInterval set the loop interval of all nodes, should be set before Start
+func (sn *Network) SetLoopInterval(interval time.Duration) {
+	sn.loopInterval = interval
+	for _, n := range sn.nodes {
+		n.SetLoopInterval(interval)
+	}
+}
+
+// Start run the nodes and wait until every node dialed all others
+func (sn *Network) Start() error {
+	sn.mu.Lock()
+	sn.running = true
+	sn.mu.Unlock()
+	sn.introduce()
+	for _, n := range sn.nodes {
+		n.start()
+	}
+	return sn.waitConnected()
+}
+
+func (n *Node) start() {
+	n.stop, n.done = make(chan os.Signal), make(chan struct{})
+	go func(n *Node) {
+		n.Run(n.stop)
+		close(n.done)
+	}(n)
+}
+
+// Join create a new node with only the genesis msg, and start it if the
+// network is running. The node is in the group of clock node if network
+// is partitioned, and catch up by the initial sync from all others.
+func (sn *Network) Join() (*Node, error) {
+	return sn.join(sn.genesis, nil)
+}
+
+// JoinBySnapshot is same as Join, but the new node fetch the snapshot
+// signed by clock node before the initial sync
+func (sn *Network) JoinBySnapshot() (*Node, error) {
+	return sn.join(sn.genesis, func(n *Node) error {
+		n.EnableSnapshotSync()
+		return nil
+	})
+}
+
+// JoinByGenesis is same as Join, but the new node has no universe, the
+// genesis is fetched from the clock node pinned
+func (sn *Network) JoinByGenesis() (*Node, error) {
+	return sn.join(nil, func(n *Node) error {
+		n.EnableGenesisSync()
+		clock := sn.nodes[clockNode]
+		return n.PinNodes(fmt.Sprintf("%s@%s:%d/%s", common.Hash2String(sn.roots[0].ID()), host, clock.port, clock.key))
+	})
+}
+
+// join create the node with genesis msg, no universe if genesis is nil,
+// setup is called before the node start
+func (sn *Network) join(genesis *core.Message, setup func(n *Node) error) (*Node, error) {
+	n, err := sn.createNode(len(sn.nodes), genesis)
+	if err != nil {
+		return nil, err
+	}
+	if setup != nil {
+		if err := setup(n); err != nil {
+			return nil, err
+		}
+	}
+	sn.mu.Lock()
+	sn.nodes = append(sn.nodes, n)
+	if sn.groups != nil {
+		sn.groups = append(sn.groups, sn.groups[clockNode])
+	}
+	running := sn.running
+	sn.mu.Unlock()
+	sn.introduce()
+	if !running {
+		return n, nil
+	}
+	n.start()
+	return n, sn.waitConnected()
+}
+
+// Stop all nodes, and close all links between them
+func (sn *Network) Stop() {
+	sn.mu.Lock()
+	sn.running = false
+	sn.mu.Unlock()
+	for _, n := range sn.nodes {
+		if n.stop != nil {
+			close(n.stop)
+			<-n.done
+			n.stop = nil
+		}
+		if n.client != nil {
+			n.client.Close()
+			n.client = nil
+		}
+		n.listener.Close()
+	}
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	for _, c := range sn.conns {
+		c.Close()
+	}
+	sn.conns = nil
+}
+
+// introduce every node to all others, the peer already exist is skipped
+func (sn *Network) introduce() {
+	for _, n := range sn.nodes {
+		for _, other := range sn.nodes {
+			if n != other {
+				// fail if peer already exist, which is not removed by node
+				n.AddPeer(other.peer())
+			}
+		}
+	}
+}
+
+// connect open the conn from node to the node of peer, the conn of
+// listener side is accepted by the node
+func (sn *Network) connect(from int, p *peer.Peer) (net.Conn, error) {
+	sn.mu.Lock()
+	to := int(p.Port) - basePort
+	if !sn.running || to < 0 || to >= len(sn.nodes) || sn.nodes[to].key != p.NodeKey {
+		sn.mu.Unlock()
+		return nil, errNodeNotExist
+	}
+	if sn.partitioned(from, to) {
+		sn.mu.Unlock()
+		return nil, errLinkDown
+	}
+	var fromAddr net.Addr = &net.TCPAddr{IP: net.ParseIP(host)}
+	if from >= 0 {
+		fromAddr = sn.nodes[from].addr()
+	}
+	dialer, acceptor := newConnPair(from, to, fromAddr, sn.nodes[to].addr())
+	dialer.w.setLatency(sn.latency[from])
+	acceptor.w.setLatency(sn.latency[to])
+	sn.conns = append(sn.conns, dialer)
+	l := sn.nodes[to].listener
+	sn.mu.Unlock()
+	if err := l.push(acceptor); err != nil {
+		dialer.Close()
+		return nil, err
+	}
+	return dialer, nil
+}
+
+// partitioned return true if two nodes are in diff groups, the clients
+// (index -1) are never partitioned
+func (sn *Network) partitioned(from, to int) bool {
+	if sn.groups == nil || from < 0 || to < 0 {
+		return false
+	}
+	return sn.groups[from] != sn.groups[to]
+}
+
+// Partition split the nodes into groups, the nodes not in any group are
+// in one more group. The links between groups are closed and can not be
+// dialed until Heal, the nodes remove the peers in other groups.
+func (sn *Network) Partition(groups ...[]int) error {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	assigned := make([]int, len(sn.nodes))
+	for g, group := range groups {
+		for _, i := range group {
+			if i < 0 || i >= len(sn.nodes) {
+				return errNodeNotExist
+			}
+			assigned[i] = g + 1
+		}
+	}
+	sn.groups = assigned
+	var conns []*conn
+	for _, c := range sn.conns {
+		if c.closed() {
+			continue
+		}
+		if sn.partitioned(c.from, c.to) {
+			c.Close()
+			continue
+		}
+		conns = append(conns, c)
+	}
+	sn.conns = conns
+	return nil
+}
+
+// Blackhole make the dials to nodes hang until the dial canceled, as the
+// endpoints which drop all packets, the nodes can still dial others. The
+// nodes black-holed before are reachable again.
+func (sn *Network) Blackhole(nodes ...int) error {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	holes := make(map[int]bool)
+	for _, i := range nodes {
+		if i < 0 || i >= len(sn.nodes) {
+			return errNodeNotExist
+		}
+		holes[i] = true
+	}
+	sn.holes = holes
+	return nil
+}
+
+// SetLatency delay the bytes written by node on its links, as the node on
+// slow network, zero latency for no delay. The links open are delayed too.
+func (sn *Network) SetLatency(i int, latency time.Duration) error {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	if i < 0 || i >= len(sn.nodes) {
+		return errNodeNotExist
+	}
+	if sn.latency == nil {
+		sn.latency = make(map[int]time.Duration)
+	}
+	sn.latency[i] = latency
+	for _, c := range sn.conns {
+		if c.from == i {
+			c.w.setLatency(latency)
+		}
+		if c.to == i {
+			c.r.setLatency(latency)
+		}
+	}
+	return nil
+}
+
+// SetFaults inject the faults into the waves written by all nodes, such as
+// drop, duplicate, delay and disconnect. The zero faults heal the network,
+// but the peers disconnected are not dialed again until introduced.
+func (sn *Network) SetFaults(f peer.Faults) {
+	sn.faults.Set(f)
+}
+
+// FaultStats return the count of waves affected by faults
+func (sn *Network) FaultStats() peer.FaultStats {
+	return sn.faults.Stats()
+}
+
+func (sn *Network) blackholed(p *peer.Peer) bool {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	return sn.holes[int(p.Port)-basePort]
+}
+
+// RandomPartition cut a random part of nodes other than the clock node
+// away from others, the nodes be cut are returned
+func (sn *Network) RandomPartition() ([]int, error) {
+	perm := sn.rand.Perm(len(sn.nodes) - 1)
+	cut := perm[:1+sn.rand.Intn(len(perm))]
+	for i := range cut {
+		cut[i]++ // skip the clock node
+	}
+	return cut, sn.Partition(cut)
+}
+
+// Heal the partition, all nodes are introduced to others again, as the
+// peers in other groups may be removed by node, and wait until every node
+// dialed all others
+func (sn *Network) Heal() error {
+	sn.mu.Lock()
+	sn.groups = nil
+	sn.mu.Unlock()
+	sn.introduce()
+	return sn.waitConnected()
+}
+
+// waitConnected wait until every node dialed all nodes reachable from it,
+// except the nodes black-holed
+func (sn *Network) waitConnected() error {
+	for start := time.Now(); time.Since(start) < connectTimeout; time.Sleep(pollInterval) {
+		if sn.connected() {
+			return nil
+		}
+	}
+	return errNotConnected
+}
+
+func (sn *Network) connected() bool {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	links := make(map[[2]int]bool)
+	for _, c := range sn.conns {
+		if c.from >= 0 && !c.closed() {
+			links[[2]int{c.from, c.to}] = true
+		}
+	}
+	for i := range sn.nodes {
+		for j := range sn.nodes {
+			if i != j && !sn.partitioned(i, j) && !sn.holes[j] && !links[[2]int{i, j}] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Reachable return the nodes can be reached from node i, including itself
+func (sn *Network) Reachable(i int) []int {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	var nodes []int
+	for j := range sn.nodes {
+		if !sn.partitioned(i, j) {
+			nodes = append(nodes, j)
+		}
+	}
+	return nodes
+}
+
+// Tick post one time proof msg by root 0 on clock node, the msg refer the
+// last time proof and the last msg of clock node.
+func (sn *Network) Tick() error {
+	n := sn.nodes[clockNode]
+	refs := []*core.MsgReference{{SenderID: sn.lastTP.SenderID, MsgID: sn.lastTP.ID()}}
+	if last, err := db.GetLastMsg(n.UDB); err != nil {
+		return err
+	} else if last.ID() != sn.lastTP.ID() {
+		refs = append(refs, &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()})
+	}
+	msg, err := sn.createMsg(0, refs)
+	if err != nil {
+		return err
+	}
+	if err := sn.post(n, msg); err != nil {
+		return err
+	}
+	sn.lastTP = msg
+	sn.seq++
+	return nil
+}
+
+// Post one text msg by root 1 on node i, the msg refer the last msg of node.
+func (sn *Network) Post(i int) error {
+	n := sn.Node(i)
+	if n == nil {
+		return errNodeNotExist
+	}
+	last, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		return err
+	}
+	msg, err := sn.createMsg(1, []*core.MsgReference{{SenderID: last.SenderID, MsgID: last.ID()}})
+	if err != nil {
+		return err
+	}
+	return sn.post(n, msg)
+}
+
+// Traffic post steps msgs on random nodes reachable from clock node, about
+// one of four is time proof. Msgs are never posted on the nodes cut away
+// from clock node, as the nodes sync msgs by the order in peer, which only
+// work if the last msg of node is known by peer.
+func (sn *Network) Traffic(steps int) error {
+	nodes := sn.Reachable(clockNode)
+	for step := 0; step < steps; step++ {
+		var err error
+		if sn.rand.Intn(4) == 0 {
+			err = sn.Tick()
+		} else {
+			err = sn.Post(nodes[sn.rand.Intn(len(nodes))])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sn *Network) createMsg(root int, refs []*core.MsgReference) (*core.Message, error) {
+	value := &core.MsgValue{ContentType: core.TypeText, Content: []byte(strconv.Itoa(sn.rand.Intn(100000)))}
+	return core.CreateMsgOnNetwork(core.NetworkDev, sn.roots[root], value, sn.keys[root], 0, refs...)
+}
+
+// post send msg to node by the client of node, and wait until the msg is
+// accepted by all nodes reachable from it, so msgs are gossiped one by one
+// and the order of msgs in every node is same
+func (sn *Network) post(n *Node, msg *core.Message) error {
+	if n.client == nil || !n.client.Connected() {
+		client := n.peer()
+		client.SetTransport(transport{net: sn, from: -1})
+		if err := client.Dial(); err != nil {
+			return err
+		}
+		n.client = client
+	}
+	if err := n.client.SendMsg(common.CreateHash(), msg); err != nil {
+		return err
+	}
+	for _, i := range sn.Reachable(n.index) {
+		if err := sn.waitAccepted(i, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitAccepted wait until the msg is saved by node i
+func (sn *Network) waitAccepted(i int, msg *core.Message) error {
+	for start := time.Now(); time.Since(start) < acceptTimeout; time.Sleep(pollInterval) {
+		if _, _, err := db.GetOrderCntByMsg(sn.nodes[i].UDB, msg.ID()); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("msg %s not accepted by node %d", common.Hash2String(msg.ID()), i)
+}
+
+// State return the state of node i
+func (sn *Network) State(i int) (*State, error) {
+	n := sn.Node(i)
+	if n == nil {
+		return nil, errNodeNotExist
+	}
+	count, err := db.GetMsgCount(n.UDB)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{MsgCount: count.Uint64()}
+	cp, err := db.GetCheckpoint(n.UDB, sn.roots[0].ID(), sn.seq)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		state.Seq, state.StateRoot = cp.Seq, cp.StateRoot
+	}
+	return state, nil
+}
+
+// Converged return nil if all the nodes (all nodes in network if not be
+// set) have same state
+func (sn *Network) Converged(nodes ...int) error {
+	if len(nodes) == 0 {
+		for i := range sn.nodes {
+			nodes = append(nodes, i)
+		}
+	}
+	var first *State
+	for _, i := range nodes {
+		state, err := sn.State(i)
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			first = state
+		} else if *state != *first {
+			return fmt.Errorf("nodes not converged, node %d %+v, node %d %+v", nodes[0], *first, i, *state)
+		}
+	}
+	return nil
+}
+
+// WaitConverged wait until the nodes converged or timeout, the error of
+// last check is returned if timeout
+func (sn *Network) WaitConverged(timeout time.Duration, nodes ...int) error {
+	start := time.Now()
+	for {
+		err := sn.Converged(nodes...)
+		if err == nil || time.Since(start) > timeout {
+			return err
+		}
+		time.Sleep(sn.loopInterval)
+	}
+}
@@ -0,0 +1,2537 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package simnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/anchor"
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/filter"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+const convergeTimeout = 10 * time.Second
+
+func TestNetwork_Gossip(t *testing.T) {
+	sn, err := New(4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Traffic(20); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	state, err := sn.State(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.MsgCount != 21 {
+		t.Error("msg count should be 21, but", state.MsgCount)
+	}
+	if sn.seq > 1 && state.Seq != sn.seq {
+		t.Error("last time proof not checkpointed")
+	}
+}
+
+func TestNetwork_Partition(t *testing.T) {
+	sn, err := New(5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Traffic(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	cut, err := sn.RandomPartition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Traffic(10); err != nil {
+		t.Fatal(err)
+	}
+	// msg posted after partition, so state of clock node is new
+	if err := sn.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout, sn.Reachable(clockNode)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Converged(append(cut, clockNode)...); err == nil {
+		t.Error("nodes cut away should not be synced", cut)
+	}
+
+	if err := sn.Heal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetwork_InitialSync(t *testing.T) {
+	sn, err := New(3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Traffic(500); err != nil {
+		t.Fatal(err)
+	}
+	// new node catch up by initial sync from all others
+	n, err := sn.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err, n.SyncStatus())
+	}
+	// counters of sync are updated after the msgs committed
+	start := time.Now()
+	for !n.SyncStatus().Done && time.Since(start) < convergeTimeout {
+		time.Sleep(sn.loopInterval)
+	}
+	status := n.SyncStatus()
+	t.Log("initial sync", status.Committed, "msgs", int(status.Rate), "msgs/s")
+	if !status.Done || status.Committed != 500 {
+		t.Errorf("msgs should be committed by initial sync %+v", status)
+	}
+}
+
+func TestNetwork_SnapshotSync(t *testing.T) {
+	sn, err := New(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Traffic(300); err != nil {
+		t.Fatal(err)
+	}
+	// msgs after the last checkpoint are fetched by initial sync
+	for i := 0; i < 3; i++ {
+		if err := sn.Post(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := sn.JoinBySnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err, n.SnapshotStatus())
+	}
+	start := time.Now()
+	for !n.SyncStatus().Done && time.Since(start) < convergeTimeout {
+		time.Sleep(sn.loopInterval)
+	}
+	status := n.SyncStatus()
+	snapshot := status.Snapshot
+	if snapshot == nil || !snapshot.Applied || snapshot.Replayed != snapshot.Chunks {
+		t.Fatalf("snapshot should be applied %+v", snapshot)
+	}
+	t.Log("snapshot", snapshot.MsgCount, "msgs in", snapshot.Chunks, "chunks from", snapshot.Peers, "peers")
+	if !status.Done || status.Committed != status.Target-snapshot.MsgCount {
+		t.Errorf("msgs after snapshot should be committed by initial sync %+v", status)
+	}
+}
+
+func TestNetwork_GenesisSync(t *testing.T) {
+	sn, err := New(2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Post(1); err != nil {
+		t.Fatal(err)
+	}
+	n, err := sn.JoinByGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	user0, user1, err := db.GetRootUsers(n.UDB)
+	if err != nil {
+		t.Fatal("roots should be saved from genesis", err)
+	}
+	if core.UniverseID(user0, user1) != core.UniverseID(sn.roots[0], sn.roots[1]) {
+		t.Error("roots of genesis not match")
+	}
+	msgs := db.GetMsgByOrder(n.UDB, big.NewInt(0), 1)
+	if len(msgs) == 0 || msgs[0].ID() != sn.genesis.ID() {
+		t.Error("first msg should be the msg of genesis")
+	}
+
+	// the genesis is answered with the roots, signed by node
+	client := sn.Node(0).peer()
+	client.SetTransport(transport{net: sn, from: -1})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	waveID := common.CreateHash()
+	if err := client.AskGenesis(waveID); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		w, err := galaxy.ReceiveWave(client.Reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wr, ok := w.(*galaxy.WaveRoots); ok && wr.WaveID == waveID {
+			if wr.Genesis == nil || wr.Genesis.FirstMsg == nil || wr.Genesis.FirstMsg.ID() != sn.genesis.ID() {
+				t.Error("genesis should be answered with first msg")
+			}
+			if wr.Auth == nil {
+				t.Error("genesis should be signed by node")
+			}
+			break
+		}
+	}
+}
+
+func TestNetwork_Admin(t *testing.T) {
+	sn, err := New(2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := sn.Node(1)
+	if err := admin.SetAdmin(l, ""); err == nil {
+		t.Error("admin on tcp should require token")
+	}
+	if err := admin.SetAdmin(l, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	defer log.SetLevel(log.LvlTrace)
+
+	url := "http://" + l.Addr().String() + node.AdminPath
+	call := func(token, method string, params ...string) (*node.AdminResponse, int) {
+		reqBytes, _ := json.Marshal(&node.AdminRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: params})
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(reqBytes))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res node.AdminResponse
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return &res, resp.StatusCode
+	}
+
+	if res, code := call("wrong", "admin_peers"); code != http.StatusUnauthorized || res.Error == nil {
+		t.Error("call with wrong token should be unauthorized")
+	}
+	if res, _ := call("secret", "admin_unknown"); res.Error == nil || res.Error.Code != node.AdminErrMethodNotFound {
+		t.Error("unknown method should not be found")
+	}
+	res, _ := call("secret", "admin_peers")
+	if peers, ok := res.Result.([]interface{}); !ok || len(peers) != 1 {
+		t.Fatal("admin should have one peer", res.Result, res.Error)
+	}
+	if res, _ := call("secret", "admin_setLogLevel", "loud"); res.Error == nil || res.Error.Code != node.AdminErrInvalidParams {
+		t.Error("log level should not be valid")
+	}
+	if res, _ := call("secret", "admin_setLogLevel", "warn"); res.Error != nil || res.Result != "trace" || log.Level() != log.LvlWarn {
+		t.Error("log level not set", res.Result, res.Error)
+	}
+	if res, _ := call("secret", "admin_backup", "relative"); res.Error == nil {
+		t.Error("backup dir should be absolute")
+	}
+	if res, _ := call("secret", "admin_lookupHandle", "@a!"); res.Error == nil || res.Error.Code != node.AdminErrInvalidParams {
+		t.Error("handle should not be valid")
+	}
+	if res, _ := call("secret", "admin_lookupHandle", "@nobody"); res.Error == nil || res.Error.Code != node.AdminErrInternal {
+		t.Error("handle should not exist", res.Result)
+	}
+	if res, _ := call("secret", "admin_shutdown"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	select {
+	case <-admin.done:
+	case <-time.After(convergeTimeout):
+		t.Fatal("node not stopped by admin")
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("admin listener should be closed")
+	}
+}
+
+func TestNetwork_Post(t *testing.T) {
+	sn, err := New(3, 39)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the user is unlocked before node run, the node without user is checked
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	poster := sn.Node(1)
+	if err := poster.SetAdmin(l, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	poster.SetCheckpointSigner(sn.roots[1], sn.keys[1])
+	lb, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Node(2).SetAdmin(lb, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	callAt := func(addr string, method string, params ...string) *node.AdminResponse {
+		reqBytes, _ := json.Marshal(&node.AdminRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: params})
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addr+node.AdminPath, bytes.NewReader(reqBytes))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res node.AdminResponse
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return &res
+	}
+	call := func(method string, params ...string) *node.AdminResponse {
+		return callAt(l.Addr().String(), method, params...)
+	}
+	if res := callAt(lb.Addr().String(), "pdu_post", "hello"); res.Error == nil {
+		t.Fatal("post should fail without user unlocked")
+	}
+	if res := call("pdu_post"); res.Error == nil || res.Error.Code != node.AdminErrInvalidParams {
+		t.Error("content of post should be required")
+	}
+	if res := call("pdu_post", "hello", common.Hash2String(common.CreateHash())); res.Error == nil || res.Error.Code != node.AdminErrInvalidParams {
+		t.Error("reference of post should exist")
+	}
+
+	// the references are recommended by universe
+	res := call("pdu_post", "hello")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	msgID, err := common.HashFromString(res.Result.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := db.GetMsg(poster.UDB, msgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Reference) == 0 || msg.SenderID != sn.roots[1].ID() || string(msg.Value.Content) != "hello" {
+		t.Errorf("post not created by unlocked user with references %+v", msg)
+	}
+	if err := sn.waitAccepted(0, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the references set by app are used as is
+	res = call("pdu_post", "again", common.Hash2String(sn.genesis.ID()))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	msgID, _ = common.HashFromString(res.Result.(string))
+	if msg, err = db.GetMsg(poster.UDB, msgID); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Reference) != 1 || msg.Reference[0].MsgID != sn.genesis.ID() {
+		t.Errorf("post should only refer the msg set %+v", msg.Reference)
+	}
+}
+
+func TestNetwork_PostWithTimeProof(t *testing.T) {
+	sn, err := New(2, 43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := sn.Node(clockNode)
+	if err := clock.EnableTP(sn.roots[0], sn.keys[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	clock.SetTimeProofSchedule(5*time.Millisecond, 0, 0)
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	// posts and time proofs of same user are created at same time
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			var err error
+			for j := 0; j < 5 && err == nil; j++ {
+				_, err = clock.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("post %d %d", i, j))})
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for start := time.Now(); clock.TimeProofStats().Published < 3; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatalf("time proofs should be published %+v", clock.TimeProofStats())
+		}
+	}
+
+	// each msg of user reference the last one, no fork by the user
+	msgs, err := db.GetMsgsBySender(clock.UDB, sn.roots[0].ID(), 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(msgs); i++ {
+		found := false
+		for _, ref := range msgs[i].Reference {
+			found = found || ref.MsgID == msgs[i-1].ID()
+		}
+		if !found {
+			t.Fatalf("msg %d should reference the last msg of user", i)
+		}
+	}
+}
+
+func TestNetwork_IdentityBundle(t *testing.T) {
+	sn, err := New(2, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	if err := sn.Traffic(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := sn.Node(0).ExportIdentity(sn.roots[0].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bundle is migrated as json file
+	bundleBytes, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle core.IdentityBundle
+	if err := json.Unmarshal(bundleBytes, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	items := bundle.Items()
+
+	r, err := sn.Node(1).ImportIdentity(&bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Added) != 0 || len(r.Rejected) != 0 || len(r.Duplicated) != len(items) {
+		t.Errorf("all msgs should be duplicated in same universe %+v", r)
+	}
+
+	fresh, err := sn.createNode(sn.Size(), sn.genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bundle
+	tampered.Msgs = append([]*core.Message{}, bundle.Msgs...)
+	msg := *tampered.Msgs[len(tampered.Msgs)-1]
+	msg.Value = &core.MsgValue{ContentType: core.TypeText, Content: []byte("tampered")}
+	tampered.Msgs[len(tampered.Msgs)-1] = &msg
+	if _, err := fresh.ImportIdentity(&tampered); err == nil {
+		t.Error("bundle with msg tampered should not be imported")
+	}
+	if count, _ := db.GetMsgCount(fresh.UDB); count.Uint64() != 1 {
+		t.Error("nothing should be inserted if bundle not valid", count)
+	}
+
+	// the msgs referring msgs of others not in local universe are rejected
+	r, err = fresh.ImportIdentity(&bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Added) == 0 || len(r.Duplicated) != 1 || len(r.Added)+len(r.Duplicated)+len(r.Rejected) != len(items) {
+		t.Errorf("msgs should be added besides genesis %+v", r)
+	}
+	for _, msgID := range r.Added {
+		if _, err := db.GetMsg(fresh.UDB, msgID); err != nil {
+			t.Error("msg added should be saved", err)
+		}
+	}
+	for _, rejection := range r.Rejected {
+		if rejection.Reason == "" {
+			t.Errorf("reason of rejection should be reported %+v", rejection)
+		}
+	}
+}
+
+func TestNetwork_APIKeys(t *testing.T) {
+	sn, err := New(2, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(0)
+	get := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/sync", nil)
+		if key != "" {
+			req.Header.Set(node.APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(""); code != http.StatusOK {
+		t.Error("api keys not enabled, but", code)
+	}
+	readKey, info, err := n.CreateAPIKey(node.RoleRead, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limitedKey, _, err := n.CreateAPIKey(node.RoleSubmit, 0.001, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := n.CreateAPIKey("root", 0, 0); err == nil {
+		t.Error("role should not be valid")
+	}
+	if len(n.APIKeys()) != 2 || n.APIKeys()[0].Hash != "" {
+		t.Error("api keys should be listed without hash")
+	}
+
+	n.EnableAPIKeys(true)
+	if code := get(""); code != http.StatusOK {
+		t.Error("public node should be read without key, but", code)
+	}
+	n.EnableAPIKeys(false)
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Error("private node should not be read without key, but", code)
+	}
+	if code := get(readKey + "0"); code != http.StatusUnauthorized {
+		t.Error("wrong secret should not be valid, but", code)
+	}
+	if code := get(readKey); code != http.StatusOK {
+		t.Error("read key should be valid, but", code)
+	}
+	if code := get(limitedKey); code != http.StatusOK {
+		t.Error("limited key should be valid once, but", code)
+	}
+	if code := get(limitedKey); code != http.StatusTooManyRequests {
+		t.Error("limited key should be rate limited, but", code)
+	}
+	if err := n.RevokeAPIKey(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(readKey); code != http.StatusUnauthorized {
+		t.Error("revoked key should not be valid, but", code)
+	}
+	if err := n.RevokeAPIKey(info.ID); err == nil {
+		t.Error("key should be revoked only once")
+	}
+}
+
+func TestNetwork_Proxy(t *testing.T) {
+	sn, err := New(2, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(0)
+	n.SetCORSOrigins("https://app.pdu.pub/")
+	if err := n.SetTrustedProxies("10.0.0.0/8", "bad"); err == nil {
+		t.Error("trusted proxy should not be valid")
+	}
+	if err := n.SetTrustedProxies("10.0.0.0/8", "192.168.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	r := httptest.NewRequest(http.MethodOptions, "/sync", nil)
+	r.Header.Set("Origin", "https://app.pdu.pub")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	if w := serve(r); w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.pdu.pub" {
+		t.Error("preflight of allowed origin should be answered", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/sync", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	if w := serve(r); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("origin not allowed should not get cors headers")
+	}
+
+	// backup paths are only served to local requests, not forwarded ones
+	cases := []struct {
+		remote string
+		xff    string
+		code   int
+	}{
+		{"127.0.0.1:1234", "", http.StatusOK},
+		{"127.0.0.1:1234", "1.2.3.4", http.StatusForbidden},
+		{"10.1.2.3:1234", "1.2.3.4", http.StatusForbidden},
+		{"10.1.2.3:1234", "127.0.0.1, 192.168.1.1", http.StatusOK},
+		{"1.2.3.4:1234", "127.0.0.1", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		r = httptest.NewRequest(http.MethodGet, "/backup/count", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if w := serve(r); w.Code != c.code {
+			t.Error("backup from", c.remote, "forwarded for", c.xff, "should be", c.code, "but", w.Code)
+		}
+	}
+}
+
+func TestNetwork_RelayPolicy(t *testing.T) {
+	sn, err := New(3, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := sn.Node(2)
+	if err := archive.SetRelayPolicy(&node.RelayPolicy{Default: "forward"}); err == nil {
+		t.Error("relay action should not be valid")
+	}
+	// relay time proofs only, other msgs are kept in archive node
+	policy := &node.RelayPolicy{Default: node.RelayDrop, Rules: []*node.RelayRule{{Action: node.RelayAllow, TimeProofs: true}}}
+	if err := archive.SetRelayPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := sn.Post(2); err != nil {
+			t.Fatal(err)
+		}
+		if err := sn.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// msgs dropped by archive node are still synced by others
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	_, stats := archive.RelayPolicy()
+	t.Log("relayed", stats.Relayed, "dropped", stats.Dropped)
+	if stats.Dropped < 3 || stats.Relayed < 3 {
+		t.Errorf("msgs posted should be dropped and time proofs relayed %+v", stats)
+	}
+}
+
+func TestNetwork_Notifications(t *testing.T) {
+	sn, err := New(2, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := sn.Node(1)
+	local := sn.roots[0].ID()
+	watcher.SetNotifyUsers(local)
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	// msg of root 1 refer the time proof of root 0, time proof of root 0 is
+	// sent by local user self
+	if err := sn.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Post(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	ns, err := watcher.GetNotifications(local, true, node.DefaultNotifyLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 1 || ns[0].Kind != node.NotifyReference || ns[0].SenderID != sn.roots[1].ID() {
+		t.Fatal("notification of reference not found", ns)
+	}
+	if ns, _ := sn.Node(0).GetNotifications(local, true, node.DefaultNotifyLimit); len(ns) != 0 {
+		t.Error("node without local users should not save notifications")
+	}
+	if count, err := watcher.MarkRead(local); err != nil || count != 1 {
+		t.Error("notification should be marked", count, err)
+	}
+	if ns, _ := watcher.GetNotifications(local, true, node.DefaultNotifyLimit); len(ns) != 0 {
+		t.Error("notification should be read")
+	}
+}
+
+func TestNetwork_NodeIdentity(t *testing.T) {
+	sn, err := New(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, watcher := sn.Node(0), sn.Node(1)
+	if len(target.key) != 64 || target.key != target.LocalNodeKey() {
+		t.Fatal("node key should be derived from node public key", target.key)
+	}
+	address := func(key string) string {
+		return fmt.Sprintf("%s@%s:%d/%s", common.Hash2String(sn.roots[0].ID()), host, target.port, key)
+	}
+	if err := watcher.PinNodes(address(target.key[:32])); err == nil {
+		t.Error("legacy node key should not be pinned")
+	}
+	// node 0 is pinned with the key of other node, so its answers and
+	// handshake are taken as impersonation by node 1
+	if err := watcher.PinNodes(address(watcher.key)); err != nil {
+		t.Fatal(err)
+	}
+	// not all nodes connected, node 1 never keep the link to node 0
+	sn.Start()
+	defer sn.Stop()
+
+	if !waitLinked(sn, 1, 0, false) {
+		t.Fatal("node impersonated should be removed")
+	}
+	if !linked(sn, 0, 1) {
+		t.Error("node 0 should still dial node 1")
+	}
+}
+
+// linked return true if the conn dialed by node from to node to is open
+func linked(sn *Network, from, to int) bool {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	for _, c := range sn.conns {
+		if c.from == from && c.to == to && !c.closed() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitLinked wait until the conn from node from to node to is open or closed
+func waitLinked(sn *Network, from, to int, open bool) bool {
+	for start := time.Now(); time.Since(start) < connectTimeout; time.Sleep(pollInterval) {
+		if linked(sn, from, to) == open {
+			return true
+		}
+	}
+	return false
+}
+
+// seedResolver return the TXT records of dns seed without dns server
+type seedResolver map[string][]string
+
+func (r seedResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func TestNetwork_DNSSeed(t *testing.T) {
+	sn, err := New(2, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, fresh := sn.Node(0), sn.Node(1)
+	userID := common.Hash2String(sn.roots[0].ID())
+	valid, err := node.CreateSeedRecord(fmt.Sprintf("%s@%s:%d/%s", userID, host, seed.port, seed.key), sn.keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed by the key other than seed key
+	forged, err := node.CreateSeedRecord(fmt.Sprintf("%s@%s:%d/%s", userID, host, basePort+5, seed.key), sn.keys[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedKey := &sn.roots[0].Auth.PublicKey
+	if _, err := node.ParseSeedRecord(forged, seedKey); err == nil {
+		t.Error("record not signed by seed key should not be valid")
+	}
+	keyStr, err := node.SeedKeyString(seedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seedKey, err = node.ParseSeedKey(keyStr); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.SetDNSSeeds("seed.pdu.test,missing.pdu.test", seedKey); err != nil {
+		t.Fatal(err)
+	}
+	fresh.SetResolver(seedResolver{"seed.pdu.test": {"v=spf1 -all", forged, valid}})
+
+	// nodes are not introduced to each other, node 1 only know node 0 by
+	// dns seed, and node 0 know node 1 by its handshake
+	sn.mu.Lock()
+	sn.running = true
+	sn.mu.Unlock()
+	for _, n := range sn.nodes {
+		n.start()
+	}
+	defer sn.Stop()
+	if err := sn.waitConnected(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetwork_PeerPolicy(t *testing.T) {
+	if err := (&node.PeerPolicy{MaxPeers: 2, MaxInbound: 3}).Validate(); err == nil {
+		t.Error("max inbound over max peers should not be valid")
+	}
+	if err := (&node.PeerPolicy{Anchors: []string{host}}).Validate(); err == nil {
+		t.Error("anchor without port should not be valid")
+	}
+	sn, err := New(3, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := sn.Node(0)
+	anchor := fmt.Sprintf("%s:%d", host, sn.Node(2).port)
+	if err := target.SetPeerPolicy(&node.PeerPolicy{MaxPerIP: 1, Anchors: []string{anchor}}); err != nil {
+		t.Fatal(err)
+	}
+	// all nodes of simnet are on same ip
+	if err := target.AddPeer(sn.Node(1).peer()); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := peer.New(host, basePort+7, sn.Node(1).key)
+	if err := target.AddPeer(other); err == nil {
+		t.Error("peer over limit of ip should not be added")
+	}
+	if err := target.AddPeer(sn.Node(2).peer()); err != nil {
+		t.Error("anchor should not be limited", err)
+	}
+
+	sn, err = New(3, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target = sn.Node(0)
+	if err := target.SetPeerPolicy(&node.PeerPolicy{MaxPeers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// node 0 only know node 1 by its handshake
+	sn.Node(1).AddPeer(target.peer())
+	sn.mu.Lock()
+	sn.running = true
+	sn.mu.Unlock()
+	for _, n := range sn.nodes {
+		n.start()
+	}
+	defer sn.Stop()
+	if !waitLinked(sn, 0, 1, true) {
+		t.Fatal("inbound peer should be dialed")
+	}
+	// outbound peer evict the inbound peer of full node
+	if err := target.AddPeer(sn.Node(2).peer()); err != nil {
+		t.Fatal(err)
+	}
+	if !waitLinked(sn, 0, 1, false) || !waitLinked(sn, 0, 2, true) {
+		t.Fatal("inbound peer should be evicted")
+	}
+	if err := target.AddPeer(sn.Node(1).peer()); err == nil {
+		t.Error("outbound peer should not be evicted")
+	}
+}
+
+func TestNetwork_Bandwidth(t *testing.T) {
+	sn, err := New(2, 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Node(1).SetBandwidthLimit(&node.BandwidthLimit{SendRate: -1}); err == nil {
+		t.Error("negative limit should not be valid")
+	}
+	// the limits are far over the traffic, waves are only counted
+	if err := sn.Node(1).SetBandwidthLimit(&node.BandwidthLimit{SendRate: 1 << 20, PeerSendRate: 1 << 18}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	if err := sn.Traffic(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	b := sn.Node(1).Bandwidth()
+	if b.Total.Sent == 0 || b.Total.Received == 0 || b.Total.SendRate == 0 {
+		t.Fatal("bandwidth not counted", b.Total)
+	}
+	var sent uint64
+	inbound, outbound := false, false
+	for _, p := range b.Peers {
+		sent += p.Sent
+		inbound, outbound = inbound || p.Inbound, outbound || !p.Inbound
+	}
+	if !inbound || !outbound {
+		t.Error("conns of both directions should be metered", b.Peers)
+	}
+	if sent != b.Total.Sent {
+		t.Error("total should be sum of peers", sent, b.Total.Sent)
+	}
+}
+
+func TestNetwork_Expiry(t *testing.T) {
+	sn, err := New(2, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	n := sn.Node(1)
+	last, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// story refer the genesis of seq 1, expire at seq 3
+	value := &core.MsgValue{ContentType: core.TypeText, Content: []byte("story")}
+	story, err := core.CreateMsgWithExpiry(core.NetworkDev, 2, sn.roots[1], value, sn.keys[1], 0, &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.post(n, story); err != nil {
+		t.Fatal(err)
+	}
+	getMsg := func(n *Node) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/msg?id="+common.Hash2String(story.ID()), nil)
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, req)
+		var res map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(w.Code, w.Body.String())
+		}
+		return res
+	}
+	if res := getMsg(n); res["expired"] != nil {
+		t.Error("story should not be expired yet", res)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sn.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped := func(n *Node) bool {
+		for start := time.Now(); time.Since(start) < acceptTimeout; time.Sleep(pollInterval) {
+			if msg, err := db.GetMsg(n.UDB, story.ID()); err == nil && msg.ContentDropped() {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < 2; i++ {
+		if !dropped(sn.Node(i)) {
+			t.Error("expired content should be dropped by node", i)
+		}
+	}
+	if res := getMsg(n); res["expired"] != true {
+		t.Error("story should be marked expired", res)
+	}
+
+	// the stub is synced by new node without content
+	joined, err := sn.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if !dropped(joined) {
+		t.Error("new node should sync the stub of expired msg")
+	}
+}
+
+func TestNetwork_SenderRateLimit(t *testing.T) {
+	sn, err := New(2, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(1)
+	if err := n.SetSenderRateLimit(-1, 1); err == nil {
+		t.Error("sender rate should not be valid")
+	}
+	// root user get full score, so burst of it is not scaled down
+	if err := n.SetSenderRateLimit(0.001, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	for i := 0; i < 2; i++ {
+		if err := sn.Post(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sn.Post(1); err == nil {
+		t.Error("msg over burst of sender should be rejected")
+	}
+	if err := n.SetSenderRateLimit(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Post(1); err != nil {
+		t.Error("msg should be accepted without limit", err)
+	}
+}
+
+func TestNetwork_Audit(t *testing.T) {
+	sn, err := New(2, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(1)
+	if err := n.SetSenderRateLimit(0.001, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Post(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Post(1); err == nil {
+		t.Error("msg over burst of sender should be rejected")
+	}
+	if err := sn.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted, err := n.GetAuditLog(1, 100, node.AuditAccept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) < 2 {
+		t.Fatal("posted msg and time proof should be accepted", len(accepted))
+	}
+	for _, e := range accepted {
+		if e.Peer == "" || e.Time == 0 {
+			t.Error("origin and time should be recorded", e)
+		}
+	}
+	rejected, err := n.GetAuditLog(1, 100, node.AuditReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || rejected[0].Reason != "sender rate limited" {
+		t.Fatal("msg over burst should be recorded with reason", rejected)
+	}
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "audit.jsonl")
+	cnt, err := n.ExportAuditLog(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(exported, []byte("\n")); lines != cnt || cnt < len(accepted)+len(rejected) {
+		t.Error("all entries should be exported as json lines", lines, cnt)
+	}
+	if _, err := n.ExportAuditLog(fileName); err == nil {
+		t.Error("file exist should not be overwritten")
+	}
+}
+
+func TestNetwork_Outbox(t *testing.T) {
+	sn, err := New(2, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	n := sn.Node(0)
+	if _, err := sn.Node(1).Draft(&core.MsgValue{ContentType: core.TypeText, Content: []byte("hi")}); err == nil {
+		t.Error("draft should fail without user unlocked")
+	}
+
+	if err := sn.Partition([]int{0}); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); n.Connected(); time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("node should be disconnected")
+		}
+	}
+	item, err := n.Draft(&core.MsgValue{ContentType: core.TypeText, Content: []byte("offline")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued, err := n.GetOutbox(db.OutboxQueued); err != nil || len(queued) != 1 || queued[0].ID != item.ID {
+		t.Fatal("msg drafted offline should be queued", err)
+	}
+
+	if err := sn.Heal(); err != nil {
+		t.Fatal(err)
+	}
+	var sent []*db.OutboxItem
+	for start := time.Now(); len(sent) == 0; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("queued msg should be sent when connected")
+		}
+		if sent, err = n.GetOutbox(db.OutboxSent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := db.GetMsg(sn.Node(1).UDB, sent[0].MsgID)
+	if err != nil {
+		t.Fatal("sent msg should be gossiped", err)
+	}
+	if string(msg.Value.Content) != "offline" || msg.Reference[0].MsgID != sn.lastTP.ID() {
+		t.Error("sent msg should refer the last msg when submitted", msg.Reference)
+	}
+
+	events, err := n.GetOutboxEvents(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 2 || events[0].Status != db.OutboxQueued || events[len(events)-1].Status != db.OutboxSent {
+		t.Error("status transitions should be recorded", events)
+	}
+}
+
+func TestNetwork_BroadcastAck(t *testing.T) {
+	sn, err := New(3, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn.Node(0).BroadcastAckStats() != nil {
+		t.Error("ack stats should be nil if not enabled")
+	}
+	for i := 0; i < sn.Size(); i++ {
+		sn.Node(i).EnableBroadcastAck(time.Second)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	if err := sn.Traffic(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// msgs gossiped between nodes are acked, even if already exist
+	for i := 0; i < sn.Size(); i++ {
+		var stats *node.AckStats
+		for start := time.Now(); ; time.Sleep(pollInterval) {
+			stats = sn.Node(i).BroadcastAckStats()
+			if stats.Pending == 0 {
+				break
+			}
+			if time.Since(start) > convergeTimeout {
+				t.Fatalf("msgs broadcast by node %d should be acked %+v", i, stats)
+			}
+		}
+		if stats.Acked == 0 || stats.Dropped != 0 || stats.Rejected != 0 {
+			t.Errorf("msgs broadcast by node %d should be acked %+v", i, stats)
+		}
+	}
+}
+
+func TestNetwork_Announce(t *testing.T) {
+	sn, err := New(4, 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn.Node(0).AnnounceStats() != nil {
+		t.Error("announce stats should be nil if not enabled")
+	}
+	for i := 0; i < sn.Size(); i++ {
+		sn.Node(i).EnableAnnounce(time.Second)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	if err := sn.Traffic(12); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// msgs announced by many peers are only asked once, the rest are known
+	var stats node.AnnounceStats
+	for i := 0; i < sn.Size(); i++ {
+		s := sn.Node(i).AnnounceStats()
+		stats.Announced += s.Announced
+		stats.Asked += s.Asked
+		stats.Known += s.Known
+		stats.Served += s.Served
+	}
+	if stats.Announced == 0 || stats.Asked == 0 || stats.Known == 0 {
+		t.Errorf("msgs should be announced and asked %+v", stats)
+	}
+	if stats.Served > stats.Asked || stats.Asked+stats.Known > stats.Announced {
+		t.Errorf("msgs served should not more than asked %+v", stats)
+	}
+}
+
+func TestNetwork_SeenWindow(t *testing.T) {
+	sn, err := New(4, 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := sn.Size() - 1
+	sn.Node(off).SetSeenWindow(0)
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	if err := sn.Traffic(12); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// msgs gossiped by every peer of full mesh are verified once
+	var suppressed uint64
+	for i := 0; i < off; i++ {
+		stats := sn.Node(i).SeenStats()
+		if stats.Size == 0 {
+			t.Errorf("msgs processed by node %d should be seen %+v", i, stats)
+		}
+		suppressed += stats.Suppressed
+	}
+	if suppressed == 0 {
+		t.Error("msgs received again should be suppressed")
+	}
+	if stats := sn.Node(off).SeenStats(); stats.Size != 0 || stats.Suppressed != 0 {
+		t.Errorf("msgs should not be seen if window disabled %+v", stats)
+	}
+}
+
+func TestNetwork_SyncStatus(t *testing.T) {
+	sn, err := New(3, 37)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	if err := sn.Traffic(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// the view is current after msgs and checkpoints answered by peers,
+	// checkpoint is created for each sequence in simnet
+	for i := 0; i < sn.Size(); i++ {
+		var status node.SyncStatus
+		for start := time.Now(); ; time.Sleep(pollInterval) {
+			status = sn.Node(i).SyncStatus()
+			if status.Current && len(status.SpaceTimes) > 0 && status.SpaceTimes[0].PeerSeq > 0 {
+				break
+			}
+			if time.Since(start) > convergeTimeout {
+				t.Fatalf("view of node %d should be current %+v", i, status)
+			}
+		}
+		if status.Remaining != 0 || status.LocalCount == 0 {
+			t.Errorf("node %d should have no msgs remaining %+v", i, status)
+		}
+		if len(status.SpaceTimes) == 0 {
+			t.Errorf("followed space-times of node %d should be in status", i)
+		}
+		for _, st := range status.SpaceTimes {
+			if st.PeerSeq > st.LocalSeq {
+				t.Errorf("seq %d of node %d should not be behind peers %d", st.LocalSeq, i, st.PeerSeq)
+			}
+		}
+	}
+}
+
+func TestNetwork_TimeProofSchedule(t *testing.T) {
+	sn, err := New(3, 38)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := sn.Node(clockNode)
+	if err := clock.EnableTP(sn.roots[0], sn.keys[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	clock.SetTimeProofSchedule(50*time.Millisecond, 20*time.Millisecond, 0)
+	// msgs signed by key of other user are rejected by universe
+	broken := sn.Node(1)
+	if err := broken.EnableTP(sn.roots[1], sn.keys[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	broken.SetTimeProofSchedule(20*time.Millisecond, 0, 2)
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	var stats node.TimeProofStats
+	for start := time.Now(); ; time.Sleep(pollInterval) {
+		if stats = clock.TimeProofStats(); stats.Published >= 3 {
+			break
+		}
+		if time.Since(start) > convergeTimeout {
+			t.Fatalf("time proofs should be published by schedule %+v", stats)
+		}
+	}
+	if stats.Alert || stats.Failures != 0 {
+		t.Errorf("alert should not be raised %+v", stats)
+	}
+	// the heartbeat is broadcast to peers
+	heartbeat, err := db.GetMsg(clock.UDB, stats.LastMsgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < sn.Size(); i++ {
+		if err := sn.waitAccepted(i, heartbeat); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for start := time.Now(); !broken.TimeProofStats().Alert; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatalf("alert should be raised after failures %+v", broken.TimeProofStats())
+		}
+	}
+	if stats := broken.TimeProofStats(); stats.Published != 0 || stats.LastError == "" {
+		t.Errorf("time proofs should fail %+v", stats)
+	}
+	ready := broken.Ready()
+	for _, c := range ready.Checks {
+		if c.Name == node.CheckTimeProof && c.OK {
+			t.Error("time proof check should fail while alert raised")
+		}
+	}
+	if ready.Ready {
+		t.Error("node should not be ready while alert raised")
+	}
+}
+
+func TestNetwork_Delivery(t *testing.T) {
+	sn, err := New(2, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	n := sn.Node(0)
+	for start := time.Now(); !n.Connected(); time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("node should be connected")
+		}
+	}
+	if _, err := n.Draft(&core.MsgValue{ContentType: core.TypeText, Content: []byte("durable")}); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := n.GetOutbox(db.OutboxSent)
+	if err != nil || len(sent) != 1 {
+		t.Fatal("drafted msg should be sent", err)
+	}
+	// the delivery is removed after acked by peer
+	for start := time.Now(); ; time.Sleep(pollInterval) {
+		deliveries, err := n.GetDeliveries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("delivery should be acked", deliveries[0].WaveID)
+		}
+	}
+	if _, err := db.GetMsg(sn.Node(1).UDB, sent[0].MsgID); err != nil {
+		t.Fatal("delivered msg should be accepted by peer", err)
+	}
+
+	// the wave sent again is only acked by wave ID, not processed again
+	client := sn.Node(1).peer()
+	client.SetTransport(transport{net: sn, from: -1})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	last, err := db.GetLastMsg(sn.Node(1).UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := sn.createMsg(1, []*core.MsgReference{{SenderID: last.SenderID, MsgID: last.ID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sn.createMsg(1, []*core.MsgReference{{SenderID: last.SenderID, MsgID: last.ID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waveID := common.CreateHash()
+	for _, msg := range []*core.Message{post, other} {
+		if err := client.SendMsgAck(waveID, msg); err != nil {
+			t.Fatal(err)
+		}
+		w, err := galaxy.ReceiveWave(client.Reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ack, ok := w.(*galaxy.WaveAck)
+		if !ok || ack.WaveID != waveID || len(ack.MsgIDs) != 1 || ack.MsgIDs[0] != post.ID() {
+			t.Fatalf("msg of wave should be acked, but get %s", w.Command())
+		}
+	}
+	if _, err := db.GetMsg(sn.Node(1).UDB, other.ID()); err != db.ErrMessageNotFound {
+		t.Error("msg in wave sent again should not be processed", err)
+	}
+}
+
+func TestNetwork_Readiness(t *testing.T) {
+	sn, err := New(2, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(0)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get("/healthz"); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Error("node should be alive", w.Code)
+	}
+	// not ready before peers connected
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Error("node should not be ready without peers", w.Code)
+	}
+	if r := n.Ready(); r.Ready || r.Checks[2].Name != node.CheckPeers || r.Checks[2].OK {
+		t.Error("peers check should fail", r.Checks[2])
+	}
+	// standalone node need no peers
+	sn.Node(1).SetReadiness(0, node.DefaultReadyMaxLag)
+	if r := sn.Node(1).Ready(); !r.Ready {
+		t.Error("node should be ready without peers required", r.Checks)
+	}
+
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	for start := time.Now(); !n.Ready().Ready; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("node should be ready after connected", n.Ready().Checks)
+		}
+	}
+	w := get("/readyz")
+	var r node.Readiness
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil || w.Code != http.StatusOK || !r.Ready || len(r.Checks) != 4 {
+		t.Error("node should be ready", w.Code, err)
+	}
+}
+
+func TestNetwork_Retention(t *testing.T) {
+	sn, err := New(2, 22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(1)
+	policy := &node.RetentionPolicy{Rules: []*node.RetentionRule{{ContentTypes: []int{core.TypeText}, Days: 1e-5}}}
+	if err := n.SetRetentionPolicy(policy, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetRetentionPolicy(&node.RetentionPolicy{Rules: []*node.RetentionRule{{Days: -1}}}, 0); err == nil {
+		t.Error("policy with negative days should not be valid")
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	last, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()}
+	value := &core.MsgValue{ContentType: core.TypeText, Content: []byte("story")}
+	story, err := core.CreateMsgWithExpiry(core.NetworkDev, 1000, sn.roots[1], value, sn.keys[1], 0, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.post(n, story); err != nil {
+		t.Fatal(err)
+	}
+	text, err := sn.createMsg(1, []*core.MsgReference{ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.post(n, text); err != nil {
+		t.Fatal(err)
+	}
+
+	// content is kept longer than policy after seconds
+	time.Sleep(2 * time.Second)
+	n.EnforceRetention()
+	if stats := n.RetentionStats(); stats.Dropped != 1 || stats.Kept == 0 {
+		t.Error("content of ephemeral msg should be dropped and others kept", stats)
+	}
+	if msg, err := db.GetMsg(n.UDB, story.ID()); err != nil || !msg.ContentDropped() {
+		t.Error("content of story should be dropped by node 1", err)
+	}
+	if msg, err := db.GetMsg(n.UDB, text.ID()); err != nil || msg.ContentDropped() {
+		t.Error("content of text should be kept", err)
+	}
+	if msg, err := db.GetMsg(sn.Node(0).UDB, story.ID()); err != nil || msg.ContentDropped() {
+		t.Error("content of story should be kept by node 0 without policy", err)
+	}
+	if stats := n.EnforceRetention(); stats.Dropped != 0 {
+		t.Error("content should not be dropped again", stats)
+	}
+}
+
+func TestNetwork_Replica(t *testing.T) {
+	sn, err := New(2, 23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader, replica := sn.Node(0), sn.Node(1)
+	server := httptest.NewServer(leader.Handler())
+	defer server.Close()
+	if err := replica.SetLeader("ws://127.0.0.1:1088", ""); err == nil {
+		t.Error("leader url should be http")
+	}
+	if err := replica.SetLeader(server.URL, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	last, err := db.GetLastMsg(leader.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// msg committed by leader is followed by replica
+	first, err := sn.createMsg(0, []*core.MsgReference{{SenderID: last.SenderID, MsgID: last.ID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.post(leader, first); err != nil {
+		t.Fatal(err)
+	}
+	// msg received by replica is forwarded to leader
+	second, err := sn.createMsg(1, []*core.MsgReference{{SenderID: first.SenderID, MsgID: first.ID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.post(replica, second); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*core.Message{first, second} {
+		lo, _, err := db.GetOrderCntByMsg(leader.UDB, msg.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ro, _, err := db.GetOrderCntByMsg(replica.UDB, msg.ID())
+		if err != nil || ro.Cmp(lo) != 0 {
+			t.Error("replica should commit msg in same order as leader", ro, lo, err)
+		}
+	}
+	if status := replica.ClusterStatus(); status.Role != node.ClusterReplica || status.Applied < 2 || status.Forwarded == 0 {
+		t.Error("replica should follow and forward msgs", status)
+	}
+	if status := leader.ClusterStatus(); status.Role != node.ClusterLeader {
+		t.Error("node should be leader by default", status)
+	}
+}
+
+func TestNetwork_ReadWhileCommit(t *testing.T) {
+	sn, err := New(2, 44)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(clockNode)
+	n.SetCheckpointSigner(sn.roots[0], sn.keys[0])
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	// the read apis are served while msgs committed
+	sender := common.Hash2String(sn.roots[0].ID())
+	genesis := common.Hash2String(sn.genesis.ID())
+	done := make(chan struct{})
+	read := make(chan int)
+	go func() {
+		codes := 0
+		for {
+			select {
+			case <-done:
+				read <- codes
+				return
+			default:
+			}
+			for _, path := range []string{"/msgs?sender=" + sender, "/msg?id=" + genesis, "/user?id=" + sender, "/reposts?id=" + genesis} {
+				w := httptest.NewRecorder()
+				n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				if w.Code != http.StatusOK {
+					codes++
+				}
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := n.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("post %d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	if codes := <-read; codes != 0 {
+		t.Errorf("read apis should be served while committing, %d fail", codes)
+	}
+}
+
+func TestNetwork_PageView(t *testing.T) {
+	sn, err := New(2, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	n := sn.Node(0)
+	sender := common.Hash2String(sn.roots[1].ID())
+	type page struct {
+		View *core.View        `json:"view"`
+		Msgs []json.RawMessage `json:"msgs"`
+	}
+	getPage := func(query string) *page {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/msgs?sender="+sender+query, nil))
+		var page page
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(w.Code, w.Body.String())
+		}
+		return &page
+	}
+	post := func() {
+		last, err := db.GetLastMsg(n.UDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := sn.createMsg(1, []*core.MsgReference{{SenderID: last.SenderID, MsgID: last.ID()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sn.post(n, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		post()
+	}
+	total := len(getPage("&limit=100").Msgs)
+	first := getPage("&limit=2")
+	if len(first.Msgs) != 2 || first.View == nil {
+		t.Fatal("first page should have view", first.View, len(first.Msgs))
+	}
+
+	// msg arrived after first page is not in pages of same view
+	post()
+	view := strconv.FormatUint(first.View.Seq, 10)
+	if page := getPage("&skip=2&limit=100&view=" + view); len(page.Msgs) != total-2 || page.View.Seq != first.View.Seq {
+		t.Error("page in view should not include new msg", len(page.Msgs), total)
+	}
+	if page := getPage("&skip=2&limit=100"); len(page.Msgs) != total-1 {
+		t.Error("latest page should include new msg", len(page.Msgs), total)
+	}
+	w := httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/msgs?sender="+sender+"&skip=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Error("negative skip should not be valid", w.Code)
+	}
+}
+
+func TestNetwork_Feed(t *testing.T) {
+	sn, err := New(2, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	n := sn.Node(0)
+	for i := 0; i < 3; i++ {
+		last, err := db.GetLastMsg(n.UDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := sn.createMsg(1, []*core.MsgReference{{SenderID: last.SenderID, MsgID: last.ID()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sn.post(n, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := n.MaterializeFeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) == 0 || status[0].Materialized != status[0].Total {
+		t.Fatal("feeds should be materialized", status)
+	}
+	var page struct {
+		Materialized uint64            `json:"materialized"`
+		Seqs         []uint64          `json:"seqs"`
+		Msgs         []json.RawMessage `json:"msgs"`
+	}
+	getFeed := func(query string) {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/feed?limit=100"+query, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(w.Code, w.Body.String())
+		}
+	}
+	getFeed("")
+	if len(page.Msgs) == 0 || len(page.Msgs) != len(page.Seqs) || page.Materialized != status[0].Materialized {
+		t.Fatal("feed should return msgs materialized", len(page.Msgs), len(page.Seqs), page.Materialized)
+	}
+	for i := 1; i < len(page.Seqs); i++ {
+		if page.Seqs[i] > page.Seqs[i-1] {
+			t.Error("feed should be ordered by seq from new to old", page.Seqs)
+		}
+	}
+	total := len(page.Msgs)
+	getFeed("&skip=1")
+	if len(page.Msgs) != total-1 {
+		t.Error("feed should skip msgs", len(page.Msgs), total)
+	}
+
+	// the user follows nobody, no msgs in the feed
+	getFeed("&user=" + common.Hash2String(sn.roots[0].ID()))
+	if len(page.Msgs) != 0 {
+		t.Error("feed of user should only include msgs from following", len(page.Msgs))
+	}
+}
+
+func TestNetwork_SeqProof(t *testing.T) {
+	sn, err := New(2, 26)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Post(clockNode); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sn.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sn.Post(clockNode); err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(clockNode)
+	last, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stID := sn.roots[0].ID()
+	w := httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proof?id="+common.Hash2String(last.ID())+"&st="+common.Hash2String(stID), nil))
+	var proof core.SeqProof
+	if err := json.Unmarshal(w.Body.Bytes(), &proof); err != nil {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	// checkpoint is recorded on every sequence, so the chain start from the one reached
+	if proof.Seq == 0 || proof.AnchorSeq != proof.Seq || len(proof.Chain) != 1 || len(proof.Path) == 0 {
+		t.Fatal("proof should be trimmed to checkpoint", proof.Seq, proof.AnchorSeq, len(proof.Chain), len(proof.Path))
+	}
+	cp, err := db.GetCheckpoint(n.UDB, stID, proof.AnchorSeq)
+	if err != nil || cp == nil {
+		t.Fatal("checkpoint should be recorded", err)
+	}
+	if ok, err := core.VerifySeqProof(&proof, *cp); err != nil || !ok {
+		t.Error("verify seq proof fail", err)
+	}
+
+	w = httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proof?id="+common.Hash2String(common.Hash{}), nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("proof of unknown msg should not be found", w.Code)
+	}
+}
+
+func TestNetwork_Inclusion(t *testing.T) {
+	sn, err := New(2, 27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Post(clockNode); err != nil {
+		t.Fatal(err)
+	}
+	n := sn.Node(clockNode)
+	posted, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	getProof := func(query string) (*node.InclusionResult, int) {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inclusion?"+query, nil))
+		if w.Code != http.StatusOK {
+			return nil, w.Code
+		}
+		var result node.InclusionResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return &result, w.Code
+	}
+	for _, query := range []string{
+		"kind=msg&id=" + common.Hash2String(posted.ID()),
+		"kind=user&id=" + common.Hash2String(sn.roots[1].ID()),
+	} {
+		result, code := getProof(query)
+		if result == nil {
+			t.Fatal("get inclusion proof fail", query, code)
+		}
+		// all nodes agree on the state root of checkpoint
+		cp, err := db.GetCheckpoint(sn.Node(1).UDB, result.Checkpoint.SpaceTimeID, result.Checkpoint.Seq)
+		if err != nil || cp == nil {
+			t.Fatal("checkpoint should be recorded", err)
+		}
+		if ok, err := core.VerifyInclusion(cp.StateRoot, result.Proof); err != nil || !ok {
+			t.Error("verify inclusion fail", query, err)
+		}
+	}
+
+	// msg after the time proof msg is not included
+	if err := sn.Post(clockNode); err != nil {
+		t.Fatal(err)
+	}
+	last, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, code := getProof("kind=msg&id=" + common.Hash2String(last.ID())); code != http.StatusNotFound {
+		t.Error("msg after checkpoint should not be included", code)
+	}
+	if _, code := getProof("kind=file&id=" + common.Hash2String(last.ID())); code != http.StatusBadRequest {
+		t.Error("kind should not be valid", code)
+	}
+}
+
+// memChain is the chain keeping the payloads of anchors in memory
+type memChain struct {
+	txs map[string][]byte
+}
+
+func (c *memChain) Name() string {
+	return "mem"
+}
+
+func (c *memChain) Publish(payload []byte) (string, error) {
+	txID := strconv.Itoa(len(c.txs))
+	c.txs[txID] = payload
+	return txID, nil
+}
+
+func (c *memChain) Lookup(txID string) ([]byte, error) {
+	payload, ok := c.txs[txID]
+	if !ok {
+		return nil, anchor.ErrTxNotFound
+	}
+	return payload, nil
+}
+
+func TestNetwork_Anchor(t *testing.T) {
+	sn, err := New(2, 28)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	n := sn.Node(clockNode)
+	chain := &memChain{txs: make(map[string][]byte)}
+	n.SetAnchor(chain, time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := sn.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := n.PublishAnchor()
+	if a == nil {
+		// published by the loop of node already
+		a = n.AnchorStats().Last
+	}
+	if err != nil || a == nil {
+		t.Fatal("publish anchor fail", err)
+	}
+	if saved, err := db.GetAnchor(n.UDB, a.SpaceTimeID, a.Seq); err != nil || saved == nil || saved.TxID != a.TxID {
+		t.Fatal("anchor should be saved", err)
+	}
+	if again, err := n.PublishAnchor(); err != nil || again != nil {
+		t.Error("checkpoint should not be anchored again", err)
+	}
+	if verified, err := n.VerifyAnchors(); err != nil || verified != 1 {
+		t.Error("verify anchors fail", verified, err)
+	}
+
+	// the payload on chain not match the local checkpoint
+	payload := chain.txs[a.TxID]
+	payload[len(payload)-1]++
+	if _, err := n.VerifyAnchors(); err == nil {
+		t.Error("tampered anchor should not be verified")
+	}
+	if stats := n.AnchorStats(); stats.Published != 1 || stats.Mismatch != 1 {
+		t.Error("anchor stats not match", stats)
+	}
+}
+
+func TestNetwork_Edit(t *testing.T) {
+	sn, err := New(2, 29)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	n := sn.Node(0)
+	if err := sn.Post(0); err != nil {
+		t.Fatal(err)
+	}
+	original, err := db.GetLastMsg(n.UDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, err := core.CreateContentEdit(original, []byte("edited"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(ce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := []*core.MsgReference{{SenderID: original.SenderID, MsgID: original.ID()}}
+	edit, err := core.CreateMsgOnNetwork(core.NetworkDev, sn.roots[1], &core.MsgValue{ContentType: core.TypeEdit, Content: content}, sn.keys[1], 0, refs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.post(n, edit); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) []byte {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatal(path, w.Code, w.Body.String())
+		}
+		return w.Body.Bytes()
+	}
+	var m core.Message
+	if err := json.Unmarshal(get("/msg?latest=true&id="+common.Hash2String(original.ID())), &m); err != nil || m.ID() != edit.ID() {
+		t.Error("latest version of msg should be the edit", err)
+	}
+	var latest map[string]json.RawMessage
+	if err := json.Unmarshal(get("/msg?id="+common.Hash2String(original.ID())), &latest); err != nil || string(latest["edits"]) != "1" {
+		t.Error("msg should be marked as edited", err, string(latest["edits"]))
+	}
+	var views []map[string]json.RawMessage
+	if err := json.Unmarshal(get("/edits?id="+common.Hash2String(edit.ID())), &views); err != nil || len(views) != 2 {
+		t.Error("edit history should include original and edit", err, len(views))
+	}
+}
+
+func TestNetwork_Faults(t *testing.T) {
+	sn, err := New(4, 36)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	// msgs dropped by gossip are synced from peers
+	sn.SetFaults(peer.Faults{Drop: 0.1, Duplicate: 0.1, Jitter: 5 * time.Millisecond})
+	if err := sn.Traffic(20); err != nil {
+		t.Fatal(err)
+	}
+	stats := sn.FaultStats()
+	if stats.Dropped == 0 || stats.Duplicated == 0 || stats.Delayed == 0 {
+		t.Errorf("faults should be injected %+v", stats)
+	}
+
+	// all links are broken by the next wave written, then healed
+	sn.SetFaults(peer.Faults{Disconnect: 1})
+	for start := time.Now(); sn.FaultStats().Disconnected == 0; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("links should be disconnected")
+		}
+	}
+	sn.SetFaults(peer.Faults{})
+	if err := sn.Heal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Traffic(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := sn.State(0); err != nil {
+		t.Fatal(err)
+	} else if state.MsgCount != 26 {
+		t.Error("msg count should be 26, but", state.MsgCount)
+	}
+}
+
+func TestNetwork_Blackhole(t *testing.T) {
+	sn, err := New(4, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dials to black hole hang until node stopped
+	for i := 0; i < sn.Size(); i++ {
+		sn.Node(i).SetPeerTimeouts(peer.Timeouts{Dial: time.Hour})
+	}
+	if err := sn.Blackhole(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		sn.Stop()
+		t.Fatal("nodes should be connected while dials to black hole hang", err)
+	}
+	if err := sn.Post(1); err != nil {
+		t.Error(err)
+	}
+	if err := sn.WaitConverged(convergeTimeout, 0, 1, 2); err != nil {
+		t.Error(err)
+	}
+	start := time.Now()
+	sn.Stop()
+	if time.Since(start) > connectTimeout {
+		t.Error("dials hang should be canceled by stop")
+	}
+}
+
+func TestNetwork_UniverseHandshake(t *testing.T) {
+	sn, err := New(2, 31)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	client := sn.Node(0).peer()
+	client.SetTransport(transport{net: sn, from: -1})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// answer of question by waveID, other waves are skipped
+	answer := func(waveID common.Hash) galaxy.Wave {
+		for {
+			w, err := galaxy.ReceiveWave(client.Reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch wm := w.(type) {
+			case *galaxy.WaveRoots:
+				if wm.WaveID == waveID {
+					return w
+				}
+			case *galaxy.WavePeers:
+				if wm.WaveID == waveID {
+					return w
+				}
+			case *galaxy.WaveErr:
+				if wm.WaveID == waveID {
+					return w
+				}
+			}
+		}
+	}
+
+	// roots are sent with the universe ID
+	waveID := common.CreateHash()
+	if err := client.AskRoots(waveID); err != nil {
+		t.Fatal(err)
+	}
+	roots, ok := answer(waveID).(*galaxy.WaveRoots)
+	if !ok {
+		t.Fatal("roots should be answered")
+	}
+	universe := core.GenesisHash(roots.Network, roots.Users[0], roots.Users[1])
+	if roots.Universe.IsZero() || roots.Universe != universe {
+		t.Error("universe of roots not match", common.Hash2String(roots.Universe))
+	}
+
+	// handshake from other universe is rejected with the code
+	ask := func(id common.Hash) galaxy.Wave {
+		localPeer, err := json.Marshal(&peer.Peer{IP: "127.0.0.1", Port: 1, NodeKey: "client", Universe: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waveID := common.CreateHash()
+		if err := client.SendQuestion(waveID, galaxy.CmdPeers, localPeer); err != nil {
+			t.Fatal(err)
+		}
+		return answer(waveID)
+	}
+	if we, ok := ask(common.CreateHash()).(*galaxy.WaveErr); !ok || we.Code != core.ErrUniverseNotMatch.Code {
+		t.Fatal("handshake from other universe should be rejected")
+	}
+	wp, ok := ask(universe).(*galaxy.WavePeers)
+	if !ok || len(wp.Peers) == 0 {
+		t.Fatal("handshake from same universe should be answered")
+	}
+	var answerer peer.Peer
+	if err := json.Unmarshal(wp.Peers[len(wp.Peers)-1], &answerer); err != nil {
+		t.Fatal(err)
+	}
+	if answerer.Universe != universe {
+		t.Error("universe of answering peer not match", common.Hash2String(answerer.Universe))
+	}
+}
+
+func TestNetwork_SyncPeerSelection(t *testing.T) {
+	sn, err := New(3, 33)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	if err := sn.Traffic(600); err != nil {
+		t.Fatal(err)
+	}
+	slow := 2
+	if err := sn.SetLatency(slow, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := sn.join(sn.genesis, func(n *Node) error {
+		return n.SetAdmin(l, "secret")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for !n.SyncStatus().Done && time.Since(start) < convergeTimeout {
+		time.Sleep(sn.loopInterval)
+	}
+	if status := n.SyncStatus(); !status.Done || status.Committed != 600 {
+		t.Fatalf("msgs should be committed by initial sync %+v", status)
+	}
+
+	adminPeers := func() []*node.AdminPeer {
+		reqBytes, _ := json.Marshal(&node.AdminRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: "admin_peers"})
+		req, _ := http.NewRequest(http.MethodPost, "http://"+l.Addr().String()+node.AdminPath, bytes.NewReader(reqBytes))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res struct {
+			Result []*node.AdminPeer `json:"result"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Result
+	}
+	// the rtt is measured by the ping of node loop, which may not be
+	// answered by the slow peer before sync done
+	var peers []*node.AdminPeer
+	for start := time.Now(); ; time.Sleep(pollInterval) {
+		peers = adminPeers()
+		measured := false
+		for _, p := range peers {
+			if p.URL == sn.Node(slow).peer().Url() && p.RTT > 0 {
+				measured = true
+			}
+		}
+		if measured || time.Since(start) > convergeTimeout {
+			break
+		}
+	}
+	// the slow peer only answer the range asked before measured
+	var ranges uint64
+	var throughput float64
+	for _, p := range peers {
+		ranges += p.Ranges
+		if p.URL != sn.Node(slow).peer().Url() {
+			throughput += p.Throughput
+			continue
+		}
+		if p.RTT < 200 {
+			t.Error("rtt of slow peer should be measured", p.RTT)
+		}
+		if p.Ranges > 1 {
+			t.Error("slow peer should not be asked for bulk sync", p.Ranges)
+		}
+	}
+	if ranges < 600/peer.MaxSyncMsgCountPerWave || throughput == 0 {
+		t.Error("ranges answered by fast peers not measured", ranges, throughput)
+	}
+}
+
+func TestNetwork_Filter(t *testing.T) {
+	sn, err := New(2, 41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered := sn.Node(0)
+	filtered.SetFilter(filter.Func(func(msg *core.Message) (float64, filter.Verdict) {
+		switch string(msg.Value.Content) {
+		case "buy now":
+			return 1, filter.Reject
+		case "maybe spam":
+			return 0.9, filter.Flag
+		}
+		return 0, filter.Accept
+	}))
+	poster := sn.Node(1)
+	poster.SetCheckpointSigner(sn.roots[1], sn.keys[1])
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+
+	// msg flagged is accepted and kept for review
+	flagged, err := poster.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte("maybe spam")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.waitAccepted(0, flagged); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); len(filtered.FilterStats().FlaggedMsgs) == 0 && time.Since(start) < convergeTimeout; time.Sleep(pollInterval) {
+	}
+	if stats := filtered.FilterStats(); stats.Flagged != 1 || len(stats.FlaggedMsgs) != 1 || stats.FlaggedMsgs[0] != flagged.ID() {
+		t.Errorf("msg should be flagged %+v", stats)
+	}
+
+	// msg rejected is not committed
+	rejected, err := poster.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte("buy now")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); filtered.FilterStats().Rejected == 0; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("msg not rejected by filter")
+		}
+	}
+	if _, err := db.GetMsg(filtered.UDB, rejected.ID()); err != db.ErrMessageNotFound {
+		t.Errorf("msg rejected should not be saved, got %v", err)
+	}
+
+	// the flagged msg reviewed by operator train the Bayes filter
+	if err := filtered.TrainFilter(flagged.ID(), true); err == nil {
+		t.Error("filter func should not be trained")
+	}
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "bayes.json")
+	if _, err := filtered.LoadFilter(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := filtered.TrainFilter(flagged.ID(), true); err != nil {
+		t.Fatal(err)
+	}
+	stats := filtered.FilterStats()
+	if len(stats.FlaggedMsgs) != 0 || stats.Bayes == nil || stats.Bayes.SpamMsgs != 1 {
+		t.Errorf("flagged msg should be trained as spam %+v", stats)
+	}
+	bayes, err := filter.LoadBayes(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bayes.Stats().SpamMsgs != 1 {
+		t.Error("Bayes filter should be saved after trained")
+	}
+}
+
+func TestNetwork_Labels(t *testing.T) {
+	sn, err := New(2, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, poster := sn.Node(0), sn.Node(1)
+	poster.SetCheckpointSigner(sn.roots[1], sn.keys[1])
+	if err := sn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Stop()
+	post := func(text string) *core.Message {
+		msg, err := poster.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte(text)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sn.waitAccepted(0, msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	hello, spam := post("hello"), post("cheap pills")
+
+	if err := n.SetLabel(hello.ID(), db.LabelFavorite); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetLabel(spam.ID(), db.LabelHidden); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetLabel(common.CreateHash(), db.LabelFavorite); err == nil {
+		t.Error("label of msg not exist should not be set")
+	}
+	if err := n.SetLabel(hello.ID(), db.LabelBlocked); err == nil {
+		t.Error("label of user should not be set on msg")
+	}
+	if labels, err := db.GetLabels(n.UDB, hello.ID()); err != nil || len(labels) != 1 || labels[0].Label != db.LabelFavorite {
+		t.Error("label should be saved in local db", labels, err)
+	}
+
+	// query apis filter msgs by labels, hidden msgs excluded by default
+	sender := common.Hash2String(sn.roots[1].ID())
+	getMsgs := func(query string) map[common.Hash][]string {
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/msgs?sender="+sender+"&limit=100"+query, nil))
+		var page struct {
+			Msgs []json.RawMessage `json:"msgs"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(w.Code, w.Body.String())
+		}
+		msgs := make(map[common.Hash][]string)
+		for _, m := range page.Msgs {
+			var msg core.Message
+			var view struct {
+				Labels []string `json:"labels"`
+			}
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(m, &view); err != nil {
+				t.Fatal(err)
+			}
+			msgs[msg.ID()] = append([]string{}, view.Labels...)
+		}
+		return msgs
+	}
+	if msgs := getMsgs(""); len(msgs[hello.ID()]) != 1 || msgs[hello.ID()][0] != db.LabelFavorite {
+		t.Errorf("msg should be returned with label %v", msgs)
+	} else if _, ok := msgs[spam.ID()]; ok {
+		t.Error("hidden msg should not be returned by default")
+	}
+	if msgs := getMsgs("&without="); len(msgs[spam.ID()]) != 1 {
+		t.Errorf("hidden msg should be returned if without is empty %v", msgs)
+	}
+	if msgs := getMsgs("&label=favorite&without="); len(msgs) != 1 || msgs[hello.ID()] == nil {
+		t.Errorf("only favorite msg should be returned %v", msgs)
+	}
+	w := httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/msgs?sender="+sender+"&label=important", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Error("unknown label should not be valid", w.Code)
+	}
+	w = httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/labeled?label=hidden", nil))
+	var labeled []*db.Label
+	if err := json.Unmarshal(w.Body.Bytes(), &labeled); err != nil || len(labeled) != 1 || labeled[0].Target != spam.ID() {
+		t.Error("hidden msg should be labeled", w.Body.String())
+	}
+
+	// the msg labeled spam train the spam filter
+	dir, err := ioutil.TempDir("", "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := n.LoadFilter(filepath.Join(dir, "bayes.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetLabel(spam.ID(), db.LabelSpam); err != nil {
+		t.Fatal(err)
+	}
+	if stats := n.FilterStats(); stats.Bayes == nil || stats.Bayes.SpamMsgs != 1 {
+		t.Errorf("msg labeled spam should train filter %+v", stats)
+	}
+
+	// relay policy match the labels of sender
+	if err := n.SetRelayPolicy(&node.RelayPolicy{Rules: []*node.RelayRule{{Action: node.RelayDrop, Labels: []string{"important"}}}}); err == nil {
+		t.Error("relay rule of unknown label should not be valid")
+	}
+	policy := &node.RelayPolicy{Rules: []*node.RelayRule{{Action: node.RelayDrop, Labels: []string{db.LabelTrusted}}}}
+	if err := n.SetRelayPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetLabel(sn.roots[1].ID(), db.LabelTrusted); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Relay(hello, nil, n.GetLabels(hello.SenderID)) || !policy.Relay(hello, nil, n.GetLabels(hello.ID())) {
+		t.Error("msg from trusted user should be dropped by relay rule")
+	}
+
+	// msgs from user blocked are rejected
+	if err := n.RemoveLabel(sn.roots[1].ID(), db.LabelTrusted); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetLabel(sn.roots[1].ID(), db.LabelBlocked); err != nil {
+		t.Fatal(err)
+	}
+	blocked, err := poster.Post(&core.MsgValue{ContentType: core.TypeText, Content: []byte("blocked")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); n.FilterStats().Rejected == 0; time.Sleep(pollInterval) {
+		if time.Since(start) > convergeTimeout {
+			t.Fatal("msg from blocked user not rejected")
+		}
+	}
+	if _, err := db.GetMsg(n.UDB, blocked.ID()); err != db.ErrMessageNotFound {
+		t.Errorf("msg from blocked user should not be saved, got %v", err)
+	}
+	if labels := n.GetLabels(sn.roots[1].ID()); len(labels) != 1 || labels[0] != db.LabelBlocked {
+		t.Errorf("labels of user not match %v", labels)
+	}
+}